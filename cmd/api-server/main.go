@@ -17,6 +17,7 @@ import (
 	"gorm.io/gorm"
 
 	"github.com/sre-norns/urth/pkg/apiserver"
+	"github.com/sre-norns/urth/pkg/cliconfig"
 	"github.com/sre-norns/wyrd/pkg/grace"
 	// dlogger "gorm.io/gorm/logger"
 )
@@ -39,6 +40,7 @@ func main() {
 	kong.Parse(&appCli,
 		kong.Name("urthd"),
 		kong.Description("Urth API service"),
+		cliconfig.Option("api-server"),
 	)
 
 	ctx := grace.NewSignalHandlingContext()
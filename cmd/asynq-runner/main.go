@@ -103,6 +103,8 @@ func (w *WorkerConfig) handleRunScenarioTask(ctx context.Context, t *asynq.Task)
 				TempDirPrefix:    string(job.ResultName),
 				KeepTempDir:      job.IsKeepDirectory,
 			},
+			Sandbox: w.Sandbox,
+			Network: w.Network.Network(),
 		})
 	if err != nil {
 		log.Printf("failed to run the job %q: %v", job.ResultName, err)
@@ -205,6 +207,9 @@ func main() {
 		appConfig.Name = generateName()
 	}
 
+	grace.SuccessRequired(appConfig.Sandbox.Validate(), "Cannot sandbox scripts as configured")
+	grace.SuccessRequired(appConfig.Network.Validate(), "Cannot route probes as configured")
+
 	apiClient, err := appConfig.NewClient()
 	grace.SuccessRequired(err, "Failed to initialize API Client")
 
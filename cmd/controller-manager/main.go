@@ -0,0 +1,106 @@
+// Command controller-manager runs Urth's control loops apart from the API
+// servers: the dispatch relay, the reconciler, the advisory and presence
+// watchers, the retention sweep and the remote writer.
+//
+// ADR 0006 §6 keeps this a deployment change. The loops are composed by
+// pkg/apiserver and pkg/controllers exactly as an api-server composes them; what
+// is different is that each runs only in the replica holding its lease, and that
+// the api-servers are started with --external-controllers. What is left
+// here is what only a process can own: flags, a database connection, a status
+// listener and a shutdown.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/alecthomas/kong"
+	_ "github.com/joho/godotenv/autoload"
+	"gorm.io/gorm"
+
+	"github.com/sre-norns/urth/pkg/apiserver"
+	"github.com/sre-norns/urth/pkg/cliconfig"
+	"github.com/sre-norns/urth/pkg/controllers"
+	"github.com/sre-norns/wyrd/pkg/grace"
+)
+
+var appCli apiserver.ControllerManagerConfig
+
+// statusHandler serves the manager's view of its loops.
+//
+// /healthz answers whether the process is up, and nothing more: a replica in
+// standby for every loop is healthy, and restarting it because another replica
+// holds the leases would only churn them. Whether the loops are running
+// somewhere is answered by the lease rows, and /status says what this replica
+// is doing about them.
+func statusHandler(manager *controllers.Manager) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /healthz", func(rw http.ResponseWriter, _ *http.Request) {
+		rw.WriteHeader(http.StatusOK)
+	})
+
+	mux.HandleFunc("GET /status", func(rw http.ResponseWriter, _ *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(rw).Encode(manager.Status()); err != nil {
+			log.Printf("failed to write loop status: %v", err)
+		}
+	})
+
+	return mux
+}
+
+func main() {
+	kong.Parse(&appCli,
+		kong.Name("controller-manager"),
+		kong.Description("Urth control loops, run apart from the API servers"),
+		cliconfig.Option("controller-manager"),
+	)
+
+	ctx := grace.NewSignalHandlingContext()
+
+	dial, err := appCli.Dialector()
+	grace.SuccessRequired(err, "Failed to create datasource connector (config issue)")
+
+	db, err := gorm.Open(dial, &gorm.Config{})
+	grace.SuccessRequired(err, "failed to connect the datastore")
+
+	// Only the loops' own tables. The rest are the api-server's to migrate,
+	// and a controller-manager started first should not create them under a
+	// schema it does not own.
+	grace.SuccessRequired(db.AutoMigrate(controllers.Models()...), "DB schema migration failed")
+
+	manager, err := apiserver.NewControllerManager(ctx, db, appCli)
+	grace.SuccessRequired(err, "failed to compose the control loops")
+	defer manager.Close()
+
+	if appCli.StatusAddress != "" {
+		server := &http.Server{
+			Addr:              appCli.StatusAddress,
+			Handler:           statusHandler(manager.Loops),
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+
+		go func() {
+			log.Printf("serving loop status on %s", server.Addr)
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Printf("status endpoint stopped: %v", err)
+			}
+		}()
+		defer server.Shutdown(context.WithoutCancel(ctx))
+	}
+
+	manager.Loops.Start(ctx)
+	log.Printf("control loops hosted by this process: %v", manager.Loops.Names())
+
+	<-ctx.Done()
+	log.Print("shutting down")
+
+	if err := manager.Loops.Wait(appCli.Controllers.ShutdownTimeout); err != nil {
+		log.Printf("control loops did not stop cleanly: %v", err)
+	}
+}
@@ -8,21 +8,46 @@ package main
 
 import (
 	"errors"
+	"os"
 
 	"github.com/alecthomas/kong"
 	_ "github.com/joho/godotenv/autoload"
 
+	"github.com/sre-norns/urth/pkg/cliconfig"
 	"github.com/sre-norns/urth/pkg/worker"
 	"github.com/sre-norns/wyrd/pkg/grace"
+	"github.com/sre-norns/wyrd/pkg/manifest"
 )
 
 var appConfig = worker.NewDefaultConfig()
 
-func main() {
-	kong.Parse(&appConfig,
+func parserOptions() []kong.Option {
+	return []kong.Option{
 		kong.Name("nats-worker"),
 		kong.Description("Urth worker: claims scenarios from its runner's queue and executes them"),
-	)
+		cliconfig.Option("nats-worker"),
+	}
+}
+
+// reloadLabels reads the configuration again the way the process started --
+// same arguments, same environment, the configuration files as they are now --
+// and returns the custom labels it finds.
+func reloadLabels() (manifest.Labels, error) {
+	fresh := worker.NewDefaultConfig()
+
+	parser, err := kong.New(&fresh, parserOptions()...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := parser.Parse(os.Args[1:]); err != nil {
+		return nil, err
+	}
+
+	return fresh.CustomLabels, nil
+}
+
+func main() {
+	kong.Parse(&appConfig, parserOptions()...)
 
 	token, err := appConfig.EnrolmentToken()
 	grace.SuccessRequired(err, "failed to read enrolment token")
@@ -38,5 +63,5 @@ func main() {
 	// than abandoning results the server is waiting for.
 	ctx := grace.NewSignalHandlingContext()
 
-	grace.SuccessRequired(worker.New(&appConfig, apiClient, token).Run(ctx), "worker terminated")
+	grace.SuccessRequired(worker.New(&appConfig, apiClient, token, worker.WithLabelReload(reloadLabels)).Run(ctx), "worker terminated")
 }
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+
+	"github.com/sre-norns/urth/pkg/urth"
+)
+
+type (
+	// AuditLog lists who changed what. See urth.AuditEvent.
+	AuditLog struct {
+		Selector string `help:"Selector (label query) to filter on, e.g. urth/audit.actor=alice-laptop" optional:"" name:"selector" short:"l"`
+		Output   string `help:"Output format. jsonl writes every matching event, one manifest per line, for keeping or for another tool" enum:"wide,short,jsonl" default:"short" name:"output" short:"o"`
+
+		Since time.Duration `help:"Only events this recent, unless --from is given. Zero for all of them" name:"since" default:"24h"`
+		From  time.Time     `help:"Start of the range, RFC 3339" name:"from" optional:""`
+		Till  time.Time     `help:"End of the range, RFC 3339. Defaults to now" name:"till" optional:""`
+	}
+
+	// AuditEvent shows one audit event in full.
+	AuditEvent struct {
+		ID manifest.ResourceName `help:"Name of the audit event" arg:"" name:"name"`
+	}
+)
+
+// auditChangesShown is how many changed fields the short listing names before
+// summing up the rest.
+const auditChangesShown = 3
+
+func (c *AuditLog) Run(cfg *commandContext) error {
+	apiClient, err := cfg.NewClient()
+	if err != nil {
+		return fmt.Errorf("failed to initialize API Client: %w", err)
+	}
+
+	selector, err := manifest.ParseSelector(c.Selector)
+	if err != nil {
+		return fmt.Errorf("failed to parse labels selector: %w", err)
+	}
+
+	query := manifest.SearchQuery{Selector: selector, FromTime: c.From, TillTime: c.Till}
+	if query.FromTime.IsZero() && c.Since > 0 {
+		query.FromTime = time.Now().Add(-c.Since)
+	}
+
+	if c.Output == "jsonl" {
+		// Not bounded by --timeout: an export is as long as the log is, and
+		// the server streams it rather than making the client wait for all of
+		// it.
+		encoder := json.NewEncoder(os.Stdout)
+		return apiClient.AuditLog().Export(cfg.Context, query, func(event urth.AuditEvent) error {
+			return encoder.Encode(event.ToManifest())
+		})
+	}
+
+	ctx, cancel := cfg.ClientCallContext()
+	defer cancel()
+
+	events, _, err := apiClient.AuditLog().List(ctx, query)
+	if err != nil {
+		return err
+	}
+
+	t := table.NewWriter()
+	t.Style().Options = table.OptionsNoBordersAndSeparators
+	t.Style().Format.HeaderAlign = text.AlignLeft
+	t.Style().Format.RowAlign = text.AlignLeft
+
+	t.SetOutputMirror(os.Stdout)
+	header := table.Row{"Time", "Actor", "Action", "Kind", "Resource", "Changes"}
+	if c.Output == "wide" {
+		header = append(header, "Name", "Verified", "Version", "Source", "Request")
+	}
+	t.AppendHeader(header)
+
+	for _, event := range events {
+		row := table.Row{
+			event.Spec.Time.Local().Format(time.DateTime),
+			event.Spec.Actor,
+			event.Spec.Action,
+			event.Spec.ResourceKind,
+			event.Spec.ResourceName,
+			auditChangedFields(event.Spec.Changes, c.Output == "wide"),
+		}
+
+		if c.Output == "wide" {
+			row = append(row,
+				event.Name,
+				event.Spec.Authenticated,
+				event.Spec.ResourceVersion,
+				auditSource(event.Spec),
+				orDash(event.Spec.RequestID),
+			)
+		}
+
+		t.AppendRow(row)
+	}
+
+	t.Render()
+
+	return nil
+}
+
+func (c *AuditEvent) Run(cfg *commandContext) error {
+	apiClient, err := cfg.NewClient()
+	if err != nil {
+		return fmt.Errorf("failed to initialize API Client: %w", err)
+	}
+
+	ctx, cancel := cfg.ClientCallContext()
+	defer cancel()
+
+	event, exists, err := apiClient.AuditLog().Get(ctx, c.ID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return fmt.Errorf("audit event %q not found", c.ID)
+	}
+
+	return cfg.OutputFormatter(event.ToManifest())
+}
+
+// auditChangedFields names the fields an event changed: all of them when wide,
+// the first few otherwise, since a resource's creation changes every field it
+// has. The values are for `get audit-event`.
+func auditChangedFields(changes []urth.AuditChange, all bool) string {
+	if len(changes) == 0 {
+		return "-"
+	}
+
+	paths := make([]string, 0, len(changes))
+	for _, change := range changes {
+		paths = append(paths, change.Path)
+	}
+
+	if all || len(paths) <= auditChangesShown {
+		return strings.Join(paths, ", ")
+	}
+
+	return fmt.Sprintf("%s and %d more", strings.Join(paths[:auditChangesShown], ", "), len(paths)-auditChangesShown)
+}
+
+// auditSource says where a change came from: the forwarded chain when a proxy
+// reported one, then the address the server saw.
+func auditSource(spec urth.AuditEventSpec) string {
+	if spec.ForwardedFor != "" {
+		return fmt.Sprintf("%s via %s", spec.ForwardedFor, orDash(spec.SourceIP))
+	}
+
+	return orDash(spec.SourceIP)
+}
+
+// defaultActor is who urthctl says its user is, when --actor is not given:
+// their login on this host, which is as much as a CLI can know and more than
+// the server would otherwise be told.
+func defaultActor() string {
+	name := os.Getenv("USER")
+	if current, err := user.Current(); err == nil && current.Username != "" {
+		name = current.Username
+	}
+
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return name
+	}
+	if name == "" {
+		return host
+	}
+
+	return name + "@" + host
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+
+	"github.com/sre-norns/urth/pkg/urth"
+)
+
+// Availability reports a scenario's availability, one row per vantage point.
+type Availability struct {
+	ScenarioID manifest.ResourceName `help:"Name of the scenario" arg:"" name:"scenario"`
+
+	From    time.Time `help:"Start of the range, RFC 3339. Defaults to the scenario's SLO window before --till" name:"from" optional:""`
+	Till    time.Time `help:"End of the range, RFC 3339. Defaults to now" name:"till" optional:""`
+	GroupBy string    `help:"Break the report down further: \"runner\" for one row per runner" optional:"" name:"group-by"`
+	Output  string    `help:"Output format: wide also lists every incident" enum:"wide,short" default:"short" name:"output" short:"o"`
+}
+
+// maybePercent renders a figure that is absent when nothing was measured.
+func maybePercent(value *float64) string {
+	if value == nil {
+		return "-"
+	}
+
+	return fmt.Sprintf("%.3f%%", *value)
+}
+
+// causedBy names what an incident was a consequence of, if it was one.
+func causedBy(incident urth.Incident) string {
+	switch {
+	case !incident.Consequential:
+		return "-"
+	case len(incident.Upstream) == 0:
+		return "(upstream)"
+	default:
+		return joinNames(incident.Upstream)
+	}
+}
+
+func (c *Availability) Run(cfg *commandContext) error {
+	apiClient, err := cfg.NewClient()
+	if err != nil {
+		return fmt.Errorf("failed to initialize API Client: %w", err)
+	}
+
+	ctx, cancel := cfg.ClientCallContext()
+	defer cancel()
+
+	report, exists, err := apiClient.Scenarios().Availability(ctx, c.ScenarioID, urth.AvailabilityQuery{
+		From:    c.From,
+		Till:    c.Till,
+		GroupBy: c.GroupBy,
+	})
+	if err != nil {
+		return err
+	} else if !exists {
+		return fmt.Errorf("scenario %q not found", c.ScenarioID)
+	}
+
+	target := "-"
+	if report.SLO != nil {
+		target = fmt.Sprintf("%v%%", report.SLO.Target)
+	}
+
+	fmt.Printf("Scenario %s, %v to %v, target %s\n\n", report.Scenario,
+		report.Query.From.Local().Format(time.DateTime), report.Query.Till.Local().Format(time.DateTime), target)
+
+	rows := append([]urth.Availability{report.Overall}, report.Runners...)
+
+	t := table.NewWriter()
+	t.Style().Options = table.OptionsNoBordersAndSeparators
+	t.Style().Format.HeaderAlign = text.AlignLeft
+	t.Style().Format.RowAlign = text.AlignLeft
+	t.SetOutputMirror(os.Stdout)
+
+	t.AppendHeader(table.Row{"Runner", "Availability", "Budget left", "Burn rate", "Incidents", "MTTR", "Good", "Bad", "Excluded"})
+	for i, row := range rows {
+		runner := string(row.RunnerName)
+		if i == 0 {
+			runner = "(all)"
+		} else if runner == "" {
+			runner = "(unplaced)"
+		}
+
+		burnRate := "-"
+		if row.BurnRate != nil {
+			burnRate = fmt.Sprintf("%.2f", *row.BurnRate)
+		}
+
+		t.AppendRow(table.Row{
+			runner,
+			maybePercent(row.Availability),
+			maybePercent(row.ErrorBudgetRemaining),
+			burnRate,
+			len(row.Incidents),
+			row.MTTR.Round(time.Second),
+			row.Good,
+			row.Bad,
+			row.Excluded,
+		})
+	}
+	t.Render()
+
+	if c.Output != "wide" {
+		return nil
+	}
+
+	fmt.Println()
+
+	incidents := table.NewWriter()
+	incidents.Style().Options = table.OptionsNoBordersAndSeparators
+	incidents.Style().Format.HeaderAlign = text.AlignLeft
+	incidents.Style().Format.RowAlign = text.AlignLeft
+	incidents.SetOutputMirror(os.Stdout)
+
+	incidents.AppendHeader(table.Row{"Runner", "Start", "End", "Duration", "Failed runs", "Caused by"})
+	for i, row := range rows {
+		runner := string(row.RunnerName)
+		if i == 0 {
+			runner = "(all)"
+		}
+
+		for _, incident := range row.Incidents {
+			end := "ongoing"
+			if incident.End != nil {
+				end = incident.End.Local().Format(time.DateTime)
+			}
+
+			incidents.AppendRow(table.Row{
+				runner,
+				incident.Start.Local().Format(time.DateTime),
+				end,
+				incident.Duration.Round(time.Second),
+				incident.FailedRuns,
+				causedBy(incident),
+			})
+		}
+	}
+	incidents.Render()
+
+	return nil
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/sre-norns/wyrd/pkg/manifest"
+
+	"github.com/sre-norns/urth/pkg/urth"
+)
+
+// CancelCmd stops a run.
+//
+// The operator's name defaults to the login the CLI runs under. The server has
+// no way to check it (see urth.CancelRunRequest), so the flag is there for the
+// shared-account and automation cases rather than as a credential.
+type CancelCmd struct {
+	Scenario manifest.ResourceName `help:"Name of the scenario the run belongs to" arg:"" name:"scenario"`
+	RunID    manifest.ResourceName `help:"Name of the run to cancel" arg:"" name:"run"`
+
+	RequestedBy string `help:"Who is cancelling the run" name:"as" env:"USER" required:""`
+	Reason      string `help:"Why the run is being cancelled" name:"reason" optional:""`
+}
+
+func (c *CancelCmd) Run(cfg *commandContext) error {
+	apiClient, err := cfg.NewClient()
+	if err != nil {
+		return fmt.Errorf("failed to initialize API Client: %w", err)
+	}
+
+	ctx, cancel := cfg.ClientCallContext()
+	defer cancel()
+
+	result, err := apiClient.Results(c.Scenario).Cancel(ctx, c.RunID, urth.CancelRunRequest{
+		RequestedBy: c.RequestedBy,
+		Reason:      c.Reason,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Said differently for the two outcomes, because they are: one is done, the
+	// other is waiting on a worker that may have finished the run already.
+	if result.Status.Status == urth.JobCancelling {
+		fmt.Printf("Asked the worker executing %q to stop it.\n", result.Name)
+		return nil
+	}
+
+	fmt.Printf("Cancelled %q before it started.\n", result.Name)
+
+	return nil
+}
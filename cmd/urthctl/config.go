@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/sre-norns/urth/pkg/cliconfig"
+)
+
+// appName is the name urthctl's configuration files go by; see pkg/cliconfig.
+const appName = "urthctl"
+
+// ConfigCmd reads and switches the contexts in urthctl's configuration files.
+//
+// Only the current context is ever written, and only to the user's own file:
+// the contexts themselves, with their tokens, are the operator's to edit, and
+// a system-wide file is not this user's to change.
+type ConfigCmd struct {
+	UseContext     useContextCmd     `cmd:"" help:"Make a context the one used when --context is not given"`
+	GetContexts    getContextsCmd    `cmd:"" help:"List the contexts the configuration files define"`
+	CurrentContext currentContextCmd `cmd:"" help:"Print the context in use"`
+}
+
+type useContextCmd struct {
+	Name string `arg:"" help:"Name of the context to use"`
+}
+
+func (c *useContextCmd) Run() error {
+	document, err := cliconfig.Load(cliconfig.Files(appName)...)
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains(document.Contexts(), c.Name) {
+		return fmt.Errorf("context %q is not defined in any of %v", c.Name, cliconfig.Files(appName))
+	}
+
+	file, err := cliconfig.UserFile(appName)
+	if err != nil {
+		return err
+	}
+
+	if err := cliconfig.SetCurrentContext(file, c.Name); err != nil {
+		return fmt.Errorf("failed to record the current context: %w", err)
+	}
+
+	fmt.Printf("Switched to context %q.\n", c.Name)
+
+	return nil
+}
+
+type getContextsCmd struct{}
+
+func (c *getContextsCmd) Run() error {
+	document, err := cliconfig.Load(cliconfig.Files(appName)...)
+	if err != nil {
+		return err
+	}
+
+	current := document.CurrentContext()
+	for _, name := range document.Contexts() {
+		marker := " "
+		if name == current {
+			marker = "*"
+		}
+		fmt.Printf("%s %s\n", marker, name)
+	}
+
+	return nil
+}
+
+type currentContextCmd struct{}
+
+// Run prints the context this invocation would use, so --context and
+// URTH_CONTEXT show through.
+func (c *currentContextCmd) Run() error {
+	name := appCli.Context
+	if name == "" {
+		document, err := cliconfig.Load(cliconfig.Files(appName)...)
+		if err != nil {
+			return err
+		}
+		name = document.CurrentContext()
+	}
+
+	if name == "" {
+		return fmt.Errorf("no context is in use")
+	}
+
+	fmt.Println(name)
+
+	return nil
+}
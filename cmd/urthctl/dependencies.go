@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// Dependencies prints the scenario dependency graph: what each scenario's
+// failures may be put down to, and what each one's outage takes down with it.
+type Dependencies struct {
+	Output string `help:"Output format: wide also lists dependents and missing names" enum:"wide,short" default:"short" name:"output" short:"o"`
+}
+
+func (c *Dependencies) Run(cfg *commandContext) error {
+	apiClient, err := cfg.NewClient()
+	if err != nil {
+		return fmt.Errorf("failed to initialize API Client: %w", err)
+	}
+
+	ctx, cancel := cfg.ClientCallContext()
+	defer cancel()
+
+	graph, err := apiClient.Scenarios().Dependencies(ctx)
+	if err != nil {
+		return err
+	}
+
+	t := table.NewWriter()
+	t.Style().Options = table.OptionsNoBordersAndSeparators
+	t.Style().Format.HeaderAlign = text.AlignLeft
+	t.Style().Format.RowAlign = text.AlignLeft
+	t.SetOutputMirror(os.Stdout)
+
+	header := table.Row{"Scenario", "Depends on"}
+	if c.Output == "wide" {
+		header = append(header, "Dependents", "Missing")
+	}
+	t.AppendHeader(header)
+
+	for _, node := range graph.Scenarios {
+		row := table.Row{node.Scenario, orDash(joinNames(node.DependsOn))}
+		if c.Output == "wide" {
+			row = append(row, orDash(joinNames(node.Dependents)), orDash(joinNames(node.Missing)))
+		}
+		t.AppendRow(row)
+	}
+
+	t.Render()
+	return nil
+}
+
+func joinNames(names []manifest.ResourceName) string {
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		parts = append(parts, string(name))
+	}
+
+	return strings.Join(parts, ", ")
+}
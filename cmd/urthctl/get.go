@@ -61,13 +61,26 @@ type (
 		Scenarios Scenarios `cmd:"" help:"List all scenarios"`
 		Script    Script    `cmd:"" help:"Get a script data for a given scenario"`
 		Results   Results   `cmd:"" help:"Get a run result"`
-		Artifact  Artifact  `cmd:"" help:"Get artifact produced during a scenario execution"`
-		Runner    Runner    `cmd:"" help:"Get a runner object from the server"`
-		Runners   Runners   `cmd:"" help:"List all runners"`
-		Labels    Labels    `cmd:"" help:"Get labels"`
+		Stats     Stats     `cmd:"" help:"Summarise a scenario's run history by hour or day"`
+
+		Availability Availability `cmd:"" help:"Report a scenario's availability against its SLO"`
+		Dependencies Dependencies `cmd:"" help:"Show which scenarios depend on which"`
+		Artifact     Artifact     `cmd:"" help:"Get artifact produced during a scenario execution"`
+		Runner       Runner       `cmd:"" help:"Get a runner object from the server"`
+		Runners      Runners      `cmd:"" help:"List all runners"`
+		Labels       Labels       `cmd:"" help:"Get labels"`
 
 		DeadLetter  DeadLetter  `cmd:"" name:"dead-letter" help:"Get one dispatch failure in full"`
 		DeadLetters DeadLetters `cmd:"" name:"dead-letters" help:"List dispatches that stopped making progress"`
+
+		MaintenanceWindows MaintenanceWindows `cmd:"" name:"maintenance-windows" help:"List maintenance windows and whether each is open"`
+		RateLimits         RateLimits         `cmd:"" name:"rate-limits" help:"List rate limits and the load on each"`
+
+		RunnerTokens RunnerTokens `cmd:"" name:"runner-tokens" help:"List the enrolment tokens issued for a runner"`
+		WorkerBlocks WorkerBlocks `cmd:"" name:"worker-blocks" help:"List worker blocks and the workers each bars"`
+
+		Audit      AuditLog   `cmd:"" help:"List who changed what, or export the audit log as JSON lines"`
+		AuditEvent AuditEvent `cmd:"" name:"audit-event" help:"Get one audit event in full, with the values it changed"`
 	}
 )
 
@@ -318,7 +331,7 @@ func (c *Results) Run(cfg *commandContext) error {
 
 	header := table.Row{"Name", "Duration", "Status", "Age"}
 	if c.Output == "wide" {
-		header = append(header, "Results", "Kind", "Artifacts")
+		header = append(header, "Results", "Kind", "Priority", "Artifacts")
 	}
 	t.AppendHeader(header)
 
@@ -329,6 +342,7 @@ func (c *Results) Run(cfg *commandContext) error {
 			row = append(row,
 				resource.Status.Result,
 				resource.Spec.ProbKind,
+				orDash(string(resource.Spec.Priority)),
 				strconv.FormatUint(resource.Status.NumberArtifacts, 10),
 			)
 		}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/alecthomas/kong"
 	_ "github.com/joho/godotenv/autoload"
+	"github.com/sre-norns/urth/pkg/cliconfig"
 	"github.com/sre-norns/urth/pkg/urth"
 	"github.com/sre-norns/wyrd/pkg/grace"
 )
@@ -31,6 +32,10 @@ func (f outputFormat) AfterApply(cfg *commandContext) (err error) {
 var appCli struct {
 	urth.APIClientConfig
 
+	// Context picks one of the contexts in the configuration files for a
+	// single invocation; see `urthctl config`.
+	Context string `help:"Configuration context to use instead of the current one" env:"URTH_CONTEXT"`
+
 	// short:"o"
 	Format outputFormat `enum:"yaml,yml,json" help:"Data output format" default:"yml"`
 
@@ -46,8 +51,16 @@ var appCli struct {
 	// reads: a retry schedules a new run.
 	Retry   RetryCmd   `cmd:"" help:"Retry a dispatch that stopped making progress"`
 	Resolve ResolveCmd `cmd:"" help:"Close a dispatch failure without retrying it"`
+	Cancel  CancelCmd  `cmd:"" help:"Cancel a scenario run that is pending or running"`
+
+	RevokeToken RevokeTokenCmd `cmd:"" name:"revoke-token" help:"Revoke a runner's enrolment token so no worker can register with it"`
 
 	Convert ConvertHar `cmd:"" help:"Convert HAR file into a .http file format"`
+
+	// Works on a keyring directory rather than the API; see SigningKeysCmd.
+	SigningKeys SigningKeysCmd `cmd:"" name:"signing-keys" help:"List and rotate the keys API servers sign tokens with"`
+
+	Config ConfigCmd `cmd:"" help:"Show and switch the contexts in the configuration files"`
 }
 
 func main() {
@@ -61,7 +74,14 @@ func main() {
 		kong.Name("urthctl"),
 		kong.Description("Urth Command line tool"),
 		kong.Bind(cfg),
+		cliconfig.Option(appName),
 	)
 
+	// Filled in rather than defaulted by kong, whose defaults are fixed
+	// strings. See defaultActor.
+	if appCli.Actor == "" {
+		appCli.Actor = defaultActor()
+	}
+
 	appCtx.FatalIfErrorf(appCtx.Run(cfg))
 }
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+
+	"github.com/sre-norns/urth/pkg/urth"
+)
+
+// Maintenance windows are declared with `urthctl apply`, like any other
+// resource. What is here is the listing, because the question asked of windows
+// during an incident is "is one open right now", and the manifest does not say.
+
+// MaintenanceWindows lists the declared maintenance windows.
+type MaintenanceWindows struct {
+	Selector string `help:"Selector (label query) to filter on" optional:"" name:"selector" short:"l"`
+	Output   string `help:"Output format" enum:"wide,short" default:"short" name:"output" short:"o"`
+}
+
+func (c *MaintenanceWindows) Run(cfg *commandContext) error {
+	apiClient, err := cfg.NewClient()
+	if err != nil {
+		return fmt.Errorf("failed to initialize API Client: %w", err)
+	}
+
+	selector, err := manifest.ParseSelector(c.Selector)
+	if err != nil {
+		return fmt.Errorf("failed to parse labels selector: %w", err)
+	}
+
+	ctx, cancel := cfg.ClientCallContext()
+	defer cancel()
+
+	resources, _, err := apiClient.MaintenanceWindows().List(ctx, manifest.SearchQuery{Selector: selector})
+	if err != nil {
+		return err
+	}
+
+	t := table.NewWriter()
+	t.Style().Options = table.OptionsNoBordersAndSeparators
+	t.Style().Format.HeaderAlign = text.AlignLeft
+	t.Style().Format.RowAlign = text.AlignLeft
+
+	t.SetOutputMirror(os.Stdout)
+	header := table.Row{"Name", "Mode", "Schedule", "Duration", "Open", "Next"}
+	if c.Output == "wide" {
+		header = append(header, "Scenarios", "Runners", "Description")
+	}
+	t.AppendHeader(header)
+
+	for _, resource := range resources {
+		window, err := urth.NewMaintenanceWindow(resource)
+		if err != nil {
+			return fmt.Errorf("error while parsing maintenance windows: %w", err)
+		}
+
+		mode := window.Spec.Mode
+		if mode == "" {
+			mode = urth.MaintenanceSkip
+		}
+
+		row := table.Row{
+			window.Name,
+			mode,
+			windowSchedule(window.Spec),
+			window.Spec.Duration,
+			windowOpen(window.Status),
+			orDash(windowTime(window.Status.NextOpening)),
+		}
+
+		if c.Output == "wide" {
+			row = append(row,
+				window.Spec.Scenarios,
+				window.Spec.Runners,
+				window.Spec.Description,
+			)
+		}
+
+		t.AppendRow(row)
+	}
+
+	t.Render()
+	return nil
+}
+
+// windowSchedule renders when a window opens: its cron expression, or the one
+// time it does.
+func windowSchedule(spec urth.MaintenanceWindowSpec) string {
+	if spec.Schedule == "" {
+		return "once"
+	}
+
+	if spec.TimeZone != "" {
+		return fmt.Sprintf("%s (%s)", spec.Schedule, spec.TimeZone)
+	}
+
+	return string(spec.Schedule)
+}
+
+// windowOpen says whether a window is in force, and until when.
+func windowOpen(status urth.MaintenanceWindowStatus) string {
+	if !status.Open || status.ClosesAt == nil {
+		return "no"
+	}
+
+	return fmt.Sprintf("until %s", status.ClosesAt.Local().Format(time.DateTime))
+}
+
+func windowTime(at *time.Time) string {
+	if at == nil {
+		return ""
+	}
+
+	return at.Local().Format(time.DateTime)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+
+	"github.com/sre-norns/urth/pkg/urth"
+)
+
+// Rate limits are declared with `urthctl apply`, like maintenance windows. The
+// listing is here because the question asked of a limit -- "is it what is
+// holding my runs back" -- is about load the manifest cannot show.
+
+// RateLimits lists the declared rate limits.
+type RateLimits struct {
+	Selector string `help:"Selector (label query) to filter on" optional:"" name:"selector" short:"l"`
+	Output   string `help:"Output format" enum:"wide,short" default:"short" name:"output" short:"o"`
+}
+
+func (c *RateLimits) Run(cfg *commandContext) error {
+	apiClient, err := cfg.NewClient()
+	if err != nil {
+		return fmt.Errorf("failed to initialize API Client: %w", err)
+	}
+
+	selector, err := manifest.ParseSelector(c.Selector)
+	if err != nil {
+		return fmt.Errorf("failed to parse labels selector: %w", err)
+	}
+
+	ctx, cancel := cfg.ClientCallContext()
+	defer cancel()
+
+	resources, _, err := apiClient.RateLimits().List(ctx, manifest.SearchQuery{Selector: selector})
+	if err != nil {
+		return err
+	}
+
+	t := table.NewWriter()
+	t.Style().Options = table.OptionsNoBordersAndSeparators
+	t.Style().Format.HeaderAlign = text.AlignLeft
+	t.Style().Format.RowAlign = text.AlignLeft
+
+	t.SetOutputMirror(os.Stdout)
+	header := table.Row{"Name", "Hosts", "Rate", "Running", "Throttling"}
+	if c.Output == "wide" {
+		header = append(header, "Scenarios", "Selector", "Description")
+	}
+	t.AppendHeader(header)
+
+	for _, resource := range resources {
+		limit, err := urth.NewRateLimit(resource)
+		if err != nil {
+			return fmt.Errorf("error while parsing rate limits: %w", err)
+		}
+
+		row := table.Row{
+			limit.Name,
+			orDash(strings.Join(limit.Spec.Hosts, ",")),
+			limitRate(limit),
+			limitConcurrency(limit),
+			limit.Status.Throttling,
+		}
+
+		if c.Output == "wide" {
+			row = append(row,
+				limit.Status.Scenarios,
+				limit.Spec.Scenarios,
+				limit.Spec.Description,
+			)
+		}
+
+		t.AppendRow(row)
+	}
+
+	t.Render()
+	return nil
+}
+
+// limitRate renders runs started against the rate bound, as "3/10 per 1m0s".
+func limitRate(limit urth.RateLimit) string {
+	if limit.Spec.MaxRuns == 0 {
+		return "-"
+	}
+
+	return fmt.Sprintf("%d/%d per %v", limit.Status.Started, limit.Spec.MaxRuns, limit.Spec.Interval)
+}
+
+// limitConcurrency renders runs running against the concurrency bound.
+func limitConcurrency(limit urth.RateLimit) string {
+	if limit.Spec.MaxConcurrent == 0 {
+		return fmt.Sprintf("%d", limit.Status.Running)
+	}
+
+	return fmt.Sprintf("%d/%d", limit.Status.Running, limit.Spec.MaxConcurrent)
+}
@@ -54,6 +54,7 @@ func (c *RunCmd) runScenario(cmdCtx context.Context, sourceName string, probSpec
 			CaptureRequestBody:  false,
 			IgnoreRedirects:     false,
 		},
+		Network: c.Network.Network(),
 	})
 	if err != nil {
 		return err
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+
+	"github.com/sre-norns/urth/pkg/urth"
+)
+
+// SigningKeysCmd manages the keyring directory API servers sign tokens with.
+//
+// These work on the directory, not through the API: the keys are the one thing
+// the API must never hand out, so rotating them is done wherever the directory
+// is -- a host the servers share a volume with, or a checkout that a secret
+// store syncs from. Like `convert`, they need no server.
+type SigningKeysCmd struct {
+	List   listSigningKeysCmd   `cmd:"" help:"List the keys in a keyring directory and what each is doing"`
+	Rotate rotateSigningKeysCmd `cmd:"" help:"Add a new key to a tier and retire the ones it supersedes after an overlap"`
+}
+
+type listSigningKeysCmd struct {
+	KeyringDir string `help:"Keyring directory the API servers read" env:"URTH_SIGNING_KEYRING_DIR" type:"existingdir" required:""`
+}
+
+func (c *listSigningKeysCmd) Run() error {
+	keys, err := urth.ReadKeyringDir(c.KeyringDir)
+	if err != nil {
+		return err
+	}
+
+	t := table.NewWriter()
+	t.Style().Options = table.OptionsNoBordersAndSeparators
+	t.Style().Format.HeaderAlign = text.AlignLeft
+	t.Style().Format.RowAlign = text.AlignLeft
+
+	t.SetOutputMirror(os.Stdout)
+	t.AppendHeader(table.Row{"Tier", "Key", "State", "Signs from", "Verifies until"})
+
+	now := time.Now()
+	for _, tier := range urth.KeyTiers {
+		tierKeys := slices.Clone(keys[tier])
+		slices.SortFunc(tierKeys, func(a, b urth.SigningKey) int { return a.NotBefore.Compare(b.NotBefore) })
+
+		active, _ := urth.ActiveSigningKey(tierKeys, now)
+		for _, key := range tierKeys {
+			t.AppendRow(table.Row{
+				tier,
+				key.ID,
+				signingKeyState(key, active, now),
+				keyTime(key.NotBefore),
+				keyTime(key.NotAfter),
+			})
+		}
+	}
+
+	t.Render()
+	return nil
+}
+
+// signingKeyState says what a key is doing in a rotation. See urth.KeyTier.
+func signingKeyState(key, active urth.SigningKey, now time.Time) string {
+	switch {
+	case !key.Verifies(now):
+		return "retired"
+	case key.ID == active.ID:
+		return "current"
+	case now.Before(key.NotBefore):
+		return "staged"
+	default:
+		return "retiring"
+	}
+}
+
+func keyTime(at time.Time) string {
+	if at.IsZero() {
+		return "-"
+	}
+
+	return at.Local().Format(time.DateTime)
+}
+
+type rotateSigningKeysCmd struct {
+	Tier string `help:"Tier to rotate: enrolment, session or run" arg:"" enum:"enrolment,session,run"`
+
+	KeyringDir    string        `help:"Keyring directory the API servers read" env:"URTH_SIGNING_KEYRING_DIR" type:"path" required:""`
+	ActivateAfter time.Duration `help:"How long the new key only verifies before it signs; longer than the servers' --signing.reload-interval" default:"2m"`
+	Overlap       time.Duration `help:"How long superseded keys keep verifying once the new key signs; longer than the tier's longest-lived token. Zero takes the tier's default" default:"0"`
+}
+
+func (c *rotateSigningKeysCmd) Run() error {
+	tier, err := urth.ParseKeyTier(c.Tier)
+	if err != nil {
+		return err
+	}
+
+	overlap := c.Overlap
+	if overlap <= 0 {
+		overlap = urth.DefaultRotationOverlap(tier)
+	}
+
+	key, err := urth.RotateSigningKey(c.KeyringDir, tier, c.ActivateAfter, overlap)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Added %s key %q. It signs from %s; the keys it supersedes verify until %s.\n",
+		tier, key.ID, keyTime(key.NotBefore), keyTime(key.NotBefore.Add(overlap)))
+
+	return nil
+}
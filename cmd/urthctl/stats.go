@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+
+	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/urth/pkg/urth"
+)
+
+// Stats summarises a scenario's run history, one row per bucket.
+//
+// The same numbers the UI graphs, for the same reason dead letters have a CLI
+// surface: "how flaky has this been since Tuesday" is asked from a terminal as
+// often as from a browser.
+type Stats struct {
+	ScenarioID manifest.ResourceName `help:"Name of the scenario" arg:"" name:"scenario"`
+
+	From        time.Time `help:"Start of the window, RFC 3339. Defaults to a day before --till" name:"from" optional:""`
+	Till        time.Time `help:"End of the window, RFC 3339. Defaults to now" name:"till" optional:""`
+	Granularity string    `help:"Bucket width" enum:"hour,day" default:"hour" name:"granularity"`
+	GroupBy     string    `help:"Break buckets down further: \"runner\" for one row per runner" optional:"" name:"group-by"`
+	Output      string    `help:"Output format" enum:"wide,short" default:"short" name:"output" short:"o"`
+}
+
+func (c *Stats) Run(cfg *commandContext) error {
+	apiClient, err := cfg.NewClient()
+	if err != nil {
+		return fmt.Errorf("failed to initialize API Client: %w", err)
+	}
+
+	ctx, cancel := cfg.ClientCallContext()
+	defer cancel()
+
+	stats, exists, err := apiClient.Scenarios().Stats(ctx, c.ScenarioID, urth.ResultStatsQuery{
+		From:        c.From,
+		Till:        c.Till,
+		Granularity: urth.RollupGranularity(c.Granularity),
+		GroupBy:     c.GroupBy,
+	})
+	if err != nil {
+		return err
+	} else if !exists {
+		return fmt.Errorf("scenario %q not found", c.ScenarioID)
+	}
+
+	t := table.NewWriter()
+	t.Style().Options = table.OptionsNoBordersAndSeparators
+	t.Style().Format.HeaderAlign = text.AlignLeft
+	t.Style().Format.RowAlign = text.AlignLeft
+	t.SetOutputMirror(os.Stdout)
+
+	header := table.Row{"Start"}
+	if stats.Query.GroupBy == urth.StatsGroupByRunner {
+		header = append(header, "Runner")
+	}
+	header = append(header, "Runs", "Success", "Failed", "P50", "P99")
+	if c.Output == "wide" {
+		header = append(header, "Degraded", "Errored", "Timeout", "Canceled", "Min", "P90", "Max", "Mean", "Width")
+	}
+	t.AppendHeader(header)
+
+	for _, bucket := range stats.Buckets {
+		row := table.Row{bucket.Start.Local().Format(time.DateTime)}
+		if stats.Query.GroupBy == urth.StatsGroupByRunner {
+			row = append(row, bucket.RunnerName)
+		}
+		row = append(row,
+			bucket.Total,
+			bucket.Outcomes[prob.RunFinishedSuccess],
+			bucket.Outcomes[prob.RunFinishedFailed],
+			bucket.Duration.P50.Round(time.Millisecond),
+			bucket.Duration.P99.Round(time.Millisecond),
+		)
+
+		if c.Output == "wide" {
+			row = append(row,
+				bucket.Outcomes[prob.RunFinishedDegraded],
+				bucket.Outcomes[prob.RunFinishedError],
+				bucket.Outcomes[prob.RunFinishedTimeout],
+				bucket.Outcomes[prob.RunFinishedCanceled],
+				bucket.Duration.Min.Round(time.Millisecond),
+				bucket.Duration.P90.Round(time.Millisecond),
+				bucket.Duration.Max.Round(time.Millisecond),
+				bucket.Duration.Mean.Round(time.Millisecond),
+				bucket.Granularity,
+			)
+		}
+
+		t.AppendRow(row)
+	}
+
+	t.Render()
+	return nil
+}
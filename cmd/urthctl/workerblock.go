@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jedib0t/go-pretty/v6/table"
+	"github.com/jedib0t/go-pretty/v6/text"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+
+	"github.com/sre-norns/urth/pkg/urth"
+)
+
+// Worker blocks are declared with `urthctl apply`, like rate limits. Revoking a
+// token is a command of its own, because a token is issued rather than
+// declared: there is no manifest of it to apply.
+
+// WorkerBlocks lists the declared worker blocks.
+type WorkerBlocks struct {
+	Selector string `help:"Selector (label query) to filter on" optional:"" name:"selector" short:"l"`
+	Output   string `help:"Output format" enum:"wide,short" default:"short" name:"output" short:"o"`
+}
+
+func (c *WorkerBlocks) Run(cfg *commandContext) error {
+	apiClient, err := cfg.NewClient()
+	if err != nil {
+		return fmt.Errorf("failed to initialize API Client: %w", err)
+	}
+
+	selector, err := manifest.ParseSelector(c.Selector)
+	if err != nil {
+		return fmt.Errorf("failed to parse labels selector: %w", err)
+	}
+
+	ctx, cancel := cfg.ClientCallContext()
+	defer cancel()
+
+	resources, _, err := apiClient.WorkerBlocks().List(ctx, manifest.SearchQuery{Selector: selector})
+	if err != nil {
+		return err
+	}
+
+	t := table.NewWriter()
+	t.Style().Options = table.OptionsNoBordersAndSeparators
+	t.Style().Format.HeaderAlign = text.AlignLeft
+	t.Style().Format.RowAlign = text.AlignLeft
+
+	t.SetOutputMirror(os.Stdout)
+	header := table.Row{"Name", "Matches", "Value", "Barring"}
+	if c.Output == "wide" {
+		header = append(header, "Workers", "Description")
+	}
+	t.AppendHeader(header)
+
+	for _, resource := range resources {
+		block, err := urth.NewWorkerBlock(resource)
+		if err != nil {
+			return fmt.Errorf("error while parsing worker blocks: %w", err)
+		}
+
+		match, value := blockMatch(block.Spec)
+		row := table.Row{block.Name, match, value, len(block.Status.Workers)}
+		if c.Output == "wide" {
+			row = append(row, orDash(joinNames(block.Status.Workers)), block.Spec.Description)
+		}
+
+		t.AppendRow(row)
+	}
+
+	t.Render()
+	return nil
+}
+
+// blockMatch names what a block matches workers by, and the value it matches.
+func blockMatch(spec urth.WorkerBlockSpec) (string, string) {
+	switch {
+	case spec.WorkerName != "":
+		return "name", string(spec.WorkerName)
+	case spec.Hostname != "":
+		return "hostname", spec.Hostname
+	default:
+		return "fingerprint", spec.Fingerprint
+	}
+}
+
+// RunnerTokens lists the enrolment tokens issued for a runner.
+type RunnerTokens struct {
+	Runner manifest.ResourceName `help:"Name of the runner" arg:"" name:"runner"`
+	Output string                `help:"Output format" enum:"wide,short" default:"short" name:"output" short:"o"`
+}
+
+func (c *RunnerTokens) Run(cfg *commandContext) error {
+	apiClient, err := cfg.NewClient()
+	if err != nil {
+		return fmt.Errorf("failed to initialize API Client: %w", err)
+	}
+
+	ctx, cancel := cfg.ClientCallContext()
+	defer cancel()
+
+	tokens, found, err := apiClient.Runners().Tokens(ctx, c.Runner)
+	if err != nil {
+		return err
+	}
+	if !found {
+		return fmt.Errorf("no runner %q", c.Runner)
+	}
+
+	t := table.NewWriter()
+	t.Style().Options = table.OptionsNoBordersAndSeparators
+	t.Style().Format.HeaderAlign = text.AlignLeft
+	t.Style().Format.RowAlign = text.AlignLeft
+
+	t.SetOutputMirror(os.Stdout)
+	header := table.Row{"Token", "Issued", "Expires", "State"}
+	if c.Output == "wide" {
+		header = append(header, "Fingerprint", "Reason")
+	}
+	t.AppendHeader(header)
+
+	now := time.Now()
+	for _, token := range tokens {
+		row := table.Row{
+			token.Name,
+			token.Spec.IssuedAt.Local().Format(time.DateTime),
+			token.Spec.ExpiresAt.Local().Format(time.DateTime),
+			tokenState(token, now),
+		}
+		if c.Output == "wide" {
+			row = append(row, token.Spec.Fingerprint, orDash(token.Status.Reason))
+		}
+
+		t.AppendRow(row)
+	}
+
+	t.Render()
+	return nil
+}
+
+// tokenState says whether a token can still enrol a worker, and if not why not.
+func tokenState(token urth.EnrolmentToken, now time.Time) string {
+	switch {
+	case token.IsRevoked():
+		return "revoked " + windowTime(token.Status.RevokedAt)
+	case now.After(token.Spec.ExpiresAt):
+		return "expired"
+	default:
+		return "valid"
+	}
+}
+
+// RevokeTokenCmd takes back a runner's enrolment token.
+type RevokeTokenCmd struct {
+	Runner manifest.ResourceName `help:"Name of the runner the token was issued for" arg:"" name:"runner"`
+	Token  manifest.ResourceName `help:"ID of the token to revoke, as listed by get runner-tokens" arg:"" name:"token"`
+
+	Reason string `help:"Why the token is being revoked" name:"reason" optional:""`
+}
+
+func (c *RevokeTokenCmd) Run(cfg *commandContext) error {
+	apiClient, err := cfg.NewClient()
+	if err != nil {
+		return fmt.Errorf("failed to initialize API Client: %w", err)
+	}
+
+	ctx, cancel := cfg.ClientCallContext()
+	defer cancel()
+
+	token, err := apiClient.Runners().RevokeToken(ctx, c.Runner, c.Token, urth.RevokeTokenRequest{Reason: c.Reason})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Revoked token %q of runner %q at %s.\n", token.Name, c.Runner, windowTime(token.Status.RevokedAt))
+
+	return nil
+}
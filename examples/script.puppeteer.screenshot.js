@@ -10,7 +10,9 @@ async function test1(browser, page) {
 (async () => {
   const browser = await puppeteer.launch({
     headless: ("URTH_PUPPETEER_HEADLESS" in process.env) ? (process.env.URTH_PUPPETEER_HEADLESS === 'true') : "new",
-    slowMo: process.env.URTH_PUPPETEER_PAGE_WAIT
+    slowMo: process.env.URTH_PUPPETEER_PAGE_WAIT,
+    // The runner's proxy and CAs, as Chromium flags
+    args: JSON.parse(process.env.URTH_PUPPETEER_ARGS || "[]")
   });
   const page = await browser.newPage();
   const har = new PuppeteerHar(page);
@@ -0,0 +1,111 @@
+package apiserver
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/sre-norns/urth/pkg/urth"
+	"github.com/sre-norns/wyrd/pkg/bark"
+)
+
+const (
+	// auditHeaderLimit bounds what is kept of a header an audit event records.
+	// The headers are the client's to set, and the log is not its to fill.
+	auditHeaderLimit = 256
+
+	// auditExportFlushEvery is how many lines an export writes between
+	// flushes: often enough that a client sees progress, rarely enough that a
+	// large export is not a syscall a line.
+	auditExportFlushEvery = 100
+)
+
+// auditRequest attaches to every request what the audit log records about who
+// sent it. See urth.AuditRequest.
+//
+// The request ID is taken from the proxy in front of the server when there is
+// one, so that an event and the proxy's access log line share it, and made up
+// otherwise. Either way it is returned, so that a client reporting an error can
+// quote it.
+func auditRequest() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		requestID := auditHeader(ctx, urth.HeaderRequestID)
+		if requestID == "" {
+			requestID = string(urth.NewRandToken(16))
+		}
+		ctx.Header(urth.HeaderRequestID, requestID)
+
+		ctx.Request = ctx.Request.WithContext(urth.WithAuditRequest(ctx.Request.Context(), urth.AuditRequest{
+			Actor:        auditHeader(ctx, urth.HeaderAuditActor),
+			SourceIP:     ctx.RemoteIP(),
+			ForwardedFor: auditHeader(ctx, "X-Forwarded-For"),
+			RequestID:    requestID,
+		}))
+
+		ctx.Next()
+	}
+}
+
+func auditHeader(ctx *gin.Context, name string) string {
+	value := ctx.GetHeader(name)
+	if len(value) > auditHeaderLimit {
+		return value[:auditHeaderLimit]
+	}
+
+	return value
+}
+
+// auditExportHandler writes the audit events matching a query as JSON lines,
+// one event manifest per line, oldest first.
+//
+// Served outside bark's content negotiation, for the reason the metrics
+// endpoints are: JSON lines is not one of the types it knows, and it would
+// answer a client asking for them with 406.
+//
+// The status goes out with the first line, so a failure after that cannot
+// change it. It is reported in the urth.HeaderExportError trailer instead, and
+// the client treats an export that ends with one as having failed.
+func auditExportHandler(srv urth.Service) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		ctx.Header("Trailer", urth.HeaderExportError)
+
+		started := false
+		start := func() {
+			if !started {
+				started = true
+				ctx.Header("Content-Type", "application/x-ndjson")
+				ctx.Header(bark.HTTPHeaderCacheControl, "no-store")
+				ctx.Status(http.StatusOK)
+			}
+		}
+
+		encoder := json.NewEncoder(ctx.Writer)
+		lines := 0
+		err := srv.AuditLog().Export(ctx.Request.Context(), bark.RequireSearchQuery(ctx), func(event urth.AuditEvent) error {
+			start()
+			if err := encoder.Encode(event.ToManifest()); err != nil {
+				return err
+			}
+
+			if lines++; lines%auditExportFlushEvery == 0 {
+				ctx.Writer.Flush()
+			}
+			return nil
+		})
+
+		if err != nil && !started {
+			ctx.Writer.Header().Del("Trailer")
+			bark.AbortWithError(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		if err != nil {
+			log.Printf("audit export stopped after %d events: %v", lines, err)
+			ctx.Writer.Header().Set(urth.HeaderExportError, err.Error())
+			return
+		}
+
+		start()
+	}
+}
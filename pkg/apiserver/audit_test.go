@@ -0,0 +1,152 @@
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sre-norns/urth/pkg/urth"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// exportingService serves an audit log that exports the given events and then
+// fails with the given error, if there is one. Nothing else is expected of it.
+type exportingService struct {
+	urth.Service
+
+	events []urth.AuditEvent
+	err    error
+}
+
+func (s exportingService) AuditLog() urth.AuditLogAPI {
+	return exportingAuditLog{events: s.events, err: s.err}
+}
+
+type exportingAuditLog struct {
+	urth.AuditLogAPI
+
+	events []urth.AuditEvent
+	err    error
+}
+
+func (l exportingAuditLog) Export(_ context.Context, _ manifest.SearchQuery, visit func(urth.AuditEvent) error) error {
+	for _, event := range l.events {
+		if err := visit(event); err != nil {
+			return err
+		}
+	}
+
+	return l.err
+}
+
+func auditEventNamed(name manifest.ResourceName, action urth.AuditAction) urth.AuditEvent {
+	return urth.AuditEvent{
+		ObjectMeta: manifest.ObjectMeta{Name: name},
+		Spec:       urth.AuditEventSpec{Action: action},
+	}
+}
+
+func export(t *testing.T, srv urth.Service) *httptest.ResponseRecorder {
+	t.Helper()
+
+	request := httptest.NewRequest(http.MethodGet, "/api/v1/audit/export", nil)
+	request.Header.Set("Accept", "application/x-ndjson")
+
+	recorder := httptest.NewRecorder()
+	Routes(srv, nil, nil, nil).ServeHTTP(recorder, request)
+
+	return recorder
+}
+
+// The export is JSON lines, which bark's content negotiation does not know and
+// would refuse with 406, so the route is served outside it.
+func TestAuditExportIsJSONLines(t *testing.T) {
+	recorder := export(t, exportingService{events: []urth.AuditEvent{
+		auditEventNamed("first", urth.AuditActionPause),
+		auditEventNamed("second", urth.AuditActionResume),
+	}})
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("export returned %d, want 200:\n%s", recorder.Code, recorder.Body)
+	}
+	if contentType := recorder.Header().Get("Content-Type"); contentType != "application/x-ndjson" {
+		t.Errorf("export is served as %q", contentType)
+	}
+
+	lines := strings.Split(strings.TrimSpace(recorder.Body.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("export wrote %d lines, want one per event:\n%s", len(lines), recorder.Body)
+	}
+
+	var first manifest.ResourceManifest
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("a line is not a manifest: %v", err)
+	}
+	if first.Metadata.Name != "first" {
+		t.Errorf("the first line is %q, want the first event", first.Metadata.Name)
+	}
+
+	if trailer := recorder.Result().Trailer.Get(urth.HeaderExportError); trailer != "" {
+		t.Errorf("a complete export reported an error: %q", trailer)
+	}
+}
+
+// Once the first line is out so is the status, and a failure after it can only
+// be reported in the trailer. Without it, a client would keep a truncated
+// export as though it were the whole log.
+func TestAuditExportReportsALateFailureInTheTrailer(t *testing.T) {
+	recorder := export(t, exportingService{
+		events: []urth.AuditEvent{auditEventNamed("first", urth.AuditActionPause)},
+		err:    errors.New("connection reset"),
+	})
+
+	if trailer := recorder.Result().Trailer.Get(urth.HeaderExportError); !strings.Contains(trailer, "connection reset") {
+		t.Errorf("a failed export ended with trailer %q", trailer)
+	}
+}
+
+// A failure before anything is written is still an ordinary error response.
+func TestAuditExportReportsAnEarlyFailureAsAnError(t *testing.T) {
+	recorder := export(t, exportingService{err: errors.New("no database")})
+
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("a failed export returned %d, want 500", recorder.Code)
+	}
+}
+
+// An empty log exports as an empty body, not as an error.
+func TestAuditExportOfNothingSucceeds(t *testing.T) {
+	recorder := export(t, exportingService{})
+
+	if recorder.Code != http.StatusOK {
+		t.Errorf("an empty export returned %d, want 200", recorder.Code)
+	}
+	if recorder.Body.Len() != 0 {
+		t.Errorf("an empty export wrote:\n%s", recorder.Body)
+	}
+}
+
+// Every response carries the request ID its audit events are recorded under:
+// the proxy's when it sent one, made up otherwise.
+func TestRequestIDIsEchoed(t *testing.T) {
+	router := Routes(nil, nil, nil, nil)
+
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/api/v1/version", nil))
+	if recorder.Header().Get(urth.HeaderRequestID) == "" {
+		t.Error("a request without an ID was not given one")
+	}
+
+	request := httptest.NewRequest(http.MethodGet, "/api/v1/version", nil)
+	request.Header.Set(urth.HeaderRequestID, "from-the-proxy")
+
+	recorder = httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+	if id := recorder.Header().Get(urth.HeaderRequestID); id != "from-the-proxy" {
+		t.Errorf("the proxy's request ID came back as %q", id)
+	}
+}
@@ -36,6 +36,11 @@ func TestClaimHTTPResponse(t *testing.T) {
 			err:      &urth.ClaimError{Disposition: urth.ClaimForbidden},
 			wantCode: http.StatusForbidden,
 		},
+		{
+			name:     "throttled run is deferred, not refused",
+			err:      &urth.ClaimError{Disposition: urth.ClaimThrottled},
+			wantCode: http.StatusTooManyRequests,
+		},
 		{
 			name:     "wrapped claim error keeps its disposition",
 			err:      fmt.Errorf("through a layer: %w", &urth.ClaimError{Disposition: urth.ClaimObsolete}),
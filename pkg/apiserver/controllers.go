@@ -0,0 +1,273 @@
+package apiserver
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"gorm.io/gorm"
+
+	"github.com/sre-norns/urth/pkg/controllers"
+	"github.com/sre-norns/urth/pkg/natsq"
+	"github.com/sre-norns/urth/pkg/redqueue"
+	"github.com/sre-norns/urth/pkg/urth"
+	"github.com/sre-norns/wyrd/pkg/dbstore"
+)
+
+// The control loops need the transport as much as the API does: the relay
+// publishes through it, the reconciler repairs its queues, and the advisory and
+// presence watchers listen on its connection. ADR 0006 §6 makes moving the loops
+// out of the api-server a deployment change rather than a redesign, and that
+// holds only while both hosts reach the transport, and compose the loops over
+// it, through the same code. Both are here.
+
+// TransportConfig is how a process reaches the job queue.
+type TransportConfig struct {
+	NATS natsq.Config `embed:"" prefix:"nats."`
+
+	// Transport selects the job queue. Both implementations are kept while the
+	// migration in ADR 0004 proceeds, so an operator can cut over and back
+	// without changing binaries.
+	Transport string `help:"Job transport to use: nats or asynq" enum:"nats,asynq" default:"asynq"`
+
+	MessageBrokerURL string `help:"Message broker address:port to connect to (asynq transport)" default:"localhost:6379"`
+}
+
+// transport is a connected job queue and what the loops take from it.
+type transport struct {
+	scheduler urth.Scheduler
+
+	// publisher is what the relay hands committed outbox entries to. The two
+	// transports reach it differently: NATS publishes a dispatch envelope
+	// straight from the entry, while asynq needs the whole job and so goes
+	// through an adapter that reloads the Result. Both share one durability
+	// story, which is the point -- retiring asynq in task 015 deletes the
+	// adapter rather than a second way of dispatching.
+	publisher urth.DispatchPublisher
+
+	// channels is the transport's half of reconciliation: restoring a runner's
+	// queue and withdrawing a dispatch nothing will claim. It stays nil for the
+	// legacy transport, which has neither notion -- that is the honest answer,
+	// not a degraded mode, and the reconciler skips those passes rather than
+	// pretending it repaired something.
+	channels urth.RunnerChannelReconciler
+
+	// nats is the NATS transport, nil on asynq.
+	nats natsq.Transport
+
+	// conn carries run-log streaming, presence, and advisories. Nil on the
+	// asynq transport.
+	conn *nats.Conn
+
+	// presence records the NATS half of worker liveness. Nil for a transport
+	// with no broker to be present on, in which case workers report that
+	// signal as `unknown` -- the honest answer, and the one that keeps the
+	// reconciler from evicting them.
+	presence *natsq.PresenceWatcher
+}
+
+// openTransport connects the configured transport. queues is what the NATS
+// transport routes high-priority runs by; see urth.HighPriorityQueueSupport.
+func openTransport(ctx context.Context, cfg TransportConfig, presence urth.WorkerPresenceStore, queues urth.HighPriorityQueueSupport) (*transport, error) {
+	if cfg.Transport != TransportNATS {
+		scheduler, err := redqueue.NewScheduler(ctx, cfg.MessageBrokerURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create a scheduler: %w", err)
+		}
+
+		return &transport{scheduler: scheduler}, nil
+	}
+
+	natsScheduler, err := natsq.NewScheduler(ctx, cfg.NATS, natsq.WithHighPriorityQueueSupport(queues))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+
+	// A separate connection for log tailing, so a browser holding a slow
+	// stream open cannot interfere with job publication.
+	conn, err := cfg.NATS.Connect("urth-api-server-logs")
+	if err != nil {
+		_ = natsScheduler.Close()
+		return nil, fmt.Errorf("failed to connect to NATS for run log streaming: %w", err)
+	}
+
+	return &transport{
+		scheduler: natsScheduler,
+		publisher: natsScheduler,
+		channels:  natsScheduler,
+		nats:      natsScheduler,
+		conn:      conn,
+		// Worker presence shares that connection. It is a handful of empty
+		// messages a minute per worker, and the traffic it competes with is a
+		// browser tailing a run.
+		presence: natsq.NewPresenceWatcher(conn, presence),
+	}, nil
+}
+
+// dispatchPublisher is the publisher the relay uses when nobody decorated it.
+func (t *transport) dispatchPublisher(store *dbstore.DBStore) urth.DispatchPublisher {
+	if t.publisher != nil {
+		return t.publisher
+	}
+
+	return urth.NewSchedulerDispatchPublisher(t.scheduler, urth.NewStoreResultLoader(store))
+}
+
+// loopsConfig is what composing the loops takes from a host's configuration.
+type loopsConfig struct {
+	Controllers        controllers.Config
+	MaxJobAge          time.Duration
+	WorkerRetention    time.Duration
+	WorkerOfflineAfter time.Duration
+	RemoteWrite        urth.RemoteWriteConfig
+}
+
+// registerLoops composes every control loop over a transport and adds the
+// chosen ones to a manager.
+func registerLoops(manager *controllers.Manager, cfg loopsConfig, db *gorm.DB, store *dbstore.DBStore, t *transport, publisher urth.DispatchPublisher) (controllers.Dispatch, *urth.RemoteWriter, error) {
+	deps := controllers.Dependencies{
+		DB:        db,
+		Store:     store,
+		Publisher: publisher,
+		Channels:  t.channels,
+		MaxJobAge: cfg.MaxJobAge,
+
+		WorkerRetention:    cfg.WorkerRetention,
+		WorkerOfflineAfter: cfg.WorkerOfflineAfter,
+		// Reuses the log-streaming connection rather than opening a third: an
+		// advisory subscription is idle almost all the time, and the traffic it
+		// competes with is a browser tailing a run.
+		Advisories: controllers.AdvisoryWatcherFor(t.conn, urth.NewAdvisoryRecorder(db, store)),
+	}
+	if t.presence != nil {
+		deps.Presence = t.presence
+	}
+
+	var writer *urth.RemoteWriter
+	if cfg.RemoteWrite.Enabled() {
+		var err error
+		if writer, err = remoteWriter(db, cfg.RemoteWrite); err != nil {
+			return controllers.Dispatch{}, nil, err
+		}
+		deps.RemoteWriter = writer
+	}
+
+	dispatch, err := controllers.Register(manager, cfg.Controllers, deps)
+	if err != nil {
+		return dispatch, nil, fmt.Errorf("failed to compose the control loops: %w", err)
+	}
+
+	return dispatch, writer, nil
+}
+
+// ControllerManagerConfig is everything an operator can set about a
+// controller-manager. The flags it shares with the api-server have the same
+// names and defaults there.
+type ControllerManagerConfig struct {
+	dbstore.Config `help:"Persistent storage URL" embed:"" prefix:"store."`
+
+	TransportConfig `embed:""`
+
+	WorkerRetention time.Duration `name:"worker.retention" help:"How long a worker silent on every signal is kept before its registration is dropped" default:"24h"`
+
+	// WorkerOfflineAfter is the API server's flag of the same name. The manager
+	// has no heartbeat interval to derive it from, so a server that sets either
+	// needs it set here to match.
+	WorkerOfflineAfter time.Duration `name:"worker.offline-after" help:"How long a liveness signal may go unheard before it counts as offline. Zero is three default heartbeat intervals" default:"0"`
+
+	RemoteWrite urth.RemoteWriteConfig `embed:"" prefix:"remote-write."`
+
+	Controllers controllers.Config `embed:""`
+
+	LeaderElection controllers.LeaderElectionConfig `embed:"" prefix:"leader-election."`
+
+	// StatusAddress serves what the manager knows about each loop. Unlike a
+	// worker's, this process sits with the rest of the control plane, where a
+	// port is expected.
+	StatusAddress string `help:"Address to serve loop status and health on. Empty serves none" default:":8081"`
+}
+
+// ControllerManager hosts the control loops apart from any api-server.
+type ControllerManager struct {
+	// Loops supervises everything Start runs.
+	Loops *controllers.Manager
+
+	// Dispatch holds the composed loops, as on Server.
+	Dispatch controllers.Dispatch
+
+	transport *transport
+}
+
+// NewControllerManager composes the control loops over an already-open
+// database.
+func NewControllerManager(ctx context.Context, db *gorm.DB, cfg ControllerManagerConfig) (*ControllerManager, error) {
+	store, err := dbstore.NewDBStore(db, dbstore.ManifestModel)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the resource store: %w", err)
+	}
+
+	presence := urth.NewWorkerPresenceStore(db)
+
+	t, err := openTransport(ctx, cfg.TransportConfig, presence, urth.NewHighPriorityQueueSupport(store, cfg.WorkerOfflineAfter))
+	if err != nil {
+		return nil, err
+	}
+
+	var options []controllers.ManagerOption
+	if cfg.LeaderElection.Enabled {
+		identity := cfg.LeaderElection.Identity
+		if identity == "" {
+			host, _ := os.Hostname()
+			identity = fmt.Sprintf("%s-%s", host, urth.NewRandToken(6))
+		}
+
+		options = append(options, controllers.WithLeaderElection(urth.NewLeaseStore(db), identity, cfg.LeaderElection.Lease))
+		log.Printf("electing loops through the database as %q", identity)
+	}
+
+	manager := &ControllerManager{
+		Loops:     controllers.NewManager(options...),
+		transport: t,
+	}
+
+	manager.Dispatch, _, err = registerLoops(manager.Loops, loopsConfig{
+		Controllers:        cfg.Controllers,
+		MaxJobAge:          cfg.NATS.MaxJobAge,
+		WorkerRetention:    cfg.WorkerRetention,
+		WorkerOfflineAfter: cfg.WorkerOfflineAfter,
+		RemoteWrite:        cfg.RemoteWrite,
+	}, db, store, t, t.dispatchPublisher(store))
+	if err != nil {
+		_ = manager.Close()
+		return nil, err
+	}
+
+	return manager, nil
+}
+
+// Close releases the transport connections. The database is the caller's.
+func (m *ControllerManager) Close() error {
+	return m.transport.Close()
+}
+
+// Close releases the transport's connections.
+func (t *transport) Close() error {
+	if t.conn != nil {
+		// Drain rather than Close: an advisory subscription may be mid-callback,
+		// and dropping it loses a dead letter nobody else can report.
+		_ = t.conn.Drain()
+		t.conn = nil
+	}
+
+	if t.scheduler != nil {
+		err := t.scheduler.Close()
+		t.scheduler = nil
+
+		return err
+	}
+
+	return nil
+}
@@ -32,6 +32,12 @@ var kindMap = map[string]manifest.Kind{
 	// letters, so the one resource an operator most often filters by reason or
 	// runner would be the one kind they could not enumerate labels for.
 	string(urth.KindDispatchFailure): urth.KindDispatchFailure,
+
+	string(urth.KindMaintenanceWindow): urth.KindMaintenanceWindow,
+	string(urth.KindRateLimit):         urth.KindRateLimit,
+	string(urth.KindEnrolmentToken):    urth.KindEnrolmentToken,
+	string(urth.KindWorkerBlock):       urth.KindWorkerBlock,
+	string(urth.KindAuditEvent):        urth.KindAuditEvent,
 }
 
 type KindRequest struct {
@@ -70,6 +76,7 @@ var (
 	errClaimUnavailable = &bark.ErrorResponse{Code: http.StatusServiceUnavailable, Message: "claim temporarily unavailable"}
 	errClaimObsolete    = &bark.ErrorResponse{Code: http.StatusConflict, Message: "run is not claimable"}
 	errClaimForbidden   = &bark.ErrorResponse{Code: http.StatusForbidden, Message: "claim refused"}
+	errClaimThrottled   = &bark.ErrorResponse{Code: http.StatusTooManyRequests, Message: "claim deferred"}
 )
 
 // claimHTTPResponse maps a claim outcome to the generic response the worker sees.
@@ -84,6 +91,11 @@ func claimHTTPResponse(err error) *bark.ErrorResponse {
 		return errClaimObsolete
 	case urth.ClaimForbidden:
 		return errClaimForbidden
+	case urth.ClaimThrottled:
+		// 429 rather than 503: the server is healthy and has decided, so a
+		// worker that knows the status backs off for longer, and one that does
+		// not still reads a status it retries.
+		return errClaimThrottled
 	default:
 		return errClaimUnavailable
 	}
@@ -157,6 +169,26 @@ func probScript(spec any) (string, bool) {
 	return field.String(), true
 }
 
+// enrolmentTokenManifests renders a runner's tokens as a resource list.
+func enrolmentTokenManifests(tokens []urth.EnrolmentToken) []manifest.ResourceManifest {
+	manifests := make([]manifest.ResourceManifest, 0, len(tokens))
+	for _, token := range tokens {
+		manifests = append(manifests, token.ToManifest())
+	}
+
+	return manifests
+}
+
+// auditEventManifests renders audit events as a resource list.
+func auditEventManifests(events []urth.AuditEvent) []manifest.ResourceManifest {
+	manifests := make([]manifest.ResourceManifest, 0, len(events))
+	for _, event := range events {
+		manifests = append(manifests, event.ToManifest())
+	}
+
+	return manifests
+}
+
 // dispatchFailureManifests renders a listing in the shape every other resource
 // list uses.
 func dispatchFailureManifests(failures []urth.DispatchFailure) []manifest.ResourceManifest {
@@ -169,12 +201,19 @@ func dispatchFailureManifests(failures []urth.DispatchFailure) []manifest.Resour
 }
 
 // statusForResourceError maps an operator action's failure to a status.
+//
+// A version conflict is the resource having moved on underneath the action --
+// a run that finished before it could be cancelled, a failure retried by
+// somebody else first -- which the operator can read again and act on, not a
+// fault of the server's.
 func statusForResourceError(err error) int {
 	switch {
 	case errors.Is(err, bark.ErrResourceNotFound):
 		return http.StatusNotFound
 	case errors.Is(err, bark.ErrForbidden):
 		return http.StatusForbidden
+	case errors.Is(err, bark.ErrResourceVersionConflict):
+		return http.StatusConflict
 	default:
 		return http.StatusInternalServerError
 	}
@@ -186,9 +225,12 @@ func statusForResourceError(err error) int {
 // it: every claim disposition this system depends on is expressed as an HTTP
 // status, and a test that never builds a route is asserting the mapping it
 // assumed rather than the one that ships. See test/integration.
-func Routes(srv urth.Service, natsConn *nats.Conn, metrics *prometheus.Registry) *gin.Engine {
+func Routes(srv urth.Service, natsConn *nats.Conn, metrics, probeMetrics *prometheus.Registry) *gin.Engine {
 	router := gin.Default()
 	router.UseRawPath = true
+	// Before any route, so that every one of them carries what the audit log
+	// records about its caller.
+	router.Use(auditRequest())
 
 	// Deliberately outside the /api/v1 group. Prometheus asks for a text exposition
 	// format, and bark's content negotiation on that group answers anything it does
@@ -203,6 +245,13 @@ func Routes(srv urth.Service, natsConn *nats.Conn, metrics *prometheus.Registry)
 			ErrorHandling: promhttp.ContinueOnError,
 		})))
 	}
+	// The latest result of every scenario, shaped like blackbox_exporter's, and
+	// outside /api/v1 for the same reason.
+	if probeMetrics != nil {
+		router.GET("/metrics/probes", gin.WrapH(promhttp.HandlerFor(probeMetrics, promhttp.HandlerOpts{
+			ErrorHandling: promhttp.ContinueOnError,
+		})))
+	}
 
 	// Simple group: v1
 	v1 := router.Group("/api/v1", bark.ContentTypeAPI())
@@ -327,7 +376,9 @@ func Routes(srv urth.Service, natsConn *nats.Conn, metrics *prometheus.Registry)
 			bark.Ok(ctx, resource)
 		})
 
-		// Request a JWT token to be used by workers to Auth as a Runner instance
+		// Request a JWT token to be used by workers to Auth as a Runner instance.
+		// A GET for the clients that already call it so, but not a pure read:
+		// each call issues, and records, a new token.
 		v1.GET("/auth/runners/:id" /*bark.AuthBearerAPI(),*/, bark.ResourceAPI(), func(ctx *gin.Context) {
 			ctx.Header(bark.HTTPHeaderCacheControl, "no-store")
 
@@ -396,6 +447,60 @@ func Routes(srv urth.Service, natsConn *nats.Conn, metrics *prometheus.Registry)
 		v1.DELETE("/runners/:id", bark.ResourceAPI(), bark.VersionedResourceAPI(), func(ctx *gin.Context) {
 			bark.Manifest(ctx).Deleted(srv.Runners().Delete(ctx.Request.Context(), bark.RequireVersionedResource(ctx)))
 		})
+		// Drain every worker of a runner, including any that register while the
+		// drain is in force.
+		v1.PUT("/runners/:id/drain", bark.ResourceAPI(), func(ctx *gin.Context) {
+			var request urth.SetDrainingRequest
+			if err := ctx.ShouldBindJSON(&request); err != nil {
+				bark.AbortWithError(ctx, http.StatusBadRequest, err)
+				return
+			}
+
+			bark.Manifest(ctx).Found(
+				srv.Runners().SetDraining(ctx.Request.Context(), bark.RequireResourceName(ctx), request.IsDraining),
+			)
+		})
+		// The enrolment tokens issued for a runner, and taking one back. A
+		// token is never deleted, only revoked: the record is how a worker
+		// registered with it is traced.
+		v1.GET("/runners/:id/tokens", bark.ResourceAPI(), func(ctx *gin.Context) {
+			tokens, found, err := srv.Runners().Tokens(ctx.Request.Context(), bark.RequireResourceName(ctx))
+			if err != nil {
+				bark.AbortWithError(ctx, statusForResourceError(err), err)
+				return
+			}
+			if !found {
+				bark.AbortWithError(ctx, http.StatusNotFound, bark.ErrResourceNotFound)
+				return
+			}
+
+			bark.Manifest(ctx).List(enrolmentTokenManifests(tokens), int64(len(tokens)), nil)
+		})
+		v1.POST("/runners/:id/tokens/:token/revoke", func(ctx *gin.Context) {
+			var resourceRequest urth.RunnerTokenRequest
+			if err := ctx.ShouldBindUri(&resourceRequest); err != nil {
+				bark.AbortWithError(ctx, http.StatusNotFound, err)
+				return
+			}
+
+			var request urth.RevokeTokenRequest
+			// The reason is optional, so an empty body is a revocation without
+			// one, as with a retry's defaults.
+			if ctx.Request.ContentLength > 0 {
+				if err := ctx.ShouldBind(&request); err != nil {
+					bark.AbortWithError(ctx, http.StatusBadRequest, err)
+					return
+				}
+			}
+
+			token, err := srv.Runners().RevokeToken(ctx.Request.Context(), manifest.ResourceName(resourceRequest.ID), resourceRequest.TokenID, request)
+			if err != nil {
+				bark.AbortWithError(ctx, statusForResourceError(err), err)
+				return
+			}
+
+			bark.Ok(ctx, token.ToManifest())
+		})
 		// The kinds of prob a scenario may declare. Read by clients offering a
 		// choice, so that the list comes from the server rather than being
 		// duplicated and drifting.
@@ -518,11 +623,91 @@ func Routes(srv urth.Service, natsConn *nats.Conn, metrics *prometheus.Registry)
 				srv.Workers().SetPaused(ctx.Request.Context(), bark.RequireResourceName(ctx), request.IsPaused),
 			)
 		})
+		// Drain a single worker: it finishes its in-flight runs and leaves.
+		v1.PUT("/workers/:id/drain", bark.ResourceAPI(), func(ctx *gin.Context) {
+			var request urth.SetDrainingRequest
+			if err := ctx.ShouldBindJSON(&request); err != nil {
+				bark.AbortWithError(ctx, http.StatusBadRequest, err)
+				return
+			}
+
+			bark.Manifest(ctx).Found(
+				srv.Workers().SetDraining(ctx.Request.Context(), bark.RequireResourceName(ctx), request.IsDraining),
+			)
+		})
 		// Revoke a worker's registration.
 		v1.DELETE("/workers/:id", bark.ResourceAPI(), bark.VersionedResourceAPI(), func(ctx *gin.Context) {
 			bark.Manifest(ctx).Deleted(srv.Workers().Delete(ctx.Request.Context(), bark.RequireVersionedResource(ctx)))
 		})
 		//------------
+		// Maintenance windows API
+		//------------
+		v1.GET("/maintenance-windows", bark.SearchableAPI(paginationLimit), func(ctx *gin.Context) {
+			bark.Manifest(ctx).List(srv.MaintenanceWindows().List(ctx.Request.Context(), bark.RequireSearchQuery(ctx)))
+		})
+		v1.POST("/maintenance-windows", bark.ManifestAPI(urth.KindMaintenanceWindow), func(ctx *gin.Context) {
+			bark.Manifest(ctx).Created(srv.MaintenanceWindows().Create(ctx.Request.Context(), bark.RequireManifest(ctx)))
+		})
+		v1.GET("/maintenance-windows/:id", bark.ResourceAPI(), func(ctx *gin.Context) {
+			bark.Manifest(ctx).Found(srv.MaintenanceWindows().Get(ctx.Request.Context(), bark.RequireResourceName(ctx)))
+		})
+		// Create or update, which is what `urthctl apply` sends.
+		v1.PUT("/maintenance-windows/:id", bark.ResourceAPI(), bark.ManifestAPI(urth.KindMaintenanceWindow), func(ctx *gin.Context) {
+			bark.Manifest(ctx).CreatedOrUpdated(srv.MaintenanceWindows().CreateOrUpdate(ctx.Request.Context(), bark.RequireManifest(ctx)))
+		})
+		v1.DELETE("/maintenance-windows/:id", bark.ResourceAPI(), bark.VersionedResourceAPI(), func(ctx *gin.Context) {
+			bark.Manifest(ctx).Deleted(srv.MaintenanceWindows().Delete(ctx.Request.Context(), bark.RequireVersionedResource(ctx)))
+		})
+		//------------
+		// Rate limits API
+		//------------
+		v1.GET("/rate-limits", bark.SearchableAPI(paginationLimit), func(ctx *gin.Context) {
+			bark.Manifest(ctx).List(srv.RateLimits().List(ctx.Request.Context(), bark.RequireSearchQuery(ctx)))
+		})
+		v1.POST("/rate-limits", bark.ManifestAPI(urth.KindRateLimit), func(ctx *gin.Context) {
+			bark.Manifest(ctx).Created(srv.RateLimits().Create(ctx.Request.Context(), bark.RequireManifest(ctx)))
+		})
+		v1.GET("/rate-limits/:id", bark.ResourceAPI(), func(ctx *gin.Context) {
+			bark.Manifest(ctx).Found(srv.RateLimits().Get(ctx.Request.Context(), bark.RequireResourceName(ctx)))
+		})
+		v1.PUT("/rate-limits/:id", bark.ResourceAPI(), bark.ManifestAPI(urth.KindRateLimit), func(ctx *gin.Context) {
+			bark.Manifest(ctx).CreatedOrUpdated(srv.RateLimits().CreateOrUpdate(ctx.Request.Context(), bark.RequireManifest(ctx)))
+		})
+		v1.DELETE("/rate-limits/:id", bark.ResourceAPI(), bark.VersionedResourceAPI(), func(ctx *gin.Context) {
+			bark.Manifest(ctx).Deleted(srv.RateLimits().Delete(ctx.Request.Context(), bark.RequireVersionedResource(ctx)))
+		})
+		//------------
+		// Audit log API
+		//------------
+		// Read-only: the service writes events as changes are made, and there
+		// is no route that changes one.
+		v1.GET("/audit", bark.SearchableAPI(paginationLimit), func(ctx *gin.Context) {
+			events, total, err := srv.AuditLog().List(ctx.Request.Context(), bark.RequireSearchQuery(ctx))
+			bark.Manifest(ctx).List(auditEventManifests(events), total, err)
+		})
+		v1.GET("/audit/:id", bark.ResourceAPI(), func(ctx *gin.Context) {
+			event, found, err := srv.AuditLog().Get(ctx.Request.Context(), bark.RequireResourceName(ctx))
+			bark.Manifest(ctx).Found(event.ToManifest(), found, err)
+		})
+		//------------
+		// Worker blocks API
+		//------------
+		v1.GET("/worker-blocks", bark.SearchableAPI(paginationLimit), func(ctx *gin.Context) {
+			bark.Manifest(ctx).List(srv.WorkerBlocks().List(ctx.Request.Context(), bark.RequireSearchQuery(ctx)))
+		})
+		v1.POST("/worker-blocks", bark.ManifestAPI(urth.KindWorkerBlock), func(ctx *gin.Context) {
+			bark.Manifest(ctx).Created(srv.WorkerBlocks().Create(ctx.Request.Context(), bark.RequireManifest(ctx)))
+		})
+		v1.GET("/worker-blocks/:id", bark.ResourceAPI(), func(ctx *gin.Context) {
+			bark.Manifest(ctx).Found(srv.WorkerBlocks().Get(ctx.Request.Context(), bark.RequireResourceName(ctx)))
+		})
+		v1.PUT("/worker-blocks/:id", bark.ResourceAPI(), bark.ManifestAPI(urth.KindWorkerBlock), func(ctx *gin.Context) {
+			bark.Manifest(ctx).CreatedOrUpdated(srv.WorkerBlocks().CreateOrUpdate(ctx.Request.Context(), bark.RequireManifest(ctx)))
+		})
+		v1.DELETE("/worker-blocks/:id", bark.ResourceAPI(), bark.VersionedResourceAPI(), func(ctx *gin.Context) {
+			bark.Manifest(ctx).Deleted(srv.WorkerBlocks().Delete(ctx.Request.Context(), bark.RequireVersionedResource(ctx)))
+		})
+		//------------
 		// Scenarios API
 		//------------
 		v1.GET("/scenarios", bark.SearchableAPI(paginationLimit), func(ctx *gin.Context) {
@@ -552,6 +737,45 @@ func Routes(srv urth.Service, natsConn *nats.Conn, metrics *prometheus.Registry)
 			bark.MaybeGotOne(ctx, preview, exists, err)
 		})
 
+		// Outcome counts and duration percentiles over a window of the
+		// scenario's history, from rollups and live runs alike.
+		v1.GET("/scenarios/:id/stats", bark.ResourceAPI(), func(ctx *gin.Context) {
+			var query urth.ResultStatsQuery
+			if err := ctx.ShouldBindQuery(&query); err != nil {
+				bark.AbortWithError(ctx, http.StatusBadRequest, err)
+				return
+			}
+
+			stats, exists, err := srv.Scenarios().Stats(ctx.Request.Context(), bark.RequireResourceName(ctx), query)
+			if errors.Is(err, urth.ErrInvalidStatsQuery) {
+				bark.AbortWithError(ctx, http.StatusBadRequest, err)
+				return
+			}
+			bark.MaybeGotOne(ctx, stats, exists, err)
+		})
+		// Availability, error budget and incidents, against the scenario's SLO.
+		v1.GET("/scenarios/:id/availability", bark.ResourceAPI(), func(ctx *gin.Context) {
+			var query urth.AvailabilityQuery
+			if err := ctx.ShouldBindQuery(&query); err != nil {
+				bark.AbortWithError(ctx, http.StatusBadRequest, err)
+				return
+			}
+
+			report, exists, err := srv.Scenarios().Availability(ctx.Request.Context(), bark.RequireResourceName(ctx), query)
+			if errors.Is(err, urth.ErrInvalidStatsQuery) {
+				bark.AbortWithError(ctx, http.StatusBadRequest, err)
+				return
+			}
+			bark.MaybeGotOne(ctx, report, exists, err)
+		})
+
+		// Every scenario's dependencies, resolved, and who depends on each.
+		// Outside /scenarios/:id because the graph belongs to no one scenario.
+		v1.GET("/scenario-dependencies", func(ctx *gin.Context) {
+			graph, err := srv.Scenarios().Dependencies(ctx.Request.Context())
+			bark.MaybeGotOne(ctx, graph, true, err)
+		})
+
 		v1.GET("/scenarios/:id/script", bark.ResourceAPI(), func(ctx *gin.Context) {
 			resource, exists, err := srv.Scenarios().Get(ctx.Request.Context(), bark.RequireResourceName(ctx))
 			if err != nil {
@@ -602,6 +826,30 @@ func Routes(srv urth.Service, natsConn *nats.Conn, metrics *prometheus.Registry)
 		// Live run log, falling back to the stored artifact once the run has
 		// finished, so one URL serves a run whether or not it is still going.
 		v1.GET("/scenarios/:id/results/:runId/logs", runLogHandler(srv, natsConn))
+		// Stop a run. Answered with the run as it now stands: `completed` and
+		// `canceled` if nothing had claimed it, `cancelling` if a worker is
+		// executing it and has been asked to stop.
+		v1.POST("/scenarios/:id/results/:runId/cancel", func(ctx *gin.Context) {
+			var resourceRequest urth.ScenarioRunResultsRequest
+			if err := ctx.ShouldBindUri(&resourceRequest); err != nil {
+				bark.AbortWithError(ctx, http.StatusNotFound, err)
+				return
+			}
+
+			var request urth.CancelRunRequest
+			if err := ctx.ShouldBindJSON(&request); err != nil {
+				bark.AbortWithError(ctx, http.StatusBadRequest, err)
+				return
+			}
+
+			result, err := srv.Results(manifest.ResourceName(resourceRequest.ID)).Cancel(ctx.Request.Context(), resourceRequest.RunID, request)
+			if err != nil {
+				bark.AbortWithError(ctx, statusForResourceError(err), err)
+				return
+			}
+
+			bark.Ok(ctx, result.ToManifest())
+		})
 		v1.PUT("/scenarios/:id/results/:runId/status", bark.AuthBearerAPI(), bark.VersionedResourceAPI(), func(ctx *gin.Context) {
 			var resourceRequest urth.ScenarioRunResultsRequest
 			if err := ctx.ShouldBindUri(&resourceRequest); err != nil {
@@ -665,5 +913,12 @@ func Routes(srv urth.Service, natsConn *nats.Conn, metrics *prometheus.Registry)
 		})
 	}
 
+	// The same prefix without bark's content negotiation, for responses in a
+	// type it does not know. See auditExportHandler.
+	stream := router.Group("/api/v1")
+	{
+		stream.GET("/audit/export", bark.SearchableAPI(paginationLimit), auditExportHandler(srv))
+	}
+
 	return router
 }
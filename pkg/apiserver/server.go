@@ -18,6 +18,7 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"os"
 	"time"
 
 	"github.com/nats-io/nats.go"
@@ -27,7 +28,6 @@ import (
 
 	"github.com/sre-norns/urth/pkg/controllers"
 	"github.com/sre-norns/urth/pkg/natsq"
-	"github.com/sre-norns/urth/pkg/redqueue"
 	"github.com/sre-norns/urth/pkg/urth"
 	"github.com/sre-norns/wyrd/pkg/dbstore"
 
@@ -68,21 +68,21 @@ const (
 type Config struct {
 	dbstore.Config `help:"Persistent storage URL" embed:"" prefix:"store."`
 
-	// Named rather than embedded: both of these are called Config, and
-	// embedding a second one collides with dbstore's.
+	// Named rather than embedded: it is called Config, and embedding a second
+	// one collides with dbstore's.
 	Signing urth.SigningKeysConfig `embed:"" prefix:"signing."`
-	NATS    natsq.Config           `embed:"" prefix:"nats."`
 
-	// Transport selects the job queue. Both implementations are kept while the
-	// migration in ADR 0004 proceeds, so an operator can cut over and back
-	// without changing binaries.
-	Transport string `help:"Job transport to use: nats or asynq" enum:"nats,asynq" default:"asynq"`
-
-	MessageBrokerURL string `help:"Message broker address:port to connect to (asynq transport)" default:"localhost:6379"`
+	// Shared with the controller-manager, which reaches the queue the same
+	// way. See controllers.go.
+	TransportConfig `embed:""`
 
 	SessionTTL     time.Duration `help:"How long an issued worker session remains valid" default:"1h"`
 	MaxRunDuration time.Duration `help:"Maximum time a worker may hold a run capability" default:"30m"`
 
+	// LateResultGrace is how long a worker may keep delivering a report it
+	// spooled through an outage. It extends every run capability by as much.
+	LateResultGrace time.Duration `help:"How long past its upload grace a run still accepts a report a worker spooled; 0 accepts none" default:"1h"`
+
 	// Worker liveness. The interval is what the server asks workers to report
 	// at; the timeout is derived from it unless set, so the two cannot be
 	// configured into contradicting each other.
@@ -90,10 +90,29 @@ type Config struct {
 	WorkerOfflineAfter      time.Duration `name:"worker.offline-after" help:"How long a liveness signal may go unheard before it counts as offline. Zero derives it from the heartbeat interval" default:"0"`
 	WorkerRetention         time.Duration `name:"worker.retention" help:"How long a worker silent on every signal is kept before its registration is dropped" default:"24h"`
 
+	// ProbeMetrics is the blackbox_exporter-shaped view of the latest results,
+	// served apart from the process's own /metrics. See urth.ProbeCollector.
+	ProbeMetrics urth.ProbeMetricsConfig `embed:"" prefix:"probe-metrics."`
+
+	// RemoteWrite pushes every finished run's metrics to a Prometheus receiver,
+	// for the runs a scrape of /metrics/probes would miss. See urth.RemoteWriter.
+	RemoteWrite urth.RemoteWriteConfig `embed:"" prefix:"remote-write."`
+
+	// Latency labels successful runs that were anomalously slow for their
+	// scenario and runner. See urth.LatencyDetector.
+	Latency urth.LatencyConfig `embed:"" prefix:"latency."`
+
 	// Control loops are configured by the package that composes them, so that a
 	// command hosting them elsewhere offers the same flags rather than a second
 	// set that drifted. See ADR 0006.
 	Controllers controllers.Config `embed:""`
+
+	// ExternalControllers turns every loop off at once, for a deployment whose
+	// loops run in a controller-manager. The server still writes what the loops
+	// consume -- outbox rows, remote-write entries -- and nothing here reads it
+	// back. Phrased as the exception so that a Config built by hand, as a test
+	// builds one, runs its loops as it always did.
+	ExternalControllers bool `help:"Run no control loops in this process, leaving them to a controller-manager"`
 }
 
 // Models are the tables an API server needs migrated before it can serve.
@@ -111,6 +130,15 @@ func Models() []any {
 		&urth.Result{},
 		&urth.Artifact{},
 		&urth.DispatchFailure{},
+		&urth.MaintenanceWindow{},
+		&urth.RateLimit{},
+		&urth.EnrolmentToken{},
+		&urth.WorkerBlock{},
+		&urth.AuditEvent{},
+		// Migrated whether or not pushing is on, so that turning it on is a flag
+		// rather than a migration.
+		&urth.RemoteWriteEntry{},
+		&urth.LatencyBaseline{},
 	}, controllers.Models()...)
 }
 
@@ -164,6 +192,18 @@ type Server struct {
 	// Metrics is the registry the /metrics route serves.
 	Metrics *prometheus.Registry
 
+	// ProbeMetrics is the registry the /metrics/probes route serves. Nil when
+	// the endpoint is disabled.
+	ProbeMetrics *prometheus.Registry
+
+	// RemoteWriter pushes finished runs to a Prometheus receiver. Nil when no
+	// receiver is configured.
+	RemoteWriter *urth.RemoteWriter
+
+	// Keyring holds the token signing keys. Start has it read them again on a
+	// timer and on SIGHUP when any is read from a file.
+	Keyring *urth.Keyring
+
 	// Transport is the scheduler side of the chosen transport. Nil is not
 	// possible: composition fails rather than producing a server that cannot
 	// dispatch.
@@ -192,7 +232,7 @@ func New(ctx context.Context, db *gorm.DB, cfg Config, options ...Option) (*Serv
 		return nil, fmt.Errorf("failed to open the resource store: %w", err)
 	}
 
-	keys, err := cfg.Signing.Build()
+	keyring, err := cfg.Signing.Keyring()
 	if err != nil {
 		return nil, fmt.Errorf("failed to prepare token signing keys: %w", err)
 	}
@@ -208,90 +248,73 @@ func New(ctx context.Context, db *gorm.DB, cfg Config, options ...Option) (*Serv
 	placementMetrics := urth.NewPlacementMetrics()
 
 	serviceOptions := []urth.ServiceOption{
-		urth.WithSigningKeys(keys),
+		urth.WithKeyring(keyring),
 		urth.WithSessionTTL(cfg.SessionTTL),
 		urth.WithMaxRunDuration(cfg.MaxRunDuration),
+		urth.WithLateResultGrace(cfg.LateResultGrace),
 		urth.WithWorkerPresence(presence),
 		urth.WithWorkerHeartbeatInterval(cfg.WorkerHeartbeatInterval),
 		urth.WithWorkerOfflineAfter(cfg.WorkerOfflineAfter),
 
+		// Every change made through the API is recorded in the audit log.
+		urth.WithAuditLog(),
+
 		// Placement reads how much work each runner already holds straight from
 		// the results table -- see urth.RunnerLoadStore for why this needs no
 		// broker round trip.
 		urth.WithRunnerLoad(urth.NewRunnerLoadStore(db)),
 		urth.WithPlacementCounter(placementMetrics),
+
+		// Rate limits are measured against the same table, for the same reason.
+		urth.WithRunRates(urth.NewRunRateStore(db)),
+
+		// Stats read rollups the retention sweep wrote alongside the runs it has
+		// not reached yet, from one snapshot.
+		urth.WithResultStats(urth.NewResultStatsStore(db)),
+
+		// Cancelling a run nobody has claimed retires its outbox entries at
+		// once rather than leaving them to the next reconciler scan.
+		urth.WithRunDispatches(urth.NewRunDispatchStore(db)),
 	}
 
 	server := &Server{
-		Store: store,
-		DB:    db,
-		cfg:   cfg,
+		Store:   store,
+		DB:      db,
+		Keyring: keyring,
+		cfg:     cfg,
 	}
 
-	// publisher is what the relay hands committed outbox entries to. The two
-	// transports reach it differently: NATS publishes a dispatch envelope
-	// straight from the entry, while asynq needs the whole job and so goes
-	// through an adapter that reloads the Result. Both share one durability
-	// story, which is the point -- retiring asynq in task 015 deletes the
-	// adapter rather than a second way of dispatching.
-	var publisher urth.DispatchPublisher
-
-	// channels is the transport's half of reconciliation: restoring a runner's
-	// queue and withdrawing a dispatch nothing will claim. It stays nil for the
-	// legacy transport, which has neither notion -- that is the honest answer,
-	// not a degraded mode, and the reconciler skips those passes rather than
-	// pretending it repaired something.
-	var channels urth.RunnerChannelReconciler
-
-	// presenceWatcher records the NATS half of worker liveness. Nil for a
-	// transport with no broker to be present on, in which case workers report
-	// that signal as `unknown` -- the honest answer, and the one that keeps the
-	// reconciler from evicting them.
-	var presenceWatcher *natsq.PresenceWatcher
-
-	switch cfg.Transport {
-	case TransportNATS:
-		natsScheduler, nerr := natsq.NewScheduler(ctx, cfg.NATS)
-		if nerr != nil {
-			return nil, fmt.Errorf("failed to connect to NATS: %w", nerr)
-		}
-		server.scheduler = natsScheduler
-		publisher = natsScheduler
-		channels = natsScheduler
+	// Derived as the service derives it, so the transport and the fleet view
+	// agree on which workers are still there.
+	offlineAfter := cfg.WorkerOfflineAfter
+	if offlineAfter <= 0 && cfg.WorkerHeartbeatInterval > 0 {
+		offlineAfter = cfg.WorkerHeartbeatInterval * urth.DefaultWorkerOfflineAfterFactor
+	}
 
+	transport, err := openTransport(ctx, cfg.TransportConfig, presence, urth.NewHighPriorityQueueSupport(store, offlineAfter))
+	if err != nil {
+		return nil, err
+	}
+	server.scheduler = transport.scheduler
+	server.natsConn = transport.conn
+
+	if transport.nats != nil {
 		// The NATS scheduler doubles as the transport provider: it already owns
 		// the JetStream handle and the naming, so having it answer "where does
 		// this runner collect work" keeps one component responsible for the
 		// topology.
 		serviceOptions = append(serviceOptions,
-			urth.WithWorkerTransport(natsScheduler),
+			urth.WithWorkerTransport(transport.nats),
 			// The same handle answers "who is waiting at this runner's queue",
 			// which is the fleet-level cross-check on per-worker presence.
-			urth.WithRunnerChannelObserver(natsScheduler),
+			urth.WithRunnerChannelObserver(transport.nats),
+			// And it reaches the workers: a cancelled run is signalled to the
+			// one executing it, and a queued one withdrawn from its stream.
+			urth.WithRunCanceller(transport.nats),
 		)
-
-		// A separate connection for log tailing, so a browser holding a slow
-		// stream open cannot interfere with job publication.
-		conn, cerr := cfg.NATS.Connect("urth-api-server-logs")
-		if cerr != nil {
-			_ = server.scheduler.Close()
-			return nil, fmt.Errorf("failed to connect to NATS for run log streaming: %w", cerr)
-		}
-		server.natsConn = conn
-
-		// Worker presence shares that connection. It is a handful of empty
-		// messages a minute per worker, and the traffic it competes with is a
-		// browser tailing a run.
-		presenceWatcher = natsq.NewPresenceWatcher(conn, presence)
-	default:
-		scheduler, serr := redqueue.NewScheduler(ctx, cfg.MessageBrokerURL)
-		if serr != nil {
-			return nil, fmt.Errorf("failed to create a scheduler: %w", serr)
-		}
-		server.scheduler = scheduler
-		publisher = urth.NewSchedulerDispatchPublisher(scheduler, urth.NewStoreResultLoader(store))
 	}
 
+	publisher := transport.dispatchPublisher(store)
 	if opts.decoratePublisher != nil {
 		publisher = opts.decoratePublisher(publisher)
 	}
@@ -307,38 +330,45 @@ func New(ctx context.Context, db *gorm.DB, cfg Config, options ...Option) (*Serv
 	// Results because NATS is unwell is strictly worse than one that keeps
 	// recording them for the relay to publish when NATS returns.
 	server.Loops = controllers.NewManager()
-	server.Dispatch, err = controllers.Register(server.Loops, cfg.Controllers, controllers.Dependencies{
-		DB:        db,
-		Store:     store,
-		Publisher: publisher,
-		Channels:  channels,
-		MaxJobAge: cfg.NATS.MaxJobAge,
-
-		WorkerRetention: cfg.WorkerRetention,
-		// Reuses the log-streaming connection rather than opening a third: an
-		// advisory subscription is idle almost all the time, and the traffic it
-		// competes with is a browser tailing a run.
-		Advisories: controllers.AdvisoryWatcherFor(server.natsConn, urth.NewAdvisoryRecorder(db, store)),
-	})
-	if err != nil {
-		_ = server.Close()
-		return nil, fmt.Errorf("failed to compose the control loops: %w", err)
-	}
-
-	// Added directly rather than through controllers.Register: that package
-	// composes the *dispatch* loops, and worker liveness is not one of them. It
-	// still wants the manager's supervision, so a panic recording presence
-	// restarts the watcher instead of taking the API server down.
-	if presenceWatcher != nil {
-		if err := server.Loops.Add("worker-presence", presenceWatcher); err != nil {
+	if !cfg.ExternalControllers {
+		server.Dispatch, server.RemoteWriter, err = registerLoops(server.Loops, loopsConfig{
+			Controllers:        cfg.Controllers,
+			MaxJobAge:          cfg.NATS.MaxJobAge,
+			WorkerRetention:    cfg.WorkerRetention,
+			WorkerOfflineAfter: offlineAfter,
+			RemoteWrite:        cfg.RemoteWrite,
+		}, db, store, transport, publisher)
+		if err != nil {
 			_ = server.Close()
-			return nil, fmt.Errorf("failed to register the worker presence watcher: %w", err)
+			return nil, err
 		}
 	}
 
+	// Queued whether or not this process pushes them: a controller-manager
+	// elsewhere may be the one that does.
+	if cfg.RemoteWrite.Enabled() {
+		serviceOptions = append(serviceOptions, urth.WithRemoteWriteQueue())
+	}
+
+	if cfg.Latency.Enabled {
+		serviceOptions = append(serviceOptions, urth.WithLatencyDetector(urth.NewLatencyDetector(urth.NewLatencyBaselineStore(db),
+			urth.WithLatencyAlpha(cfg.Latency.Alpha),
+			urth.WithLatencyWindow(cfg.Latency.Window),
+			urth.WithLatencyDeviations(cfg.Latency.Deviations),
+			urth.WithLatencyPercentile(cfg.Latency.Percentile),
+			urth.WithLatencyMinSamples(cfg.Latency.MinSamples),
+		)))
+	}
+
 	server.Service = urth.NewService(store, server.scheduler, serviceOptions...)
 	server.Metrics = metricsRegistry(db, server.scheduler, placementMetrics)
-	server.Router = Routes(server.Service, server.natsConn, server.Metrics)
+	if server.RemoteWriter != nil {
+		server.Metrics.MustRegister(server.RemoteWriter.Collector())
+	}
+	if cfg.ProbeMetrics.Enabled {
+		server.ProbeMetrics = probeMetricsRegistry(db, cfg.ProbeMetrics)
+	}
+	server.Router = Routes(server.Service, server.natsConn, server.Metrics, server.ProbeMetrics)
 
 	return server, nil
 }
@@ -349,6 +379,13 @@ func New(ctx context.Context, db *gorm.DB, cfg Config, options ...Option) (*Serv
 func (s *Server) Start(ctx context.Context) {
 	s.Loops.Start(ctx)
 
+	// Not a supervised loop: it has nothing to repair, and a reload that fails
+	// keeps the keys already held. See urth.Keyring.
+	if s.cfg.Signing.Reloadable() {
+		go s.Keyring.Watch(ctx, s.cfg.Signing.ReloadInterval)
+		log.Printf("signing keys: %s", s.Keyring)
+	}
+
 	if names := s.Loops.Names(); len(names) > 0 {
 		log.Printf("control loops running in this process: %v", names)
 	} else {
@@ -419,3 +456,44 @@ func metricsRegistry(db *gorm.DB, scheduler urth.Scheduler, placement *urth.Plac
 
 	return registry
 }
+
+// probeMetricsRegistry assembles what the probes themselves report.
+//
+// A registry and an endpoint apart from /metrics, as blackbox_exporter keeps
+// /probe apart from its own: the two are scraped by different jobs, at
+// different intervals, and usually into different dashboards. Keeping them
+// apart also means a scenario count in the thousands cannot slow down the
+// scrape that says whether the api-server itself is healthy.
+func probeMetricsRegistry(db *gorm.DB, cfg urth.ProbeMetricsConfig) *prometheus.Registry {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(urth.NewProbeCollector(urth.NewProbeTargetStore(db),
+		urth.WithProbeLabels(cfg.Labels...),
+		urth.WithMaxProbeTargets(cfg.MaxTargets),
+		urth.WithMaxProbeArtifactSamples(cfg.MaxArtifactSamples),
+	))
+
+	return registry
+}
+
+// remoteWriter builds the loop that pushes finished runs to a receiver.
+func remoteWriter(db *gorm.DB, cfg urth.RemoteWriteConfig) (*urth.RemoteWriter, error) {
+	clientOptions := []urth.RemoteWriteClientOption{}
+	if cfg.BearerTokenFile != "" {
+		// Read once at startup. A rotated token takes a restart, which is also
+		// when every other credential this process holds is picked up.
+		token, err := os.ReadFile(cfg.BearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the remote write bearer token: %w", err)
+		}
+		clientOptions = append(clientOptions, urth.WithRemoteWriteBearerToken(string(token)))
+	}
+
+	return urth.NewRemoteWriter(urth.NewRemoteWriteQueue(db), urth.NewRemoteWriteClient(cfg.URL, clientOptions...),
+		urth.WithRemoteWriteLabels(cfg.Labels...),
+		urth.WithRemoteWriteMaxSamples(cfg.MaxSamples),
+		urth.WithRemoteWriteBatchSize(cfg.BatchSize),
+		urth.WithRemoteWritePollInterval(cfg.PollInterval),
+		urth.WithRemoteWriteTimeout(cfg.Timeout),
+		urth.WithRemoteWriteMetricsGrace(cfg.MetricsGrace),
+	), nil
+}
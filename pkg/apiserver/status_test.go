@@ -0,0 +1,83 @@
+package apiserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sre-norns/urth/pkg/urth"
+	"github.com/sre-norns/wyrd/pkg/bark"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// conflictingService fails every operator action it is asked for with a
+// version conflict.
+type conflictingService struct {
+	urth.Service
+}
+
+var errConflict = fmt.Errorf("%w: changed underneath", bark.ErrResourceVersionConflict)
+
+func (conflictingService) Results(manifest.ResourceName) urth.RunResultAPI {
+	return conflictingResults{}
+}
+
+func (conflictingService) DispatchFailures() urth.DispatchFailuresAPI {
+	return conflictingDispatchFailures{}
+}
+
+type conflictingResults struct {
+	urth.RunResultAPI
+}
+
+func (conflictingResults) Cancel(context.Context, manifest.ResourceName, urth.CancelRunRequest) (urth.Result, error) {
+	return urth.Result{}, errConflict
+}
+
+type conflictingDispatchFailures struct {
+	urth.DispatchFailuresAPI
+}
+
+func (conflictingDispatchFailures) Retry(context.Context, manifest.ResourceName, urth.RetryDispatchFailureRequest) (urth.DispatchFailure, urth.Result, error) {
+	return urth.DispatchFailure{}, urth.Result{}, errConflict
+}
+
+func (conflictingDispatchFailures) Resolve(context.Context, manifest.ResourceName) (urth.DispatchFailure, error) {
+	return urth.DispatchFailure{}, errConflict
+}
+
+func post(t *testing.T, srv urth.Service, path, body string) int {
+	t.Helper()
+
+	request := httptest.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+
+	recorder := httptest.NewRecorder()
+	Routes(srv, nil, nil, nil).ServeHTTP(recorder, request)
+
+	return recorder.Code
+}
+
+// Cancelling a run that has already finished is a conflict the operator can
+// act on, and says so.
+func TestCancellingAFinishedRunIsAConflict(t *testing.T) {
+	if code := post(t, conflictingService{}, "/api/v1/scenarios/checkout/results/run-1/cancel", `{"requestedBy": "alice"}`); code != http.StatusConflict {
+		t.Errorf("cancelling a finished run returned %d, want 409", code)
+	}
+}
+
+// The other operator actions answer a conflict the same way: the resource moved
+// on, and the operator can read it again and retry.
+func TestOtherActionsAnswerAConflictWith409(t *testing.T) {
+	for _, path := range []string{
+		"/api/v1/dispatch-failures/failure-1/retry",
+		"/api/v1/dispatch-failures/failure-1/resolve",
+	} {
+		if code := post(t, conflictingService{}, path, ""); code != http.StatusConflict {
+			t.Errorf("%s returned %d on a conflict, want 409", path, code)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+// Package cliconfig reads the YAML configuration files of Urth's binaries.
+//
+// Every binary was configured by flags and environment variables alone, which
+// is fine for a container and tiresome everywhere else: an operator running
+// urthctl against three API servers retyped the address and token each time,
+// and a worker on a VM kept its labels in a systemd unit. A file is where those
+// belong.
+//
+// A binary reads, from lowest precedence to highest:
+//
+//   - /etc/urth/<app>.yaml
+//   - <dir>/urth/<app>.yaml for each directory in $XDG_CONFIG_DIRS (/etc/xdg
+//     when unset), the first directory listed winning
+//   - $XDG_CONFIG_HOME/urth/<app>.yaml (~/.config when unset)
+//
+// A missing file is skipped. The files are merged key by key into one
+// document, whose keys are flag names -- `api-server-address: ...` -- either
+// written out in full or nested at their dots, so `sandbox: {user: probes}` is
+// `--sandbox.user`. Whatever is set on the command line or in the environment
+// still wins over every file: flags, then environment, then files, then the
+// flag's default.
+//
+// A document may also hold named contexts, as urthctl uses them: a map of
+// settings under `contexts`, of which the one named by `current-context`, or
+// by a --context flag, applies over the rest of the document.
+package cliconfig
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/alecthomas/kong"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// ContextsKey holds a document's named contexts.
+	ContextsKey = "contexts"
+
+	// CurrentContextKey names the context that applies when no --context flag
+	// is given.
+	CurrentContextKey = "current-context"
+
+	// ContextFlag is the flag a binary declares to choose a context for one
+	// invocation.
+	ContextFlag = "context"
+)
+
+// systemDir is read before anything XDG names, as the place a package or a
+// configuration-management tool puts a host's settings.
+const systemDir = "/etc/urth"
+
+// Document is a merged configuration.
+type Document map[string]any
+
+// Files lists the files app reads, lowest precedence first. Not all of them
+// need exist.
+func Files(app string) []string {
+	name := app + ".yaml"
+
+	files := []string{filepath.Join(systemDir, name)}
+
+	dirs := filepath.SplitList(os.Getenv("XDG_CONFIG_DIRS"))
+	if len(dirs) == 0 {
+		dirs = []string{"/etc/xdg"}
+	}
+	// Listed most important first, read least important first.
+	for _, dir := range slices.Backward(dirs) {
+		if filepath.IsAbs(dir) {
+			files = append(files, filepath.Join(dir, "urth", name))
+		}
+	}
+
+	if user, err := UserFile(app); err == nil {
+		files = append(files, user)
+	}
+
+	return files
+}
+
+// UserFile is the file that holds app's per-user settings, and the one a
+// command that changes them writes to.
+func UserFile(app string) (string, error) {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" || !filepath.IsAbs(dir) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("cannot locate the user's configuration directory: %w", err)
+		}
+		dir = filepath.Join(home, ".config")
+	}
+
+	return filepath.Join(dir, "urth", app+".yaml"), nil
+}
+
+// Load reads and merges the given files, skipping those that do not exist.
+func Load(files ...string) (Document, error) {
+	merged := Document{}
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		document, err := parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		merge(merged, document)
+	}
+
+	return merged, nil
+}
+
+func parse(data []byte) (Document, error) {
+	// Into a plain map: yaml.v3 gives nested maps the type of the outermost,
+	// and the lookups expect map[string]any all the way down.
+	document := map[string]any{}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, err
+	}
+
+	return document, nil
+}
+
+// merge copies over into into, key by key: a nested map is merged, anything
+// else replaced.
+func merge(into, over map[string]any) {
+	for key, value := range over {
+		existing, isMap := into[key].(map[string]any)
+		overriding, overridesMap := value.(map[string]any)
+		if isMap && overridesMap {
+			merged := maps.Clone(existing)
+			merge(merged, overriding)
+			into[key] = merged
+			continue
+		}
+
+		into[key] = value
+	}
+}
+
+// Option configures a kong parser to read app's files, and to load a file given
+// to a kong.ConfigFlag the same way.
+func Option(app string) kong.Option {
+	return kong.OptionFunc(func(k *kong.Kong) error {
+		document, err := Load(Files(app)...)
+		if err != nil {
+			return err
+		}
+
+		if err := kong.Configuration(Loader).Apply(k); err != nil {
+			return err
+		}
+
+		return kong.Resolvers(document).Apply(k)
+	})
+}
+
+// Loader is a kong.ConfigurationLoader for YAML.
+func Loader(r io.Reader) (kong.Resolver, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return parse(data)
+}
+
+// Contexts returns the names of the document's contexts, sorted.
+func (d Document) Contexts() []string {
+	contexts, _ := d[ContextsKey].(map[string]any)
+
+	return slices.Sorted(maps.Keys(contexts))
+}
+
+// CurrentContext is the context the document selects, if any.
+func (d Document) CurrentContext() string {
+	current, _ := d[CurrentContextKey].(string)
+	return current
+}
+
+func (d Document) context(name string) (map[string]any, bool) {
+	contexts, _ := d[ContextsKey].(map[string]any)
+	context, ok := contexts[name].(map[string]any)
+
+	return context, ok
+}
+
+// Validate is part of kong.Resolver. Keys that name no flag are allowed: one
+// file may serve several versions of a binary.
+func (d Document) Validate(*kong.Application) error {
+	return nil
+}
+
+// Resolve is part of kong.Resolver.
+func (d Document) Resolve(kctx *kong.Context, _ *kong.Path, flag *kong.Flag) (any, error) {
+	// The environment beats a file. kong would apply a file over an
+	// environment variable, because it has already read the variable into the
+	// flag by the time resolvers are asked; saying nothing here undoes that.
+	for _, env := range flag.Envs {
+		if _, set := os.LookupEnv(env); set {
+			return nil, nil
+		}
+	}
+
+	if name, chosen := d.selectedContext(kctx); name != "" && flag.Name != ContextFlag {
+		context, ok := d.context(name)
+		// A context asked for by name must exist. A stale current-context
+		// is passed over instead: failing on it would fail `config
+		// use-context` too, the command that mends it.
+		if !ok && chosen {
+			return nil, fmt.Errorf("context %q is not defined; the contexts are %s", name, strings.Join(d.Contexts(), ", "))
+		}
+		if value, ok := lookup(context, flag.Name); ok {
+			return value, nil
+		}
+	}
+
+	value, _ := lookup(d, flag.Name)
+	return value, nil
+}
+
+// selectedContext is the --context flag's value where the binary has one and it
+// was given, and the document's current context otherwise. chosen reports
+// which.
+func (d Document) selectedContext(kctx *kong.Context) (name string, chosen bool) {
+	for _, flag := range kctx.Flags() {
+		if flag.Name != ContextFlag {
+			continue
+		}
+		if name, _ := kctx.FlagValue(flag).(string); name != "" {
+			return name, true
+		}
+	}
+
+	return d.CurrentContext(), false
+}
+
+// lookup finds a flag's value by its name, or by the name split at its dots
+// into nested maps. Each part may be written with dashes or underscores.
+func lookup(values map[string]any, name string) (any, bool) {
+	if value, ok := lookupKey(values, name); ok {
+		return value, true
+	}
+
+	parts := strings.Split(name, ".")
+	if len(parts) == 1 {
+		return nil, false
+	}
+
+	var current any = values
+	for _, part := range parts {
+		nested, ok := current.(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		if current, ok = lookupKey(nested, part); !ok {
+			return nil, false
+		}
+	}
+
+	return current, true
+}
+
+func lookupKey(values map[string]any, key string) (any, bool) {
+	if value, ok := values[key]; ok {
+		return value, true
+	}
+
+	value, ok := values[strings.ReplaceAll(key, "-", "_")]
+	return value, ok
+}
+
+// SetCurrentContext records name as the current context in file, creating the
+// file if it does not exist. Everything else in the file, comments included,
+// is left as it was.
+func SetCurrentContext(file, name string) error {
+	var root yaml.Node
+
+	data, err := os.ReadFile(file)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		if err := yaml.Unmarshal(data, &root); err != nil {
+			return fmt.Errorf("%s: %w", file, err)
+		}
+	}
+
+	if root.Kind == 0 {
+		root = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	if root.Kind != yaml.DocumentNode || len(root.Content) != 1 || root.Content[0].Kind != yaml.MappingNode {
+		return fmt.Errorf("%s: not a YAML mapping", file)
+	}
+
+	mapping := root.Content[0]
+	value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name}
+
+	replaced := false
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == CurrentContextKey {
+			mapping.Content[i+1] = value
+			replaced = true
+		}
+	}
+	if !replaced {
+		mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: CurrentContextKey}, value)
+	}
+
+	// Indented as people write YAML, rather than yaml.v3's default of four.
+	var out bytes.Buffer
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&root); err != nil {
+		return err
+	}
+
+	// Owner-only: the file a context lives in is the file its token lives in.
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return err
+	}
+
+	return os.WriteFile(file, out.Bytes(), 0o600)
+}
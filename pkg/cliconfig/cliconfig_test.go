@@ -0,0 +1,189 @@
+package cliconfig
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/alecthomas/kong"
+	"github.com/stretchr/testify/require"
+)
+
+type testCli struct {
+	Context string        `help:"Context to use" env:"TEST_URTH_CONTEXT"`
+	Address string        `name:"api-server-address" env:"TEST_URTH_ADDRESS" default:"http://localhost:8080"`
+	Format  string        `default:"yml"`
+	Timeout time.Duration `default:"1m"`
+	Labels  map[string]string
+	Sandbox struct {
+		User string
+	} `embed:"" prefix:"sandbox."`
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+
+	file := filepath.Join(dir, name)
+	require.NoError(t, os.MkdirAll(filepath.Dir(file), 0o700))
+	require.NoError(t, os.WriteFile(file, []byte(content), 0o600))
+
+	return file
+}
+
+func parseCli(t *testing.T, document Document, args ...string) (testCli, error) {
+	t.Helper()
+
+	var cli testCli
+	parser, err := kong.New(&cli, kong.Resolvers(document))
+	require.NoError(t, err)
+
+	_, err = parser.Parse(args)
+	return cli, err
+}
+
+// Later files win key by key, nested maps merging rather than replacing.
+func TestLoadMergesFilesInOrder(t *testing.T) {
+	dir := t.TempDir()
+
+	system := writeFile(t, dir, "system.yaml", `
+api-server-address: http://urth.corp
+format: json
+sandbox:
+  user: probes
+`)
+	user := writeFile(t, dir, "user.yaml", `
+format: yaml
+sandbox:
+  group: probes
+`)
+
+	document, err := Load(system, filepath.Join(dir, "missing.yaml"), user)
+	require.NoError(t, err)
+
+	require.Equal(t, "http://urth.corp", document["api-server-address"])
+	require.Equal(t, "yaml", document["format"])
+	require.Equal(t, map[string]any{"user": "probes", "group": "probes"}, document["sandbox"])
+}
+
+func TestFilesFollowXDG(t *testing.T) {
+	t.Setenv("XDG_CONFIG_DIRS", "/opt/first:relative:/opt/second")
+	t.Setenv("XDG_CONFIG_HOME", "/home/probe/.config")
+
+	require.Equal(t, []string{
+		"/etc/urth/urthctl.yaml",
+		"/opt/second/urth/urthctl.yaml",
+		"/opt/first/urth/urthctl.yaml",
+		"/home/probe/.config/urth/urthctl.yaml",
+	}, Files("urthctl"))
+
+	t.Setenv("XDG_CONFIG_DIRS", "")
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("HOME", "/home/probe")
+
+	require.Equal(t, []string{
+		"/etc/urth/urthctl.yaml",
+		"/etc/xdg/urth/urthctl.yaml",
+		"/home/probe/.config/urth/urthctl.yaml",
+	}, Files("urthctl"))
+}
+
+// Flags beat the environment, which beats the file, which beats defaults.
+func TestPrecedence(t *testing.T) {
+	document := Document{
+		"api-server-address": "http://from-file",
+		"timeout":            "30s",
+		"labels":             map[string]any{"region": "eu"},
+		"sandbox":            map[string]any{"user": "probes"},
+	}
+
+	cli, err := parseCli(t, document)
+	require.NoError(t, err)
+	require.Equal(t, "http://from-file", cli.Address)
+	require.Equal(t, 30*time.Second, cli.Timeout)
+	require.Equal(t, "yml", cli.Format, "a key the file does not set keeps its default")
+	require.Equal(t, map[string]string{"region": "eu"}, cli.Labels)
+	require.Equal(t, "probes", cli.Sandbox.User, "a dotted flag is found nested")
+
+	t.Setenv("TEST_URTH_ADDRESS", "http://from-env")
+	cli, err = parseCli(t, document)
+	require.NoError(t, err)
+	require.Equal(t, "http://from-env", cli.Address)
+
+	cli, err = parseCli(t, document, "--api-server-address=http://from-flag")
+	require.NoError(t, err)
+	require.Equal(t, "http://from-flag", cli.Address)
+}
+
+func TestContexts(t *testing.T) {
+	document := Document{
+		"format":          "json",
+		"current-context": "staging",
+		"contexts": map[string]any{
+			"staging":    map[string]any{"api-server-address": "http://staging"},
+			"production": map[string]any{"api-server-address": "http://production", "format": "yaml"},
+		},
+	}
+	require.Equal(t, []string{"production", "staging"}, document.Contexts())
+
+	cli, err := parseCli(t, document)
+	require.NoError(t, err)
+	require.Equal(t, "http://staging", cli.Address)
+	require.Equal(t, "json", cli.Format, "what a context leaves out comes from the rest of the document")
+
+	cli, err = parseCli(t, document, "--context=production")
+	require.NoError(t, err)
+	require.Equal(t, "http://production", cli.Address)
+	require.Equal(t, "yaml", cli.Format)
+
+	t.Setenv("TEST_URTH_CONTEXT", "production")
+	cli, err = parseCli(t, document)
+	require.NoError(t, err)
+	require.Equal(t, "http://production", cli.Address)
+
+	_, err = parseCli(t, document, "--context=qa")
+	require.ErrorContains(t, err, `context "qa" is not defined`)
+}
+
+// A current-context naming a removed context does not stop the binary, or
+// `config use-context` could not mend it.
+func TestStaleCurrentContextIsPassedOver(t *testing.T) {
+	document := Document{
+		"api-server-address": "http://default",
+		"current-context":    "removed",
+	}
+
+	cli, err := parseCli(t, document)
+	require.NoError(t, err)
+	require.Equal(t, "http://default", cli.Address)
+}
+
+func TestSetCurrentContextKeepsTheRestOfTheFile(t *testing.T) {
+	dir := t.TempDir()
+
+	file := writeFile(t, dir, "urthctl.yaml", `# Shared by the on-call rota.
+current-context: staging
+contexts:
+  staging:
+    api-server-address: http://staging # behind the VPN
+`)
+
+	require.NoError(t, SetCurrentContext(file, "production"))
+
+	data, err := os.ReadFile(file)
+	require.NoError(t, err)
+	require.Contains(t, string(data), "# Shared by the on-call rota.")
+	require.Contains(t, string(data), "# behind the VPN")
+
+	document, err := Load(file)
+	require.NoError(t, err)
+	require.Equal(t, "production", document.CurrentContext())
+	require.Equal(t, []string{"staging"}, document.Contexts())
+
+	created := filepath.Join(dir, "new", "urth", "urthctl.yaml")
+	require.NoError(t, SetCurrentContext(created, "staging"))
+
+	document, err = Load(created)
+	require.NoError(t, err)
+	require.Equal(t, "staging", document.CurrentContext())
+}
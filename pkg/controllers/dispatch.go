@@ -1,6 +1,9 @@
 package controllers
 
 import (
+	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/nats-io/nats.go"
@@ -10,6 +13,28 @@ import (
 	"gorm.io/gorm"
 )
 
+// The loops Register can compose, by the names they run under.
+const (
+	LoopDispatchRelay      = "dispatch-relay"
+	LoopDispatchReconciler = "dispatch-reconciler"
+	LoopResultRetention    = "result-retention"
+	LoopDispatchAdvisories = "dispatch-advisories"
+	LoopWorkerPresence     = "worker-presence"
+	LoopRemoteWrite        = "remote-write"
+)
+
+// LoopNames lists every loop Register can compose.
+func LoopNames() []string {
+	return []string{
+		LoopDispatchRelay,
+		LoopDispatchReconciler,
+		LoopResultRetention,
+		LoopDispatchAdvisories,
+		LoopWorkerPresence,
+		LoopRemoteWrite,
+	}
+}
+
 // Config selects and tunes the dispatch control loops.
 //
 // It carries kong tags so that every command hosting these loops offers the same
@@ -17,6 +42,12 @@ import (
 // who moves the loops out of the api-server should not find that the knobs
 // changed names on the way.
 type Config struct {
+	// Controllers chooses, by name, which loops this process runs: the way to
+	// split them between processes without a flag per loop per command. The
+	// per-loop Enabled flags still apply on top, so an existing deployment
+	// that disabled one keeps it disabled.
+	Controllers []string `help:"Loops to run in this process: * for all, a name to add one, -name to leave one out, e.g. *,-result-retention" default:"*"`
+
 	// Relay settings. The relay runs inside every api-server replica by default:
 	// ADR 0004 allows it as a separate process, but a deployment where nobody
 	// remembered to start one is a deployment where every run sits pending, so
@@ -42,11 +73,27 @@ type Config struct {
 	// margin is deliberately generous.
 	PendingDispatchGrace time.Duration `help:"How long past the transport's job expiry a pending run waits before it is expired" default:"30m"`
 
+	// OrphanChannelGrace is how old a runner queue whose runner does not exist
+	// must be before the reconciler takes it down. Zero turns the sweep off; a
+	// deleted runner's own queue is still removed when the runner is.
+	OrphanChannelGrace time.Duration `help:"How old a queue with no runner must be before it is removed. Zero keeps them" default:"15m"`
+
 	// Advisory watcher settings. The broker abandoning a message is the one
 	// dead-letter category no worker can report -- by the time the transport
 	// gives up, the workers that failed to claim it have long since moved on.
 	AdvisoriesEnabled bool `help:"Record dispatches the broker has stopped redelivering" default:"true" negatable:""`
 
+	// Retention settings. The sweep is registered by default but deletes
+	// nothing until ResultRetention is set: rolling a run up throws its
+	// artifacts away, and that is an operator's decision, not an upgrade's. With
+	// it registered, hourly rollups are still compacted, which only ever touches
+	// rows the sweep itself wrote.
+	RetentionEnabled      bool          `help:"Run the result retention sweep in this process" default:"true" negatable:""`
+	RetentionInterval     time.Duration `help:"How often the retention sweep looks for history to roll up" default:"15m"`
+	RetentionBatchSize    int           `help:"How many runs one retention transaction rolls up and deletes" default:"500"`
+	ResultRetention       time.Duration `help:"How long finished runs are kept in full before being rolled up; 0 keeps them forever" default:"0s"`
+	HourlyRollupRetention time.Duration `help:"How long hourly rollups are kept before being compacted into daily ones" default:"2160h"`
+
 	// ShutdownTimeout bounds how long a command waits for its loops to stop.
 	ShutdownTimeout time.Duration `help:"How long to wait for control loops to stop during shutdown" default:"15s"`
 }
@@ -87,9 +134,22 @@ type Dependencies struct {
 	// offline timeout are the numbers this has to be comfortably larger than.
 	WorkerRetention time.Duration
 
+	// WorkerOfflineAfter is how long a liveness signal may go unheard before it
+	// counts as offline, for the runs past a claim deadline the reconciler
+	// judges by who is online. Zero is the default threshold.
+	WorkerOfflineAfter time.Duration
+
 	// Advisories watches for dispatches the transport has abandoned. Nil for a
 	// transport with no such notion, in which case that loop is not registered.
 	Advisories Loop
+
+	// Presence records the broker half of worker liveness. Nil for a transport
+	// with no broker to be present on.
+	Presence Loop
+
+	// RemoteWriter pushes finished runs to a Prometheus receiver. Nil when no
+	// receiver is configured.
+	RemoteWriter Loop
 }
 
 // Dispatch is what Register built, for a host that needs to reach a loop after
@@ -106,6 +166,9 @@ type Dispatch struct {
 	// see urth.ReconcileStore and ADR 0006 §5.
 	Reconciler *urth.Reconciler
 
+	// Retention is nil when the retention sweep is disabled in this process.
+	Retention *urth.RetentionSweeper
+
 	// Advisories reports whether the abandoned-dispatch watcher was registered.
 	Advisories bool
 }
@@ -118,7 +181,12 @@ type Dispatch struct {
 func Register(manager *Manager, cfg Config, deps Dependencies) (Dispatch, error) {
 	var dispatch Dispatch
 
-	if cfg.RelayEnabled {
+	selected, err := cfg.selection()
+	if err != nil {
+		return dispatch, err
+	}
+
+	if cfg.RelayEnabled && selected(LoopDispatchRelay) {
 		dispatch.Relay = urth.NewDispatchRelay(urth.NewDispatchOutbox(deps.DB), deps.Publisher,
 			urth.WithRelayPollInterval(cfg.RelayPollInterval),
 			urth.WithRelayBatchSize(cfg.RelayBatchSize),
@@ -129,12 +197,12 @@ func Register(manager *Manager, cfg Config, deps Dependencies) (Dispatch, error)
 			urth.WithUndeliverableDispatches(urth.NewUndeliverableRecorder(deps.Store)),
 		)
 
-		if err := manager.Add("dispatch-relay", dispatch.Relay); err != nil {
+		if err := manager.Add(LoopDispatchRelay, dispatch.Relay); err != nil {
 			return dispatch, err
 		}
 	}
 
-	if cfg.ReconcileEnabled {
+	if cfg.ReconcileEnabled && selected(LoopDispatchReconciler) {
 		dispatch.Reconciler = urth.NewReconciler(urth.NewReconcileStore(deps.DB, deps.Store),
 			urth.WithReconcileInterval(cfg.ReconcileInterval),
 			urth.WithReconcileLease(cfg.ReconcileLease),
@@ -142,29 +210,108 @@ func Register(manager *Manager, cfg Config, deps Dependencies) (Dispatch, error)
 			urth.WithPendingDispatchTimeout(deps.MaxJobAge+cfg.PendingDispatchGrace),
 			urth.WithRunnerChannels(deps.Channels),
 			urth.WithWorkerRetention(deps.WorkerRetention),
+			urth.WithReconcileWorkerOfflineAfter(deps.WorkerOfflineAfter),
+			urth.WithOrphanChannelGrace(cfg.OrphanChannelGrace),
+		)
+
+		reconciler := dispatch.Reconciler
+		if err := manager.Add(LoopDispatchReconciler, reconciler,
+			WithLoopStatus(func() any { return reconciler.Status() })); err != nil {
+			return dispatch, err
+		}
+	}
+
+	if cfg.RetentionEnabled && selected(LoopResultRetention) {
+		defaults := urth.RetentionPolicy{
+			Results:       cfg.ResultRetention,
+			HourlyRollups: cfg.HourlyRollupRetention,
+		}
+		if err := defaults.Validate(); err != nil {
+			return dispatch, err
+		}
+
+		dispatch.Retention = urth.NewRetentionSweeper(urth.NewRetentionStore(deps.DB),
+			urth.WithRetentionInterval(cfg.RetentionInterval),
+			urth.WithRetentionBatchSize(cfg.RetentionBatchSize),
+			urth.WithDefaultRetention(defaults),
 		)
 
-		if err := manager.Add("dispatch-reconciler", dispatch.Reconciler); err != nil {
+		if err := manager.Add(LoopResultRetention, dispatch.Retention); err != nil {
 			return dispatch, err
 		}
 	}
 
-	if cfg.AdvisoriesEnabled && deps.Advisories != nil {
+	if cfg.AdvisoriesEnabled && deps.Advisories != nil && selected(LoopDispatchAdvisories) {
 		// Safe in every replica without a lease: recording a dead letter is
 		// idempotent by dispatch and reason, so every replica that sees the same
 		// advisory converges on one record. Which is just as well, because
 		// advisories are at-most-once and a single designated listener would be
 		// a single point at which they are missed.
-		if err := manager.Add("dispatch-advisories", deps.Advisories); err != nil {
+		if err := manager.Add(LoopDispatchAdvisories, deps.Advisories); err != nil {
 			return dispatch, err
 		}
 
 		dispatch.Advisories = true
 	}
 
+	// Not a dispatch loop, but a control loop all the same, and one a
+	// controller-manager must be able to take over with the rest.
+	if deps.Presence != nil && selected(LoopWorkerPresence) {
+		if err := manager.Add(LoopWorkerPresence, deps.Presence); err != nil {
+			return dispatch, err
+		}
+	}
+
+	// The writer's row lease keeps two processes from pushing the same run at
+	// once, so it is as safe in every replica as the relay is.
+	if deps.RemoteWriter != nil && selected(LoopRemoteWrite) {
+		if err := manager.Add(LoopRemoteWrite, deps.RemoteWriter); err != nil {
+			return dispatch, err
+		}
+	}
+
 	return dispatch, nil
 }
 
+// selection turns Controllers into a test of whether a loop is chosen.
+//
+// Read left to right, the last word on a loop winning. A Config built without
+// kong, and so without the flag's default, selects every loop, as it did before
+// there was a selection. A name that is not a
+// loop is refused: `--controllers=*,-dispatch-reconcile` leaving the
+// reconciler running is the kind of mistake that stays unnoticed until it
+// matters.
+func (c Config) selection() (func(string) bool, error) {
+	if c.Controllers == nil {
+		return func(string) bool { return true }, nil
+	}
+
+	chosen := map[string]bool{}
+
+	for _, word := range c.Controllers {
+		word = strings.TrimSpace(word)
+		if word == "" {
+			continue
+		}
+
+		exclude := strings.HasPrefix(word, "-")
+		name := strings.TrimPrefix(word, "-")
+
+		switch {
+		case name == "*":
+			for _, loop := range LoopNames() {
+				chosen[loop] = !exclude
+			}
+		case slices.Contains(LoopNames(), name):
+			chosen[name] = !exclude
+		default:
+			return nil, fmt.Errorf("no control loop is called %q; the loops are %s", name, strings.Join(LoopNames(), ", "))
+		}
+	}
+
+	return func(name string) bool { return chosen[name] }, nil
+}
+
 // Models are the tables the dispatch loops own, for a command's migration step.
 //
 // Listed here so that a command hosting these loops cannot start with the loops
@@ -174,6 +321,7 @@ func Models() []any {
 	return []any{
 		&urth.DispatchOutboxEntry{},
 		&urth.ReconcileLease{},
+		&urth.ResultRollup{},
 	}
 }
 
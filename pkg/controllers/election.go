@@ -0,0 +1,205 @@
+package controllers
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/sre-norns/urth/pkg/urth"
+)
+
+// Leader election is for the controller-manager, not the api-server.
+//
+// ADR 0006 rejects electing a leader among api-server replicas: a loop there
+// runs in every replica, and the per-scan lease the reconciler already takes
+// single-flights its work without a second mechanism. A controller-manager is
+// different in kind. It exists to be the one place the loops run, it is
+// deployed as two or three replicas for availability rather than for capacity,
+// and a relay or a presence watcher has no per-scan lease of its own -- every
+// replica would publish, and every replica would write every worker's presence.
+// So each loop it hosts is run by whichever replica holds that loop's lease.
+//
+// The leases are per loop rather than one for the process, so that a deployment
+// can split its loops between managers with --controllers and still have each
+// loop elected among the managers that host it. They are rows of the table the
+// scan leases already use, named LeaseNamePrefix + the loop's name, so "which
+// process is running the relay" is a SELECT an operator already knows how to
+// write.
+
+// LeaseNamePrefix starts the name of every loop lease.
+const LeaseNamePrefix = "controller/"
+
+// Leader election defaults.
+const (
+	// DefaultElectionLease is how long a manager holds a loop after its last
+	// renewal. A replica that dies takes at most this long to be replaced.
+	DefaultElectionLease = 15 * time.Second
+)
+
+// ErrLeaseLost reports a loop stopped because its manager no longer holds its
+// lease. It is not a failure: another manager is running the loop.
+var ErrLeaseLost = errors.New("controllers: the loop's lease was lost")
+
+// LeaderElectionConfig is how a controller-manager elects which replica runs a
+// loop.
+type LeaderElectionConfig struct {
+	Enabled bool          `help:"Run each loop only in the replica holding its lease in the database" default:"true" negatable:""`
+	Lease   time.Duration `help:"How long a replica holds a loop after its last renewal; renewed every third of it, and given up at half of it without one" default:"15s"`
+
+	// Identity is what the lease rows say holds them. The host name alone
+	// would do for one replica per host; the suffix keeps a restarted process
+	// from mistaking the lease of its predecessor for its own.
+	Identity string `help:"Name this replica records in the leases it holds. Defaults to the host name and a random suffix"`
+}
+
+type election struct {
+	store  urth.LeaseStore
+	holder string
+	lease  time.Duration
+}
+
+// WithLeaderElection runs every loop only while this manager holds that loop's
+// lease in store, under the name holder.
+func WithLeaderElection(store urth.LeaseStore, holder string, lease time.Duration) ManagerOption {
+	return func(m *Manager) {
+		if lease <= 0 {
+			lease = DefaultElectionLease
+		}
+
+		m.election = &election{store: store, holder: holder, lease: lease}
+	}
+}
+
+// runElected waits for the loop's lease, runs the loop while the lease is
+// renewed, and gives the lease up when the loop stops.
+//
+// Returns ErrLeaseLost if the lease went before ctx did, so that supervise
+// goes straight back to waiting rather than backing off as after a failure.
+func (m *Manager) runElected(ctx context.Context, entry namedLoop) error {
+	name := LeaseNamePrefix + entry.name
+
+	acquiredAt, err := m.awaitLease(ctx, entry.name, name)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("controllers: loop %q acquired its lease as %q", entry.name, m.election.holder)
+
+	leaderCtx, lost := context.WithCancelCause(ctx)
+	renewed := make(chan struct{})
+	go func() {
+		defer close(renewed)
+		m.renewLease(leaderCtx, name, acquiredAt, lost)
+	}()
+
+	m.setState(entry.name, LoopRunning)
+	err = m.runOnce(leaderCtx, entry)
+
+	lost(nil)
+	<-renewed
+
+	// Released even when ctx is done, and especially then: a manager shutting
+	// down for a rolling restart should hand its loops over at once, not a
+	// lease later.
+	releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), m.election.storeTimeout())
+	defer cancel()
+	if rerr := m.election.store.ReleaseLease(releaseCtx, name, m.election.holder); rerr != nil {
+		log.Printf("controllers: loop %q failed to release its lease: %v", entry.name, rerr)
+	}
+
+	if ctx.Err() == nil && errors.Is(context.Cause(leaderCtx), ErrLeaseLost) {
+		return ErrLeaseLost
+	}
+
+	return err
+}
+
+// Every lease call is bounded by the election's store timeout. A call to a
+// database that does not answer would otherwise outlast the lease it is trying
+// to keep, and renewLease could not act on the lease lapsing until it returned.
+func (e *election) storeTimeout() time.Duration {
+	return e.lease / 6
+}
+
+// renewInterval is how often a held lease is renewed, and a free one retried.
+func (e *election) renewInterval() time.Duration {
+	return e.lease / 3
+}
+
+// acquire tries for the lease once, and reports when the attempt started. A
+// lease the store grants runs from some moment after that, so counting it from
+// the start is never later than the store does.
+func (e *election) acquire(ctx context.Context, name string) (bool, time.Time, error) {
+	startedAt := time.Now()
+
+	attemptCtx, cancel := context.WithTimeout(ctx, e.storeTimeout())
+	defer cancel()
+
+	held, err := e.store.AcquireLease(attemptCtx, name, e.holder, e.lease)
+
+	return held, startedAt, err
+}
+
+// awaitLease blocks until this manager holds the lease, or ctx ends, and
+// returns when the granted attempt started.
+func (m *Manager) awaitLease(ctx context.Context, loop, name string) (time.Time, error) {
+	for {
+		held, startedAt, err := m.election.acquire(ctx, name)
+		if err != nil {
+			log.Printf("controllers: loop %q could not take its lease: %v", loop, err)
+		}
+		if held {
+			return startedAt, nil
+		}
+
+		m.setState(loop, LoopStandby)
+
+		select {
+		case <-ctx.Done():
+			return time.Time{}, ctx.Err()
+		case <-time.After(m.election.renewInterval()):
+		}
+	}
+}
+
+// renewLease keeps the lease acquired at renewedAt until ctx ends, and cancels
+// the loop through lost once it can no longer say it holds it.
+//
+// A renewal the database refuses -- another holder took over -- ends the loop
+// at once. One that fails outright is retried, so that a database restart does
+// not bounce every loop, but only while the retry is sure to be over before the
+// lease lapses: it starts an interval later and may take a store timeout, so a
+// failure with less than that left of the lease ends the loop there and then.
+// Waiting for the lease to actually lapse would be too late. Another manager
+// takes the loop over the moment it does, and this one's loop would still be
+// stopping.
+func (m *Manager) renewLease(ctx context.Context, name string, renewedAt time.Time, lost context.CancelCauseFunc) {
+	interval := m.election.renewInterval()
+	margin := interval + m.election.storeTimeout()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+
+		held, startedAt, err := m.election.acquire(ctx, name)
+		switch {
+		case ctx.Err() != nil:
+			return
+		case err != nil && time.Since(renewedAt) < m.election.lease-margin:
+			log.Printf("controllers: failed to renew lease %q, retrying: %v", name, err)
+		case err != nil:
+			log.Printf("controllers: failed to renew lease %q, giving it up before it lapses: %v", name, err)
+			lost(ErrLeaseLost)
+			return
+		case !held:
+			lost(ErrLeaseLost)
+			return
+		default:
+			renewedAt = startedAt
+		}
+	}
+}
@@ -0,0 +1,224 @@
+package controllers_test
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sre-norns/urth/pkg/controllers"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryLeases is the lease table's takeover rule, in memory.
+type memoryLeases struct {
+	mu          sync.Mutex
+	holders     map[string]string
+	expires     map[string]time.Time
+	unreachable bool
+}
+
+func newMemoryLeases() *memoryLeases {
+	return &memoryLeases{holders: map[string]string{}, expires: map[string]time.Time{}}
+}
+
+func (l *memoryLeases) AcquireLease(ctx context.Context, name, holder string, lease time.Duration) (bool, error) {
+	if l.isUnreachable() {
+		// A database that does not answer: the call lasts as long as it is
+		// allowed to.
+		<-ctx.Done()
+		return false, ctx.Err()
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if current, ok := l.holders[name]; ok && current != holder && time.Now().Before(l.expires[name]) {
+		return false, nil
+	}
+
+	l.holders[name] = holder
+	l.expires[name] = time.Now().Add(lease)
+
+	return true, nil
+}
+
+func (l *memoryLeases) ReleaseLease(_ context.Context, name, holder string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.holders[name] == holder {
+		l.expires[name] = time.Now()
+	}
+
+	return nil
+}
+
+func (l *memoryLeases) steal(name, holder string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.holders[name] = holder
+	l.expires[name] = time.Now().Add(time.Hour)
+}
+
+func (l *memoryLeases) cutOff() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.unreachable = true
+}
+
+func (l *memoryLeases) isUnreachable() bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.unreachable
+}
+
+func (l *memoryLeases) expiry(name string) time.Time {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.expires[name]
+}
+
+func electedManager(leases *memoryLeases, holder string) *controllers.Manager {
+	return controllers.NewManager(
+		controllers.WithBackoff(time.Millisecond, 5*time.Millisecond),
+		controllers.WithHealthyRunTime(time.Hour),
+		controllers.WithLeaderElection(leases, holder, 30*time.Millisecond),
+	)
+}
+
+func stateOf(manager *controllers.Manager, name string) controllers.LoopState {
+	for _, status := range manager.Status() {
+		if status.Name == name {
+			return status.State
+		}
+	}
+
+	return ""
+}
+
+// Two replicas host the loop; one runs it, and when that one shuts down the
+// other takes over without waiting out the lease.
+func TestElectedLoopRunsInOneReplicaAtATime(t *testing.T) {
+	leases := newMemoryLeases()
+
+	var running atomic.Int32
+	var overlapped atomic.Bool
+	loop := controllers.LoopFunc(func(ctx context.Context) error {
+		if running.Add(1) > 1 {
+			overlapped.Store(true)
+		}
+		defer running.Add(-1)
+
+		return blockUntilDone(ctx)
+	})
+
+	firstCtx, stopFirst := context.WithCancel(context.Background())
+	defer stopFirst()
+	secondCtx, stopSecond := context.WithCancel(context.Background())
+	defer stopSecond()
+
+	first := electedManager(leases, "first")
+	first.MustAdd("relay", loop)
+	first.Start(firstCtx)
+
+	require.Eventually(t, func() bool { return stateOf(first, "relay") == controllers.LoopRunning }, 5*time.Second, time.Millisecond)
+
+	second := electedManager(leases, "second")
+	second.MustAdd("relay", loop)
+	second.Start(secondCtx)
+
+	require.Eventually(t, func() bool { return stateOf(second, "relay") == controllers.LoopStandby }, 5*time.Second, time.Millisecond)
+
+	stopFirst()
+	require.NoError(t, first.Wait(5*time.Second))
+
+	require.Eventually(t, func() bool { return stateOf(second, "relay") == controllers.LoopRunning }, 5*time.Second, time.Millisecond,
+		"the standby replica should take the loop over once the first released it")
+	require.False(t, overlapped.Load(), "the loop ran in both replicas at once")
+
+	stopSecond()
+	require.NoError(t, second.Wait(5*time.Second))
+}
+
+// A loop whose lease is taken over stops, and waits for it again rather than
+// counting as a failure.
+func TestElectedLoopStopsWhenItsLeaseIsLost(t *testing.T) {
+	leases := newMemoryLeases()
+
+	stopped := make(chan struct{}, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	manager := electedManager(leases, "mine")
+	manager.MustAdd("reconciler", controllers.LoopFunc(func(ctx context.Context) error {
+		err := blockUntilDone(ctx)
+		stopped <- struct{}{}
+		return err
+	}))
+	manager.Start(ctx)
+
+	require.Eventually(t, func() bool { return stateOf(manager, "reconciler") == controllers.LoopRunning }, 5*time.Second, time.Millisecond)
+
+	leases.steal(controllers.LeaseNamePrefix+"reconciler", "someone-else")
+
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a loop kept running after its lease was taken over")
+	}
+
+	require.Eventually(t, func() bool { return stateOf(manager, "reconciler") == controllers.LoopStandby }, 5*time.Second, time.Millisecond)
+	require.Zero(t, manager.Status()[0].Restarts, "losing a lease is not a failure")
+
+	cancel()
+	require.NoError(t, manager.Wait(5*time.Second))
+	require.Equal(t, controllers.LoopStopped, stateOf(manager, "reconciler"))
+}
+
+// A manager that cannot reach the database stops its loop while the lease is
+// still its own, rather than once another manager may already have the loop.
+func TestElectedLoopStopsBeforeAnUnrenewedLeaseLapses(t *testing.T) {
+	const lease = 300 * time.Millisecond
+	leases := newMemoryLeases()
+	name := controllers.LeaseNamePrefix + "presence"
+
+	stoppedAt := make(chan time.Time, 1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	manager := controllers.NewManager(
+		controllers.WithHealthyRunTime(time.Hour),
+		controllers.WithLeaderElection(leases, "mine", lease),
+	)
+	manager.MustAdd("presence", controllers.LoopFunc(func(ctx context.Context) error {
+		err := blockUntilDone(ctx)
+		stoppedAt <- time.Now()
+		return err
+	}))
+	manager.Start(ctx)
+
+	require.Eventually(t, func() bool { return stateOf(manager, "presence") == controllers.LoopRunning }, 5*time.Second, time.Millisecond)
+
+	leases.cutOff()
+
+	// The lease only ever moves forward, so its last expiry is the one the
+	// stalled renewals failed to extend.
+	select {
+	case at := <-stoppedAt:
+		require.True(t, at.Before(leases.expiry(name)),
+			"the loop stopped at %v, after its lease lapsed at %v", at, leases.expiry(name))
+	case <-time.After(5 * time.Second):
+		t.Fatal("a loop kept running though its lease could not be renewed")
+	}
+
+	cancel()
+	require.NoError(t, manager.Wait(5*time.Second))
+}
@@ -80,21 +80,38 @@ type Manager struct {
 	maxBackoff     time.Duration
 	healthyRunTime time.Duration
 
+	// election is nil unless each loop must hold a lease to run. See
+	// election.go.
+	election *election
+
 	mu      sync.Mutex
 	loops   []namedLoop
+	states  map[string]*LoopStatus
 	started bool
 
 	wg sync.WaitGroup
 }
 
 type namedLoop struct {
-	name string
-	loop Loop
+	name   string
+	loop   Loop
+	status func() any
 }
 
 // ManagerOption configures a Manager.
 type ManagerOption func(*Manager)
 
+// LoopOption configures one loop as it is added.
+type LoopOption func(*namedLoop)
+
+// WithLoopStatus reports a loop's own view of its health alongside what the
+// manager knows about it -- the reconciler's ReconcileStatus, say. The manager
+// only sees a loop start and stop; whether its passes succeed is the loop's to
+// say.
+func WithLoopStatus(fn func() any) LoopOption {
+	return func(l *namedLoop) { l.status = fn }
+}
+
 // WithBackoff sets the bounds on the wait between restarts.
 func WithBackoff(minimum, maximum time.Duration) ManagerOption {
 	return func(m *Manager) {
@@ -114,6 +131,7 @@ func NewManager(options ...ManagerOption) *Manager {
 		minBackoff:     DefaultMinBackoff,
 		maxBackoff:     DefaultMaxBackoff,
 		healthyRunTime: DefaultHealthyRunTime,
+		states:         map[string]*LoopStatus{},
 	}
 
 	for _, option := range options {
@@ -128,7 +146,7 @@ func NewManager(options ...ManagerOption) *Manager {
 // Registering after Start is refused rather than tolerated: a loop added to a
 // running manager would never be started, and a control loop that silently does
 // not exist is the failure this whole arrangement is trying to avoid.
-func (m *Manager) Add(name string, loop Loop) error {
+func (m *Manager) Add(name string, loop Loop, options ...LoopOption) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
@@ -136,7 +154,13 @@ func (m *Manager) Add(name string, loop Loop) error {
 		return fmt.Errorf("%w: cannot add loop %q", ErrManagerStarted, name)
 	}
 
-	m.loops = append(m.loops, namedLoop{name: name, loop: loop})
+	entry := namedLoop{name: name, loop: loop}
+	for _, option := range options {
+		option(&entry)
+	}
+
+	m.loops = append(m.loops, entry)
+	m.states[name] = &LoopStatus{Name: name, State: LoopStarting, Since: time.Now()}
 
 	return nil
 }
@@ -147,8 +171,8 @@ func (m *Manager) Add(name string, loop Loop) error {
 // continuing without the loop. Everything else should use Add: the only error
 // it returns is a programming mistake, but a command that has one is better off
 // saying so through its own startup path than dying in a stack trace.
-func (m *Manager) MustAdd(name string, loop Loop) {
-	if err := m.Add(name, loop); err != nil {
+func (m *Manager) MustAdd(name string, loop Loop, options ...LoopOption) {
+	if err := m.Add(name, loop, options...); err != nil {
 		panic(err)
 	}
 }
@@ -223,20 +247,37 @@ func (m *Manager) Wait(timeout time.Duration) error {
 // answer is the same -- log it and start it again -- because the failure a
 // control loop must never have is stopping quietly.
 func (m *Manager) supervise(ctx context.Context, entry namedLoop) {
+	defer m.setState(entry.name, LoopStopped)
+
 	backoff := m.minBackoff
 
 	for {
 		startedAt := time.Now()
-		err := m.runOnce(ctx, entry)
+
+		var err error
+		if m.election != nil {
+			err = m.runElected(ctx, entry)
+		} else {
+			m.setState(entry.name, LoopRunning)
+			err = m.runOnce(ctx, entry)
+		}
 
 		if ctx.Err() != nil {
 			return
 		}
 
+		// Not a failure: another process holds the loop now, and this one goes
+		// back to waiting for it at once.
+		if errors.Is(err, ErrLeaseLost) {
+			log.Printf("controllers: loop %q lost its lease; standing by", entry.name)
+			continue
+		}
+
 		if time.Since(startedAt) >= m.healthyRunTime {
 			backoff = m.minBackoff
 		}
 
+		m.recordFailure(entry.name, err)
 		log.Printf("controllers: loop %q stopped unexpectedly (%v); restarting in %v",
 			entry.name, err, backoff)
 
@@ -270,3 +311,94 @@ func (m *Manager) runOnce(ctx context.Context, entry namedLoop) (err error) {
 
 	return entry.loop.Run(ctx)
 }
+
+// LoopState is where a loop is in its supervision.
+type LoopState string
+
+const (
+	// LoopStarting is a loop registered with a manager not yet started.
+	LoopStarting LoopState = "starting"
+
+	// LoopStandby is a loop waiting for its lease while another process runs
+	// it. Only a manager with leader election has loops in this state.
+	LoopStandby LoopState = "standby"
+
+	// LoopRunning is a loop whose Run is executing.
+	LoopRunning LoopState = "running"
+
+	// LoopRestarting is a loop that stopped unexpectedly, waiting out its
+	// backoff.
+	LoopRestarting LoopState = "restarting"
+
+	// LoopStopped is a loop whose manager's context has ended.
+	LoopStopped LoopState = "stopped"
+)
+
+// LoopStatus is what a manager knows about one of its loops.
+//
+// Like ReconcileStatus, it is this process's view: a loop in standby here may be
+// running elsewhere, and only the lease row says where. It answers "what is this
+// process doing and why", not "is anything reconciling".
+type LoopStatus struct {
+	Name  string    `json:"name" yaml:"name"`
+	State LoopState `json:"state" yaml:"state"`
+
+	// Since is when the loop entered State.
+	Since time.Time `json:"since" yaml:"since"`
+
+	// Restarts counts the times the loop stopped unexpectedly and was started
+	// again. Losing a lease is not counted.
+	Restarts int `json:"restarts" yaml:"restarts"`
+
+	// LastError is why the loop last stopped unexpectedly, and LastErrorAt when.
+	LastError   string    `json:"lastError,omitempty" yaml:"lastError,omitempty"`
+	LastErrorAt time.Time `json:"lastErrorAt,omitzero" yaml:"lastErrorAt,omitempty"`
+
+	// Detail is the loop's own status, for a loop added WithLoopStatus.
+	Detail any `json:"detail,omitempty" yaml:"detail,omitempty"`
+}
+
+// Status reports every registered loop, in registration order.
+func (m *Manager) Status() []LoopStatus {
+	m.mu.Lock()
+	loops := m.loops
+	statuses := make([]LoopStatus, 0, len(loops))
+	for _, entry := range loops {
+		statuses = append(statuses, *m.states[entry.name])
+	}
+	m.mu.Unlock()
+
+	// Asked outside the lock: a loop's status takes the loop's own lock, and
+	// nothing says it does not call back into the manager while holding it.
+	for i, entry := range loops {
+		if entry.status != nil {
+			statuses[i].Detail = entry.status()
+		}
+	}
+
+	return statuses
+}
+
+func (m *Manager) setState(name string, state LoopState) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if status := m.states[name]; status.State != state {
+		status.State = state
+		status.Since = time.Now()
+	}
+}
+
+func (m *Manager) recordFailure(name string, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status := m.states[name]
+	status.State = LoopRestarting
+	status.Since = time.Now()
+	status.Restarts++
+	status.LastErrorAt = status.Since
+	if err != nil {
+		status.LastError = err.Error()
+	}
+}
@@ -264,3 +264,61 @@ func TestLoopFuncReportsItsError(t *testing.T) {
 		return sentinel
 	}).Run(context.Background()), sentinel)
 }
+
+func TestManagerStatusReportsRestartsAndTheLoopsOwnStatus(t *testing.T) {
+	var starts atomic.Int32
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	manager := fastManager()
+	manager.MustAdd("fails-once", controllers.LoopFunc(func(ctx context.Context) error {
+		if starts.Add(1) == 1 {
+			return errors.New("broker unreachable")
+		}
+
+		return blockUntilDone(ctx)
+	}), controllers.WithLoopStatus(func() any { return "scanned 3 runs" }))
+
+	statuses := manager.Status()
+	require.Len(t, statuses, 1)
+	require.Equal(t, controllers.LoopStarting, statuses[0].State)
+
+	manager.Start(ctx)
+
+	require.Eventually(t, func() bool {
+		status := manager.Status()[0]
+		return status.State == controllers.LoopRunning && status.Restarts == 1
+	}, 5*time.Second, time.Millisecond)
+
+	status := manager.Status()[0]
+	require.Equal(t, "broker unreachable", status.LastError)
+	require.Equal(t, "scanned 3 runs", status.Detail)
+
+	cancel()
+	require.NoError(t, manager.Wait(5*time.Second))
+	require.Equal(t, controllers.LoopStopped, manager.Status()[0].State)
+}
+
+func TestRegisterRunsOnlyTheSelectedLoops(t *testing.T) {
+	loop := controllers.LoopFunc(blockUntilDone)
+	deps := controllers.Dependencies{Presence: loop, RemoteWriter: loop}
+
+	manager := controllers.NewManager()
+	_, err := controllers.Register(manager, controllers.Config{Controllers: []string{"*", "-worker-presence"}}, deps)
+	require.NoError(t, err)
+	require.Equal(t, []string{controllers.LoopRemoteWrite}, manager.Names())
+
+	manager = controllers.NewManager()
+	_, err = controllers.Register(manager, controllers.Config{Controllers: []string{"worker-presence"}}, deps)
+	require.NoError(t, err)
+	require.Equal(t, []string{controllers.LoopWorkerPresence}, manager.Names())
+
+	manager = controllers.NewManager()
+	_, err = controllers.Register(manager, controllers.Config{}, deps)
+	require.NoError(t, err)
+	require.Len(t, manager.Names(), 2, "a Config built by hand selects every loop")
+
+	_, err = controllers.Register(controllers.NewManager(), controllers.Config{Controllers: []string{"*", "-dispatch-reconcile"}}, deps)
+	require.ErrorContains(t, err, `no control loop is called "dispatch-reconcile"`)
+}
@@ -64,6 +64,10 @@ func StreamDrift(have jetstream.StreamConfig, cfg Config) []Drift {
 	add("retention policy", have.Retention, want.Retention)
 	add("storage", have.Storage, want.Storage)
 
+	// Compared as a list, not as a value: a stream provisioned before the
+	// high-priority subjects existed has only the first, and is brought up to
+	// date in place.
+	add("subjects", strings.Join(have.Subjects, ","), strings.Join(want.Subjects, ","))
 	add("replicas", have.Replicas, want.Replicas)
 	add("max messages", have.MaxMsgs, want.MaxMsgs)
 	add("max bytes", have.MaxBytes, want.MaxBytes)
@@ -101,7 +105,7 @@ func immutableStreamDrift(drift []Drift) []Drift {
 func jobStreamConfig(cfg Config) jetstream.StreamConfig {
 	return jetstream.StreamConfig{
 		Name:     JobsStreamName,
-		Subjects: []string{JobsSubjectWildcard},
+		Subjects: []string{JobsSubjectWildcard, HighPriorityJobsSubjectWildcard},
 
 		// WorkQueue: a job is delivered to exactly one worker and removed once
 		// acknowledged. Note this is what makes runner subject filters need to
@@ -116,7 +120,7 @@ func jobStreamConfig(cfg Config) jetstream.StreamConfig {
 		// Result errored and alert.
 		Discard:              jetstream.DiscardNew,
 		DiscardNewPerSubject: true,
-		MaxMsgsPerSubject:    cfg.MaxJobsPerRunner,
+		MaxMsgsPerSubject:    cfg.MaxJobsPerQueue(),
 
 		// Global bounds, alongside the per-subject one. The per-subject limit
 		// stops a single offline runner from consuming the stream; it does not
@@ -195,6 +199,29 @@ func EnsureJobStream(ctx context.Context, js jetstream.JetStream, cfg Config) (j
 // so that a runner created before this code shipped -- or one whose consumer an
 // operator removed -- still gets a queue rather than silently dropping jobs.
 func EnsureRunnerConsumer(ctx context.Context, js jetstream.JetStream, cfg Config, runnerUID manifest.ResourceID) (jetstream.Consumer, error) {
+	return ensureConsumer(ctx, js, cfg, runnerUID, RunnerConsumerName(runnerUID), JobSubject(runnerUID))
+}
+
+// EnsureHighPriorityConsumer creates or updates the durable pull consumer for
+// a runner's high-priority jobs, on the same terms as EnsureRunnerConsumer.
+//
+// Separate from it, so that each call still provisions one consumer; a runner
+// whose queues are being provisioned wants both, which is ensureRunnerQueues.
+func EnsureHighPriorityConsumer(ctx context.Context, js jetstream.JetStream, cfg Config, runnerUID manifest.ResourceID) (jetstream.Consumer, error) {
+	return ensureConsumer(ctx, js, cfg, runnerUID, HighPriorityConsumerName(runnerUID), HighPriorityJobSubject(runnerUID))
+}
+
+// ensureRunnerQueues provisions both of a runner's consumers.
+func ensureRunnerQueues(ctx context.Context, js jetstream.JetStream, cfg Config, runnerUID manifest.ResourceID) error {
+	if _, err := EnsureRunnerConsumer(ctx, js, cfg, runnerUID); err != nil {
+		return err
+	}
+
+	_, err := EnsureHighPriorityConsumer(ctx, js, cfg, runnerUID)
+	return err
+}
+
+func ensureConsumer(ctx context.Context, js jetstream.JetStream, cfg Config, runnerUID manifest.ResourceID, durable, subject string) (jetstream.Consumer, error) {
 	// No pre-flight drift check here, unlike the stream, and not by oversight.
 	// There is nothing on a consumer of *this* stream that JetStream refuses to
 	// change in place: a work-queue stream will not accept a consumer with any
@@ -204,12 +231,12 @@ func EnsureRunnerConsumer(ctx context.Context, js jetstream.JetStream, cfg Confi
 	// CreateOrUpdateConsumer without touching the messages queued under it.
 	// Guarding against drift that cannot happen would only block the repair.
 	consumer, err := js.CreateOrUpdateConsumer(ctx, JobsStreamName, jetstream.ConsumerConfig{
-		Durable: RunnerConsumerName(runnerUID),
+		Durable: durable,
 
 		// Exactly one subject. A wildcard here would overlap other runners'
 		// subjects, which a work-queue stream rejects outright -- and if it did
 		// not, would let one runner's workers drain another's queue.
-		FilterSubject: JobSubject(runnerUID),
+		FilterSubject: subject,
 
 		AckPolicy: jetstream.AckExplicitPolicy,
 
@@ -233,7 +260,7 @@ func EnsureRunnerConsumer(ctx context.Context, js jetstream.JetStream, cfg Confi
 		// runner they belong to.
 	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to provision consumer for runner %q: %w", runnerUID, err)
+		return nil, fmt.Errorf("failed to provision consumer %q for runner %q: %w", durable, runnerUID, err)
 	}
 
 	return consumer, nil
@@ -246,12 +273,22 @@ func EnsureRunnerConsumer(ctx context.Context, js jetstream.JetStream, cfg Confi
 // plane has not provisioned, which is a configuration error worth failing on
 // rather than papering over.
 func BindRunnerConsumer(ctx context.Context, js jetstream.JetStream, runnerUID manifest.ResourceID) (jetstream.Consumer, error) {
-	consumer, err := js.Consumer(ctx, JobsStreamName, RunnerConsumerName(runnerUID))
+	return bindConsumer(ctx, js, runnerUID, RunnerConsumerName(runnerUID))
+}
+
+// BindHighPriorityConsumer looks up a runner's existing high-priority consumer,
+// on the same terms as BindRunnerConsumer.
+func BindHighPriorityConsumer(ctx context.Context, js jetstream.JetStream, runnerUID manifest.ResourceID) (jetstream.Consumer, error) {
+	return bindConsumer(ctx, js, runnerUID, HighPriorityConsumerName(runnerUID))
+}
+
+func bindConsumer(ctx context.Context, js jetstream.JetStream, runnerUID manifest.ResourceID, durable string) (jetstream.Consumer, error) {
+	consumer, err := js.Consumer(ctx, JobsStreamName, durable)
 	if errors.Is(err, jetstream.ErrConsumerNotFound) {
-		return nil, fmt.Errorf("%w: runner %q", ErrNoConsumer, runnerUID)
+		return nil, fmt.Errorf("%w: runner %q, consumer %q", ErrNoConsumer, runnerUID, durable)
 	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to bind consumer for runner %q: %w", runnerUID, err)
+		return nil, fmt.Errorf("failed to bind consumer %q for runner %q: %w", durable, runnerUID, err)
 	}
 
 	return consumer, nil
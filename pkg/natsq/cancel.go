@@ -0,0 +1,94 @@
+package natsq
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/sre-norns/urth/pkg/urth"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// Cancel signals travel on Core NATS, for the reason run logs do: a signal is
+// only worth anything to the worker executing the run at that moment. A worker
+// that is not subscribed is not running it, and one that misses the signal
+// finishes the run and reports it -- a completed run, not a lost one. A durable
+// stream would keep the request for a process that can do nothing with it.
+
+// CancelSubject returns the subject the worker executing one run listens on
+// for a request to stop it.
+//
+// The runner UID leads, as it does in LogSubject, so a worker's subscribe
+// permission can be scoped to its own runner's prefix and no worker can watch
+// another runner's runs being cancelled.
+func CancelSubject(runnerUID, resultUID manifest.ResourceID) string {
+	return fmt.Sprintf("%s.cancel.%s.%s", SubjectPrefix, runnerUID, resultUID)
+}
+
+// RunnerCancelSubjectPrefix returns the subject prefix a runner's workers may
+// subscribe to. It is the permission grant that scopes CancelSubject.
+func RunnerCancelSubjectPrefix(runnerUID manifest.ResourceID) string {
+	return fmt.Sprintf("%s.cancel.%s.*", SubjectPrefix, runnerUID)
+}
+
+// CancelSignal is what a worker is told about a cancelled run: enough to say in
+// its log who stopped it, and nothing it has to act on beyond stopping.
+type CancelSignal struct {
+	RequestedBy string `json:"requestedBy"`
+	Reason      string `json:"reason,omitempty"`
+}
+
+// SignalCancel implements urth.RunCanceller.
+//
+// Published on the runner the Result records as its executor: that is the only
+// runner whose worker can be holding it.
+func (s *scheduler) SignalCancel(ctx context.Context, result urth.Result) error {
+	if result.Status.Executor.RunnerID == "" {
+		return fmt.Errorf("run %q has no executor to signal", result.Name)
+	}
+
+	var signal CancelSignal
+	if cancellation := result.Status.Cancellation; cancellation != nil {
+		signal = CancelSignal{RequestedBy: cancellation.RequestedBy, Reason: cancellation.Reason}
+	}
+
+	data, err := json.Marshal(signal)
+	if err != nil {
+		return err
+	}
+
+	if err := s.conn.Publish(CancelSubject(result.Status.Executor.RunnerID, result.UID), data); err != nil {
+		return fmt.Errorf("failed to signal cancellation of run %q: %w", result.Name, err)
+	}
+
+	// Flushed, so an error reaching the broker is this call's error rather than
+	// something the connection discovers later and the caller never hears of.
+	// Bounded, because FlushWithContext refuses a context with no deadline and
+	// an operator's request should not wait on a broker that is not answering.
+	flushCtx, cancel := context.WithTimeout(ctx, cancelSignalTimeout)
+	defer cancel()
+
+	return s.conn.FlushWithContext(flushCtx)
+}
+
+// cancelSignalTimeout bounds the broker round trip a cancellation makes.
+const cancelSignalTimeout = 2 * time.Second
+
+// SubscribeCancel calls onCancel when the run is cancelled. A signal that will
+// not decode still cancels: the request to stop is the message arriving, and
+// its body only says who sent it.
+func SubscribeCancel(conn *nats.Conn, runnerUID, resultUID manifest.ResourceID, onCancel func(CancelSignal)) (*nats.Subscription, error) {
+	sub, err := conn.Subscribe(CancelSubject(runnerUID, resultUID), func(msg *nats.Msg) {
+		var signal CancelSignal
+		_ = json.Unmarshal(msg.Data, &signal)
+
+		onCancel(signal)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to cancellation of run %v: %w", resultUID, err)
+	}
+
+	return sub, nil
+}
@@ -0,0 +1,70 @@
+package natsq_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sre-norns/urth/pkg/natsq"
+	"github.com/sre-norns/urth/pkg/urth"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// A cancellation is addressed to the runner recorded as the run's executor and
+// to that one run. A worker of the same runner executing a different run must
+// not hear it, or cancelling one run would stop its neighbours.
+func TestCancelSignalReachesOnlyTheCancelledRun(t *testing.T) {
+	transport, _, url := newReconcilableTransport(t)
+
+	conn, err := outboxTestConfig(url).Connect("test-worker")
+	if err != nil {
+		t.Fatalf("failed to connect to NATS: %v", err)
+	}
+	t.Cleanup(conn.Close)
+
+	signals := make(chan natsq.CancelSignal, 2)
+	subscribe := func(resultUID manifest.ResourceID) {
+		sub, err := natsq.SubscribeCancel(conn, reconcileRunnerUID, resultUID, func(signal natsq.CancelSignal) {
+			signals <- signal
+		})
+		if err != nil {
+			t.Fatalf("failed to subscribe: %v", err)
+		}
+		t.Cleanup(func() { _ = sub.Unsubscribe() })
+	}
+	subscribe("result-cancelled")
+	subscribe("result-neighbour")
+
+	// The subscriptions must reach the server before the signal does.
+	if err := conn.Flush(); err != nil {
+		t.Fatalf("failed to flush subscriptions: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	result := urth.Result{}
+	result.Name = "cancelled-run"
+	result.UID = "result-cancelled"
+	result.Status.Executor.RunnerID = reconcileRunnerUID
+	result.Status.Cancellation = &urth.RunCancellation{RequestedBy: "alice", Reason: "wrong target"}
+
+	if err := transport.SignalCancel(ctx, result); err != nil {
+		t.Fatalf("failed to signal cancellation: %v", err)
+	}
+
+	select {
+	case signal := <-signals:
+		if signal.RequestedBy != "alice" || signal.Reason != "wrong target" {
+			t.Errorf("signal = %+v, want it to say who cancelled and why", signal)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the cancel signal")
+	}
+
+	select {
+	case signal := <-signals:
+		t.Errorf("a second run heard the cancellation: %+v", signal)
+	case <-time.After(500 * time.Millisecond):
+	}
+}
@@ -37,10 +37,32 @@ func (s *scheduler) ObserveRunnerChannel(ctx context.Context, runnerUID manifest
 		return urth.RunnerChannelStatus{}, fmt.Errorf("failed to read the queue state of runner %q: %w", runnerUID, err)
 	}
 
-	return urth.RunnerChannelStatus{
+	status := urth.RunnerChannelStatus{
 		Observed:   true,
 		Pullers:    info.NumWaiting,
 		Pending:    info.NumPending,
 		AckPending: info.NumAckPending,
-	}, nil
+	}
+
+	// Work queued on the high-priority consumer is queued for this runner all
+	// the same, and an idle worker parks its long pull there -- see
+	// worker.pump -- so that is where one bound and asking shows up.
+	high, err := BindHighPriorityConsumer(ctx, s.js, runnerUID)
+	if errors.Is(err, ErrNoConsumer) {
+		return status, nil
+	}
+	if err != nil {
+		return urth.RunnerChannelStatus{}, err
+	}
+
+	highInfo, err := high.Info(ctx)
+	if err != nil {
+		return urth.RunnerChannelStatus{}, fmt.Errorf("failed to read the high-priority queue state of runner %q: %w", runnerUID, err)
+	}
+
+	status.Pullers += highInfo.NumWaiting
+	status.Pending += highInfo.NumPending
+	status.AckPending += highInfo.NumAckPending
+
+	return status, nil
 }
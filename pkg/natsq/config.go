@@ -3,9 +3,11 @@ package natsq
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/nats-io/nats.go"
+	"github.com/sre-norns/urth/pkg/urth"
 	"github.com/sre-norns/wyrd/pkg/manifest"
 )
 
@@ -44,8 +46,16 @@ const (
 
 	// JobsSubjectWildcard matches every runner's job subject.
 	JobsSubjectWildcard = SubjectPrefix + ".jobs.*"
+
+	// HighPriorityJobsSubjectWildcard matches every runner's high-priority job
+	// subject. See HighPriorityJobSubject.
+	HighPriorityJobsSubjectWildcard = SubjectPrefix + ".jobs.*." + highPrioritySuffix
 )
 
+// highPrioritySuffix ends the subject and the consumer name of a runner's
+// high-priority queue.
+const highPrioritySuffix = "high"
+
 // JobSubject returns the subject carrying jobs for one runner.
 //
 // The subject is keyed on the runner's immutable UID, never its name. Deleting
@@ -55,9 +65,64 @@ func JobSubject(runnerUID manifest.ResourceID) string {
 	return fmt.Sprintf("%s.jobs.%s", SubjectPrefix, runnerUID)
 }
 
+// HighPriorityJobSubject returns the subject carrying a runner's high-priority
+// jobs -- manual runs and retries, under the default priority class.
+//
+// A subject of its own, beside JobSubject rather than in place of it, so that a
+// dispatch published before priorities existed, and every normal one since, is
+// still where it always was. The two never overlap: JobsSubjectWildcard matches
+// exactly one token after "jobs", and this subject has two.
+func HighPriorityJobSubject(runnerUID manifest.ResourceID) string {
+	return JobSubject(runnerUID) + "." + highPrioritySuffix
+}
+
+// JobSubjectFor returns the subject a dispatch of the given priority is
+// published on.
+func JobSubjectFor(runnerUID manifest.ResourceID, priority urth.RunPriority) string {
+	if priority.IsHigh() {
+		return HighPriorityJobSubject(runnerUID)
+	}
+
+	return JobSubject(runnerUID)
+}
+
+// runnerConsumerPrefix starts the durable name of every runner's consumer.
+const runnerConsumerPrefix = "runner-"
+
 // RunnerConsumerName returns the durable consumer name for a runner.
 func RunnerConsumerName(runnerUID manifest.ResourceID) string {
-	return fmt.Sprintf("runner-%s", runnerUID)
+	return runnerConsumerPrefix + string(runnerUID)
+}
+
+// HighPriorityConsumerName returns the durable name of the consumer a runner's
+// workers pull high-priority jobs from.
+//
+// A second consumer rather than a second filter on the first: a consumer
+// delivers in stream order whatever its filters match, so one consumer over both
+// subjects would hand out a manual run only once every scheduled run published
+// before it had gone. Ordering between the queues is the worker's to decide,
+// which takes one consumer each.
+func HighPriorityConsumerName(runnerUID manifest.ResourceID) string {
+	return RunnerConsumerName(runnerUID) + "-" + highPrioritySuffix
+}
+
+// runnerFromConsumer recovers the runner UID from a durable consumer name,
+// reporting false for a consumer that is not a runner's queue. Both of a
+// runner's consumers name the same runner.
+func runnerFromConsumer(name string) (manifest.ResourceID, bool) {
+	uid, found := strings.CutPrefix(name, runnerConsumerPrefix)
+	if !found || uid == "" {
+		return "", false
+	}
+
+	// Runner UIDs are UUIDs, which never end in the suffix, so trimming it
+	// cannot eat into one.
+	uid = strings.TrimSuffix(uid, "-"+highPrioritySuffix)
+	if uid == "" {
+		return "", false
+	}
+
+	return manifest.ResourceID(uid), true
 }
 
 // ClientConfig is what any NATS participant needs in order to connect.
@@ -96,7 +161,13 @@ type Config struct {
 	// MaxJobsPerRunner bounds one runner's share of the shared stream, so that a
 	// runner whose workers are all offline cannot fill the stream and start
 	// rejecting publications for every other runner.
-	MaxJobsPerRunner int64 `help:"Maximum queued jobs per runner before publication is rejected" default:"1024"`
+	//
+	// JetStream applies a limit per subject, and a runner has one subject per
+	// priority, so the limit is split evenly between the two: a backlog of
+	// scheduled runs that has filled its half does not refuse the manual run an
+	// operator is waiting on, and the runner as a whole still holds no more
+	// than this. See MaxJobsPerQueue.
+	MaxJobsPerRunner int64 `help:"Maximum queued jobs per runner, split evenly between its normal and high-priority queues, before publication is rejected" default:"1024"`
 
 	// MaxMsgSize bounds one dispatch envelope. The envelope is deliberately
 	// nearly empty -- identity, not the probe definition, which is disclosed only
@@ -170,6 +241,12 @@ const (
 	flagMaxRunnerSeries  = "--nats.max-runner-series"
 )
 
+// MaxJobsPerQueue is one priority queue's half of MaxJobsPerRunner, which is
+// what the stream's per-subject limit is set to.
+func (c Config) MaxJobsPerQueue() int64 {
+	return c.MaxJobsPerRunner / 2
+}
+
 // Validate checks every constraint that can be settled from the flags alone.
 //
 // kong calls this during parsing -- Config is embedded in each command's CLI
@@ -201,6 +278,8 @@ func (c Config) Validate() error {
 	}
 	if c.MaxJobsPerRunner <= 0 {
 		problems = append(problems, fmt.Errorf("%s must be a positive number of messages, got %d: without it one offline runner can consume the whole stream", flagMaxJobsPerRunner, c.MaxJobsPerRunner))
+	} else if c.MaxJobsPerRunner < 2 {
+		problems = append(problems, fmt.Errorf("%s must be at least 2, got %d: it is split between a runner's two priority queues", flagMaxJobsPerRunner, c.MaxJobsPerRunner))
 	}
 	if c.MaxMsgSize <= 0 {
 		problems = append(problems, fmt.Errorf("%s must be a positive size in bytes, got %d", flagMaxMsgSize, c.MaxMsgSize))
@@ -145,6 +145,21 @@ func TestPerRunnerLimitMayNotExceedTheGlobalOne(t *testing.T) {
 	}
 }
 
+// A runner's limit is split between its two priority queues, so a queue's half
+// is never more than the runner was allowed, and never zero.
+func TestPerRunnerLimitIsSplitBetweenItsQueues(t *testing.T) {
+	cfg := validConfig()
+	cfg.MaxJobsPerRunner = 1024
+	if got := cfg.MaxJobsPerQueue(); got != 512 {
+		t.Errorf("per-queue limit = %d, want 512", got)
+	}
+
+	cfg.MaxJobsPerRunner = 1
+	if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "--nats.max-jobs-per-runner") {
+		t.Errorf("a per-runner limit that leaves a queue nothing must be rejected, got: %v", err)
+	}
+}
+
 // JetStream accepts 1..5 replicas. Anything else is refused by the server, and
 // an even count has no quorum benefit worth the write cost.
 func TestValidateRejectsUnsupportedReplicaCounts(t *testing.T) {
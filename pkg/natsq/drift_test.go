@@ -34,7 +34,7 @@ func TestEnsureJobStreamAppliesChangedLimits(t *testing.T) {
 
 	// An operator raises the fleet's bound and restarts.
 	cfg.MaxJobs = 128
-	cfg.MaxJobsPerRunner = 32
+	cfg.MaxJobsPerRunner = 64
 
 	stream, err := natsq.EnsureJobStream(ctx, js, cfg)
 	if err != nil {
@@ -46,7 +46,7 @@ func TestEnsureJobStreamAppliesChangedLimits(t *testing.T) {
 		t.Fatalf("failed to read stream info: %v", err)
 	}
 	if info.Config.MaxMsgs != 128 || info.Config.MaxMsgsPerSubject != 32 {
-		t.Errorf("stream limits are %d/%d, want 128/32", info.Config.MaxMsgs, info.Config.MaxMsgsPerSubject)
+		t.Errorf("stream limits are %d/%d, want 128/32 (64 per runner, half per queue)", info.Config.MaxMsgs, info.Config.MaxMsgsPerSubject)
 	}
 }
 
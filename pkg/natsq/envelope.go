@@ -13,6 +13,7 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"github.com/sre-norns/urth/pkg/prob"
 	"github.com/sre-norns/wyrd/pkg/manifest"
 )
 
@@ -31,7 +32,7 @@ const DispatchEnvelopeVersion = 1
 // which Result it belongs to, and a worker learns what to actually run by
 // claiming the job through the authenticated API.
 //
-// So this struct carries no prob, no credentials, no artifacts, and no
+// So this struct carries no prob spec, no credentials, no artifacts, and no
 // independently mutable copy of the scenario. Adding any of those back
 // re-opens the hole.
 type DispatchEnvelope struct {
@@ -63,6 +64,16 @@ type DispatchEnvelope struct {
 	// response it lost without starting a second run.
 	DispatchID string `json:"dispatchId"`
 
+	// ProbKind names the prober the run needs, so that a worker whose slots for
+	// that kind are full can hand the message back before claiming it, rather
+	// than holding it past AckWait or claiming a run it cannot start. It is the
+	// one piece of the job the envelope carries, and it is safe to: a kind says
+	// which prober, not what the prober will be asked to do.
+	//
+	// Optional, so the schema version does not move. A dispatch published
+	// without it is bounded by the worker's overall concurrency only.
+	ProbKind prob.Kind `json:"probKind,omitempty"`
+
 	// Trace carries tracing metadata across the queue boundary.
 	Trace map[string]string `json:"trace,omitempty"`
 }
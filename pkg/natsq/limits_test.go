@@ -60,7 +60,7 @@ func TestPerRunnerLimitDoesNotEvictAnotherRunner(t *testing.T) {
 	js := mustJetStream(t, conn)
 
 	cfg := testConfig()
-	cfg.MaxJobsPerRunner = 2
+	cfg.MaxJobsPerRunner = 4 // two on each queue
 	cfg.MaxJobs = 16
 
 	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
@@ -115,7 +115,7 @@ func TestGlobalLimitRefusesPublicationAndKeepsQueuedWork(t *testing.T) {
 
 	cfg := testConfig()
 	cfg.MaxJobs = 3
-	cfg.MaxJobsPerRunner = 2
+	cfg.MaxJobsPerRunner = 4 // two on each queue
 
 	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 	defer cancel()
@@ -298,7 +298,7 @@ func TestStreamAndConsumerCarryEveryConfiguredLimit(t *testing.T) {
 	}{
 		{"MaxMsgs", got.MaxMsgs, cfg.MaxJobs},
 		{"MaxBytes", got.MaxBytes, cfg.MaxBytes},
-		{"MaxMsgsPerSubject", got.MaxMsgsPerSubject, cfg.MaxJobsPerRunner},
+		{"MaxMsgsPerSubject", got.MaxMsgsPerSubject, cfg.MaxJobsPerQueue()},
 		{"MaxMsgSize", got.MaxMsgSize, cfg.MaxMsgSize},
 		{"MaxAge", got.MaxAge, cfg.MaxJobAge},
 		{"Duplicates", got.Duplicates, cfg.DuplicateWindow},
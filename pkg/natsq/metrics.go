@@ -276,17 +276,29 @@ func (c *JetStreamCollector) collectStream(ctx context.Context, ch chan<- promet
 }
 
 // collectConsumers reads every runner queue's state.
+//
+// A runner's two consumers -- normal and high priority -- are summed into one
+// state. Reported apart they would be two series under the same runner label,
+// which the registry refuses, and "how much is queued for this runner" is the
+// question either way.
 func (c *JetStreamCollector) collectConsumers(ctx context.Context, stream jetstream.Stream) ([]runnerState, error) {
 	var states []runnerState
+	seen := make(map[string]int)
 
 	listing := stream.ListConsumers(ctx)
 	for info := range listing.Info() {
-		states = append(states, runnerState{
-			runner:      runnerFromConsumerName(info.Name),
-			pending:     info.NumPending,
-			ackPending:  info.NumAckPending,
-			redelivered: info.NumRedelivered,
-		})
+		runner := runnerFromConsumerName(info.Name)
+
+		i, ok := seen[runner]
+		if !ok {
+			i = len(states)
+			seen[runner] = i
+			states = append(states, runnerState{runner: runner})
+		}
+
+		states[i].pending += info.NumPending
+		states[i].ackPending += info.NumAckPending
+		states[i].redelivered += info.NumRedelivered
 	}
 
 	return states, listing.Err()
@@ -319,9 +331,8 @@ func (c *JetStreamCollector) reportable(states []runnerState) []runnerState {
 // label reads as the resource an operator knows rather than as JetStream's
 // naming of it.
 func runnerFromConsumerName(name string) string {
-	const prefix = "runner-"
-	if len(name) > len(prefix) && name[:len(prefix)] == prefix {
-		return name[len(prefix):]
+	if uid, ok := runnerFromConsumer(name); ok {
+		return string(uid)
 	}
 
 	return name
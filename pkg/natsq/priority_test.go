@@ -0,0 +1,179 @@
+package natsq_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go/jetstream"
+
+	"github.com/sre-norns/urth/pkg/natsq"
+	"github.com/sre-norns/urth/pkg/urth"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// The high-priority subject sits beside the normal one, so that everything
+// published before priorities existed is still where it was, and the two never
+// overlap -- a work-queue stream refuses overlapping consumers.
+func TestHighPrioritySubjectIsDisjointFromTheNormalOne(t *testing.T) {
+	const runnerUID = "7a0c3c1e-0f0b-4c1e-9a57-2b1d0c9e4f11"
+
+	normal := natsq.JobSubjectFor(runnerUID, urth.PriorityNormal)
+	high := natsq.JobSubjectFor(runnerUID, urth.PriorityHigh)
+
+	if normal != natsq.JobSubject(runnerUID) {
+		t.Errorf("normal priority goes on %q, want the runner's existing subject %q", normal, natsq.JobSubject(runnerUID))
+	}
+	if got := natsq.JobSubjectFor(runnerUID, ""); got != normal {
+		t.Errorf("an entry without a priority goes on %q, want %q", got, normal)
+	}
+	if high == normal {
+		t.Fatalf("high and normal priority share subject %q", high)
+	}
+
+	// JobsSubjectWildcard is one token after "jobs"; the high subject is two.
+	if tokens := strings.Count(high, ".") - strings.Count(natsq.JobsSubjectWildcard, "."); tokens != 1 {
+		t.Errorf("high subject %q should be one token deeper than %q", high, natsq.JobsSubjectWildcard)
+	}
+
+	if natsq.HighPriorityConsumerName(runnerUID) == natsq.RunnerConsumerName(runnerUID) {
+		t.Error("the runner's two consumers share a durable name")
+	}
+}
+
+// upgradedFleet answers whether a runner's workers all pull the high queue.
+type upgradedFleet bool
+
+func (f upgradedFleet) DrainsHighPriority(context.Context, manifest.ResourceID) (bool, error) {
+	return bool(f), nil
+}
+
+// A manual run published behind a backlog of scheduled ones is on a queue of
+// its own, and a worker asking that queue gets it first.
+func TestHighPriorityDispatchJumpsTheQueue(t *testing.T) {
+	transport, js, _ := newReconcilableTransport(t, natsq.WithHighPriorityQueueSupport(upgradedFleet(true)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	info, err := transport.ConnectionInfoFor(ctx, testRunnerUID)
+	if err != nil {
+		t.Fatalf("failed to provision the runner channel: %v", err)
+	}
+	if info.HighPriorityConsumer != natsq.HighPriorityConsumerName(testRunnerUID) {
+		t.Errorf("connection info offers high-priority consumer %q, want %q",
+			info.HighPriorityConsumer, natsq.HighPriorityConsumerName(testRunnerUID))
+	}
+
+	for _, eventUID := range []string{"scheduled-1.1", "scheduled-2.1", "scheduled-3.1"} {
+		if _, err := transport.PublishDispatch(ctx, dispatchEntry(eventUID, manifest.ResourceID("result-"+eventUID))); err != nil {
+			t.Fatalf("failed to publish %s: %v", eventUID, err)
+		}
+	}
+
+	manual := dispatchEntry("manual-1.1", "result-manual")
+	manual.Priority = urth.PriorityHigh
+	if _, err := transport.PublishDispatch(ctx, manual); err != nil {
+		t.Fatalf("failed to publish the manual run: %v", err)
+	}
+
+	high, err := natsq.BindHighPriorityConsumer(ctx, js, testRunnerUID)
+	if err != nil {
+		t.Fatalf("failed to bind the high-priority consumer: %v", err)
+	}
+
+	batch, err := high.Fetch(10, jetstream.FetchMaxWait(2*time.Second))
+	if err != nil {
+		t.Fatalf("fetch from the high-priority queue failed: %v", err)
+	}
+
+	var delivered []string
+	for msg := range batch.Messages() {
+		envelope, err := natsq.UnmarshalEnvelope(msg.Data())
+		if err != nil {
+			t.Fatalf("failed to decode envelope: %v", err)
+		}
+		delivered = append(delivered, envelope.DispatchID)
+		_ = msg.Ack()
+	}
+	if len(delivered) != 1 || delivered[0] != manual.EventUID {
+		t.Fatalf("high-priority queue delivered %v, want only %q", delivered, manual.EventUID)
+	}
+
+	normal, err := natsq.BindRunnerConsumer(ctx, js, testRunnerUID)
+	if err != nil {
+		t.Fatalf("failed to bind the normal consumer: %v", err)
+	}
+	state, err := normal.Info(ctx)
+	if err != nil {
+		t.Fatalf("failed to read the normal queue: %v", err)
+	}
+	if state.NumPending != 3 {
+		t.Errorf("normal queue holds %d jobs, want the 3 scheduled ones", state.NumPending)
+	}
+}
+
+// A runner with a worker that predates the high queue binds only the normal
+// one, so a high run is published there too, under a dispatch ID of its own:
+// whichever copy is claimed first wins, and the other's claim is refused.
+func TestHighPriorityDispatchAlsoReachesWorkersThatPredateTheQueue(t *testing.T) {
+	transport, js, _ := newReconcilableTransport(t, natsq.WithHighPriorityQueueSupport(upgradedFleet(false)))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	if _, err := transport.ConnectionInfoFor(ctx, testRunnerUID); err != nil {
+		t.Fatalf("failed to provision the runner channel: %v", err)
+	}
+
+	manual := dispatchEntry("manual-1.1", "result-manual")
+	manual.Priority = urth.PriorityHigh
+	for range 2 {
+		// Twice, as a relay retrying the entry would: no third copy is queued.
+		if _, err := transport.PublishDispatch(ctx, manual); err != nil {
+			t.Fatalf("failed to publish the manual run: %v", err)
+		}
+	}
+
+	dispatchesOn := func(consumer jetstream.Consumer) []string {
+		t.Helper()
+
+		batch, err := consumer.Fetch(10, jetstream.FetchMaxWait(2*time.Second))
+		if err != nil {
+			t.Fatalf("fetch failed: %v", err)
+		}
+
+		var delivered []string
+		for msg := range batch.Messages() {
+			envelope, err := natsq.UnmarshalEnvelope(msg.Data())
+			if err != nil {
+				t.Fatalf("failed to decode envelope: %v", err)
+			}
+			if envelope.ResultUID != manual.ResultUID {
+				t.Errorf("copy is for result %v, want %v", envelope.ResultUID, manual.ResultUID)
+			}
+			delivered = append(delivered, envelope.DispatchID)
+			_ = msg.Ack()
+		}
+
+		return delivered
+	}
+
+	high, err := natsq.BindHighPriorityConsumer(ctx, js, testRunnerUID)
+	if err != nil {
+		t.Fatalf("failed to bind the high-priority consumer: %v", err)
+	}
+	normal, err := natsq.BindRunnerConsumer(ctx, js, testRunnerUID)
+	if err != nil {
+		t.Fatalf("failed to bind the normal consumer: %v", err)
+	}
+
+	onHigh, onNormal := dispatchesOn(high), dispatchesOn(normal)
+	if len(onHigh) != 1 || onHigh[0] != manual.EventUID {
+		t.Errorf("high-priority queue delivered %v, want only %q", onHigh, manual.EventUID)
+	}
+	if len(onNormal) != 1 || onNormal[0] == manual.EventUID {
+		t.Errorf("normal queue delivered %v, want one copy under a dispatch ID of its own", onNormal)
+	}
+}
@@ -3,9 +3,11 @@ package natsq
 import (
 	"context"
 	"fmt"
+	"log"
 
 	"github.com/nats-io/nats.go/jetstream"
 	"github.com/sre-norns/urth/pkg/urth"
+	"github.com/sre-norns/wyrd/pkg/manifest"
 )
 
 // PublishDispatch implements urth.DispatchPublisher.
@@ -34,6 +36,7 @@ func (s *scheduler) PublishDispatch(ctx context.Context, entry urth.DispatchOutb
 		// equal is what lets a worker's claim, JetStream's duplicate window, and
 		// the outbox row all be matched up when diagnosing a stuck run.
 		DispatchID: entry.EventUID,
+		ProbKind:   entry.ProbKind,
 	}
 
 	data, err := MarshalEnvelope(envelope)
@@ -48,17 +51,74 @@ func (s *scheduler) PublishDispatch(ctx context.Context, entry urth.DispatchOutb
 	// before JetStream has persisted the message would let the relay mark the
 	// entry published when it may never be delivered -- reintroducing, one layer
 	// further down, exactly the lost-dispatch window the outbox closes.
-	ack, err := s.js.Publish(ctx, JobSubject(entry.RunnerUID), data, jetstream.WithMsgID(entry.EventUID))
+	//
+	// The subject is the entry's priority queue.
+	ack, err := s.js.Publish(ctx, JobSubjectFor(entry.RunnerUID, entry.Priority), data, jetstream.WithMsgID(entry.EventUID))
 	if err != nil {
 		s.totalErrors.Add(1)
 		return urth.DispatchReceipt{}, fmt.Errorf("failed to publish dispatch %v: %w", entry.EventUID, err)
 	}
 
+	if entry.Priority.IsHigh() && !s.drainsHighPriority(ctx, entry.RunnerUID) {
+		if err := s.publishNormalCopy(ctx, envelope); err != nil {
+			s.totalErrors.Add(1)
+			return urth.DispatchReceipt{}, err
+		}
+	}
+
 	s.totalScheduled.Add(1)
 
 	// The stream sequence is what makes this message addressable later. A
 	// duplicate suppressed by the message-ID window comes back with the sequence
 	// of the original, which is the answer the reconciler wants: the message that
-	// is actually queued.
+	// is actually queued. For a high run published twice it is the high copy's,
+	// the one an upgraded worker takes first.
 	return urth.DispatchReceipt{Sequence: ack.Sequence}, nil
 }
+
+// normalCopySuffix marks the dispatch ID of a high run's copy on the normal
+// queue. See publishNormalCopy.
+const normalCopySuffix = ".normal"
+
+// publishNormalCopy publishes a high run's envelope to its runner's normal
+// queue as well, for the workers that do not pull the high one. See
+// urth.HighPriorityQueueSupport.
+//
+// The copy has a dispatch ID of its own. A worker that pulls both queues may
+// be handed both copies, and with one ID the second claim would be the same
+// worker repeating the same dispatch -- which the server honours as a reclaim,
+// and the run would execute twice. With two, whichever copy is claimed first
+// moves the Result on, and the other's claim is refused as obsolete and
+// acknowledged. The ID also keys the copy's own duplicate window, so the
+// relay retrying the entry does not queue a third.
+func (s *scheduler) publishNormalCopy(ctx context.Context, envelope DispatchEnvelope) error {
+	envelope.DispatchID += normalCopySuffix
+
+	data, err := MarshalEnvelope(envelope)
+	if err != nil {
+		return fmt.Errorf("%w: failed to encode dispatch %v: %w", urth.ErrPermanentDispatch, envelope.DispatchID, err)
+	}
+
+	if _, err := s.js.Publish(ctx, JobSubject(envelope.RunnerUID), data, jetstream.WithMsgID(envelope.DispatchID)); err != nil {
+		return fmt.Errorf("failed to publish dispatch %v: %w", envelope.DispatchID, err)
+	}
+
+	return nil
+}
+
+// drainsHighPriority reports whether a high run for the runner can go to its
+// high queue alone. Unknown is no: a run published twice costs a refused
+// claim, and one published where nothing pulls costs the run.
+func (s *scheduler) drainsHighPriority(ctx context.Context, runnerUID manifest.ResourceID) bool {
+	if s.queues == nil {
+		return false
+	}
+
+	drains, err := s.queues.DrainsHighPriority(ctx, runnerUID)
+	if err != nil {
+		log.Printf("can't tell whether runner %v drains its high-priority queue, publishing to both: %v", runnerUID, err)
+		return false
+	}
+
+	return drains
+}
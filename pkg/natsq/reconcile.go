@@ -16,11 +16,25 @@ import (
 // CreateOrUpdateConsumer unconditionally would work just as well and report
 // every runner as "restored" on every scan, which turns the one number an
 // operator would page on -- a queue that had to be rebuilt -- into noise.
+//
+// Both of the runner's consumers are checked, and either one missing counts: a
+// runner without its high-priority consumer queues every manual run on a subject
+// nothing is pulling from, which is a broken queue however healthy the other
+// looks.
 func (s *scheduler) EnsureRunnerChannel(ctx context.Context, runnerUID manifest.ResourceID) (bool, error) {
-	if _, err := BindRunnerConsumer(ctx, s.js, runnerUID); err == nil {
+	missing := false
+	for _, bind := range []func(context.Context, jetstream.JetStream, manifest.ResourceID) (jetstream.Consumer, error){
+		BindRunnerConsumer, BindHighPriorityConsumer,
+	} {
+		if _, err := bind(ctx, s.js, runnerUID); errors.Is(err, ErrNoConsumer) {
+			missing = true
+		} else if err != nil {
+			return false, err
+		}
+	}
+
+	if !missing {
 		return false, nil
-	} else if !errors.Is(err, ErrNoConsumer) {
-		return false, err
 	}
 
 	// The consumer is genuinely absent: deleted by an operator clearing up, or
@@ -28,7 +42,7 @@ func (s *scheduler) EnsureRunnerChannel(ctx context.Context, runnerUID manifest.
 	// workers cannot fix this -- ADR 0004 gives them no administration rights, on
 	// purpose -- so if the control plane does not rebuild it, the runner accepts
 	// dispatches and delivers none of them.
-	if _, err := EnsureRunnerConsumer(ctx, s.js, s.cfg, runnerUID); err != nil {
+	if err := ensureRunnerQueues(ctx, s.js, s.cfg, runnerUID); err != nil {
 		return false, err
 	}
 
@@ -71,3 +85,75 @@ func (s *scheduler) DropDispatch(ctx context.Context, entry urth.DispatchOutboxE
 
 	return fmt.Errorf("failed to withdraw dispatch %v at sequence %d: %w", entry.EventUID, entry.PublishedSeq, err)
 }
+
+// RemoveRunnerChannel implements urth.RunnerChannelReconciler.
+//
+// The consumers go first, then whatever is still queued on the runner's
+// subjects. Without the purge the messages would outlive their queue: a
+// work-queue stream keeps a message nobody is filtering for until MaxAge, and
+// every one of them counts against the stream's limits meanwhile. Purging the
+// whole subject is right here, where DropDispatch refuses to: the runner is
+// gone, and there is no live work behind these messages to spare.
+func (s *scheduler) RemoveRunnerChannel(ctx context.Context, runnerUID manifest.ResourceID) error {
+	stream, err := s.js.Stream(ctx, JobsStreamName)
+	if err != nil {
+		return fmt.Errorf("failed to look up stream %q: %w", JobsStreamName, err)
+	}
+
+	for _, durable := range []string{RunnerConsumerName(runnerUID), HighPriorityConsumerName(runnerUID)} {
+		err = stream.DeleteConsumer(ctx, durable)
+		if err != nil && !errors.Is(err, jetstream.ErrConsumerNotFound) {
+			return fmt.Errorf("failed to delete consumer %q of runner %q: %w", durable, runnerUID, err)
+		}
+	}
+
+	for _, subject := range []string{JobSubject(runnerUID), HighPriorityJobSubject(runnerUID)} {
+		if err := stream.Purge(ctx, jetstream.WithPurgeSubject(subject)); err != nil {
+			return fmt.Errorf("failed to purge the queue of runner %q: %w", runnerUID, err)
+		}
+	}
+
+	return nil
+}
+
+// RunnerChannels implements urth.RunnerChannelReconciler.
+//
+// A listing cut short is an error, not a shorter list. Nothing is removed on
+// the strength of what this returns -- the runner listing decides that -- but a
+// caller that cannot tell "no more consumers" from "stopped reading" has no
+// business acting on either.
+func (s *scheduler) RunnerChannels(ctx context.Context) ([]urth.RunnerChannel, error) {
+	stream, err := s.js.Stream(ctx, JobsStreamName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up stream %q: %w", JobsStreamName, err)
+	}
+
+	var channels []urth.RunnerChannel
+	seen := make(map[manifest.ResourceID]int)
+
+	listing := stream.ListConsumers(ctx)
+	for info := range listing.Info() {
+		runnerUID, ok := runnerFromConsumer(info.Name)
+		if !ok {
+			continue
+		}
+
+		// A runner has two consumers and is one channel. The younger of the two
+		// is the one reported, so the orphan sweep's grace holds while either
+		// is new.
+		if i, ok := seen[runnerUID]; ok {
+			if info.Created.After(channels[i].CreatedAt) {
+				channels[i].CreatedAt = info.Created
+			}
+			continue
+		}
+
+		seen[runnerUID] = len(channels)
+		channels = append(channels, urth.RunnerChannel{RunnerUID: runnerUID, CreatedAt: info.Created})
+	}
+	if err := listing.Err(); err != nil {
+		return nil, fmt.Errorf("failed to list the consumers of stream %q: %w", JobsStreamName, err)
+	}
+
+	return channels, nil
+}
@@ -17,7 +17,7 @@ const reconcileRunnerUID = manifest.ResourceID("runner-reconcile")
 
 // newReconcilableTransport gives a test a transport over a live JetStream, plus
 // the raw handle used to break things behind its back.
-func newReconcilableTransport(t *testing.T) (natsq.Transport, jetstream.JetStream, string) {
+func newReconcilableTransport(t *testing.T, options ...natsq.SchedulerOption) (natsq.Transport, jetstream.JetStream, string) {
 	t.Helper()
 
 	server := newRestartableNATS(t)
@@ -25,7 +25,7 @@ func newReconcilableTransport(t *testing.T) (natsq.Transport, jetstream.JetStrea
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	transport, err := natsq.NewScheduler(ctx, outboxTestConfig(server.url()))
+	transport, err := natsq.NewScheduler(ctx, outboxTestConfig(server.url()), options...)
 	if err != nil {
 		t.Fatalf("failed to create transport: %v", err)
 	}
@@ -175,6 +175,63 @@ func TestDropDispatchIsQuietWhenTheMessageIsAlreadyGone(t *testing.T) {
 	}
 }
 
+// Taking a deleted runner's queue down takes its consumer and whatever is still
+// queued for it -- and nothing queued for anyone else. Doing it twice is the
+// reconciler retrying a teardown that half-landed, and must not fail.
+func TestRemoveRunnerChannelTakesOnlyThatRunnersQueue(t *testing.T) {
+	transport, js, url := newReconcilableTransport(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	for _, runnerUID := range []manifest.ResourceID{reconcileRunnerUID, testRunnerUID} {
+		if _, err := transport.ConnectionInfoFor(ctx, runnerUID); err != nil {
+			t.Fatalf("failed to provision the channel of runner %q: %v", runnerUID, err)
+		}
+	}
+
+	doomed := dispatchEntry("result-doomed.1", "result-doomed")
+	doomed.RunnerUID = reconcileRunnerUID
+	if _, err := transport.PublishDispatch(ctx, doomed); err != nil {
+		t.Fatalf("failed to publish to the deleted runner: %v", err)
+	}
+	if _, err := transport.PublishDispatch(ctx, dispatchEntry("result-kept.1", "result-kept")); err != nil {
+		t.Fatalf("failed to publish to the surviving runner: %v", err)
+	}
+
+	channels, err := transport.RunnerChannels(ctx)
+	if err != nil {
+		t.Fatalf("failed to list the runner channels: %v", err)
+	}
+	if len(channels) != 2 {
+		t.Fatalf("listed %d runner channels, want 2: %v", len(channels), channels)
+	}
+
+	for range 2 {
+		if err := transport.RemoveRunnerChannel(ctx, reconcileRunnerUID); err != nil {
+			t.Fatalf("failed to remove the runner channel: %v", err)
+		}
+	}
+
+	if _, err := natsq.BindRunnerConsumer(ctx, js, reconcileRunnerUID); !errors.Is(err, natsq.ErrNoConsumer) {
+		t.Fatalf("consumer lookup after removal reported %v, want ErrNoConsumer", err)
+	}
+	if _, err := natsq.BindRunnerConsumer(ctx, js, testRunnerUID); err != nil {
+		t.Fatalf("the other runner's consumer went with it: %v", err)
+	}
+	if msgs := queuedMessages(t, url); msgs != 1 {
+		t.Fatalf("stream holds %d messages after the removal, want the other runner's 1", msgs)
+	}
+
+	channels, err = transport.RunnerChannels(ctx)
+	if err != nil {
+		t.Fatalf("failed to list the runner channels: %v", err)
+	}
+	if len(channels) != 1 || channels[0].RunnerUID != testRunnerUID {
+		t.Fatalf("listed %v after the removal, want only runner %q", channels, testRunnerUID)
+	}
+}
+
 func dispatchEntry(eventUID string, resultUID manifest.ResourceID) urth.DispatchOutboxEntry {
 	return urth.DispatchOutboxEntry{
 		SchemaVersion: urth.DispatchOutboxEntryVersion,
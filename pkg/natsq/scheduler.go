@@ -28,7 +28,7 @@ var ErrNoRunner = urth.ErrDispatchUnplaced
 
 // Transport is everything the API server needs from the NATS backbone: it
 // publishes relayed dispatches, tells a registering worker where to collect
-// work, repairs the assets it owns, and still satisfies the legacy Scheduler the
+// work, repairs the assets it owns, signals cancelled runs, and still satisfies the legacy Scheduler the
 // composition takes.
 type Transport interface {
 	urth.Scheduler
@@ -36,6 +36,7 @@ type Transport interface {
 	urth.WorkerTransportProvider
 	urth.RunnerChannelReconciler
 	urth.RunnerChannelObserver
+	urth.RunCanceller
 }
 
 type scheduler struct {
@@ -43,16 +44,30 @@ type scheduler struct {
 	js   jetstream.JetStream
 	cfg  Config
 
+	// queues says whether a runner's high-priority queue is drained by all
+	// its workers. Nil is never: every high run goes to both queues.
+	queues urth.HighPriorityQueueSupport
+
 	totalErrors    atomic.Uint64
 	totalScheduled atomic.Uint64
 }
 
+// SchedulerOption configures the scheduler NewScheduler returns.
+type SchedulerOption func(*scheduler)
+
+// WithHighPriorityQueueSupport lets high-priority runs be published to their
+// runner's high queue alone, once support says every worker of the runner
+// pulls it. Without it every high run is published to both queues.
+func WithHighPriorityQueueSupport(support urth.HighPriorityQueueSupport) SchedulerOption {
+	return func(s *scheduler) { s.queues = support }
+}
+
 // NewScheduler connects to NATS and provisions the shared jobs stream.
 //
 // Stream provisioning happens here, at startup, rather than lazily on first
 // dispatch: a misconfigured JetStream should stop an API server from coming up,
 // not surface later as the first scenario run of the day failing.
-func NewScheduler(ctx context.Context, cfg Config) (Transport, error) {
+func NewScheduler(ctx context.Context, cfg Config, options ...SchedulerOption) (Transport, error) {
 	conn, err := cfg.Connect("urth-api-server")
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
@@ -69,7 +84,12 @@ func NewScheduler(ctx context.Context, cfg Config) (Transport, error) {
 		return nil, err
 	}
 
-	return &scheduler{conn: conn, js: js, cfg: cfg}, nil
+	s := &scheduler{conn: conn, js: js, cfg: cfg}
+	for _, option := range options {
+		option(s)
+	}
+
+	return s, nil
 }
 
 // PublishStats implements PublishCounters.
@@ -130,7 +150,7 @@ func DispatchIDFor(uid manifest.ResourceID, version manifest.Version) string {
 // would otherwise have jobs published to a subject nothing is bound to. Calling
 // it on every registration is cheap and idempotent.
 func (s *scheduler) ConnectionInfoFor(ctx context.Context, runnerUID manifest.ResourceID) (urth.NATSConnectionInfo, error) {
-	if _, err := EnsureRunnerConsumer(ctx, s.js, s.cfg, runnerUID); err != nil {
+	if err := ensureRunnerQueues(ctx, s.js, s.cfg, runnerUID); err != nil {
 		return urth.NATSConnectionInfo{}, err
 	}
 
@@ -148,12 +168,15 @@ func (s *scheduler) ConnectionInfoFor(ctx context.Context, runnerUID manifest.Re
 	}
 
 	return urth.NATSConnectionInfo{
-		SchemaVersion:    urth.NATSConnectionInfoVersion,
-		URLs:             strings.Split(s.cfg.URL, ","),
-		Stream:           JobsStreamName,
-		Consumer:         RunnerConsumerName(runnerUID),
-		Subject:          JobSubject(runnerUID),
-		LogSubjectPrefix: RunnerLogSubjectPrefix(runnerUID),
-		Credential:       credential,
+		SchemaVersion:        urth.NATSConnectionInfoVersion,
+		URLs:                 strings.Split(s.cfg.URL, ","),
+		Stream:               JobsStreamName,
+		Consumer:             RunnerConsumerName(runnerUID),
+		Subject:              JobSubject(runnerUID),
+		HighPriorityConsumer: HighPriorityConsumerName(runnerUID),
+		HighPrioritySubject:  HighPriorityJobSubject(runnerUID),
+		LogSubjectPrefix:     RunnerLogSubjectPrefix(runnerUID),
+		CancelSubjectPrefix:  RunnerCancelSubjectPrefix(runnerUID),
+		Credential:           credential,
 	}, nil
 }
@@ -1,5 +1,10 @@
 package prob
 
+import (
+	"context"
+	"io"
+)
+
 type PuppeteerOptions struct {
 	Headless        bool
 	PageWaitSeconds int
@@ -23,4 +28,55 @@ type RunOptions struct {
 	Puppeteer PuppeteerOptions
 	HTTP      HTTPOptions
 	Har       HarOptions
+
+	// Sandbox is how probers that run a script interpreter confine it. Nil is
+	// the least confinement a prober's sandbox offers, not none. See
+	// pkg/runner/sandbox.
+	Sandbox Sandbox
+
+	// Network is the proxy and CAs a probe's traffic goes through: the
+	// runner's settings under the worker's own. A prober applies it through
+	// pkg/runner/egress, and the run records what its registration says it
+	// honours.
+	Network NetworkConfig
+}
+
+// Sandbox runs a prober's script interpreter under limits.
+//
+// An interface rather than the runner's configuration, because the probers'
+// shared types sit below the runner that configures the limits: pkg/runner
+// imports the probers, and the one implementation, pkg/runner/sandbox, is
+// handed in by whoever builds the RunOptions.
+type Sandbox interface {
+	// Run executes the command until it exits or ctx ends.
+	Run(ctx context.Context, command SandboxCommand) error
+}
+
+// SandboxCommand is one process to run in a Sandbox.
+type SandboxCommand struct {
+	// Path is the program. Resolved against the worker's PATH, not the
+	// script's, so a prober finds its interpreter where the worker would.
+	Path string
+	Args []string
+
+	// Dir is the run directory. It must exist; the sandbox makes it the
+	// script's to write to and keeps its private home inside it.
+	Dir string
+
+	// Env is added to the minimal environment. Only what the prober means the
+	// script to have.
+	Env []string
+
+	// Prepare, when set, is given the script's private home once it exists and
+	// before the script starts, to put there what the script's tools look for
+	// in a home: a browser's trust store, for one. What it writes is handed to
+	// the sandbox's user along with the home.
+	Prepare func(ctx context.Context, home string) error
+
+	// Stdin is delivered only once the limits are in place. Anything the
+	// sandbox is meant to contain -- the script itself -- must arrive this way
+	// rather than as an argument.
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
 }
@@ -0,0 +1,86 @@
+package prob
+
+import "net/url"
+
+// NetworkConfig is where a probe's traffic goes and which CAs it trusts, as it
+// travels: in a runner's spec, in the claim that hands it to a worker, and in
+// the RunOptions a prober is given.
+//
+// Only the data lives here. What it means -- choosing a proxy for a target,
+// building a transport, installing a CA for a browser -- is pkg/runner/egress,
+// whose Config carries the same fields with the worker's flag definitions. The
+// two convert into each other directly, so a field added to one must be added
+// to the other. Kept apart because this type is part of the API and of
+// pkg/prob, and neither should depend on the runner, or take its flags.
+//
+// The zero value changes nothing.
+type NetworkConfig struct {
+	// HTTPProxy and HTTPSProxy are the proxies for plain and TLS targets. A
+	// proxy URL may be http, https, socks5 or socks5h; credentials go in its
+	// user info and are never recorded.
+	HTTPProxy  string `form:"httpProxy,omitempty" json:"httpProxy,omitempty" yaml:"httpProxy,omitempty" xml:"httpProxy,omitempty"`
+	HTTPSProxy string `form:"httpsProxy,omitempty" json:"httpsProxy,omitempty" yaml:"httpsProxy,omitempty" xml:"httpsProxy,omitempty"`
+
+	// SOCKSProxy is used for a scheme that has no proxy of its own.
+	SOCKSProxy string `form:"socksProxy,omitempty" json:"socksProxy,omitempty" yaml:"socksProxy,omitempty" xml:"socksProxy,omitempty"`
+
+	// NoProxy lists the targets reached directly: host names, domains
+	// (".example.com"), IP addresses and CIDR ranges, as NO_PROXY takes them.
+	NoProxy []string `form:"noProxy,omitempty" json:"noProxy,omitempty" yaml:"noProxy,omitempty" xml:"noProxy,omitempty"`
+
+	// CAFiles are PEM bundles trusted in addition to the system's roots. A
+	// path in a runner spec names a file on every worker host of the runner.
+	CAFiles []string `form:"caFiles,omitempty" json:"caFiles,omitempty" yaml:"caFiles,omitempty" xml:"caFiles,omitempty"`
+
+	// CACertificates is PEM text trusted like CAFiles, for a runner spec,
+	// which can carry a CA but not put a file on its workers' disks.
+	CACertificates string `form:"caCertificates,omitempty" json:"caCertificates,omitempty" yaml:"caCertificates,omitempty" xml:"caCertificates,omitempty"`
+}
+
+// NetworkHonours says which parts of a NetworkConfig a prober enforces: every
+// probe it runs goes through the proxy, or trusts the CAs, whatever its
+// scenario's script does. A run records only those. Something a prober merely
+// offers -- to a script that may ignore it -- is not honoured.
+type NetworkHonours struct {
+	Proxy bool
+	CAs   bool
+}
+
+// NetworkApplied is the network configuration a run was held to, as it is
+// recorded on the run's Result.
+//
+// Credentials are redacted from the proxy URLs, and the CAs are named by their
+// subjects rather than by where they came from: "trusted Corp Root CA" is what
+// explains a certificate error, whichever file it was read from.
+type NetworkApplied struct {
+	HTTPProxy  string   `json:"httpProxy,omitempty" yaml:"httpProxy,omitempty"`
+	HTTPSProxy string   `json:"httpsProxy,omitempty" yaml:"httpsProxy,omitempty"`
+	SOCKSProxy string   `json:"socksProxy,omitempty" yaml:"socksProxy,omitempty"`
+	NoProxy    []string `json:"noProxy,omitempty" yaml:"noProxy,omitempty"`
+	CAs        []string `json:"cas,omitempty" yaml:"cas,omitempty"`
+}
+
+// Redacted is the configuration with the passwords removed from its proxy URLs,
+// for anywhere it is written down: a run's record, the audit log.
+func (c NetworkConfig) Redacted() NetworkConfig {
+	c.HTTPProxy = RedactProxy(c.HTTPProxy)
+	c.HTTPSProxy = RedactProxy(c.HTTPSProxy)
+	c.SOCKSProxy = RedactProxy(c.SOCKSProxy)
+
+	return c
+}
+
+// RedactProxy removes the password from a proxy URL.
+func RedactProxy(proxy string) string {
+	parsed, err := url.Parse(proxy)
+	if err != nil {
+		// Unparseable, and so possibly holding credentials in a form Redacted
+		// cannot find. Saying nothing is the only safe rendering.
+		if proxy == "" {
+			return ""
+		}
+		return "(invalid proxy URL)"
+	}
+
+	return parsed.Redacted()
+}
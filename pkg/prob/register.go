@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log/slog"
 	"maps"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
@@ -16,6 +17,14 @@ var (
 
 type ScriptRunFn func(ctx context.Context, spec any, config RunOptions, registry *prometheus.Registry, logger *slog.Logger) (RunStatus, []Artifact, error)
 
+// SelfTestFn checks that this host can actually run a prober: that the
+// privilege, binary or runtime it needs is there. It returns nil when it is, and
+// otherwise an error saying what is missing, in words an operator can act on.
+//
+// It must be cheap and side-effect free -- it is run at startup and then again
+// on a timer for as long as the worker lives -- and it must honour ctx.
+type SelfTestFn func(ctx context.Context) error
+
 type ProbRegistration struct {
 	// Function to execute a script
 	RunFunc ScriptRunFn
@@ -28,6 +37,16 @@ type ProbRegistration struct {
 
 	// Types of artifacts this prob is expected to produce
 	Produce []string
+
+	// SelfTest is optional. A prober without one is taken to work wherever it
+	// is linked, which is true of the probers that only open sockets a process
+	// may always open.
+	SelfTest SelfTestFn
+
+	// Egress says which of RunOptions.Network this prober enforces, and so what
+	// is recorded on its runs. The zero value is a prober that opens its own
+	// sockets and goes through no proxy, which is right for tcp, dns and icmp.
+	Egress NetworkHonours
 }
 
 // Registrar of Probing modules
@@ -72,3 +91,34 @@ func FindRunFunc(kind Kind) (ScriptRunFn, bool) {
 	result, ok := kindRunnerMap[kind]
 	return result.RunFunc, ok
 }
+
+// SelfTestTimeout bounds a single prober's self-test. A check that hangs -- a
+// runtime that never answers -- is as much a failure as one that errors, and
+// must not hold the rest up.
+const SelfTestTimeout = 30 * time.Second
+
+// SelfTest runs every registered prober's self-test and returns the kinds that
+// failed, with why. An empty result means every linked prober can run here.
+//
+// Linking a prober is a statement about the binary, not about the host it was
+// started on: an icmp prober without CAP_NET_RAW, or a puppeteer prober on a
+// host without node, is still registered. This is what tells the two apart.
+func SelfTest(ctx context.Context) map[Kind]error {
+	failures := make(map[Kind]error)
+
+	for kind, registration := range ListProbs() {
+		if registration.SelfTest == nil {
+			continue
+		}
+
+		testCtx, cancel := context.WithTimeout(ctx, SelfTestTimeout)
+		err := registration.SelfTest(testCtx)
+		cancel()
+
+		if err != nil {
+			failures[kind] = err
+		}
+	}
+
+	return failures
+}
@@ -22,6 +22,11 @@ const (
 	RunFinishedError    RunStatus = "errored"
 	RunFinishedCanceled RunStatus = "canceled"
 	RunFinishedTimeout  RunStatus = "timeout"
+
+	// RunFinishedDegraded is never returned by a prober. The server assigns it
+	// to a run that succeeded but took anomalously long, when the scenario asks
+	// for slow runs to be told apart from fast ones; see urth.LatencyPolicy.
+	RunFinishedDegraded RunStatus = "degraded"
 )
 
 type Manifest struct {
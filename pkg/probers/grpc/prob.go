@@ -12,6 +12,7 @@ import (
 	"github.com/prometheus/blackbox_exporter/prober"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/urth/pkg/runner/egress"
 	"github.com/sre-norns/wyrd/pkg/manifest"
 )
 
@@ -39,6 +40,10 @@ func init() {
 			RunFunc:     RunScript,
 			ContentType: ScriptMimeType,
 			Version:     moduleVersion,
+			// CAs only. grpc-go takes its proxy from the process environment
+			// and nowhere else, and blackbox gives no way to pass it a dialer;
+			// the worker's environment is the worker's, not the probe's.
+			Egress: prob.NetworkHonours{CAs: true},
 		},
 	)
 }
@@ -53,7 +58,15 @@ func RunScript(ctx context.Context, probSpec any, config prob.RunOptions, regist
 		return prob.RunFinishedError, nil, prob.ErrNoTarget
 	}
 
-	if success := prober.ProbeGRPC(ctx, spec.Target, bxconfig.Module{GRPC: spec.GRPC}, registry, logger); !success {
+	module := bxconfig.Module{GRPC: spec.GRPC}
+	if module.GRPC.TLS {
+		if err := egress.FromNetwork(config.Network).ApplyTLS(&module.GRPC.TLSConfig); err != nil {
+			logger.Error("Failed to apply the network configuration", "err", err)
+			return prob.RunFinishedError, nil, err
+		}
+	}
+
+	if success := prober.ProbeGRPC(ctx, spec.Target, module, registry, logger); !success {
 		return prob.RunFinishedFailed, nil, nil
 	}
 
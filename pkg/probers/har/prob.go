@@ -39,6 +39,7 @@ func init() {
 			RunFunc:     RunScript,
 			ContentType: ScriptMimeType,
 			Version:     moduleVersion,
+			Egress:      prob.NetworkHonours{Proxy: true, CAs: true},
 		})
 }
 
@@ -12,6 +12,7 @@ import (
 	"github.com/prometheus/blackbox_exporter/prober"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/urth/pkg/runner/egress"
 	"github.com/sre-norns/wyrd/pkg/manifest"
 )
 
@@ -39,6 +40,7 @@ func init() {
 			RunFunc:     RunScript,
 			ContentType: ScriptMimeType,
 			Version:     moduleVersion,
+			Egress:      prob.NetworkHonours{Proxy: true, CAs: true},
 		},
 	)
 }
@@ -53,7 +55,13 @@ func RunScript(ctx context.Context, probSpec any, config prob.RunOptions, regist
 		return prob.RunFinishedError, nil, prob.ErrNoTarget
 	}
 
-	if success := prober.ProbeHTTP(ctx, spec.Target, bxconfig.Module{HTTP: spec.HTTP}, registry, logger); !success {
+	module := bxconfig.Module{HTTP: spec.HTTP}
+	if err := egress.FromNetwork(config.Network).ApplyHTTPClient(&module.HTTP.HTTPClientConfig, spec.Target); err != nil {
+		logger.Error("Failed to apply the network configuration", "err", err)
+		return prob.RunFinishedError, nil, err
+	}
+
+	if success := prober.ProbeHTTP(ctx, spec.Target, module, registry, logger); !success {
 		return prob.RunFinishedFailed, nil, nil
 	}
 
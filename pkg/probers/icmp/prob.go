@@ -2,9 +2,11 @@ package icmp
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"reflect"
+	"runtime"
 	"runtime/debug"
 	"strings"
 
@@ -13,6 +15,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sre-norns/urth/pkg/prob"
 	"github.com/sre-norns/wyrd/pkg/manifest"
+	"golang.org/x/net/icmp"
 )
 
 const (
@@ -39,6 +42,7 @@ func init() {
 			RunFunc:     RunScript,
 			ContentType: ScriptMimeType,
 			Version:     moduleVersion,
+			SelfTest:    SelfTest,
 		},
 	)
 }
@@ -59,3 +63,29 @@ func RunScript(ctx context.Context, probSpec any, config prob.RunOptions, regist
 
 	return prob.RunFinishedSuccess, nil, nil
 }
+
+// SelfTest checks that this process can open an ICMP socket the way the prober
+// will: unprivileged first where the platform has such a thing, raw otherwise.
+//
+// Neither is a given. The unprivileged kind needs the process's group inside
+// net.ipv4.ping_group_range, which many distributions leave empty, and the raw
+// kind needs CAP_NET_RAW, which a container drops by default. A worker with
+// neither fails every ping it is given, so it must not claim to ping.
+func SelfTest(context.Context) error {
+	var problems []error
+
+	networks := []string{"ip4:icmp"}
+	if runtime.GOOS == "linux" || runtime.GOOS == "darwin" {
+		networks = []string{"udp4", "ip4:icmp"}
+	}
+
+	for _, network := range networks {
+		conn, err := icmp.ListenPacket(network, "0.0.0.0")
+		if err == nil {
+			return conn.Close()
+		}
+		problems = append(problems, fmt.Errorf("%s: %w", network, err))
+	}
+
+	return fmt.Errorf("cannot open an ICMP socket; grant CAP_NET_RAW or widen net.ipv4.ping_group_range: %w", errors.Join(problems...))
+}
@@ -2,6 +2,8 @@ package puppeteer
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -15,6 +17,8 @@ import (
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/urth/pkg/runner/egress"
+	"github.com/sre-norns/urth/pkg/runner/sandbox"
 	"github.com/sre-norns/wyrd/pkg/manifest"
 )
 
@@ -47,9 +51,74 @@ func init() {
 			RunFunc:     RunScript,
 			ContentType: ScriptMimeType,
 			Version:     moduleVersion,
+			SelfTest:    SelfTest,
+			// The CAs are installed into the browser's trust store, which holds
+			// whatever the script does. The proxy is only offered to the
+			// script, which decides whether its browser uses it; see
+			// networkEnv.
+			Egress: prob.NetworkHonours{CAs: true},
 		})
 }
 
+// caBundleFile is where a run's extra CAs are written for node to read. It is
+// the worker's configuration rather than anything the script made, so it is
+// not captured as an artifact.
+const caBundleFile = "urth-ca.pem"
+
+// networkEnv is how the network configuration reaches a script.
+//
+// The script launches the browser, not the prober, so the proxy is handed over
+// as Chromium launch flags in URTH_PUPPETEER_ARGS, a JSON array for the script
+// to pass on:
+//
+//	puppeteer.launch({args: JSON.parse(process.env.URTH_PUPPETEER_ARGS || "[]")})
+//
+// A script that does not pass them on goes direct, as every script did before
+// they existed -- which is why a run does not record the proxy as applied.
+//
+// The CAs do not depend on the script. node itself -- a fetch made by the
+// script -- trusts them through NODE_EXTRA_CA_CERTS, and the browser through
+// the trust store installed in the script's home; see trustStore.
+func networkEnv(network egress.Config, workDir string) ([]string, error) {
+	var env []string
+
+	if args := network.ChromeArgs(); len(args) > 0 {
+		encoded, err := json.Marshal(args)
+		if err != nil {
+			return nil, err
+		}
+		env = append(env, "URTH_PUPPETEER_ARGS="+string(encoded))
+	}
+
+	bundle, err := network.CAPEM()
+	if err != nil {
+		return nil, err
+	}
+	if len(bundle) > 0 {
+		file := filepath.Join(workDir, caBundleFile)
+		if err := os.WriteFile(file, bundle, 0o644); err != nil {
+			return nil, fmt.Errorf("failed to write the CA bundle for the script: %w", err)
+		}
+		env = append(env, "NODE_EXTRA_CA_CERTS="+file)
+	}
+
+	return env, nil
+}
+
+// trustStore installs the run's CAs where the browser the script launches will
+// trust them: an NSS database in the script's private home. A run that has CAs
+// and cannot install them fails, rather than going on to fail every TLS check
+// against an intercepting proxy for a reason its result would not show.
+func trustStore(network egress.Config) func(context.Context, string) error {
+	return func(ctx context.Context, home string) error {
+		if err := network.InstallNSS(ctx, home); err != nil {
+			return fmt.Errorf("failed to install the run's CAs for the browser: %w", err)
+		}
+
+		return nil
+	}
+}
+
 func setupNodeDir(dir string) error {
 	cmd := exec.Command("npm", "init", "-y")
 	cmd.Dir = dir
@@ -112,47 +181,65 @@ func RunScript(ctx context.Context, probSpec any, config prob.RunOptions, regist
 	}(workDir, config.Puppeteer.KeepTempDir)
 	logger.Info("Working directory configured", "dir", workDir, "keep", config.Puppeteer.KeepTempDir)
 
-	cmd := exec.CommandContext(ctx, "node", "-")
-	// cmd.Env = append(cmd.Env, fmt.Sprintf("PUPPETEER_CACHE_DIR=%v", options.Puppeteer.WorkingDirectory))
-
-	// FIXME: Breaks on latest version of puppeteer
-	// hasDisplay := os.Getenv("DISPLAY")
-	// if hasDisplay != "" {
-	// 	cmd.Env = append(cmd.Env, fmt.Sprintf("DISPLAY=%v", hasDisplay))
-	// }
-
-	cmd.Env = append(cmd.Env, fmt.Sprintf("URTH_PUPPETEER_HEADLESS=%t", config.Puppeteer.Headless))
+	// The script runs in the sandbox: node gets a minimal environment rather
+	// than the worker's, which holds its enrolment token, and the script is
+	// delivered on stdin only once the limits are in place.
+	env := []string{fmt.Sprintf("URTH_PUPPETEER_HEADLESS=%t", config.Puppeteer.Headless)}
 	if config.Puppeteer.PageWaitSeconds != 0 {
-		cmd.Env = append(cmd.Env, fmt.Sprintf("URTH_PUPPETEER_PAGE_WAIT=%d", config.Puppeteer.PageWaitSeconds))
+		env = append(env, fmt.Sprintf("URTH_PUPPETEER_PAGE_WAIT=%d", config.Puppeteer.PageWaitSeconds))
 	}
 
-	cmd.Dir = workDir
+	// Puppeteer finds its browser relative to HOME unless told otherwise, and
+	// the sandbox gives the script a HOME of its own. Point it at the cache the
+	// browser was installed into, which is the worker's.
+	if cacheDir := browserCacheDir(); cacheDir != "" {
+		env = append(env, "PUPPETEER_CACHE_DIR="+cacheDir)
+	}
 
-	inPipe, err := cmd.StdinPipe()
+	network := egress.FromNetwork(config.Network)
+	networkEnv, err := networkEnv(network, workDir)
 	if err != nil {
-		err := fmt.Errorf("failed to open input pipe: %w", err)
-		logger.Error("Creating STD IN Pipe", "err", err)
-		return prob.RunFinishedError, nil, nil
+		logger.Error("Failed to apply the network configuration", "err", err)
+
+		return prob.RunFinishedError, nil, err
 	}
+	env = append(env, networkEnv...)
 
-	// TODO: Write common prolog for all scrips
-	go func() {
-		defer inPipe.Close()
-		n, err := inPipe.Write([]byte(spec.Script))
-		if err != nil {
-			logger.Error("failed to write script into the nodejs input pipe", "err", err)
-		}
-		logger.Info("Script loaded", "bytes", n)
-	}()
+	// FIXME: Breaks on latest version of puppeteer
+	// hasDisplay := os.Getenv("DISPLAY")
+	// if hasDisplay != "" {
+	// 	env = append(env, fmt.Sprintf("DISPLAY=%v", hasDisplay))
+	// }
 
 	// TODO: Capture artifacts and store HAR file
 	runResult := prob.RunFinishedSuccess
 
-	cmd.Stderr = slog.NewLogLogger(logger.Handler(), slog.LevelError).Writer()
-	cmd.Stdout = slog.NewLogLogger(logger.Handler(), slog.LevelInfo).Writer()
+	logger.Info("Script loaded", "bytes", len(spec.Script))
+	box := config.Sandbox
+	if box == nil {
+		box = sandbox.Config{}
+	}
 
-	// Run the proces
-	if err := cmd.Run(); err != nil {
+	err = box.Run(ctx, sandbox.Command{
+		Path:    "node",
+		Args:    []string{"-"},
+		Dir:     workDir,
+		Env:     env,
+		Prepare: trustStore(network),
+		Stdin:   strings.NewReader(spec.Script),
+		Stderr:  slog.NewLogLogger(logger.Handler(), slog.LevelError).Writer(),
+		Stdout:  slog.NewLogLogger(logger.Handler(), slog.LevelInfo).Writer(),
+	})
+
+	var violation error
+	switch {
+	case errors.Is(err, sandbox.ErrViolation):
+		// The script was stopped, not failed: say which limit it hit, and
+		// return it so the run's record carries the reason.
+		logger.Error("Script stopped by the sandbox", "reason", err)
+		runResult = prob.RunFinishedError
+		violation = err
+	case err != nil:
 		logger.Error("Failed to execute command", "err", err)
 		runResult = prob.RunFinishedError
 	}
@@ -164,7 +251,7 @@ func RunScript(ctx context.Context, probSpec any, config prob.RunOptions, regist
 		logger.Error("Failed to open working directory (No artifacts can be captured)", "err", err)
 	} else {
 		for _, entry := range workDirEntries {
-			if entry.Name() == "node_modules" || entry.Name() == "package.json" {
+			if entry.Name() == "node_modules" || entry.Name() == "package.json" || entry.Name() == caBundleFile {
 				continue
 			}
 
@@ -192,5 +279,66 @@ func RunScript(ctx context.Context, probSpec any, config prob.RunOptions, regist
 		}
 	}
 
-	return runResult, artifacts, nil
+	return runResult, artifacts, violation
+}
+
+// browserCacheDir is where `npm install puppeteer` put the browser when the
+// worker installed it: PUPPETEER_CACHE_DIR if the worker has one, and
+// otherwise puppeteer's default under the worker's home.
+func browserCacheDir() string {
+	if dir := os.Getenv("PUPPETEER_CACHE_DIR"); dir != "" {
+		return dir
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+
+	return filepath.Join(home, ".cache", "puppeteer")
+}
+
+// browserExecutables are where puppeteer's installer puts each browser it
+// knows, relative to its cache directory: browser, then build, then platform.
+var browserExecutables = []string{
+	filepath.Join("chrome", "*", "*", "chrome"),
+	filepath.Join("chrome-headless-shell", "*", "*", "chrome-headless-shell"),
+}
+
+// SelfTest checks that this host can run a script: node and npm answer, and a
+// browser puppeteer has already installed can start.
+//
+// A host with no browser installed yet passes. The first run installs one, and
+// refusing runs until something installed it would mean it never was. A browser
+// that is there but cannot start -- shared libraries the image never had are
+// the usual reason -- fails every run it is given, and that is what this is for.
+func SelfTest(ctx context.Context) error {
+	for _, tool := range []string{"node", "npm"} {
+		if out, err := exec.CommandContext(ctx, tool, "--version").CombinedOutput(); err != nil {
+			return fmt.Errorf("%s does not run: %w: %s", tool, err, strings.TrimSpace(string(out)))
+		}
+	}
+
+	cacheDir := browserCacheDir()
+	if cacheDir == "" {
+		return nil
+	}
+
+	var problems []error
+	for _, pattern := range browserExecutables {
+		browsers, _ := filepath.Glob(filepath.Join(cacheDir, pattern))
+		for _, browser := range browsers {
+			out, err := exec.CommandContext(ctx, browser, "--version").CombinedOutput()
+			if err == nil {
+				return nil
+			}
+			problems = append(problems, fmt.Errorf("%s: %w: %s", browser, err, strings.TrimSpace(string(out))))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("no installed browser starts: %w", errors.Join(problems...))
+	}
+
+	return nil
 }
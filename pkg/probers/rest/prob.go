@@ -18,6 +18,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	httpparser "github.com/sre-norns/urth/pkg/http-parser"
 	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/urth/pkg/runner/egress"
 	"github.com/sre-norns/wyrd/pkg/manifest"
 
 	"github.com/google/martian/har"
@@ -47,6 +48,7 @@ func init() {
 			RunFunc:     RunScript,
 			ContentType: ScriptMimeType,
 			Version:     moduleVersion,
+			Egress:      prob.NetworkHonours{Proxy: true, CAs: true},
 		})
 }
 
@@ -220,6 +222,14 @@ func RunHTTPRequests(ctx context.Context, requests []httpparser.TestRequest, opt
 
 	outcome := prob.RunFinishedSuccess
 	client := http.Client{}
+	if network := egress.FromNetwork(options.Network); !network.IsZero() {
+		transport, err := network.Transport(http.DefaultTransport.(*http.Transport))
+		if err != nil {
+			logger.Error("Failed to apply the network configuration", "err", err)
+			return prob.RunFinishedError, nil, nil
+		}
+		client.Transport = transport
+	}
 	tracer := newHTTPRequestTracer(logger)
 
 	for i, req := range requests {
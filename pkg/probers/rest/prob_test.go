@@ -166,6 +166,27 @@ func TestHarArtifactDeclaresItselfSecretBearing(t *testing.T) {
 	require.True(t, har.DataClass.MayContainSecrets())
 }
 
+// A script's requests go through the configured proxy, not directly to the
+// host they name.
+func TestRequestsGoThroughTheConfiguredProxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	req, err := http.NewRequest(http.MethodGet, "http://probed.example/health", nil)
+	require.NoError(t, err)
+
+	outcome, _, err := RunHTTPRequests(t.Context(), []httpparser.TestRequest{{Request: req}},
+		prob.RunOptions{Network: prob.NetworkConfig{HTTPProxy: proxy.URL}}, discardLogger())
+	require.NoError(t, err)
+
+	require.Equal(t, prob.RunFinishedSuccess, outcome)
+	require.Equal(t, []string{"http://probed.example/health"}, proxied)
+}
+
 func findArtifact(t *testing.T, artifacts []prob.Artifact, rel string) prob.Artifact {
 	t.Helper()
 
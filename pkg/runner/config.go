@@ -1,16 +1,21 @@
 package runner
 
 import (
+	"context"
 	"fmt"
 	"log"
+	"maps"
 	"os"
 	"os/exec"
 	"runtime"
 	"runtime/debug"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/urth/pkg/runner/egress"
+	"github.com/sre-norns/urth/pkg/runner/sandbox"
 	"github.com/sre-norns/urth/pkg/urth"
 	"github.com/sre-norns/wyrd/pkg/manifest"
 	"golang.org/x/mod/semver"
@@ -29,10 +34,26 @@ const (
 
 type RunnerConfig struct {
 	systemLabels manifest.Labels `kong:"-"`
+
+	// selfTest is what the probers' self-tests found when the configuration was
+	// built, kept so the worker can start from the same results its labels were
+	// made from rather than testing the host a second time. Nil on a
+	// configuration built any other way; see SelfTestFailures.
+	selfTest *selfTestRun `kong:"-"`
+
 	CustomLabels manifest.Labels `help:"Extra labels to identify this instance of the runner"`
 
 	WorkingDirectory string        `help:"Worker directory where test are executed" default:"./worker" type:"existingdir"`
 	Timeout          time.Duration `help:"Maximum duration alloted for each script run" default:"1m"`
+
+	// Sandbox confines the probers that run a script. Part of the runner
+	// configuration rather than the worker's, so both worker commands take the
+	// same flags.
+	Sandbox sandbox.Config `embed:"" prefix:"sandbox."`
+
+	// Network is the proxy and extra CAs probes go through from this host. A
+	// worker applies it over its runner's settings; see egress.Config.
+	Network egress.Config `embed:"" prefix:"network."`
 }
 
 func GetNodeRuntimeLabels() manifest.Labels {
@@ -115,26 +136,71 @@ func (c *RunnerConfig) GetEffectiveLabels() manifest.Labels {
 }
 
 func NewDefaultConfig() RunnerConfig {
+	selfTest := testProbers()
+
 	return RunnerConfig{
 		systemLabels: manifest.MergeLabels(
 			GetRuntimeLabels(),
 			GetNodeRuntimeLabels(),
 			GetPythonRuntimeLabels(),
-			ProberAsLabels(),
+			capableProberLabels(selfTest.failures),
 		),
+		selfTest: selfTest,
 	}
 }
 
+// selfTestRun is one run of the probers' self-tests.
+type selfTestRun struct {
+	failures map[prob.Kind]error
+}
+
+// SelfTestFailures returns the kinds that failed their self-test when the
+// configuration was built, with why, and whether the tests were run then at
+// all. A browser that takes seconds to start is tested once at startup, not
+// once for the labels and again for the worker.
+func (c *RunnerConfig) SelfTestFailures() (map[prob.Kind]error, bool) {
+	if c.selfTest == nil {
+		return nil, false
+	}
+
+	return c.selfTest.failures, true
+}
+
+// testProbers runs the self-tests and logs each kind that will not be
+// advertised.
+func testProbers() *selfTestRun {
+	failures := prob.SelfTest(context.Background())
+	for _, kind := range slices.Sorted(maps.Keys(failures)) {
+		log.Printf("not advertising prob kind %q: its self-test failed: %v", kind, failures[kind])
+	}
+
+	return &selfTestRun{failures: failures}
+}
+
 func kindAsLabel(kind prob.Kind) string {
 	return fmt.Sprintf("%v%v", urth.LabelWorkerCapProbPrefix, kind)
 }
 
-// ProberAsLabels exposes the loaded probers as labels, so that a worker can
-// advertise which prob kinds it is capable of running.
+// ProberAsLabels exposes the probers that can run here as labels, so that a
+// worker advertises which prob kinds it is capable of running.
+//
+// Capable, not merely linked: each prober's self-test is run first, and a kind
+// that fails it is left out and logged. A worker that advertised icmp without
+// being able to open an ICMP socket would be sent pings only to fail them.
 func ProberAsLabels() manifest.Labels {
+	return capableProberLabels(testProbers().failures)
+}
+
+// capableProberLabels labels every registered prober except the kinds in
+// failures.
+func capableProberLabels(failures map[prob.Kind]error) manifest.Labels {
 	probs := prob.ListProbs()
 	result := make(manifest.Labels, len(probs))
 	for kind, prob := range probs {
+		if _, failed := failures[kind]; failed {
+			continue
+		}
+
 		// A prober's version comes from the build's VCS state, which is not
 		// constrained to the label grammar: a binary built from a dirty tree
 		// reports "...+dirty", and the '+' makes the whole worker registration
@@ -1,12 +1,14 @@
 package runner
 
 import (
+	"errors"
 	"os"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 
+	"github.com/sre-norns/urth/pkg/prob"
 	"github.com/sre-norns/urth/pkg/urth"
 	"github.com/sre-norns/wyrd/pkg/manifest"
 )
@@ -31,6 +33,15 @@ func TestWorkerLabelsAreValid(t *testing.T) {
 	}
 }
 
+// A prober that fails its self-test is linked but not advertised, so a worker
+// stops claiming a kind it would only fail.
+func TestFailingProbersAreNotAdvertised(t *testing.T) {
+	labels := capableProberLabels(map[prob.Kind]error{"icmp": errors.New("no CAP_NET_RAW")})
+
+	require.NotContains(t, labels, kindAsLabel("icmp"))
+	require.Contains(t, labels, kindAsLabel("http"), "a passing prober is still advertised")
+}
+
 func TestRuntimeLabelsAdvertiseHostname(t *testing.T) {
 	hostname, err := os.Hostname()
 	require.NoError(t, err)
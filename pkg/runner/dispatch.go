@@ -9,6 +9,7 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/urth/pkg/runner/egress"
 	"github.com/sre-norns/urth/pkg/urth"
 
 	_ "github.com/sre-norns/urth/pkg/probers/dns"
@@ -107,5 +108,8 @@ func Play(ctx context.Context, probSpec prob.Manifest, options prob.RunOptions,
 
 	artifacts = append(artifacts, logger.ToArtifact())
 
-	return urth.NewRunResults(result), artifacts, err
+	status := urth.NewRunResults(result)
+	status.Network = egress.FromNetwork(options.Network).Applied(prob.ListProbs()[probSpec.Kind].Egress)
+
+	return status, artifacts, err
 }
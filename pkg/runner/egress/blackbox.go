@@ -0,0 +1,99 @@
+package egress
+
+import (
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+
+	pconfig "github.com/prometheus/common/config"
+)
+
+// The http and grpc probers hand their work to blackbox_exporter, which builds
+// its own client from the scenario's module. The configuration is applied to
+// that module instead of to a transport, and only where the scenario has said
+// nothing: a scenario that names its own proxy or CA is explicit about the
+// target it probes, and that beats a default for the whole fleet.
+
+// systemBundles are where Linux distributions keep the system's roots, in the
+// order crypto/x509 looks for them.
+var systemBundles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/ca-bundle.pem",
+	"/etc/pki/tls/cacert.pem",
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem",
+	"/etc/ssl/cert.pem",
+}
+
+// RootsPEM returns the system's roots and c's CAs as one PEM bundle, or nil
+// when c adds no CAs.
+//
+// Blackbox takes a CA as the *only* root it trusts, so the extra CAs alone
+// would stop every public site verifying. The system's bundle is read from
+// where crypto/x509 would find it; on a host with none, the extra CAs are all a
+// blackbox probe trusts.
+func (c Config) RootsPEM() ([]byte, error) {
+	extra, err := c.CAPEM()
+	if err != nil || len(extra) == 0 {
+		return nil, err
+	}
+
+	candidates := systemBundles
+	if file := os.Getenv("SSL_CERT_FILE"); file != "" {
+		candidates = append([]string{file}, candidates...)
+	}
+
+	for _, file := range candidates {
+		system, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+
+		return append(append(bytes.TrimRight(system, "\n"), '\n'), extra...), nil
+	}
+
+	return extra, nil
+}
+
+// ApplyTLS adds c's CAs to a blackbox TLS config that names no CA of its own.
+func (c Config) ApplyTLS(tls *pconfig.TLSConfig) error {
+	if tls.CA != "" || tls.CAFile != "" || tls.CARef != "" {
+		return nil
+	}
+
+	roots, err := c.RootsPEM()
+	if err != nil {
+		return err
+	}
+	tls.CA = string(roots)
+
+	return nil
+}
+
+// ApplyHTTPClient applies c to a blackbox HTTP client config for a probe of
+// target: the proxy the target goes through, unless the scenario chose its
+// own, and the CAs.
+//
+// Blackbox takes one proxy URL, not a rule, so the choice is made here once,
+// for the target. A redirect to a host c would treat differently goes through
+// the same proxy.
+func (c Config) ApplyHTTPClient(client *pconfig.HTTPClientConfig, target string) error {
+	scenarioProxy := client.ProxyURL.URL != nil || client.ProxyFromEnvironment
+	if c.hasProxy() && !scenarioProxy {
+		targetURL, err := url.Parse(target)
+		if err != nil {
+			return fmt.Errorf("failed to parse target to choose a proxy for it: %w", err)
+		}
+
+		proxy, err := c.ProxyFor(targetURL)
+		if err != nil {
+			return fmt.Errorf("failed to choose a proxy for the target: %w", err)
+		}
+		if proxy != nil {
+			client.ProxyURL = pconfig.URL{URL: proxy}
+		}
+	}
+
+	return c.ApplyTLS(&client.TLSConfig)
+}
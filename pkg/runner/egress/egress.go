@@ -0,0 +1,404 @@
+// Package egress is how a probe leaves the network it runs in: through which
+// proxy, and trusting which certificate authorities besides the system's.
+//
+// A worker inside a corporate network rarely reaches the sites it probes
+// directly. Its traffic goes through an HTTP or SOCKS proxy, and TLS to the
+// outside is often intercepted by that proxy and re-signed with a private CA.
+// Until now the probers knew neither: the rest and har probers used a bare
+// net/http client, blackbox took whatever the scenario's own module said, and
+// a browser started by a puppeteer script went wherever its defaults took it.
+// The proxy variables in the worker's environment were no answer either --
+// they would have sent the worker's own calls to the API through the proxy
+// too, and a script's environment is deliberately not the worker's.
+//
+// So the configuration is explicit, in two places. A runner's spec says what
+// its fleet's network needs, because that is usually a property of where the
+// runner's workers are deployed; a worker's flags say what its host needs, and
+// win where they disagree, because the host is the one that knows. The
+// probers that make HTTP requests apply the result, and the run records what
+// was applied, so a failure can be put down to the proxy rather than to the
+// site.
+//
+// It is a subpackage rather than part of pkg/runner for the reason
+// pkg/runner/sandbox is: the probers use it and pkg/runner imports the probers.
+package egress
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"golang.org/x/net/http/httpproxy"
+
+	"github.com/sre-norns/urth/pkg/prob"
+)
+
+// Config is where a probe's traffic goes and which CAs it trusts.
+//
+// The kong tags are flag definitions: RunnerConfig embeds this with the
+// `network.` prefix. The settings travel -- in a runner's spec, a claim, a
+// prober's RunOptions -- as prob.NetworkConfig, which has the same fields and
+// converts to and from this directly; see FromNetwork. The zero value changes
+// nothing, and a prober given it behaves exactly as it did before this existed.
+type Config struct {
+	// HTTPProxy and HTTPSProxy are the proxies for plain and TLS targets. A
+	// proxy URL may be http, https, socks5 or socks5h; credentials go in its
+	// user info and are never recorded.
+	HTTPProxy  string `name:"http-proxy" help:"Proxy for probes of http:// targets" placeholder:"URL"`
+	HTTPSProxy string `name:"https-proxy" help:"Proxy for probes of https:// targets" placeholder:"URL"`
+
+	// SOCKSProxy is used for a scheme that has no proxy of its own, which makes
+	// it the one setting a network with only a SOCKS gateway needs.
+	SOCKSProxy string `name:"socks-proxy" help:"SOCKS5 proxy for probes of targets with no proxy of their own, e.g. socks5://gateway:1080" placeholder:"URL"`
+
+	// NoProxy lists the targets reached directly: host names, domains
+	// (".example.com"), IP addresses and CIDR ranges, as NO_PROXY takes them.
+	// Loopback targets are never proxied.
+	NoProxy []string `name:"no-proxy" help:"Hosts, domains and CIDR ranges probed directly rather than through the proxy" placeholder:"HOST"`
+
+	// CAFiles are PEM bundles trusted in addition to the system's roots, read
+	// when a probe runs. A path in a runner spec names a file on every worker
+	// host of the runner.
+	CAFiles []string `name:"ca-file" help:"PEM file of CA certificates to trust in addition to the system's" placeholder:"FILE"`
+
+	// CACertificates is PEM text trusted like CAFiles. Meant for a runner spec,
+	// which can carry a CA but not put a file on its workers' disks; a flag
+	// naming a file does that better on the host, so it is not one.
+	CACertificates string `kong:"-"`
+}
+
+// FromNetwork returns the configuration a runner spec, a claim or a prober's
+// RunOptions carries, ready to apply.
+func FromNetwork(network prob.NetworkConfig) Config {
+	return Config(network)
+}
+
+// Network returns c as it travels.
+func (c Config) Network() prob.NetworkConfig {
+	return prob.NetworkConfig(c)
+}
+
+// proxySchemes are the proxy URL schemes net/http can dial through.
+var proxySchemes = []string{"http", "https", "socks5", "socks5h"}
+
+// IsZero reports whether c changes nothing.
+func (c Config) IsZero() bool {
+	return !c.hasProxy() && !c.hasCAs()
+}
+
+func (c Config) hasProxy() bool {
+	return c.HTTPProxy != "" || c.HTTPSProxy != "" || c.SOCKSProxy != ""
+}
+
+func (c Config) hasCAs() bool {
+	return len(c.CAFiles) > 0 || strings.TrimSpace(c.CACertificates) != ""
+}
+
+// WithDefaults fills what c leaves unset from defaults: the runner's settings
+// under a worker's own.
+//
+// Each proxy setting is taken as a whole, the worker's where it has one. The
+// CAs add up instead: a CA is trusted for a reason, and a worker adding one
+// for its own host has no cause to stop trusting the fleet's.
+func (c Config) WithDefaults(defaults Config) Config {
+	merged := c
+
+	if merged.HTTPProxy == "" {
+		merged.HTTPProxy = defaults.HTTPProxy
+	}
+	if merged.HTTPSProxy == "" {
+		merged.HTTPSProxy = defaults.HTTPSProxy
+	}
+	if merged.SOCKSProxy == "" {
+		merged.SOCKSProxy = defaults.SOCKSProxy
+	}
+	if len(merged.NoProxy) == 0 {
+		merged.NoProxy = defaults.NoProxy
+	}
+
+	merged.CAFiles = append(slices.Clone(defaults.CAFiles), c.CAFiles...)
+	if strings.TrimSpace(defaults.CACertificates) != "" {
+		merged.CACertificates = strings.TrimSpace(defaults.CACertificates + "\n" + c.CACertificates)
+	}
+
+	return merged
+}
+
+// Validate checks the proxy URLs and that every CA given can be read, so a
+// mistake stops the worker at startup rather than failing every run.
+func (c Config) Validate() error {
+	var problems []error
+
+	for _, proxy := range []struct{ flag, value string }{
+		{"--network.http-proxy", c.HTTPProxy},
+		{"--network.https-proxy", c.HTTPSProxy},
+		{"--network.socks-proxy", c.SOCKSProxy},
+	} {
+		if proxy.value == "" {
+			continue
+		}
+		if err := validateProxy(proxy.value); err != nil {
+			problems = append(problems, fmt.Errorf("%s: %w", proxy.flag, err))
+		}
+	}
+
+	if _, err := c.certificates(); err != nil {
+		problems = append(problems, err)
+	}
+
+	return errors.Join(problems...)
+}
+
+func validateProxy(value string) error {
+	// The parse error is not passed on: it quotes the URL, password and all.
+	proxy, err := url.Parse(value)
+	if err != nil {
+		return errors.New("not a valid URL")
+	}
+	if !slices.Contains(proxySchemes, proxy.Scheme) || proxy.Host == "" {
+		return fmt.Errorf("%q is not a proxy URL: want scheme://host:port with a scheme of %s", prob.RedactProxy(value), strings.Join(proxySchemes, ", "))
+	}
+
+	return nil
+}
+
+// ProxyFunc returns the proxy selection for an http.Transport, or nil when c
+// names no proxy and a transport should keep the one it has.
+func (c Config) ProxyFunc() func(*http.Request) (*url.URL, error) {
+	if !c.hasProxy() {
+		return nil
+	}
+
+	proxyFor := c.proxyForURL()
+	return func(request *http.Request) (*url.URL, error) {
+		return proxyFor(request.URL)
+	}
+}
+
+// ProxyFor returns the proxy a request to target goes through, or nil when it
+// goes directly.
+func (c Config) ProxyFor(target *url.URL) (*url.URL, error) {
+	if !c.hasProxy() {
+		return nil, nil
+	}
+
+	return c.proxyForURL()(target)
+}
+
+func (c Config) proxyForURL() func(*url.URL) (*url.URL, error) {
+	orSOCKS := func(proxy string) string {
+		if proxy == "" {
+			return c.SOCKSProxy
+		}
+		return proxy
+	}
+
+	return (&httpproxy.Config{
+		HTTPProxy:  orSOCKS(c.HTTPProxy),
+		HTTPSProxy: orSOCKS(c.HTTPSProxy),
+		NoProxy:    strings.Join(c.NoProxy, ","),
+	}).ProxyFunc()
+}
+
+// Transport returns a copy of base that applies c. base is not modified.
+func (c Config) Transport(base *http.Transport) (*http.Transport, error) {
+	transport := base.Clone()
+
+	if proxy := c.ProxyFunc(); proxy != nil {
+		transport.Proxy = proxy
+	}
+
+	roots, err := c.CertPool()
+	if err != nil {
+		return nil, err
+	}
+	if roots != nil {
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.RootCAs = roots
+	}
+
+	return transport, nil
+}
+
+// CertPool returns the system's roots with c's CAs added, or nil when c adds
+// none and the system's roots apply as they are.
+func (c Config) CertPool() (*x509.CertPool, error) {
+	certificates, err := c.certificates()
+	if err != nil || len(certificates) == 0 {
+		return nil, err
+	}
+
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		// No system roots to add to, as on a host without a CA store: the
+		// configured CAs are then the only ones.
+		roots = x509.NewCertPool()
+	}
+	for _, certificate := range certificates {
+		roots.AddCert(certificate)
+	}
+
+	return roots, nil
+}
+
+// CAPEM returns c's CAs as one PEM bundle, for the consumers that take a bundle
+// rather than a pool: blackbox's TLS config and node's NODE_EXTRA_CA_CERTS.
+func (c Config) CAPEM() ([]byte, error) {
+	certificates, err := c.certificates()
+	if err != nil {
+		return nil, err
+	}
+
+	var bundle []byte
+	for _, certificate := range certificates {
+		bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})...)
+	}
+
+	return bundle, nil
+}
+
+// certificates reads and parses every CA c names.
+func (c Config) certificates() ([]*x509.Certificate, error) {
+	var certificates []*x509.Certificate
+
+	for _, file := range c.CAFiles {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+
+		parsed, err := parsePEM(data)
+		if err != nil {
+			return nil, fmt.Errorf("CA bundle %q: %w", file, err)
+		}
+		certificates = append(certificates, parsed...)
+	}
+
+	if strings.TrimSpace(c.CACertificates) != "" {
+		parsed, err := parsePEM([]byte(c.CACertificates))
+		if err != nil {
+			return nil, fmt.Errorf("caCertificates: %w", err)
+		}
+		certificates = append(certificates, parsed...)
+	}
+
+	return certificates, nil
+}
+
+func parsePEM(data []byte) ([]*x509.Certificate, error) {
+	var certificates []*x509.Certificate
+
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+
+		certificate, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, certificate)
+	}
+
+	if len(certificates) == 0 {
+		return nil, errors.New("no PEM certificates found")
+	}
+
+	return certificates, nil
+}
+
+// ChromeArgs returns the Chromium launch flags that apply c's proxy to a
+// browser. Chromium takes one proxy per scheme and a bypass list, which map
+// directly.
+//
+// The CAs are not among them. The only flag that comes near is
+// --ignore-certificate-errors-spki-list, and it does not add a root: it waives
+// every check on a chain that contains one of the keys, names and expiry
+// included, which is a browser that would accept a certificate for the wrong
+// site. The CAs go into the browser's trust store instead; see InstallNSS.
+func (c Config) ChromeArgs() []string {
+	var args []string
+
+	var rules []string
+	for _, rule := range []struct{ scheme, proxy string }{
+		{"http", c.HTTPProxy},
+		{"https", c.HTTPSProxy},
+	} {
+		if rule.proxy != "" {
+			rules = append(rules, rule.scheme+"="+chromeProxy(rule.proxy))
+		}
+	}
+	if c.SOCKSProxy != "" {
+		// Chromium's fallback rule: used for any scheme without its own.
+		rules = append(rules, chromeProxy(c.SOCKSProxy))
+	}
+	if len(rules) > 0 {
+		args = append(args, "--proxy-server="+strings.Join(rules, ";"))
+		if len(c.NoProxy) > 0 {
+			args = append(args, "--proxy-bypass-list="+strings.Join(c.NoProxy, ";"))
+		}
+	}
+
+	return args
+}
+
+// chromeProxy converts a proxy URL into Chromium's form. Chromium cannot take
+// credentials on the command line, so they are dropped here; a proxy that
+// needs them has to be answered by the script, through page.authenticate.
+func chromeProxy(proxy string) string {
+	parsed, err := url.Parse(proxy)
+	if err != nil || parsed.Host == "" {
+		return proxy
+	}
+
+	scheme := parsed.Scheme
+	if scheme == "socks5h" {
+		scheme = "socks5"
+	}
+
+	return scheme + "://" + parsed.Host
+}
+
+// Applied returns what of c a prober that honours h enforces, or nil when it
+// enforces nothing.
+func (c Config) Applied(h prob.NetworkHonours) *prob.NetworkApplied {
+	var applied prob.NetworkApplied
+
+	if h.Proxy {
+		applied.HTTPProxy = prob.RedactProxy(c.HTTPProxy)
+		applied.HTTPSProxy = prob.RedactProxy(c.HTTPSProxy)
+		applied.SOCKSProxy = prob.RedactProxy(c.SOCKSProxy)
+		if c.hasProxy() {
+			applied.NoProxy = c.NoProxy
+		}
+	}
+
+	if h.CAs {
+		// Validated at startup; a CA that has become unreadable since fails
+		// the run that tries it, and the record names what still parses.
+		certificates, _ := c.certificates()
+		for _, certificate := range certificates {
+			applied.CAs = append(applied.CAs, certificate.Subject.String())
+		}
+	}
+
+	if applied.HTTPProxy == "" && applied.HTTPSProxy == "" && applied.SOCKSProxy == "" && len(applied.CAs) == 0 {
+		return nil
+	}
+
+	return &applied
+}
@@ -0,0 +1,96 @@
+package egress
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// A browser is the one client here that cannot be handed a root pool. On Linux
+// Chromium trusts what is in the NSS database under its user's home, and the
+// home a script runs with is a fresh, private one. So the CAs are installed
+// there, into a database made for the run: the browser then verifies a chain
+// to them exactly as it does to a public root -- names, expiry and all -- and
+// does so whether or not the script passes it any flags.
+
+// nssTool is NSS's own database tool. Packaged as libnss3-tools (Debian) or
+// nss-tools (Fedora); it is the only supported way to write the database.
+const nssTool = "certutil"
+
+// nssDatabase is where Chromium on Linux looks for the user's NSS database,
+// relative to HOME.
+var nssDatabase = filepath.Join(".pki", "nssdb")
+
+// ErrNoNSSTool is returned when CAs are to be installed for a browser and the
+// host has no certutil to install them with.
+var ErrNoNSSTool = errors.New("certutil not found: install libnss3-tools (or nss-tools) to trust extra CAs in a browser")
+
+// InstallNSS creates the NSS database a browser run with home as its HOME
+// trusts, holding c's CAs as trusted roots. It does nothing when c has no CAs.
+//
+// The database is made fresh, so nothing trusted by an earlier run -- or by
+// the worker's own user -- is carried into this one.
+func (c Config) InstallNSS(ctx context.Context, home string) error {
+	certificates, err := c.certificates()
+	if err != nil || len(certificates) == 0 {
+		return err
+	}
+
+	// Elsewhere the browser reads the platform's own store, which is the
+	// host's rather than the run's, and a database here would be ignored.
+	if runtime.GOOS != "linux" {
+		return fmt.Errorf("extra CAs can be trusted in a browser only on Linux, not %s", runtime.GOOS)
+	}
+
+	tool, err := exec.LookPath(nssTool)
+	if err != nil {
+		return ErrNoNSSTool
+	}
+
+	dir := filepath.Join(home, nssDatabase)
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create the browser's trust store: %w", err)
+	}
+	database := "sql:" + dir
+
+	if err := runNSSTool(ctx, tool, "-N", "-d", database, "--empty-password"); err != nil {
+		return fmt.Errorf("failed to create the browser's trust store: %w", err)
+	}
+
+	for i, certificate := range certificates {
+		// One file per certificate: certutil -A reads the first one it finds.
+		file := filepath.Join(dir, fmt.Sprintf("ca-%d.pem", i))
+		block := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Raw})
+		if err := os.WriteFile(file, block, 0o600); err != nil {
+			return fmt.Errorf("failed to stage CA %q: %w", certificate.Subject, err)
+		}
+
+		// "C,," trusts it to issue server certificates, and for nothing else:
+		// not e-mail, not code signing.
+		err := runNSSTool(ctx, tool, "-A", "-d", database, "-n", fmt.Sprintf("urth-ca-%d", i), "-t", "C,,", "-a", "-i", file)
+		os.Remove(file)
+		if err != nil {
+			return fmt.Errorf("failed to trust CA %q in the browser's trust store: %w", certificate.Subject, err)
+		}
+	}
+
+	return nil
+}
+
+func runNSSTool(ctx context.Context, tool string, args ...string) error {
+	out, err := exec.CommandContext(ctx, tool, args...).CombinedOutput()
+	if err != nil {
+		if detail := strings.TrimSpace(string(out)); detail != "" {
+			return fmt.Errorf("%w: %s", err, detail)
+		}
+		return err
+	}
+
+	return nil
+}
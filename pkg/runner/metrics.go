@@ -13,7 +13,7 @@ import (
 	"github.com/sre-norns/urth/pkg/urth"
 )
 
-const MetricsRelType = "metrics"
+const MetricsRelType = urth.MetricsArtifactRel
 
 type Compression string
 
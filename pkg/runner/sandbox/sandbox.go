@@ -0,0 +1,230 @@
+// Package sandbox runs a prober's script interpreter under limits.
+//
+// A scenario's script is code somebody else wrote, and the script probers run
+// it: puppeteer pipes it into `node -`. Until now that process was the worker's
+// own child in every respect that matters -- its uid, its environment, its open
+// files budget, its view of the network -- so a script could read the worker's
+// enrolment token out of the environment, fill the disk, fork until the host
+// stopped answering, or leave a browser behind that outlived the run.
+//
+// What this package gives a script is deliberately less:
+//
+//   - a process group of its own, killed as a whole when the run ends or its
+//     context does, so nothing it started outlives it;
+//   - a private home and temp directory inside the run directory, removed
+//     afterwards;
+//   - a minimal environment: a fixed PATH, that home, and whatever the prober
+//     passes on purpose -- nothing inherited from the worker;
+//   - resource limits on CPU time, memory, open files and processes;
+//   - optionally, a dedicated unprivileged uid, a cgroup v2 of its own under a
+//     directory delegated to the worker, and a network namespace prepared by
+//     the operator.
+//
+// It is a subpackage rather than part of pkg/runner because the probers use it
+// and pkg/runner imports the probers.
+//
+// This is containment, not a security boundary against a determined attacker
+// on a shared kernel. It closes the accidents and the easy abuse; a deployment
+// running scripts it does not trust at all should run the worker itself inside
+// a VM or a container built for that.
+package sandbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/sre-norns/urth/pkg/prob"
+)
+
+// Config is what an operator can set about the sandbox.
+//
+// The kong tags are flag definitions: RunnerConfig embeds this with the
+// `sandbox.` prefix, so every command that runs probes gets the same flags.
+// The zero value is usable and applies only what needs no privilege: its own
+// process group, a private temp directory and a minimal environment.
+type Config struct {
+	// User is who the script runs as. Changing uid needs the worker to run as
+	// root, which is the one arrangement where running scripts as the worker is
+	// the worst thing it can do.
+	User string `help:"Run scripts as this unprivileged user. Needs the worker to run as root" placeholder:"USER"`
+
+	CPUTime   time.Duration `help:"CPU time each script process may use. 0 for no limit" default:"0"`
+	Memory    int64         `help:"Memory a script may use, in MiB: per process, or for the whole run with --sandbox.cgroup. 0 for no limit" default:"0" placeholder:"MiB"`
+	OpenFiles uint64        `help:"Open files each script process may hold. 0 for no limit" default:"4096"`
+
+	// Processes is counted per uid by the kernel, so on its own it can only
+	// be applied with User: under the worker's uid it would count the worker's
+	// own threads. With Cgroup it is the run's pids.max instead. Off by
+	// default for that reason: a default that applies only in some
+	// configurations reads as a limit in all of them.
+	Processes uint64 `help:"Processes a script may run at once. Needs --sandbox.user or --sandbox.cgroup. 0 for no limit" default:"0"`
+
+	// Cgroup is a cgroup v2 directory delegated to the worker. Each run gets a
+	// child of it, which is what makes the memory and process limits apply to
+	// the run as a whole, lets a violation be told apart from a crash, and
+	// catches processes that left the script's process group.
+	Cgroup string `help:"cgroup v2 directory delegated to the worker; each run gets its own child with the memory and process limits" placeholder:"DIR"`
+
+	// NetNS is a network namespace for scripts to run in, as created by
+	// `ip netns add`. The operator decides what it can reach; an empty
+	// namespace would stop a probe probing anything.
+	NetNS string `name:"netns" help:"Network namespace to run scripts in, e.g. /run/netns/probes" placeholder:"PATH"`
+}
+
+// ErrViolation marks a script stopped for exceeding one of its limits.
+//
+// Distinct from the script failing on its own, because the two mean different
+// things to whoever reads the result: a failure is about the site probed, a
+// violation is about the script or the limits it was given.
+var ErrViolation = errors.New("sandbox limit exceeded")
+
+// ErrUnsupported marks a setting this platform cannot apply. A sandbox that
+// silently ran without a limit it was configured with would be worse than
+// refusing to start.
+var ErrUnsupported = errors.New("sandbox setting not supported on this platform")
+
+// defaultPath is the PATH a script sees. Fixed rather than inherited: the
+// worker's PATH says where the worker's tools are, which is not the script's
+// business.
+const defaultPath = "/usr/local/bin:/usr/bin:/bin"
+
+// killGrace bounds how long Run waits for the script's output to drain after
+// the script has been killed. A grandchild that kept a pipe open would
+// otherwise hold the run open after the process that mattered has gone.
+const killGrace = 5 * time.Second
+
+// Command is one process to run in the sandbox. Declared in pkg/prob, so that
+// a prober can be handed a sandbox without pkg/prob depending on this package.
+type Command = prob.SandboxCommand
+
+// Config runs scripts for the probers.
+var _ prob.Sandbox = Config{}
+
+// Run executes the command under the sandbox until it exits or ctx ends.
+//
+// The error is a violation (ErrViolation) when the script was stopped by one of
+// its limits, the context's error when the run was cut short, and otherwise
+// whatever exec reports about how the process exited.
+func (c Config) Run(ctx context.Context, command Command) error {
+	path, err := exec.LookPath(command.Path)
+	if err != nil {
+		return err
+	}
+
+	home, err := os.MkdirTemp(command.Dir, ".sandbox-")
+	if err != nil {
+		return fmt.Errorf("failed to create the sandbox's private directory: %w", err)
+	}
+	defer os.RemoveAll(home)
+
+	if command.Prepare != nil {
+		if err := command.Prepare(ctx, home); err != nil {
+			return err
+		}
+	}
+
+	cmd := exec.CommandContext(ctx, path, command.Args...)
+	cmd.Dir = command.Dir
+	cmd.Env = append([]string{
+		"PATH=" + defaultPath,
+		"HOME=" + home,
+		"TMPDIR=" + home,
+		"LANG=C.UTF-8",
+	}, command.Env...)
+	cmd.Stdout = command.Stdout
+	cmd.Stderr = command.Stderr
+	cmd.WaitDelay = killGrace
+
+	run, err := c.prepare(cmd, command.Dir, home)
+	if err != nil {
+		return err
+	}
+	defer run.cleanup()
+
+	// The whole group, not just the child exec.CommandContext knows about. A
+	// browser started by the script is a grandchild, and killing only node
+	// leaves it running with nobody to reap it.
+	cmd.Cancel = run.kill
+
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("failed to open the script's input: %w", err)
+	}
+
+	if err := run.start(cmd); err != nil {
+		return err
+	}
+
+	// The process exists but has been handed nothing yet. Limits that could
+	// not be applied mean the script must not get its input at all.
+	if err := run.limit(cmd.Process.Pid); err != nil {
+		_ = run.kill()
+		_ = cmd.Wait()
+		return err
+	}
+
+	go func() {
+		defer stdin.Close()
+		if command.Stdin != nil {
+			_, _ = io.Copy(stdin, command.Stdin)
+		}
+	}()
+
+	waitErr := cmd.Wait()
+
+	// Whatever the script left running goes with it, on success as well as on
+	// failure: a run is over when its process is, not when its stragglers are.
+	_ = run.kill()
+
+	if waitErr == nil {
+		return nil
+	}
+	if violation := run.violation(cmd.ProcessState); violation != "" {
+		return fmt.Errorf("%w: %s", ErrViolation, violation)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	return waitErr
+}
+
+// Validate checks the settings against this host before any script runs, so a
+// sandbox that cannot be built stops the worker at startup rather than failing
+// every run.
+func (c Config) Validate() error {
+	var problems []error
+
+	if c.CPUTime < 0 {
+		problems = append(problems, fmt.Errorf("--sandbox.cpu-time must not be negative, got %v", c.CPUTime))
+	}
+	if c.Memory < 0 {
+		problems = append(problems, fmt.Errorf("--sandbox.memory must not be negative, got %d", c.Memory))
+	}
+	if c.Cgroup != "" {
+		if _, err := os.Stat(filepath.Join(c.Cgroup, "cgroup.controllers")); err != nil {
+			problems = append(problems, fmt.Errorf("--sandbox.cgroup %q is not a cgroup v2 directory: %w", c.Cgroup, err))
+		}
+	}
+	if c.NetNS != "" {
+		if _, err := os.Stat(c.NetNS); err != nil {
+			problems = append(problems, fmt.Errorf("--sandbox.netns %q: %w", c.NetNS, err))
+		}
+	}
+	if c.Processes > 0 && c.User == "" && c.Cgroup == "" {
+		// Not refused: the limit is harmless, only absent. But an operator who
+		// set it believes scripts are held to it, and should hear otherwise.
+		log.Printf("sandbox: --sandbox.processes=%d is not applied: it needs --sandbox.user or --sandbox.cgroup", c.Processes)
+	}
+
+	problems = append(problems, c.validatePlatform()...)
+
+	return errors.Join(problems...)
+}
@@ -0,0 +1,376 @@
+package sandbox
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// cpuHardGrace is how far past the CPU time limit the kernel's hard limit sits.
+// At the soft limit the script is sent SIGXCPU, which is what lets the result
+// say why it stopped; the hard limit is the SIGKILL for a script that ignored
+// it.
+const cpuHardGrace = 5 * time.Second
+
+// run is one sandboxed process's host-side state.
+type run struct {
+	config Config
+
+	pid int
+
+	// cgroup is the run's own cgroup directory and an open handle on it, when
+	// the sandbox has one.
+	cgroup   string
+	cgroupFD *os.File
+}
+
+// prepare sets up everything that has to exist before the process does.
+func (c Config) prepare(cmd *exec.Cmd, dir, home string) (*run, error) {
+	r := &run{config: c}
+
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		// A group of its own, so the whole tree can be killed with one signal.
+		Setpgid: true,
+		// And killed if the worker dies, rather than left running unowned.
+		Pdeathsig: syscall.SIGKILL,
+	}
+
+	if c.User != "" {
+		uid, gid, err := lookupUser(c.User)
+		if err != nil {
+			return nil, err
+		}
+
+		// An empty group list, so the script keeps none of the worker's
+		// supplementary groups either.
+		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uid, Gid: gid, Groups: []uint32{}}
+
+		// The run directory is where the script leaves its artifacts, and the
+		// private home is where it keeps everything else. Both are fresh for
+		// this run, so handing them over gives away nothing older. The home is
+		// handed over whole, with whatever the prober prepared in it: a trust
+		// store the browser cannot open is no trust store.
+		if err := os.Chown(dir, int(uid), int(gid)); err != nil {
+			return nil, fmt.Errorf("failed to hand %q to sandbox user %q: %w", dir, c.User, err)
+		}
+		err = filepath.WalkDir(home, func(path string, _ fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			return os.Lchown(path, int(uid), int(gid))
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to hand %q to sandbox user %q: %w", home, c.User, err)
+		}
+	}
+
+	if c.Cgroup != "" {
+		if err := r.makeCgroup(); err != nil {
+			r.cleanup()
+			return nil, err
+		}
+
+		// Placed in the cgroup as it is created, rather than moved there after
+		// it has started: there is no moment when the script runs unlimited.
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = int(r.cgroupFD.Fd())
+	}
+
+	return r, nil
+}
+
+// makeCgroup creates the run's cgroup under the delegated directory and sets its
+// limits.
+func (r *run) makeCgroup() error {
+	dir, err := os.MkdirTemp(r.config.Cgroup, "run-")
+	if err != nil {
+		return fmt.Errorf("failed to create the run's cgroup: %w", err)
+	}
+	r.cgroup = dir
+
+	// A limit that cannot be written is a sandbox that is not what it was
+	// configured to be. Usually it means the controller is not enabled in the
+	// delegated directory's cgroup.subtree_control.
+	if r.config.Memory > 0 {
+		if err := writeCgroup(dir, "memory.max", strconv.FormatInt(r.config.Memory<<20, 10)); err != nil {
+			return err
+		}
+		// No swap to spill into, or the limit only delays the violation.
+		if err := writeCgroup(dir, "memory.swap.max", "0"); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	if r.config.Processes > 0 {
+		if err := writeCgroup(dir, "pids.max", strconv.FormatUint(r.config.Processes, 10)); err != nil {
+			return err
+		}
+	}
+
+	fd, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open the run's cgroup: %w", err)
+	}
+	r.cgroupFD = fd
+
+	return nil
+}
+
+// start starts the process, inside the configured network namespace if there
+// is one.
+//
+// A new process inherits the namespaces of the thread that forked it, so the
+// fork is made from a thread moved into the namespace for the purpose and
+// moved back straight after. The thread is locked for the duration so no other
+// goroutine is scheduled onto it while it is somewhere else.
+func (r *run) start(cmd *exec.Cmd) error {
+	if r.config.NetNS == "" {
+		return r.started(cmd, cmd.Start())
+	}
+
+	runtime.LockOSThread()
+
+	restore, err := os.Open("/proc/thread-self/ns/net")
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("failed to note the worker's network namespace: %w", err)
+	}
+	defer restore.Close()
+
+	target, err := os.Open(r.config.NetNS)
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("failed to open network namespace %q: %w", r.config.NetNS, err)
+	}
+	defer target.Close()
+
+	if err := unix.Setns(int(target.Fd()), unix.CLONE_NEWNET); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("failed to enter network namespace %q: %w", r.config.NetNS, err)
+	}
+
+	startErr := cmd.Start()
+
+	if err := unix.Setns(int(restore.Fd()), unix.CLONE_NEWNET); err != nil {
+		// Left locked on purpose: the runtime retires a thread whose goroutine
+		// exits while locked to it, which is the only safe end for a thread
+		// stranded in the wrong namespace. That also fires the script's
+		// Pdeathsig, so it is killed here rather than left half-accounted.
+		if startErr == nil {
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+		}
+		return fmt.Errorf("failed to leave network namespace %q: %w", r.config.NetNS, err)
+	}
+
+	runtime.UnlockOSThread()
+
+	return r.started(cmd, startErr)
+}
+
+func (r *run) started(cmd *exec.Cmd, err error) error {
+	if err != nil {
+		return err
+	}
+
+	r.pid = cmd.Process.Pid
+
+	return nil
+}
+
+// limit applies the resource limits to the started process. Its children
+// inherit them.
+func (r *run) limit(pid int) error {
+	c := r.config
+
+	set := func(resource int, name string, soft, hard uint64) error {
+		if err := unix.Prlimit(pid, resource, &unix.Rlimit{Cur: soft, Max: hard}, nil); err != nil {
+			return fmt.Errorf("failed to limit the script's %s: %w", name, err)
+		}
+		return nil
+	}
+
+	if c.CPUTime > 0 {
+		seconds := uint64((c.CPUTime + time.Second - 1) / time.Second)
+		if err := set(unix.RLIMIT_CPU, "CPU time", seconds, seconds+uint64(cpuHardGrace/time.Second)); err != nil {
+			return err
+		}
+	}
+
+	// With a cgroup, memory and processes are limited for the run as a whole,
+	// which is the limit that means something. Without one they fall back to
+	// what a single process can be limited to.
+	if c.Memory > 0 && c.Cgroup == "" {
+		bytes := uint64(c.Memory) << 20
+		if err := set(unix.RLIMIT_DATA, "memory", bytes, bytes); err != nil {
+			return err
+		}
+	}
+	if c.Processes > 0 && c.Cgroup == "" && c.User != "" {
+		if err := set(unix.RLIMIT_NPROC, "processes", c.Processes, c.Processes); err != nil {
+			return err
+		}
+	}
+
+	if c.OpenFiles > 0 {
+		if err := set(unix.RLIMIT_NOFILE, "open files", c.OpenFiles, c.OpenFiles); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// kill ends the process group and, with a cgroup, everything in it. Killing
+// what has already gone is not an error.
+//
+// Both, because each catches what the other misses: a process can leave its
+// process group with setsid, but not its cgroup.
+func (r *run) kill() error {
+	if r.pid > 0 {
+		if err := syscall.Kill(-r.pid, syscall.SIGKILL); err != nil && !errors.Is(err, syscall.ESRCH) {
+			return err
+		}
+	}
+
+	if r.cgroup != "" {
+		// cgroup.kill needs Linux 5.14. Older kernels still have the process
+		// group, which covers everything that did not go out of its way.
+		_ = writeCgroup(r.cgroup, "cgroup.kill", "1")
+	}
+
+	return nil
+}
+
+// violation names the limit that stopped the process, or returns "" when none
+// did.
+func (r *run) violation(state *os.ProcessState) string {
+	c := r.config
+
+	if r.cgroup != "" {
+		if c.Memory > 0 && cgroupEvent(r.cgroup, "memory.events", "oom_kill") > 0 {
+			return fmt.Sprintf("the run used more than its %d MiB of memory", c.Memory)
+		}
+		if c.Processes > 0 && cgroupEvent(r.cgroup, "pids.events", "max") > 0 {
+			return fmt.Sprintf("the run tried to start more than %d processes", c.Processes)
+		}
+	}
+
+	if c.CPUTime > 0 && state != nil {
+		if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			used := state.UserTime() + state.SystemTime()
+
+			switch status.Signal() {
+			case syscall.SIGXCPU:
+				return fmt.Sprintf("the script used more than its %v of CPU time", c.CPUTime)
+			case syscall.SIGKILL:
+				// Killed at the hard limit, having ignored SIGXCPU -- or
+				// killed by the run ending, which is not a violation.
+				if used >= c.CPUTime {
+					return fmt.Sprintf("the script used more than its %v of CPU time", c.CPUTime)
+				}
+			}
+		}
+	}
+
+	return ""
+}
+
+// cleanup removes the run's cgroup once everything in it has gone.
+func (r *run) cleanup() {
+	if r.cgroupFD != nil {
+		r.cgroupFD.Close()
+	}
+	if r.cgroup == "" {
+		return
+	}
+
+	// A killed process leaves its cgroup as it is reaped, not as it is
+	// signalled, so the directory can be briefly busy.
+	for range 50 {
+		if err := os.Remove(r.cgroup); err == nil || errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	_ = os.Remove(r.cgroup)
+}
+
+func (c Config) validatePlatform() []error {
+	var problems []error
+
+	if c.User != "" {
+		if uid, _, err := lookupUser(c.User); err != nil {
+			problems = append(problems, err)
+		} else if uid == 0 {
+			problems = append(problems, fmt.Errorf("--sandbox.user %q is root, which is what the sandbox is for not running scripts as", c.User))
+		}
+		if os.Geteuid() != 0 {
+			problems = append(problems, fmt.Errorf("--sandbox.user needs the worker to run as root to change to %q", c.User))
+		}
+	}
+	if c.NetNS != "" && os.Geteuid() != 0 {
+		problems = append(problems, errors.New("--sandbox.netns needs the worker to run as root to enter a network namespace"))
+	}
+
+	return problems
+}
+
+func lookupUser(name string) (uid, gid uint32, err error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, 0, fmt.Errorf("sandbox user %q: %w", name, err)
+	}
+
+	parsedUID, err := strconv.ParseUint(u.Uid, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("sandbox user %q has uid %q: %w", name, u.Uid, err)
+	}
+	parsedGID, err := strconv.ParseUint(u.Gid, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("sandbox user %q has gid %q: %w", name, u.Gid, err)
+	}
+
+	return uint32(parsedUID), uint32(parsedGID), nil
+}
+
+func writeCgroup(dir, file, value string) error {
+	if err := os.WriteFile(filepath.Join(dir, file), []byte(value), 0); err != nil {
+		return fmt.Errorf("failed to set %s in %q: %w", file, dir, err)
+	}
+
+	return nil
+}
+
+// cgroupEvent reads one counter from a cgroup's events file, or 0 when it
+// cannot.
+func cgroupEvent(dir, file, key string) uint64 {
+	f, err := os.Open(filepath.Join(dir, file))
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, value, ok := strings.Cut(scanner.Text(), " ")
+		if ok && name == key {
+			n, _ := strconv.ParseUint(value, 10, 64)
+			return n
+		}
+	}
+
+	return 0
+}
@@ -0,0 +1,115 @@
+package sandbox_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sre-norns/urth/pkg/runner/sandbox"
+)
+
+func runShell(t *testing.T, ctx context.Context, config sandbox.Config, script string) (string, error) {
+	t.Helper()
+
+	// Traversable by anyone, as a worker's working directory must be for a
+	// sandbox user to enter the run directory inside it. The test's own
+	// temporary directories are not.
+	dir := t.TempDir()
+	for _, path := range []string{filepath.Dir(dir), dir} {
+		require.NoError(t, os.Chmod(path, 0o755))
+	}
+
+	var out bytes.Buffer
+	err := config.Run(ctx, sandbox.Command{
+		Path:   "/bin/sh",
+		Args:   []string{"-s"},
+		Dir:    dir,
+		Env:    []string{"URTH_GIVEN=yes"},
+		Stdin:  strings.NewReader(script),
+		Stdout: &out,
+		Stderr: &out,
+	})
+
+	return out.String(), err
+}
+
+// The script sees what the prober gave it and a home of its own -- none of the
+// worker's environment, which is where its enrolment token lives.
+func TestScriptGetsAMinimalEnvironment(t *testing.T) {
+	t.Setenv("URTH_TOKEN", "secret")
+
+	out, err := runShell(t, context.Background(), sandbox.Config{}, `env; test -w "$TMPDIR" && echo TMP_WRITABLE`)
+	require.NoError(t, err)
+
+	require.NotContains(t, out, "secret")
+	require.Contains(t, out, "URTH_GIVEN=yes")
+	require.Contains(t, out, "HOME=")
+	require.Contains(t, out, "TMP_WRITABLE")
+}
+
+// Ending the run ends everything the script started, not only the process the
+// sandbox started itself.
+func TestCancellationKillsTheWholeProcessGroup(t *testing.T) {
+	dir := t.TempDir()
+	pidFile := filepath.Join(dir, "child.pid")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	started := time.Now()
+	_, err := runShell(t, ctx, sandbox.Config{}, "sleep 60 & echo $! > "+pidFile+"; wait")
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+	require.Less(t, time.Since(started), 10*time.Second, "the run outlived its context")
+
+	data, err := os.ReadFile(pidFile)
+	require.NoError(t, err)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return syscall.Kill(pid, 0) == syscall.ESRCH
+	}, 5*time.Second, 50*time.Millisecond, "the script's background process survived the run")
+}
+
+// A script that burns through its CPU time is stopped, and the error says which
+// limit it hit rather than reporting an ordinary failure.
+func TestCPUTimeLimitIsAViolation(t *testing.T) {
+	_, err := runShell(t, context.Background(), sandbox.Config{CPUTime: time.Second}, "while :; do :; done")
+
+	require.ErrorIs(t, err, sandbox.ErrViolation)
+	require.Contains(t, err.Error(), "CPU time")
+}
+
+// A script that fails on its own is a failure, not a violation.
+func TestScriptFailureIsNotAViolation(t *testing.T) {
+	_, err := runShell(t, context.Background(), sandbox.Config{CPUTime: time.Minute, OpenFiles: 64}, "exit 3")
+
+	require.Error(t, err)
+	require.NotErrorIs(t, err, sandbox.ErrViolation)
+}
+
+func TestScriptRunsAsTheSandboxUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("changing uid needs root")
+	}
+
+	config := sandbox.Config{User: "nobody"}
+	require.NoError(t, config.Validate())
+
+	out, err := runShell(t, context.Background(), config, `id -un; touch ./artifact && echo WROTE`)
+	require.NoError(t, err)
+	require.Contains(t, out, "nobody")
+	require.Contains(t, out, "WROTE")
+}
+
+func TestValidateRefusesRootAsTheSandboxUser(t *testing.T) {
+	require.Error(t, sandbox.Config{User: "root"}.Validate())
+}
@@ -0,0 +1,62 @@
+//go:build !linux
+
+package sandbox
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+)
+
+// Elsewhere than Linux the sandbox is a minimal environment and a private
+// directory, and nothing more. Workers are deployed on Linux; this exists so
+// that the probers still build, and run, on a developer's machine.
+
+type run struct {
+	cmd *exec.Cmd
+}
+
+func (c Config) prepare(cmd *exec.Cmd, _, _ string) (*run, error) {
+	return &run{cmd: cmd}, nil
+}
+
+func (r *run) start(cmd *exec.Cmd) error { return cmd.Start() }
+
+func (r *run) limit(int) error { return nil }
+
+func (r *run) kill() error {
+	if r.cmd.Process == nil {
+		return nil
+	}
+	if err := r.cmd.Process.Kill(); err != nil && err != os.ErrProcessDone {
+		return err
+	}
+	return nil
+}
+
+func (r *run) violation(*os.ProcessState) string { return "" }
+
+func (r *run) cleanup() {}
+
+func (c Config) validatePlatform() []error {
+	var problems []error
+
+	for flag, set := range map[string]bool{
+		"--sandbox.user":   c.User != "",
+		"--sandbox.cgroup": c.Cgroup != "",
+		"--sandbox.netns":  c.NetNS != "",
+	} {
+		if set {
+			problems = append(problems, fmt.Errorf("%w: %s", ErrUnsupported, flag))
+		}
+	}
+
+	if c.CPUTime > 0 || c.Memory > 0 {
+		problems = append(problems, fmt.Errorf("%w: resource limits", ErrUnsupported))
+	} else {
+		log.Print("sandbox: resource limits are only applied on Linux; scripts run unlimited here")
+	}
+
+	return problems
+}
@@ -16,6 +16,12 @@ type (
 		RunID manifest.ResourceName `uri:"runId" form:"runId" binding:"required"`
 	}
 
+	// RunnerTokenRequest names one enrolment token of a runner.
+	RunnerTokenRequest struct {
+		bark.ResourceRequest
+		TokenID manifest.ResourceName `uri:"token" form:"token" binding:"required"`
+	}
+
 	ScenarioRunResultArtifactRequest struct {
 		ScenarioRunResultsRequest `uri:",inline" form:",inline" binding:"required"`
 		ArtifactID                manifest.ResourceName `uri:"artifactId" form:"artifactId" binding:"required"`
@@ -53,6 +59,25 @@ type (
 		IsPaused bool `form:"paused" json:"paused" yaml:"paused" xml:"paused"`
 	}
 
+	// SetDrainingRequest asks the server to drain a worker, or every worker of
+	// a runner, or to stop doing so. A request of its own for the reason
+	// SetPausedRequest is.
+	SetDrainingRequest struct {
+		IsDraining bool `form:"draining" json:"draining" yaml:"draining" xml:"draining"`
+	}
+
+	// CancelRunRequest asks the server to stop a run.
+	//
+	// RequestedBy is asserted by the caller: the API has no operator
+	// authentication to take it from (task 005), so it is a record of who said
+	// they asked, not evidence of who did. It is required all the same, because
+	// a cancelled run nobody can account for is the question the field exists
+	// to answer.
+	CancelRunRequest struct {
+		RequestedBy string `form:"requestedBy" json:"requestedBy" yaml:"requestedBy" xml:"requestedBy" binding:"required"`
+		Reason      string `form:"reason,omitempty" json:"reason,omitempty" yaml:"reason,omitempty" xml:"reason,omitempty"`
+	}
+
 	// WorkerHeartbeatRequest is a worker reporting that it is still there.
 	//
 	// It carries no identity: the session credential the request is authenticated
@@ -63,6 +88,12 @@ type (
 		// the fleet view updates at once rather than waiting out the timeout.
 		// Best-effort by nature -- a worker that is killed sends nothing.
 		Leaving bool `form:"leaving,omitempty" json:"leaving,omitempty" yaml:"leaving,omitempty" xml:"leaving,omitempty"`
+
+		// SelfTestFailures is every prob kind the worker's latest self-test
+		// failed: the whole set each time, not a change, so a report that
+		// goes missing is corrected by the next. Empty from a worker that
+		// found nothing wrong, and from one that predates self-tests.
+		SelfTestFailures []ProbSelfTestFailure `form:"selfTestFailures,omitempty" json:"selfTestFailures,omitempty" yaml:"selfTestFailures,omitempty" xml:"selfTestFailures,omitempty"`
 	}
 
 	// WorkerHeartbeatResponse tells a worker how to keep reporting.
@@ -77,6 +108,14 @@ type (
 		// Advisory: the server refuses its claims regardless, but a worker that
 		// knows can say so in its log instead of appearing to fail.
 		Paused bool `form:"paused" json:"paused" yaml:"paused" xml:"paused"`
+
+		// Drain asks the worker to stop pulling work, finish its in-flight runs,
+		// report that it is leaving, and exit. Set when the worker or its runner
+		// has been drained. Unlike Paused this is an instruction rather than
+		// advice: the server does not refuse a drained worker's claims, because
+		// a claim refused on its account would dead-letter a run another worker
+		// could have taken.
+		Drain bool `form:"drain,omitempty" json:"drain,omitempty" yaml:"drain,omitempty" xml:"drain,omitempty"`
 	}
 
 	// AuthJobRequest is a job authorization request: a worker sends it to take
@@ -117,6 +156,19 @@ type (
 		// Labels the worker offers about this run. Advisory only -- server-owned
 		// labels are merged last and win.
 		Labels manifest.Labels `form:"labels,omitempty" json:"labels,omitempty" yaml:"labels,omitempty" xml:"labels,omitempty"`
+
+		// FailingKinds are the prob kinds failing their self-test on the
+		// worker right now. A run of one of them is deferred rather than
+		// granted: re-queued by the server for a worker that can run it, as a
+		// throttled run is, instead of handed back to the broker where every
+		// hand-back spends one of the message's deliveries.
+		FailingKinds []prob.Kind `form:"failingKinds,omitempty" json:"failingKinds,omitempty" yaml:"failingKinds,omitempty" xml:"failingKinds,omitempty"`
+
+		// BusyKinds are the prob kinds whose every slot is taken on the worker
+		// right now (--concurrency.<kind>). A run of one of them is deferred
+		// like a run of a failing kind: the worker has no room for it yet, and
+		// a hand-back would spend a delivery on each worker that has none.
+		BusyKinds []prob.Kind `form:"busyKinds,omitempty" json:"busyKinds,omitempty" yaml:"busyKinds,omitempty" xml:"busyKinds,omitempty"`
 	}
 
 	AuthJobResponse struct {
@@ -142,5 +194,15 @@ type (
 		// is the server's number, not the worker's request, and the same value
 		// is recorded on the Result.
 		Deadline time.Time `form:"deadline,omitempty" json:"deadline,omitempty" yaml:"deadline,omitempty" xml:"deadline,omitempty"`
+
+		// Network is the runner's network configuration as it stands at the
+		// claim. The worker applies its own flags over it.
+		Network prob.NetworkConfig `form:"network,omitempty" json:"network,omitempty" yaml:"network,omitempty" xml:"network,omitempty"`
+
+		// LateResultGrace is how long past the capability's expiry the server
+		// still takes the run's outcome from a worker that spooled it. A worker
+		// keeps a spooled report that long and no longer. Zero from a server
+		// that takes no late reports, or predates them.
+		LateResultGrace time.Duration `form:"lateResultGrace,omitempty" json:"lateResultGrace,omitempty" yaml:"lateResultGrace,omitempty" xml:"lateResultGrace,omitempty"`
 	}
 )
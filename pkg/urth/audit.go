@@ -0,0 +1,403 @@
+package urth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// The audit log: who changed what, from where, and what it was before.
+//
+// Every resource records its current state and nothing records how it got
+// there. A runner found disabled, a worker paused, a dead letter retried twice,
+// a scenario whose prob now points somewhere else -- the resource says what is,
+// the server log might say that something happened, and nothing says who asked.
+// On a shared fleet that is the first question, and until now it was answered by
+// asking around.
+//
+// So the service writes an AuditEvent for every change an operator or a worker
+// makes through it. The event names the actor, the action and the resource, and
+// carries the difference the action made to the resource's spec and labels --
+// or, for actions that only flip a switch, to that switch. It also carries where
+// the request came from and its request ID, so that an event can be lined up
+// with the access log of whatever proxy is in front of the server.
+//
+// Two things the log is not. It is not a record of the data plane: heartbeats,
+// claims, status reports and uploaded artifacts are the fleet doing its job,
+// thousands of times an hour, and each already leaves its own record. And it is
+// not proof of identity for operators. The API has no operator authentication,
+// so an operator's name is whatever their client says it is (HeaderAuditActor),
+// and the event says so: Authenticated is false. What a worker does is recorded
+// under the name its credential proves.
+//
+// Events are only ever created. There is no route that updates or deletes one,
+// and nothing sweeps them: how long to keep an audit trail is a question for
+// whoever owns the database, not for a retention flag.
+
+// KindAuditEvent is the resource kind for audit log entries.
+const KindAuditEvent manifest.Kind = "auditEvents"
+
+// Labels on every audit event, so that the log can be searched the way any
+// other resource is. Values are label-safe forms (see LabelSafeValue): an actor
+// `alice@laptop` is labelled `alice-laptop`.
+const (
+	LabelAuditActor     = LabelsPrefix + "audit.actor"
+	LabelAuditActorKind = LabelsPrefix + "audit.actor-kind"
+	LabelAuditAction    = LabelsPrefix + "audit.action"
+	LabelAuditKind      = LabelsPrefix + "audit.kind"
+	LabelAuditResource  = LabelsPrefix + "audit.resource"
+)
+
+const (
+	// HeaderAuditActor is the request header an operator's client names its
+	// user in. Self-reported: see AuditEventSpec.Authenticated.
+	HeaderAuditActor = "X-Urth-Actor"
+
+	// HeaderRequestID carries the ID a request is recorded under. A proxy that
+	// already assigns one is believed; otherwise the server makes one up and
+	// returns it.
+	HeaderRequestID = "X-Request-ID"
+
+	// HeaderExportError is the trailer an export that failed part way ends
+	// with. Its status went out with the first line, so this is the only way
+	// left to say that the lines stop short of everything that matched.
+	HeaderExportError = "X-Urth-Export-Error"
+
+	// AnonymousActor is who an operator is recorded as when their client does
+	// not say.
+	AnonymousActor = "anonymous"
+)
+
+// AuditActorKind says whether an event was caused by an operator or by a worker.
+type AuditActorKind string
+
+const (
+	AuditActorOperator AuditActorKind = "operator"
+	AuditActorWorker   AuditActorKind = "worker"
+)
+
+// AuditAction names what was done. Together with the resource kind it says what
+// happened: `delete` of a runner and `delete` of a worker are different events.
+type AuditAction string
+
+const (
+	AuditActionCreate AuditAction = "create"
+	AuditActionUpdate AuditAction = "update"
+	AuditActionDelete AuditAction = "delete"
+
+	AuditActionUpdateScript AuditAction = "update-script"
+
+	AuditActionPause   AuditAction = "pause"
+	AuditActionResume  AuditAction = "resume"
+	AuditActionDrain   AuditAction = "drain"
+	AuditActionUndrain AuditAction = "undrain"
+
+	AuditActionIssueToken  AuditAction = "issue-token"
+	AuditActionRevokeToken AuditAction = "revoke-token"
+	AuditActionRegister    AuditAction = "register"
+
+	AuditActionCancel  AuditAction = "cancel"
+	AuditActionReport  AuditAction = "report"
+	AuditActionRetry   AuditAction = "retry"
+	AuditActionResolve AuditAction = "resolve"
+)
+
+// AuditValueLimit is the largest value, encoded as JSON, an event records as
+// is. Anything larger -- a prob script, a HAR file -- is recorded as an
+// AuditDigest: enough to tell that it changed and to match it against a copy,
+// without the log growing by a script every time somebody saves one.
+const AuditValueLimit = 1024
+
+// AuditChange is one field an action changed.
+type AuditChange struct {
+	// Path names the field, dotted from the top of the resource: `spec.active`,
+	// `labels.team`, `status.paused`. Lists are compared whole.
+	Path string `form:"path" json:"path" yaml:"path" xml:"path"`
+
+	// Before and After are the field's values either side of the action; one
+	// is missing when the field was added or removed. A value larger than
+	// AuditValueLimit is an AuditDigest.
+	Before any `form:"before,omitempty" json:"before,omitempty" yaml:"before,omitempty" xml:"before,omitempty"`
+	After  any `form:"after,omitempty" json:"after,omitempty" yaml:"after,omitempty" xml:"after,omitempty"`
+}
+
+// AuditDigest stands in for a value too large to record.
+type AuditDigest struct {
+	SHA256 string `form:"sha256" json:"sha256" yaml:"sha256" xml:"sha256"`
+	Size   int    `form:"size" json:"size" yaml:"size" xml:"size"`
+}
+
+// AuditEventSpec is one change to the system and who made it.
+type AuditEventSpec struct {
+	// Time is when the change was made.
+	Time time.Time `form:"time" json:"time" yaml:"time" xml:"time" gorm:"type:TIMESTAMPTZ"`
+
+	// Actor is who made the change: the name an operator's client gave, or the
+	// name of the worker whose credential authorised it.
+	Actor     string         `form:"actor" json:"actor" yaml:"actor" xml:"actor"`
+	ActorKind AuditActorKind `form:"actorKind" json:"actorKind" yaml:"actorKind" xml:"actorKind"`
+
+	// Authenticated is true when the server verified Actor rather than taking
+	// the client's word for it -- that is, for workers only.
+	Authenticated bool `form:"authenticated" json:"authenticated" yaml:"authenticated" xml:"authenticated"`
+
+	Action AuditAction `form:"action" json:"action" yaml:"action" xml:"action"`
+
+	// The resource acted on, as it stood after the action; before it, for a
+	// delete.
+	ResourceKind    manifest.Kind         `form:"resourceKind" json:"resourceKind" yaml:"resourceKind" xml:"resourceKind"`
+	ResourceName    manifest.ResourceName `form:"resourceName" json:"resourceName" yaml:"resourceName" xml:"resourceName"`
+	ResourceUID     manifest.ResourceID   `form:"resourceUID,omitempty" json:"resourceUID,omitempty" yaml:"resourceUID,omitempty" xml:"resourceUID,omitempty"`
+	ResourceVersion manifest.Version      `form:"resourceVersion,omitempty" json:"resourceVersion,omitempty" yaml:"resourceVersion,omitempty" xml:"resourceVersion,omitempty"`
+
+	// Changes is what the action did to the resource, field by field.
+	Changes []AuditChange `form:"changes,omitempty" json:"changes,omitempty" yaml:"changes,omitempty" xml:"changes,omitempty" gorm:"serializer:json"`
+
+	// SourceIP is the address the request came from, as the server saw it:
+	// behind a proxy, the proxy. ForwardedFor is the request's
+	// X-Forwarded-For, recorded rather than believed.
+	SourceIP     string `form:"sourceIP,omitempty" json:"sourceIP,omitempty" yaml:"sourceIP,omitempty" xml:"sourceIP,omitempty"`
+	ForwardedFor string `form:"forwardedFor,omitempty" json:"forwardedFor,omitempty" yaml:"forwardedFor,omitempty" xml:"forwardedFor,omitempty"`
+
+	// RequestID is the HeaderRequestID the request was served under.
+	RequestID string `form:"requestID,omitempty" json:"requestID,omitempty" yaml:"requestID,omitempty" xml:"requestID,omitempty"`
+}
+
+// AuditEvent is one entry in the audit log.
+type AuditEvent manifest.ResourceModel[AuditEventSpec]
+
+// ToManifest renders the resource for the API.
+func (r AuditEvent) ToManifest() manifest.ResourceManifest {
+	return manifest.ToManifest(manifest.ResourceModel[AuditEventSpec](r))
+}
+
+// NewAuditEvent converts a manifest into the model.
+func NewAuditEvent(m manifest.ResourceManifest) (AuditEvent, error) {
+	e, err := manifest.ManifestAsResource[AuditEventSpec](m)
+	entry := AuditEvent(e)
+	if err != nil {
+		return entry, fmt.Errorf("failed to convert resource manifest into an AuditEvent model: %w", err)
+	}
+
+	if entry.ObjectMeta.Name == "" {
+		return entry, ErrResourceNameEmpty
+	}
+
+	return entry, nil
+}
+
+// newAuditEvent names an event and labels it for search.
+func newAuditEvent(spec AuditEventSpec) AuditEvent {
+	labels := manifest.Labels{
+		LabelAuditActorKind: string(spec.ActorKind),
+		LabelAuditAction:    string(spec.Action),
+		LabelAuditKind:      string(spec.ResourceKind),
+	}
+	putLabel(labels, LabelAuditActor, spec.Actor)
+	putLabel(labels, LabelAuditResource, string(spec.ResourceName))
+
+	return AuditEvent{
+		ObjectMeta: manifest.ObjectMeta{
+			// Lower-cased for the reason issuedTokenID gives.
+			Name:   manifest.ResourceName(strings.ToLower(string(NewRandToken(16)))),
+			Labels: labels,
+		},
+		Spec: spec,
+	}
+}
+
+// AuditRequest is what the transport knows about who is asking. The API server
+// attaches one to every request's context; the service reads it when it records
+// an event.
+type AuditRequest struct {
+	// Actor is the name the client gave in HeaderAuditActor, if any.
+	Actor string
+
+	SourceIP     string
+	ForwardedFor string
+	RequestID    string
+}
+
+type auditRequestKey struct{}
+
+// WithAuditRequest attaches what the transport knows about a request to its
+// context.
+func WithAuditRequest(ctx context.Context, request AuditRequest) context.Context {
+	return context.WithValue(ctx, auditRequestKey{}, request)
+}
+
+// auditRequestFrom reads what WithAuditRequest attached. A call with none -- the
+// reconciler, a test -- is recorded with no source at all, which is the truth.
+func auditRequestFrom(ctx context.Context) AuditRequest {
+	request, _ := ctx.Value(auditRequestKey{}).(AuditRequest)
+	return request
+}
+
+// auditSection is a part of a resource an action may change.
+type auditSection string
+
+const (
+	auditLabels auditSection = "labels"
+	auditSpec   auditSection = "spec"
+	auditStatus auditSection = "status"
+)
+
+// auditDocument picks the sections of a resource an event compares.
+//
+// Spec and labels for anything an operator declares, since a label can move a
+// scenario onto other runners as surely as a spec edit can. Status only for the
+// actions that exist to change it, and never for a resource whose status is
+// partly computed when read, where it would show changes nobody made.
+func auditDocument(m manifest.ResourceManifest, sections ...auditSection) map[string]any {
+	document := make(map[string]any, len(sections))
+	for _, section := range sections {
+		switch section {
+		case auditLabels:
+			document[string(section)] = m.Metadata.Labels
+		case auditSpec:
+			document[string(section)] = auditRedacted(m.Spec)
+		case auditStatus:
+			document[string(section)] = m.Status
+		}
+	}
+
+	return document
+}
+
+// auditRedacted is a spec as the audit log may keep it, without the secrets an
+// operator puts in it.
+//
+// Redacted before the comparison rather than after, so a secret is in neither
+// side of any change. The log is append-only and never swept: a password
+// written there is kept long after it was rotated, and handed to everyone who
+// can read or export the log. Only the password is taken out, so a change of
+// proxy host or user still shows; a change of the password alone shows as an
+// update that changed nothing the log can say.
+func auditRedacted(spec any) any {
+	switch spec := spec.(type) {
+	case *RunnerSpec:
+		if spec == nil {
+			return spec
+		}
+		redacted := *spec
+		redacted.Network = redacted.Network.Redacted()
+		return &redacted
+	case RunnerSpec:
+		spec.Network = spec.Network.Redacted()
+		return spec
+	default:
+		return spec
+	}
+}
+
+// auditChanges compares two documents field by field.
+//
+// Both are taken through JSON first, so that a field is compared the way the
+// API shows it, under the name the API shows it by.
+func auditChanges(before, after map[string]any) ([]AuditChange, error) {
+	was, err := flattenAuditDocument(before)
+	if err != nil {
+		return nil, err
+	}
+	now, err := flattenAuditDocument(after)
+	if err != nil {
+		return nil, err
+	}
+
+	paths := slices.Collect(maps.Keys(was))
+	for path := range now {
+		if _, ok := was[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	slices.Sort(paths)
+
+	var changes []AuditChange
+	for _, path := range paths {
+		if reflect.DeepEqual(was[path], now[path]) {
+			continue
+		}
+
+		changes = append(changes, AuditChange{
+			Path:   path,
+			Before: auditValue(was[path]),
+			After:  auditValue(now[path]),
+		})
+	}
+
+	return changes, nil
+}
+
+// flattenAuditDocument lists a document's fields by dotted path.
+//
+// A null is the same as a missing field: `omitempty` makes the two
+// indistinguishable on the way out, so telling them apart here would only
+// report changes between two spellings of nothing.
+func flattenAuditDocument(document map[string]any) (map[string]any, error) {
+	data, err := json.Marshal(document)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode a resource for the audit log: %w", err)
+	}
+
+	var generic map[string]any
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, fmt.Errorf("failed to decode a resource for the audit log: %w", err)
+	}
+
+	fields := map[string]any{}
+	var flatten func(prefix string, value any)
+	flatten = func(prefix string, value any) {
+		switch value := value.(type) {
+		case nil:
+		case map[string]any:
+			for key, field := range value {
+				flatten(prefix+"."+key, field)
+			}
+		default:
+			fields[prefix] = value
+		}
+	}
+
+	for key, value := range generic {
+		flatten(key, value)
+	}
+
+	return fields, nil
+}
+
+// auditValue is how a value is recorded: as is, or as an AuditDigest when it is
+// larger than AuditValueLimit.
+func auditValue(value any) any {
+	if value == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil || len(data) <= AuditValueLimit {
+		return value
+	}
+
+	sum := sha256.Sum256(data)
+	return AuditDigest{SHA256: hex.EncodeToString(sum[:]), Size: len(data)}
+}
+
+// auditFlag reads one boolean out of a resource's status. An absent flag is an
+// unset one: the flags audited this way are all `omitempty`.
+func auditFlag(m manifest.ResourceManifest, name string) bool {
+	fields, err := flattenAuditDocument(auditDocument(m, auditStatus))
+	if err != nil {
+		return false
+	}
+
+	flag, _ := fields[string(auditStatus)+"."+name].(bool)
+	return flag
+}
@@ -0,0 +1,640 @@
+package urth
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/wyrd/pkg/bark"
+	"github.com/sre-norns/wyrd/pkg/dbstore"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+	"gorm.io/gorm"
+)
+
+// auditedService records every change made through the service in the audit
+// log. See AuditEvent.
+//
+// A decorator rather than a line in each API implementation, so that what is
+// audited can be read in one place, and so that an implementation cannot grow a
+// mutating method that nobody remembered to record: a method not overridden
+// here is one a reviewer can see is not audited.
+//
+// Each event is written after the change succeeds, and is not in its
+// transaction. A change that was refused is not an event -- the server log has
+// the refusal -- and an event that cannot be written is logged rather than
+// failing a change that has already been made. The price is that a crash
+// between the two loses the event, which is the trade the outbox avoids for
+// dispatches and that an audit trail of operator actions does not need to.
+type auditedService struct {
+	Service
+	audit auditor
+}
+
+func (s *auditedService) Runners() RunnersAPI {
+	api := s.Service.Runners()
+	return &auditedRunners{RunnersAPI: api, resources: s.audit.resources(KindRunner, api)}
+}
+
+func (s *auditedService) Workers() WorkersAPI {
+	return &auditedWorkers{WorkersAPI: s.Service.Workers(), audit: s.audit}
+}
+
+func (s *auditedService) Scenarios() ScenarioAPI {
+	api := s.Service.Scenarios()
+	return &auditedScenarios{ScenarioAPI: api, resources: s.audit.resources(KindScenario, api)}
+}
+
+func (s *auditedService) Results(scenarioName manifest.ResourceName) RunResultAPI {
+	return &auditedResults{RunResultAPI: s.Service.Results(scenarioName), audit: s.audit}
+}
+
+func (s *auditedService) Artifacts() ArtifactAPI {
+	return &auditedArtifacts{ArtifactAPI: s.Service.Artifacts(), audit: s.audit}
+}
+
+func (s *auditedService) DispatchFailures() DispatchFailuresAPI {
+	return &auditedDispatchFailures{DispatchFailuresAPI: s.Service.DispatchFailures(), audit: s.audit}
+}
+
+func (s *auditedService) MaintenanceWindows() MaintenanceWindowsAPI {
+	return s.audit.resources(KindMaintenanceWindow, s.Service.MaintenanceWindows())
+}
+
+func (s *auditedService) RateLimits() RateLimitsAPI {
+	return s.audit.resources(KindRateLimit, s.Service.RateLimits())
+}
+
+func (s *auditedService) WorkerBlocks() WorkerBlocksAPI {
+	return s.audit.resources(KindWorkerBlock, s.Service.WorkerBlocks())
+}
+
+// auditor writes audit events, and reads resources as they stood before a
+// change so that the event can say what the change was.
+type auditor struct {
+	store dbstore.TransactionalStore
+}
+
+// auditEntry is what a wrapper knows about a change; record adds who made it,
+// from where, and when.
+type auditEntry struct {
+	action  AuditAction
+	kind    manifest.Kind
+	meta    manifest.ObjectMeta
+	changes []AuditChange
+
+	// worker is the worker whose credential authorised the change, when one
+	// did. Empty for an operator's change.
+	worker manifest.ResourceName
+}
+
+func (a auditor) record(ctx context.Context, entry auditEntry) {
+	request := auditRequestFrom(ctx)
+	spec := AuditEventSpec{
+		Time:            time.Now(),
+		Actor:           request.Actor,
+		ActorKind:       AuditActorOperator,
+		Action:          entry.action,
+		ResourceKind:    entry.kind,
+		ResourceName:    entry.meta.Name,
+		ResourceUID:     entry.meta.UID,
+		ResourceVersion: entry.meta.Version,
+		Changes:         entry.changes,
+		SourceIP:        request.SourceIP,
+		ForwardedFor:    request.ForwardedFor,
+		RequestID:       request.RequestID,
+	}
+
+	switch {
+	case entry.worker != "":
+		// A worker's name comes from its credential, never the header.
+		spec.Actor = string(entry.worker)
+		spec.ActorKind = AuditActorWorker
+		spec.Authenticated = true
+	case spec.Actor == "":
+		spec.Actor = AnonymousActor
+	}
+
+	// Not cancelled with the request: the change has been made, and a client
+	// that hangs up as soon as it hears so must not take the record with it.
+	event := newAuditEvent(spec)
+	if err := a.store.Create(context.WithoutCancel(ctx), &event); err != nil {
+		log.Printf("failed to record audit event: %s %s %q by %s %q: %v",
+			spec.Action, spec.ResourceKind, spec.ResourceName, spec.ActorKind, spec.Actor, err)
+	}
+}
+
+// recordChange records an action as the difference between a resource before
+// and after it, in the given sections. A resource that did not exist on one
+// side is an empty manifest there.
+func (a auditor) recordChange(ctx context.Context, action AuditAction, kind manifest.Kind, before, after manifest.ResourceManifest, sections ...auditSection) {
+	meta := after.Metadata
+	if meta.Name == "" {
+		meta = before.Metadata
+	}
+
+	changes, err := auditChanges(auditDocument(before, sections...), auditDocument(after, sections...))
+	if err != nil {
+		// Recorded without the difference rather than not at all: who did what
+		// to which resource is most of the point.
+		log.Printf("failed to compare %s %q for the audit log: %v", kind, meta.Name, err)
+	}
+
+	a.record(ctx, auditEntry{action: action, kind: kind, meta: meta, changes: changes})
+}
+
+// recordFlag records an action that sets one status flag.
+func (a auditor) recordFlag(ctx context.Context, action AuditAction, kind manifest.Kind, flag string, before, after manifest.ResourceManifest) {
+	change := AuditChange{
+		Path:   string(auditStatus) + "." + flag,
+		Before: auditFlag(before, flag),
+		After:  auditFlag(after, flag),
+	}
+
+	a.record(ctx, auditEntry{action: action, kind: kind, meta: after.Metadata, changes: []AuditChange{change}})
+}
+
+// load reads a resource of a kind the way find finds it. A resource that
+// cannot be read is treated as absent, and logged: the change it is read for is
+// about to happen regardless.
+func (a auditor) load(kind manifest.Kind, find func(model any) (bool, error)) manifest.ResourceManifest {
+	model, ok := kindToModel(kind)
+	if !ok {
+		return manifest.ResourceManifest{}
+	}
+
+	if found, err := find(model); err != nil {
+		log.Printf("failed to read %s for the audit log: %v", kind, err)
+		return manifest.ResourceManifest{}
+	} else if !found {
+		return manifest.ResourceManifest{}
+	}
+
+	resource, ok := model.(interface {
+		ToManifest() manifest.ResourceManifest
+	})
+	if !ok {
+		return manifest.ResourceManifest{}
+	}
+
+	return resource.ToManifest()
+}
+
+func (a auditor) byUID(ctx context.Context, kind manifest.Kind, id manifest.ResourceID) manifest.ResourceManifest {
+	return a.load(kind, func(model any) (bool, error) { return a.store.GetByUID(ctx, model, id) })
+}
+
+func (a auditor) byName(ctx context.Context, kind manifest.Kind, name manifest.ResourceName) manifest.ResourceManifest {
+	if name == "" {
+		return manifest.ResourceManifest{}
+	}
+
+	return a.load(kind, func(model any) (bool, error) { return a.store.GetByName(ctx, model, name) })
+}
+
+// workerName names the worker with a UID, for an event it caused. The UID
+// stands in when the worker's record is gone.
+func (a auditor) workerName(ctx context.Context, id manifest.ResourceID) manifest.ResourceName {
+	if name := a.byUID(ctx, KindWorkerInstance, id).Metadata.Name; name != "" {
+		return name
+	}
+
+	return manifest.ResourceName(id)
+}
+
+// ------------------------------
+// / Declared resources
+// ------------------------------
+
+// manageableResources is the API of a resource an operator declares: read,
+// and written by manifest. Maintenance windows, rate limits and worker blocks
+// have nothing else.
+type manageableResources interface {
+	ReadableResourceAPI[manifest.ResourceManifest]
+	ManageableResourceAPI
+}
+
+// auditedResources records the writes to a declared resource.
+type auditedResources struct {
+	manageableResources
+	kind  manifest.Kind
+	audit auditor
+}
+
+func (a auditor) resources(kind manifest.Kind, api manageableResources) *auditedResources {
+	return &auditedResources{manageableResources: api, kind: kind, audit: a}
+}
+
+func (r *auditedResources) CreateOrUpdate(ctx context.Context, entry manifest.ResourceManifest) (manifest.ResourceManifest, bool, error) {
+	before := r.audit.byName(ctx, r.kind, entry.Metadata.Name)
+
+	result, created, err := r.manageableResources.CreateOrUpdate(ctx, entry)
+	if err == nil {
+		action := AuditActionUpdate
+		if created {
+			action = AuditActionCreate
+		}
+		r.audit.recordChange(ctx, action, r.kind, before, result, auditLabels, auditSpec)
+	}
+
+	return result, created, err
+}
+
+func (r *auditedResources) Create(ctx context.Context, entry manifest.ResourceManifest) (manifest.ResourceManifest, error) {
+	result, err := r.manageableResources.Create(ctx, entry)
+	if err == nil {
+		r.audit.recordChange(ctx, AuditActionCreate, r.kind, manifest.ResourceManifest{}, result, auditLabels, auditSpec)
+	}
+
+	return result, err
+}
+
+func (r *auditedResources) Update(ctx context.Context, id manifest.VersionedResourceID, entry manifest.ResourceManifest) (manifest.ResourceManifest, error) {
+	before := r.audit.byUID(ctx, r.kind, id.ID)
+
+	result, err := r.manageableResources.Update(ctx, id, entry)
+	if err == nil {
+		r.audit.recordChange(ctx, AuditActionUpdate, r.kind, before, result, auditLabels, auditSpec)
+	}
+
+	return result, err
+}
+
+func (r *auditedResources) Delete(ctx context.Context, id manifest.VersionedResourceID) (bool, error) {
+	before := r.audit.byUID(ctx, r.kind, id.ID)
+
+	deleted, err := r.manageableResources.Delete(ctx, id)
+	if err == nil && deleted {
+		r.audit.recordChange(ctx, AuditActionDelete, r.kind, before, manifest.ResourceManifest{}, auditLabels, auditSpec)
+	}
+
+	return deleted, err
+}
+
+// ------------------------------
+// / Runners and workers
+// ------------------------------
+
+type auditedRunners struct {
+	RunnersAPI
+	resources *auditedResources
+}
+
+func (r *auditedRunners) CreateOrUpdate(ctx context.Context, entry manifest.ResourceManifest) (manifest.ResourceManifest, bool, error) {
+	return r.resources.CreateOrUpdate(ctx, entry)
+}
+
+func (r *auditedRunners) Create(ctx context.Context, entry manifest.ResourceManifest) (manifest.ResourceManifest, error) {
+	return r.resources.Create(ctx, entry)
+}
+
+func (r *auditedRunners) Update(ctx context.Context, id manifest.VersionedResourceID, entry manifest.ResourceManifest) (manifest.ResourceManifest, error) {
+	return r.resources.Update(ctx, id, entry)
+}
+
+func (r *auditedRunners) Delete(ctx context.Context, id manifest.VersionedResourceID) (bool, error) {
+	return r.resources.Delete(ctx, id)
+}
+
+// GetToken records the issue of an enrolment token under the token's record,
+// which holds its fingerprint. The token itself is never written down.
+func (r *auditedRunners) GetToken(ctx context.Context, id manifest.ResourceName) (APIToken, bool, error) {
+	token, found, err := r.RunnersAPI.GetToken(ctx, id)
+	if err != nil || !found {
+		return token, found, err
+	}
+
+	audit := r.resources.audit
+	if parsed, _, parseErr := jwt.NewParser().ParseUnverified(string(token), jwt.MapClaims{}); parseErr == nil {
+		record := audit.byName(ctx, KindEnrolmentToken, manifest.ResourceName(enrolmentTokenID(parsed)))
+		audit.recordChange(ctx, AuditActionIssueToken, KindEnrolmentToken, manifest.ResourceManifest{}, record, auditLabels, auditSpec)
+	}
+
+	return token, found, err
+}
+
+func (r *auditedRunners) RevokeToken(ctx context.Context, id manifest.ResourceName, tokenID manifest.ResourceName, request RevokeTokenRequest) (EnrolmentToken, error) {
+	audit := r.resources.audit
+	before := audit.byName(ctx, KindEnrolmentToken, tokenID)
+
+	token, err := r.RunnersAPI.RevokeToken(ctx, id, tokenID, request)
+	if err == nil {
+		audit.recordChange(ctx, AuditActionRevokeToken, KindEnrolmentToken, before, token.ToManifest(), auditStatus)
+	}
+
+	return token, err
+}
+
+func (r *auditedRunners) SetDraining(ctx context.Context, id manifest.ResourceName, draining bool) (manifest.ResourceManifest, bool, error) {
+	audit := r.resources.audit
+	before := audit.byName(ctx, KindRunner, id)
+
+	result, found, err := r.RunnersAPI.SetDraining(ctx, id, draining)
+	if err == nil && found {
+		audit.recordFlag(ctx, drainAction(draining), KindRunner, "draining", before, result)
+	}
+
+	return result, found, err
+}
+
+// Auth is the deprecated registration. It is audited all the same: it admits a
+// worker exactly as AuthWorker does.
+func (r *auditedRunners) Auth(ctx context.Context, token APIToken, worker manifest.ResourceManifest) (manifest.ResourceManifest, error) {
+	audit := r.resources.audit
+	before := audit.byName(ctx, KindWorkerInstance, worker.Metadata.Name)
+
+	//lint:ignore SA1019 deliberate: this decorates the deprecated call, it does not use it.
+	result, err := r.RunnersAPI.Auth(ctx, token, worker)
+	if err == nil {
+		r.recordRegistration(ctx, before, audit.byName(ctx, KindWorkerInstance, worker.Metadata.Name))
+	}
+
+	return result, err
+}
+
+func (r *auditedRunners) AuthWorker(ctx context.Context, token APIToken, worker manifest.ResourceManifest) (WorkerRegistrationResponse, error) {
+	before := r.resources.audit.byName(ctx, KindWorkerInstance, worker.Metadata.Name)
+
+	result, err := r.RunnersAPI.AuthWorker(ctx, token, worker)
+	if err == nil {
+		r.recordRegistration(ctx, before, result.Worker)
+	}
+
+	return result, err
+}
+
+// recordRegistration records a worker joining, as the worker: the enrolment
+// token it presented has been verified by now. A worker renewing its session
+// registers again, and that is recorded too, with whatever it changed about
+// itself.
+func (r *auditedRunners) recordRegistration(ctx context.Context, before, after manifest.ResourceManifest) {
+	if after.Metadata.Name == "" {
+		return
+	}
+
+	changes, err := auditChanges(auditDocument(before, auditLabels, auditSpec), auditDocument(after, auditLabels, auditSpec))
+	if err != nil {
+		log.Printf("failed to compare worker %q for the audit log: %v", after.Metadata.Name, err)
+	}
+
+	r.resources.audit.record(ctx, auditEntry{
+		action:  AuditActionRegister,
+		kind:    KindWorkerInstance,
+		meta:    after.Metadata,
+		changes: changes,
+		worker:  after.Metadata.Name,
+	})
+}
+
+type auditedWorkers struct {
+	WorkersAPI
+	audit auditor
+}
+
+func (r *auditedWorkers) SetPaused(ctx context.Context, id manifest.ResourceName, paused bool) (manifest.ResourceManifest, bool, error) {
+	before := r.audit.byName(ctx, KindWorkerInstance, id)
+
+	result, found, err := r.WorkersAPI.SetPaused(ctx, id, paused)
+	if err == nil && found {
+		action := AuditActionResume
+		if paused {
+			action = AuditActionPause
+		}
+		r.audit.recordFlag(ctx, action, KindWorkerInstance, "paused", before, result)
+	}
+
+	return result, found, err
+}
+
+func (r *auditedWorkers) SetDraining(ctx context.Context, id manifest.ResourceName, draining bool) (manifest.ResourceManifest, bool, error) {
+	before := r.audit.byName(ctx, KindWorkerInstance, id)
+
+	result, found, err := r.WorkersAPI.SetDraining(ctx, id, draining)
+	if err == nil && found {
+		r.audit.recordFlag(ctx, drainAction(draining), KindWorkerInstance, "draining", before, result)
+	}
+
+	return result, found, err
+}
+
+func (r *auditedWorkers) Delete(ctx context.Context, id manifest.VersionedResourceID) (bool, error) {
+	before := r.audit.byUID(ctx, KindWorkerInstance, id.ID)
+
+	deleted, err := r.WorkersAPI.Delete(ctx, id)
+	if err == nil && deleted {
+		r.audit.recordChange(ctx, AuditActionDelete, KindWorkerInstance, before, manifest.ResourceManifest{}, auditLabels, auditSpec)
+	}
+
+	return deleted, err
+}
+
+func drainAction(draining bool) AuditAction {
+	if draining {
+		return AuditActionDrain
+	}
+
+	return AuditActionUndrain
+}
+
+// ------------------------------
+// / Scenarios and their runs
+// ------------------------------
+
+type auditedScenarios struct {
+	ScenarioAPI
+	resources *auditedResources
+}
+
+func (r *auditedScenarios) CreateOrUpdate(ctx context.Context, entry manifest.ResourceManifest) (manifest.ResourceManifest, bool, error) {
+	return r.resources.CreateOrUpdate(ctx, entry)
+}
+
+func (r *auditedScenarios) Create(ctx context.Context, entry manifest.ResourceManifest) (manifest.ResourceManifest, error) {
+	return r.resources.Create(ctx, entry)
+}
+
+func (r *auditedScenarios) Update(ctx context.Context, id manifest.VersionedResourceID, entry manifest.ResourceManifest) (manifest.ResourceManifest, error) {
+	return r.resources.Update(ctx, id, entry)
+}
+
+func (r *auditedScenarios) Delete(ctx context.Context, id manifest.VersionedResourceID) (bool, error) {
+	return r.resources.Delete(ctx, id)
+}
+
+func (r *auditedScenarios) UpdateScript(ctx context.Context, id manifest.VersionedResourceID, entry prob.Manifest) (bark.CreatedResponse, bool, error) {
+	audit := r.resources.audit
+	before := audit.byUID(ctx, KindScenario, id.ID)
+
+	result, found, err := r.ScenarioAPI.UpdateScript(ctx, id, entry)
+	if err == nil && found {
+		audit.recordChange(ctx, AuditActionUpdateScript, KindScenario, before, audit.byUID(ctx, KindScenario, id.ID), auditSpec)
+	}
+
+	return result, found, err
+}
+
+// auditedResults records the runs an operator starts and stops. What workers
+// report about runs is the data plane, and is not recorded.
+type auditedResults struct {
+	RunResultAPI
+	audit auditor
+}
+
+func (r *auditedResults) Create(ctx context.Context, entry manifest.ResourceManifest) (Result, error) {
+	result, err := r.RunResultAPI.Create(ctx, entry)
+	if err == nil {
+		r.audit.recordChange(ctx, AuditActionCreate, KindResult, manifest.ResourceManifest{}, result.ToManifest(), auditLabels, auditSpec)
+	}
+
+	return result, err
+}
+
+func (r *auditedResults) Cancel(ctx context.Context, runID manifest.ResourceName, request CancelRunRequest) (Result, error) {
+	before := r.audit.byName(ctx, KindResult, runID)
+
+	result, err := r.RunResultAPI.Cancel(ctx, runID, request)
+	if err == nil {
+		r.audit.recordChange(ctx, AuditActionCancel, KindResult, before, result.ToManifest(), auditStatus)
+	}
+
+	return result, err
+}
+
+// auditedArtifacts records the deletion of artifacts, by label only: an
+// artifact may carry credentials (see LabelArtifactMayContainSecrets), and the
+// audit log is read far more widely than any one artifact.
+type auditedArtifacts struct {
+	ArtifactAPI
+	audit auditor
+}
+
+func (r *auditedArtifacts) Delete(ctx context.Context, id manifest.VersionedResourceID) (bool, error) {
+	before := r.audit.byUID(ctx, KindArtifact, id.ID)
+
+	deleted, err := r.ArtifactAPI.Delete(ctx, id)
+	if err == nil && deleted {
+		r.audit.recordChange(ctx, AuditActionDelete, KindArtifact, before, manifest.ResourceManifest{}, auditLabels)
+	}
+
+	return deleted, err
+}
+
+// ------------------------------
+// / Dead letters
+// ------------------------------
+
+type auditedDispatchFailures struct {
+	DispatchFailuresAPI
+	audit auditor
+}
+
+// Report is recorded as the reporting worker's action: it strands a run.
+func (r *auditedDispatchFailures) Report(ctx context.Context, session APIToken, request ReportDispatchFailureRequest) (DispatchFailure, error) {
+	failure, err := r.DispatchFailuresAPI.Report(ctx, session, request)
+	if err != nil {
+		return failure, err
+	}
+
+	changes, diffErr := auditChanges(nil, auditDocument(failure.ToManifest(), auditLabels, auditSpec))
+	if diffErr != nil {
+		log.Printf("failed to describe dispatch failure %q for the audit log: %v", failure.Name, diffErr)
+	}
+
+	r.audit.record(ctx, auditEntry{
+		action:  AuditActionReport,
+		kind:    KindDispatchFailure,
+		meta:    failure.ObjectMeta,
+		changes: changes,
+		worker:  r.audit.workerName(ctx, failure.Spec.WorkerUID),
+	})
+
+	return failure, nil
+}
+
+func (r *auditedDispatchFailures) Retry(ctx context.Context, id manifest.ResourceName, request RetryDispatchFailureRequest) (DispatchFailure, Result, error) {
+	before := r.audit.byName(ctx, KindDispatchFailure, id)
+
+	failure, retry, err := r.DispatchFailuresAPI.Retry(ctx, id, request)
+	if err == nil {
+		r.audit.recordChange(ctx, AuditActionRetry, KindDispatchFailure, before, failure.ToManifest(), auditStatus)
+	}
+
+	return failure, retry, err
+}
+
+func (r *auditedDispatchFailures) Resolve(ctx context.Context, id manifest.ResourceName) (DispatchFailure, error) {
+	before := r.audit.byName(ctx, KindDispatchFailure, id)
+
+	failure, err := r.DispatchFailuresAPI.Resolve(ctx, id)
+	if err == nil {
+		r.audit.recordChange(ctx, AuditActionResolve, KindDispatchFailure, before, failure.ToManifest(), auditStatus)
+	}
+
+	return failure, err
+}
+
+// ------------------------------
+// / Audit log API
+// ------------------------------
+
+// auditExportPage is how many events an export reads at a time.
+const auditExportPage = 500
+
+type auditLogAPIImpl struct {
+	store dbstore.TransactionalStore
+}
+
+// List returns events newest first: the question is usually what just happened.
+func (m *auditLogAPIImpl) List(ctx context.Context, searchQuery manifest.SearchQuery) (results []AuditEvent, total int64, err error) {
+	total, err = m.store.Find(ctx, &results, searchQuery, dbstore.OrderByCreatedAt(dbstore.OrderDescending))
+	return
+}
+
+func (m *auditLogAPIImpl) Get(ctx context.Context, id manifest.ResourceName) (result AuditEvent, exists bool, err error) {
+	exists, err = m.store.GetByName(ctx, &result, id)
+	return
+}
+
+// Export reads oldest first, a page at a time, each page the events after the
+// last one read. Events are only ever appended, but an offset would still skip
+// one: created_at is not unique, and events written in one instant come back in
+// no particular order, so two pages read by offset could each see a different
+// one of a tie. Ordering by (created_at, uid) and starting each page after the
+// last event read visits every event exactly once, and reads no more of the
+// table for the thousandth page than for the first.
+func (m *auditLogAPIImpl) Export(ctx context.Context, searchQuery manifest.SearchQuery, visit func(AuditEvent) error) error {
+	searchQuery.Offset = 0
+	searchQuery.Limit = auditExportPage
+
+	var last *AuditEvent
+	for {
+		var page []AuditEvent
+		if _, err := m.store.Find(ctx, &page, searchQuery, auditEventsAfter(last)); err != nil {
+			return err
+		}
+
+		for _, event := range page {
+			if err := visit(event); err != nil {
+				return err
+			}
+		}
+
+		if len(page) < auditExportPage {
+			return nil
+		}
+		last = &page[len(page)-1]
+	}
+}
+
+// auditEventsAfter orders events by (created_at, uid) and, given the last event
+// read, selects those after it.
+func auditEventsAfter(last *AuditEvent) dbstore.Option {
+	return func(db *gorm.DB) *gorm.DB {
+		if last != nil {
+			db = db.Where("(created_at, uid) > (?, ?)", last.CreatedAt, last.UID)
+		}
+
+		return db.Order("created_at ASC").Order("uid ASC")
+	}
+}
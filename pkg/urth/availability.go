@@ -0,0 +1,413 @@
+package urth
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// Availability reporting: "what was our uptime from eu-west last month", answered
+// from run history rather than from a spreadsheet.
+//
+// Availability here is run-based -- the share of measurements that succeeded --
+// not time-based. A prober only knows what it saw when it looked, and inventing
+// a state for the minutes between two runs would be reporting a guess with a
+// decimal point on it. Incidents are the one place time comes back in: an
+// incident runs from the first failed measurement to the next good one, because
+// that is the interval nobody could show the target was up.
+//
+// The report reads the same window a stats request does, so it covers history
+// the retention sweep has already rolled up. Over that part, counts are exact
+// and incident boundaries are only as fine as the buckets: an hour with both
+// failures and successes in it cannot say which came first.
+
+// DefaultSLOWindow is the window an SLO is measured over when it names none.
+// A month is what availability is usually promised over.
+const DefaultSLOWindow = 30 * 24 * time.Hour
+
+// SLO is a scenario's availability objective.
+type SLO struct {
+	// Target is the share of runs that must succeed, as a percentage: 99.9
+	// means three nines.
+	Target float64 `form:"target" json:"target" yaml:"target" xml:"target"`
+
+	// Window is the rolling period the target applies to, and the default
+	// range of an availability report. Defaults to DefaultSLOWindow.
+	Window time.Duration `form:"window" json:"window,omitempty" yaml:"window,omitempty" xml:"window,omitempty"`
+}
+
+// Validate refuses an objective that cannot be measured against.
+func (s SLO) Validate() error {
+	// 100 is refused along with 0: a target of 100% has no error budget, so
+	// every report would divide by zero to say something everybody already knew.
+	if s.Target <= 0 || s.Target >= 100 {
+		return fmt.Errorf("SLO target must be a percentage between 0 and 100 exclusive, got %v", s.Target)
+	}
+	if s.Window < 0 {
+		return fmt.Errorf("SLO window must not be negative, got %v", s.Window)
+	}
+
+	return nil
+}
+
+// window is the period the objective is measured over, defaulted.
+func (s *SLO) window() time.Duration {
+	if s == nil || s.Window <= 0 {
+		return DefaultSLOWindow
+	}
+
+	return s.Window
+}
+
+// validateSLO refuses a scenario whose objective is nonsense.
+func validateSLO(slo *SLO) error {
+	if slo == nil {
+		return nil
+	}
+
+	if err := slo.Validate(); err != nil {
+		return fmt.Errorf("scenario's SLO is invalid: %w", err)
+	}
+
+	return nil
+}
+
+// AvailabilityQuery selects the range and breakdown of an availability report.
+type AvailabilityQuery struct {
+	// From and Till bound the range, by when runs were due. Till defaults to
+	// now and From to the scenario's SLO window before it.
+	From time.Time `form:"from" json:"from,omitempty" yaml:"from,omitempty" xml:"from,omitempty" time_format:"2006-01-02T15:04:05Z07:00"`
+	Till time.Time `form:"till" json:"till,omitempty" yaml:"till,omitempty" xml:"till,omitempty" time_format:"2006-01-02T15:04:05Z07:00"`
+
+	// GroupBy adds a report per runner. Only "runner" is understood.
+	GroupBy string `form:"groupBy" json:"groupBy,omitempty" yaml:"groupBy,omitempty" xml:"groupBy,omitempty"`
+}
+
+// Normalize fills defaults and refuses what cannot be answered.
+func (q AvailabilityQuery) Normalize(now time.Time, slo *SLO) (AvailabilityQuery, error) {
+	switch q.GroupBy {
+	case "", StatsGroupByRunner:
+	default:
+		return q, fmt.Errorf("%w: cannot group by %q, only by %q", ErrInvalidStatsQuery, q.GroupBy, StatsGroupByRunner)
+	}
+
+	if q.Till.IsZero() {
+		q.Till = now
+	}
+	if q.From.IsZero() {
+		q.From = q.Till.Add(-slo.window())
+	}
+	if !q.From.Before(q.Till) {
+		return q, fmt.Errorf("%w: from (%v) must be before till (%v)", ErrInvalidStatsQuery,
+			q.From.Format(time.RFC3339), q.Till.Format(time.RFC3339))
+	}
+
+	q.From, q.Till = q.From.UTC(), q.Till.UTC()
+
+	return q, nil
+}
+
+// AvailabilityReport is a scenario's availability over a range.
+type AvailabilityReport struct {
+	Scenario manifest.ResourceName `form:"scenario" json:"scenario" yaml:"scenario" xml:"scenario"`
+
+	// Query is the request as answered, with its defaults filled in.
+	Query AvailabilityQuery `form:"query" json:"query" yaml:"query" xml:"query"`
+
+	// SLO is the objective the budget figures are measured against. Nil when
+	// the scenario declares none, in which case only availability is reported.
+	SLO *SLO `form:"slo,omitempty" json:"slo,omitempty" yaml:"slo,omitempty" xml:"slo,omitempty"`
+
+	// Overall is every vantage point together.
+	Overall Availability `form:"overall" json:"overall" yaml:"overall" xml:"overall"`
+
+	// Runners breaks the report down per runner, when asked to.
+	Runners []Availability `form:"runners,omitempty" json:"runners,omitempty" yaml:"runners,omitempty" xml:"runners,omitempty"`
+}
+
+// Availability is the availability of one scenario from one vantage point, or
+// from all of them.
+type Availability struct {
+	RunnerID   manifest.ResourceID   `form:"runnerId,omitempty" json:"runnerId,omitempty" yaml:"runnerId,omitempty" xml:"runnerId,omitempty"`
+	RunnerName manifest.ResourceName `form:"runnerName,omitempty" json:"runnerName,omitempty" yaml:"runnerName,omitempty" xml:"runnerName,omitempty"`
+
+	// Good and Bad count the runs that measured the target: successes, and
+	// failures or timeouts. Excluded counts the runs that measured nothing --
+	// cancelled, or never run because the server could not schedule them --
+	// which say something about urth, not about the thing being probed; and
+	// the runs taken inside a maintenance window, which say something about the
+	// maintenance.
+	Good     uint64 `form:"good" json:"good" yaml:"good" xml:"good"`
+	Bad      uint64 `form:"bad" json:"bad" yaml:"bad" xml:"bad"`
+	Excluded uint64 `form:"excluded" json:"excluded" yaml:"excluded" xml:"excluded"`
+
+	// Consequential counts the Bad runs that failed while a dependency was
+	// failing too. They stay in Bad -- the target was unreachable, whatever
+	// the reason -- and are counted again here so that a report can say how
+	// much of the damage was somebody else's outage. See ScenarioDependency.
+	Consequential uint64 `form:"consequential" json:"consequential" yaml:"consequential" xml:"consequential"`
+
+	// Availability is Good as a percentage of Good and Bad. Nil when nothing
+	// was measured: no data is not the same as 0% or 100%.
+	Availability *float64 `form:"availability,omitempty" json:"availability,omitempty" yaml:"availability,omitempty" xml:"availability,omitempty"`
+
+	// ErrorBudgetRemaining is the share of the SLO's allowed failures not yet
+	// spent, as a percentage. Negative once the objective is missed. Nil without
+	// an SLO or without measurements.
+	ErrorBudgetRemaining *float64 `form:"errorBudgetRemaining,omitempty" json:"errorBudgetRemaining,omitempty" yaml:"errorBudgetRemaining,omitempty" xml:"errorBudgetRemaining,omitempty"`
+
+	// BurnRate is how fast the budget was spent relative to the rate that would
+	// exactly exhaust it: 1 spends it all by the end of the window, 10 ten
+	// times over.
+	BurnRate *float64 `form:"burnRate,omitempty" json:"burnRate,omitempty" yaml:"burnRate,omitempty" xml:"burnRate,omitempty"`
+
+	// MTTR is the mean duration of the incidents that ended in range.
+	MTTR time.Duration `form:"mttr" json:"mttr" yaml:"mttr" xml:"mttr"`
+
+	Incidents []Incident `form:"incidents,omitempty" json:"incidents,omitempty" yaml:"incidents,omitempty" xml:"incidents,omitempty"`
+}
+
+// Incident is an interval nobody could show the target was up: from the first
+// failed run to the next good one.
+type Incident struct {
+	Start time.Time `form:"start" json:"start" yaml:"start" xml:"start"`
+
+	// End is when the next good run was due. Nil while the incident is still
+	// open at the end of the range.
+	End *time.Time `form:"end,omitempty" json:"end,omitempty" yaml:"end,omitempty" xml:"end,omitempty"`
+
+	// Duration runs to End, or to the end of the range for an open incident.
+	Duration time.Duration `form:"duration" json:"duration" yaml:"duration" xml:"duration"`
+
+	// FailedRuns counts the bad runs in it.
+	FailedRuns uint64 `form:"failedRuns" json:"failedRuns" yaml:"failedRuns" xml:"failedRuns"`
+
+	// Consequential is set when every failed run in the incident failed while
+	// a dependency was failing too: the incident is a consequence of another
+	// one, not a root cause. One failure of its own makes it a root cause.
+	Consequential bool `form:"consequential" json:"consequential" yaml:"consequential" xml:"consequential"`
+
+	// Upstream names the dependencies its failed runs were put down to. Only
+	// live runs name theirs; a rolled-up bucket counts its consequential runs
+	// but no longer knows whose.
+	Upstream []manifest.ResourceName `form:"upstream,omitempty" json:"upstream,omitempty" yaml:"upstream,omitempty" xml:"upstream,omitempty"`
+}
+
+// availabilityOutcome sorts a run outcome into what it says about the target.
+type availabilityOutcome int
+
+const (
+	availabilityExcluded availabilityOutcome = iota
+	availabilityGood
+	availabilityBad
+)
+
+func classifyOutcome(outcome prob.RunStatus) availabilityOutcome {
+	switch outcome {
+	case prob.RunFinishedSuccess, prob.RunFinishedDegraded:
+		// A degraded run answered, only slowly; see LatencyPolicy.Degrade.
+		return availabilityGood
+	case prob.RunFinishedFailed, prob.RunFinishedTimeout:
+		return availabilityBad
+	default:
+		return availabilityExcluded
+	}
+}
+
+// availabilityObservation is one run, or one rolled-up bucket of them, in time.
+type availabilityObservation struct {
+	at         time.Time
+	runnerID   manifest.ResourceID
+	runnerName manifest.ResourceName
+
+	good, bad, excluded uint64
+
+	// consequential counts the bad runs that failed behind a failing
+	// dependency, and upstream names it for a live run.
+	consequential uint64
+	upstream      manifest.ResourceName
+}
+
+// observations flattens a window into one time-ordered sequence.
+func (w ResultStatsWindow) observations() []availabilityObservation {
+	result := make([]availabilityObservation, 0, len(w.Rollups)+len(w.Runs))
+
+	for _, rollup := range w.Rollups {
+		observation := availabilityObservation{
+			at:         rollup.BucketStart,
+			runnerID:   rollup.RunnerID,
+			runnerName: rollup.RunnerName,
+		}
+		for outcome, count := range rollup.Outcomes {
+			// Runs inside a maintenance window measured the maintenance.
+			inMaintenance := min(rollup.Maintenance[outcome], count)
+			observation.excluded += inMaintenance
+			count -= inMaintenance
+
+			switch classifyOutcome(outcome) {
+			case availabilityGood:
+				observation.good += count
+			case availabilityBad:
+				observation.bad += count
+				observation.consequential += min(rollup.Consequential[outcome], count)
+			default:
+				observation.excluded += count
+			}
+		}
+		result = append(result, observation)
+	}
+
+	for _, run := range w.Runs {
+		at, ok := resultTime(run)
+		if !ok || !run.Status.Status.IsTerminal() {
+			continue
+		}
+
+		observation := availabilityObservation{
+			at:         at,
+			runnerID:   run.Status.Executor.RunnerID,
+			runnerName: run.Status.Executor.RunnerName,
+		}
+		outcome := classifyOutcome(ResultOutcome(run))
+		if run.Status.Maintenance != "" {
+			outcome = availabilityExcluded
+		}
+
+		switch outcome {
+		case availabilityGood:
+			observation.good = 1
+		case availabilityBad:
+			observation.bad = 1
+			if run.Status.UpstreamFailed != "" {
+				observation.consequential = 1
+				observation.upstream = run.Status.UpstreamFailed
+			}
+		default:
+			observation.excluded = 1
+		}
+		result = append(result, observation)
+	}
+
+	slices.SortStableFunc(result, func(x, y availabilityObservation) int {
+		return x.at.Compare(y.at)
+	})
+
+	return result
+}
+
+// Availability computes a report over a window.
+func (w ResultStatsWindow) Availability(query AvailabilityQuery, slo *SLO) AvailabilityReport {
+	observations := w.observations()
+
+	report := AvailabilityReport{
+		Query:   query,
+		SLO:     slo,
+		Overall: availabilityOf(observations, query.Till, slo),
+	}
+
+	if query.GroupBy == StatsGroupByRunner {
+		byRunner := map[manifest.ResourceID][]availabilityObservation{}
+		for _, observation := range observations {
+			byRunner[observation.runnerID] = append(byRunner[observation.runnerID], observation)
+		}
+
+		for runnerID, runnerObservations := range byRunner {
+			availability := availabilityOf(runnerObservations, query.Till, slo)
+			availability.RunnerID = runnerID
+			availability.RunnerName = runnerObservations[len(runnerObservations)-1].runnerName
+			report.Runners = append(report.Runners, availability)
+		}
+
+		slices.SortFunc(report.Runners, func(x, y Availability) int {
+			return strings.Compare(string(x.RunnerID), string(y.RunnerID))
+		})
+	}
+
+	return report
+}
+
+// availabilityOf summarises a time-ordered sequence of observations.
+//
+// An observation with no good runs and some bad ones opens an incident, or
+// extends an open one; any good run closes it. A rolled-up bucket with both is
+// taken as the target having been up at some point in it, which is the most
+// the bucket can say.
+func availabilityOf(observations []availabilityObservation, till time.Time, slo *SLO) Availability {
+	var result Availability
+	var open *Incident
+	var consequential uint64
+
+	settle := func() {
+		open.Consequential = consequential == open.FailedRuns
+		slices.Sort(open.Upstream)
+		result.Incidents = append(result.Incidents, *open)
+		open, consequential = nil, 0
+	}
+
+	for _, observation := range observations {
+		result.Good += observation.good
+		result.Bad += observation.bad
+		result.Excluded += observation.excluded
+		result.Consequential += observation.consequential
+
+		switch {
+		case observation.good > 0:
+			if open != nil {
+				end := observation.at
+				open.End = &end
+				open.Duration = end.Sub(open.Start)
+				settle()
+			}
+		case observation.bad > 0:
+			if open == nil {
+				open = &Incident{Start: observation.at}
+			}
+			open.FailedRuns += observation.bad
+			consequential += observation.consequential
+			if observation.upstream != "" && !slices.Contains(open.Upstream, observation.upstream) {
+				open.Upstream = append(open.Upstream, observation.upstream)
+			}
+		}
+	}
+
+	if open != nil {
+		open.Duration = till.Sub(open.Start)
+		settle()
+	}
+
+	var resolved int
+	var repairing time.Duration
+	for _, incident := range result.Incidents {
+		if incident.End != nil {
+			resolved++
+			repairing += incident.Duration
+		}
+	}
+	if resolved > 0 {
+		result.MTTR = repairing / time.Duration(resolved)
+	}
+
+	measured := result.Good + result.Bad
+	if measured == 0 {
+		return result
+	}
+
+	availability := 100 * float64(result.Good) / float64(measured)
+	result.Availability = &availability
+
+	if slo != nil {
+		allowed := (100 - slo.Target) / 100
+		failureRate := float64(result.Bad) / float64(measured)
+
+		burnRate := failureRate / allowed
+		remaining := 100 * (1 - burnRate)
+		result.BurnRate = &burnRate
+		result.ErrorBudgetRemaining = &remaining
+	}
+
+	return result
+}
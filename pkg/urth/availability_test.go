@@ -0,0 +1,169 @@
+package urth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAvailabilityFindsIncidentsBetweenGoodRuns(t *testing.T) {
+	at := func(minutes int) time.Time { return rollupEpoch.Add(time.Duration(minutes) * time.Minute) }
+
+	window := ResultStatsWindow{Runs: []Result{
+		finishedRun(at(0), time.Second, prob.RunFinishedSuccess, "eu"),
+		finishedRun(at(1), time.Second, prob.RunFinishedFailed, "eu"),
+		finishedRun(at(2), time.Second, prob.RunFinishedTimeout, "eu"),
+		finishedRun(at(3), time.Second, prob.RunFinishedCanceled, "eu"),
+		finishedRun(at(4), time.Second, prob.RunFinishedSuccess, "eu"),
+		finishedRun(at(9), time.Second, prob.RunFinishedFailed, "eu"),
+	}}
+	query := AvailabilityQuery{From: rollupEpoch, Till: at(10)}
+
+	report := window.Availability(query, &SLO{Target: 90})
+
+	overall := report.Overall
+	require.EqualValues(t, 2, overall.Good)
+	require.EqualValues(t, 3, overall.Bad)
+	require.EqualValues(t, 1, overall.Excluded, "a cancelled run measured nothing")
+	require.InDelta(t, 40, *overall.Availability, 1e-9)
+
+	// 60% failures against a 10% allowance.
+	require.InDelta(t, 6, *overall.BurnRate, 1e-9)
+	require.InDelta(t, -500, *overall.ErrorBudgetRemaining, 1e-9)
+
+	require.Len(t, overall.Incidents, 2)
+	require.Equal(t, at(1), overall.Incidents[0].Start)
+	require.Equal(t, at(4), *overall.Incidents[0].End)
+	require.EqualValues(t, 2, overall.Incidents[0].FailedRuns)
+	require.Nil(t, overall.Incidents[1].End, "the last incident is still open")
+	require.Equal(t, time.Minute, overall.Incidents[1].Duration)
+
+	require.Equal(t, 3*time.Minute, overall.MTTR, "only resolved incidents count towards MTTR")
+}
+
+func TestAvailabilityWithoutMeasurementsIsUnknown(t *testing.T) {
+	report := ResultStatsWindow{}.Availability(AvailabilityQuery{}, &SLO{Target: 99})
+
+	require.Nil(t, report.Overall.Availability)
+	require.Nil(t, report.Overall.BurnRate)
+}
+
+func TestAvailabilityWithoutSLOReportsNoBudget(t *testing.T) {
+	window := ResultStatsWindow{Runs: []Result{
+		finishedRun(rollupEpoch, time.Second, prob.RunFinishedSuccess, "eu"),
+	}}
+
+	report := window.Availability(AvailabilityQuery{}, nil)
+
+	require.InDelta(t, 100, *report.Overall.Availability, 1e-9)
+	require.Nil(t, report.Overall.ErrorBudgetRemaining)
+}
+
+func TestAvailabilityGroupsByRunnerAcrossRolledUpHistory(t *testing.T) {
+	sweep := newRollupAccumulator(RollupHourly, true)
+	sweep.addResult(finishedRun(rollupEpoch, time.Second, prob.RunFinishedFailed, "us"))
+	sweep.addResult(finishedRun(rollupEpoch.Add(time.Minute), time.Second, prob.RunFinishedSuccess, "eu"))
+
+	window := ResultStatsWindow{
+		Rollups: sweep.rollups(),
+		Runs: []Result{
+			finishedRun(rollupEpoch.Add(2*time.Hour), time.Second, prob.RunFinishedSuccess, "us"),
+		},
+	}
+
+	report := window.Availability(AvailabilityQuery{GroupBy: StatsGroupByRunner, Till: rollupEpoch.Add(3 * time.Hour)}, nil)
+
+	require.Len(t, report.Runners, 2)
+	eu, us := report.Runners[0], report.Runners[1]
+	require.EqualValues(t, "eu", eu.RunnerName)
+	require.Empty(t, eu.Incidents)
+	require.EqualValues(t, "us", us.RunnerName)
+	require.Len(t, us.Incidents, 1)
+	require.Equal(t, 2*time.Hour, us.Incidents[0].Duration, "a rolled-up bucket is as precise as its start")
+}
+
+func TestSLOIsValidated(t *testing.T) {
+	require.NoError(t, validateScenario(ScenarioSpec{SLO: &SLO{Target: 99.9}}))
+	require.Error(t, validateScenario(ScenarioSpec{SLO: &SLO{Target: 100}}))
+	require.Error(t, validateScenario(ScenarioSpec{SLO: &SLO{Target: 99, Window: -time.Hour}}))
+}
+
+// A run taken inside a maintenance window counts for nothing, whether it is
+// still live or already rolled up: otherwise the report would change the day the
+// sweep reached it.
+func TestAvailabilityLeavesOutRunsInMaintenance(t *testing.T) {
+	inMaintenance := func(run Result) Result {
+		run.Status.Maintenance = "db-upgrade"
+		return run
+	}
+
+	sweep := newRollupAccumulator(RollupHourly, true)
+	sweep.addResult(finishedRun(rollupEpoch, time.Second, prob.RunFinishedSuccess, "eu"))
+	sweep.addResult(inMaintenance(finishedRun(rollupEpoch.Add(time.Minute), time.Second, prob.RunFinishedFailed, "eu")))
+
+	window := ResultStatsWindow{
+		Rollups: sweep.rollups(),
+		Runs: []Result{
+			inMaintenance(finishedRun(rollupEpoch.Add(2*time.Hour), time.Second, prob.RunFinishedFailed, "eu")),
+			finishedRun(rollupEpoch.Add(3*time.Hour), time.Second, prob.RunFinishedSuccess, "eu"),
+		},
+	}
+
+	overall := window.Availability(AvailabilityQuery{Till: rollupEpoch.Add(4 * time.Hour)}, &SLO{Target: 99}).Overall
+	require.EqualValues(t, 2, overall.Good)
+	require.Zero(t, overall.Bad)
+	require.EqualValues(t, 2, overall.Excluded)
+	require.Empty(t, overall.Incidents)
+	require.InDelta(t, 100, *overall.Availability, 1e-9)
+
+	// Stats still count them: they ran.
+	buckets := window.Summarize(ResultStatsQuery{From: rollupEpoch, Till: rollupEpoch.Add(4 * time.Hour), Granularity: RollupHourly})
+	var total uint64
+	for _, bucket := range buckets {
+		total += bucket.Total
+	}
+	require.EqualValues(t, 4, total)
+}
+
+// Failures behind a failing dependency still count as down, but an incident
+// made only of them is marked as somebody else's, and says whose.
+func TestAvailabilityMarksConsequentialIncidents(t *testing.T) {
+	at := func(minutes int) time.Time { return rollupEpoch.Add(time.Duration(minutes) * time.Minute) }
+	behindGateway := func(run Result) Result {
+		run.Status.UpstreamFailed = "vpn-gateway"
+		return run
+	}
+
+	window := ResultStatsWindow{Runs: []Result{
+		behindGateway(finishedRun(at(0), time.Second, prob.RunFinishedFailed, "eu")),
+		behindGateway(finishedRun(at(1), time.Second, prob.RunFinishedTimeout, "eu")),
+		finishedRun(at(2), time.Second, prob.RunFinishedSuccess, "eu"),
+		// One failure of its own makes the next incident a root cause.
+		behindGateway(finishedRun(at(3), time.Second, prob.RunFinishedFailed, "eu")),
+		finishedRun(at(4), time.Second, prob.RunFinishedFailed, "eu"),
+		finishedRun(at(5), time.Second, prob.RunFinishedSuccess, "eu"),
+	}}
+
+	overall := window.Availability(AvailabilityQuery{Till: at(6)}, nil).Overall
+	require.EqualValues(t, 4, overall.Bad)
+	require.EqualValues(t, 3, overall.Consequential)
+
+	require.Len(t, overall.Incidents, 2)
+	require.True(t, overall.Incidents[0].Consequential)
+	require.Equal(t, []manifest.ResourceName{"vpn-gateway"}, overall.Incidents[0].Upstream)
+	require.False(t, overall.Incidents[1].Consequential)
+
+	// And the same once the sweep has rolled them up.
+	sweep := newRollupAccumulator(RollupHourly, true)
+	for _, run := range window.Runs[:2] {
+		sweep.addResult(run)
+	}
+
+	rolledUp := ResultStatsWindow{Rollups: sweep.rollups()}.Availability(AvailabilityQuery{Till: at(6)}, nil).Overall
+	require.EqualValues(t, 2, rolledUp.Consequential)
+	require.Len(t, rolledUp.Incidents, 1)
+	require.True(t, rolledUp.Incidents[0].Consequential)
+}
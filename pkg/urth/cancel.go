@@ -0,0 +1,254 @@
+package urth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/wyrd/pkg/bark"
+	"github.com/sre-norns/wyrd/pkg/dbstore"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// Cancelling a run means two different things depending on where the run is.
+//
+// A run nobody has claimed is still only a row and, perhaps, a queued message.
+// Cancelling it is a bookkeeping decision the server can make on its own: the
+// Result becomes terminal at once, and its dispatch is withdrawn so no worker is
+// handed a job it would only refuse. A run that is executing belongs, for the
+// moment, to the worker holding it. The server cannot stop a probe it is not
+// running, so it records the request, moves the Result to `cancelling`, and
+// asks the worker to stop. The worker's report of how the run ended is what
+// finally settles it -- and if the worker finished before the request reached
+// it, that report stands, because a run that completed did complete.
+//
+// A worker that dies holding a cancelling run is the same as one that dies
+// holding a running one: its lease elapses and the reconciler expires it. An
+// operator cancelled it, but nothing confirmed it stopped, and `timeout` is
+// the honest record of that.
+
+// RunCancellation is who asked for a run to stop, and when.
+type RunCancellation struct {
+	// RequestedBy is the operator who asked, as they identified themselves.
+	// See CancelRunRequest for why that is a claim and not a proof.
+	RequestedBy string `form:"requestedBy" json:"requestedBy" yaml:"requestedBy" xml:"requestedBy"`
+
+	// Reason is the operator's explanation, if they gave one.
+	Reason string `form:"reason,omitempty" json:"reason,omitempty" yaml:"reason,omitempty" xml:"reason,omitempty"`
+
+	// RequestedAt is when the server accepted the request.
+	RequestedAt time.Time `form:"requestedAt" json:"requestedAt" yaml:"requestedAt" xml:"requestedAt"`
+}
+
+// RunCanceller is the transport's half of cancelling a run.
+//
+// Owned here and implemented by transport packages, for the reason
+// RunnerChannelReconciler is: the domain knows that a worker executing a run
+// must be told to stop and that a queued dispatch must stop being deliverable,
+// and nothing about subjects or streams.
+type RunCanceller interface {
+	// SignalCancel asks whichever worker is executing a run to stop it. Best
+	// effort: a worker that misses the signal finishes the run and reports it,
+	// which is a completed run rather than a lost one.
+	SignalCancel(ctx context.Context, result Result) error
+
+	// DropDispatch withdraws a published message that is no longer wanted.
+	// Withdrawing something already gone is not an error.
+	DropDispatch(ctx context.Context, entry DispatchOutboxEntry) error
+}
+
+// RunDispatchStore finds and retires the dispatches written for one run.
+//
+// Its own interface over gorm for the reason ReconcileStore is: the outbox is
+// not a resource, and "every entry not yet retired for this run" is not a
+// question the UID-addressed store can ask.
+type RunDispatchStore interface {
+	// UnretiredDispatches lists a run's outbox entries that are not retired,
+	// published or not.
+	UnretiredDispatches(ctx context.Context, resultUID manifest.ResourceID) ([]DispatchOutboxEntry, error)
+
+	// RetireDispatch marks an entry as one that will never be published.
+	RetireDispatch(ctx context.Context, id uint, at time.Time, reason string) error
+}
+
+// cancelAttempts bounds how many times a cancellation re-reads a run that moved
+// under it. A run changes state a handful of times in its life, so losing more
+// than a couple of races in a row means something is rewriting it in a loop.
+const cancelAttempts = 3
+
+// Cancel stops a run, or asks the worker executing it to.
+func (m *resultsAPIImpl) Cancel(ctx context.Context, runID manifest.ResourceName, request CancelRunRequest) (Result, error) {
+	for range cancelAttempts {
+		var entry Result
+		if ok, err := m.store.GetByName(ctx, &entry, runID); err != nil {
+			return Result{}, err
+		} else if !ok {
+			return Result{}, bark.ErrResourceNotFound
+		}
+
+		switch status := entry.Status.Status; {
+		case status.IsTerminal():
+			return entry, fmt.Errorf("%w: run %q has already finished (%v)", bark.ErrResourceVersionConflict, entry.Name, status)
+		case status == JobCancelling:
+			// Asked twice. The first request is the one on record; the second
+			// changes nothing, and answering it with an error would have an
+			// operator retrying a request that already worked.
+			return entry, nil
+		}
+
+		cancellation := &RunCancellation{
+			RequestedBy: request.RequestedBy,
+			Reason:      request.Reason,
+			RequestedAt: time.Now(),
+		}
+
+		var (
+			cancelled bool
+			err       error
+		)
+		if entry.Status.Status == JobPending {
+			cancelled, err = m.cancelPending(ctx, &entry, cancellation)
+		} else {
+			cancelled, err = m.cancelRunning(ctx, &entry, cancellation)
+		}
+		if err != nil {
+			return Result{}, err
+		}
+		if cancelled {
+			return entry, nil
+		}
+
+		// Lost a race -- claimed, reported, or expired in between. Read it
+		// again and decide afresh; what it has become decides what cancelling
+		// it means.
+		log.Printf("run %q changed while being cancelled; retrying", entry.Name)
+	}
+
+	return Result{}, fmt.Errorf("%w: run %q kept changing while being cancelled", bark.ErrResourceVersionConflict, runID)
+}
+
+// cancelPending finishes a run nobody has claimed, then withdraws its dispatch.
+//
+// The Result is written first and on its own. Once it is terminal every claim
+// is refused, so a dispatch that slips out before it is withdrawn is acked away
+// as obsolete rather than executed; withdrawing it is what spares a worker the
+// round trip, not what makes the cancellation hold.
+func (m *resultsAPIImpl) cancelPending(ctx context.Context, entry *Result, cancellation *RunCancellation) (bool, error) {
+	now := cancellation.RequestedAt
+
+	entry.Spec.TimeEnded = &now
+	entry.Status.Status = JobCompleted
+	entry.Status.Result = prob.RunFinishedCanceled
+	entry.Status.Cancellation = cancellation
+	entry.Labels = manifest.MergeLabels(entry.Labels, manifest.Labels{
+		LabelResultJobState: string(entry.Status.Status),
+		LabelResultStatus:   string(entry.Status.Result),
+	})
+
+	// Version-guarded for the reason the claim is: this decides the race with
+	// a worker claiming the same run. Whichever lands second sees a stale
+	// version and does not apply.
+	if ok, err := m.store.Update(ctx, entry, entry.UID, dbstore.WithVersion(entry.Version)); err != nil {
+		return false, fmt.Errorf("failed to cancel run %q: %w", entry.Name, err)
+	} else if !ok {
+		return false, nil
+	}
+
+	log.Printf("pending run %q cancelled by %q", entry.Name, cancellation.RequestedBy)
+
+	m.withdrawDispatches(ctx, *entry, now)
+
+	return true, nil
+}
+
+// withdrawDispatches clears a cancelled run's outbox entries and any message
+// already queued for it.
+//
+// Failures are logged and left: the entry stays unretired, and the reconciler's
+// stale-dispatch sweep finds every unretired entry of a terminal run and does
+// exactly this on its next scan. Failing the request would tell an operator
+// their cancellation did not happen when it did.
+func (m *resultsAPIImpl) withdrawDispatches(ctx context.Context, entry Result, now time.Time) {
+	if m.dispatches == nil {
+		return
+	}
+
+	dispatches, err := m.dispatches.UnretiredDispatches(ctx, entry.UID)
+	if err != nil {
+		log.Printf("could not look up the dispatches of cancelled run %q: %v", entry.Name, err)
+		return
+	}
+
+	for _, dispatch := range dispatches {
+		reason := "run cancelled before it was claimed"
+
+		if dispatch.PublishedAt != nil && dispatch.PublishedSeq != 0 {
+			if m.canceller == nil {
+				// Nothing here can reach the queue. Leave the entry for the
+				// reconciler, which is built with a transport that can.
+				continue
+			}
+			if err := m.canceller.DropDispatch(ctx, dispatch); err != nil {
+				log.Printf("could not withdraw dispatch %v of cancelled run %q: %v", dispatch.EventUID, entry.Name, err)
+				continue
+			}
+			reason = "run cancelled before it was claimed; queued message withdrawn"
+		}
+
+		if err := m.dispatches.RetireDispatch(ctx, dispatch.ID, now, reason); err != nil {
+			log.Printf("could not retire dispatch %v of cancelled run %q: %v", dispatch.EventUID, entry.Name, err)
+		}
+	}
+}
+
+// cancelRunning records the request against an executing run and signals the
+// worker holding it.
+//
+// Recorded before the signal, so a worker that stops at once and reports finds
+// the request already there. The signal is best effort and its failure is only
+// logged: the request is on record, and the run ends -- cancelled, finished or
+// expired -- whether or not the worker heard.
+func (m *resultsAPIImpl) cancelRunning(ctx context.Context, entry *Result, cancellation *RunCancellation) (bool, error) {
+	entry.Status.Status = JobCancelling
+	entry.Status.Cancellation = cancellation
+	entry.Labels = manifest.MergeLabels(entry.Labels, manifest.Labels{
+		LabelResultJobState: string(entry.Status.Status),
+	})
+
+	if ok, err := m.store.Update(ctx, entry, entry.UID, dbstore.WithVersion(entry.Version)); err != nil {
+		return false, fmt.Errorf("failed to cancel run %q: %w", entry.Name, err)
+	} else if !ok {
+		return false, nil
+	}
+
+	log.Printf("run %q cancelled by %q while executing on worker %q", entry.Name, cancellation.RequestedBy, entry.Status.Executor.WorkerName)
+
+	if m.canceller != nil {
+		if err := m.canceller.SignalCancel(ctx, *entry); err != nil {
+			log.Printf("could not signal the worker executing %q to stop: %v", entry.Name, err)
+		}
+	}
+
+	return true, nil
+}
+
+// acceptsStaleReport reports whether a run whose version moved on since it was
+// claimed still takes its worker's report.
+//
+// Two ways that happens. A cancellation writes the Result after the claim, so
+// the worker it was meant for reports against a version that is now one
+// behind -- and that report is the one the cancellation is waiting on. And a
+// spooled report may find the run expired while the worker could not get
+// through, which acceptDelivery may yet undo.
+func acceptsStaleReport(entry Result, delivery *ResultDelivery) bool {
+	switch entry.Status.Status {
+	case JobCancelling:
+		return true
+	case JobExpired:
+		return delivery.spooled()
+	default:
+		return false
+	}
+}
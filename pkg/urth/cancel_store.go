@@ -0,0 +1,42 @@
+package urth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sre-norns/wyrd/pkg/manifest"
+	"gorm.io/gorm"
+)
+
+// runDispatchStore reads and retires one run's outbox entries.
+type runDispatchStore struct {
+	db *gorm.DB
+}
+
+// NewRunDispatchStore returns the outbox view a cancellation needs.
+func NewRunDispatchStore(db *gorm.DB) RunDispatchStore {
+	return &runDispatchStore{db: db}
+}
+
+func (s *runDispatchStore) UnretiredDispatches(ctx context.Context, resultUID manifest.ResourceID) ([]DispatchOutboxEntry, error) {
+	var entries []DispatchOutboxEntry
+
+	// Every version's entries, not only the current one's: a run amended
+	// after it was dispatched may have a message queued for the version it
+	// was, and that message is as unwanted as the rest.
+	err := s.db.WithContext(ctx).
+		Where("result_uid = ?", resultUID).
+		Where("retired_at IS NULL").
+		Order("id ASC").
+		Find(&entries).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dispatches of run %v: %w", resultUID, err)
+	}
+
+	return entries, nil
+}
+
+func (s *runDispatchStore) RetireDispatch(ctx context.Context, id uint, at time.Time, reason string) error {
+	return retireDispatch(ctx, s.db, id, at, reason)
+}
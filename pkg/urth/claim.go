@@ -19,7 +19,10 @@ import (
 //   - a run that no longer needs the dispatch (ClaimObsolete) must be
 //     acknowledged, so it stops being redelivered forever;
 //   - a permanent policy refusal (ClaimForbidden) must stop being redelivered to a
-//     worker that will never be allowed to run it.
+//     worker that will never be allowed to run it;
+//   - a run that may not start *yet* (ClaimThrottled) has been re-queued by the
+//     server for later, because what it is waiting on is load elsewhere
+//     draining away; the dispatch in hand is acknowledged. See deferRun.
 //
 // Collapsing these into one status -- as the prototype did, answering every claim
 // failure with 401 -- means a transient database blip deletes the only live
@@ -42,6 +45,14 @@ const (
 	// expired, its worker is paused, or its runner is disabled or mismatched -- a
 	// policy decision that redelivery to the same worker will not reverse.
 	ClaimForbidden
+
+	// ClaimThrottled: the run is claimable, but starting it now would exceed a
+	// RateLimit on what it probes. Nothing is wrong with the run, the worker or
+	// the server, so the run waits its turn -- re-queued by the server under a
+	// new version, with a longer delay than ClaimUnavailable, since the limit
+	// frees up as other runs finish, not as a blip passes. The dispatch that
+	// asked is superseded by the re-queue, and is acknowledged.
+	ClaimThrottled
 )
 
 func (d ClaimDisposition) String() string {
@@ -52,6 +63,8 @@ func (d ClaimDisposition) String() string {
 		return "obsolete"
 	case ClaimForbidden:
 		return "forbidden"
+	case ClaimThrottled:
+		return "throttled"
 	default:
 		return fmt.Sprintf("ClaimDisposition(%d)", int(d))
 	}
@@ -98,6 +111,10 @@ func claimForbidden(reason string) *ClaimError {
 	return &ClaimError{Disposition: ClaimForbidden, reason: reason}
 }
 
+func claimThrottled(reason string) *ClaimError {
+	return &ClaimError{Disposition: ClaimThrottled, reason: reason}
+}
+
 // ClaimDispositionOf reports the disposition of a claim error. Any error that is
 // not a *ClaimError -- an unexpected panic-recovery, a wrapped store error that
 // escaped classification -- is reported as ClaimUnavailable: an unclassified
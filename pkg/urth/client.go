@@ -13,6 +13,7 @@ import (
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/sre-norns/urth/pkg/prob"
 	"github.com/sre-norns/wyrd/pkg/bark"
@@ -28,6 +29,7 @@ type APIClientConfig struct {
 	HTTPClient *http.Client `kong:"-"`
 
 	Token            APIToken      `help:"API token to authenticate to the API server"`
+	Actor            string        `help:"Name the server's audit log records your changes under" env:"URTH_ACTOR"`
 	APIServerAddress string        `help:"URL of the API server" default:"http://localhost:8080/api"`
 	Timeout          time.Duration `help:"Communication timeout for API server" default:"1m"`
 }
@@ -127,6 +129,30 @@ func (c *RestAPIClient) DispatchFailures() DispatchFailuresAPI {
 	}
 }
 
+func (c *RestAPIClient) MaintenanceWindows() MaintenanceWindowsAPI {
+	return &maintenanceWindowsAPIClient{
+		RestAPIClient: *c,
+	}
+}
+
+func (c *RestAPIClient) RateLimits() RateLimitsAPI {
+	return &rateLimitsAPIClient{
+		RestAPIClient: *c,
+	}
+}
+
+func (c *RestAPIClient) WorkerBlocks() WorkerBlocksAPI {
+	return &workerBlocksAPIClient{
+		RestAPIClient: *c,
+	}
+}
+
+func (c *RestAPIClient) AuditLog() AuditLogAPI {
+	return &auditLogAPIClient{
+		RestAPIClient: *c,
+	}
+}
+
 func (c *RestAPIClient) resourceAPICall(ctx context.Context, method string, targetAPI *url.URL, data []byte) (result manifest.ResourceManifest, created bool, err error) {
 	request, err := c.requestWithAuth(ctx, method, targetAPI, "", nil, bytes.NewReader(data))
 	if err != nil {
@@ -215,6 +241,9 @@ func (c *RestAPIClient) requestWithAuth(ctx context.Context, method string, apiU
 	if token != "" {
 		request.Header.Add("Authorization", fmt.Sprintf("Bearer %v", token))
 	}
+	if c.config.Actor != "" {
+		request.Header.Add(HeaderAuditActor, c.config.Actor)
+	}
 
 	for k, values := range extraHeaders {
 		for _, v := range values {
@@ -388,7 +417,7 @@ func apiURLForResource(baseURL *url.URL, typeInfo manifest.TypeMeta, resourceNam
 		return nil, ErrUnspecifiedAPIVersion
 	}
 
-	collection := strings.ToLower(string(typeInfo.Kind)) // TODO: Ensure that type name is plural?
+	collection := resourceCollection(typeInfo.Kind) // TODO: Ensure that type name is plural?
 	if collection == "" {
 		return nil, ErrUnspecifiedAPIKind
 	}
@@ -396,6 +425,25 @@ func apiURLForResource(baseURL *url.URL, typeInfo manifest.TypeMeta, resourceNam
 	return urlForPath(baseURL, path.Join(typeInfo.APIVersion, collection, string(resourceName)), query), nil
 }
 
+// resourceCollection is the path a kind's resources are served under: the kind
+// in kebab case, so that `maintenanceWindows` is applied to the same
+// `/maintenance-windows` an operator reads them from. Single-word kinds are
+// simply lower-cased, as they always were.
+func resourceCollection(kind manifest.Kind) string {
+	var collection strings.Builder
+	for i, r := range string(kind) {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				collection.WriteByte('-')
+			}
+			r = unicode.ToLower(r)
+		}
+		collection.WriteRune(r)
+	}
+
+	return collection.String()
+}
+
 func urlForPath(baseURL *url.URL, apiPath string, query url.Values) *url.URL {
 	rawQuery := ""
 	if len(query) > 0 {
@@ -419,6 +467,12 @@ func searchToQuery(searchQuery manifest.SearchQuery) url.Values {
 	if searchQuery.Selector != nil && !searchQuery.Selector.Empty() {
 		queryParams.Set("labels", searchQuery.Selector.String())
 	}
+	if !searchQuery.FromTime.IsZero() {
+		queryParams.Set("from", searchQuery.FromTime.Format(time.RFC3339))
+	}
+	if !searchQuery.TillTime.IsZero() {
+		queryParams.Set("till", searchQuery.TillTime.Format(time.RFC3339))
+	}
 
 	return queryParams
 }
@@ -457,6 +511,10 @@ func (c *workersAPIClient) SetPaused(ctx context.Context, id manifest.ResourceNa
 	return result, err == nil, err
 }
 
+func (c *workersAPIClient) SetDraining(ctx context.Context, id manifest.ResourceName, draining bool) (result manifest.ResourceManifest, exists bool, err error) {
+	return c.setDraining(ctx, fmt.Sprintf("v1/workers/%v/drain", id), draining)
+}
+
 func (c *workersAPIClient) Delete(ctx context.Context, id manifest.VersionedResourceID) (bool, error) {
 	return c.deleteResource(ctx, fmt.Sprintf("v1/workers/%v", id.ID), id.Version)
 }
@@ -549,6 +607,23 @@ func (c *runnersAPIClient) Update(ctx context.Context, id manifest.VersionedReso
 	}
 }
 
+func (c *runnersAPIClient) SetDraining(ctx context.Context, id manifest.ResourceName, draining bool) (result manifest.ResourceManifest, exists bool, err error) {
+	return c.setDraining(ctx, fmt.Sprintf("v1/runners/%v/drain", id), draining)
+}
+
+// setDraining is the one request behind draining a worker and draining a runner,
+// which differ only in the path.
+func (c *RestAPIClient) setDraining(ctx context.Context, path string, draining bool) (result manifest.ResourceManifest, exists bool, err error) {
+	data, err := json.Marshal(SetDrainingRequest{IsDraining: draining})
+	if err != nil {
+		return
+	}
+
+	result, _, err = c.resourceAPICall(ctx, http.MethodPut, urlForPath(c.baseURL, path, nil), data)
+
+	return result, err == nil, err
+}
+
 func (c *runnersAPIClient) GetToken(ctx context.Context, runnerName manifest.ResourceName) (APIToken, bool, error) {
 	targetAPI := urlForPath(c.baseURL, fmt.Sprintf("v1/auth/runners/%v", runnerName), nil)
 	resp, err := c.getWithAuth(ctx, targetAPI, "", nil)
@@ -568,6 +643,67 @@ func (c *runnersAPIClient) GetToken(ctx context.Context, runnerName manifest.Res
 	}
 }
 
+// Tokens lists the enrolment tokens issued for a runner.
+func (c *runnersAPIClient) Tokens(ctx context.Context, runnerName manifest.ResourceName) ([]EnrolmentToken, bool, error) {
+	targetAPI := urlForPath(c.baseURL, fmt.Sprintf("v1/runners/%v/tokens", runnerName), nil)
+	resp, err := c.get(ctx, targetAPI)
+	if err != nil {
+		return nil, false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, false, nil
+	default:
+		return nil, false, readAPIError(resp)
+	}
+
+	resources, _, err := readPaginatedResource[manifest.ResourceManifest](resp.Body)
+	if err != nil {
+		return nil, true, err
+	}
+
+	tokens := make([]EnrolmentToken, 0, len(resources))
+	for _, resource := range resources {
+		token, err := NewEnrolmentToken(resource)
+		if err != nil {
+			return nil, true, err
+		}
+
+		tokens = append(tokens, token)
+	}
+
+	return tokens, true, nil
+}
+
+// RevokeToken takes back one of a runner's enrolment tokens.
+func (c *runnersAPIClient) RevokeToken(ctx context.Context, runnerName manifest.ResourceName, tokenID manifest.ResourceName, request RevokeTokenRequest) (result EnrolmentToken, err error) {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return result, err
+	}
+
+	targetAPI := urlForPath(c.baseURL, fmt.Sprintf("v1/runners/%v/tokens/%v/revoke", runnerName, tokenID), nil)
+	resp, err := c.postWithAuth(ctx, targetAPI, "", nil, bytes.NewReader(data))
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusAccepted, http.StatusCreated:
+		var resource manifest.ResourceManifest
+		if err := json.NewDecoder(resp.Body).Decode(&resource); err != nil {
+			return result, fmt.Errorf("RestApiClient response decoding error: %w", err)
+		}
+		return NewEnrolmentToken(resource)
+	default:
+		return result, readAPIError(resp)
+	}
+}
+
 func (c *runnersAPIClient) Auth(ctx context.Context, token APIToken, newEntry manifest.ResourceManifest) (result manifest.ResourceManifest, err error) {
 	data, err := json.Marshal(newEntry)
 	if err != nil {
@@ -719,6 +855,32 @@ func (c *resultsAPIRestClient) ClaimRun(ctx context.Context, resultUID manifest.
 	}
 }
 
+// Cancel asks the server to stop a run.
+func (c *resultsAPIRestClient) Cancel(ctx context.Context, runID manifest.ResourceName, request CancelRunRequest) (result Result, err error) {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return result, err
+	}
+
+	targetAPI := urlForPath(c.baseURL, fmt.Sprintf("v1/scenarios/%v/results/%v/cancel", c.ScenarioID, runID), nil)
+	resp, err := c.postWithAuth(ctx, targetAPI, "", nil, bytes.NewReader(data))
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusAccepted:
+		var resource manifest.ResourceManifest
+		if err := json.NewDecoder(resp.Body).Decode(&resource); err != nil {
+			return result, fmt.Errorf("RestApiClient response decoding error: %w", err)
+		}
+		return NewResult(resource)
+	default:
+		return result, readAPIError(resp)
+	}
+}
+
 func (c *resultsAPIRestClient) UpdateStatus(ctx context.Context, id manifest.VersionedResourceID, token APIToken, runResults ResultStatus) (result bark.CreatedResponse, err error) {
 	data, err := json.Marshal(runResults)
 	if err != nil {
@@ -751,6 +913,86 @@ func (c *resultsAPIRestClient) UpdateStatus(ctx context.Context, id manifest.Ver
 	}
 }
 
+// Stats implements ScenarioAPI.
+func (c *scenariosAPIClient) Stats(ctx context.Context, id manifest.ResourceName, query ResultStatsQuery) (result ResultStats, exists bool, err error) {
+	targetAPI := urlForPath(c.baseURL, fmt.Sprintf("v1/scenarios/%v/stats", id), statsToQuery(query))
+
+	resp, err := c.get(ctx, targetAPI)
+	if err != nil {
+		return result, false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return result, true, json.NewDecoder(resp.Body).Decode(&result)
+	case http.StatusNotFound:
+		return result, false, nil
+	default:
+		return result, false, readAPIError(resp)
+	}
+}
+
+// Availability implements ScenarioAPI.
+func (c *scenariosAPIClient) Availability(ctx context.Context, id manifest.ResourceName, query AvailabilityQuery) (result AvailabilityReport, exists bool, err error) {
+	targetAPI := urlForPath(c.baseURL, fmt.Sprintf("v1/scenarios/%v/availability", id), statsToQuery(ResultStatsQuery{
+		From:    query.From,
+		Till:    query.Till,
+		GroupBy: query.GroupBy,
+	}))
+
+	resp, err := c.get(ctx, targetAPI)
+	if err != nil {
+		return result, false, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return result, true, json.NewDecoder(resp.Body).Decode(&result)
+	case http.StatusNotFound:
+		return result, false, nil
+	default:
+		return result, false, readAPIError(resp)
+	}
+}
+
+// Dependencies implements ScenarioAPI.
+func (c *scenariosAPIClient) Dependencies(ctx context.Context) (result DependencyGraph, err error) {
+	targetAPI := urlForPath(c.baseURL, "v1/scenario-dependencies", nil)
+
+	resp, err := c.get(ctx, targetAPI)
+	if err != nil {
+		return result, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return result, json.NewDecoder(resp.Body).Decode(&result)
+	default:
+		return result, readAPIError(resp)
+	}
+}
+
+func statsToQuery(query ResultStatsQuery) url.Values {
+	queryParams := url.Values{}
+	if !query.From.IsZero() {
+		queryParams.Set("from", query.From.Format(time.RFC3339))
+	}
+	if !query.Till.IsZero() {
+		queryParams.Set("till", query.Till.Format(time.RFC3339))
+	}
+	if query.Granularity != "" {
+		queryParams.Set("granularity", string(query.Granularity))
+	}
+	if query.GroupBy != "" {
+		queryParams.Set("groupBy", query.GroupBy)
+	}
+
+	return queryParams
+}
+
 // --------
 // Labels API
 // --------
@@ -1101,3 +1343,196 @@ func (c *dispatchFailuresAPIClient) Resolve(ctx context.Context, id manifest.Res
 		return result, readAPIError(resp)
 	}
 }
+
+// --------
+// Maintenance windows API
+// --------
+
+type maintenanceWindowsAPIClient struct {
+	RestAPIClient
+}
+
+func (c *maintenanceWindowsAPIClient) List(ctx context.Context, searchQuery manifest.SearchQuery) ([]manifest.ResourceManifest, int64, error) {
+	targetAPI := urlForPath(c.baseURL, "v1/maintenance-windows", searchToQuery(searchQuery))
+
+	return c.listResources(ctx, targetAPI)
+}
+
+func (c *maintenanceWindowsAPIClient) Get(ctx context.Context, id manifest.ResourceName) (result manifest.ResourceManifest, exists bool, err error) {
+	exists, err = c.getResource(ctx, fmt.Sprintf("v1/maintenance-windows/%v", id), &result)
+	return
+}
+
+func (c *maintenanceWindowsAPIClient) CreateOrUpdate(ctx context.Context, newEntry manifest.ResourceManifest) (manifest.ResourceManifest, bool, error) {
+	return c.ApplyObjectDefinition(ctx, newEntry)
+}
+
+func (c *maintenanceWindowsAPIClient) Create(ctx context.Context, newEntry manifest.ResourceManifest) (manifest.ResourceManifest, error) {
+	return c.createResource(ctx, "v1/maintenance-windows", "", &newEntry)
+}
+
+func (c *maintenanceWindowsAPIClient) Delete(ctx context.Context, id manifest.VersionedResourceID) (bool, error) {
+	return c.deleteResource(ctx, fmt.Sprintf("v1/maintenance-windows/%v", id.ID), id.Version)
+}
+
+// Update replaces a window. The server answers PUT by name as create-or-update,
+// so this is CreateOrUpdate with the identity taken from the manifest.
+func (c *maintenanceWindowsAPIClient) Update(ctx context.Context, id manifest.VersionedResourceID, entry manifest.ResourceManifest) (manifest.ResourceManifest, error) {
+	result, _, err := c.ApplyObjectDefinition(ctx, entry)
+	return result, err
+}
+
+// --------
+// Rate limits API
+// --------
+
+type rateLimitsAPIClient struct {
+	RestAPIClient
+}
+
+func (c *rateLimitsAPIClient) List(ctx context.Context, searchQuery manifest.SearchQuery) ([]manifest.ResourceManifest, int64, error) {
+	targetAPI := urlForPath(c.baseURL, "v1/rate-limits", searchToQuery(searchQuery))
+
+	return c.listResources(ctx, targetAPI)
+}
+
+func (c *rateLimitsAPIClient) Get(ctx context.Context, id manifest.ResourceName) (result manifest.ResourceManifest, exists bool, err error) {
+	exists, err = c.getResource(ctx, fmt.Sprintf("v1/rate-limits/%v", id), &result)
+	return
+}
+
+func (c *rateLimitsAPIClient) CreateOrUpdate(ctx context.Context, newEntry manifest.ResourceManifest) (manifest.ResourceManifest, bool, error) {
+	return c.ApplyObjectDefinition(ctx, newEntry)
+}
+
+func (c *rateLimitsAPIClient) Create(ctx context.Context, newEntry manifest.ResourceManifest) (manifest.ResourceManifest, error) {
+	return c.createResource(ctx, "v1/rate-limits", "", &newEntry)
+}
+
+func (c *rateLimitsAPIClient) Delete(ctx context.Context, id manifest.VersionedResourceID) (bool, error) {
+	return c.deleteResource(ctx, fmt.Sprintf("v1/rate-limits/%v", id.ID), id.Version)
+}
+
+// Update replaces a limit, as maintenanceWindowsAPIClient.Update does.
+func (c *rateLimitsAPIClient) Update(ctx context.Context, id manifest.VersionedResourceID, entry manifest.ResourceManifest) (manifest.ResourceManifest, error) {
+	result, _, err := c.ApplyObjectDefinition(ctx, entry)
+	return result, err
+}
+
+// --------
+// Worker blocks API
+// --------
+
+type workerBlocksAPIClient struct {
+	RestAPIClient
+}
+
+func (c *workerBlocksAPIClient) List(ctx context.Context, searchQuery manifest.SearchQuery) ([]manifest.ResourceManifest, int64, error) {
+	targetAPI := urlForPath(c.baseURL, "v1/worker-blocks", searchToQuery(searchQuery))
+
+	return c.listResources(ctx, targetAPI)
+}
+
+func (c *workerBlocksAPIClient) Get(ctx context.Context, id manifest.ResourceName) (result manifest.ResourceManifest, exists bool, err error) {
+	exists, err = c.getResource(ctx, fmt.Sprintf("v1/worker-blocks/%v", id), &result)
+	return
+}
+
+func (c *workerBlocksAPIClient) CreateOrUpdate(ctx context.Context, newEntry manifest.ResourceManifest) (manifest.ResourceManifest, bool, error) {
+	return c.ApplyObjectDefinition(ctx, newEntry)
+}
+
+func (c *workerBlocksAPIClient) Create(ctx context.Context, newEntry manifest.ResourceManifest) (manifest.ResourceManifest, error) {
+	return c.createResource(ctx, "v1/worker-blocks", "", &newEntry)
+}
+
+func (c *workerBlocksAPIClient) Delete(ctx context.Context, id manifest.VersionedResourceID) (bool, error) {
+	return c.deleteResource(ctx, fmt.Sprintf("v1/worker-blocks/%v", id.ID), id.Version)
+}
+
+// Update replaces a block, as maintenanceWindowsAPIClient.Update does.
+func (c *workerBlocksAPIClient) Update(ctx context.Context, id manifest.VersionedResourceID, entry manifest.ResourceManifest) (manifest.ResourceManifest, error) {
+	result, _, err := c.ApplyObjectDefinition(ctx, entry)
+	return result, err
+}
+
+// --------
+// Audit log API
+// --------
+
+type auditLogAPIClient struct {
+	RestAPIClient
+}
+
+// List audit events matching the query, newest first. Converted from manifests
+// for the reason dispatchFailuresAPIClient.List gives.
+func (c *auditLogAPIClient) List(ctx context.Context, searchQuery manifest.SearchQuery) ([]AuditEvent, int64, error) {
+	targetAPI := urlForPath(c.baseURL, "v1/audit", searchToQuery(searchQuery))
+
+	resources, total, err := c.listResources(ctx, targetAPI)
+	if err != nil {
+		return nil, total, err
+	}
+
+	events := make([]AuditEvent, 0, len(resources))
+	for _, resource := range resources {
+		event, err := NewAuditEvent(resource)
+		if err != nil {
+			return nil, total, err
+		}
+
+		events = append(events, event)
+	}
+
+	return events, total, nil
+}
+
+func (c *auditLogAPIClient) Get(ctx context.Context, id manifest.ResourceName) (result AuditEvent, exists bool, err error) {
+	var resource manifest.ResourceManifest
+	exists, err = c.getResource(ctx, fmt.Sprintf("v1/audit/%v", id), &resource)
+	if !exists || err != nil {
+		return
+	}
+
+	result, err = NewAuditEvent(resource)
+
+	return
+}
+
+// Export reads the server's JSON-lines export, visiting each event as it
+// arrives rather than holding the whole log in memory.
+func (c *auditLogAPIClient) Export(ctx context.Context, searchQuery manifest.SearchQuery, visit func(AuditEvent) error) error {
+	targetAPI := urlForPath(c.baseURL, "v1/audit/export", searchToQuery(searchQuery))
+	resp, err := c.get(ctx, targetAPI)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return readAPIError(resp)
+	}
+
+	decoder := json.NewDecoder(resp.Body)
+	for {
+		var resource manifest.ResourceManifest
+		if err := decoder.Decode(&resource); err == io.EOF {
+			// Trailers are read once the body is.
+			if reason := resp.Trailer.Get(HeaderExportError); reason != "" {
+				return fmt.Errorf("audit export ended early: %s", reason)
+			}
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("RestApiClient response decoding error: %w", err)
+		}
+
+		event, err := NewAuditEvent(resource)
+		if err != nil {
+			return err
+		}
+
+		if err := visit(event); err != nil {
+			return err
+		}
+	}
+}
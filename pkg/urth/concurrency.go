@@ -0,0 +1,140 @@
+package urth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// A scenario whose runs take longer than its schedule piles them up: a browser
+// probe of ninety seconds on a one-minute schedule has a new run pending before
+// the last one has finished, and the queue only grows. A concurrency policy says
+// what a trigger does about the runs of the same scenario that are still in
+// flight -- pending, running or cancelling -- the way a Kubernetes CronJob's does.
+//
+// It is applied where every run begins, resultsAPIImpl.Create, so a scheduled
+// trigger and an operator's manual one are held to the same rule. A trigger the
+// policy skips is not refused: a scheduled trigger has no caller to hand an error
+// to, so the skip is recorded as a run of its own, finished on the spot and
+// labelled with why. "Where is the 14:02 run" is then answered by the run
+// history rather than by a gap in it.
+//
+// The check and the create are not one transaction. Two triggers landing in the
+// same instant under Forbid can both find nothing in flight and both run. That
+// is the same race CronJob accepts, and closing it would take a lock on the
+// scenario for every run created -- a price every scenario would pay for the
+// benefit of one whose triggers collide to the millisecond.
+
+// ConcurrencyPolicy says what a new run of a scenario does about its runs still
+// in flight.
+type ConcurrencyPolicy string
+
+const (
+	// ConcurrencyAllow starts the new run regardless. It is the default, and
+	// what every scenario did before there was a policy.
+	ConcurrencyAllow ConcurrencyPolicy = "Allow"
+
+	// ConcurrencyForbid skips the new run while an earlier one is in flight.
+	// The skipped run is recorded, finished, with ReasonConcurrencyForbidden.
+	ConcurrencyForbid ConcurrencyPolicy = "Forbid"
+
+	// ConcurrencyReplace starts the new run and cancels the ones in flight,
+	// exactly as an operator's cancellation would, recording the new run as the
+	// reason.
+	ConcurrencyReplace ConcurrencyPolicy = "Replace"
+)
+
+// ConcurrencyRequester is who a cancellation made by the Replace policy says
+// asked for it. See RunCancellation.
+const ConcurrencyRequester = "concurrency-policy"
+
+// Validate refuses a policy this server does not know.
+//
+// Refused rather than read as Allow: a scenario written with "forbid" in the
+// wrong case would otherwise run concurrently while its manifest says it does
+// not, which is the one outcome the field exists to prevent.
+func (p ConcurrencyPolicy) Validate() error {
+	switch p {
+	case "", ConcurrencyAllow, ConcurrencyForbid, ConcurrencyReplace:
+		return nil
+	default:
+		return fmt.Errorf("unknown concurrency policy %q: expected %s, %s or %s",
+			p, ConcurrencyAllow, ConcurrencyForbid, ConcurrencyReplace)
+	}
+}
+
+// inFlightJobStates lists the states of a run a concurrency policy waits on.
+//
+// Cancelling is one of them: the probe is still executing until its worker
+// reports, and a Forbid scenario whose old run is being cancelled still has that
+// run hitting the target.
+func inFlightJobStates() []string {
+	return []string{string(JobPending), string(JobRunning), string(JobCancelling)}
+}
+
+// runsInFlight lists the scenario's runs that have not finished.
+//
+// Through the job-state label, which every transition keeps in step with the
+// status, so the question is one indexed query over this scenario's runs rather
+// than a scan of its history.
+func (m *resultsAPIImpl) runsInFlight(ctx context.Context, scenario Scenario) ([]Result, error) {
+	requirement, err := manifest.NewRequirement(LabelResultJobState, manifest.In, inFlightJobStates())
+	if err != nil {
+		return nil, fmt.Errorf("failed to build the in-flight run query: %w", err)
+	}
+
+	var results []Result
+	if _, err := m.store.FindLinked(ctx, &results, "Results", &scenario,
+		manifest.SearchQuery{Selector: manifest.NewSelector(requirement)}); err != nil {
+		return nil, fmt.Errorf("failed to list the runs of %q still in flight: %w", scenario.Name, err)
+	}
+
+	return results, nil
+}
+
+// skipRun records a run the scenario's concurrency policy did not start.
+//
+// Finished rather than failed: nothing went wrong, the trigger was declined. The
+// verdict is `canceled`, which availability counts as neither up nor down, and
+// the reason goes in LabelResultUnschedulable beside the other reasons a run was
+// refused rather than executed.
+func skipRun(result *Result, reason string, at time.Time) {
+	result.Status.Status = JobCompleted
+	result.Status.Result = prob.RunFinishedCanceled
+	result.Spec.TimeEnded = &at
+
+	result.Labels = manifest.MergeLabels(
+		result.Labels,
+		manifest.Labels{
+			LabelResultJobState:      string(result.Status.Status),
+			LabelResultStatus:        string(result.Status.Result),
+			LabelResultUnschedulable: reason,
+		},
+	)
+}
+
+// replaceRuns cancels the runs a Replace trigger supersedes.
+//
+// After the new run is committed, never before: cancelling first and then
+// failing to create would leave the scenario with nothing running at all, which
+// is worse than briefly running two. Failures are logged and left. The new run
+// is the one the trigger asked for, and it exists; an old run that could not be
+// cancelled finishes or expires as it would have without the policy.
+func (m *resultsAPIImpl) replaceRuns(ctx context.Context, superseded []Result, replacement Result) {
+	for _, run := range superseded {
+		_, err := m.Cancel(ctx, run.Name, CancelRunRequest{
+			RequestedBy: ConcurrencyRequester,
+			Reason:      fmt.Sprintf("replaced by run %q", replacement.Name),
+		})
+		if err != nil {
+			log.Printf("run %q could not be cancelled in favour of %q: %v", run.Name, replacement.Name, err)
+			continue
+		}
+
+		log.Printf("run %q replaced by %q", run.Name, replacement.Name)
+	}
+}
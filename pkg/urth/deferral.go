@@ -0,0 +1,111 @@
+package urth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/sre-norns/wyrd/pkg/dbstore"
+)
+
+// Deferral: a run that may not start yet, re-queued by the server rather than
+// handed back to the broker.
+//
+// Handing it back was the first design, and it does not survive a busy target.
+// JetStream counts every delivery of a message, whether it ends in a nak or in
+// AckWait running out, and the consumer files a dead letter at MaxDeliver. A
+// run throttled by a rate limit that stays saturated for a minute or two -- the
+// very situation a limit exists for -- used up its five deliveries inside the
+// backoff and was dead-lettered, though nothing about it was wrong and it would
+// have run a moment later.
+//
+// So a deferred claim moves the run on instead. In one transaction the Result
+// is written again -- which bumps its version -- and a fresh outbox entry is
+// queued for the new version, held back by deferralDelay. The worker is told
+// the claim is throttled and acknowledges the message it holds: the dispatch it
+// describes is now superseded, and the one that replaces it starts with a full
+// set of deliveries. A run deferred for longer than its scenario cares to wait
+// is settled the way any unstarted run is: by its claim deadline, or by the
+// reconciler's pending timeout.
+//
+// The same holds for a worker whose self-test fails for the run's kind: it
+// claims anyway, naming the kinds it cannot run (ClaimJobRequest.FailingKinds),
+// and the run is deferred for a worker that can rather than bounced off this
+// one until MaxDeliver. And for a worker whose slots for the kind are all taken
+// (ClaimJobRequest.BusyKinds): a burst of slow runs of one kind outlasts five
+// deliveries easily on a worker allowed one of them at a time.
+
+// Deferral delays. The first re-queue comes back quickly, for a limit that was
+// only momentarily full; each one after waits twice as long, up to a ceiling,
+// so a target that stays busy is asked about less often the longer it does.
+const (
+	deferralDelay    = 5 * time.Second
+	deferralMaxDelay = time.Minute
+)
+
+// deferralBackoff is how long the re-queue after `deferrals` earlier ones
+// waits.
+func deferralBackoff(deferrals int) time.Duration {
+	delay := deferralDelay
+	for range max(deferrals, 0) {
+		delay *= 2
+		if delay >= deferralMaxDelay {
+			return deferralMaxDelay
+		}
+	}
+
+	return delay
+}
+
+// deferRun re-queues a pending run whose claim was turned away for now, and
+// returns the ClaimThrottled the worker is answered with.
+//
+// The write is version-guarded like the claim it replaces. A claim racing
+// this one -- another worker, another dispatch of the same run -- has already
+// moved the Result on, and for this worker the dispatch is then obsolete.
+func (m *resultsAPIImpl) deferRun(ctx context.Context, entry Result, reason string) error {
+	now := time.Now()
+	delay := deferralBackoff(entry.Status.Deferrals)
+
+	deferred := entry
+	deferred.Status.Deferrals++
+
+	tx, err := m.store.Begin(ctx)
+	if err != nil {
+		return claimUnavailable("open transaction to defer run", err)
+	}
+	defer tx.Rollback()
+
+	if ok, err := tx.Update(&deferred, deferred.UID, dbstore.WithVersion(entry.Version)); err != nil {
+		return claimUnavailable("defer run", err)
+	} else if !ok {
+		return claimObsolete("result changed while being deferred")
+	}
+
+	// Read back for the version the update assigned: the outbox entry is keyed
+	// on it, and an entry for the version just superseded would be refused by
+	// the very claim it exists to invite.
+	if ok, err := tx.GetByUID(&deferred, deferred.UID); err != nil {
+		return claimUnavailable("reload deferred run", err)
+	} else if !ok {
+		return claimObsolete("result deleted while being deferred")
+	}
+
+	// A service with no scheduler dispatches nothing; see shouldDispatch.
+	if m.scheduler != nil {
+		outboxEntry := NewDispatchOutboxEntry(deferred, now)
+		outboxEntry.NotBefore = now.Add(delay)
+		if err := tx.Create(&outboxEntry); err != nil {
+			return claimUnavailable("re-queue deferred run", fmt.Errorf("failed to enqueue dispatch for %q: %w", entry.Name, err))
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return claimUnavailable("commit deferral", err)
+	}
+
+	log.Printf("claim for %q deferred (%s); re-queued in %v", entry.Name, reason, delay)
+
+	return claimThrottled(reason)
+}
@@ -0,0 +1,91 @@
+package urth
+
+import (
+	"time"
+
+	"github.com/sre-norns/wyrd/pkg/bark"
+
+	"github.com/sre-norns/urth/pkg/prob"
+)
+
+// Late delivery: a worker that could not reach the API server when a run
+// finished keeps the report on disk and delivers it later (see pkg/worker's
+// spool). The server takes such a report for a while past the run's deadline,
+// because the alternative is keeping `expired` -- which only ever meant that
+// nobody said -- over an account of what the run actually found.
+
+// DefaultLateResultGrace is how long past its upload grace a run accepts a
+// spooled report. An hour covers an API server restart or a short network
+// partition; a run reported later than that is history nobody is still
+// waiting on.
+const DefaultLateResultGrace = 1 * time.Hour
+
+// DeliveryLate is the LabelResultDelivery value of a run reported late.
+const DeliveryLate = "late"
+
+// acceptDelivery decides whether a status report arrived in time to be taken,
+// and whether it is late.
+//
+// A report is on time until the run's upload grace is over. After that only a
+// spooled outcome is taken, and only for the late grace counted from the run's
+// recorded deadline: the worker kept an observation through an outage, and it
+// is still the only account of what the run found. A run the reconciler expired
+// in the meantime is corrected rather than kept, for the same reason --
+// `expired` meant "nobody told us", and now somebody has.
+func (m *resultsAPIImpl) acceptDelivery(entry Result, report ResultStatus, now time.Time) (late bool, err error) {
+	if entry.Status.Deadline.IsZero() {
+		return false, nil
+	}
+
+	cutoff := entry.Status.Deadline.Add(artifactUploadGrace)
+	if !now.After(cutoff) && entry.Status.Status != JobExpired {
+		return false, nil
+	}
+
+	if !lateDeliverable(report) || now.After(cutoff.Add(m.lateResultGrace)) {
+		return false, bark.ErrResourceUnauthorized
+	}
+
+	return true, nil
+}
+
+// deliveryLeeway is how long past its expiry a run capability is taken for a
+// report. The capability itself ends with the upload grace, so that nothing
+// else a worker does with it -- an artifact upload, above all -- outlives the
+// run by an hour; only a report that may be taken late gets the late grace, and
+// acceptDelivery then holds it to the run's recorded deadline.
+func (m *resultsAPIImpl) deliveryLeeway(report ResultStatus) time.Duration {
+	if !lateDeliverable(report) {
+		return 0
+	}
+
+	return m.lateResultGrace
+}
+
+// lateDeliverable reports whether a report is one the late grace is for: an
+// outcome, held on a worker's disk.
+func lateDeliverable(report ResultStatus) bool {
+	return report.Delivery.spooled() && report.Result != prob.RunNotFinished
+}
+
+// spooled reports whether a report was held on a worker's disk.
+func (d *ResultDelivery) spooled() bool {
+	return d != nil && d.Spooled
+}
+
+// deliveredAt is when a reported run ended: when the worker says it saw it
+// finish, for a spooled report that says, and otherwise now.
+//
+// The worker's clock is taken only within what the server already knows about
+// the run -- after it started, and not after now -- so a skewed clock cannot
+// give a run a negative duration or an end in the future.
+func deliveredAt(entry Result, delivery *ResultDelivery, now time.Time) time.Time {
+	if !delivery.spooled() || delivery.FinishedAt.IsZero() || delivery.FinishedAt.After(now) {
+		return now
+	}
+	if started := entry.Spec.TimeStarted; started != nil && delivery.FinishedAt.Before(*started) {
+		return now
+	}
+
+	return delivery.FinishedAt
+}
@@ -0,0 +1,100 @@
+package urth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sre-norns/wyrd/pkg/bark"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sre-norns/urth/pkg/prob"
+)
+
+func TestAcceptDeliveryTakesOnlySpooledReportsLate(t *testing.T) {
+	deadline := time.Date(2024, time.March, 4, 12, 0, 0, 0, time.UTC)
+	m := &resultsAPIImpl{lateResultGrace: time.Hour}
+
+	running := Result{Status: ResultStatus{Status: JobRunning, Deadline: deadline}}
+	expired := Result{Status: ResultStatus{Status: JobExpired, Deadline: deadline}}
+	reported := ResultStatus{Result: prob.RunFinishedSuccess}
+	spooled := ResultStatus{Result: prob.RunFinishedSuccess, Delivery: &ResultDelivery{Spooled: true}}
+	spooledNoOutcome := ResultStatus{Delivery: &ResultDelivery{Spooled: true}}
+
+	onTime := deadline.Add(artifactUploadGrace - time.Second)
+	late := deadline.Add(artifactUploadGrace + time.Minute)
+	tooLate := deadline.Add(artifactUploadGrace + time.Hour + time.Second)
+
+	cases := []struct {
+		name   string
+		entry  Result
+		report ResultStatus
+		at     time.Time
+		late   bool
+		err    error
+	}{
+		{name: "on time", entry: running, report: reported, at: onTime},
+		{name: "spooled, on time", entry: running, report: spooled, at: onTime},
+		{name: "late, not spooled", entry: running, report: reported, at: late, err: bark.ErrResourceUnauthorized},
+		{name: "late, spooled", entry: running, report: spooled, at: late, late: true},
+		{name: "late, spooled without an outcome", entry: running, report: spooledNoOutcome, at: late, err: bark.ErrResourceUnauthorized},
+		{name: "expired, spooled", entry: expired, report: spooled, at: onTime, late: true},
+		{name: "past the late grace", entry: expired, report: spooled, at: tooLate, err: bark.ErrResourceUnauthorized},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			late, err := m.acceptDelivery(tc.entry, tc.report, tc.at)
+			require.ErrorIs(t, err, tc.err)
+			require.Equal(t, tc.late, late)
+		})
+	}
+}
+
+// The capability ends with the upload grace. The late grace stretches it for a
+// spooled outcome and nothing else, so an expired capability cannot be used to
+// upload an artifact, or to report anything but how the run ended.
+func TestRunCapabilityOutlivesItsDeadlineOnlyForASpooledOutcome(t *testing.T) {
+	m := &resultsAPIImpl{keys: testKeys(t), lateResultGrace: time.Hour}
+	entry := Result{
+		ObjectMeta: manifest.ObjectMeta{UID: "result-uid"},
+		Spec:       ResultSpec{Execution: ExecutionSnapshot{ScenarioName: "scenario", Prob: prob.Manifest{Kind: "http"}}},
+	}
+
+	// A run whose deadline and upload grace have both passed.
+	deadline := time.Now().Add(-artifactUploadGrace - time.Minute)
+	response, err := m.authorizeRun(context.Background(), entry, Runner{}, deadline)
+	require.NoError(t, err)
+
+	_, err = jwt.Parse(string(response.Token), tokenKeyfunc(m.keys, KeyTierRun))
+	require.ErrorIs(t, err, jwt.ErrTokenExpired, "the capability itself lasts the upload grace")
+
+	spooled := ResultStatus{Result: prob.RunFinishedFailed, Delivery: &ResultDelivery{Spooled: true}}
+	require.NoError(t, m.validateUpdateRequest(context.Background(), entry, response.Token, m.deliveryLeeway(spooled)))
+
+	for name, report := range map[string]ResultStatus{
+		"not spooled":        {Result: prob.RunFinishedFailed},
+		"without an outcome": {Delivery: &ResultDelivery{Spooled: true}},
+	} {
+		require.ErrorIs(t, m.validateUpdateRequest(context.Background(), entry, response.Token, m.deliveryLeeway(report)),
+			bark.ErrResourceUnauthorized, name)
+	}
+}
+
+func TestDeliveredAtTrustsTheWorkerOnlyWithinTheRun(t *testing.T) {
+	started := time.Date(2024, time.March, 4, 12, 0, 0, 0, time.UTC)
+	now := started.Add(time.Hour)
+	entry := Result{Spec: ResultSpec{TimeStarted: &started}}
+
+	finished := started.Add(10 * time.Second)
+	require.Equal(t, finished, deliveredAt(entry, &ResultDelivery{Spooled: true, FinishedAt: finished}, now))
+
+	// Not spooled: the report is taken to be immediate.
+	require.Equal(t, now, deliveredAt(entry, &ResultDelivery{FinishedAt: finished}, now))
+
+	// A clock that disagrees with the server's is not believed.
+	require.Equal(t, now, deliveredAt(entry, &ResultDelivery{Spooled: true, FinishedAt: started.Add(-time.Second)}, now))
+	require.Equal(t, now, deliveredAt(entry, &ResultDelivery{Spooled: true, FinishedAt: now.Add(time.Second)}, now))
+}
@@ -0,0 +1,384 @@
+package urth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/wyrd/pkg/dbstore"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// Scenario dependencies: when the VPN gateway is down, the fifty HTTP checks
+// behind it are down too, and fifty-one pages say one thing.
+//
+// A scenario names what it depends on in ScenarioSpec.DependsOn, by name or by
+// label selector. When a run of it fails while the latest verdict of one of its
+// dependencies is failing too, the run is labelled with that dependency in
+// LabelResultUpstreamFailed: its failure is a consequence, and the dependency's
+// is the root cause. The run still counts as bad -- the target was unreachable,
+// whatever the reason -- but an availability report marks the incidents made of
+// such runs as consequential, and /metrics/probes and remote write carry a
+// probe_upstream_failed series for an alert rule to leave them out by.
+//
+// Judged when the outcome is reported, from the dependency's latest verdict at
+// that moment, and never revisited, for the reason a maintenance window is not:
+// the label is the record of what was known when the run failed. Only direct
+// dependencies are consulted. A check two hops from the broken gateway fails
+// behind an intermediate check that failed too, and names that one; the graph
+// leads from there to the root.
+//
+// A cycle makes "which failure came first" unanswerable, so one is refused
+// when the scenario that would close it is applied. Selectors are resolved
+// then too, against the scenarios that exist; a dependency named before the
+// scenario it names is applied is allowed, and simply never fails until it does.
+
+// ErrDependencyCycle is returned for a scenario whose dependencies lead back to
+// itself.
+var ErrDependencyCycle = errors.New("scenario dependencies form a cycle")
+
+// ScenarioDependency is one entry of ScenarioSpec.DependsOn: a scenario by name,
+// or every scenario a selector matches. Exactly one of the two is set.
+type ScenarioDependency struct {
+	Scenario manifest.ResourceName  `form:"scenario,omitempty" json:"scenario,omitempty" yaml:"scenario,omitempty" xml:"scenario,omitempty"`
+	Selector manifest.LabelSelector `form:"selector,omitempty" json:"selector,omitempty" yaml:"selector,omitempty" xml:"selector,omitempty"`
+}
+
+// hasSelector reports whether the dependency is given by selector.
+func (d ScenarioDependency) hasSelector() bool {
+	return len(d.Selector.MatchLabels) > 0 || len(d.Selector.MatchSelector) > 0
+}
+
+// Validate refuses a dependency that names nothing, or two things at once.
+func (d ScenarioDependency) Validate() error {
+	switch {
+	case d.Scenario == "" && !d.hasSelector():
+		return fmt.Errorf("a dependency must name a scenario or give a selector")
+	case d.Scenario != "" && d.hasSelector():
+		return fmt.Errorf("dependency on %q must name a scenario or give a selector, not both", d.Scenario)
+	}
+
+	if d.hasSelector() {
+		if _, err := manifest.ParseSelector(d.Selector.AsLabels()); err != nil {
+			return fmt.Errorf("dependency selector is invalid: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// validateDependencies refuses a scenario's dependencies that cannot be
+// resolved. Cycles need the other scenarios too; see checkDependencyCycle.
+func validateDependencies(dependencies []ScenarioDependency) error {
+	for _, dependency := range dependencies {
+		if err := dependency.Validate(); err != nil {
+			return fmt.Errorf("scenario's dependencies are invalid: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// resolveDependencies lists the scenarios `scenario` depends on, by name and in
+// name order, and the names it gives that are not among `all`.
+//
+// A selector never matches the scenario that declares it: "everything labelled
+// tier=network" written on a network check means the others, and reading it as
+// a dependency on itself would refuse the manifest as a cycle of one.
+func resolveDependencies(scenario Scenario, all []Scenario) (upstream, missing []manifest.ResourceName) {
+	for _, dependency := range scenario.Spec.DependsOn {
+		if !dependency.hasSelector() {
+			if slices.ContainsFunc(all, func(s Scenario) bool { return s.Name == dependency.Scenario }) {
+				upstream = append(upstream, dependency.Scenario)
+			} else {
+				missing = append(missing, dependency.Scenario)
+			}
+			continue
+		}
+
+		selector, err := manifest.ParseSelector(dependency.Selector.AsLabels())
+		if err != nil || selector.Empty() {
+			continue
+		}
+		for _, candidate := range all {
+			if candidate.Name != scenario.Name && selector.Matches(candidate.Labels) {
+				upstream = append(upstream, candidate.Name)
+			}
+		}
+	}
+
+	slices.Sort(upstream)
+	slices.Sort(missing)
+
+	return slices.Compact(upstream), slices.Compact(missing)
+}
+
+// ScenarioDependencies is one scenario's place in the dependency graph.
+type ScenarioDependencies struct {
+	Scenario manifest.ResourceName `form:"scenario" json:"scenario" yaml:"scenario" xml:"scenario"`
+
+	// DependsOn lists the scenarios this one's failures may be put down to,
+	// with its selectors resolved.
+	DependsOn []manifest.ResourceName `form:"dependsOn,omitempty" json:"dependsOn,omitempty" yaml:"dependsOn,omitempty" xml:"dependsOn,omitempty"`
+
+	// Dependents lists the scenarios that depend on this one.
+	Dependents []manifest.ResourceName `form:"dependents,omitempty" json:"dependents,omitempty" yaml:"dependents,omitempty" xml:"dependents,omitempty"`
+
+	// Missing lists the names this scenario depends on that no scenario has.
+	Missing []manifest.ResourceName `form:"missing,omitempty" json:"missing,omitempty" yaml:"missing,omitempty" xml:"missing,omitempty"`
+}
+
+// DependencyGraph is every scenario's dependencies, resolved, in name order.
+type DependencyGraph struct {
+	Scenarios []ScenarioDependencies `form:"scenarios" json:"scenarios" yaml:"scenarios" xml:"scenarios"`
+}
+
+// newDependencyGraph resolves the dependencies of every scenario in `all`.
+func newDependencyGraph(all []Scenario) DependencyGraph {
+	byName := make(map[manifest.ResourceName]*ScenarioDependencies, len(all))
+	graph := DependencyGraph{Scenarios: make([]ScenarioDependencies, len(all))}
+
+	for i, scenario := range all {
+		upstream, missing := resolveDependencies(scenario, all)
+		graph.Scenarios[i] = ScenarioDependencies{
+			Scenario:  scenario.Name,
+			DependsOn: upstream,
+			Missing:   missing,
+		}
+	}
+
+	slices.SortFunc(graph.Scenarios, func(x, y ScenarioDependencies) int {
+		return strings.Compare(string(x.Scenario), string(y.Scenario))
+	})
+	for i := range graph.Scenarios {
+		byName[graph.Scenarios[i].Scenario] = &graph.Scenarios[i]
+	}
+	for _, node := range graph.Scenarios {
+		for _, name := range node.DependsOn {
+			if upstream, ok := byName[name]; ok {
+				upstream.Dependents = append(upstream.Dependents, node.Scenario)
+			}
+		}
+	}
+
+	return graph
+}
+
+// cycleThrough returns a path from `start` back to itself, or nil if there is
+// none.
+//
+// Only cycles through `start` are looked for. It is the scenario being applied,
+// and every cycle its change could have closed runs through it; one elsewhere in
+// the graph predates this check and is not this manifest's to answer for.
+func (g DependencyGraph) cycleThrough(start manifest.ResourceName) []manifest.ResourceName {
+	edges := make(map[manifest.ResourceName][]manifest.ResourceName, len(g.Scenarios))
+	for _, node := range g.Scenarios {
+		edges[node.Scenario] = node.DependsOn
+	}
+
+	visited := map[manifest.ResourceName]bool{}
+	var path []manifest.ResourceName
+
+	var walk func(manifest.ResourceName) bool
+	walk = func(name manifest.ResourceName) bool {
+		path = append(path, name)
+		for _, next := range edges[name] {
+			if next == start {
+				path = append(path, next)
+				return true
+			}
+			if visited[next] {
+				continue
+			}
+			visited[next] = true
+			if walk(next) {
+				return true
+			}
+		}
+		path = path[:len(path)-1]
+		return false
+	}
+
+	if walk(start) {
+		return path
+	}
+
+	return nil
+}
+
+// checkDependencyCycle refuses `candidate` if, stored alongside the scenarios
+// that exist, its dependencies would lead back to it.
+//
+// The candidate replaces its stored self, labels included: relabelling a
+// scenario can pull it into another's selector just as editing its own
+// DependsOn can.
+func checkDependencyCycle(ctx context.Context, store dbstore.TransactionalStore, candidate Scenario) error {
+	var all []Scenario
+	if _, err := store.Find(ctx, &all, manifest.SearchQuery{}); err != nil {
+		return fmt.Errorf("failed to list scenarios to check dependencies: %w", err)
+	}
+
+	all = slices.DeleteFunc(all, func(s Scenario) bool { return s.Name == candidate.Name })
+	all = append(all, candidate)
+
+	if cycle := newDependencyGraph(all).cycleThrough(candidate.Name); cycle != nil {
+		names := make([]string, 0, len(cycle))
+		for _, name := range cycle {
+			names = append(names, string(name))
+		}
+		return fmt.Errorf("%w: %s", ErrDependencyCycle, strings.Join(names, " -> "))
+	}
+
+	return nil
+}
+
+// dependencySnapshot is the scenarios table as failingUpstream reads it: every
+// scenario, without its script, and what each one's dependencies resolve to
+// among them.
+type dependencySnapshot struct {
+	scenarios []Scenario
+	byName    map[manifest.ResourceName]int
+
+	// upstream is each scenario's resolved dependencies: for a dependency by
+	// selector, the scenarios it covers.
+	upstream *scenarioMemo[[]manifest.ResourceName]
+}
+
+func loadDependencySnapshot(ctx context.Context, store dbstore.TransactionalStore) (dependencySnapshot, error) {
+	var all []Scenario
+	if _, err := store.Find(ctx, &all, manifest.SearchQuery{}); err != nil {
+		return dependencySnapshot{}, fmt.Errorf("failed to list scenarios to resolve dependencies: %w", err)
+	}
+
+	snapshot := dependencySnapshot{
+		scenarios: all,
+		byName:    make(map[manifest.ResourceName]int, len(all)),
+		upstream:  newScenarioMemo[[]manifest.ResourceName](),
+	}
+	for i := range snapshot.scenarios {
+		// A script is the bulk of a scenario, and nothing here reads it.
+		snapshot.scenarios[i].Spec.Prob.Spec = nil
+		snapshot.byName[snapshot.scenarios[i].Name] = i
+	}
+
+	return snapshot, nil
+}
+
+// resolve returns the scenarios `scenario` depends on.
+func (s dependencySnapshot) resolve(scenario Scenario) []manifest.ResourceName {
+	return s.upstream.get(scenario, func(scenario Scenario) []manifest.ResourceName {
+		upstream, _ := resolveDependencies(scenario, s.scenarios)
+		return upstream
+	})
+}
+
+// failingUpstream finds the first of a scenario's dependencies, in name order,
+// whose latest verdict is failing.
+//
+// The latest verdict is the newest finished run that measured the target, from
+// any runner, outside any maintenance window: a dependency under planned
+// maintenance is not a root cause anyone is paged for, and its dependents'
+// failures then stand as their own. A dependency that is switched off has no
+// current verdict and is passed over.
+//
+// The dependencies are resolved against the service's policy cache rather than
+// a read of every scenario per failed run; see policyCaches. The verdicts are
+// not cached: they are what changes from one run to the next.
+func failingUpstream(ctx context.Context, store dbstore.TransactionalStore, cache *policyCache[dependencySnapshot], scenario Scenario) (manifest.ResourceName, error) {
+	if len(scenario.Spec.DependsOn) == 0 {
+		return "", nil
+	}
+
+	snapshot, err := cache.get(ctx, store)
+	if err != nil {
+		return "", err
+	}
+
+	upstream := snapshot.resolve(scenario)
+	if len(upstream) == 0 {
+		return "", nil
+	}
+
+	measured, err := manifest.NewRequirement(LabelResultStatus, manifest.In, measuredOutcomes())
+	if err != nil {
+		return "", fmt.Errorf("failed to build the verdict query: %w", err)
+	}
+
+	for _, name := range upstream {
+		dependency := snapshot.scenarios[snapshot.byName[name]]
+		if !dependency.Spec.IsActive {
+			continue
+		}
+
+		// A few rather than one, so that the newest run outside maintenance
+		// is among them without a label query the store cannot express.
+		var latest []Result
+		if _, err := store.FindLinked(ctx, &latest, "Results", &dependency,
+			manifest.SearchQuery{Selector: manifest.NewSelector(measured), Limit: latestVerdictLookback},
+			dbstore.OrderByCreatedAt(dbstore.OrderDescending)); err != nil {
+			return "", fmt.Errorf("failed to read the latest verdict of %q: %w", name, err)
+		}
+
+		for _, run := range latest {
+			if run.Status.Maintenance != "" {
+				continue
+			}
+			if classifyOutcome(ResultOutcome(run)) == availabilityBad {
+				return name, nil
+			}
+			break
+		}
+	}
+
+	return "", nil
+}
+
+// latestVerdictLookback is how many of a dependency's newest runs are read to
+// find its latest verdict.
+const latestVerdictLookback = 10
+
+// measuredOutcomes are the verdicts that say something about a target.
+func measuredOutcomes() []string {
+	return []string{
+		string(prob.RunFinishedSuccess), string(prob.RunFinishedDegraded),
+		string(prob.RunFinishedFailed), string(prob.RunFinishedTimeout),
+	}
+}
+
+// judgeUpstream labels a failed run whose scenario depends on one that is
+// failing too.
+//
+// Any failure is logged and the run recorded as reported, for the reason
+// judgeLatency gives: the outcome is a fact, and which failure caused which is
+// an opinion about it. A run in maintenance is not judged; it is out of every
+// verdict already.
+func (m *resultsAPIImpl) judgeUpstream(ctx context.Context, entry *Result) {
+	if entry.Status.Maintenance != "" || classifyOutcome(ResultOutcome(*entry)) != availabilityBad {
+		return
+	}
+
+	var scenario Scenario
+	if ok, err := m.store.GetByUID(ctx, &scenario, entry.Spec.ScenarioID); err != nil || !ok {
+		log.Printf("not judging the dependencies of %q: its scenario could not be read: %v", entry.Name, err)
+		return
+	}
+
+	upstream, err := failingUpstream(ctx, m.store, m.policies.dependencies, scenario)
+	if err != nil {
+		log.Print(err)
+		return
+	}
+	if upstream != "" {
+		markUpstreamFailure(entry, upstream)
+	}
+}
+
+// markUpstreamFailure records that a failed run's dependency was failing too.
+func markUpstreamFailure(result *Result, upstream manifest.ResourceName) {
+	result.Status.UpstreamFailed = upstream
+	putLabel(result.Labels, LabelResultUpstreamFailed, string(upstream))
+}
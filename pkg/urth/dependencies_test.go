@@ -0,0 +1,62 @@
+package urth
+
+import (
+	"testing"
+
+	"github.com/sre-norns/wyrd/pkg/manifest"
+	"github.com/stretchr/testify/require"
+)
+
+// dependentScenario declares a scenario depending on others by name.
+func dependentScenario(name manifest.ResourceName, upstream ...manifest.ResourceName) Scenario {
+	scenario := Scenario{ObjectMeta: manifest.ObjectMeta{Name: name}}
+	for _, dependency := range upstream {
+		scenario.Spec.DependsOn = append(scenario.Spec.DependsOn, ScenarioDependency{Scenario: dependency})
+	}
+
+	return scenario
+}
+
+func TestDependencyGraphResolvesBothDirections(t *testing.T) {
+	graph := newDependencyGraph([]Scenario{
+		dependentScenario("checkout", "vpn-gateway", "payments"),
+		dependentScenario("payments", "vpn-gateway"),
+		dependentScenario("vpn-gateway"),
+		// Applied before the scenario it names: kept, and reported as missing.
+		dependentScenario("search", "index-builder"),
+	})
+
+	require.Equal(t, []ScenarioDependencies{
+		{Scenario: "checkout", DependsOn: []manifest.ResourceName{"payments", "vpn-gateway"}},
+		{Scenario: "payments", DependsOn: []manifest.ResourceName{"vpn-gateway"}, Dependents: []manifest.ResourceName{"checkout"}},
+		{Scenario: "search", Missing: []manifest.ResourceName{"index-builder"}},
+		{Scenario: "vpn-gateway", Dependents: []manifest.ResourceName{"checkout", "payments"}},
+	}, graph.Scenarios)
+}
+
+func TestDependencyCycleIsFoundThroughTheAppliedScenario(t *testing.T) {
+	graph := newDependencyGraph([]Scenario{
+		dependentScenario("checkout", "payments"),
+		dependentScenario("payments", "vpn-gateway"),
+		dependentScenario("vpn-gateway", "checkout"),
+		dependentScenario("search", "checkout"),
+	})
+
+	require.Equal(t, []manifest.ResourceName{"vpn-gateway", "checkout", "payments", "vpn-gateway"},
+		graph.cycleThrough("vpn-gateway"))
+	require.Nil(t, graph.cycleThrough("search"), "depending on a cycle is not being part of one")
+
+	require.Equal(t, []manifest.ResourceName{"self", "self"},
+		newDependencyGraph([]Scenario{dependentScenario("self", "self")}).cycleThrough("self"))
+}
+
+func TestScenarioDependencyNamesExactlyOneThing(t *testing.T) {
+	require.NoError(t, ScenarioDependency{Scenario: "vpn-gateway"}.Validate())
+	require.NoError(t, ScenarioDependency{Selector: manifest.LabelSelector{MatchLabels: manifest.Labels{"tier": "network"}}}.Validate())
+
+	require.Error(t, ScenarioDependency{}.Validate())
+	require.Error(t, ScenarioDependency{
+		Scenario: "vpn-gateway",
+		Selector: manifest.LabelSelector{MatchLabels: manifest.Labels{"tier": "network"}},
+	}.Validate())
+}
@@ -256,6 +256,8 @@ func retryDispatchFailure(ctx context.Context, store dbstore.TransactionalStore,
 		},
 	}
 
+	prioritise(&retry, retryPriorityClass(ctx, store, original.Spec.ScenarioID), TriggerRetry)
+
 	updated := failure
 
 	tx, err := store.Begin(ctx)
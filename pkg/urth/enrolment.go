@@ -0,0 +1,260 @@
+package urth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sre-norns/wyrd/pkg/bark"
+	"github.com/sre-norns/wyrd/pkg/dbstore"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// Enrolment tokens: a record of every runner token issued, so that one can be
+// taken back.
+//
+// The enrolment credential -- see "Worker enrolment, in three credentials" --
+// is reusable, held by whoever provisions workers, and signed with a secret
+// every runner shares. The prototype kept no record of having issued one, which
+// left exactly two ways to stop a leaked token working: wait out its expiry, or
+// rotate the signing secret and re-enrol every worker of every runner. The first
+// is a day, the second is an outage.
+//
+// So every token now carries an ID, its `jti`, and is recorded under that name
+// when it is issued. Registration looks the record up, and a token whose record
+// is revoked, or missing, is refused. The record keeps a fingerprint of the
+// token rather than the token itself: enough to tell which token a worker came
+// in with, not enough to enrol one.
+//
+// A token issued before tokens were recorded has no `jti`. It is accepted, as it
+// always was, and expires within a day of having been issued; a blocklist entry
+// by fingerprint bars one sooner. See WorkerBlock.
+
+// KindEnrolmentToken is the resource kind for issued enrolment tokens.
+const KindEnrolmentToken manifest.Kind = "enrolmentTokens"
+
+// EnrolmentTokenTTL is how long an enrolment token stays valid once issued.
+const EnrolmentTokenTTL = 23 * time.Hour
+
+// EnrolmentTokenSpec is what was issued, and to which runner.
+type EnrolmentTokenSpec struct {
+	// RunnerID is the runner the token enrols workers into, and RunnerName its
+	// name when the token was issued.
+	RunnerID   manifest.ResourceID   `form:"runnerId" json:"runnerId" yaml:"runnerId" xml:"runnerId"`
+	RunnerName manifest.ResourceName `form:"runnerName" json:"runnerName" yaml:"runnerName" xml:"runnerName"`
+
+	// Fingerprint is the token's EnrolmentFingerprint, which is what a worker
+	// registered with it records and what a WorkerBlock can name.
+	Fingerprint string `form:"fingerprint" json:"fingerprint" yaml:"fingerprint" xml:"fingerprint"`
+
+	IssuedAt  time.Time `form:"issuedAt" json:"issuedAt" yaml:"issuedAt" xml:"issuedAt" gorm:"type:TIMESTAMPTZ"`
+	ExpiresAt time.Time `form:"expiresAt" json:"expiresAt" yaml:"expiresAt" xml:"expiresAt" gorm:"type:TIMESTAMPTZ"`
+}
+
+// EnrolmentTokenStatus says whether a token has been taken back.
+type EnrolmentTokenStatus struct {
+	// RevokedAt is when the token was revoked; nil while it is good.
+	RevokedAt *time.Time `form:"revokedAt,omitempty" json:"revokedAt,omitempty" yaml:"revokedAt,omitempty" xml:"revokedAt,omitempty" gorm:"type:TIMESTAMPTZ NULL"`
+
+	// Reason is what the operator who revoked it said, if anything.
+	Reason string `form:"reason,omitempty" json:"reason,omitempty" yaml:"reason,omitempty" xml:"reason,omitempty"`
+}
+
+// EnrolmentToken is the record of one issued runner token, named by its `jti`.
+type EnrolmentToken manifest.StatefulResource[EnrolmentTokenSpec, EnrolmentTokenStatus]
+
+// ToManifest renders the resource for the API.
+func (r EnrolmentToken) ToManifest() manifest.ResourceManifest {
+	return manifest.ToManifestWithStatus(manifest.StatefulResource[EnrolmentTokenSpec, EnrolmentTokenStatus](r))
+}
+
+// NewEnrolmentToken converts a manifest into the model.
+func NewEnrolmentToken(m manifest.ResourceManifest) (EnrolmentToken, error) {
+	e, err := manifest.ManifestAsStatefulResource[EnrolmentTokenSpec, EnrolmentTokenStatus](m)
+	entry := EnrolmentToken(e)
+	if err != nil {
+		return entry, fmt.Errorf("failed to convert resource manifest into an EnrolmentToken model: %w", err)
+	}
+
+	if entry.ObjectMeta.Name == "" {
+		return entry, ErrResourceNameEmpty
+	}
+
+	if err := entry.ObjectMeta.Validate(); err != nil {
+		return entry, err
+	}
+
+	return entry, nil
+}
+
+// IsRevoked reports whether the token has been taken back.
+func (r EnrolmentToken) IsRevoked() bool {
+	return r.Status.RevokedAt != nil
+}
+
+// RevokeTokenRequest asks the server to revoke an enrolment token.
+type RevokeTokenRequest struct {
+	// Reason is kept on the token's record, for whoever wonders later why
+	// their workers stopped registering.
+	Reason string `form:"reason,omitempty" json:"reason,omitempty" yaml:"reason,omitempty" xml:"reason,omitempty"`
+}
+
+// EnrolmentFingerprint identifies an enrolment token without disclosing it.
+//
+// It is the one credential a worker presents that an operator also has, so it
+// is what ties a worker to the token it came in with -- including a token old
+// enough to have no `jti` to look up.
+func EnrolmentFingerprint(token APIToken) string {
+	sum := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// newEnrolmentToken builds the record of a token about to be issued.
+func newEnrolmentToken(runner Runner, id string, token APIToken, issuedAt, expiresAt time.Time) EnrolmentToken {
+	return EnrolmentToken{
+		ObjectMeta: manifest.ObjectMeta{
+			Name:   manifest.ResourceName(id),
+			Labels: workerLabels(runner),
+		},
+		Spec: EnrolmentTokenSpec{
+			RunnerID:    runner.UID,
+			RunnerName:  runner.Name,
+			Fingerprint: EnrolmentFingerprint(token),
+			IssuedAt:    issuedAt,
+			ExpiresAt:   expiresAt,
+		},
+	}
+}
+
+// enrolmentTokenID reads the `jti` of a parsed enrolment token, empty for a
+// token issued before they had one.
+func enrolmentTokenID(token *jwt.Token) string {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return ""
+	}
+
+	id, _ := claims["jti"].(string)
+	return id
+}
+
+// checkEnrolment refuses an enrolment token whose record says it must not be
+// used: revoked, issued for another runner, or not on record at all.
+//
+// A missing record is refused rather than waved through. Every token with an ID
+// was recorded before it was handed out, so an ID nobody recorded is a token
+// this server did not issue, or one whose record was deliberately removed.
+func checkEnrolment(ctx context.Context, store dbstore.TransactionalStore, id string, runner Runner) error {
+	var record EnrolmentToken
+	if ok, err := store.GetByName(ctx, &record, manifest.ResourceName(id)); err != nil {
+		return err
+	} else if !ok {
+		log.Printf("refused enrolment token %q for runner %q: not on record", id, runner.Name)
+		return bark.ErrResourceUnauthorized
+	}
+
+	if record.Spec.RunnerID != runner.UID {
+		log.Printf("refused enrolment token %q for runner %q: it was issued for runner %q", id, runner.Name, record.Spec.RunnerName)
+		return bark.ErrResourceUnauthorized
+	}
+
+	if record.IsRevoked() {
+		log.Printf("refused enrolment token %q for runner %q: revoked at %v", id, runner.Name, *record.Status.RevokedAt)
+		return bark.ErrResourceUnauthorized
+	}
+
+	return nil
+}
+
+// Tokens lists the enrolment tokens issued for a runner, oldest first, revoked
+// and expired ones included: the list is how an operator finds the token a
+// worker came in with, and that may well be one of those.
+func (m *runnersAPIImpl) Tokens(ctx context.Context, runnerName manifest.ResourceName) ([]EnrolmentToken, bool, error) {
+	var runner Runner
+	if ok, err := m.store.GetByName(ctx, &runner, runnerName); err != nil || !ok {
+		return nil, ok, err
+	}
+
+	requirement, err := manifest.NewRequirement(LabelRunnerUID, manifest.In, []string{string(runner.UID)})
+	if err != nil {
+		return nil, true, fmt.Errorf("failed to build a token query for runner %q: %w", runner.Name, err)
+	}
+
+	var records []EnrolmentToken
+	if _, err := m.store.Find(ctx, &records, manifest.SearchQuery{Selector: manifest.NewSelector(requirement)},
+		dbstore.OrderByCreatedAt(dbstore.OrderAscending)); err != nil {
+		return nil, true, err
+	}
+
+	tokens := make([]EnrolmentToken, 0, len(records))
+	for _, record := range records {
+		// The label query should not return another runner's token; a
+		// hand-edited label could, and listing it here would invite revoking
+		// the wrong one.
+		if record.Spec.RunnerID == runner.UID {
+			tokens = append(tokens, record)
+		}
+	}
+
+	return tokens, true, nil
+}
+
+// RevokeToken takes back an enrolment token, so that no worker can register
+// with it again.
+//
+// Revoking is all it does to registration. Workers already registered with the
+// token keep their records, but registration is not the only place the token is
+// consulted: a worker's claims and heartbeats are refused once the token it came
+// in with is revoked. See workerBarred.
+//
+// Revoking a revoked token changes nothing and is not an error; the first
+// revocation's time and reason stand.
+func (m *runnersAPIImpl) RevokeToken(ctx context.Context, runnerName manifest.ResourceName, tokenID manifest.ResourceName, request RevokeTokenRequest) (EnrolmentToken, error) {
+	var runner Runner
+	if ok, err := m.store.GetByName(ctx, &runner, runnerName); err != nil {
+		return EnrolmentToken{}, err
+	} else if !ok {
+		return EnrolmentToken{}, bark.ErrResourceNotFound
+	}
+
+	var record EnrolmentToken
+	if ok, err := m.store.GetByName(ctx, &record, tokenID); err != nil {
+		return record, err
+	} else if !ok || record.Spec.RunnerID != runner.UID {
+		// Another runner's token reads as not found, so that a typo in the
+		// runner's name cannot revoke a token the caller did not mean.
+		return EnrolmentToken{}, bark.ErrResourceNotFound
+	}
+
+	if record.IsRevoked() {
+		return record, nil
+	}
+
+	now := time.Now()
+	record.Status.RevokedAt = &now
+	record.Status.Reason = request.Reason
+
+	defer m.bars.invalidate()
+	if ok, err := m.store.Update(ctx, &record, record.UID, dbstore.WithVersion(record.Version)); err != nil {
+		return record, err
+	} else if !ok {
+		return record, bark.ErrResourceVersionConflict
+	}
+
+	log.Printf("revoked enrolment token %q of runner %q: %s", record.Name, runner.Name, request.Reason)
+
+	return record, nil
+}
+
+// issuedTokenID mints the `jti` of a new enrolment token.
+//
+// Lower-cased because it is also the record's name, and a resource name must be
+// a DNS subdomain, which NewRandToken's mixed-case alphabet is not.
+func issuedTokenID() string {
+	return strings.ToLower(string(NewRandToken(16)))
+}
@@ -0,0 +1,526 @@
+package urth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Key rotation.
+//
+// A tier used to have exactly one secret, so changing it invalidated every
+// token it had signed at the moment the new one took over: every worker session
+// at once, and every run in flight, whose capability is its only way to report.
+// Rotating on a schedule meant scheduling an outage.
+//
+// Now a tier holds a set of keys, each with an ID and a validity window, and
+// every token names the key that signed it in its `kid` header. A token is
+// verified against the key it names, so an old key can keep verifying the tokens
+// it signed while a new one signs everything issued from now on. Rotation is a
+// sequence of three states a key passes through:
+//
+//   - staged: verified but not yet signing. A new key is written with a
+//     NotBefore in the future, so that every replica has read it before any
+//     replica signs with it. Without this, the first replica to pick it up would
+//     issue tokens the others refuse.
+//   - current: the newest key past its NotBefore. It signs.
+//   - retiring: superseded, still verifying until its NotAfter. The overlap
+//     between the two is what lets the tokens it signed run out on their own.
+//
+// See RotateSigningKey, and `urthctl signing-keys rotate`, which calls it.
+
+// KeyTier names one of the three token families Urth signs. Each has its own
+// keys; see SigningKeys for why.
+type KeyTier string
+
+const (
+	KeyTierEnrolment KeyTier = "enrolment"
+	KeyTierSession   KeyTier = "session"
+	KeyTierRun       KeyTier = "run"
+)
+
+// KeyTiers are every tier, in the order they are reported.
+var KeyTiers = []KeyTier{KeyTierEnrolment, KeyTierSession, KeyTierRun}
+
+// ParseKeyTier reads a tier name as an operator writes it.
+func ParseKeyTier(name string) (KeyTier, error) {
+	tier := KeyTier(strings.ToLower(strings.TrimSpace(name)))
+	if !slices.Contains(KeyTiers, tier) {
+		return "", fmt.Errorf("unknown signing key tier %q, expected one of %v", name, KeyTiers)
+	}
+
+	return tier, nil
+}
+
+// DefaultRotationOverlap is how long a superseded key of a tier keeps verifying
+// when a rotation does not say. It outlasts the longest token the tier issues
+// under default settings: an enrolment token's EnrolmentTokenTTL, an hour's
+// session, and a run capability of the default maximum run duration plus the
+// upload and late-report graces.
+func DefaultRotationOverlap(tier KeyTier) time.Duration {
+	switch tier {
+	case KeyTierEnrolment:
+		return EnrolmentTokenTTL + time.Hour
+	default:
+		return 2 * time.Hour
+	}
+}
+
+// minKeyringSecret is the shortest secret accepted from a keyring directory.
+// Those are written by RotateSigningKey, which generates 32 bytes; anything
+// shorter was written by hand, and HS256 is only as strong as its secret.
+const minKeyringSecret = 32
+
+// SigningKey is one secret of a tier, named and bounded in time.
+type SigningKey struct {
+	// ID is the `kid` of every token the key signs.
+	ID string `json:"id"`
+
+	// Secret is the HMAC secret. Base64 in a key file.
+	Secret []byte `json:"secret"`
+
+	// NotBefore is when the key starts signing. It verifies from the moment it
+	// is read. Zero signs at once.
+	NotBefore time.Time `json:"notBefore,omitzero"`
+
+	// NotAfter is when the key stops verifying, and signing. Zero never.
+	NotAfter time.Time `json:"notAfter,omitzero"`
+}
+
+// Verifies reports whether a token signed with the key is still accepted.
+func (k SigningKey) Verifies(now time.Time) bool {
+	return k.NotAfter.IsZero() || now.Before(k.NotAfter)
+}
+
+// Signs reports whether the key may sign a token.
+func (k SigningKey) Signs(now time.Time) bool {
+	return k.Verifies(now) && !now.Before(k.NotBefore)
+}
+
+// ActiveSigningKey picks the key that signs: of those allowed to, the one that
+// started signing last. A key that started earlier is one a rotation has
+// superseded, even if it has not retired yet.
+func ActiveSigningKey(keys []SigningKey, now time.Time) (SigningKey, bool) {
+	var active SigningKey
+	found := false
+	for _, key := range keys {
+		if !key.Signs(now) {
+			continue
+		}
+
+		if !found || !key.NotBefore.Before(active.NotBefore) {
+			active, found = key, true
+		}
+	}
+
+	return active, found
+}
+
+// staticKeyID names a key that was configured as a bare secret, with no ID of
+// its own.
+//
+// Derived from the secret rather than generated, so that replicas configured
+// with the same secret give its tokens the same `kid` and verify each other's.
+// A truncated digest says nothing about the secret an HMAC signature does not
+// already.
+func staticKeyID(secret []byte) string {
+	digest := sha256.Sum256(secret)
+	return "static-" + hex.EncodeToString(digest[:8])
+}
+
+// TokenKeys is where tokens of each tier are signed and verified from.
+//
+// Implemented by SigningKeys, a fixed secret per tier, and by Keyring, which
+// holds several and reloads them. The token helpers take either, so a test can
+// hand them a literal and a server its keyring.
+type TokenKeys interface {
+	// SigningKey returns the key a new token of the tier is signed with.
+	SigningKey(tier KeyTier) (SigningKey, error)
+
+	// VerificationKeys returns every key a token of the tier is accepted from.
+	VerificationKeys(tier KeyTier) []SigningKey
+}
+
+// signToken signs claims with the current key of a tier, naming it in the
+// token's `kid` header.
+func signToken(keys TokenKeys, tier KeyTier, claims jwt.Claims) (string, error) {
+	key, err := keys.SigningKey(tier)
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	token.Header["kid"] = key.ID
+
+	return token.SignedString(key.Secret)
+}
+
+// tokenKeyfunc verifies a token of a tier against the key it names.
+//
+// A token naming a key the tier does not hold, or no longer accepts, is refused
+// outright. One naming none was signed before tokens carried a `kid`; it is
+// tried against every key the tier accepts, which is what accepting it before
+// amounted to, and stops working once the key it was signed with retires.
+func tokenKeyfunc(keys TokenKeys, tier KeyTier) jwt.Keyfunc {
+	return func(token *jwt.Token) (any, error) {
+		// Don't forget to validate the alg is what you expect:
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
+		}
+
+		accepted := keys.VerificationKeys(tier)
+
+		if kid, ok := token.Header["kid"]; ok {
+			id, _ := kid.(string)
+			for _, key := range accepted {
+				if key.ID == id {
+					return key.Secret, nil
+				}
+			}
+
+			return nil, fmt.Errorf("unknown %s signing key %q", tier, id)
+		}
+
+		set := jwt.VerificationKeySet{}
+		for _, key := range accepted {
+			set.Keys = append(set.Keys, key.Secret)
+		}
+
+		return set, nil
+	}
+}
+
+// Keyring holds the keys of every tier and reads them again on demand.
+//
+// Safe for concurrent use: every request reads it, and Watch replaces its keys
+// underneath them. A reload that fails -- an unreadable file, a tier left with
+// no key able to sign -- keeps the keys already held, because refusing every
+// token over a typo in a key file is the outage rotation exists to avoid.
+type Keyring struct {
+	load func() (map[KeyTier][]SigningKey, error)
+
+	mu   sync.RWMutex
+	keys map[KeyTier][]SigningKey
+}
+
+// NewKeyring holds a fixed secret per tier. It never changes on reload.
+func NewKeyring(keys SigningKeys) *Keyring {
+	fixed := map[KeyTier][]SigningKey{}
+	for _, tier := range KeyTiers {
+		if key, err := keys.SigningKey(tier); err == nil {
+			fixed[tier] = []SigningKey{key}
+		}
+	}
+
+	return &Keyring{
+		load: func() (map[KeyTier][]SigningKey, error) { return fixed, nil },
+		keys: fixed,
+	}
+}
+
+// SigningKey returns the key a new token of the tier is signed with.
+func (r *Keyring) SigningKey(tier KeyTier) (SigningKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	key, ok := ActiveSigningKey(r.keys[tier], time.Now())
+	if !ok {
+		return key, fmt.Errorf("no %s signing key is active", tier)
+	}
+
+	return key, nil
+}
+
+// VerificationKeys returns every key a token of the tier is accepted from.
+func (r *Keyring) VerificationKeys(tier KeyTier) []SigningKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	now := time.Now()
+	accepted := make([]SigningKey, 0, len(r.keys[tier]))
+	for _, key := range r.keys[tier] {
+		if key.Verifies(now) {
+			accepted = append(accepted, key)
+		}
+	}
+
+	return accepted
+}
+
+// Reload reads the keys again, and reports whether they changed.
+func (r *Keyring) Reload() (bool, error) {
+	keys, err := r.load()
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	for _, tier := range KeyTiers {
+		if _, ok := ActiveSigningKey(keys[tier], now); !ok {
+			return false, fmt.Errorf("no %s signing key is active", tier)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changed := keyringSummary(r.keys) != keyringSummary(keys)
+	r.keys = keys
+
+	return changed, nil
+}
+
+// keyringSummary is what a reload compares to say whether anything changed:
+// which keys each tier holds, and when each is valid. Never the secrets.
+func keyringSummary(keys map[KeyTier][]SigningKey) string {
+	var summary strings.Builder
+	for _, tier := range KeyTiers {
+		for _, key := range keys[tier] {
+			fmt.Fprintf(&summary, "%s/%s %s %s %x;", tier, key.ID, key.NotBefore, key.NotAfter, sha256.Sum256(key.Secret))
+		}
+	}
+
+	return summary.String()
+}
+
+// Watch reloads the keys every interval, and on every SIGHUP, until ctx ends.
+// An interval of zero reloads on SIGHUP only.
+func (r *Keyring) Watch(ctx context.Context, interval time.Duration) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tick:
+			r.reload()
+		case <-hangups:
+			r.reload()
+		}
+	}
+}
+
+func (r *Keyring) reload() {
+	changed, err := r.Reload()
+	if err != nil {
+		log.Printf("signing keys not reloaded, keeping the current ones: %v", err)
+		return
+	}
+
+	if changed {
+		log.Printf("signing keys reloaded: %s", r)
+	}
+}
+
+// String lists the keys each tier holds by ID, for a log line.
+func (r *Keyring) String() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	tiers := make([]string, 0, len(KeyTiers))
+	for _, tier := range KeyTiers {
+		ids := make([]string, 0, len(r.keys[tier]))
+		for _, key := range r.keys[tier] {
+			ids = append(ids, key.ID)
+		}
+		tiers = append(tiers, fmt.Sprintf("%s=%v", tier, ids))
+	}
+
+	return strings.Join(tiers, " ")
+}
+
+// A keyring directory holds one subdirectory per tier, and one file per key in
+// it, named after the key's ID:
+//
+//	<dir>/session/20261019T140000Z-3f9a1c0e.json
+//
+// One file per key rather than one per tier, so that writing a new key never
+// rewrites the ones in use, and a replica reading mid-rotation sees each key
+// either whole or not at all. Files are written to a temporary name and renamed
+// into place for the same reason.
+
+// keyFileExt is the extension of a key file in a keyring directory.
+const keyFileExt = ".json"
+
+// ReadKeyringDir reads every key in a keyring directory, by tier. A tier with no
+// subdirectory has no keys.
+func ReadKeyringDir(dir string) (map[KeyTier][]SigningKey, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("failed to read keyring directory: %w", err)
+	}
+
+	keys := map[KeyTier][]SigningKey{}
+	for _, tier := range KeyTiers {
+		tierKeys, err := readTierKeys(dir, tier)
+		if err != nil {
+			return nil, err
+		}
+
+		keys[tier] = tierKeys
+	}
+
+	return keys, nil
+}
+
+func readTierKeys(dir string, tier KeyTier) ([]SigningKey, error) {
+	entries, err := os.ReadDir(filepath.Join(dir, string(tier)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read %s keys: %w", tier, err)
+	}
+
+	var keys []SigningKey
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), keyFileExt)
+		if entry.IsDir() || !ok || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		path := filepath.Join(dir, string(tier), entry.Name())
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s key %q: %w", tier, id, err)
+		}
+
+		var key SigningKey
+		if err := json.Unmarshal(content, &key); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+		}
+
+		// The file name is what an operator sees and what a rotation deletes by,
+		// so it must not disagree with the `kid` the key signs under.
+		if key.ID != id {
+			return nil, fmt.Errorf("key file %s holds key %q", path, key.ID)
+		}
+
+		if len(key.Secret) < minKeyringSecret {
+			return nil, fmt.Errorf("key %s/%s is %d bytes, at least %d are required", tier, id, len(key.Secret), minKeyringSecret)
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// writeSigningKey writes a key into a tier's directory, creating it if needed.
+func writeSigningKey(dir string, tier KeyTier, key SigningKey) error {
+	tierDir := filepath.Join(dir, string(tier))
+	if err := os.MkdirAll(tierDir, 0o700); err != nil {
+		return fmt.Errorf("failed to create %s: %w", tierDir, err)
+	}
+
+	content, err := json.MarshalIndent(key, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	temp, err := os.CreateTemp(tierDir, ".key-*")
+	if err != nil {
+		return fmt.Errorf("failed to write %s key %q: %w", tier, key.ID, err)
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(content); err != nil {
+		_ = temp.Close()
+		return fmt.Errorf("failed to write %s key %q: %w", tier, key.ID, err)
+	}
+
+	if err := temp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s key %q: %w", tier, key.ID, err)
+	}
+
+	return os.Rename(temp.Name(), filepath.Join(tierDir, key.ID+keyFileExt))
+}
+
+// RotateSigningKey adds a new key to a tier of a keyring directory and retires
+// the ones it supersedes.
+//
+// The new key signs from activateAfter on, which should be longer than the
+// servers' reload interval so that every replica verifies it before any signs
+// with it. Every key it supersedes keeps verifying for overlap past that, which
+// should be longer than the tier's longest-lived token, so that nothing signed
+// before the switch stops working before it would have expired anyway. A key
+// already due to retire sooner is left as it is.
+//
+// Keys that have already retired are deleted. Nothing else is: an operator who
+// rotated by mistake rotates again, and the keys still verifying stay.
+func RotateSigningKey(dir string, tier KeyTier, activateAfter, overlap time.Duration) (SigningKey, error) {
+	now := time.Now().UTC()
+
+	existing, err := readTierKeys(dir, tier)
+	if err != nil {
+		return SigningKey{}, err
+	}
+
+	secret := make([]byte, minKeyringSecret)
+	if _, err := rand.Read(secret); err != nil {
+		return SigningKey{}, fmt.Errorf("failed to generate %s signing key: %w", tier, err)
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return SigningKey{}, fmt.Errorf("failed to generate %s signing key: %w", tier, err)
+	}
+
+	activatesAt := now.Add(max(activateAfter, 0))
+	created := SigningKey{
+		ID:        activatesAt.Format("20060102T150405Z") + "-" + hex.EncodeToString(suffix),
+		Secret:    secret,
+		NotBefore: activatesAt,
+	}
+
+	// Written first: should anything after fail, the tier has gained a key
+	// rather than lost one.
+	if err := writeSigningKey(dir, tier, created); err != nil {
+		return SigningKey{}, err
+	}
+
+	retiresAt := activatesAt.Add(overlap)
+	for _, key := range existing {
+		path := filepath.Join(dir, string(tier), key.ID+keyFileExt)
+
+		if !key.Verifies(now) {
+			if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+				return created, fmt.Errorf("failed to delete retired %s key %q: %w", tier, key.ID, err)
+			}
+			continue
+		}
+
+		if !key.NotAfter.IsZero() && !key.NotAfter.After(retiresAt) {
+			continue
+		}
+
+		key.NotAfter = retiresAt
+		if err := writeSigningKey(dir, tier, key); err != nil {
+			return created, err
+		}
+	}
+
+	return created, nil
+}
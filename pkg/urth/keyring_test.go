@@ -0,0 +1,297 @@
+package urth
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+)
+
+func testSecret(fill byte) []byte {
+	return bytes.Repeat([]byte{fill}, minKeyringSecret)
+}
+
+// fixedKeyring holds the given keys for every tier.
+func fixedKeyring(keys ...SigningKey) *Keyring {
+	held := map[KeyTier][]SigningKey{}
+	for _, tier := range KeyTiers {
+		held[tier] = keys
+	}
+
+	return &Keyring{
+		load: func() (map[KeyTier][]SigningKey, error) { return held, nil },
+		keys: held,
+	}
+}
+
+func runCapability(t *testing.T, keys TokenKeys) string {
+	t.Helper()
+
+	signed, err := signToken(keys, KeyTierRun, jwt.RegisteredClaims{
+		Subject:   "result-uid",
+		ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+	})
+	require.NoError(t, err)
+
+	return signed
+}
+
+func verifyRunCapability(keys TokenKeys, token string) error {
+	_, err := jwt.Parse(token, tokenKeyfunc(keys, KeyTierRun))
+	return err
+}
+
+func tokenKID(t *testing.T, token string) any {
+	t.Helper()
+
+	parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+	require.NoError(t, err)
+
+	return parsed.Header["kid"]
+}
+
+// A configured secret signs under an ID derived from it, so that replicas given
+// the same secret name it the same way.
+func TestTokensNameTheKeyThatSignedThem(t *testing.T) {
+	keys := testKeys(t)
+
+	token := runCapability(t, keys)
+	require.Equal(t, staticKeyID(keys.Run), tokenKID(t, token))
+	require.NoError(t, verifyRunCapability(keys, token))
+
+	again, err := SigningKeysConfig{RunKey: "run-key-for-tests"}.Build()
+	require.NoError(t, err)
+	require.NoError(t, verifyRunCapability(again, token), "another replica with the same secret")
+}
+
+// The point of rotation: a token signed with the superseded key keeps working
+// until that key retires, while everything new is signed with its successor.
+func TestRotatedKeyVerifiesUntilItRetires(t *testing.T) {
+	now := time.Now()
+	old := SigningKey{ID: "old", Secret: testSecret('o')}
+	current := SigningKey{ID: "new", Secret: testSecret('n'), NotBefore: now.Add(-time.Minute)}
+
+	before := runCapability(t, fixedKeyring(old))
+
+	old.NotAfter = now.Add(time.Hour)
+	rotated := fixedKeyring(old, current)
+
+	require.NoError(t, verifyRunCapability(rotated, before), "signed before the rotation")
+
+	after := runCapability(t, rotated)
+	require.Equal(t, "new", tokenKID(t, after))
+	require.NoError(t, verifyRunCapability(rotated, after))
+
+	old.NotAfter = now.Add(-time.Second)
+	retired := fixedKeyring(old, current)
+	require.Error(t, verifyRunCapability(retired, before), "signed with a retired key")
+	require.NoError(t, verifyRunCapability(retired, after))
+}
+
+// A staged key is read before it is used: replicas that have it accept what a
+// replica already signing with it issues.
+func TestStagedKeyVerifiesButDoesNotSign(t *testing.T) {
+	current := SigningKey{ID: "current", Secret: testSecret('c')}
+	staged := SigningKey{ID: "staged", Secret: testSecret('s'), NotBefore: time.Now().Add(time.Hour)}
+
+	ring := fixedKeyring(current, staged)
+	require.Equal(t, "current", tokenKID(t, runCapability(t, ring)))
+
+	fromAnotherReplica := runCapability(t, fixedKeyring(SigningKey{ID: "staged", Secret: staged.Secret}))
+	require.NoError(t, verifyRunCapability(ring, fromAnotherReplica))
+}
+
+// A token naming a key the tier does not hold is refused even when its
+// signature would check out against one it does.
+func TestUnknownKeyIDIsRefused(t *testing.T) {
+	held := SigningKey{ID: "held", Secret: testSecret('h')}
+
+	forged := runCapability(t, fixedKeyring(SigningKey{ID: "someone-elses", Secret: held.Secret}))
+	require.Error(t, verifyRunCapability(fixedKeyring(held), forged))
+}
+
+// Tokens issued before tokens carried a `kid` are still accepted, by whichever
+// key of the tier signed them.
+func TestTokenWithoutKeyIDIsTriedAgainstEveryKey(t *testing.T) {
+	old := SigningKey{ID: "old", Secret: testSecret('o'), NotAfter: time.Now().Add(time.Hour)}
+	current := SigningKey{ID: "new", Secret: testSecret('n'), NotBefore: time.Now().Add(-time.Minute)}
+
+	legacy, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{Subject: "result-uid"}).SignedString(old.Secret)
+	require.NoError(t, err)
+
+	require.NoError(t, verifyRunCapability(fixedKeyring(old, current), legacy))
+	require.Error(t, verifyRunCapability(fixedKeyring(current), legacy))
+}
+
+func TestRotateSigningKey(t *testing.T) {
+	dir := t.TempDir()
+
+	first, err := RotateSigningKey(dir, KeyTierSession, 0, time.Hour)
+	require.NoError(t, err)
+
+	keys, err := ReadKeyringDir(dir)
+	require.NoError(t, err)
+	require.Len(t, keys[KeyTierSession], 1)
+	require.Empty(t, keys[KeyTierRun], "other tiers are left alone")
+
+	second, err := RotateSigningKey(dir, KeyTierSession, time.Minute, time.Hour)
+	require.NoError(t, err)
+	require.NotEqual(t, first.ID, second.ID)
+
+	keys, err = ReadKeyringDir(dir)
+	require.NoError(t, err)
+	require.Len(t, keys[KeyTierSession], 2)
+
+	now := time.Now()
+	active, ok := ActiveSigningKey(keys[KeyTierSession], now)
+	require.True(t, ok)
+	require.Equal(t, first.ID, active.ID, "the old key signs until the new one is staged long enough")
+
+	for _, key := range keys[KeyTierSession] {
+		if key.ID == first.ID {
+			require.WithinDuration(t, second.NotBefore.Add(time.Hour), key.NotAfter, time.Second)
+		}
+	}
+
+	active, ok = ActiveSigningKey(keys[KeyTierSession], now.Add(2*time.Minute))
+	require.True(t, ok)
+	require.Equal(t, second.ID, active.ID)
+
+	info, err := os.Stat(filepath.Join(dir, "session", second.ID+keyFileExt))
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0o600), info.Mode().Perm(), "a key file is readable by its owner only")
+}
+
+func TestRotateSigningKeyDeletesRetiredKeys(t *testing.T) {
+	dir := t.TempDir()
+
+	retired := SigningKey{ID: "retired", Secret: testSecret('r'), NotAfter: time.Now().Add(-time.Minute)}
+	require.NoError(t, writeSigningKey(dir, KeyTierRun, retired))
+
+	_, err := RotateSigningKey(dir, KeyTierRun, 0, time.Hour)
+	require.NoError(t, err)
+
+	keys, err := ReadKeyringDir(dir)
+	require.NoError(t, err)
+	require.Len(t, keys[KeyTierRun], 1)
+	require.NotEqual(t, "retired", keys[KeyTierRun][0].ID)
+}
+
+func TestKeyringDirRefusesMalformedKeys(t *testing.T) {
+	for name, key := range map[string]SigningKey{
+		"a short secret":                  {ID: "short", Secret: []byte("too-short")},
+		"an ID that is not its file name": {ID: "other", Secret: testSecret('x')},
+	} {
+		t.Run(name, func(t *testing.T) {
+			dir := t.TempDir()
+			require.NoError(t, writeSigningKey(dir, KeyTierRun, key))
+			if key.ID == "other" {
+				require.NoError(t, os.Rename(filepath.Join(dir, "run", "other.json"), filepath.Join(dir, "run", "renamed.json")))
+			}
+
+			_, err := ReadKeyringDir(dir)
+			require.Error(t, err)
+		})
+	}
+}
+
+// A key file is read again on reload, so a secret mounted from a secret store
+// can change under a running server.
+func TestKeyFileIsReadAgainOnReload(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "session.key")
+	require.NoError(t, os.WriteFile(file, []byte("first-secret\n"), 0o600))
+
+	ring, err := SigningKeysConfig{SessionKeyFile: file}.Keyring()
+	require.NoError(t, err)
+
+	key, err := ring.SigningKey(KeyTierSession)
+	require.NoError(t, err)
+	require.Equal(t, []byte("first-secret"), key.Secret, "the trailing newline is not part of the secret")
+
+	generatedRun, err := ring.SigningKey(KeyTierRun)
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile(file, []byte("second-secret"), 0o600))
+	changed, err := ring.Reload()
+	require.NoError(t, err)
+	require.True(t, changed)
+
+	key, err = ring.SigningKey(KeyTierSession)
+	require.NoError(t, err)
+	require.Equal(t, []byte("second-secret"), key.Secret)
+
+	run, err := ring.SigningKey(KeyTierRun)
+	require.NoError(t, err)
+	require.Equal(t, generatedRun, run, "a generated key survives a reload")
+
+	// A reload that fails keeps what the keyring held.
+	require.NoError(t, os.Remove(file))
+	_, err = ring.Reload()
+	require.Error(t, err)
+
+	key, err = ring.SigningKey(KeyTierSession)
+	require.NoError(t, err)
+	require.Equal(t, []byte("second-secret"), key.Secret)
+}
+
+// A configured secret and a keyring directory combine, which is how a
+// deployment moves onto rotation: tokens signed with the secret keep verifying
+// while the directory's key signs.
+func TestConfiguredSecretVerifiesAlongsideKeyringDir(t *testing.T) {
+	dir := t.TempDir()
+
+	config := SigningKeysConfig{RunKey: "configured-run-key", KeyringDir: dir}
+	ring, err := config.Keyring()
+	require.NoError(t, err)
+
+	before := runCapability(t, ring)
+	require.Equal(t, staticKeyID([]byte("configured-run-key")), tokenKID(t, before))
+
+	rotated, err := RotateSigningKey(dir, KeyTierRun, 0, time.Hour)
+	require.NoError(t, err)
+
+	_, err = ring.Reload()
+	require.NoError(t, err)
+
+	require.Equal(t, rotated.ID, tokenKID(t, runCapability(t, ring)))
+	require.NoError(t, verifyRunCapability(ring, before))
+}
+
+// A tier with no key in a configured keyring directory is given one written
+// there, not one held in memory: it survives a restart and another replica
+// reading the same directory verifies it.
+func TestKeyringDirBootstrapsAnEmptyTier(t *testing.T) {
+	dir := t.TempDir()
+
+	ring, err := SigningKeysConfig{KeyringDir: dir}.Keyring()
+	require.NoError(t, err)
+
+	written, err := ReadKeyringDir(dir)
+	require.NoError(t, err)
+	for _, tier := range KeyTiers {
+		require.Len(t, written[tier], 1, "tier %s", tier)
+	}
+
+	token := runCapability(t, ring)
+	require.Equal(t, written[KeyTierRun][0].ID, tokenKID(t, token))
+
+	restarted, err := SigningKeysConfig{KeyringDir: dir}.Keyring()
+	require.NoError(t, err)
+	require.NoError(t, verifyRunCapability(restarted, token))
+
+	again, err := ReadKeyringDir(dir)
+	require.NoError(t, err)
+	require.Len(t, again[KeyTierRun], 1, "a tier that has a key is left alone")
+}
+
+func TestKeyConfiguredTwiceIsRefused(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "run.key")
+	require.NoError(t, os.WriteFile(file, []byte("secret"), 0o600))
+
+	_, err := SigningKeysConfig{RunKey: "secret", RunKeyFile: file}.Keyring()
+	require.Error(t, err)
+}
@@ -55,6 +55,17 @@ const (
 	LabelWorkerCapPrefix     = LabelsPrefix + "capability."
 	LabelWorkerCapProbPrefix = LabelWorkerCapPrefix + "prob."
 
+	// A worker's slot limits: how many runs it executes at once overall, and of
+	// each prob kind it limits separately. Advertised so that an operator
+	// looking at the fleet can see why a runner with idle workers is not taking
+	// puppeteer runs.
+	LabelWorkerCapConcurrency       = LabelWorkerCapPrefix + "concurrency"
+	LabelWorkerCapConcurrencyPrefix = LabelWorkerCapConcurrency + "."
+
+	// A worker that pulls its runner's high-priority queue as well as the
+	// normal one. See HighPriorityQueueSupport.
+	LabelWorkerCapHighPriorityQueue = LabelWorkerCapPrefix + "queue.high"
+
 	// Well-known worker labels:
 	LabelWorkerOS           = LabelsPrefix + "worker.os"
 	LabelWorkerArch         = LabelsPrefix + "worker.arch"
@@ -91,6 +102,44 @@ const (
 	// Values are label-grammar slugs, not sentences. See ReasonNoExecutionSnapshot.
 	LabelResultUnschedulable = LabelsPrefix + "result.unschedulable"
 
+	// LabelResultAnomaly marks a run whose measurements were out of line with
+	// its scenario's history, though not necessarily failed. The only value is
+	// AnomalySlow; see LatencyDetector.
+	LabelResultAnomaly = LabelsPrefix + "result.anomaly"
+
+	// LabelResultDelivery marks a run whose outcome reached the server after
+	// its upload grace, from a worker's spool. The only value is DeliveryLate.
+	//
+	// Worth a label because such a run was, for a while, reported as something
+	// else -- expired, usually -- and anything that acted on that in the
+	// meantime is what an operator will want to find.
+	LabelResultDelivery = LabelsPrefix + "result.delivery"
+
+	// LabelResultMaintenance names the maintenance window a run was created
+	// inside. Such a run is left out of every verdict on its target's health;
+	// see MaintenanceWindow. A label as well as ResultStatus.Maintenance, so
+	// that "everything the Sunday window covered" is a query like any other.
+	LabelResultMaintenance = LabelsPrefix + "result.maintenance"
+
+	// LabelResultRateLimit names the rate limit that kept a run from being
+	// admitted; see RateLimit. A run a limit only deferred at claim time is not
+	// labelled -- it ran, later, and nothing about its result is the limit's.
+	LabelResultRateLimit = LabelsPrefix + "result.rate-limit"
+
+	// LabelResultUpstreamFailed names the dependency that was failing when a
+	// run failed: the run's failure is a consequence, and that scenario's the
+	// cause. See ScenarioDependency. A label as well as
+	// ResultStatus.UpstreamFailed, so that "everything the gateway outage took
+	// down with it" is a query like any other.
+	LabelResultUpstreamFailed = LabelsPrefix + "result.upstream-failed"
+
+	// LabelResultTrigger and LabelResultPriority record what asked for a run
+	// and the queue it was dispatched on, as RunTrigger and RunPriority. Labels
+	// so that "how long did manual runs wait during the outage" can select the
+	// runs it is about.
+	LabelResultTrigger  = LabelsPrefix + "result.trigger"
+	LabelResultPriority = LabelsPrefix + "result.priority"
+
 	LabelResultMessageID = "run.messageId"
 
 	// LabelRetryOfResult and LabelRetryOfFailure mark a run created by retrying a
@@ -151,4 +200,36 @@ const (
 	// Separated from ReasonNoEligibleRunner because the remedy is different: no
 	// number of runners fixes it, the scenario has to be edited.
 	ReasonInvalidRequirements = "invalid-requirements"
+
+	// ReasonConcurrencyForbidden marks a run that was not started because an
+	// earlier run of its scenario was still in flight and the scenario's
+	// ConcurrencyPolicy is Forbid.
+	//
+	// Not a fault, unlike the reasons above: it is the policy working. It is
+	// recorded all the same so a skipped trigger shows up in the run history
+	// instead of as a gap in it.
+	ReasonConcurrencyForbidden = "concurrency-forbidden"
+
+	// ReasonMaintenance marks a run that was not started because a maintenance
+	// window in Skip mode covered it. Like ReasonConcurrencyForbidden, it is
+	// planned rather than a fault; LabelResultMaintenance names the window.
+	ReasonMaintenance = "maintenance-window"
+
+	// ReasonRateLimited marks a run that was not started because admitting it
+	// would have broken a RateLimit on its target. Planned, like the two above;
+	// LabelResultRateLimit names the limit.
+	ReasonRateLimited = "rate-limited"
+
+	// ReasonNoOnlineCapacity marks a run that was not queued because its
+	// scenario's OnlineCapacityPolicy is require-online and the runner it was
+	// placed on had no worker online that could run it.
+	//
+	// A fault, unlike the three above, though not necessarily the fleet's: it
+	// may be a runner down for the night that this scenario should not use.
+	ReasonNoOnlineCapacity = "no-online-capacity"
+
+	// ReasonClaimDeadlinePassed marks a run that was queued under the
+	// fail-fast-after policy and that nothing claimed within the scenario's
+	// OnlineCapacityAfter. The run is expired rather than errored: it waited.
+	ReasonClaimDeadlinePassed = "claim-deadline-passed"
 )
@@ -0,0 +1,373 @@
+package urth
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// Latency anomalies: a check that passes in nine seconds where it used to pass
+// in one is telling you something, and a success/failure verdict throws that
+// away. Every successful run is compared with a baseline of the runs before it,
+// from the same scenario and the same runner, and one that is out of line is
+// labelled so that "every slow run this week" is a label search.
+//
+// The baseline is per runner because latency is. A check run from the far side
+// of an ocean is slower than the same check run next door, every time, and one
+// baseline for both would call every run from the far side slow.
+//
+// Two estimates are kept and a run must exceed both. The moving average and its
+// deviation follow a shift in normal quickly; a percentile of the recent window
+// does not assume durations are bell-shaped, which they are not -- a check that
+// usually hits a cache and sometimes misses has two normals, and sigma alone
+// would flag every miss.
+
+// AnomalySlow is the LabelResultAnomaly value of a run that took anomalously long.
+const AnomalySlow = "slow"
+
+const (
+	// DefaultLatencyAlpha weighs the newest run in the moving average. A tenth
+	// means the baseline mostly reflects the last twenty or so runs.
+	DefaultLatencyAlpha = 0.1
+
+	// DefaultLatencyWindow is how many recent durations the percentile is taken
+	// over.
+	DefaultLatencyWindow = 100
+
+	// DefaultLatencyDeviations is how many standard deviations above the moving
+	// average a run must be to count as slow.
+	DefaultLatencyDeviations = 3.0
+
+	// DefaultLatencyPercentile is the percentile of the recent window a run must
+	// also exceed.
+	DefaultLatencyPercentile = 99.0
+
+	// DefaultLatencyMinSamples is how many runs a baseline needs before anything
+	// is judged against it. Fewer, and the first slow run of a new scenario is
+	// most of its own baseline.
+	DefaultLatencyMinSamples = 20
+)
+
+// LatencyPolicy is a scenario's say in how its runs are judged. Unset fields
+// follow the server's defaults; see LatencyConfig.
+type LatencyPolicy struct {
+	// Disabled opts the scenario out: its runs are neither judged nor recorded.
+	// For a check whose duration is meaningless -- one that waits on purpose.
+	Disabled bool `form:"disabled" json:"disabled,omitempty" yaml:"disabled,omitempty" xml:"disabled,omitempty"`
+
+	// Deviations is how many standard deviations above the moving average a run
+	// must be to count as slow.
+	Deviations float64 `form:"deviations" json:"deviations,omitempty" yaml:"deviations,omitempty" xml:"deviations,omitempty"`
+
+	// Percentile is the percentile of recent runs a slow run must also exceed.
+	Percentile float64 `form:"percentile" json:"percentile,omitempty" yaml:"percentile,omitempty" xml:"percentile,omitempty"`
+
+	// MinSamples is how many runs the baseline needs before a run is judged.
+	MinSamples int `form:"minSamples" json:"minSamples,omitempty" yaml:"minSamples,omitempty" xml:"minSamples,omitempty"`
+
+	// Degrade records a slow run's verdict as degraded rather than success.
+	//
+	// Off by default, because it changes what the run reports. A degraded run
+	// still counts as up, in availability and in probe_success, but anything
+	// selecting on result=success stops seeing it. A scenario whose latency is
+	// its contract wants exactly that; most only want the label.
+	Degrade bool `form:"degrade" json:"degrade,omitempty" yaml:"degrade,omitempty" xml:"degrade,omitempty"`
+}
+
+// Validate refuses a policy that cannot be applied.
+func (p LatencyPolicy) Validate() error {
+	if p.Deviations < 0 {
+		return fmt.Errorf("latency deviations must not be negative, got %v", p.Deviations)
+	}
+	if p.Percentile < 0 || p.Percentile >= 100 {
+		return fmt.Errorf("latency percentile must be between 0 and 100, got %v", p.Percentile)
+	}
+	if p.MinSamples < 0 {
+		return fmt.Errorf("latency min samples must not be negative, got %v", p.MinSamples)
+	}
+
+	return nil
+}
+
+// validateLatencyPolicy refuses a scenario whose latency policy is nonsense.
+func validateLatencyPolicy(policy *LatencyPolicy) error {
+	if policy == nil {
+		return nil
+	}
+
+	if err := policy.Validate(); err != nil {
+		return fmt.Errorf("scenario's latency policy is invalid: %w", err)
+	}
+
+	return nil
+}
+
+// LatencyConfig is what an operator can set about latency baselines. The
+// thresholds are defaults a scenario's LatencyPolicy may override; the shape of
+// the baseline is not, because every scenario's is kept the same way.
+type LatencyConfig struct {
+	Enabled    bool    `help:"Label successful runs that took anomalously long against their scenario's history" default:"true" negatable:""`
+	Alpha      float64 `help:"Weight of the newest run in the moving average; smaller adapts more slowly" default:"0.1"`
+	Window     int     `help:"How many recent durations the percentile is taken over" default:"100"`
+	Deviations float64 `help:"Standard deviations above the moving average a run must be to count as slow" default:"3"`
+	Percentile float64 `help:"Percentile of recent runs a slow run must also exceed" default:"99"`
+	MinSamples int     `help:"Runs a baseline needs before anything is judged against it" default:"20"`
+}
+
+// LatencyBaseline is what the runs of one scenario from one runner have taken.
+//
+// Internal plumbing rather than a manifest resource, like ResultRollup and for
+// the same reasons, and keyed the same way: by scenario UID, so that a renamed
+// scenario keeps its history. Durations are in seconds.
+type LatencyBaseline struct {
+	ID uint `gorm:"primaryKey;autoIncrement"`
+
+	ScenarioID manifest.ResourceID `gorm:"not null;uniqueIndex:idx_latency_baselines_key,priority:1"`
+	RunnerID   manifest.ResourceID `gorm:"not null;uniqueIndex:idx_latency_baselines_key,priority:2"`
+
+	// Samples is how many runs have been observed, ever.
+	Samples uint64 `gorm:"not null;default:0"`
+
+	// Mean and Variance are exponentially weighted.
+	Mean     float64 `gorm:"not null;default:0"`
+	Variance float64 `gorm:"not null;default:0"`
+
+	// Recent is the last window of durations, oldest first.
+	Recent []float64 `gorm:"serializer:json"`
+
+	UpdatedAt time.Time
+}
+
+// TableName keeps the table out of gorm's pluralisation guesswork.
+func (LatencyBaseline) TableName() string {
+	return "latency_baselines"
+}
+
+// Observe folds one duration into the baseline.
+//
+// The variance is updated incrementally, as in Finch's "Incremental calculation
+// of weighted mean and variance", so the baseline never needs the history it
+// summarises.
+func (b *LatencyBaseline) Observe(seconds, alpha float64, window int) {
+	if b.Samples == 0 {
+		b.Mean, b.Variance = seconds, 0
+	} else {
+		diff := seconds - b.Mean
+		increment := alpha * diff
+		b.Mean += increment
+		b.Variance = (1 - alpha) * (b.Variance + diff*increment)
+	}
+	b.Samples++
+
+	b.Recent = append(b.Recent, seconds)
+	if window > 0 && len(b.Recent) > window {
+		b.Recent = slices.Clone(b.Recent[len(b.Recent)-window:])
+	}
+}
+
+// Threshold is the duration, in seconds, above which a run is slow.
+func (b LatencyBaseline) Threshold(deviations, percentile float64) float64 {
+	return max(b.Mean+deviations*math.Sqrt(b.Variance), percentileOf(b.Recent, percentile))
+}
+
+// percentileOf is the nearest-rank percentile of values.
+func percentileOf(values []float64, percentile float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+
+	sorted := slices.Clone(values)
+	slices.Sort(sorted)
+
+	rank := int(math.Ceil(percentile / 100 * float64(len(sorted))))
+	return sorted[min(max(rank, 1), len(sorted))-1]
+}
+
+// LatencyBaselineStore keeps the baselines runs are judged against.
+type LatencyBaselineStore interface {
+	// LatencyBaseline reads a baseline, reporting whether one exists yet.
+	LatencyBaseline(ctx context.Context, scenarioID, runnerID manifest.ResourceID) (LatencyBaseline, bool, error)
+
+	// UpdateLatencyBaseline applies update to a baseline, creating it first if
+	// needed. Two updates to the same baseline never interleave: concurrent
+	// completions from one runner are the normal case, not a race to lose.
+	UpdateLatencyBaseline(ctx context.Context, scenarioID, runnerID manifest.ResourceID, update func(*LatencyBaseline)) error
+}
+
+// LatencyVerdict is how a run's duration compares with its baseline.
+type LatencyVerdict struct {
+	// Slow is whether the run took anomalously long.
+	Slow bool
+
+	// Duration is how long the run took, and Threshold how long it could have
+	// taken without being slow. Threshold is zero when the run was not judged.
+	Duration  time.Duration
+	Threshold time.Duration
+
+	// Degrade is whether the scenario wants a slow run's verdict degraded.
+	Degrade bool
+}
+
+// LatencyDetector judges finished runs against their baselines and keeps the
+// baselines up to date.
+type LatencyDetector struct {
+	store LatencyBaselineStore
+
+	alpha      float64
+	window     int
+	deviations float64
+	percentile float64
+	minSamples int
+}
+
+// LatencyDetectorOption configures a LatencyDetector.
+type LatencyDetectorOption func(*LatencyDetector)
+
+// WithLatencyAlpha sets the weight of the newest run in the moving average.
+func WithLatencyAlpha(alpha float64) LatencyDetectorOption {
+	return func(d *LatencyDetector) {
+		if alpha > 0 && alpha <= 1 {
+			d.alpha = alpha
+		}
+	}
+}
+
+// WithLatencyWindow sets how many recent durations the percentile is taken over.
+func WithLatencyWindow(window int) LatencyDetectorOption {
+	return func(d *LatencyDetector) {
+		if window > 0 {
+			d.window = window
+		}
+	}
+}
+
+// WithLatencyDeviations sets the default number of standard deviations a slow
+// run is above the moving average.
+func WithLatencyDeviations(deviations float64) LatencyDetectorOption {
+	return func(d *LatencyDetector) {
+		if deviations > 0 {
+			d.deviations = deviations
+		}
+	}
+}
+
+// WithLatencyPercentile sets the default percentile a slow run must exceed.
+func WithLatencyPercentile(percentile float64) LatencyDetectorOption {
+	return func(d *LatencyDetector) {
+		if percentile > 0 && percentile < 100 {
+			d.percentile = percentile
+		}
+	}
+}
+
+// WithLatencyMinSamples sets the default number of runs a baseline needs
+// before anything is judged against it.
+func WithLatencyMinSamples(n int) LatencyDetectorOption {
+	return func(d *LatencyDetector) {
+		if n > 0 {
+			d.minSamples = n
+		}
+	}
+}
+
+// NewLatencyDetector returns a detector keeping its baselines in store.
+func NewLatencyDetector(store LatencyBaselineStore, options ...LatencyDetectorOption) *LatencyDetector {
+	d := &LatencyDetector{
+		store:      store,
+		alpha:      DefaultLatencyAlpha,
+		window:     DefaultLatencyWindow,
+		deviations: DefaultLatencyDeviations,
+		percentile: DefaultLatencyPercentile,
+		minSamples: DefaultLatencyMinSamples,
+	}
+
+	for _, option := range options {
+		option(d)
+	}
+
+	return d
+}
+
+// Judge compares a finished run with the baseline of the runs before it.
+//
+// Only successful runs are judged. A failure's duration says how long it took
+// to fail, which is not latency, and a timeout is already as slow as a run gets.
+func (d *LatencyDetector) Judge(ctx context.Context, run Result, policy *LatencyPolicy) (LatencyVerdict, error) {
+	duration, ok := latencyOf(run)
+	if !ok || run.Status.Result != prob.RunFinishedSuccess || (policy != nil && policy.Disabled) {
+		return LatencyVerdict{Duration: duration}, nil
+	}
+
+	baseline, exists, err := d.store.LatencyBaseline(ctx, run.Spec.ScenarioID, run.Status.Executor.RunnerID)
+	if err != nil {
+		return LatencyVerdict{Duration: duration}, fmt.Errorf("failed to read the latency baseline of %q: %w", run.Name, err)
+	}
+
+	deviations, percentile, minSamples := d.deviations, d.percentile, d.minSamples
+	degrade := false
+	if policy != nil {
+		if policy.Deviations > 0 {
+			deviations = policy.Deviations
+		}
+		if policy.Percentile > 0 {
+			percentile = policy.Percentile
+		}
+		if policy.MinSamples > 0 {
+			minSamples = policy.MinSamples
+		}
+		degrade = policy.Degrade
+	}
+
+	if !exists || baseline.Samples < uint64(minSamples) {
+		return LatencyVerdict{Duration: duration}, nil
+	}
+
+	threshold := time.Duration(baseline.Threshold(deviations, percentile) * float64(time.Second))
+
+	return LatencyVerdict{
+		Slow:      duration > threshold,
+		Duration:  duration,
+		Threshold: threshold,
+		Degrade:   degrade,
+	}, nil
+}
+
+// Observe folds a finished run into its baseline. A slow run is folded in like
+// any other: if it is the new normal, the baseline should learn that.
+func (d *LatencyDetector) Observe(ctx context.Context, run Result, policy *LatencyPolicy) error {
+	duration, ok := latencyOf(run)
+	if !ok || (policy != nil && policy.Disabled) {
+		return nil
+	}
+
+	switch run.Status.Result {
+	case prob.RunFinishedSuccess, prob.RunFinishedDegraded:
+	default:
+		return nil
+	}
+
+	err := d.store.UpdateLatencyBaseline(ctx, run.Spec.ScenarioID, run.Status.Executor.RunnerID, func(b *LatencyBaseline) {
+		b.Observe(duration.Seconds(), d.alpha, d.window)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update the latency baseline of %q: %w", run.Name, err)
+	}
+
+	return nil
+}
+
+// latencyOf is how long a run took, if it says, and if it can be attributed to
+// a runner: a baseline with no runner would mix every vantage point.
+func latencyOf(run Result) (time.Duration, bool) {
+	started, ended := run.Spec.TimeStarted, run.Spec.TimeEnded
+	if started == nil || ended == nil || run.Status.Executor.RunnerID == "" {
+		return 0, false
+	}
+
+	return max(ended.Sub(*started), 0), true
+}
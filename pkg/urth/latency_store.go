@@ -0,0 +1,66 @@
+package urth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/sre-norns/wyrd/pkg/manifest"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// latencyBaselineStore keeps baselines in the latency_baselines table.
+type latencyBaselineStore struct {
+	db *gorm.DB
+}
+
+// NewLatencyBaselineStore returns the baseline store backed by an existing gorm
+// connection.
+func NewLatencyBaselineStore(db *gorm.DB) LatencyBaselineStore {
+	return &latencyBaselineStore{db: db}
+}
+
+// LatencyBaseline implements LatencyBaselineStore.
+func (s *latencyBaselineStore) LatencyBaseline(ctx context.Context, scenarioID, runnerID manifest.ResourceID) (LatencyBaseline, bool, error) {
+	var baseline LatencyBaseline
+
+	err := s.db.WithContext(ctx).
+		Where("scenario_id = ? AND runner_id = ?", scenarioID, runnerID).
+		Take(&baseline).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return baseline, false, nil
+	} else if err != nil {
+		return baseline, false, err
+	}
+
+	return baseline, true, nil
+}
+
+// UpdateLatencyBaseline implements LatencyBaselineStore.
+//
+// The row is created if missing and then locked, rather than read and created if
+// missing: two first runs finishing together would otherwise both find nothing,
+// and one of them would fail on the unique key.
+func (s *latencyBaselineStore) UpdateLatencyBaseline(ctx context.Context, scenarioID, runnerID manifest.ResourceID, update func(*LatencyBaseline)) error {
+	return s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		seed := LatencyBaseline{ScenarioID: scenarioID, RunnerID: runnerID}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&seed).Error; err != nil {
+			return fmt.Errorf("failed to create latency baseline: %w", err)
+		}
+
+		query := tx.Where("scenario_id = ? AND runner_id = ?", scenarioID, runnerID)
+		if s.db.Dialector.Name() == "postgres" {
+			query = query.Clauses(clause.Locking{Strength: "UPDATE"})
+		}
+
+		var baseline LatencyBaseline
+		if err := query.Take(&baseline).Error; err != nil {
+			return fmt.Errorf("failed to lock latency baseline: %w", err)
+		}
+
+		update(&baseline)
+
+		return tx.Save(&baseline).Error
+	})
+}
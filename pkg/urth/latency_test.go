@@ -0,0 +1,202 @@
+package urth_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/urth/pkg/urth"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// What is asserted here is the promise the label makes to whoever searches on
+// it: a run labelled slow was out of line with its own history, and a run that
+// was merely on the slow side of normal is not.
+
+type latencyKey struct {
+	scenario, runner manifest.ResourceID
+}
+
+type fakeLatencyBaselines map[latencyKey]urth.LatencyBaseline
+
+func (s fakeLatencyBaselines) LatencyBaseline(_ context.Context, scenarioID, runnerID manifest.ResourceID) (urth.LatencyBaseline, bool, error) {
+	baseline, ok := s[latencyKey{scenarioID, runnerID}]
+	return baseline, ok, nil
+}
+
+func (s fakeLatencyBaselines) UpdateLatencyBaseline(_ context.Context, scenarioID, runnerID manifest.ResourceID, update func(*urth.LatencyBaseline)) error {
+	key := latencyKey{scenarioID, runnerID}
+	baseline := s[key]
+	update(&baseline)
+	s[key] = baseline
+
+	return nil
+}
+
+func timedRun(runner string, outcome prob.RunStatus, took time.Duration) urth.Result {
+	started := time.Date(2024, time.March, 4, 12, 0, 0, 0, time.UTC)
+	ended := started.Add(took)
+
+	return urth.Result{
+		ObjectMeta: manifest.ObjectMeta{Name: "run"},
+		Spec:       urth.ResultSpec{ScenarioID: "checkout", TimeStarted: &started, TimeEnded: &ended},
+		Status: urth.ResultStatus{
+			Status:   urth.JobCompleted,
+			Result:   outcome,
+			Executor: urth.ExecutorRef{RunnerID: manifest.ResourceID(runner)},
+		},
+	}
+}
+
+// observeRuns feeds a detector a history of successful runs from one runner.
+func observeRuns(t *testing.T, detector *urth.LatencyDetector, runner string, durations ...time.Duration) {
+	t.Helper()
+
+	for _, took := range durations {
+		require.NoError(t, detector.Observe(context.Background(), timedRun(runner, prob.RunFinishedSuccess, took), nil))
+	}
+}
+
+// jittered is n runs around a typical duration, a few percent either way.
+func jittered(n int, typical time.Duration) []time.Duration {
+	durations := make([]time.Duration, 0, n)
+	for i := range n {
+		durations = append(durations, typical+time.Duration(i%5-2)*typical/50)
+	}
+
+	return durations
+}
+
+func TestLatencyDetectorFlagsARunOutOfLineWithItsRunner(t *testing.T) {
+	ctx := context.Background()
+	detector := urth.NewLatencyDetector(fakeLatencyBaselines{})
+
+	observeRuns(t, detector, "eu", jittered(40, time.Second)...)
+	observeRuns(t, detector, "ap", jittered(40, 4*time.Second)...)
+
+	verdict, err := detector.Judge(ctx, timedRun("eu", prob.RunFinishedSuccess, 1050*time.Millisecond), nil)
+	require.NoError(t, err)
+	require.False(t, verdict.Slow, "the slow side of normal is still normal")
+
+	verdict, err = detector.Judge(ctx, timedRun("eu", prob.RunFinishedSuccess, 3*time.Second), nil)
+	require.NoError(t, err)
+	require.True(t, verdict.Slow)
+	require.Greater(t, verdict.Threshold, time.Second)
+	require.Less(t, verdict.Threshold, 3*time.Second)
+
+	// The same duration from a runner that always takes that long is not slow.
+	verdict, err = detector.Judge(ctx, timedRun("ap", prob.RunFinishedSuccess, 4*time.Second), nil)
+	require.NoError(t, err)
+	require.False(t, verdict.Slow)
+
+	// A failure's duration is how long it took to fail, which is not latency.
+	verdict, err = detector.Judge(ctx, timedRun("eu", prob.RunFinishedFailed, 30*time.Second), nil)
+	require.NoError(t, err)
+	require.False(t, verdict.Slow)
+}
+
+func TestLatencyDetectorWaitsForEnoughHistory(t *testing.T) {
+	ctx := context.Background()
+	detector := urth.NewLatencyDetector(fakeLatencyBaselines{})
+
+	observeRuns(t, detector, "eu", jittered(urth.DefaultLatencyMinSamples-1, time.Second)...)
+
+	verdict, err := detector.Judge(ctx, timedRun("eu", prob.RunFinishedSuccess, 10*time.Second), nil)
+	require.NoError(t, err)
+	require.False(t, verdict.Slow)
+	require.Zero(t, verdict.Threshold, "not judged at all")
+
+	// A scenario may ask for less.
+	verdict, err = detector.Judge(ctx, timedRun("eu", prob.RunFinishedSuccess, 10*time.Second), &urth.LatencyPolicy{MinSamples: 5, Degrade: true})
+	require.NoError(t, err)
+	require.True(t, verdict.Slow)
+	require.True(t, verdict.Degrade)
+}
+
+// A check that usually hits a cache and sometimes misses has two normals. The
+// moving average alone would call every miss slow; the percentile knows better.
+func TestLatencyDetectorToleratesATwoModeCheck(t *testing.T) {
+	ctx := context.Background()
+	detector := urth.NewLatencyDetector(fakeLatencyBaselines{})
+
+	for i := range 100 {
+		took := 100 * time.Millisecond
+		if i%10 == 9 {
+			took = time.Second
+		}
+		observeRuns(t, detector, "eu", took)
+	}
+
+	verdict, err := detector.Judge(ctx, timedRun("eu", prob.RunFinishedSuccess, time.Second), nil)
+	require.NoError(t, err)
+	require.False(t, verdict.Slow, "a cache miss is one of this check's normals")
+
+	verdict, err = detector.Judge(ctx, timedRun("eu", prob.RunFinishedSuccess, 5*time.Second), nil)
+	require.NoError(t, err)
+	require.True(t, verdict.Slow)
+}
+
+func TestLatencyDetectorSkipsOptedOutScenarios(t *testing.T) {
+	ctx := context.Background()
+	baselines := fakeLatencyBaselines{}
+	detector := urth.NewLatencyDetector(baselines)
+	disabled := &urth.LatencyPolicy{Disabled: true}
+
+	require.NoError(t, detector.Observe(ctx, timedRun("eu", prob.RunFinishedSuccess, time.Second), disabled))
+	require.Empty(t, baselines)
+
+	observeRuns(t, detector, "eu", jittered(40, time.Second)...)
+	verdict, err := detector.Judge(ctx, timedRun("eu", prob.RunFinishedSuccess, time.Minute), disabled)
+	require.NoError(t, err)
+	require.False(t, verdict.Slow)
+}
+
+func TestLatencyBaselineKeepsABoundedWindow(t *testing.T) {
+	var baseline urth.LatencyBaseline
+	for i := range 10 {
+		baseline.Observe(float64(i), 0.5, 4)
+	}
+
+	require.EqualValues(t, 10, baseline.Samples)
+	require.Equal(t, []float64{6, 7, 8, 9}, baseline.Recent)
+	require.Greater(t, baseline.Mean, 7.0, "the average follows the newest runs")
+}
+
+func TestLatencyPolicyValidate(t *testing.T) {
+	require.NoError(t, urth.LatencyPolicy{}.Validate())
+	require.NoError(t, urth.LatencyPolicy{Deviations: 2, Percentile: 95, MinSamples: 10}.Validate())
+	require.Error(t, urth.LatencyPolicy{Deviations: -1}.Validate())
+	require.Error(t, urth.LatencyPolicy{Percentile: 100}.Validate())
+	require.Error(t, urth.LatencyPolicy{MinSamples: -1}.Validate())
+}
+
+// The store against the real schema: the first update creates the row, later
+// ones find it.
+func TestLatencyBaselineStoreUpdatesInPlace(t *testing.T) {
+	_, db, _ := newTestService(t, &stubScheduler{})
+	ctx := context.Background()
+	store := urth.NewLatencyBaselineStore(db)
+
+	_, exists, err := store.LatencyBaseline(ctx, "checkout", "eu")
+	require.NoError(t, err)
+	require.False(t, exists)
+
+	for _, seconds := range []float64{1, 2, 3} {
+		require.NoError(t, store.UpdateLatencyBaseline(ctx, "checkout", "eu", func(b *urth.LatencyBaseline) {
+			b.Observe(seconds, urth.DefaultLatencyAlpha, urth.DefaultLatencyWindow)
+		}))
+	}
+
+	baseline, exists, err := store.LatencyBaseline(ctx, "checkout", "eu")
+	require.NoError(t, err)
+	require.True(t, exists)
+	require.EqualValues(t, 3, baseline.Samples)
+	require.Equal(t, []float64{1, 2, 3}, baseline.Recent)
+
+	var rows int64
+	require.NoError(t, db.Model(&urth.LatencyBaseline{}).Count(&rows).Error)
+	require.EqualValues(t, 1, rows)
+}
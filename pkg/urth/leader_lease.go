@@ -0,0 +1,41 @@
+package urth
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// LeaseStore takes and gives up named control-loop leases.
+//
+// The reconciler and the retention sweep each take one per scan, through their
+// own stores. This is the same row and the same takeover rule offered to a host
+// that elects one process to run a loop at all -- a controller-manager deployed
+// as several replicas -- so that "who holds it" is answered by the one table an
+// operator already watches, however the lease is used.
+type LeaseStore interface {
+	// AcquireLease takes the named lease, or renews it for the holder that
+	// already has it, reporting false when another holder's lease is live.
+	AcquireLease(ctx context.Context, name, holder string, lease time.Duration) (bool, error)
+
+	// ReleaseLease gives the named lease up early, if holder still holds it.
+	ReleaseLease(ctx context.Context, name, holder string) error
+}
+
+type leaseStore struct {
+	db *gorm.DB
+}
+
+// NewLeaseStore returns the lease table of an existing database.
+func NewLeaseStore(db *gorm.DB) LeaseStore {
+	return &leaseStore{db: db}
+}
+
+func (s *leaseStore) AcquireLease(ctx context.Context, name, holder string, lease time.Duration) (bool, error) {
+	return acquireLease(ctx, s.db, name, holder, lease)
+}
+
+func (s *leaseStore) ReleaseLease(ctx context.Context, name, holder string) error {
+	return releaseLease(ctx, s.db, name, holder)
+}
@@ -0,0 +1,394 @@
+package urth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/adhocore/gronx"
+	"github.com/sre-norns/wyrd/pkg/dbstore"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// Maintenance windows: planned downtime, declared ahead of time, so that the
+// checks of a target being worked on stop paging people about it.
+//
+// A window selects scenarios, runners or both by label, and is either one-off --
+// a start and a duration -- or recurring on a cron schedule, the way a weekly
+// patching slot is. A run created while a window covering it is open is labelled
+// with the window's name in LabelResultMaintenance, and then either skipped,
+// recorded as such without being dispatched, or run as usual and kept out of
+// every verdict about the target's health: availability, the probe_success a
+// scrape reports, the series pushed by remote write and the latency baseline.
+//
+// Consulted where every run begins, resultsAPIImpl.Create, for the reason the
+// concurrency policy is: a scheduled trigger and a manual one are held to the
+// same rule, and there is no second place a run can come from. It is consulted
+// after placement, because a window may select the runner a run is going to --
+// "eu-west's egress is being replaced" -- as well as the scenario.
+//
+// Decided once, when the run is created, and never revisited. A run created just
+// before a window opens is not in maintenance, however long it then takes; a
+// window deleted after the fact does not bring its runs back into the verdicts.
+// The label is the record of what was decided, and history that rewrote itself
+// whenever a window was edited would not be a record of anything.
+
+// KindMaintenanceWindow is the resource kind for maintenance windows.
+const KindMaintenanceWindow manifest.Kind = "maintenanceWindows"
+
+// MaintenanceMode says what a window does to the runs it covers.
+type MaintenanceMode string
+
+const (
+	// MaintenanceSkip records a covered run as skipped, without dispatching it.
+	// It is the default: a window is usually declared because the target is
+	// known to be down, and probing it only to discard the answer is load on
+	// something already being worked on.
+	MaintenanceSkip MaintenanceMode = "Skip"
+
+	// MaintenanceMark runs covered runs as usual and keeps them out of the
+	// verdicts. For the maintenance whose effect is itself worth watching: the
+	// run history still shows when the target went down and came back.
+	MaintenanceMark MaintenanceMode = "Mark"
+)
+
+// Validate refuses a mode this server does not know, for the reason
+// ConcurrencyPolicy.Validate gives.
+func (m MaintenanceMode) Validate() error {
+	switch m {
+	case "", MaintenanceSkip, MaintenanceMark:
+		return nil
+	default:
+		return fmt.Errorf("unknown maintenance mode %q: expected %s or %s", m, MaintenanceSkip, MaintenanceMark)
+	}
+}
+
+// skips reports whether a covered run is recorded rather than executed.
+func (m MaintenanceMode) skips() bool {
+	return m != MaintenanceMark
+}
+
+// MaintenanceWindowSpec is when a window is open and what it covers.
+type MaintenanceWindowSpec struct {
+	// Description is a human readable text to say what the maintenance is for.
+	Description string `form:"description" json:"description,omitempty" yaml:"description,omitempty" xml:"description,omitempty"`
+
+	// Start is when the window opens. For a recurring window it is when the
+	// recurrence begins, and may be left out to have it begin at once.
+	Start *time.Time `form:"start" json:"start,omitempty" yaml:"start,omitempty" xml:"start,omitempty" gorm:"type:TIMESTAMPTZ NULL"`
+
+	// Schedule makes the window recurring: it opens at every tick of this cron
+	// expression from Start on. Empty for a one-off window.
+	Schedule CronSchedule `form:"schedule" json:"schedule,omitempty" yaml:"schedule,omitempty" xml:"schedule,omitempty"`
+
+	// TimeZone is the IANA zone Schedule is read in. "Sundays at 02:00" means
+	// 02:00 somewhere, and that is rarely UTC. Defaults to UTC.
+	TimeZone string `form:"timeZone" json:"timeZone,omitempty" yaml:"timeZone,omitempty" xml:"timeZone,omitempty"`
+
+	// Duration is how long the window stays open each time it opens.
+	Duration time.Duration `form:"duration" json:"duration" yaml:"duration" xml:"duration"`
+
+	// Scenarios selects the scenarios the window covers. Empty covers all of
+	// them.
+	Scenarios manifest.LabelSelector `form:"scenarios" json:"scenarios,omitempty" yaml:"scenarios,omitempty" xml:"scenarios,omitempty" gorm:"serializer:json"`
+
+	// Runners selects the runners the window covers: a run is covered only if
+	// it was placed on one of them. Empty covers every runner, and runs that
+	// could not be placed at all.
+	Runners manifest.LabelSelector `form:"runners" json:"runners,omitempty" yaml:"runners,omitempty" xml:"runners,omitempty" gorm:"serializer:json"`
+
+	// Mode is what the window does to the runs it covers: Skip (the default) or
+	// Mark. See MaintenanceMode.
+	Mode MaintenanceMode `form:"mode" json:"mode,omitempty" yaml:"mode,omitempty" xml:"mode,omitempty"`
+}
+
+// MaintenanceWindowStatus is the window as of the moment it was read. Nothing
+// here is stored.
+type MaintenanceWindowStatus struct {
+	// Open is true while the window is in force.
+	Open bool `json:"open" yaml:"open" gorm:"-"`
+
+	// OpenedAt and ClosesAt bound the current opening, when the window is open.
+	OpenedAt *time.Time `json:"openedAt,omitempty" yaml:"openedAt,omitempty" gorm:"-"`
+	ClosesAt *time.Time `json:"closesAt,omitempty" yaml:"closesAt,omitempty" gorm:"-"`
+
+	// NextOpening is when the window next opens, if it ever will again.
+	NextOpening *time.Time `json:"nextOpening,omitempty" yaml:"nextOpening,omitempty" gorm:"-"`
+}
+
+// MaintenanceWindow is a period of planned maintenance.
+type MaintenanceWindow manifest.StatefulResource[MaintenanceWindowSpec, MaintenanceWindowStatus]
+
+// ToManifest renders the resource for the API.
+func (r MaintenanceWindow) ToManifest() manifest.ResourceManifest {
+	return manifest.ToManifestWithStatus(manifest.StatefulResource[MaintenanceWindowSpec, MaintenanceWindowStatus](r))
+}
+
+// NewMaintenanceWindow converts a manifest into the model.
+func NewMaintenanceWindow(m manifest.ResourceManifest) (MaintenanceWindow, error) {
+	e, err := manifest.ManifestAsStatefulResource[MaintenanceWindowSpec, MaintenanceWindowStatus](m)
+	entry := MaintenanceWindow(e)
+	if err != nil {
+		return entry, fmt.Errorf("failed to convert resource manifest into a MaintenanceWindow model: %w", err)
+	}
+
+	if entry.ObjectMeta.Name == "" {
+		return entry, ErrResourceNameEmpty
+	}
+
+	if err := entry.ObjectMeta.Validate(); err != nil {
+		return entry, err
+	}
+
+	return entry, nil
+}
+
+// start is when the window first opens; the zero time for a recurring window
+// that begins at once.
+func (s MaintenanceWindowSpec) start() time.Time {
+	if s.Start == nil {
+		return time.Time{}
+	}
+
+	return *s.Start
+}
+
+// location is the zone the schedule is read in.
+func (s MaintenanceWindowSpec) location() (*time.Location, error) {
+	if s.TimeZone == "" {
+		return time.UTC, nil
+	}
+
+	return time.LoadLocation(s.TimeZone)
+}
+
+// Validate refuses a window that can be stored but would never do what its
+// manifest says.
+func (s MaintenanceWindowSpec) Validate() error {
+	if s.Duration <= 0 {
+		return fmt.Errorf("maintenance window duration must be positive, got %v", s.Duration)
+	}
+
+	if s.Schedule == "" && s.Start == nil {
+		return fmt.Errorf("a one-off maintenance window needs a start")
+	}
+
+	if s.Schedule != "" && !gronx.IsValid(string(s.Schedule)) {
+		return fmt.Errorf("maintenance window schedule %q is not a valid cron expression", s.Schedule)
+	}
+
+	if _, err := s.location(); err != nil {
+		return fmt.Errorf("maintenance window time zone %q is unknown: %w", s.TimeZone, err)
+	}
+
+	if _, err := maintenanceSelector(s.Scenarios); err != nil {
+		return fmt.Errorf("maintenance window's scenario selector is invalid: %w", err)
+	}
+
+	if _, err := maintenanceSelector(s.Runners); err != nil {
+		return fmt.Errorf("maintenance window's runner selector is invalid: %w", err)
+	}
+
+	return s.Mode.Validate()
+}
+
+// OpenAt reports the opening of the window in force at `at`, if there is one.
+//
+// For a recurring window that is the latest tick at or before `at`: windows
+// longer than their schedule's interval overlap, and the latest opening is the
+// one that closes last.
+func (s MaintenanceWindowSpec) OpenAt(at time.Time) (opened time.Time, open bool) {
+	start := s.start()
+	if s.Schedule == "" {
+		return start, s.Start != nil && !at.Before(start) && at.Before(start.Add(s.Duration))
+	}
+
+	location, err := s.location()
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	tick, err := gronx.PrevTickBefore(string(s.Schedule), at.In(location), true)
+	if err != nil || tick.Before(start) {
+		return time.Time{}, false
+	}
+
+	return tick, at.Before(tick.Add(s.Duration))
+}
+
+// NextOpening reports when the window next opens after `at`.
+func (s MaintenanceWindowSpec) NextOpening(at time.Time) (time.Time, bool) {
+	start := s.start()
+	if s.Schedule == "" {
+		return start, s.Start != nil && at.Before(start)
+	}
+
+	location, err := s.location()
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	// A recurrence that has not begun yet opens at its first tick from Start,
+	// which may be Start itself.
+	from, inclusive := at, false
+	if from.Before(start) {
+		from, inclusive = start, true
+	}
+
+	tick, err := gronx.NextTickAfter(string(s.Schedule), from.In(location), inclusive)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return tick, true
+}
+
+// withStatus fills in the computed status as of `at`.
+func (r MaintenanceWindow) withStatus(at time.Time) MaintenanceWindow {
+	r.Status = MaintenanceWindowStatus{}
+
+	if opened, open := r.Spec.OpenAt(at); open {
+		closes := opened.Add(r.Spec.Duration)
+		r.Status.Open = true
+		r.Status.OpenedAt = &opened
+		r.Status.ClosesAt = &closes
+	}
+
+	if next, ok := r.Spec.NextOpening(at); ok {
+		r.Status.NextOpening = &next
+	}
+
+	return r
+}
+
+// maintenanceSelector parses one of a window's selectors.
+func maintenanceSelector(selector manifest.LabelSelector) (manifest.Selector, error) {
+	return manifest.ParseSelector(selector.AsLabels())
+}
+
+// windowSnapshot is the maintenance windows table as coveringWindow judges it:
+// every window, in the order it is consulted, with its selectors parsed.
+type windowSnapshot struct {
+	windows []windowCandidate
+}
+
+type windowCandidate struct {
+	window MaintenanceWindow
+
+	// scenarioSelector and runnerSelector are the window's selectors, parsed;
+	// an empty one selects everything. A window whose selectors do not parse
+	// covers nothing, and is left out of the snapshot.
+	scenarioSelector manifest.Selector
+	runnerSelector   manifest.Selector
+
+	// scenarios is the window's covered-scenario set. Whether the runner
+	// selector matches is judged per run, against the runner the run was
+	// placed on.
+	scenarios *scenarioMemo[bool]
+}
+
+// coversScenario judges the scenario half of whether the window applies.
+func (c *windowCandidate) coversScenario(scenario Scenario) bool {
+	return c.scenarioSelector.Empty() || c.scenarioSelector.Matches(scenario.Labels)
+}
+
+// covers reports whether the window applies to a run of `scenario` placed on
+// `runner`, which is nil for a run that could not be placed.
+func (c *windowCandidate) covers(scenario Scenario, runner *Runner) bool {
+	if !c.scenarios.get(scenario, c.coversScenario) {
+		return false
+	}
+	if c.runnerSelector.Empty() {
+		return true
+	}
+
+	return runner != nil && c.runnerSelector.Matches(runner.Labels)
+}
+
+// loadWindowSnapshot reads every window, in the order coveringWindow consults
+// them: a skipping window before a marking one -- the stronger instruction is
+// the one somebody meant -- and ties to the first by name, so a run is labelled
+// the same way every time.
+func loadWindowSnapshot(ctx context.Context, store dbstore.TransactionalStore) (windowSnapshot, error) {
+	var windows []MaintenanceWindow
+	if _, err := store.Find(ctx, &windows, manifest.SearchQuery{}); err != nil {
+		return windowSnapshot{}, fmt.Errorf("failed to list maintenance windows: %w", err)
+	}
+
+	slices.SortFunc(windows, func(x, y MaintenanceWindow) int {
+		if x.Spec.Mode.skips() != y.Spec.Mode.skips() {
+			if x.Spec.Mode.skips() {
+				return -1
+			}
+			return 1
+		}
+		return strings.Compare(string(x.Name), string(y.Name))
+	})
+
+	snapshot := windowSnapshot{windows: make([]windowCandidate, 0, len(windows))}
+	for _, window := range windows {
+		scenarios, err := maintenanceSelector(window.Spec.Scenarios)
+		if err != nil {
+			continue
+		}
+		runners, err := maintenanceSelector(window.Spec.Runners)
+		if err != nil {
+			continue
+		}
+
+		snapshot.windows = append(snapshot.windows, windowCandidate{
+			window:           window,
+			scenarioSelector: scenarios,
+			runnerSelector:   runners,
+			scenarios:        newScenarioMemo[bool](),
+		})
+	}
+
+	return snapshot, nil
+}
+
+// coveringWindow finds the open window, if any, that covers a run of
+// `scenario` placed on `runner`.
+//
+// Every window is judged here rather than in a query: whether a cron window is
+// open is not something SQL can answer, and a fleet has a handful of windows,
+// not thousands. They are read from the service's policy cache rather than on
+// every run; see policyCaches.
+func coveringWindow(ctx context.Context, store dbstore.TransactionalStore, cache *policyCache[windowSnapshot], scenario Scenario, runner *Runner, at time.Time) (*MaintenanceWindow, error) {
+	snapshot, err := cache.get(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range snapshot.windows {
+		candidate := &snapshot.windows[i]
+		if _, open := candidate.window.Spec.OpenAt(at); !open {
+			continue
+		}
+		if candidate.covers(scenario, runner) {
+			window := candidate.window
+			return &window, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// applyMaintenance records a covering window on a run about to be created, and
+// skips the run if the window says to.
+//
+// Only a pending run is skipped. One placement already refused is terminal for
+// a reason of its own, and that reason is the more useful thing to keep; it is
+// labelled all the same, so it is kept out of the verdicts with the rest.
+func applyMaintenance(result *Result, window MaintenanceWindow, at time.Time) {
+	result.Status.Maintenance = window.Name
+	putLabel(result.Labels, LabelResultMaintenance, string(window.Name))
+
+	if window.Spec.Mode.skips() && result.Status.Status == JobPending {
+		log.Printf("run %q of %q skipped: maintenance window %q is open", result.Name, result.Spec.Scenario.Name, window.Name)
+		skipRun(result, ReasonMaintenance, at)
+	}
+}
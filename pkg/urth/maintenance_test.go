@@ -0,0 +1,177 @@
+package urth_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/urth/pkg/urth"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+var maintenanceEpoch = time.Date(2024, time.March, 3, 0, 0, 0, 0, time.UTC) // a Sunday
+
+func TestOneOffMaintenanceWindowIsOpenForItsDuration(t *testing.T) {
+	start := maintenanceEpoch.Add(2 * time.Hour)
+	spec := urth.MaintenanceWindowSpec{Start: &start, Duration: time.Hour}
+
+	_, open := spec.OpenAt(start.Add(-time.Second))
+	require.False(t, open, "not before it starts")
+
+	opened, open := spec.OpenAt(start)
+	require.True(t, open)
+	require.Equal(t, start, opened)
+
+	_, open = spec.OpenAt(start.Add(59 * time.Minute))
+	require.True(t, open)
+
+	_, open = spec.OpenAt(start.Add(time.Hour))
+	require.False(t, open, "closed once its duration is up")
+
+	next, ok := spec.NextOpening(start.Add(-time.Minute))
+	require.True(t, ok)
+	require.Equal(t, start, next)
+
+	_, ok = spec.NextOpening(start.Add(time.Minute))
+	require.False(t, ok, "a one-off window does not open again")
+}
+
+// "Sundays from 02:00 for two hours, Berlin time": the schedule is read in its
+// zone, and the recurrence does not begin before Start.
+func TestRecurringMaintenanceWindowOpensOnItsSchedule(t *testing.T) {
+	berlin, err := time.LoadLocation("Europe/Berlin")
+	require.NoError(t, err)
+
+	spec := urth.MaintenanceWindowSpec{
+		Schedule: "0 2 * * 0",
+		TimeZone: "Europe/Berlin",
+		Duration: 2 * time.Hour,
+	}
+	require.NoError(t, spec.Validate())
+
+	sunday := time.Date(2024, time.March, 3, 2, 0, 0, 0, berlin)
+
+	opened, open := spec.OpenAt(sunday.Add(90 * time.Minute))
+	require.True(t, open)
+	require.True(t, sunday.Equal(opened))
+
+	_, open = spec.OpenAt(sunday.Add(2 * time.Hour))
+	require.False(t, open)
+
+	_, open = spec.OpenAt(sunday.Add(24 * time.Hour))
+	require.False(t, open, "Monday is not in the window")
+
+	next, ok := spec.NextOpening(sunday.Add(time.Hour))
+	require.True(t, ok)
+	require.True(t, sunday.AddDate(0, 0, 7).Equal(next))
+
+	// A recurrence that begins next week leaves this Sunday alone.
+	later := sunday.AddDate(0, 0, 1)
+	spec.Start = &later
+
+	_, open = spec.OpenAt(sunday.Add(time.Hour))
+	require.False(t, open)
+
+	next, ok = spec.NextOpening(sunday.Add(time.Hour))
+	require.True(t, ok)
+	require.True(t, sunday.AddDate(0, 0, 7).Equal(next))
+}
+
+func TestMaintenanceWindowIsValidated(t *testing.T) {
+	start := maintenanceEpoch
+
+	require.NoError(t, urth.MaintenanceWindowSpec{Start: &start, Duration: time.Hour}.Validate())
+
+	for name, spec := range map[string]urth.MaintenanceWindowSpec{
+		"no duration":       {Start: &start},
+		"one-off, no start": {Duration: time.Hour},
+		"bad schedule":      {Schedule: "every sunday", Duration: time.Hour},
+		"unknown zone":      {Schedule: "0 2 * * 0", TimeZone: "Mars/Olympus", Duration: time.Hour},
+		"mode in lowercase": {Start: &start, Duration: time.Hour, Mode: "skip"},
+	} {
+		require.Error(t, spec.Validate(), name)
+	}
+}
+
+// declareWindow applies a window that is open now and covers every scenario.
+func declareWindow(t *testing.T, srv urth.Service, name manifest.ResourceName, mode urth.MaintenanceMode) {
+	t.Helper()
+
+	start := time.Now().Add(-time.Minute)
+	_, err := srv.MaintenanceWindows().Create(context.Background(), manifest.ResourceManifest{
+		TypeMeta: manifest.TypeMeta{Kind: urth.KindMaintenanceWindow},
+		Metadata: manifest.ObjectMeta{Name: name},
+		Spec: &urth.MaintenanceWindowSpec{
+			Start:    &start,
+			Duration: time.Hour,
+			Mode:     mode,
+		},
+	})
+	require.NoError(t, err)
+}
+
+// A run triggered inside a Skip window is recorded, named after the window, and
+// never dispatched.
+func TestSkipWindowRecordsARunWithoutDispatchingIt(t *testing.T) {
+	srv, _, db, store := cancellingService(t)
+	scenarioName := seedScenario(t, store)
+	declareWindow(t, srv, "db-upgrade", urth.MaintenanceSkip)
+
+	run, err := srv.Results(scenarioName).Create(context.Background(), newRunRequest())
+	require.NoError(t, err)
+
+	skipped := loadResult(t, store, run.UID)
+	require.Equal(t, urth.JobCompleted, skipped.Status.Status)
+	require.Equal(t, prob.RunFinishedCanceled, skipped.Status.Result)
+	require.Equal(t, manifest.ResourceName("db-upgrade"), skipped.Status.Maintenance)
+	require.Equal(t, "db-upgrade", skipped.Labels[urth.LabelResultMaintenance])
+	require.Equal(t, urth.ReasonMaintenance, skipped.Labels[urth.LabelResultUnschedulable])
+	require.Zero(t, countOutbox(t, db))
+}
+
+// Under Mark the run goes ahead as usual and carries the window's name.
+func TestMarkWindowRunsButLabelsTheRun(t *testing.T) {
+	srv, _, db, store := cancellingService(t)
+	scenarioName := seedScenario(t, store)
+	declareWindow(t, srv, "failover-drill", urth.MaintenanceMark)
+
+	run, err := srv.Results(scenarioName).Create(context.Background(), newRunRequest())
+	require.NoError(t, err)
+
+	marked := loadResult(t, store, run.UID)
+	require.Equal(t, urth.JobPending, marked.Status.Status)
+	require.Equal(t, manifest.ResourceName("failover-drill"), marked.Status.Maintenance)
+	require.Equal(t, "failover-drill", marked.Labels[urth.LabelResultMaintenance])
+	require.EqualValues(t, 1, countOutbox(t, db))
+}
+
+// Windows are cached between runs, but a window declared or removed through the
+// service applies to the very next run.
+func TestWindowChangesApplyToTheNextRun(t *testing.T) {
+	srv, _, _, store := cancellingService(t)
+	scenarioName := seedScenario(t, store)
+
+	before, err := srv.Results(scenarioName).Create(context.Background(), newRunRequest())
+	require.NoError(t, err)
+	require.Empty(t, loadResult(t, store, before.UID).Status.Maintenance)
+
+	declareWindow(t, srv, "network-cutover", urth.MaintenanceMark)
+
+	during, err := srv.Results(scenarioName).Create(context.Background(), newRunRequest())
+	require.NoError(t, err)
+	require.Equal(t, manifest.ResourceName("network-cutover"), loadResult(t, store, during.UID).Status.Maintenance)
+
+	window, ok, err := srv.MaintenanceWindows().Get(context.Background(), "network-cutover")
+	require.NoError(t, err)
+	require.True(t, ok)
+	deleted, err := srv.MaintenanceWindows().Delete(context.Background(), manifest.NewVersionedID(window.Metadata.UID, window.Metadata.Version))
+	require.NoError(t, err)
+	require.True(t, deleted)
+
+	after, err := srv.Results(scenarioName).Create(context.Background(), newRunRequest())
+	require.NoError(t, err)
+	require.Empty(t, loadResult(t, store, after.UID).Status.Maintenance)
+}
@@ -0,0 +1,227 @@
+package urth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/wyrd/pkg/dbstore"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// Capacity ranks runners but never refuses a run. A run placed on a fleet whose
+// workers are all offline is queued on the runner's durable channel and waits
+// for them, which ADR 0004 requires and which is right for most checks: the
+// run happens late rather than not at all.
+//
+// It is wrong for a check whose answer is only worth anything now. A login
+// probe that runs an hour after it was scheduled, because that is when the
+// runner's workers came back, reports on a site as it was an hour later than
+// its timestamp says, and its verdict lands in the run history as though it
+// were on time. An operator would rather have a run that says "nothing could
+// take this" than one that says "passed" about the wrong hour.
+//
+// So a scenario may say what it wants when nothing can take its run promptly.
+// The choice is the scenario's, not the fleet's: the same runner serves checks
+// that should wait and checks that should not.
+
+// OnlineCapacityPolicy says what a new run of a scenario does when no worker
+// able to take it is online.
+type OnlineCapacityPolicy string
+
+const (
+	// OnlineCapacityQueue queues the run regardless, and it executes whenever a
+	// worker comes back. It is the default, and what every scenario did before
+	// there was a policy.
+	OnlineCapacityQueue OnlineCapacityPolicy = "queue"
+
+	// OnlineCapacityRequireOnline records the run terminal when it is created,
+	// with ReasonNoOnlineCapacity, unless the runner it is placed on has a
+	// worker online that can run it.
+	OnlineCapacityRequireOnline OnlineCapacityPolicy = "require-online"
+
+	// OnlineCapacityFailFastAfter queues the run, but only for as long as the
+	// scenario's OnlineCapacityAfter while nothing can take it. A run nothing
+	// has claimed by then is recorded expired, with ReasonClaimDeadlinePassed,
+	// and never executes -- unless its runner has a worker online that can run
+	// it, in which case the run is waiting behind other work, not for a fleet
+	// to come back, and it keeps its place. See hasOnlineCapacity.
+	OnlineCapacityFailFastAfter OnlineCapacityPolicy = "fail-fast-after"
+)
+
+// Effective returns the policy that applies, reading empty as the default.
+func (p OnlineCapacityPolicy) Effective() OnlineCapacityPolicy {
+	if p == "" {
+		return OnlineCapacityQueue
+	}
+
+	return p
+}
+
+// Validate refuses a policy this server does not know.
+//
+// Refused rather than read as queue, for the reason ConcurrencyPolicy.Validate
+// gives: a scenario whose manifest says "require-online" in the wrong spelling
+// would otherwise queue, which is exactly what it was written to prevent.
+func (p OnlineCapacityPolicy) Validate() error {
+	switch p {
+	case "", OnlineCapacityQueue, OnlineCapacityRequireOnline, OnlineCapacityFailFastAfter:
+		return nil
+	default:
+		return fmt.Errorf("unknown online capacity policy %q: expected %s, %s or %s",
+			p, OnlineCapacityQueue, OnlineCapacityRequireOnline, OnlineCapacityFailFastAfter)
+	}
+}
+
+// validateOnlineCapacity refuses a policy whose duration is missing or
+// meaningless.
+//
+// A duration under any other policy is refused rather than ignored, since it
+// is most likely a scenario that meant fail-fast-after and forgot to say so.
+func validateOnlineCapacity(policy OnlineCapacityPolicy, after time.Duration) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	switch {
+	case policy == OnlineCapacityFailFastAfter && after <= 0:
+		return fmt.Errorf("online capacity policy %s needs a positive onlineCapacityAfter, got %v", policy, after)
+	case policy != OnlineCapacityFailFastAfter && after != 0:
+		return fmt.Errorf("onlineCapacityAfter is only meaningful with the %s policy, not %s",
+			OnlineCapacityFailFastAfter, policy.Effective())
+	}
+
+	return nil
+}
+
+// OnlineWorkers counts the workers of a runner that are online and able to
+// run kind, which is what require-online asks about.
+//
+// Placement measures this already when it has a choice to make. It does not
+// for a sole candidate, or when the measurement failed, and those are the
+// cases this is for.
+func (p placement) OnlineWorkers(ctx context.Context, runner Runner, kind prob.Kind) (int, error) {
+	capacity, err := p.workerCapacity(ctx, []Runner{runner}, kind)
+	if err != nil {
+		return 0, err
+	}
+
+	return capacity[runner.UID].OnlineWorkers, nil
+}
+
+// hasOnlineCapacity reports whether the runner a pending run was placed on has
+// a worker online that can run it, which is what stands between a run past its
+// claim deadline and its expiry.
+//
+// The deadline is about the fleet, not the queue. A scenario that asked to
+// fail fast asked not to be run an hour late because no worker was there; a
+// run whose runner is online and merely busy will be claimed as soon as a slot
+// frees, which is the ordinary wait of every run, and expiring it would throw
+// away the checks of a fleet that is working.
+func (p placement) hasOnlineCapacity(ctx context.Context, run Result) (bool, error) {
+	online, err := p.onlineWorkersOf(ctx, RunnerKind{RunnerUID: run.Status.Executor.RunnerID, Kind: run.Spec.ProbKind})
+	return online > 0, err
+}
+
+// onlineWorkersOf is OnlineWorkers for a runner known by UID. A runner deleted
+// since has none.
+func (p placement) onlineWorkersOf(ctx context.Context, of RunnerKind) (int, error) {
+	var runner Runner
+	if found, err := p.store.GetByUID(ctx, &runner, of.RunnerUID); err != nil {
+		return 0, fmt.Errorf("failed to load runner %v: %w", of.RunnerUID, err)
+	} else if !found {
+		return 0, nil
+	}
+
+	online, err := p.OnlineWorkers(ctx, runner, of.Kind)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count the online workers of runner %q: %w", runner.Name, err)
+	}
+
+	return online, nil
+}
+
+// requireOnline records a run terminal when the runner it was placed on has no
+// worker online to take it.
+//
+// Asked of the chosen runner rather than the whole fleet, which comes to the
+// same thing: placement only chooses a runner with nobody online when nobody
+// is online anywhere it could have chosen.
+func (m *resultsAPIImpl) requireOnline(ctx context.Context, entry *Result, decision placementDecision, at time.Time) error {
+	online := decision.Capacity.OnlineWorkers
+	if decision.Regime == PlacementSoleCandidate || decision.Regime == PlacementUnmeasured {
+		counted, err := m.placement.OnlineWorkers(ctx, decision.Runner, entry.Spec.ProbKind)
+		if err != nil {
+			return fmt.Errorf("failed to count the online workers of runner %q: %w", decision.Runner.Name, err)
+		}
+		online = counted
+	}
+
+	if online > 0 {
+		return nil
+	}
+
+	log.Printf("run %q of %q failed: runner %q has no worker online and the scenario requires one",
+		entry.Name, entry.Spec.Scenario.Name, decision.Runner.Name)
+	failRun(entry, ReasonNoOnlineCapacity, at)
+
+	return nil
+}
+
+// claimDeadlinePassed reports whether a pending run has waited longer than its
+// scenario allowed. Whether it is expired for it is hasOnlineCapacity's to say.
+func claimDeadlinePassed(result Result, now time.Time) bool {
+	return result.Status.Status == JobPending &&
+		result.Status.ClaimBy != nil && now.After(*result.Status.ClaimBy)
+}
+
+// missClaimDeadline records a run that nothing claimed in time.
+//
+// `expired` rather than `errored`, unlike a run refused at creation: the run
+// was queued, waited and ran out of time, which is what an expired run is. The
+// label says whose time it was.
+func missClaimDeadline(result *Result, at time.Time) {
+	labelMissedClaim(result)
+	expireResult(result, at)
+}
+
+// labelMissedClaim records why a run is about to expire, for a caller whose
+// store does the expiring.
+func labelMissedClaim(result *Result) {
+	result.Labels = manifest.MergeLabels(result.Labels, manifest.Labels{
+		LabelResultUnschedulable: ReasonClaimDeadlinePassed,
+	})
+}
+
+// expireUnclaimed settles a run that reached a claim after its deadline.
+//
+// Version-guarded, as markUnschedulable is: if anything moved the run on in
+// the meantime, that is newer than this decision and stands. The reconciler
+// settles the runs that no claim reaches; this one is here because a worker
+// asking for it is proof it would otherwise execute, late.
+func (m *resultsAPIImpl) expireUnclaimed(ctx context.Context, entry Result, now time.Time) {
+	missClaimDeadline(&entry, now)
+
+	if ok, err := m.store.Update(ctx, &entry, entry.UID, dbstore.WithVersion(entry.Version)); err != nil {
+		log.Printf("failed to expire run %q past its claim deadline: %v", entry.Name, err)
+	} else if !ok {
+		log.Printf("run %q changed while being expired past its claim deadline", entry.Name)
+	}
+}
+
+// applyOnlineCapacity reports the scenario's policy on a preview, and what it
+// would do to a run created now.
+//
+// Only require-online changes the verdict. fail-fast-after still queues; whether
+// the run is claimed in time is not something a preview can know.
+func (preview *PlacementPreview) applyOnlineCapacity(spec ScenarioSpec) {
+	preview.OnlineCapacity = spec.OnlineCapacity.Effective()
+	preview.OnlineCapacityAfter = spec.OnlineCapacityAfter
+
+	if preview.Schedulable && preview.OnlineCapacity == OnlineCapacityRequireOnline && preview.OnlineWorkers == 0 {
+		preview.Schedulable = false
+		preview.Reason = ReasonNoOnlineCapacity
+	}
+}
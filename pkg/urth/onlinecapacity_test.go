@@ -0,0 +1,79 @@
+package urth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestUnknownOnlineCapacityPolicyIsRefused(t *testing.T) {
+	for _, policy := range []OnlineCapacityPolicy{"", OnlineCapacityQueue, OnlineCapacityRequireOnline, OnlineCapacityFailFastAfter} {
+		require.NoError(t, policy.Validate(), "policy %q", policy)
+	}
+
+	require.Error(t, OnlineCapacityPolicy("Require-Online").Validate())
+	require.Equal(t, OnlineCapacityQueue, OnlineCapacityPolicy("").Effective())
+}
+
+// The duration belongs to fail-fast-after and to nothing else: required there,
+// refused elsewhere as the likely sign of a policy that was forgotten.
+func TestOnlineCapacityAfterIsOnlyForFailFastAfter(t *testing.T) {
+	require.NoError(t, validateOnlineCapacity(OnlineCapacityFailFastAfter, 5*time.Minute))
+	require.Error(t, validateOnlineCapacity(OnlineCapacityFailFastAfter, 0))
+	require.Error(t, validateOnlineCapacity(OnlineCapacityFailFastAfter, -time.Minute))
+
+	require.NoError(t, validateOnlineCapacity("", 0))
+	require.Error(t, validateOnlineCapacity("", 5*time.Minute))
+	require.Error(t, validateOnlineCapacity(OnlineCapacityRequireOnline, 5*time.Minute))
+}
+
+// Only require-online changes a preview's verdict, and only when nothing is
+// online. fail-fast-after is reported but still schedulable: whether a run would
+// be claimed in time is not a question a preview can answer.
+func TestPreviewAppliesTheOnlineCapacityPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		spec        ScenarioSpec
+		online      int
+		schedulable bool
+		reason      string
+	}{
+		{name: "default queues", spec: ScenarioSpec{}, schedulable: true},
+		{name: "require-online, nobody online", spec: ScenarioSpec{OnlineCapacity: OnlineCapacityRequireOnline},
+			reason: ReasonNoOnlineCapacity},
+		{name: "require-online, someone online", spec: ScenarioSpec{OnlineCapacity: OnlineCapacityRequireOnline},
+			online: 1, schedulable: true},
+		{name: "fail-fast-after, nobody online", spec: ScenarioSpec{OnlineCapacity: OnlineCapacityFailFastAfter,
+			OnlineCapacityAfter: time.Minute}, schedulable: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			preview := PlacementPreview{EligibleRunners: 1, OnlineWorkers: tc.online, Schedulable: true}
+			preview.applyOnlineCapacity(tc.spec)
+
+			require.Equal(t, tc.spec.OnlineCapacity.Effective(), preview.OnlineCapacity)
+			require.Equal(t, tc.spec.OnlineCapacityAfter, preview.OnlineCapacityAfter)
+			require.Equal(t, tc.schedulable, preview.Schedulable)
+			require.Equal(t, tc.reason, preview.Reason)
+		})
+	}
+
+	// A reason placement already gave is not overwritten by this one.
+	preview := PlacementPreview{Reason: ReasonNoEligibleRunner}
+	preview.applyOnlineCapacity(ScenarioSpec{OnlineCapacity: OnlineCapacityRequireOnline})
+	require.Equal(t, ReasonNoEligibleRunner, preview.Reason)
+}
+
+func TestClaimDeadlineOnlyAppliesToPendingRuns(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Second)
+
+	pending := Result{Status: ResultStatus{Status: JobPending, ClaimBy: &past}}
+	require.True(t, claimDeadlinePassed(pending, now))
+
+	running := Result{Status: ResultStatus{Status: JobRunning, ClaimBy: &past}}
+	require.False(t, claimDeadlinePassed(running, now), "a run claimed in time answers to its lease")
+
+	require.False(t, claimDeadlinePassed(Result{Status: ResultStatus{Status: JobPending}}, now),
+		"a run without a deadline waits as long as it must")
+}
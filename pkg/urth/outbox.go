@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"time"
 
+	"github.com/sre-norns/urth/pkg/prob"
 	"github.com/sre-norns/wyrd/pkg/manifest"
 )
 
@@ -70,6 +71,18 @@ type DispatchOutboxEntry struct {
 	// entitled to reject them. See resultsAPIImpl.placeRun.
 	RunnerUID manifest.ResourceID `gorm:"index"`
 
+	// ProbKind is the kind of prob the run will execute, carried to the worker
+	// so it can decline a run it has no room for before claiming it. A hint for
+	// scheduling, not a disclosure: the kind says which prober, never what it
+	// will be asked to do. Empty on entries written before it existed, which a
+	// worker treats as "no kind-specific limit applies".
+	ProbKind prob.Kind
+
+	// Priority is the runner queue the dispatch goes on. See RunPriority. Empty
+	// on entries written before it existed, which go on the normal queue they
+	// would have gone on anyway.
+	Priority RunPriority
+
 	// Time columns carry no explicit `type:` tag. Postgres must store these as
 	// TIMESTAMPTZ -- a naive TIMESTAMP reads back shifted by the server's offset,
 	// which this project has already been bitten by once -- and gorm's Postgres
@@ -153,6 +166,8 @@ func NewDispatchOutboxEntry(result Result, now time.Time) DispatchOutboxEntry {
 		// snapshot names the scenario revision this dispatch is actually for.
 		ScenarioName: result.Spec.Execution.ScenarioName,
 		RunnerUID:    result.Status.Executor.RunnerID,
+		ProbKind:     result.Spec.ProbKind,
+		Priority:     result.Spec.Priority,
 		NotBefore:    now,
 	}
 }
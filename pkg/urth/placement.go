@@ -7,8 +7,10 @@ import (
 	"math"
 	"math/rand/v2"
 	"sort"
+	"strings"
 	"time"
 
+	"github.com/sre-norns/urth/pkg/prob"
 	"github.com/sre-norns/wyrd/pkg/dbstore"
 	"github.com/sre-norns/wyrd/pkg/manifest"
 )
@@ -124,7 +126,7 @@ const (
 // only decides which queue the run is put in.
 type RunnerCapacity struct {
 	// OnlineWorkers is workers that are reachable on *both* liveness signals and
-	// not paused.
+	// neither paused nor draining.
 	//
 	// Strictly WorkerConditionOnline, which is where the two-signal design earns
 	// its keep: a worker that cannot reach the API server cannot claim what it
@@ -137,8 +139,8 @@ type RunnerCapacity struct {
 	// Used only as the fallback weight when no runner has anything online.
 	RegisteredWorkers int
 
-	// ReadyWorkers is registered and not paused, saying nothing about whether the
-	// worker can still be reached.
+	// ReadyWorkers is registered, not paused and not draining, saying nothing
+	// about whether the worker can still be reached.
 	//
 	// It exists because that is what PlacementPreview.ReadyWorkers has always
 	// meant, and narrowing an existing field to the stricter definition would
@@ -209,7 +211,8 @@ type PlacementPreview struct {
 	// runner.
 	RegisteredWorkers int `json:"registeredWorkers" yaml:"registeredWorkers"`
 
-	// ReadyWorkers is how many of those are not paused, and so may claim work.
+	// ReadyWorkers is how many of those are neither paused nor draining, and so
+	// may take work.
 	//
 	// Says nothing about whether they can still be reached: a worker whose
 	// process died is registered and unpaused until its registration is dropped.
@@ -242,6 +245,13 @@ type PlacementPreview struct {
 	// Detail is human-readable context -- currently a selector parse error.
 	// Nothing branches on it.
 	Detail string `json:"detail,omitempty" yaml:"detail,omitempty"`
+
+	// OnlineCapacity is the scenario's online capacity policy, with the default
+	// spelled out, and OnlineCapacityAfter its wait under fail-fast-after. Under
+	// require-online, a fleet with no worker online is reported as not
+	// schedulable, with ReasonNoOnlineCapacity.
+	OnlineCapacity      OnlineCapacityPolicy `json:"onlineCapacity,omitempty" yaml:"onlineCapacity,omitempty"`
+	OnlineCapacityAfter time.Duration        `json:"onlineCapacityAfter,omitempty" yaml:"onlineCapacityAfter,omitempty"`
 }
 
 // selectorFor parses a resource's placement requirements.
@@ -259,8 +269,9 @@ func selectorFor(requirements manifest.LabelSelector) (manifest.Selector, error)
 
 // candidates lists the runners a selector matches, and the eligible subset.
 //
-// Only an active runner is eligible: dispatching to a disabled one would queue
-// work that, by the claim rules, no worker of that runner may take.
+// Only an active runner is eligible: dispatching to a disabled one, or to one
+// being deleted, would queue work that, by the claim rules, no worker of that
+// runner may take.
 func (p placement) candidates(ctx context.Context, requirements manifest.LabelSelector) (matching, eligible []Runner, err error) {
 	selector, err := selectorFor(requirements)
 	if err != nil {
@@ -272,7 +283,7 @@ func (p placement) candidates(ctx context.Context, requirements manifest.LabelSe
 	}
 
 	for _, runner := range matching {
-		if runner.Spec.IsActive {
+		if runner.acceptsWork() {
 			eligible = append(eligible, runner)
 		}
 	}
@@ -287,7 +298,10 @@ func (p placement) candidates(ctx context.Context, requirements manifest.LabelSe
 // there were, and then threw the list away -- every job went to one shared queue
 // and any worker could take it, so a scenario that declared it needed a runner
 // inside a particular network had no way of getting one.
-func (p placement) Place(ctx context.Context, requirements manifest.LabelSelector, scenarioName manifest.ResourceName) (placementDecision, error) {
+//
+// kind is the prob kind of the run, and narrows the workers counted as capacity
+// to those able to run it. Empty counts every worker, as before kinds were known.
+func (p placement) Place(ctx context.Context, requirements manifest.LabelSelector, kind prob.Kind, scenarioName manifest.ResourceName) (placementDecision, error) {
 	matching, eligible, err := p.candidates(ctx, requirements)
 	if err != nil {
 		// A selector that does not parse is a property of the scenario, not a
@@ -310,7 +324,7 @@ func (p placement) Place(ctx context.Context, requirements manifest.LabelSelecto
 	// for a given source.
 	sort.Slice(eligible, func(i, j int) bool { return eligible[i].UID < eligible[j].UID })
 
-	selected, capacity, regime := p.choose(ctx, eligible)
+	selected, capacity, regime := p.choose(ctx, eligible, kind)
 	p.countDecision(regime)
 
 	log.Printf("placed run of %q on runner %q by %s (%d of %d eligible; online=%d queued=%d running=%d spare=%d pressure=%.2f)",
@@ -332,7 +346,7 @@ func (p placement) Place(ctx context.Context, requirements manifest.LabelSelecto
 // about not sending every run to the same one, which is what sorting by UID and
 // taking the first did: a runner won because of its identifier, and kept winning
 // however far behind it fell.
-func (p placement) choose(ctx context.Context, eligible []Runner) (Runner, RunnerCapacity, PlacementRegime) {
+func (p placement) choose(ctx context.Context, eligible []Runner, kind prob.Kind) (Runner, RunnerCapacity, PlacementRegime) {
 	// One candidate is not a decision, and measuring capacity to confirm that
 	// would put two queries on the run-creation path of every deployment with a
 	// single runner -- which is most of them.
@@ -340,7 +354,7 @@ func (p placement) choose(ctx context.Context, eligible []Runner) (Runner, Runne
 		return eligible[0], RunnerCapacity{}, PlacementSoleCandidate
 	}
 
-	capacity, err := p.capacityOf(ctx, eligible)
+	capacity, err := p.capacityOf(ctx, eligible, kind)
 	if err != nil {
 		// Degraded, never fatal. A count that could not be read is not a reason
 		// to refuse a run: fall back to the behaviour that predates this, which
@@ -354,8 +368,8 @@ func (p placement) choose(ctx context.Context, eligible []Runner) (Runner, Runne
 }
 
 // capacityOf assembles the capacity picture for a set of candidate runners.
-func (p placement) capacityOf(ctx context.Context, eligible []Runner) (map[manifest.ResourceID]RunnerCapacity, error) {
-	capacity, err := p.workerCapacity(ctx, eligible)
+func (p placement) capacityOf(ctx context.Context, eligible []Runner, kind prob.Kind) (map[manifest.ResourceID]RunnerCapacity, error) {
+	capacity, err := p.workerCapacity(ctx, eligible, kind)
 	if err != nil {
 		return nil, err
 	}
@@ -472,7 +486,7 @@ func (p placement) selectRunner(eligible []Runner, capacity map[manifest.Resourc
 }
 
 // Preview reports what Place would decide, without creating anything.
-func (p placement) Preview(ctx context.Context, requirements manifest.LabelSelector) (PlacementPreview, error) {
+func (p placement) Preview(ctx context.Context, requirements manifest.LabelSelector, kind prob.Kind) (PlacementPreview, error) {
 	preview := PlacementPreview{Requirements: requirements.AsLabels()}
 
 	matching, eligible, err := p.candidates(ctx, requirements)
@@ -493,7 +507,7 @@ func (p placement) Preview(ctx context.Context, requirements manifest.LabelSelec
 		preview.Reason = ReasonNoEligibleRunner
 	}
 
-	capacity, err := p.capacityOf(ctx, eligible)
+	capacity, err := p.capacityOf(ctx, eligible, kind)
 	if err != nil {
 		return preview, err
 	}
@@ -530,16 +544,21 @@ func (p placement) Preview(ctx context.Context, requirements manifest.LabelSelec
 // and presence is computed rather than stored for the reasons on
 // WorkerInstanceStatus.Presence.
 //
+// Only workers able to run kind are counted as ready or online; see workerCanRun.
+// The rest are still registered, which is all they are for this run.
+//
 // One query for the whole eligible set, keyed by runner on the way out.
-func (p placement) workerCapacity(ctx context.Context, runners []Runner) (map[manifest.ResourceID]RunnerCapacity, error) {
+func (p placement) workerCapacity(ctx context.Context, runners []Runner, kind prob.Kind) (map[manifest.ResourceID]RunnerCapacity, error) {
 	capacity := make(map[manifest.ResourceID]RunnerCapacity, len(runners))
 	if len(runners) == 0 {
 		return capacity, nil
 	}
 
 	uids := make([]string, 0, len(runners))
+	draining := make(map[manifest.ResourceID]bool, len(runners))
 	for _, runner := range runners {
 		uids = append(uids, string(runner.UID))
+		draining[runner.UID] = runner.drainsWorkers()
 		// Seeded so a runner with no workers at all is present with zeroes,
 		// rather than missing and having to be defaulted by every reader.
 		capacity[runner.UID] = RunnerCapacity{}
@@ -572,15 +591,20 @@ func (p placement) workerCapacity(ctx context.Context, runners []Runner) (map[ma
 
 		entry.RegisteredWorkers++
 
-		if worker.Status.IsPaused {
+		// A draining worker is on its way out and pulls nothing more from its
+		// queue, so it is no more capacity than a paused one. One that cannot
+		// run this kind would hand the run back to its queue, which is no
+		// capacity either.
+		if worker.Status.IsPaused || drainRequested(worker.Status, draining[runnerUID]) || !workerCanRun(worker, kind) {
 			capacity[runnerUID] = entry
 			continue
 		}
 
 		entry.ReadyWorkers++
 
-		// Strictly online: a paused worker will not be given work, and one that
-		// has lost either path cannot do it. See RunnerCapacity.OnlineWorkers.
+		// Strictly online: a paused or draining worker will not be given work,
+		// and one that has lost either path cannot do it. See
+		// RunnerCapacity.OnlineWorkers.
 		if WorkerPresenceAt(worker.Status, now, p.offlineAfter).Condition == WorkerConditionOnline {
 			entry.OnlineWorkers++
 		}
@@ -590,3 +614,36 @@ func (p placement) workerCapacity(ctx context.Context, runners []Runner) (map[ma
 
 	return capacity, nil
 }
+
+// workerCanRun reports whether a worker should be expected to run a prob kind.
+//
+// Two things can say it cannot. Its registration labels name the kinds that
+// passed their self-test when it started, so a worker that advertises prob
+// kinds but not this one does not have it -- not linked, or not working. And its
+// status carries the kinds its latest self-test failed, which is how a
+// capability lost after registration is heard about.
+//
+// A worker that advertises no prob kind at all is given the benefit of the
+// doubt: its labels say nothing either way, and refusing it would make a fleet
+// registered before capabilities were advertised look empty.
+func workerCanRun(worker WorkerInstance, kind prob.Kind) bool {
+	if kind == "" {
+		return true
+	}
+
+	if worker.Status.SelfTestFailed(kind) {
+		return false
+	}
+
+	if _, ok := worker.Labels[LabelWorkerCapProbPrefix+string(kind)]; ok {
+		return true
+	}
+
+	for label := range worker.Labels {
+		if strings.HasPrefix(label, LabelWorkerCapProbPrefix) {
+			return false
+		}
+	}
+
+	return true
+}
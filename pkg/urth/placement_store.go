@@ -73,14 +73,16 @@ func (s *runnerLoadStore) InFlightRuns(ctx context.Context) (map[manifest.Resour
 	// counts each row's artifacts, which is a query per row. This reads
 	// aggregates and never materialises a Result at all.
 	//
-	// The two statuses are named rather than expressed as "not terminal", so a
+	// The statuses are named rather than expressed as "not terminal", so a
 	// state added later is counted only once someone has decided it should be.
+	// A cancelling run is counted as running: until its worker stops, it is
+	// still occupying a slot.
 	err := s.db.WithContext(ctx).
 		Session(&gorm.Session{SkipHooks: true}).
 		Model(&Result{}).
 		Select("status_executor_runner_id AS runner_id, status_status AS status, COUNT(*) AS total").
 		Where("deleted_at IS NULL").
-		Where("status_status IN ?", []JobStatus{JobPending, JobRunning}).
+		Where("status_status IN ?", []JobStatus{JobPending, JobRunning, JobCancelling}).
 		// An unplaced run -- one nothing could take -- carries no runner and is
 		// nobody's load.
 		Where("status_executor_runner_id IS NOT NULL AND status_executor_runner_id <> ''").
@@ -97,7 +99,7 @@ func (s *runnerLoadStore) InFlightRuns(ctx context.Context) (map[manifest.Resour
 		switch JobStatus(row.Status) {
 		case JobPending:
 			entry.Queued += row.Total
-		case JobRunning:
+		case JobRunning, JobCancelling:
 			entry.Running += row.Total
 		}
 
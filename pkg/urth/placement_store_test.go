@@ -163,6 +163,34 @@ func TestPlacementAvoidsARunnerWithNoReachableWorkers(t *testing.T) {
 		"a runner nobody is on must not win merely because its UID sorts first")
 }
 
+// A runner whose workers report that they cannot run the scenario's kind is no
+// capacity for it, however many of them are online.
+func TestPlacementAvoidsARunnerWhoseWorkersCannotRunTheKind(t *testing.T) {
+	srv, db, store := placementService(t)
+
+	first := seedRunnerWithWorkers(t, store, db, firstRunnerUID, "runner-aaa", 2)
+	seedRunnerWithWorkers(t, store, db, secondRunnerUID, "runner-bbb", 1)
+	scenario := seedOpenScenario(t, store, "incapable-scenario")
+
+	ctx := context.Background()
+	presence := urth.NewWorkerPresenceStore(db)
+
+	var workers []urth.WorkerInstance
+	require.NoError(t, db.Where("runner_id = ?", first.UID).Find(&workers).Error)
+	require.Len(t, workers, 2)
+	for _, worker := range workers {
+		found, err := presence.RecordSelfTest(ctx, worker.UID, []urth.ProbSelfTestFailure{{Kind: "http", Reason: "broken"}})
+		require.NoError(t, err)
+		require.True(t, found)
+	}
+
+	created, err := srv.Results(scenario.Name).Create(ctx, newRunRequest())
+	require.NoError(t, err)
+
+	require.Equal(t, manifest.ResourceName("runner-bbb"), runnerOf(t, store, created.UID),
+		"workers that cannot run http are not capacity for an http run")
+}
+
 // An entirely offline fleet still takes work. The queue is durable, so a run
 // waits for the fleet to come back; refusing it here would be a different bug
 // from the one this change fixes.
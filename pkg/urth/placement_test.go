@@ -4,6 +4,7 @@ import (
 	"math"
 	"testing"
 
+	"github.com/sre-norns/urth/pkg/prob"
 	"github.com/sre-norns/wyrd/pkg/manifest"
 	"github.com/stretchr/testify/require"
 )
@@ -192,3 +193,41 @@ func TestRunnerCapacityPressure(t *testing.T) {
 	require.Equal(t, 5, RunnerCapacity{OnlineWorkers: 4, Queued: 2, Running: 3}.Committed())
 	require.Equal(t, -1, RunnerCapacity{OnlineWorkers: 4, Queued: 2, Running: 3}.Spare())
 }
+
+// A worker is counted for a kind unless something it said rules it out: its
+// registration advertising other kinds but not this one, or its latest
+// self-test failing this one.
+func TestWorkerCanRun(t *testing.T) {
+	advertises := func(kinds ...string) manifest.Labels {
+		labels := manifest.Labels{LabelRunnerUID: "runner"}
+		for _, kind := range kinds {
+			labels[LabelWorkerCapProbPrefix+kind] = "devel"
+		}
+		return labels
+	}
+
+	testCases := map[string]struct {
+		labels   manifest.Labels
+		failures []ProbSelfTestFailure
+		kind     string
+		want     bool
+	}{
+		"advertised":              {labels: advertises("http", "icmp"), kind: "icmp", want: true},
+		"not advertised":          {labels: advertises("http"), kind: "icmp", want: false},
+		"advertises nothing":      {labels: advertises(), kind: "icmp", want: true},
+		"failing since":           {labels: advertises("icmp"), failures: []ProbSelfTestFailure{{Kind: "icmp"}}, kind: "icmp", want: false},
+		"another kind failing":    {labels: advertises("http", "icmp"), failures: []ProbSelfTestFailure{{Kind: "http"}}, kind: "icmp", want: true},
+		"kind unknown to the run": {labels: advertises("http"), kind: "", want: true},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			worker := WorkerInstance{
+				ObjectMeta: manifest.ObjectMeta{Labels: testCase.labels},
+				Status:     WorkerInstanceStatus{SelfTestFailures: testCase.failures},
+			}
+
+			require.Equal(t, testCase.want, workerCanRun(worker, prob.Kind(testCase.kind)))
+		})
+	}
+}
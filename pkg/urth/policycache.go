@@ -0,0 +1,168 @@
+package urth
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sre-norns/wyrd/pkg/dbstore"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// Policy caches: the small tables consulted on every run, held in memory.
+//
+// Maintenance windows are judged where every run is created, rate limits
+// wherever one is claimed, and dependencies at both; worker blocks on every
+// claim and every heartbeat. Each of those tables is read whole -- whether a
+// cron window is open, or a selector matches, is not a question SQL answers --
+// and a fleet has a handful of rows in each. But a
+// handful of rows read on every run is still a query per run, and at a few
+// thousand runs a minute the reads of tables that change a few times a week
+// were most of the load the hot paths put on the database.
+//
+// So each table is read into a snapshot that is shared until the table is
+// written. A write made through this service drops the snapshot at once, and
+// the next run reads the table again. A write made through another replica
+// cannot be seen that way, so a snapshot is also dropped once it is
+// policyCacheTTL old: a window declared on one replica covers the runs created
+// on the others within that.
+//
+// A snapshot also keeps what was worked out from its rows -- the parsed
+// selectors, which scenarios a row covers -- so that the same scenario is not
+// judged against the same row on every run. That is dropped along with the rows
+// and needs no invalidation of its own.
+
+// policyCacheTTL bounds how long a replica goes on using a policy table after
+// another replica changed it.
+const policyCacheTTL = 10 * time.Second
+
+// policyCaches are a service's policy caches, shared by every API it hands
+// out, so that a write through one drops the snapshot the others read.
+type policyCaches struct {
+	windows      *policyCache[windowSnapshot]
+	dependencies *policyCache[dependencySnapshot]
+	rateLimits   *policyCache[rateLimitSnapshot]
+	workerBars   *policyCache[workerBarSnapshot]
+}
+
+func newPolicyCaches() *policyCaches {
+	return &policyCaches{
+		windows:      newPolicyCache(loadWindowSnapshot),
+		dependencies: newPolicyCache(loadDependencySnapshot),
+		rateLimits:   newPolicyCache(newRateLimitLoader(newScenarioMemo[string]())),
+		workerBars:   newPolicyCache(loadWorkerBarSnapshot),
+	}
+}
+
+// policyCache holds one snapshot of type S, built by load.
+type policyCache[S any] struct {
+	load func(ctx context.Context, store dbstore.TransactionalStore) (S, error)
+	ttl  time.Duration
+
+	mu       sync.Mutex
+	snapshot S
+	loadedAt time.Time
+	loaded   bool
+
+	// generation counts invalidations. A load that began before one is not
+	// kept: it may have read the table as it was before the write.
+	generation uint64
+}
+
+func newPolicyCache[S any](load func(ctx context.Context, store dbstore.TransactionalStore) (S, error)) *policyCache[S] {
+	return &policyCache[S]{load: load, ttl: policyCacheTTL}
+}
+
+// get returns the current snapshot, reading the table for a new one if there
+// is none or it has expired.
+func (c *policyCache[S]) get(ctx context.Context, store dbstore.TransactionalStore) (S, error) {
+	c.mu.Lock()
+	if c.loaded && time.Since(c.loadedAt) < c.ttl {
+		snapshot := c.snapshot
+		c.mu.Unlock()
+		return snapshot, nil
+	}
+	generation := c.generation
+	c.mu.Unlock()
+
+	// Read outside the lock: a slow database should hold up the runs waiting
+	// for it, not every run on the replica.
+	loadedAt := time.Now()
+	snapshot, err := c.load(ctx, store)
+	if err != nil {
+		return snapshot, err
+	}
+
+	c.mu.Lock()
+	if c.generation == generation {
+		c.snapshot, c.loadedAt, c.loaded = snapshot, loadedAt, true
+	}
+	c.mu.Unlock()
+
+	return snapshot, nil
+}
+
+// invalidate drops the snapshot, after a write to its table.
+func (c *policyCache[S]) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	c.loaded = false
+	var zero S
+	c.snapshot = zero
+}
+
+// scenarioMemo is what one policy row, or one snapshot, worked out about each
+// scenario -- a row's covered-scenario set, a scenario's resolved dependencies
+// -- computed once per version of the scenario. A scenario edited since has a
+// new version, and is worked out again.
+type scenarioMemo[V any] struct {
+	mu       sync.Mutex
+	versions map[manifest.ResourceID]manifest.Version
+	values   map[manifest.ResourceID]V
+}
+
+func newScenarioMemo[V any]() *scenarioMemo[V] {
+	return &scenarioMemo[V]{
+		versions: map[manifest.ResourceID]manifest.Version{},
+		values:   map[manifest.ResourceID]V{},
+	}
+}
+
+// get returns what is known about scenario, calling compute only for a
+// scenario, or a version of one, it has not seen.
+func (c *scenarioMemo[V]) get(scenario Scenario, compute func(Scenario) V) V {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if version, ok := c.versions[scenario.UID]; ok && version == scenario.Version {
+		return c.values[scenario.UID]
+	}
+
+	value := compute(scenario)
+	c.versions[scenario.UID] = scenario.Version
+	c.values[scenario.UID] = value
+
+	return value
+}
+
+// retain forgets every scenario not among scenarios, which are all there are:
+// a memo kept from one snapshot to the next would otherwise hold on to every
+// scenario ever deleted.
+func (c *scenarioMemo[V]) retain(scenarios []Scenario) {
+	kept := make(map[manifest.ResourceID]bool, len(scenarios))
+	for _, scenario := range scenarios {
+		kept[scenario.UID] = true
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for uid := range c.versions {
+		if !kept[uid] {
+			delete(c.versions, uid)
+			delete(c.values, uid)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package urth
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sre-norns/wyrd/pkg/dbstore"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+	"github.com/stretchr/testify/require"
+)
+
+func countingCache(reads *atomic.Int32) *policyCache[int32] {
+	return newPolicyCache(func(context.Context, dbstore.TransactionalStore) (int32, error) {
+		return reads.Add(1), nil
+	})
+}
+
+func TestPolicyCacheReadsTheTableOncePerWrite(t *testing.T) {
+	var reads atomic.Int32
+	cache := countingCache(&reads)
+
+	for range 3 {
+		snapshot, err := cache.get(context.Background(), nil)
+		require.NoError(t, err)
+		require.EqualValues(t, 1, snapshot)
+	}
+
+	cache.invalidate()
+
+	snapshot, err := cache.get(context.Background(), nil)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, snapshot, "a write is seen by the next read")
+}
+
+// A replica cannot see another's writes, so a snapshot does not outlive its TTL.
+func TestPolicyCacheExpires(t *testing.T) {
+	var reads atomic.Int32
+	cache := countingCache(&reads)
+	cache.ttl = time.Millisecond
+
+	_, err := cache.get(context.Background(), nil)
+	require.NoError(t, err)
+	time.Sleep(5 * time.Millisecond)
+
+	snapshot, err := cache.get(context.Background(), nil)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, snapshot)
+}
+
+// A read that was under way when the table was written may have seen it as it
+// was, and is not kept.
+func TestPolicyCacheDropsAReadOverlappingAWrite(t *testing.T) {
+	var reads atomic.Int32
+	var cache *policyCache[int32]
+	cache = newPolicyCache(func(context.Context, dbstore.TransactionalStore) (int32, error) {
+		n := reads.Add(1)
+		if n == 1 {
+			cache.invalidate()
+		}
+		return n, nil
+	})
+
+	snapshot, err := cache.get(context.Background(), nil)
+	require.NoError(t, err)
+	require.EqualValues(t, 1, snapshot, "the caller still gets what was read")
+
+	snapshot, err = cache.get(context.Background(), nil)
+	require.NoError(t, err)
+	require.EqualValues(t, 2, snapshot, "but the next caller reads again")
+}
+
+func TestScenarioMemoJudgesEachVersionOnce(t *testing.T) {
+	coverage := newScenarioMemo[bool]()
+
+	judged := 0
+	judge := func(scenario Scenario) bool {
+		judged++
+		return scenario.Labels["team"] == "payments"
+	}
+
+	scenario := Scenario{ObjectMeta: manifest.ObjectMeta{UID: "s-1", Version: 1, Labels: manifest.Labels{"team": "payments"}}}
+	require.True(t, coverage.get(scenario, judge))
+	require.True(t, coverage.get(scenario, judge))
+	require.Equal(t, 1, judged)
+
+	scenario.Version = 2
+	scenario.Labels = manifest.Labels{"team": "search"}
+	require.False(t, coverage.get(scenario, judge), "a relabelled scenario is judged again")
+	require.Equal(t, 2, judged)
+}
+
+// A memo kept across snapshots does not keep what it knew of deleted scenarios.
+func TestScenarioMemoForgetsScenariosNoLongerListed(t *testing.T) {
+	hosts := newScenarioMemo[string]()
+
+	kept := Scenario{ObjectMeta: manifest.ObjectMeta{UID: "s-1", Version: 1}}
+	deleted := Scenario{ObjectMeta: manifest.ObjectMeta{UID: "s-2", Version: 1}}
+	host := func(Scenario) string { return "example.com" }
+	hosts.get(kept, host)
+	hosts.get(deleted, host)
+
+	hosts.retain([]Scenario{kept})
+
+	require.Contains(t, hosts.values, kept.UID)
+	require.NotContains(t, hosts.values, deleted.UID)
+}
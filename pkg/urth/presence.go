@@ -95,6 +95,30 @@ const (
 	WorkerConditionNATSUnreachable WorkerCondition = "nats-unreachable"
 )
 
+// WorkerLifecycle is where a worker is in its life, as opposed to whether it can
+// be heard.
+//
+// Orthogonal to the condition, and reported beside it rather than folded in: a
+// draining worker is still online -- it has runs to finish and reports while it
+// does -- and folding the two together would make a worker that is emptying
+// itself on request look the same as one that has gone quiet.
+type WorkerLifecycle string
+
+const (
+	// WorkerLifecycleActive is a worker taking work as normal, or one that has
+	// never said otherwise.
+	WorkerLifecycleActive WorkerLifecycle = "active"
+
+	// WorkerLifecycleDraining is a worker asked to drain, by itself or through
+	// its runner, that has not yet left: it takes no new work and is finishing
+	// what it holds.
+	WorkerLifecycleDraining WorkerLifecycle = "draining"
+
+	// WorkerLifecycleLeft is a worker that said it was leaving and has not been
+	// heard from since.
+	WorkerLifecycleLeft WorkerLifecycle = "left"
+)
+
 // WorkerContact records which evidence last proved a worker was reachable.
 //
 // Kept because "last seen 20 seconds ago" answers a different question depending
@@ -124,6 +148,9 @@ type WorkerPresenceReport struct {
 
 	// Condition is the two signals combined.
 	Condition WorkerCondition `form:"condition" json:"condition" yaml:"condition" xml:"condition"`
+
+	// Lifecycle is whether the worker is working, draining, or has left.
+	Lifecycle WorkerLifecycle `form:"lifecycle" json:"lifecycle" yaml:"lifecycle" xml:"lifecycle"`
 }
 
 // WorkerPresenceAt is the single definition of what the recorded timestamps mean.
@@ -133,7 +160,16 @@ type WorkerPresenceReport struct {
 // its own: a second implementation of "is this worker alive" would drift from the
 // first, and the symptom would be a UI showing a worker the reconciler has
 // already decided to evict.
+//
+// A drain requested through the worker's runner is not in the worker's own
+// status; callers holding the runner use workerPresenceAt to account for it.
 func WorkerPresenceAt(status WorkerInstanceStatus, now time.Time, offlineAfter time.Duration) WorkerPresenceReport {
+	return workerPresenceAt(status, false, now, offlineAfter)
+}
+
+// workerPresenceAt is WorkerPresenceAt for a worker whose runner may itself be
+// draining.
+func workerPresenceAt(status WorkerInstanceStatus, runnerDraining bool, now time.Time, offlineAfter time.Duration) WorkerPresenceReport {
 	if offlineAfter <= 0 {
 		offlineAfter = DefaultWorkerHeartbeatInterval * DefaultWorkerOfflineAfterFactor
 	}
@@ -147,11 +183,17 @@ func WorkerPresenceAt(status WorkerInstanceStatus, now time.Time, offlineAfter t
 	// recently it was heard, because being heard is exactly what it just told us
 	// to stop expecting. Guarded on the timestamps so that a worker which left
 	// and has since come back is not held down by its own farewell.
+	report.Lifecycle = WorkerLifecycleActive
+	if drainRequested(status, runnerDraining) {
+		report.Lifecycle = WorkerLifecycleDraining
+	}
+
 	if left := status.LeftAt; left != nil &&
 		!left.Before(derefTime(status.LastSeenTime)) &&
 		!left.Before(derefTime(status.NATSLastSeenTime)) {
 		report.API = downgradeToOffline(report.API)
 		report.NATS = downgradeToOffline(report.NATS)
+		report.Lifecycle = WorkerLifecycleLeft
 	}
 
 	report.Condition = conditionOf(report.API, report.NATS)
@@ -159,6 +201,13 @@ func WorkerPresenceAt(status WorkerInstanceStatus, now time.Time, offlineAfter t
 	return report
 }
 
+// drainRequested reports whether a worker has been asked to drain, on its own or
+// through its runner. The one place the two are combined, so that the heartbeat,
+// placement and the fleet view cannot disagree about which workers are leaving.
+func drainRequested(status WorkerInstanceStatus, runnerDraining bool) bool {
+	return status.IsDraining || runnerDraining
+}
+
 // presenceOf grades one signal's timestamp.
 func presenceOf(seen *time.Time, now time.Time, offlineAfter time.Duration) WorkerPresence {
 	if seen == nil || seen.IsZero() {
@@ -2,6 +2,7 @@ package urth
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -46,6 +47,13 @@ type WorkerPresenceStore interface {
 	// under the same prefix. Matching the membership here closes that without a
 	// second round trip.
 	RecordNATSPresence(ctx context.Context, workerUID, runnerUID manifest.ResourceID, at time.Time) (bool, error)
+
+	// RecordSelfTest replaces what the worker last reported about the prob
+	// kinds it cannot run.
+	//
+	// Here rather than through a resource save for the reason every other
+	// method is: it arrives with every heartbeat, and a report is not an edit.
+	RecordSelfTest(ctx context.Context, workerUID manifest.ResourceID, failures []ProbSelfTestFailure) (bool, error)
 }
 
 // workerPresenceStore writes liveness columns straight to the database.
@@ -86,6 +94,25 @@ func (s *workerPresenceStore) RecordNATSPresence(ctx context.Context, workerUID,
 	})
 }
 
+func (s *workerPresenceStore) RecordSelfTest(ctx context.Context, workerUID manifest.ResourceID, failures []ProbSelfTestFailure) (bool, error) {
+	// Encoded here because a column map bypasses gorm's serializer: the field's
+	// serializer:json only applies when the whole struct is saved. What is
+	// written is what that serializer would have written -- JSON text, and NULL
+	// for nothing at all.
+	var value any
+	if len(failures) > 0 {
+		encoded, err := json.Marshal(failures)
+		if err != nil {
+			return false, fmt.Errorf("failed to encode self-test failures for worker %v: %w", workerUID, err)
+		}
+		value = string(encoded)
+	}
+
+	return s.touch(ctx, workerUID, nil, map[string]any{
+		"status_self_test_failures": value,
+	})
+}
+
 // touch writes liveness columns for one worker without disturbing anything else
 // about the record.
 //
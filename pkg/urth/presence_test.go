@@ -128,3 +128,30 @@ func TestIsSilentIgnoresWorkersThatNeverReported(t *testing.T) {
 	require.False(t, WorkerInstanceStatus{NATSLastSeenTime: &recent}.IsSilent(cutoff),
 		"one live signal is enough to be present")
 }
+
+// A drained worker is still online while it finishes what it holds, and reads
+// left once it has said so. The lifecycle is what tells those two apart from a
+// worker that is merely working, or merely gone quiet.
+func TestWorkerLifecycle(t *testing.T) {
+	now := time.Date(2026, 7, 29, 12, 0, 0, 0, time.UTC)
+	const offlineAfter = 3 * time.Minute
+
+	seen := now.Add(-time.Second)
+	working := WorkerInstanceStatus{LastSeenTime: &seen, NATSLastSeenTime: &seen}
+
+	require.Equal(t, WorkerLifecycleActive, WorkerPresenceAt(working, now, offlineAfter).Lifecycle)
+
+	drained := working
+	drained.IsDraining = true
+	report := WorkerPresenceAt(drained, now, offlineAfter)
+	require.Equal(t, WorkerLifecycleDraining, report.Lifecycle)
+	require.Equal(t, WorkerConditionOnline, report.Condition, "a draining worker is still there")
+
+	require.Equal(t, WorkerLifecycleDraining, workerPresenceAt(working, true, now, offlineAfter).Lifecycle,
+		"a runner's drain is every one of its workers' drain")
+
+	drained.LeftAt = &seen
+	report = WorkerPresenceAt(drained, now, offlineAfter)
+	require.Equal(t, WorkerLifecycleLeft, report.Lifecycle)
+	require.Equal(t, WorkerConditionOffline, report.Condition)
+}
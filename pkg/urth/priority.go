@@ -0,0 +1,156 @@
+package urth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sre-norns/wyrd/pkg/dbstore"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// A runner's queue is first come, first served, and most of what comes is
+// scheduled load. An operator pressing "Run now" during an incident -- to see
+// whether the fix worked -- found their run behind every scheduled run already
+// queued for the same runner, which on a busy pool is minutes of waiting for
+// the one answer anyone is looking at.
+//
+// So a run carries a priority, decided once, where it is created, from what
+// asked for it and the scenario's priority class. The transport keeps a
+// separate queue per priority and workers drain the high one first, with a
+// share of their pulls reserved for the normal one so that a flood of manual
+// runs cannot starve scheduled load entirely.
+//
+// Two priorities, not a scale. The question the queue has to answer is "is a
+// person waiting on this", and a finer ordering would only be another number
+// to argue about in every scenario review.
+
+// RunPriority is which of a runner's queues a run is dispatched on.
+type RunPriority string
+
+const (
+	// PriorityNormal is the queue scheduled load drains through. An empty
+	// priority, on a run created before there was one, reads as this.
+	PriorityNormal RunPriority = "normal"
+
+	// PriorityHigh is pulled ahead of normal by every worker of the runner.
+	PriorityHigh RunPriority = "high"
+)
+
+// IsHigh reports whether a run jumps its runner's queue.
+func (p RunPriority) IsHigh() bool {
+	return p == PriorityHigh
+}
+
+// RunTrigger is what asked for a run.
+type RunTrigger string
+
+const (
+	// TriggerScheduled is a run created without a name, which is how the
+	// schedule asks for one.
+	TriggerScheduled RunTrigger = "scheduled"
+
+	// TriggerManual is a run created under the manualRunPrefix -- a "Run now".
+	TriggerManual RunTrigger = "manual"
+
+	// TriggerRetry is a run created by retrying a dead-lettered dispatch. See
+	// DispatchFailureAPI.
+	TriggerRetry RunTrigger = "retry"
+)
+
+// manualRunPrefix starts the name a client asks for when a person triggers a
+// run; the rest of the name is generated. See resultsAPIImpl.Create.
+const manualRunPrefix = "manual-"
+
+// triggerOf says what asked for a run, from the name it was requested under.
+func triggerOf(name manifest.ResourceName) RunTrigger {
+	if strings.HasPrefix(string(name), manualRunPrefix) {
+		return TriggerManual
+	}
+
+	return TriggerScheduled
+}
+
+// PriorityClass says how a scenario's runs are prioritised.
+type PriorityClass string
+
+const (
+	// PriorityClassStandard puts the runs a person is waiting on -- manual runs
+	// and retries -- ahead of scheduled ones. It is the default.
+	PriorityClassStandard PriorityClass = "Standard"
+
+	// PriorityClassCritical dispatches every run high, scheduled ones included.
+	// Meant for the handful of scenarios whose verdict is the incident signal;
+	// a fleet that marks everything critical has marked nothing.
+	PriorityClassCritical PriorityClass = "Critical"
+
+	// PriorityClassBackground dispatches every run normal, manual ones
+	// included: a long soak or crawl that nobody wants queued ahead of the
+	// scenarios an incident is being watched through.
+	PriorityClassBackground PriorityClass = "Background"
+)
+
+// Validate refuses a class this server does not know.
+//
+// Refused rather than read as Standard, for the same reason as an unknown
+// ConcurrencyPolicy: a class in the wrong case would quietly not do what the
+// manifest says.
+func (c PriorityClass) Validate() error {
+	switch c {
+	case "", PriorityClassStandard, PriorityClassCritical, PriorityClassBackground:
+		return nil
+	default:
+		return fmt.Errorf("unknown priority class %q: expected %s, %s or %s",
+			c, PriorityClassStandard, PriorityClassCritical, PriorityClassBackground)
+	}
+}
+
+// PriorityFor is the priority a run of this class is dispatched at.
+func (c PriorityClass) PriorityFor(trigger RunTrigger) RunPriority {
+	switch c {
+	case PriorityClassCritical:
+		return PriorityHigh
+	case PriorityClassBackground:
+		return PriorityNormal
+	}
+
+	if trigger == TriggerScheduled {
+		return PriorityNormal
+	}
+
+	return PriorityHigh
+}
+
+// prioritise records a new run's trigger and the priority it is dispatched at.
+//
+// Always overwritten, never taken from the request: a client that could set
+// its own priority would set it high, and the queue would be back to first come,
+// first served.
+func prioritise(result *Result, class PriorityClass, trigger RunTrigger) {
+	result.Spec.Priority = class.PriorityFor(trigger)
+	result.Labels = manifest.MergeLabels(result.Labels, manifest.Labels{
+		LabelResultTrigger:  string(trigger),
+		LabelResultPriority: string(result.Spec.Priority),
+	})
+}
+
+// retryPriorityClass is the class a retry of a run is prioritised under: its
+// scenario's as it stands now.
+//
+// Unlike the execution input, which a retry copies so it re-attempts exactly
+// what failed, priority is a decision about the queue at the moment of the
+// retry, and a scenario an operator has since moved to Background should not
+// have its retries jump the queue. A scenario that has gone since leaves the
+// default.
+func retryPriorityClass(ctx context.Context, store dbstore.TransactionalStore, scenarioID manifest.ResourceID) PriorityClass {
+	if scenarioID == "" {
+		return PriorityClassStandard
+	}
+
+	var scenario Scenario
+	if found, err := store.GetByUID(ctx, &scenario, scenarioID); err != nil || !found {
+		return PriorityClassStandard
+	}
+
+	return scenario.Spec.PriorityClass
+}
@@ -0,0 +1,113 @@
+package urth
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sre-norns/wyrd/pkg/dbstore"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// A runner's high-priority queue is drained only by workers that know it is
+// there. One that predates it binds the normal queue alone, and during a
+// rolling upgrade a runner can be served by nothing else: a run published high
+// to it would sit on a queue nobody pulls until its claim deadline, and the
+// runs that go high are the ones a person is waiting on.
+//
+// So a worker says that it pulls the high queue, with the
+// LabelWorkerCapHighPriorityQueue label it registers with, and a high run is
+// routed there alone only once every worker of its runner that could take it
+// does. Until then the transport publishes it to both queues. The two copies
+// carry different dispatch IDs, so whichever is claimed first wins and the
+// other's claim is refused as obsolete by the version guard -- including when
+// one upgraded worker pulls both.
+
+// HighPriorityQueueSupport says whether a runner's high-priority queue can be
+// relied on alone.
+type HighPriorityQueueSupport interface {
+	// DrainsHighPriority reports whether every worker of the runner that is
+	// not offline pulls the high-priority queue. A runner with no such worker
+	// reports false: nothing is known about the worker that will come.
+	DrainsHighPriority(ctx context.Context, runnerUID manifest.ResourceID) (bool, error)
+}
+
+// highPriorityQueueSupport answers from the runner's worker registrations,
+// remembered per runner for policyCacheTTL: it is asked on every high
+// dispatch, and a fleet's versions change with a rollout, not with every run.
+type highPriorityQueueSupport struct {
+	store        dbstore.TransactionalStore
+	offlineAfter time.Duration
+	ttl          time.Duration
+
+	mu      sync.Mutex
+	answers map[manifest.ResourceID]queueSupportAnswer
+}
+
+type queueSupportAnswer struct {
+	drains    bool
+	checkedAt time.Time
+}
+
+// NewHighPriorityQueueSupport returns a HighPriorityQueueSupport reading the
+// worker registrations in store. offlineAfter is the presence threshold, as
+// WorkerPresenceAt takes it; zero is the default.
+func NewHighPriorityQueueSupport(store dbstore.TransactionalStore, offlineAfter time.Duration) HighPriorityQueueSupport {
+	return &highPriorityQueueSupport{
+		store:        store,
+		offlineAfter: offlineAfter,
+		ttl:          policyCacheTTL,
+		answers:      map[manifest.ResourceID]queueSupportAnswer{},
+	}
+}
+
+func (s *highPriorityQueueSupport) DrainsHighPriority(ctx context.Context, runnerUID manifest.ResourceID) (bool, error) {
+	s.mu.Lock()
+	answer, ok := s.answers[runnerUID]
+	s.mu.Unlock()
+	if ok && time.Since(answer.checkedAt) < s.ttl {
+		return answer.drains, nil
+	}
+
+	requirement, err := manifest.NewRequirement(LabelRunnerUID, manifest.Equals, []string{string(runnerUID)})
+	if err != nil {
+		return false, fmt.Errorf("failed to build a worker query for runner %v: %w", runnerUID, err)
+	}
+
+	var workers []WorkerInstance
+	if _, err := s.store.Find(ctx, &workers, manifest.SearchQuery{Selector: manifest.NewSelector(requirement)}); err != nil {
+		return false, fmt.Errorf("failed to list workers of runner %v: %w", runnerUID, err)
+	}
+
+	checkedAt := time.Now()
+	drains := drainsHighPriority(workers, checkedAt, s.offlineAfter)
+
+	s.mu.Lock()
+	s.answers[runnerUID] = queueSupportAnswer{drains: drains, checkedAt: checkedAt}
+	s.mu.Unlock()
+
+	return drains, nil
+}
+
+// drainsHighPriority reports whether workers include one that is not offline,
+// and every one that is not offline pulls the high-priority queue.
+//
+// An offline worker is left out either way. It pulls nothing now, and a
+// registration left behind by a worker that was replaced must not hold its
+// runner on both queues for good.
+func drainsHighPriority(workers []WorkerInstance, now time.Time, offlineAfter time.Duration) bool {
+	present := 0
+	for _, worker := range workers {
+		if WorkerPresenceAt(worker.Status, now, offlineAfter).Condition == WorkerConditionOffline {
+			continue
+		}
+
+		if _, ok := worker.Labels[LabelWorkerCapHighPriorityQueue]; !ok {
+			return false
+		}
+		present++
+	}
+
+	return present > 0
+}
@@ -0,0 +1,45 @@
+package urth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sre-norns/wyrd/pkg/manifest"
+	"github.com/stretchr/testify/require"
+)
+
+// A high run goes to the high queue alone only when no worker that could take
+// it would miss it there.
+func TestHighPriorityQueueIsReliedOnOnlyWhenEveryPresentWorkerDrainsIt(t *testing.T) {
+	now := time.Now()
+	recent := now.Add(-time.Second)
+	long := now.Add(-24 * time.Hour)
+
+	upgraded := func(seen time.Time) WorkerInstance {
+		return WorkerInstance{
+			ObjectMeta: manifest.ObjectMeta{Labels: manifest.Labels{LabelWorkerCapHighPriorityQueue: "true"}},
+			Status:     WorkerInstanceStatus{LastSeenTime: &seen},
+		}
+	}
+	older := func(seen time.Time) WorkerInstance {
+		return WorkerInstance{Status: WorkerInstanceStatus{LastSeenTime: &seen}}
+	}
+
+	testCases := map[string]struct {
+		workers []WorkerInstance
+		want    bool
+	}{
+		"no workers":                      {want: false},
+		"all upgraded":                    {workers: []WorkerInstance{upgraded(recent), upgraded(recent)}, want: true},
+		"mid-rollout":                     {workers: []WorkerInstance{upgraded(recent), older(recent)}, want: false},
+		"only older":                      {workers: []WorkerInstance{older(recent)}, want: false},
+		"an older one long gone":          {workers: []WorkerInstance{upgraded(recent), older(long)}, want: true},
+		"the only upgraded one long gone": {workers: []WorkerInstance{upgraded(long)}, want: false},
+	}
+
+	for name, testCase := range testCases {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, testCase.want, drainsHighPriority(testCase.workers, now, time.Minute))
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package urth_test
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sre-norns/urth/pkg/urth"
+)
+
+// The whole rule, as a table: under the default class a person waiting on a run
+// puts it ahead, and the two other classes override the trigger either way.
+func TestPriorityClassDecidesEachTriggersPriority(t *testing.T) {
+	for class, want := range map[urth.PriorityClass]map[urth.RunTrigger]urth.RunPriority{
+		"": {
+			urth.TriggerScheduled: urth.PriorityNormal,
+			urth.TriggerManual:    urth.PriorityHigh,
+			urth.TriggerRetry:     urth.PriorityHigh,
+		},
+		urth.PriorityClassStandard: {
+			urth.TriggerScheduled: urth.PriorityNormal,
+			urth.TriggerManual:    urth.PriorityHigh,
+			urth.TriggerRetry:     urth.PriorityHigh,
+		},
+		urth.PriorityClassCritical: {
+			urth.TriggerScheduled: urth.PriorityHigh,
+			urth.TriggerManual:    urth.PriorityHigh,
+			urth.TriggerRetry:     urth.PriorityHigh,
+		},
+		urth.PriorityClassBackground: {
+			urth.TriggerScheduled: urth.PriorityNormal,
+			urth.TriggerManual:    urth.PriorityNormal,
+			urth.TriggerRetry:     urth.PriorityNormal,
+		},
+	} {
+		for trigger, priority := range want {
+			require.Equal(t, priority, class.PriorityFor(trigger), "class %q, trigger %q", class, trigger)
+		}
+	}
+}
+
+func TestUnknownPriorityClassIsRefused(t *testing.T) {
+	for _, class := range []urth.PriorityClass{"", urth.PriorityClassStandard, urth.PriorityClassCritical, urth.PriorityClassBackground} {
+		require.NoError(t, class.Validate(), "class %q", class)
+	}
+
+	require.Error(t, urth.PriorityClass("critical").Validate())
+}
@@ -0,0 +1,417 @@
+package urth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log"
+	"math"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// Probe metrics: the latest result of every scenario, in the shape
+// blackbox_exporter uses, so that urth slots into the dashboards already built
+// for it.
+//
+// A scrape reports state rather than events. What a probe dashboard asks is
+// "is this target up right now, and how slow is it", and the answer is the most
+// recent finished run for each scenario and vantage point, re-read on every
+// scrape. Nothing is accumulated here, and a scrape that misses a run loses
+// nothing the run history does not still hold.
+
+// MetricsArtifactRel is the artifact relation a runner stores its metrics
+// registry under.
+const MetricsArtifactRel = "metrics"
+
+// Probe metrics defaults.
+const (
+	// DefaultMaxProbeTargets bounds how many scenario and runner pairs one scrape
+	// reports. Both halves are operator-created and unbounded, and their product
+	// is what lands in Prometheus, for the reason natsq.DefaultMaxRunnerSeries
+	// gives.
+	DefaultMaxProbeTargets = 1000
+
+	// DefaultMaxProbeArtifactSamples bounds how many samples one run's metrics
+	// artifact may contribute. A prober is free to register whatever it likes,
+	// and one that labels a series by URL should not be able to take down the
+	// monitoring system it reports to.
+	DefaultMaxProbeArtifactSamples = 200
+)
+
+// Names of the series every probe target reports.
+const (
+	probeSuccessMetric  = "probe_success"
+	probeDurationMetric = "probe_duration_seconds"
+
+	// probeUpstreamFailedMetric is reported, as 1, only for a target whose
+	// latest run failed behind a failing dependency, and names the dependency
+	// in an `upstream` label. Absent otherwise, so that an alert rule can
+	// leave consequential failures out with `unless on(scenario, runner)`.
+	probeUpstreamFailedMetric = "probe_upstream_failed"
+)
+
+// ProbeMetricsConfig is what an operator can set about the probe metrics
+// endpoint.
+type ProbeMetricsConfig struct {
+	Enabled bool `help:"Serve the latest result of every scenario at /metrics/probes" default:"true" negatable:""`
+
+	// Labels is an allowlist. Scenario labels are free text, and copying all of
+	// them onto every series would make the cardinality of a dashboard a function
+	// of what anyone ever typed into a manifest.
+	Labels []string `help:"Scenario labels copied onto every probe series" sep:","`
+
+	MaxTargets         int `help:"How many scenario and runner pairs one scrape reports" default:"1000"`
+	MaxArtifactSamples int `help:"How many samples one run's metrics artifact may contribute" default:"200"`
+}
+
+// ProbeTarget is the latest finished run of one scenario on one runner.
+type ProbeTarget struct {
+	ScenarioName   manifest.ResourceName
+	ScenarioLabels manifest.Labels
+
+	Run Result
+
+	// Metrics is the run's stored metrics artifact, if it has one. A run the
+	// reconciler expired never reported and has none; it still has an outcome.
+	Metrics *ArtifactSpec
+}
+
+// ProbeTargetStore reads the latest run of every scenario and runner.
+type ProbeTargetStore interface {
+	// LatestProbeTargets returns up to limit targets in a stable order, and how
+	// many there are in total.
+	LatestProbeTargets(ctx context.Context, limit int) ([]ProbeTarget, int, error)
+}
+
+// ProbeCollector reports the latest probe result of every scenario.
+//
+// An unchecked collector: the series a metrics artifact contributes are
+// whatever its prober registered, and cannot be described before they are read.
+type ProbeCollector struct {
+	store ProbeTargetStore
+
+	labels     []string
+	maxTargets int
+	maxSamples int
+	timeout    time.Duration
+
+	targetsUnreported *prometheus.Desc
+	samplesUnreported *prometheus.Desc
+	scrapeErrors      *prometheus.Desc
+
+	failures atomic.Uint64
+}
+
+// ProbeCollectorOption configures a ProbeCollector.
+type ProbeCollectorOption func(*ProbeCollector)
+
+// WithProbeLabels sets which scenario labels are copied onto every series.
+func WithProbeLabels(labels ...string) ProbeCollectorOption {
+	return func(c *ProbeCollector) { c.labels = labels }
+}
+
+// WithMaxProbeTargets caps how many scenario and runner pairs are reported.
+func WithMaxProbeTargets(limit int) ProbeCollectorOption {
+	return func(c *ProbeCollector) { c.maxTargets = limit }
+}
+
+// WithMaxProbeArtifactSamples caps what one metrics artifact contributes.
+func WithMaxProbeArtifactSamples(limit int) ProbeCollectorOption {
+	return func(c *ProbeCollector) { c.maxSamples = limit }
+}
+
+// NewProbeCollector builds the collector over a store.
+func NewProbeCollector(store ProbeTargetStore, options ...ProbeCollectorOption) *ProbeCollector {
+	const namespace = "urth_probe"
+
+	c := &ProbeCollector{
+		store:      store,
+		maxTargets: DefaultMaxProbeTargets,
+		maxSamples: DefaultMaxProbeArtifactSamples,
+		timeout:    DefaultMetricsTimeout,
+
+		targetsUnreported: prometheus.NewDesc(namespace+"_targets_unreported",
+			"Scenario and runner pairs omitted because the target cap was reached.", nil, nil),
+		samplesUnreported: prometheus.NewDesc(namespace+"_artifact_samples_unreported",
+			"Samples from stored metrics artifacts omitted because a cap was reached or they conflicted with another target's.", nil, nil),
+		scrapeErrors: prometheus.NewDesc(namespace+"_scrape_failures_total",
+			"Scrapes that could not read the latest probe results from the database.", nil, nil),
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	c.labels = probeLabelAllowlist(c.labels)
+
+	return c
+}
+
+// probeLabelAllowlist drops scenario label keys that would collide once
+// sanitised. Two keys that sanitise to the same label name would make every
+// series invalid; the first one wins.
+func probeLabelAllowlist(keys []string) []string {
+	seen := map[string]bool{}
+	labels := keys[:0:0]
+	for _, label := range keys {
+		if name := probeLabelName(label); !seen[name] {
+			seen[name] = true
+			labels = append(labels, label)
+		}
+	}
+
+	return labels
+}
+
+// Describe implements prometheus.Collector. Nothing is described, which is what
+// makes this an unchecked collector.
+func (c *ProbeCollector) Describe(chan<- *prometheus.Desc) {}
+
+// Collect implements prometheus.Collector.
+func (c *ProbeCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	targets, total, err := c.store.LatestProbeTargets(ctx, c.maxTargets)
+	if err != nil {
+		log.Printf("metrics: failed to read the latest probe results: %v", err)
+		c.failures.Add(1)
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.scrapeErrors, prometheus.CounterValue, float64(c.failures.Load()))
+	if err != nil {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.targetsUnreported, prometheus.GaugeValue, float64(max(total-len(targets), 0)))
+
+	names := probeTargetLabelNames(c.labels)
+	families := probeFamilies{}
+
+	var dropped int
+	for _, target := range targets {
+		values := probeTargetLabelValues(c.labels, target)
+
+		ch <- prometheus.MustNewConstMetric(
+			prometheus.NewDesc(probeSuccessMetric, "Displays whether or not the probe was a success", names, nil),
+			prometheus.GaugeValue, probeSuccess(target.Run), values...)
+
+		if started, ended := target.Run.Spec.TimeStarted, target.Run.Spec.TimeEnded; started != nil && ended != nil {
+			ch <- prometheus.MustNewConstMetric(
+				prometheus.NewDesc(probeDurationMetric, "Returns how long the probe took to complete in seconds", names, nil),
+				prometheus.GaugeValue, ended.Sub(*started).Seconds(), values...)
+		}
+
+		if upstream := target.Run.Status.UpstreamFailed; upstream != "" {
+			ch <- prometheus.MustNewConstMetric(
+				prometheus.NewDesc(probeUpstreamFailedMetric, "Whether the probe failed while a scenario it depends on was failing",
+					append(slices.Clone(names), "upstream"), nil),
+				prometheus.GaugeValue, 1, append(slices.Clone(values), string(upstream))...)
+		}
+
+		dropped += c.collectArtifact(ch, families, target, names, values)
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.samplesUnreported, prometheus.GaugeValue, float64(dropped))
+}
+
+// probeTargetLabelNames are the labels identifying a target, in a fixed order.
+func probeTargetLabelNames(allowlist []string) []string {
+	names := []string{"scenario", "runner", "kind"}
+	for _, label := range allowlist {
+		names = append(names, probeLabelName(label))
+	}
+
+	return names
+}
+
+func probeTargetLabelValues(allowlist []string, target ProbeTarget) []string {
+	values := []string{
+		string(target.ScenarioName),
+		string(target.Run.Status.Executor.RunnerName),
+		string(target.Run.Spec.ProbKind),
+	}
+	for _, label := range allowlist {
+		values = append(values, target.ScenarioLabels[label])
+	}
+
+	return values
+}
+
+// probeLabelName turns a scenario label key into a Prometheus label name.
+//
+// Label keys follow Kubernetes conventions -- dots and slashes -- which
+// Prometheus names may not contain. Prefixed, so that a scenario label called
+// `runner` cannot overwrite the label that says which runner this is.
+func probeLabelName(key string) string {
+	var name strings.Builder
+	name.WriteString("label_")
+	for _, r := range key {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			name.WriteRune(r)
+		default:
+			name.WriteRune('_')
+		}
+	}
+
+	return name.String()
+}
+
+// probeSuccess is 1 for a run that succeeded, slowly or not, and 0 for any
+// other outcome, as blackbox_exporter reports it.
+func probeSuccess(run Result) float64 {
+	switch ResultOutcome(run) {
+	case prob.RunFinishedSuccess, prob.RunFinishedDegraded:
+		return 1
+	}
+
+	return 0
+}
+
+// probeFamilies remembers the first type and help seen for each family in one
+// scrape. A registry refuses a family whose metrics disagree on either, and two
+// runners on different versions of a prober can well disagree.
+type probeFamilies map[string]*dto.MetricFamily
+
+// admit reports whether a family from an artifact may be exposed alongside what
+// this scrape already has.
+func (f probeFamilies) admit(family *dto.MetricFamily) bool {
+	switch family.GetName() {
+	case probeSuccessMetric, probeDurationMetric, probeUpstreamFailedMetric:
+		// Reported from the Result, which has them even for a run that never
+		// stored an artifact.
+		return false
+	}
+
+	seen, ok := f[family.GetName()]
+	if !ok {
+		f[family.GetName()] = family
+		return true
+	}
+
+	return seen.GetType() == family.GetType() && seen.GetHelp() == family.GetHelp()
+}
+
+// collectArtifact exposes the series in a target's metrics artifact, relabelled
+// with the target. It reports how many samples were left out.
+func (c *ProbeCollector) collectArtifact(ch chan<- prometheus.Metric, families probeFamilies, target ProbeTarget, names, values []string) int {
+	var emitted, dropped int
+	for _, family := range decodeMetricsArtifact(target) {
+		if !families.admit(family) {
+			dropped += len(family.GetMetric())
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			if emitted >= c.maxSamples {
+				dropped++
+				continue
+			}
+
+			sample, err := probeArtifactMetric(family, metric, names, values)
+			if err != nil {
+				dropped++
+				continue
+			}
+
+			ch <- sample
+			emitted++
+		}
+	}
+
+	return dropped
+}
+
+// decodeMetricsArtifact reads the families a target's metrics artifact holds.
+//
+// An artifact that stops decoding part way contributes what was read before
+// the fault: it was written by a prober, and one malformed family says nothing
+// about the ones before it.
+func decodeMetricsArtifact(target ProbeTarget) []*dto.MetricFamily {
+	if target.Metrics == nil || len(target.Metrics.Content) == 0 {
+		return nil
+	}
+
+	decoder := expfmt.NewDecoder(bytes.NewReader(target.Metrics.Content), expfmt.Format(target.Metrics.MimeType))
+
+	var families []*dto.MetricFamily
+	for {
+		family := &dto.MetricFamily{}
+		if err := decoder.Decode(family); err != nil {
+			if !errors.Is(err, io.EOF) {
+				log.Printf("metrics: failed to read the metrics artifact of run %v: %v", target.Run.UID, err)
+			}
+
+			return families
+		}
+
+		families = append(families, family)
+	}
+}
+
+// exportedLabelName renames a stored label that collides with one of the
+// target's. This is what Prometheus itself does with honor_labels off: the
+// target says which scenario this is, not whatever the prober wrote.
+func exportedLabelName(name string, reserved []string) string {
+	for _, taken := range reserved {
+		if name == taken {
+			return "exported_" + name
+		}
+	}
+
+	return name
+}
+
+// probeArtifactMetric rebuilds one stored sample with the target's labels.
+//
+// A stored label that collides with a target label is kept as `exported_<name>`.
+func probeArtifactMetric(family *dto.MetricFamily, metric *dto.Metric, targetNames, targetValues []string) (prometheus.Metric, error) {
+	names := append([]string(nil), targetNames...)
+	values := append([]string(nil), targetValues...)
+
+	for _, pair := range metric.GetLabel() {
+		names = append(names, exportedLabelName(pair.GetName(), targetNames))
+		values = append(values, pair.GetValue())
+	}
+
+	desc := prometheus.NewDesc(family.GetName(), family.GetHelp(), names, nil)
+
+	switch family.GetType() {
+	case dto.MetricType_COUNTER:
+		return prometheus.NewConstMetric(desc, prometheus.CounterValue, metric.GetCounter().GetValue(), values...)
+	case dto.MetricType_GAUGE:
+		return prometheus.NewConstMetric(desc, prometheus.GaugeValue, metric.GetGauge().GetValue(), values...)
+	case dto.MetricType_HISTOGRAM:
+		histogram := metric.GetHistogram()
+		buckets := make(map[float64]uint64, len(histogram.GetBucket()))
+		for _, bucket := range histogram.GetBucket() {
+			// The +Inf bucket is implied by the count.
+			if !math.IsInf(bucket.GetUpperBound(), +1) {
+				buckets[bucket.GetUpperBound()] = bucket.GetCumulativeCount()
+			}
+		}
+
+		return prometheus.NewConstHistogram(desc, histogram.GetSampleCount(), histogram.GetSampleSum(), buckets, values...)
+	case dto.MetricType_SUMMARY:
+		summary := metric.GetSummary()
+		quantiles := make(map[float64]float64, len(summary.GetQuantile()))
+		for _, quantile := range summary.GetQuantile() {
+			quantiles[quantile.GetQuantile()] = quantile.GetValue()
+		}
+
+		return prometheus.NewConstSummary(desc, summary.GetSampleCount(), summary.GetSampleSum(), quantiles, values...)
+	default:
+		return prometheus.NewConstMetric(desc, prometheus.UntypedValue, metric.GetUntyped().GetValue(), values...)
+	}
+}
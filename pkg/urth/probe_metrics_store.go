@@ -0,0 +1,182 @@
+package urth
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sre-norns/wyrd/pkg/manifest"
+	"gorm.io/gorm"
+)
+
+// probeTargetStore reads the latest run of every target straight through gorm,
+// for the reason the placement load store does: "the newest run per scenario and
+// runner" is a grouped query the resource store cannot express.
+type probeTargetStore struct {
+	db *gorm.DB
+}
+
+// NewProbeTargetStore returns the probe metrics view of an existing database.
+func NewProbeTargetStore(db *gorm.DB) ProbeTargetStore {
+	return &probeTargetStore{db: db}
+}
+
+// latestRunKey is one target and when its newest finished run was due.
+type latestRunKey struct {
+	ScenarioID manifest.ResourceID
+	RunnerID   manifest.ResourceID
+	Latest     time.Time
+}
+
+// LatestProbeTargets implements ProbeTargetStore.
+//
+// Four reads rather than one join: the newest run per pair, the runs
+// themselves, and then their scenarios and metrics artifacts through
+// probeTargetsFor. The first is the only one that scans history; the cap is
+// applied to it, so that the rest -- and the artifact content in particular --
+// are only ever read for targets that will be reported.
+func (s *probeTargetStore) LatestProbeTargets(ctx context.Context, limit int) ([]ProbeTarget, int, error) {
+	db := s.db.WithContext(ctx).Session(&gorm.Session{SkipHooks: true})
+
+	var keys []latestRunKey
+	err := db.Model(&Result{}).
+		Select("scenario_id, status_executor_runner_id AS runner_id, MAX(created_at) AS latest").
+		Where("status_status IN ?", TerminalJobStates()).
+		// A run inside a maintenance window is no verdict on the target: the
+		// latest one before the window stands until the first one after it.
+		Where("COALESCE(status_maintenance, '') = ''").
+		// Deleted scenarios are not targets any more, whatever their history.
+		Where("scenario_id IN (?)", db.Model(&Scenario{}).Select("uid")).
+		Group("scenario_id, status_executor_runner_id").
+		Scan(&keys).Error
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to find the latest runs: %w", err)
+	}
+
+	total := len(keys)
+	if total == 0 {
+		return nil, 0, nil
+	}
+
+	// A stable order, so the targets that fall off a capped scrape are the same
+	// ones every time rather than a different random set per scrape.
+	slices.SortFunc(keys, func(x, y latestRunKey) int {
+		if c := strings.Compare(string(x.ScenarioID), string(y.ScenarioID)); c != 0 {
+			return c
+		}
+		return strings.Compare(string(x.RunnerID), string(y.RunnerID))
+	})
+	if limit > 0 && len(keys) > limit {
+		keys = keys[:limit]
+	}
+
+	tuples := make([][]any, 0, len(keys))
+	for _, key := range keys {
+		tuples = append(tuples, []any{key.ScenarioID, key.RunnerID, key.Latest})
+	}
+
+	var runs []Result
+	err = db.
+		Select(probeTargetRunColumns).
+		Where("(scenario_id, status_executor_runner_id, created_at) IN ?", tuples).
+		Where("status_status IN ?", TerminalJobStates()).
+		Where("COALESCE(status_maintenance, '') = ''").
+		Order("uid ASC").
+		Find(&runs).Error
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to read the latest runs: %w", err)
+	}
+
+	found, err := probeTargetsFor(db, runs)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// Two runs of one target due at the same instant would both match; the
+	// first one in UID order stands for it.
+	type targetKey struct{ scenario, runner manifest.ResourceID }
+	seen := make(map[targetKey]bool, len(found))
+
+	targets := make([]ProbeTarget, 0, len(found))
+	for _, target := range found {
+		key := targetKey{target.Run.Spec.ScenarioID, target.Run.Status.Executor.RunnerID}
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		targets = append(targets, target)
+	}
+
+	slices.SortFunc(targets, func(x, y ProbeTarget) int {
+		if c := strings.Compare(string(x.ScenarioName), string(y.ScenarioName)); c != 0 {
+			return c
+		}
+		return strings.Compare(string(x.Run.Status.Executor.RunnerName), string(y.Run.Status.Executor.RunnerName))
+	})
+
+	return targets, total, nil
+}
+
+// probeTargetRunColumns are the Result columns a probe target is reported from.
+var probeTargetRunColumns = []string{"uid", "created_at", "scenario_id", "prob_kind", "time_started", "time_ended",
+	"status_status", "status_result", "status_executor_runner_id", "status_executor_runner_name",
+	"status_upstream_failed"}
+
+// probeTargetsFor attaches to each run its scenario and its metrics artifact,
+// in the runs' order. A run whose scenario is gone is left out: there is
+// nothing left to name it by.
+func probeTargetsFor(db *gorm.DB, runs []Result) ([]ProbeTarget, error) {
+	if len(runs) == 0 {
+		return nil, nil
+	}
+
+	scenarioIDs := make([]manifest.ResourceID, 0, len(runs))
+	runIDs := make([]manifest.ResourceID, 0, len(runs))
+	for _, run := range runs {
+		scenarioIDs = append(scenarioIDs, run.Spec.ScenarioID)
+		runIDs = append(runIDs, run.UID)
+	}
+
+	var scenarios []Scenario
+	if err := db.Select("uid", "name", "labels").Where("uid IN ?", scenarioIDs).Find(&scenarios).Error; err != nil {
+		return nil, fmt.Errorf("failed to read probed scenarios: %w", err)
+	}
+	scenariosByID := make(map[manifest.ResourceID]Scenario, len(scenarios))
+	for _, scenario := range scenarios {
+		scenariosByID[scenario.UID] = scenario
+	}
+
+	var artifacts []Artifact
+	err := db.
+		Select("uid", "result_id", "mime_type", "content").
+		Where("result_id IN ?", runIDs).
+		Where("rel = ?", MetricsArtifactRel).
+		Find(&artifacts).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metrics artifacts: %w", err)
+	}
+	metricsByRun := make(map[manifest.ResourceID]*ArtifactSpec, len(artifacts))
+	for i := range artifacts {
+		metricsByRun[artifacts[i].Spec.ResultID] = &artifacts[i].Spec
+	}
+
+	targets := make([]ProbeTarget, 0, len(runs))
+	for _, run := range runs {
+		scenario, ok := scenariosByID[run.Spec.ScenarioID]
+		if !ok {
+			continue
+		}
+
+		targets = append(targets, ProbeTarget{
+			ScenarioName:   scenario.Name,
+			ScenarioLabels: scenario.Labels,
+			Run:            run,
+			Metrics:        metricsByRun[run.UID],
+		})
+	}
+
+	return targets, nil
+}
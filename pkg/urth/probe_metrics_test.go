@@ -0,0 +1,239 @@
+package urth_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/urth/pkg/urth"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// The probe endpoint is read by dashboards built for blackbox_exporter, so what
+// is asserted here is the shape a dashboard depends on: one probe_success per
+// scenario and vantage point, labelled the same way every time, and nothing a
+// prober writes able to break the scrape or its cardinality.
+
+type fakeProbeTargets struct {
+	targets []urth.ProbeTarget
+}
+
+func (s fakeProbeTargets) LatestProbeTargets(_ context.Context, limit int) ([]urth.ProbeTarget, int, error) {
+	if len(s.targets) > limit {
+		return s.targets[:limit], len(s.targets), nil
+	}
+
+	return s.targets, len(s.targets), nil
+}
+
+// metricsArtifact encodes a registry the way a runner stores it.
+func metricsArtifact(t *testing.T, collectors ...prometheus.Collector) *urth.ArtifactSpec {
+	t.Helper()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors...)
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	format := expfmt.NewFormat(expfmt.TypeTextPlain)
+
+	var buf bytes.Buffer
+	encoder := expfmt.NewEncoder(&buf, format)
+	for _, family := range families {
+		require.NoError(t, encoder.Encode(family))
+	}
+
+	return &urth.ArtifactSpec{Artifact: prob.Artifact{Rel: urth.MetricsArtifactRel, MimeType: string(format), Content: buf.Bytes()}}
+}
+
+func probeTarget(scenario, runner string, outcome prob.RunStatus, metrics *urth.ArtifactSpec) urth.ProbeTarget {
+	started := time.Date(2024, time.March, 4, 12, 0, 0, 0, time.UTC)
+	ended := started.Add(1500 * time.Millisecond)
+
+	return urth.ProbeTarget{
+		ScenarioName:   manifest.ResourceName(scenario),
+		ScenarioLabels: manifest.Labels{"team": "edge", "app.kubernetes.io/name": scenario},
+		Run: urth.Result{
+			Spec: urth.ResultSpec{ProbKind: "http", TimeStarted: &started, TimeEnded: &ended},
+			Status: urth.ResultStatus{
+				Status:   urth.JobCompleted,
+				Result:   outcome,
+				Executor: urth.ExecutorRef{RunnerID: manifest.ResourceID(runner), RunnerName: manifest.ResourceName(runner)},
+			},
+		},
+		Metrics: metrics,
+	}
+}
+
+func gatherProbes(t *testing.T, collector *urth.ProbeCollector) map[string]*dto.MetricFamily {
+	t.Helper()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	byName := map[string]*dto.MetricFamily{}
+	for _, family := range families {
+		byName[family.GetName()] = family
+	}
+
+	return byName
+}
+
+func labelsOf(metric *dto.Metric) map[string]string {
+	result := map[string]string{}
+	for _, pair := range metric.GetLabel() {
+		result[pair.GetName()] = pair.GetValue()
+	}
+
+	return result
+}
+
+func TestProbeMetricsReportTheLatestRunPerTarget(t *testing.T) {
+	dnsLookup := prometheus.NewGauge(prometheus.GaugeOpts{Name: "probe_dns_lookup_time_seconds", Help: "DNS lookup time."})
+	dnsLookup.Set(0.02)
+	staleSuccess := prometheus.NewGauge(prometheus.GaugeOpts{Name: "probe_success", Help: "Displays whether or not the probe was a success"})
+	staleSuccess.Set(1)
+
+	collector := urth.NewProbeCollector(fakeProbeTargets{targets: []urth.ProbeTarget{
+		probeTarget("checkout", "eu-west", prob.RunFinishedSuccess, metricsArtifact(t, dnsLookup)),
+		// The artifact says success; the run says otherwise, and the run wins.
+		probeTarget("checkout", "us-east", prob.RunFinishedFailed, metricsArtifact(t, staleSuccess)),
+	}}, urth.WithProbeLabels("team", "app.kubernetes.io/name"))
+
+	families := gatherProbes(t, collector)
+
+	success := families["probe_success"]
+	require.NotNil(t, success)
+	require.Len(t, success.GetMetric(), 2)
+	for _, metric := range success.GetMetric() {
+		labels := labelsOf(metric)
+		require.Equal(t, "checkout", labels["scenario"])
+		require.Equal(t, "http", labels["kind"])
+		require.Equal(t, "edge", labels["label_team"])
+		require.Equal(t, "checkout", labels["label_app_kubernetes_io_name"])
+
+		want := 0.0
+		if labels["runner"] == "eu-west" {
+			want = 1
+		}
+		require.Equal(t, want, metric.GetGauge().GetValue(), "runner %s", labels["runner"])
+	}
+
+	duration := families["probe_duration_seconds"]
+	require.NotNil(t, duration)
+	require.InDelta(t, 1.5, duration.GetMetric()[0].GetGauge().GetValue(), 1e-9)
+
+	lookup := families["probe_dns_lookup_time_seconds"]
+	require.NotNil(t, lookup, "series from the stored artifact are exposed")
+	require.Equal(t, "eu-west", labelsOf(lookup.GetMetric()[0])["runner"])
+}
+
+// A failure behind a failing dependency is reported like any other, and flagged
+// beside it, so that an alert rule can page for the root cause alone.
+func TestProbeMetricsFlagFailuresBehindAFailingDependency(t *testing.T) {
+	consequential := probeTarget("checkout", "eu-west", prob.RunFinishedFailed, nil)
+	consequential.Run.Status.UpstreamFailed = "vpn-gateway"
+
+	collector := urth.NewProbeCollector(fakeProbeTargets{targets: []urth.ProbeTarget{
+		consequential,
+		probeTarget("checkout", "us-east", prob.RunFinishedFailed, nil),
+	}})
+
+	families := gatherProbes(t, collector)
+	require.Len(t, families["probe_success"].GetMetric(), 2)
+
+	flagged := families["probe_upstream_failed"].GetMetric()
+	require.Len(t, flagged, 1, "only the run behind a failing dependency is flagged")
+
+	labels := labelsOf(flagged[0])
+	require.Equal(t, "eu-west", labels["runner"])
+	require.Equal(t, "vpn-gateway", labels["upstream"])
+	require.Equal(t, 1.0, flagged[0].GetGauge().GetValue())
+}
+
+func TestProbeMetricsStayWithinTheirCaps(t *testing.T) {
+	noisy := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "probe_http_status", Help: "Status by URL."}, []string{"url", "scenario"})
+	for _, url := range []string{"/a", "/b", "/c"} {
+		noisy.WithLabelValues(url, "spoofed").Set(200)
+	}
+
+	collector := urth.NewProbeCollector(fakeProbeTargets{targets: []urth.ProbeTarget{
+		probeTarget("a", "eu", prob.RunFinishedSuccess, metricsArtifact(t, noisy)),
+		probeTarget("b", "eu", prob.RunFinishedSuccess, nil),
+	}}, urth.WithMaxProbeTargets(1), urth.WithMaxProbeArtifactSamples(2))
+
+	families := gatherProbes(t, collector)
+
+	require.Len(t, families["probe_success"].GetMetric(), 1)
+	require.Equal(t, 1.0, families["urth_probe_targets_unreported"].GetMetric()[0].GetGauge().GetValue())
+
+	statuses := families["probe_http_status"].GetMetric()
+	require.Len(t, statuses, 2)
+	require.Equal(t, 1.0, families["urth_probe_artifact_samples_unreported"].GetMetric()[0].GetGauge().GetValue())
+
+	// A prober cannot relabel which scenario it reports for.
+	labels := labelsOf(statuses[0])
+	require.Equal(t, "a", labels["scenario"])
+	require.Equal(t, "spoofed", labels["exported_scenario"])
+}
+
+// The grouped read against the real schema: the newest finished run of each
+// target, with the metrics artifact of that run and not of an older one.
+func TestLatestProbeTargetsReadsTheNewestFinishedRun(t *testing.T) {
+	_, db, store := newTestService(t, &stubScheduler{})
+	ctx := context.Background()
+
+	scenario := seedOpenScenario(t, store, "probed")
+
+	newRun := func(name string, status urth.JobStatus, outcome prob.RunStatus) urth.Result {
+		run := urth.Result{
+			ObjectMeta: manifest.ObjectMeta{Name: manifest.ResourceName(name)},
+			Spec:       urth.ResultSpec{ScenarioID: scenario.UID, ProbKind: "http"},
+			Status: urth.ResultStatus{
+				Status:   status,
+				Result:   outcome,
+				Executor: urth.ExecutorRef{RunnerID: firstRunnerUID, RunnerName: "eu"},
+			},
+		}
+		require.NoError(t, store.Create(ctx, &run))
+
+		artifact := urth.Artifact{
+			ObjectMeta: manifest.ObjectMeta{Name: manifest.ResourceName(name + "-metrics")},
+			Spec: urth.ArtifactSpec{
+				ResultID: run.UID,
+				Artifact: prob.Artifact{Rel: urth.MetricsArtifactRel, MimeType: "text/plain", Content: []byte(name)},
+			},
+		}
+		require.NoError(t, store.Create(ctx, &artifact))
+
+		return run
+	}
+
+	newRun("older", urth.JobCompleted, prob.RunFinishedFailed)
+	time.Sleep(10 * time.Millisecond)
+	latest := newRun("latest", urth.JobCompleted, prob.RunFinishedSuccess)
+	time.Sleep(10 * time.Millisecond)
+	// Still running: it has no outcome to report yet.
+	newRun("running", urth.JobRunning, prob.RunNotFinished)
+
+	targets, total, err := urth.NewProbeTargetStore(db).LatestProbeTargets(ctx, 10)
+	require.NoError(t, err)
+	require.Equal(t, 1, total)
+	require.Len(t, targets, 1)
+
+	require.Equal(t, manifest.ResourceName("probed"), targets[0].ScenarioName)
+	require.Equal(t, latest.UID, targets[0].Run.UID)
+	require.NotNil(t, targets[0].Metrics)
+	require.Equal(t, "latest", string(targets[0].Metrics.Content))
+}
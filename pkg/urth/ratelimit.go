@@ -0,0 +1,517 @@
+package urth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sre-norns/wyrd/pkg/dbstore"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+
+	"github.com/sre-norns/urth/pkg/prob"
+)
+
+// Rate limits: a ceiling on how hard the fleet as a whole probes one target.
+//
+// Every other limit in the system is per scenario or per worker, and none of
+// them sees that twelve scenarios, each modest, all probe the same upstream, or
+// that a fan-out across five regions turns one trigger into five requests in the
+// same second. Enough of that and the target's WAF decides it is under attack,
+// and every check behind it goes red together for a reason that is entirely
+// the checks' own doing.
+//
+// A limit selects what it protects by target host, by scenario label, or both,
+// and bounds two things: how many runs may start in any Interval (MaxRuns), and
+// how many may be running at once (MaxConcurrent). It is enforced twice:
+//
+//   - where every run begins, resultsAPIImpl.Create. A run that would take the
+//     count of runs admitted in the last Interval past MaxRuns is recorded as
+//     skipped, like a trigger the concurrency policy declines. Dropped rather
+//     than queued, because a schedule that asks for more than the budget every
+//     interval would otherwise build a backlog with no end.
+//   - where a run starts, ClaimRun. A run already admitted may still arrive in a
+//     burst -- a runner back from an outage drains its whole queue at once -- so
+//     a claim that would exceed either bound is refused as ClaimThrottled, and
+//     the server re-queues the run for later under a new version; see deferRun.
+//     The run stays pending and nothing about it fails.
+//
+// A limit, not a lock. The counts are read, not reserved, so claims racing each
+// other can overshoot MaxConcurrent by however many of them raced. For keeping
+// a WAF calm that is close enough, and it costs no lock on the claim path.
+//
+// The run being decided is judged by what it will actually probe: its
+// snapshot's target. The load it is measured against is counted over the
+// scenarios the limit covers as they are now -- a limit describes what a target
+// can take today, and a run created last week against a host that has since
+// been limited is still load on it.
+
+// KindRateLimit is the resource kind for rate limits.
+const KindRateLimit manifest.Kind = "rateLimits"
+
+// RateLimitSpec is what a limit protects and how much it lets through.
+type RateLimitSpec struct {
+	// Description is a human readable text to say what the limit protects.
+	Description string `form:"description" json:"description,omitempty" yaml:"description,omitempty" xml:"description,omitempty"`
+
+	// Hosts are the target hosts the limit covers: a scenario is covered when
+	// the host its prob targets is one of these. A leading "*." covers every
+	// subdomain, so "*.example.com" takes in api.example.com but not
+	// example.com itself. Empty covers any host.
+	Hosts []string `form:"hosts" json:"hosts,omitempty" yaml:"hosts,omitempty" xml:"hosts,omitempty" gorm:"serializer:json"`
+
+	// Scenarios selects the scenarios the limit covers, for probes -- scripted
+	// ones, mostly -- whose target is not a single host to name. Empty covers
+	// any scenario.
+	Scenarios manifest.LabelSelector `form:"scenarios" json:"scenarios,omitempty" yaml:"scenarios,omitempty" xml:"scenarios,omitempty" gorm:"serializer:json"`
+
+	// MaxRuns is how many covered runs may start in any Interval. Zero leaves
+	// the rate unbounded.
+	MaxRuns int `form:"maxRuns" json:"maxRuns,omitempty" yaml:"maxRuns,omitempty" xml:"maxRuns,omitempty"`
+
+	// Interval is the sliding window MaxRuns is counted over.
+	Interval time.Duration `form:"interval" json:"interval,omitempty" yaml:"interval,omitempty" xml:"interval,omitempty"`
+
+	// MaxConcurrent is how many covered runs may be running at once. Zero
+	// leaves concurrency unbounded.
+	MaxConcurrent int `form:"maxConcurrent" json:"maxConcurrent,omitempty" yaml:"maxConcurrent,omitempty" xml:"maxConcurrent,omitempty"`
+}
+
+// RateLimitStatus is the limit's load as of the moment it was read. Nothing
+// here is stored.
+type RateLimitStatus struct {
+	// Scenarios is how many scenarios the limit currently covers.
+	Scenarios int `json:"scenarios" yaml:"scenarios" gorm:"-"`
+
+	// Started is how many covered runs started in the last Interval.
+	Started int `json:"started" yaml:"started" gorm:"-"`
+
+	// Running is how many covered runs are running now.
+	Running int `json:"running" yaml:"running" gorm:"-"`
+
+	// Throttling is true while a claim of a covered run would be deferred.
+	Throttling bool `json:"throttling" yaml:"throttling" gorm:"-"`
+}
+
+// RateLimit bounds how hard the fleet probes a target.
+type RateLimit manifest.StatefulResource[RateLimitSpec, RateLimitStatus]
+
+// ToManifest renders the resource for the API.
+func (r RateLimit) ToManifest() manifest.ResourceManifest {
+	return manifest.ToManifestWithStatus(manifest.StatefulResource[RateLimitSpec, RateLimitStatus](r))
+}
+
+// NewRateLimit converts a manifest into the model.
+func NewRateLimit(m manifest.ResourceManifest) (RateLimit, error) {
+	e, err := manifest.ManifestAsStatefulResource[RateLimitSpec, RateLimitStatus](m)
+	entry := RateLimit(e)
+	if err != nil {
+		return entry, fmt.Errorf("failed to convert resource manifest into a RateLimit model: %w", err)
+	}
+
+	if entry.ObjectMeta.Name == "" {
+		return entry, ErrResourceNameEmpty
+	}
+
+	if err := entry.ObjectMeta.Validate(); err != nil {
+		return entry, err
+	}
+
+	return entry, nil
+}
+
+// Validate refuses a limit that can be stored but would never do what its
+// manifest says.
+//
+// A limit that selects nothing in particular is refused too: with neither hosts
+// nor a selector it covers every scenario in the fleet, and a ceiling on all
+// probing is a mistake far more often than it is meant.
+func (s RateLimitSpec) Validate() error {
+	if len(s.Hosts) == 0 && len(s.Scenarios.MatchLabels) == 0 && len(s.Scenarios.MatchSelector) == 0 {
+		return fmt.Errorf("a rate limit needs hosts, a scenario selector or both")
+	}
+
+	for _, host := range s.Hosts {
+		if normalizeHost(strings.TrimPrefix(host, "*.")) == "" {
+			return fmt.Errorf("rate limit host %q is empty", host)
+		}
+	}
+
+	if _, err := s.Scenarios.AsSelector(); err != nil {
+		return fmt.Errorf("rate limit's scenario selector is invalid: %w", err)
+	}
+
+	if s.MaxRuns < 0 || s.MaxConcurrent < 0 {
+		return fmt.Errorf("rate limit bounds must not be negative")
+	}
+
+	if s.MaxRuns == 0 && s.MaxConcurrent == 0 {
+		return fmt.Errorf("a rate limit needs maxRuns, maxConcurrent or both")
+	}
+
+	if s.MaxRuns > 0 && s.Interval <= 0 {
+		return fmt.Errorf("rate limit interval must be positive when maxRuns is set, got %v", s.Interval)
+	}
+
+	return nil
+}
+
+// coversHost reports whether a target host is one of the limit's.
+func (s RateLimitSpec) coversHost(host string) bool {
+	if len(s.Hosts) == 0 {
+		return true
+	}
+	if host == "" {
+		return false
+	}
+
+	for _, pattern := range s.Hosts {
+		if domain, wildcard := strings.CutPrefix(pattern, "*."); wildcard {
+			if strings.HasSuffix(host, "."+normalizeHost(domain)) {
+				return true
+			}
+			continue
+		}
+
+		if normalizeHost(pattern) == host {
+			return true
+		}
+	}
+
+	return false
+}
+
+// covers reports whether the limit applies to runs of a scenario with these
+// labels, probing this host.
+func (r RateLimit) covers(labels manifest.Labels, host string) bool {
+	scenarios, err := r.Spec.Scenarios.AsSelector()
+	if err != nil || (!scenarios.Empty() && !scenarios.Matches(labels)) {
+		return false
+	}
+
+	return r.Spec.coversHost(host)
+}
+
+// exceededBy says which of the limit's bounds a run would break, given the
+// load already on it; empty when it would break none.
+//
+// `admitting` selects the question: at creation only the rate is asked, of the
+// runs admitted in the interval, since a run waiting in a queue is not yet load
+// on anything. At claim both bounds are asked, of the runs that actually
+// started.
+func (r RateLimit) exceededBy(rate RunRate, admitting bool) string {
+	switch {
+	case admitting && r.Spec.MaxRuns > 0 && rate.Admitted >= r.Spec.MaxRuns:
+		return fmt.Sprintf("%d runs admitted in the last %v, limit %d", rate.Admitted, r.Spec.Interval, r.Spec.MaxRuns)
+	case admitting:
+		return ""
+	case r.Spec.MaxRuns > 0 && rate.Started >= r.Spec.MaxRuns:
+		return fmt.Sprintf("%d runs started in the last %v, limit %d", rate.Started, r.Spec.Interval, r.Spec.MaxRuns)
+	case r.Spec.MaxConcurrent > 0 && rate.Running >= r.Spec.MaxConcurrent:
+		return fmt.Sprintf("%d runs running, limit %d", rate.Running, r.Spec.MaxConcurrent)
+	default:
+		return ""
+	}
+}
+
+// ProbeTargetHost is the host a prob sends its traffic to, or empty when it
+// does not name one.
+//
+// Read from the prob's `target`, which is what every single-target prober calls
+// it; a scripted prob reaches whatever its script does, and is covered by
+// label instead. The target may be a URL, a host and port or a bare host, and
+// is compared in lower case, since DNS names are.
+func ProbeTargetHost(p prob.Manifest) string {
+	raw, err := json.Marshal(p)
+	if err != nil {
+		return ""
+	}
+
+	var target struct {
+		Spec struct {
+			Target string `json:"target"`
+		} `json:"spec"`
+	}
+	if err := json.Unmarshal(raw, &target); err != nil {
+		return ""
+	}
+
+	return normalizeHost(target.Spec.Target)
+}
+
+// normalizeHost reduces a target to its host name.
+func normalizeHost(target string) string {
+	target = strings.TrimSpace(target)
+	if strings.Contains(target, "://") {
+		if u, err := url.Parse(target); err == nil {
+			target = u.Hostname()
+		}
+	} else if host, _, err := net.SplitHostPort(target); err == nil {
+		target = host
+	}
+
+	return strings.TrimSuffix(strings.ToLower(strings.Trim(target, "[]")), ".")
+}
+
+// RunRate is the covered load a limit is measured against.
+type RunRate struct {
+	// Admitted is runs created in the interval that were, or still may be,
+	// executed. A trigger skipped or never placed sent nothing anywhere.
+	Admitted int
+
+	// Started is runs claimed in the interval.
+	Started int
+
+	// Running is runs executing now.
+	Running int
+}
+
+// boundRateLimit is a limit together with the scenarios it covers right now.
+type boundRateLimit struct {
+	RateLimit
+
+	scenarios []manifest.ResourceID
+}
+
+// rateLimitSnapshot is the rate limits table as coveringRateLimits and
+// withStatus judge it, with the scenarios each limit covers worked out once.
+//
+// Scenarios are part of it, and a write to one drops it too: a limit covers a
+// scenario by its labels and the host inside its prob, and the covered set is
+// what every claim measures the load of.
+type rateLimitSnapshot struct {
+	// limits are in name order, the order a run is judged against them.
+	limits []RateLimit
+
+	// targets is every scenario, as a limit covers it. Left empty when there
+	// are no limits, so a fleet without any reads one small table.
+	targets []rateLimitTarget
+
+	// covered is each limit's covered-scenario set, by the limit's UID.
+	covered map[manifest.ResourceID][]manifest.ResourceID
+}
+
+// rateLimitTarget is what a limit judges a scenario by.
+type rateLimitTarget struct {
+	uid    manifest.ResourceID
+	labels manifest.Labels
+	host   string
+}
+
+// coveredBy returns the scenarios limit covers: from the snapshot when it
+// holds this version of the limit, or worked out from the targets when it does
+// not -- a limit written since, whose write has not dropped the snapshot yet.
+func (s rateLimitSnapshot) coveredBy(limit RateLimit) []manifest.ResourceID {
+	for _, known := range s.limits {
+		if known.UID == limit.UID && known.Version == limit.Version {
+			return s.covered[limit.UID]
+		}
+	}
+
+	return limit.coveredAmong(s.targets)
+}
+
+func (r RateLimit) coveredAmong(targets []rateLimitTarget) []manifest.ResourceID {
+	var covered []manifest.ResourceID
+	for _, target := range targets {
+		if r.covers(target.labels, target.host) {
+			covered = append(covered, target.uid)
+		}
+	}
+
+	return covered
+}
+
+// newRateLimitLoader returns the loader of rateLimitSnapshot.
+//
+// A scenario's host is found by encoding its prob, which is not free, and a
+// snapshot is rebuilt on every scenario write. So the hosts are remembered
+// across snapshots in hosts, per version of each scenario, and only a scenario
+// edited since is encoded again.
+func newRateLimitLoader(hosts *scenarioMemo[string]) func(context.Context, dbstore.TransactionalStore) (rateLimitSnapshot, error) {
+	return func(ctx context.Context, store dbstore.TransactionalStore) (rateLimitSnapshot, error) {
+		var limits []RateLimit
+		if _, err := store.Find(ctx, &limits, manifest.SearchQuery{}); err != nil {
+			return rateLimitSnapshot{}, fmt.Errorf("failed to list rate limits: %w", err)
+		}
+		if len(limits) == 0 {
+			return rateLimitSnapshot{}, nil
+		}
+
+		slices.SortFunc(limits, func(x, y RateLimit) int {
+			return strings.Compare(string(x.Name), string(y.Name))
+		})
+
+		var scenarios []Scenario
+		if _, err := store.Find(ctx, &scenarios, manifest.SearchQuery{}); err != nil {
+			return rateLimitSnapshot{}, fmt.Errorf("failed to list scenarios for rate limits: %w", err)
+		}
+
+		snapshot := rateLimitSnapshot{
+			limits:  limits,
+			targets: make([]rateLimitTarget, 0, len(scenarios)),
+			covered: make(map[manifest.ResourceID][]manifest.ResourceID, len(limits)),
+		}
+		for _, scenario := range scenarios {
+			snapshot.targets = append(snapshot.targets, rateLimitTarget{
+				uid:    scenario.UID,
+				labels: scenario.Labels,
+				host:   hosts.get(scenario, scenarioTargetHost),
+			})
+		}
+		hosts.retain(scenarios)
+
+		for _, limit := range limits {
+			snapshot.covered[limit.UID] = limit.coveredAmong(snapshot.targets)
+		}
+
+		return snapshot, nil
+	}
+}
+
+func scenarioTargetHost(scenario Scenario) string {
+	return ProbeTargetHost(scenario.Spec.Prob)
+}
+
+// coveringRateLimits finds the limits that apply to a run of `scenario`
+// probing `host`, each with every scenario it covers.
+//
+// Judged in full, like maintenance windows: a fleet has a handful of limits,
+// and whether one covers a scenario depends on the host inside its prob, which
+// no query can see. They are read from the service's policy cache rather than
+// on every run; see policyCaches.
+//
+// The run's own scenario is judged as the run has it, not as the snapshot
+// does: the run carries the prob it will execute.
+func coveringRateLimits(ctx context.Context, store dbstore.TransactionalStore, cache *policyCache[rateLimitSnapshot], scenario Scenario, host string) ([]boundRateLimit, error) {
+	snapshot, err := cache.get(ctx, store)
+	if err != nil {
+		return nil, err
+	}
+
+	var bound []boundRateLimit
+	for _, limit := range snapshot.limits {
+		if !limit.covers(scenario.Labels, host) {
+			continue
+		}
+
+		entry := boundRateLimit{RateLimit: limit, scenarios: []manifest.ResourceID{scenario.UID}}
+		for _, other := range snapshot.covered[limit.UID] {
+			if other != scenario.UID {
+				entry.scenarios = append(entry.scenarios, other)
+			}
+		}
+
+		bound = append(bound, entry)
+	}
+
+	return bound, nil
+}
+
+// exceededRateLimit finds the first limit a run of `scenario` probing `host`
+// would break, and why. A server without a RunRateStore enforces nothing.
+func exceededRateLimit(ctx context.Context, store dbstore.TransactionalStore, cache *policyCache[rateLimitSnapshot], rates RunRateStore, scenario Scenario, host string, admitting bool, at time.Time) (*RateLimit, string, error) {
+	if rates == nil {
+		return nil, "", nil
+	}
+
+	limits, err := coveringRateLimits(ctx, store, cache, scenario, host)
+	if err != nil {
+		return nil, "", err
+	}
+
+	for i := range limits {
+		rate, err := rates.RunRate(ctx, limits[i].scenarios, at.Add(-limits[i].Spec.Interval))
+		if err != nil {
+			return nil, "", err
+		}
+
+		if reason := limits[i].exceededBy(rate, admitting); reason != "" {
+			return &limits[i].RateLimit, reason, nil
+		}
+	}
+
+	return nil, "", nil
+}
+
+// throttle refuses a claim that would break a rate limit on the run's target,
+// deferring the run.
+//
+// A store failure is ClaimUnavailable, as everywhere on the claim path: the run
+// is still pending and the dispatch must come back, whether or not it turns out
+// to be within its limits.
+func (m *resultsAPIImpl) throttle(ctx context.Context, run Result) error {
+	if m.rates == nil {
+		return nil
+	}
+
+	// A scenario deleted since the run was created leaves no labels to match,
+	// and the run is judged by its host alone.
+	var scenario Scenario
+	if _, err := m.store.GetByUID(ctx, &scenario, run.Spec.Execution.ScenarioUID); err != nil {
+		return claimUnavailable("load scenario for rate limits", err)
+	}
+	scenario.UID = run.Spec.Execution.ScenarioUID
+
+	limit, reason, err := exceededRateLimit(ctx, m.store, m.policies.rateLimits, m.rates, scenario,
+		ProbeTargetHost(run.Spec.Execution.Prob), false, time.Now())
+	if err != nil {
+		return claimUnavailable("measure rate limits", err)
+	}
+
+	if limit != nil {
+		return m.deferRun(ctx, run, fmt.Sprintf("rate limit %q: %s", limit.Name, reason))
+	}
+
+	return nil
+}
+
+// applyRateLimit records the limit that kept a run from being admitted, and
+// skips it.
+func applyRateLimit(result *Result, limit RateLimit, reason string, at time.Time) {
+	log.Printf("run %q of %q skipped: rate limit %q: %s", result.Name, result.Spec.Scenario.Name, limit.Name, reason)
+
+	putLabel(result.Labels, LabelResultRateLimit, string(limit.Name))
+	skipRun(result, ReasonRateLimited, at)
+}
+
+// withStatus fills in the computed status as of `at`.
+//
+// Measured per limit rather than per run, so Throttling answers "would a run
+// covered by nothing but this limit be deferred now" -- which is the question
+// asked of a limit while a target is being protected by it.
+func (r RateLimit) withStatus(ctx context.Context, store dbstore.TransactionalStore, cache *policyCache[rateLimitSnapshot], rates RunRateStore, at time.Time) RateLimit {
+	r.Status = RateLimitStatus{}
+	if rates == nil {
+		return r
+	}
+
+	snapshot, err := cache.get(ctx, store)
+	if err != nil {
+		log.Printf("rate limit %q: failed to list the scenarios it covers: %v", r.Name, err)
+		return r
+	}
+
+	covered := snapshot.coveredBy(r)
+	r.Status.Scenarios = len(covered)
+	if len(covered) == 0 {
+		return r
+	}
+
+	rate, err := rates.RunRate(ctx, covered, at.Add(-r.Spec.Interval))
+	if err != nil {
+		log.Printf("rate limit %q: failed to measure its load: %v", r.Name, err)
+		return r
+	}
+
+	r.Status.Started = rate.Started
+	r.Status.Running = rate.Running
+	r.Status.Throttling = r.exceededBy(rate, false) != ""
+
+	return r
+}
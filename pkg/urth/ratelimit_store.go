@@ -0,0 +1,69 @@
+package urth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sre-norns/wyrd/pkg/manifest"
+	"gorm.io/gorm"
+)
+
+// RunRateStore measures the load a set of scenarios is putting on whatever they
+// probe, for RateLimit.
+//
+// Its own interface over gorm for the reason RunnerLoadStore is one: "how many
+// runs of these scenarios started since then" is a counting query across
+// scenarios that the resource store, addressed by UID and label, cannot
+// express.
+type RunRateStore interface {
+	// RunRate counts the runs of `scenarios` admitted and started since
+	// `since`, and those running now.
+	RunRate(ctx context.Context, scenarios []manifest.ResourceID, since time.Time) (RunRate, error)
+}
+
+// runRateStore reads run counts straight from the results table.
+type runRateStore struct {
+	db *gorm.DB
+}
+
+// NewRunRateStore returns a rate store over an existing database handle, in
+// the shape NewRunnerLoadStore established.
+func NewRunRateStore(db *gorm.DB) RunRateStore {
+	return &runRateStore{db: db}
+}
+
+func (s *runRateStore) RunRate(ctx context.Context, scenarios []manifest.ResourceID, since time.Time) (RunRate, error) {
+	var rate RunRate
+	if len(scenarios) == 0 {
+		return rate, nil
+	}
+
+	running := []JobStatus{JobRunning, JobCancelling}
+
+	// One pass, three tallies. Hooks skipped for the reason runnerLoadStore
+	// skips them: nothing here is a Result, only counts of them.
+	//
+	// A run counts as admitted if it is still to execute or did execute. One
+	// skipped by a policy, or never placed, is finished without ever having
+	// started, and sent nothing to the target.
+	err := s.db.WithContext(ctx).
+		Session(&gorm.Session{SkipHooks: true}).
+		Model(&Result{}).
+		Select(`COALESCE(SUM(CASE WHEN created_at >= ? AND (status_status IN ? OR time_started IS NOT NULL) THEN 1 ELSE 0 END), 0) AS admitted,
+			COALESCE(SUM(CASE WHEN time_started >= ? THEN 1 ELSE 0 END), 0) AS started,
+			COALESCE(SUM(CASE WHEN status_status IN ? THEN 1 ELSE 0 END), 0) AS running`,
+			since, []JobStatus{JobPending, JobRunning, JobCancelling}, since, running).
+		Where("deleted_at IS NULL").
+		Where("scenario_id IN ?", scenarios).
+		// Nothing older than the window can count toward it, unless it is still
+		// running -- which bounds the scan to recent history and the runs in
+		// flight.
+		Where("created_at >= ? OR time_started >= ? OR status_status IN ?", since, since, running).
+		Scan(&rate).Error
+	if err != nil {
+		return rate, fmt.Errorf("failed to count runs for a rate limit: %w", err)
+	}
+
+	return rate, nil
+}
@@ -0,0 +1,53 @@
+package urth_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/urth/pkg/urth"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// Every single-target prober calls it `target`, and writes it differently: a
+// URL, a host and port, or a bare name. A limit on a host has to catch all
+// three.
+func TestProbeTargetHostReducesATargetToItsHost(t *testing.T) {
+	for target, want := range map[string]string{
+		"https://API.example.com:8443/health?x=1": "api.example.com",
+		"api.example.com:443":                     "api.example.com",
+		"api.example.com.":                        "api.example.com",
+		"10.0.0.7":                                "10.0.0.7",
+		"[2001:db8::1]:53":                        "2001:db8::1",
+		"":                                        "",
+	} {
+		host := urth.ProbeTargetHost(prob.Manifest{Kind: "http", Spec: map[string]any{"target": target}})
+		require.Equal(t, want, host, target)
+	}
+
+	require.Empty(t, urth.ProbeTargetHost(prob.Manifest{Kind: "puppeteer", Spec: map[string]any{"script": "..."}}),
+		"a scripted prob names no target")
+}
+
+func TestRateLimitIsValidated(t *testing.T) {
+	hosts := []string{"api.example.com"}
+	require.NoError(t, urth.RateLimitSpec{Hosts: hosts, MaxConcurrent: 2}.Validate())
+	require.NoError(t, urth.RateLimitSpec{Hosts: hosts, MaxRuns: 10, Interval: time.Minute}.Validate())
+	require.NoError(t, urth.RateLimitSpec{
+		Scenarios:     manifest.LabelSelector{MatchLabels: manifest.Labels{"upstream": "gateway"}},
+		MaxConcurrent: 1,
+	}.Validate())
+
+	for name, spec := range map[string]urth.RateLimitSpec{
+		"covers everything":     {MaxConcurrent: 1},
+		"bounds nothing":        {Hosts: hosts},
+		"rate with no interval": {Hosts: hosts, MaxRuns: 10},
+		"negative bound":        {Hosts: hosts, MaxConcurrent: -1},
+		"blank host":            {Hosts: []string{" "}, MaxConcurrent: 1},
+		"bare wildcard":         {Hosts: []string{"*."}, MaxConcurrent: 1},
+	} {
+		require.Error(t, spec.Validate(), name)
+	}
+}
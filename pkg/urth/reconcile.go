@@ -43,6 +43,12 @@ const (
 	// merely slow.
 	DefaultPendingDispatchTimeout = 90 * time.Minute
 
+	// DefaultOrphanChannelGrace is how old a runner queue with no runner has to
+	// be before it is taken down. It is there for the queue created a moment
+	// before its runner is committed, and for the operator who deletes a runner
+	// and applies it again as two commands a few minutes apart.
+	DefaultOrphanChannelGrace = 15 * time.Minute
+
 	// ReconcileScanLeaseName names the lease row guarding the scan.
 	ReconcileScanLeaseName = "dispatch-reconcile"
 )
@@ -56,6 +62,10 @@ const (
 // reconciler does not spend a database round trip per candidate discovering
 // that.
 //
+// The table holds one row per control loop that wants a single scanner at a
+// time, named by the loop: ReconcileScanLeaseName here, RetentionSweepLeaseName
+// for the retention sweep.
+//
 // Time columns carry no explicit `type:` tag, for the reason given on
 // DispatchOutboxEntry: gorm's Postgres driver already maps time.Time to
 // timestamptz, and naming it forces it on SQLite where the driver cannot scan
@@ -102,6 +112,13 @@ type PendingRun struct {
 	Dispatch *DispatchOutboxEntry
 }
 
+// RunnerKind names the workers a pending run waits for: its runner's, able to
+// run its prob kind.
+type RunnerKind struct {
+	RunnerUID manifest.ResourceID
+	Kind      prob.Kind
+}
+
 // StaleDispatch is an outbox entry whose Result no longer wants it.
 type StaleDispatch struct {
 	Entry DispatchOutboxEntry
@@ -131,6 +148,16 @@ type ReconcileStore interface {
 	// ExpiredRuns lists running Results whose execution lease elapsed before cutoff.
 	ExpiredRuns(ctx context.Context, cutoff time.Time, limit int) ([]Result, error)
 
+	// UnclaimedRuns lists pending Results whose claim deadline passed before
+	// now, other than those waiting for one of `waiting`. See
+	// OnlineCapacityFailFastAfter.
+	UnclaimedRuns(ctx context.Context, now time.Time, waiting []RunnerKind, limit int) ([]Result, error)
+
+	// OnlineWorkers counts the workers of a runner that are online and able to
+	// run a prob kind, grading presence with offlineAfter. A runner that no
+	// longer exists has none.
+	OnlineWorkers(ctx context.Context, of RunnerKind, offlineAfter time.Duration) (int, error)
+
 	// StalePendingRuns lists Results still pending since before cutoff, each with
 	// the outbox entry written for its current version, if there is one.
 	StalePendingRuns(ctx context.Context, cutoff time.Time, limit int) ([]PendingRun, error)
@@ -159,6 +186,22 @@ type ReconcileStore interface {
 	// ActiveRunnerUIDs lists the runners that should have a live queue.
 	ActiveRunnerUIDs(ctx context.Context) ([]manifest.ResourceID, error)
 
+	// RunnerUIDs lists every runner that exists, disabled and terminating ones
+	// included. The orphan sweep reads an absence from it, so it returns the
+	// whole table or an error -- never a partial list.
+	RunnerUIDs(ctx context.Context) ([]manifest.ResourceID, error)
+
+	// TerminatingRunners lists runners marked for deletion, oldest first.
+	TerminatingRunners(ctx context.Context, limit int) ([]Runner, error)
+
+	// UnfinishedRunsOn lists the Results placed on a runner that are not yet
+	// terminal.
+	UnfinishedRunsOn(ctx context.Context, runnerUID manifest.ResourceID, limit int) ([]Result, error)
+
+	// RemoveRunner deletes a runner whose teardown is complete. It reports false
+	// when the runner changed between the scan reading it and this write.
+	RemoveRunner(ctx context.Context, runner Runner) (bool, error)
+
 	// SilentWorkers lists registrations quiet on every liveness signal since
 	// before cutoff. Workers that have never reported at all are excluded --
 	// they are `unknown`, not offline, and there is no evidence to act on.
@@ -166,6 +209,11 @@ type ReconcileStore interface {
 
 	// DropWorker revokes one worker's registration.
 	DropWorker(ctx context.Context, worker WorkerInstance) (bool, error)
+
+	// PruneEnrolmentTokens deletes up to limit records of enrolment tokens that
+	// expired before cutoff and that no registered worker came in with,
+	// reporting how many it deleted.
+	PruneEnrolmentTokens(ctx context.Context, cutoff time.Time, limit int) (int, error)
 }
 
 // RunnerChannelReconciler is the transport's half of reconciliation.
@@ -186,6 +234,24 @@ type RunnerChannelReconciler interface {
 	// DropDispatch withdraws a published message that is no longer wanted.
 	// Withdrawing something already gone is not an error.
 	DropDispatch(ctx context.Context, entry DispatchOutboxEntry) error
+
+	// RemoveRunnerChannel takes a runner's queue assets down, discarding
+	// anything still queued on them. Removing what is already gone is not an
+	// error.
+	RemoveRunnerChannel(ctx context.Context, runnerUID manifest.ResourceID) error
+
+	// RunnerChannels lists every runner queue the transport holds. Like
+	// ReconcileStore.RunnerUIDs it is complete or it is an error.
+	RunnerChannels(ctx context.Context) ([]RunnerChannel, error)
+}
+
+// RunnerChannel is one runner queue as the transport holds it.
+type RunnerChannel struct {
+	RunnerUID manifest.ResourceID
+
+	// CreatedAt is when the transport created the queue. The orphan sweep
+	// leaves young queues alone; see DefaultOrphanChannelGrace.
+	CreatedAt time.Time
 }
 
 // ReconcileReport is what one scan did, and is the reconciler's observability
@@ -226,6 +292,25 @@ type ReconcileReport struct {
 	// liveness signal for longer than the retention window.
 	EvictedWorkers int `json:"evictedWorkers" yaml:"evictedWorkers"`
 
+	// ExpiredOrphaned counts pending runs expired because the runner they were
+	// placed on was deleted.
+	ExpiredOrphaned int `json:"expiredOrphaned" yaml:"expiredOrphaned"`
+
+	// ExpiredUnclaimed counts pending runs expired because nothing claimed them
+	// by the deadline their scenario's fail-fast-after policy set.
+	ExpiredUnclaimed int `json:"expiredUnclaimed" yaml:"expiredUnclaimed"`
+
+	// RemovedRunners counts deleted runners whose teardown finished and whose
+	// resource was removed.
+	RemovedRunners int `json:"removedRunners" yaml:"removedRunners"`
+
+	// RemovedChannels counts runner queues taken down: a deleted runner's, and
+	// any left behind by a runner that no longer exists.
+	RemovedChannels int `json:"removedChannels" yaml:"removedChannels"`
+
+	// PrunedTokens counts records of expired enrolment tokens deleted.
+	PrunedTokens int `json:"prunedTokens" yaml:"prunedTokens"`
+
 	// Failures counts repairs that were attempted and did not land. A scan
 	// continues past them: one unreachable broker is no reason to leave every
 	// expired lease in place.
@@ -241,7 +326,8 @@ type ReconcileReport struct {
 func (r ReconcileReport) Repaired() int {
 	return r.ExpiredRunning + r.ExpiredPending + r.Redispatched +
 		r.RetiredDispatches + r.DroppedMessages + r.ReleasedLeases + r.RestoredChannels +
-		r.EvictedWorkers
+		r.EvictedWorkers + r.ExpiredOrphaned + r.ExpiredUnclaimed + r.RemovedRunners + r.RemovedChannels +
+		r.PrunedTokens
 }
 
 // ReconcileStatus is the reconciler's own health, as distinct from what any one
@@ -281,6 +367,8 @@ type Reconciler struct {
 	pendingTimeout  time.Duration
 	leaseGrace      time.Duration
 	workerRetention time.Duration
+	offlineAfter    time.Duration
+	orphanGrace     time.Duration
 
 	mu          sync.Mutex
 	last        ReconcileReport
@@ -331,6 +419,19 @@ func WithWorkerRetention(value time.Duration) ReconcilerOption {
 	return func(r *Reconciler) { r.workerRetention = value }
 }
 
+// WithReconcileWorkerOfflineAfter grades worker presence, when a run past its
+// claim deadline is judged, with the numbers the workers API reports. Zero is
+// the default threshold.
+func WithReconcileWorkerOfflineAfter(value time.Duration) ReconcilerOption {
+	return func(r *Reconciler) { r.offlineAfter = value }
+}
+
+// WithOrphanChannelGrace sets how old a queue with no runner must be before it
+// is taken down.
+func WithOrphanChannelGrace(value time.Duration) ReconcilerOption {
+	return func(r *Reconciler) { r.orphanGrace = value }
+}
+
 // WithRunnerChannels gives the reconciler the transport's half. Without it,
 // runner queues and withdrawn messages are left alone -- which is the right
 // behaviour for a transport that has no such notion, not a degraded mode.
@@ -348,6 +449,7 @@ func NewReconciler(store ReconcileStore, options ...ReconcilerOption) *Reconcile
 		batchSize:       DefaultReconcileBatchSize,
 		pendingTimeout:  DefaultPendingDispatchTimeout,
 		workerRetention: DefaultWorkerRetention,
+		orphanGrace:     DefaultOrphanChannelGrace,
 		// The capability a worker holds outlives its deadline by this much, so
 		// that a run using its whole budget can still report. Expiring at the
 		// deadline itself would bump the Result's version out from under an
@@ -419,10 +521,18 @@ func (r *Reconciler) RunOnce(ctx context.Context) (ReconcileReport, error) {
 		// Expiry runs before the dispatch sweep so a run expired by this scan
 		// has its queued message withdrawn by the same scan rather than the next.
 		r.expireAbandonedRuns(ctx, &report),
+		r.expireUnclaimedRuns(ctx, &report),
 		r.reconcilePendingDispatches(ctx, &report),
+		// Before the dispatch sweep for the reason expiry is: the runs it
+		// expires have their queued messages withdrawn in the same scan.
+		r.finalizeRunners(ctx, &report),
 		r.retireStaleDispatches(ctx, &report),
 		r.reconcileRunnerChannels(ctx, &report),
+		r.reapOrphanChannels(ctx, &report),
 		r.evictSilentWorkers(ctx, &report),
+		// After eviction, so that the tokens only an evicted worker still held
+		// go in the same scan.
+		r.pruneEnrolmentTokens(ctx, &report),
 	)
 
 	report.Duration = time.Since(report.StartedAt)
@@ -450,11 +560,14 @@ func (r *Reconciler) log(report ReconcileReport, err error) {
 	}
 
 	log.Printf("reconciler %q repaired %d in %v (running-expired=%d pending-expired=%d redispatched=%d "+
-		"retired=%d dropped=%d leases=%d channels=%d workers-evicted=%d failures=%d oldest=%v)",
+		"retired=%d dropped=%d leases=%d channels=%d workers-evicted=%d orphaned-expired=%d "+
+		"unclaimed-expired=%d runners-removed=%d channels-removed=%d tokens-pruned=%d failures=%d oldest=%v)",
 		r.holder, report.Repaired(), report.Duration,
 		report.ExpiredRunning, report.ExpiredPending, report.Redispatched,
 		report.RetiredDispatches, report.DroppedMessages, report.ReleasedLeases,
-		report.RestoredChannels, report.EvictedWorkers, report.Failures, report.OldestInconsistent)
+		report.RestoredChannels, report.EvictedWorkers, report.ExpiredOrphaned,
+		report.ExpiredUnclaimed, report.RemovedRunners, report.RemovedChannels, report.PrunedTokens,
+		report.Failures, report.OldestInconsistent)
 
 	if err != nil {
 		log.Printf("reconciler %q: %v", r.holder, err)
@@ -546,6 +659,89 @@ func (r *Reconciler) expireAbandonedRuns(ctx context.Context, report *ReconcileR
 	return errs
 }
 
+// expireUnclaimedRuns settles the runs of fail-fast-after scenarios that
+// nothing claimed in time, while nothing was online to claim them.
+//
+// A claim that arrives late refuses the run itself, but one for a fleet that is
+// down never arrives, and the run would sit pending until the pending timeout
+// -- by which point "fail fast" has failed slowly. This is what makes the
+// policy's promise hold when no worker is there to test it.
+//
+// A run whose runner has a worker online that can take it is left alone: it is
+// waiting behind busy workers, not for a fleet to come back, and the policy
+// does not cover that wait; see OnlineCapacityFailFastAfter. Its runner and
+// kind are then left out of the scan, and the scan repeated, so that a backlog
+// on a busy runner cannot fill every batch and hide the runs of one that is
+// down. Each repeat leaves out at least one more runner, so it ends.
+//
+// Not drift, strictly: the policy is working. It is counted with the repairs
+// all the same, since it is the reconciler that acted and the log line is where
+// an operator will look for why a run never started.
+func (r *Reconciler) expireUnclaimedRuns(ctx context.Context, report *ReconcileReport) error {
+	now := time.Now()
+
+	var waiting []RunnerKind
+	online := map[RunnerKind]bool{}
+
+	var errs error
+	for {
+		candidates, err := r.store.UnclaimedRuns(ctx, now, waiting, r.batchSize)
+		if err != nil {
+			report.Failures++
+			return errors.Join(errs, fmt.Errorf("failed to list runs past their claim deadline: %w", err))
+		}
+
+		busy := false
+		for _, candidate := range candidates {
+			of := RunnerKind{RunnerUID: candidate.Status.Executor.RunnerID, Kind: candidate.Spec.ProbKind}
+			capacity, known := online[of]
+			if !known {
+				workers, err := r.store.OnlineWorkers(ctx, of, r.offlineAfter)
+				if err != nil {
+					// Unknown is not offline: a run expired on a failed read
+					// cannot be given back.
+					report.Failures++
+					errs = errors.Join(errs, fmt.Errorf("failed to count the online workers of runner %v: %w", of.RunnerUID, err))
+					workers = 1
+				}
+
+				capacity = workers > 0
+				online[of] = capacity
+				if capacity {
+					waiting = append(waiting, of)
+					busy = true
+				}
+			}
+			if capacity {
+				continue
+			}
+
+			reason := fmt.Sprintf("not claimed by %v", candidate.Status.ClaimBy.UTC().Format(time.RFC3339))
+
+			// Labelled before the store expires it, which persists the labels it is
+			// handed along with the expiry.
+			labelMissedClaim(&candidate)
+
+			switch expired, err := r.store.ExpireRun(ctx, candidate, now, reason); {
+			case err != nil:
+				report.Failures++
+				errs = errors.Join(errs, fmt.Errorf("failed to expire unclaimed run %q: %w", candidate.Name, err))
+			case !expired:
+				// Claimed in the moment before the deadline, most likely, or settled
+				// by that late claim. Either way it is no longer pending.
+				log.Printf("run %q moved on before its claim deadline could be enforced", candidate.Name)
+			default:
+				report.ExpiredUnclaimed++
+				log.Printf("pending run %q expired: %s, and runner %v has no worker online to run it", candidate.Name, reason, of.RunnerUID)
+			}
+		}
+
+		if !busy || len(candidates) < r.batchSize {
+			return errs
+		}
+	}
+}
+
 // evictSilentWorkers drops registrations of workers that stopped reporting.
 //
 // A worker's registration is not self-cleaning: nothing deletes it when the
@@ -565,7 +761,7 @@ func (r *Reconciler) expireAbandonedRuns(ctx context.Context, report *ReconcileR
 //
 // Evicting a worker does not disturb its runner or anything queued for it, and
 // the worker may register again at any time. This drops a registration; it does
-// not bar a worker.
+// not bar a worker; a WorkerBlock does.
 func (r *Reconciler) evictSilentWorkers(ctx context.Context, report *ReconcileReport) error {
 	if r.workerRetention <= 0 {
 		return nil
@@ -600,6 +796,31 @@ func (r *Reconciler) evictSilentWorkers(ctx context.Context, report *ReconcileRe
 	return errs
 }
 
+// pruneEnrolmentTokens deletes the records of enrolment tokens past their
+// expiry.
+//
+// Every token issued is recorded, and tokens are issued on every read of the
+// token route -- by hand, and by any provisioning script that fetches one per
+// worker it starts. Nothing else removes a record, so without this the table
+// grows by every token ever handed out, and it is read whole by the worker bar
+// snapshot.
+//
+// An expired token enrols nobody, so its record has nothing left to refuse.
+// A revoked one is kept until it expires like any other: until then, the record
+// is what says it is revoked. Neither is deleted while a registered worker came
+// in with it, because that worker's claims are judged on the record, and a
+// record gone reads as a token nobody issued.
+func (r *Reconciler) pruneEnrolmentTokens(ctx context.Context, report *ReconcileReport) error {
+	pruned, err := r.store.PruneEnrolmentTokens(ctx, time.Now(), r.batchSize)
+	report.PrunedTokens += pruned
+	if err != nil {
+		report.Failures++
+		return fmt.Errorf("failed to prune expired enrolment tokens: %w", err)
+	}
+
+	return nil
+}
+
 // reconcilePendingDispatches repairs runs that never started.
 //
 // Three states hide behind "still pending", and they want opposite handling:
@@ -764,6 +985,177 @@ func (r *Reconciler) reconcileRunnerChannels(ctx context.Context, report *Reconc
 	return errs
 }
 
+// finalizeRunners finishes removing runners that have been deleted.
+//
+// runnersAPIImpl.Delete only marks a runner; this does the rest, in an order
+// that never leaves something behind with nothing left to find it:
+//
+//   - pending runs placed on the runner are expired, saying why. They are not
+//     placed again elsewhere. ADR 0007 §3 is explicit that a placement is never
+//     rewritten: the decision chose this runner, and a run on a runner that no
+//     longer exists is a failed run, recorded as one.
+//   - runs already executing are left to finish, and the runner waits for them.
+//     Their workers can still report, and a worker that died holding one is
+//     expired by its lease like any other, so the wait is bounded.
+//   - the runner's queue is taken down, and only then the resource. Removing the
+//     resource first would leave the queue to the orphan sweep's grace period;
+//     removing the queue first and failing to remove the resource is retried
+//     by the next scan, and removing a queue already gone is not an error.
+func (r *Reconciler) finalizeRunners(ctx context.Context, report *ReconcileReport) error {
+	runners, err := r.store.TerminatingRunners(ctx, r.batchSize)
+	if err != nil {
+		report.Failures++
+		return fmt.Errorf("failed to list runners being deleted: %w", err)
+	}
+
+	var errs error
+	for _, runner := range runners {
+		settled, err := r.settleRunsOn(ctx, runner, report)
+		if err != nil {
+			errs = errors.Join(errs, err)
+			continue
+		}
+		if !settled {
+			continue
+		}
+
+		if r.channels != nil {
+			if err := r.channels.RemoveRunnerChannel(ctx, runner.UID); err != nil {
+				report.Failures++
+				errs = errors.Join(errs, fmt.Errorf("failed to remove the queue of deleted runner %q: %w", runner.Name, err))
+
+				continue
+			}
+			report.RemovedChannels++
+		}
+
+		switch removed, err := r.store.RemoveRunner(ctx, runner); {
+		case err != nil:
+			report.Failures++
+			errs = errors.Join(errs, fmt.Errorf("failed to remove deleted runner %q: %w", runner.Name, err))
+		case !removed:
+			// Written to since the scan read it -- a worker's registration
+			// touching it, most likely. Still terminating, so the next scan
+			// finishes it.
+			log.Printf("deleted runner %q changed before it could be removed", runner.Name)
+		default:
+			report.RemovedRunners++
+			log.Printf("removed deleted runner %q, terminating since %v",
+				runner.Name, runner.Status.TerminatingSince.UTC().Format(time.RFC3339))
+		}
+	}
+
+	return errs
+}
+
+// settleRunsOn expires the pending runs placed on a deleted runner, reporting
+// whether nothing unfinished is left on it.
+func (r *Reconciler) settleRunsOn(ctx context.Context, runner Runner, report *ReconcileReport) (bool, error) {
+	runs, err := r.store.UnfinishedRunsOn(ctx, runner.UID, r.batchSize)
+	if err != nil {
+		report.Failures++
+		return false, fmt.Errorf("failed to list the runs of deleted runner %q: %w", runner.Name, err)
+	}
+
+	// A full batch may not be all of them. Whatever is beyond it is settled by
+	// the next scan, and the runner is not removed before then.
+	settled := len(runs) < r.batchSize
+	now := time.Now()
+	reason := fmt.Sprintf("runner %q was deleted before the run was claimed", runner.Name)
+
+	var errs error
+	for _, run := range runs {
+		if run.Status.Status != JobPending {
+			settled = false
+			continue
+		}
+
+		report.noteInconsistent(run, now)
+
+		switch expired, err := r.store.ExpireRun(ctx, run, now, reason); {
+		case err != nil:
+			settled = false
+			report.Failures++
+			errs = errors.Join(errs, fmt.Errorf("failed to expire run %q of deleted runner %q: %w", run.Name, runner.Name, err))
+		case !expired:
+			// Claimed in the moment before the runner was marked, most likely.
+			// It is running now, and is waited for.
+			settled = false
+			log.Printf("run %q of deleted runner %q moved on before it could be expired", run.Name, runner.Name)
+		default:
+			report.ExpiredOrphaned++
+			log.Printf("pending run %q expired: %s", run.Name, reason)
+		}
+	}
+
+	return settled, errs
+}
+
+// reapOrphanChannels takes down runner queues that no runner claims.
+//
+// finalizeRunners removes the queue of every runner deleted through the API.
+// This is for the rest: runners deleted before that existed, removed behind the
+// API's back, or re-queued by a dispatch that slipped out while their teardown
+// was under way. Each leaves a consumer that nothing will ever read, and
+// consumers are a bounded broker resource.
+//
+// The sweep deletes things on the strength of an absence, so it keeps to the
+// three rules ADR 0007 §5 sets for it:
+//
+//   - only a runner's absence counts. A disabled runner keeps its queue: that
+//     is policy, and its work is meant to survive re-enabling it.
+//   - the runner listing is complete or the sweep does not run. One failed
+//     query read as "there are no runners" would take down the whole fleet's
+//     queues. The queues are listed first, so a runner created in between is
+//     in the runner listing that follows.
+//   - a queue younger than the grace period is left alone.
+func (r *Reconciler) reapOrphanChannels(ctx context.Context, report *ReconcileReport) error {
+	if r.channels == nil || r.orphanGrace <= 0 {
+		return nil
+	}
+
+	channels, err := r.channels.RunnerChannels(ctx)
+	if err != nil {
+		report.Failures++
+		return fmt.Errorf("failed to list runner queues: %w", err)
+	}
+	if len(channels) == 0 {
+		return nil
+	}
+
+	runners, err := r.store.RunnerUIDs(ctx)
+	if err != nil {
+		report.Failures++
+		return fmt.Errorf("failed to list runners to find orphaned queues: %w", err)
+	}
+
+	known := make(map[manifest.ResourceID]bool, len(runners))
+	for _, uid := range runners {
+		known[uid] = true
+	}
+
+	cutoff := time.Now().Add(-r.orphanGrace)
+
+	var errs error
+	for _, channel := range channels {
+		if known[channel.RunnerUID] || channel.CreatedAt.After(cutoff) {
+			continue
+		}
+
+		if err := r.channels.RemoveRunnerChannel(ctx, channel.RunnerUID); err != nil {
+			report.Failures++
+			errs = errors.Join(errs, fmt.Errorf("failed to remove the orphaned queue of runner %v: %w", channel.RunnerUID, err))
+
+			continue
+		}
+
+		report.RemovedChannels++
+		log.Printf("removed the queue of runner %v, which no longer exists", channel.RunnerUID)
+	}
+
+	return errs
+}
+
 // noteInconsistent tracks the age of the oldest drift this scan has seen.
 func (r *ReconcileReport) noteInconsistent(result Result, now time.Time) {
 	if result.CreatedAt == nil {
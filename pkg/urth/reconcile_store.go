@@ -30,20 +30,32 @@ func NewReconcileStore(db *gorm.DB, store *dbstore.DBStore) ReconcileStore {
 	return &reconcileStore{db: db, store: store}
 }
 
-// AcquireScanLease claims the right to run one scan.
+func (s *reconcileStore) AcquireScanLease(ctx context.Context, holder string, lease time.Duration) (bool, error) {
+	return acquireLease(ctx, s.db, ReconcileScanLeaseName, holder, lease)
+}
+
+func (s *reconcileStore) ReleaseScanLease(ctx context.Context, holder string) error {
+	return releaseLease(ctx, s.db, ReconcileScanLeaseName, holder)
+}
+
+// acquireLease claims a named control-loop lease.
 //
 // Two statements rather than an upsert, because the interesting case is not
-// insertion: it is one reconciler taking over from another whose lease has
-// elapsed, which has to be a single conditional UPDATE or two reconcilers can
-// both read "expired" and both write "mine". `RowsAffected` from that UPDATE is
-// the whole decision.
-func (s *reconcileStore) AcquireScanLease(ctx context.Context, holder string, lease time.Duration) (bool, error) {
+// insertion: it is one holder taking over from another whose lease has
+// elapsed, which has to be a single conditional UPDATE or two loops can both
+// read "expired" and both write "mine". `RowsAffected` from that UPDATE is the
+// whole decision.
+//
+// Shared by every loop that wants one scanner at a time -- the reconciler and
+// the retention sweep each take a row of their own -- so there is one
+// implementation of taking over an expired lease rather than one per loop.
+func acquireLease(ctx context.Context, db *gorm.DB, name, holder string, lease time.Duration) (bool, error) {
 	now := time.Now()
 	expires := now.Add(lease)
 
-	taken := s.db.WithContext(ctx).Model(&ReconcileLease{}).
-		Where("name = ?", ReconcileScanLeaseName).
-		// Either nobody holds it any more, or this reconciler is renewing its own.
+	taken := db.WithContext(ctx).Model(&ReconcileLease{}).
+		Where("name = ?", name).
+		// Either nobody holds it any more, or this holder is renewing its own.
 		Where("expires_at <= ? OR holder = ?", now, holder).
 		Updates(map[string]any{
 			"holder":     holder,
@@ -51,7 +63,7 @@ func (s *reconcileStore) AcquireScanLease(ctx context.Context, holder string, le
 			"updated_at": now,
 		})
 	if taken.Error != nil {
-		return false, fmt.Errorf("failed to take the reconcile lease: %w", taken.Error)
+		return false, fmt.Errorf("failed to take the %q lease: %w", name, taken.Error)
 	}
 	if taken.RowsAffected == 1 {
 		return true, nil
@@ -59,30 +71,31 @@ func (s *reconcileStore) AcquireScanLease(ctx context.Context, holder string, le
 
 	// No row matched. Either the lease is live and held elsewhere -- in which
 	// case the insert below conflicts and reports nothing taken -- or this is the
-	// first scan this deployment has ever run.
+	// first time this deployment has ever taken it.
 	row := ReconcileLease{
-		Name:      ReconcileScanLeaseName,
+		Name:      name,
 		Holder:    holder,
 		ExpiresAt: expires,
 		UpdatedAt: now,
 	}
 
-	created := s.db.WithContext(ctx).
+	created := db.WithContext(ctx).
 		Clauses(clause.OnConflict{DoNothing: true}).
 		Create(&row)
 	if created.Error != nil {
-		return false, fmt.Errorf("failed to create the reconcile lease: %w", created.Error)
+		return false, fmt.Errorf("failed to create the %q lease: %w", name, created.Error)
 	}
 
 	return created.RowsAffected == 1, nil
 }
 
-func (s *reconcileStore) ReleaseScanLease(ctx context.Context, holder string) error {
+// releaseLease gives a named lease up early.
+func releaseLease(ctx context.Context, db *gorm.DB, name, holder string) error {
 	now := time.Now()
 
-	tx := s.db.WithContext(ctx).Model(&ReconcileLease{}).
-		Where("name = ?", ReconcileScanLeaseName).
-		// Only the holder may release it. A reconciler that lost the lease to a
+	tx := db.WithContext(ctx).Model(&ReconcileLease{}).
+		Where("name = ?", name).
+		// Only the holder may release it. A holder that lost the lease to a
 		// takeover must not then expire the new holder's claim on its way out.
 		Where("holder = ?", holder).
 		Updates(map[string]any{
@@ -90,7 +103,7 @@ func (s *reconcileStore) ReleaseScanLease(ctx context.Context, holder string) er
 			"updated_at": now,
 		})
 	if tx.Error != nil {
-		return fmt.Errorf("failed to release the reconcile lease: %w", tx.Error)
+		return fmt.Errorf("failed to release the %q lease: %w", name, tx.Error)
 	}
 
 	return nil
@@ -109,7 +122,9 @@ func (s *reconcileStore) ExpiredRuns(ctx context.Context, cutoff time.Time, limi
 	var results []Result
 
 	err := s.scan(ctx).
-		Where("status_status = ?", JobRunning).
+		// A cancelling run is still executing as far as anything can tell, and a
+		// worker that dies holding one leaves it exactly as stranded.
+		Where("status_status IN ?", []JobStatus{JobRunning, JobCancelling}).
 		// A zero deadline is a run claimed by the deprecated Auth path, which
 		// never recorded one. Expiring those on the strength of a zero value
 		// would terminate every legacy run the moment this shipped.
@@ -126,6 +141,36 @@ func (s *reconcileStore) ExpiredRuns(ctx context.Context, cutoff time.Time, limi
 	return results, nil
 }
 
+func (s *reconcileStore) UnclaimedRuns(ctx context.Context, now time.Time, waiting []RunnerKind, limit int) ([]Result, error) {
+	var results []Result
+
+	// Only pending runs. One claimed in time and still running has its
+	// execution lease to answer to, and is none of this policy's business.
+	query := s.scan(ctx).
+		Where("status_status = ?", JobPending).
+		Where("status_claim_by IS NOT NULL").
+		Where("status_claim_by < ?", now)
+	for _, of := range waiting {
+		query = query.Where("NOT (status_executor_runner_id = ? AND prob_kind = ?)", of.RunnerUID, of.Kind)
+	}
+
+	err := query.
+		Order("status_claim_by ASC").
+		Limit(limit).
+		Find(&results).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query runs past their claim deadline: %w", err)
+	}
+
+	return results, nil
+}
+
+// OnlineWorkers asks placement, so that the reconciler and the claim path grade
+// a runner's workers as run creation does.
+func (s *reconcileStore) OnlineWorkers(ctx context.Context, of RunnerKind, offlineAfter time.Duration) (int, error) {
+	return placement{store: s.store, offlineAfter: offlineAfter}.onlineWorkersOf(ctx, of)
+}
+
 // SilentWorkers lists registrations that have gone quiet on every signal.
 //
 // The first predicate is the load-bearing one: a worker with neither timestamp
@@ -166,6 +211,33 @@ func (s *reconcileStore) DropWorker(ctx context.Context, worker WorkerInstance)
 	return s.store.Delete(ctx, &WorkerInstance{}, worker.UID, worker.Version)
 }
 
+// PruneEnrolmentTokens deletes the records outright rather than through
+// dbstore: a soft-deleted record is still a row, and rows are what this is for.
+// A worker dropped since is no reason to keep one, so only live registrations
+// are consulted.
+func (s *reconcileStore) PruneEnrolmentTokens(ctx context.Context, cutoff time.Time, limit int) (int, error) {
+	expired := s.scan(ctx).Unscoped().
+		Model(&EnrolmentToken{}).
+		Select("uid").
+		Where("expires_at < ?", cutoff).
+		Where("NOT EXISTS (?)", s.scan(ctx).
+			Model(&WorkerInstance{}).
+			Select("1").
+			Where("worker_instances.status_enrolment_token_id = enrolment_tokens.name").
+			Where("worker_instances.deleted_at IS NULL")).
+		Order("expires_at ASC").
+		Limit(limit)
+
+	pruned := s.scan(ctx).Unscoped().
+		Where("uid IN (?)", expired).
+		Delete(&EnrolmentToken{})
+	if pruned.Error != nil {
+		return 0, fmt.Errorf("failed to delete expired enrolment tokens: %w", pruned.Error)
+	}
+
+	return int(pruned.RowsAffected), nil
+}
+
 func (s *reconcileStore) StalePendingRuns(ctx context.Context, cutoff time.Time, limit int) ([]PendingRun, error) {
 	var results []Result
 
@@ -376,7 +448,14 @@ func (s *reconcileStore) StaleDispatches(ctx context.Context, limit int) ([]Stal
 }
 
 func (s *reconcileStore) RetireDispatch(ctx context.Context, id uint, at time.Time, reason string) error {
-	tx := s.db.WithContext(ctx).Model(&DispatchOutboxEntry{}).
+	return retireDispatch(ctx, s.db, id, at, reason)
+}
+
+// retireDispatch marks one outbox entry as never to be published. Shared by the
+// reconciler and by cancellation, so a dispatch retired either way leaves the
+// same row behind.
+func retireDispatch(ctx context.Context, db *gorm.DB, id uint, at time.Time, reason string) error {
+	tx := db.WithContext(ctx).Model(&DispatchOutboxEntry{}).
 		Where("id = ?", id).
 		Where("retired_at IS NULL").
 		Updates(map[string]any{
@@ -418,6 +497,10 @@ func (s *reconcileStore) ActiveRunnerUIDs(ctx context.Context) ([]manifest.Resou
 
 	err := s.scan(ctx).Model(&Runner{}).
 		Where("is_active = ?", true).
+		// A runner being deleted is having its queue taken down; restoring it
+		// would undo the teardown in the same scan.
+		Where("status_terminating_since IS NULL").
+		Where("deleted_at IS NULL").
 		Pluck("uid", &uids).Error
 	if err != nil {
 		return nil, fmt.Errorf("failed to query active runners: %w", err)
@@ -426,6 +509,65 @@ func (s *reconcileStore) ActiveRunnerUIDs(ctx context.Context) ([]manifest.Resou
 	return uids, nil
 }
 
+// RunnerUIDs lists every runner there is.
+//
+// Not paged, unlike every other listing here, and that is the point: the orphan
+// sweep removes a queue because its runner is missing from this list, and a
+// page would be a list with runners missing from it. A fleet's runners are a
+// few hundred UIDs at the most.
+func (s *reconcileStore) RunnerUIDs(ctx context.Context) ([]manifest.ResourceID, error) {
+	var uids []manifest.ResourceID
+
+	err := s.scan(ctx).Model(&Runner{}).
+		Where("deleted_at IS NULL").
+		Pluck("uid", &uids).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query runners: %w", err)
+	}
+
+	return uids, nil
+}
+
+func (s *reconcileStore) TerminatingRunners(ctx context.Context, limit int) ([]Runner, error) {
+	var runners []Runner
+
+	err := s.scan(ctx).
+		Where("status_terminating_since IS NOT NULL").
+		Where("deleted_at IS NULL").
+		Order("status_terminating_since ASC").
+		Limit(limit).
+		Find(&runners).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query runners being deleted: %w", err)
+	}
+
+	return runners, nil
+}
+
+func (s *reconcileStore) UnfinishedRunsOn(ctx context.Context, runnerUID manifest.ResourceID, limit int) ([]Result, error) {
+	var results []Result
+
+	err := s.scan(ctx).
+		Where("status_executor_runner_id = ?", runnerUID).
+		Where("status_status NOT IN ?", TerminalJobStates()).
+		Where("deleted_at IS NULL").
+		Order("created_at ASC").
+		Limit(limit).
+		Find(&results).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to query the unfinished runs of runner %v: %w", runnerUID, err)
+	}
+
+	return results, nil
+}
+
+// RemoveRunner deletes a runner through dbstore, for the reason DropWorker
+// does: a runner the reconciler removes should leave the trace one deleted
+// outright would have.
+func (s *reconcileStore) RemoveRunner(ctx context.Context, runner Runner) (bool, error) {
+	return s.store.Delete(ctx, &Runner{}, runner.UID, runner.Version)
+}
+
 // truncateReason bounds the stored explanation, which is written for operators
 // and can inherit the length of whatever error produced it.
 func truncateReason(reason string) string {
@@ -30,8 +30,12 @@ type fakeChannels struct {
 	// restore only those rather than reporting every runner as repaired.
 	missing map[manifest.ResourceID]bool
 
+	// queues are what RunnerChannels lists.
+	queues []urth.RunnerChannel
+
 	ensured []manifest.ResourceID
 	dropped []uint64
+	removed []manifest.ResourceID
 
 	ensureErr error
 	dropErr   error
@@ -73,6 +77,22 @@ func (f *fakeChannels) DropDispatch(_ context.Context, entry urth.DispatchOutbox
 	return nil
 }
 
+func (f *fakeChannels) RemoveRunnerChannel(_ context.Context, runnerUID manifest.ResourceID) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.removed = append(f.removed, runnerUID)
+
+	return nil
+}
+
+func (f *fakeChannels) RunnerChannels(context.Context) ([]urth.RunnerChannel, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return append([]urth.RunnerChannel(nil), f.queues...), nil
+}
+
 func (f *fakeChannels) droppedSeqs() []uint64 {
 	f.mu.Lock()
 	defer f.mu.Unlock()
@@ -700,6 +720,125 @@ func TestReconcilerRestoresMissingRunnerChannels(t *testing.T) {
 	require.Zero(t, second.RestoredChannels)
 }
 
+// Deleting a runner used to be a bare delete: its queue stayed in the broker for
+// good, and the runs placed on it waited out the pending timeout to be expired
+// with a reason that blamed the transport. Now the runner is only marked, and the
+// reconciler settles its runs, takes its queue down, and removes it.
+func TestReconcilerFinishesDeletingARunner(t *testing.T) {
+	srv, db, store := newTestService(t, &stubScheduler{})
+	scenarioName := seedScenario(t, store)
+
+	ctx := context.Background()
+
+	created, err := srv.Results(scenarioName).Create(ctx, newRunRequest())
+	require.NoError(t, err)
+
+	var runner urth.Runner
+	found, err := store.GetByName(ctx, &runner, "test-runner")
+	require.NoError(t, err)
+	require.True(t, found)
+
+	deleted, err := srv.Runners().Delete(ctx, runner.GetVersionedID())
+	require.NoError(t, err)
+	require.True(t, deleted)
+
+	// Still there, and saying why.
+	got, found, err := srv.Runners().Get(ctx, "test-runner")
+	require.NoError(t, err)
+	require.True(t, found, "a deleted runner stays until its teardown is done")
+	require.NotNil(t, got.Status.(*urth.RunnerStatus).TerminatingSince)
+
+	channels := newFakeChannels()
+	reconciler := urth.NewReconciler(urth.NewReconcileStore(db, store), urth.WithRunnerChannels(channels))
+
+	report, err := reconciler.RunOnce(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, report.ExpiredOrphaned)
+	require.Equal(t, 1, report.RemovedRunners)
+	require.Equal(t, 1, report.RemovedChannels)
+	require.Equal(t, []manifest.ResourceID{runner.UID}, channels.removed)
+	require.Empty(t, channels.ensured, "a runner being deleted must not have its queue restored")
+
+	expired := loadResult(t, store, created.UID)
+	require.Equal(t, urth.JobExpired, expired.Status.Status,
+		"a run placed on a deleted runner is not placed again elsewhere")
+
+	_, found, err = srv.Runners().Get(ctx, "test-runner")
+	require.NoError(t, err)
+	require.False(t, found)
+}
+
+// A run already executing on a deleted runner is let finish, and the runner
+// waits for it: its worker can still report, and removing the runner under it
+// would leave the report nowhere to land.
+func TestDeletedRunnerWaitsForItsRunningRuns(t *testing.T) {
+	keys := testKeys(t)
+	srv, db, store := newTestService(t, &stubScheduler{}, urth.WithSigningKeys(keys))
+	scenarioName := seedScenario(t, store)
+
+	ctx := context.Background()
+
+	created, err := srv.Results(scenarioName).Create(ctx, newRunRequest())
+	require.NoError(t, err)
+	claimRun(t, srv, created)
+
+	var runner urth.Runner
+	found, err := store.GetByName(ctx, &runner, "test-runner")
+	require.NoError(t, err)
+	require.True(t, found)
+
+	deleted, err := srv.Runners().Delete(ctx, runner.GetVersionedID())
+	require.NoError(t, err)
+	require.True(t, deleted)
+
+	channels := newFakeChannels()
+	reconciler := urth.NewReconciler(urth.NewReconcileStore(db, store), urth.WithRunnerChannels(channels))
+
+	report, err := reconciler.RunOnce(ctx)
+	require.NoError(t, err)
+	require.Zero(t, report.RemovedRunners)
+	require.Empty(t, channels.removed)
+	require.Equal(t, urth.JobRunning, loadResult(t, store, created.UID).Status.Status)
+
+	_, found, err = srv.Runners().Get(ctx, "test-runner")
+	require.NoError(t, err)
+	require.True(t, found)
+}
+
+// The orphan sweep deletes on the strength of an absence, so it is held to ADR
+// 0007 §5: only a queue whose runner does not exist at all, and only once it is
+// older than the grace period. A disabled runner keeps its queue.
+func TestReconcilerReapsOnlyOldQueuesOfAbsentRunners(t *testing.T) {
+	_, db, store := newTestService(t, &stubScheduler{})
+
+	ctx := context.Background()
+
+	disabled := urth.Runner{
+		ObjectMeta: manifest.ObjectMeta{Name: "disabled-runner"},
+		Spec:       urth.RunnerSpec{IsActive: false},
+	}
+	require.NoError(t, store.Create(ctx, &disabled))
+
+	old := time.Now().Add(-time.Hour)
+
+	channels := newFakeChannels()
+	channels.queues = []urth.RunnerChannel{
+		{RunnerUID: disabled.UID, CreatedAt: old},
+		{RunnerUID: "gone-long-ago", CreatedAt: old},
+		{RunnerUID: "gone-just-now", CreatedAt: time.Now()},
+	}
+
+	reconciler := urth.NewReconciler(urth.NewReconcileStore(db, store),
+		urth.WithRunnerChannels(channels),
+		urth.WithOrphanChannelGrace(10*time.Minute),
+	)
+
+	report, err := reconciler.RunOnce(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, report.RemovedChannels)
+	require.Equal(t, []manifest.ResourceID{"gone-long-ago"}, channels.removed)
+}
+
 // One failing pass must not cancel the others. An unreachable broker is no
 // reason to leave every expired execution lease in place, and a scan that
 // stopped at the first error would do exactly that.
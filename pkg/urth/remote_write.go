@@ -0,0 +1,758 @@
+package urth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// Remote write: every finished run's metrics, pushed to Prometheus with the
+// time the run ended.
+//
+// /metrics/probes reports state, and a scrape only ever sees the latest run of
+// each target. A scenario that runs every ten seconds under a one minute scrape
+// loses five runs in six there, and with them every failure short enough to fit
+// between two scrapes. Pushing each run as it finishes, stamped with its own end
+// time, is what keeps those samples.
+//
+// The push is carried by a queue table for the reason the dispatch outbox is:
+// marking a run finished and handing its samples to a receiver are two separate
+// writes, and a receiver that is down must cost a delay rather than the samples.
+// The queue row is written in the run's own completion transaction, and a writer
+// loop drains it at least once.
+
+// Remote write defaults.
+const (
+	// DefaultRemoteWriteBatchSize is how many runs one write request carries.
+	DefaultRemoteWriteBatchSize = 64
+
+	// DefaultRemoteWritePollInterval is how long a writer waits after finding
+	// nothing to send. It is the latency a run's samples see on a quiet system,
+	// and samples carry their own timestamps, so it need not be short.
+	DefaultRemoteWritePollInterval = 5 * time.Second
+
+	// DefaultRemoteWriteLease is how long a claim survives its writer.
+	DefaultRemoteWriteLease = time.Minute
+
+	// DefaultRemoteWriteTimeout bounds one write request.
+	DefaultRemoteWriteTimeout = 30 * time.Second
+
+	// DefaultRemoteWriteMetricsGrace is how long a finished run waits for its
+	// metrics artifact. A worker uploads the artifact and posts the status
+	// concurrently, so either can land first; past this, the run is sent with
+	// what the Result alone says.
+	DefaultRemoteWriteMetricsGrace = 5 * time.Minute
+
+	// DefaultRemoteWriteRetryBackoff is the first retry delay after a failure.
+	DefaultRemoteWriteRetryBackoff = 5 * time.Second
+
+	// DefaultRemoteWriteMaxBackoff caps exponential growth, so a receiver that
+	// has been down for an hour is written to promptly once it returns.
+	DefaultRemoteWriteMaxBackoff = 5 * time.Minute
+
+	// remoteWriteRecheck is how soon a run still missing its artifact is looked
+	// at again, within the grace period.
+	remoteWriteRecheck = 10 * time.Second
+)
+
+// ErrRemoteWriteRejected marks a write the receiver refused outright.
+//
+// Prometheus answers a malformed or out-of-bounds sample with a 4xx, and sending
+// the same samples again gets the same answer. Retrying it would hold every run
+// queued behind it for as long as it exists.
+var ErrRemoteWriteRejected = errors.New("remote write rejected")
+
+// RemoteWriteConfig is what an operator can set about pushing run metrics.
+type RemoteWriteConfig struct {
+	// URL is the receiver's remote-write endpoint. The sink is off while it is
+	// empty: pushing is a deployment decision, and there is no receiver that
+	// could be assumed.
+	URL string `help:"Prometheus remote-write endpoint each finished run's metrics are pushed to; empty disables pushing" name:"url"`
+
+	BearerTokenFile string `help:"File holding a bearer token sent with every write" type:"path"`
+
+	// Labels is the same allowlist /metrics/probes takes, for the same reason.
+	Labels []string `help:"Scenario labels copied onto every pushed series" sep:","`
+
+	BatchSize    int           `help:"How many runs one write request carries" default:"64"`
+	PollInterval time.Duration `help:"How often the writer looks for finished runs when idle" default:"5s"`
+	Timeout      time.Duration `help:"How long one write request may take" default:"30s"`
+	MetricsGrace time.Duration `help:"How long a finished run waits for its metrics artifact before being pushed without it" default:"5m"`
+	MaxSamples   int           `help:"How many samples one run's metrics artifact may contribute" default:"200"`
+}
+
+// Enabled reports whether a receiver is configured.
+func (c RemoteWriteConfig) Enabled() bool {
+	return c.URL != ""
+}
+
+// RemoteWriteEntry is the durable record that a finished run's metrics still
+// have to be pushed.
+//
+// Like DispatchOutboxEntry it is internal plumbing rather than a manifest
+// resource. A row lives until its samples are accepted, and is then deleted:
+// nothing reads a pushed row, and keeping one per run ever finished would grow
+// the table with the results it describes.
+type RemoteWriteEntry struct {
+	ID uint `gorm:"primaryKey;autoIncrement"`
+
+	// ResultUID is the run to push. Unique, so a completion reported twice
+	// queues its samples once.
+	ResultUID manifest.ResourceID `gorm:"uniqueIndex;not null"`
+
+	// EndedAt is when the run finished, and the timestamp every one of its
+	// samples carries. Entries are sent in this order, because a receiver
+	// refuses a sample older than one it already holds for the same series.
+	EndedAt time.Time `gorm:"not null;index"`
+
+	CreatedAt time.Time `gorm:"not null"`
+	UpdatedAt time.Time `gorm:"not null"`
+
+	// RetiredAt marks an entry the receiver rejected. Kept rather than deleted,
+	// so an operator can see which runs never arrived and why.
+	RetiredAt     *time.Time `gorm:"index"`
+	RetiredReason string
+
+	// NotBefore, Attempts, LastError and the claim columns mean what they mean
+	// on the dispatch outbox.
+	NotBefore      time.Time `gorm:"not null;index"`
+	Attempts       int       `gorm:"not null"`
+	LastError      string
+	ClaimedBy      string     `gorm:"index"`
+	ClaimExpiresAt *time.Time `gorm:"index"`
+}
+
+// TableName keeps the table out of gorm's pluralisation guesswork.
+func (RemoteWriteEntry) TableName() string {
+	return "remote_write_queue"
+}
+
+// NewRemoteWriteEntry builds the queue row for a run that just finished.
+func NewRemoteWriteEntry(result Result, now time.Time) RemoteWriteEntry {
+	ended := now
+	if result.Spec.TimeEnded != nil {
+		ended = *result.Spec.TimeEnded
+	}
+
+	return RemoteWriteEntry{
+		ResultUID: result.UID,
+		EndedAt:   ended,
+		NotBefore: now,
+	}
+}
+
+// RemoteWriteQueue is the writer's view of the queue table.
+type RemoteWriteQueue interface {
+	// Claim leases up to limit due entries, oldest run first.
+	Claim(ctx context.Context, writerID string, limit int, lease time.Duration) ([]RemoteWriteEntry, error)
+
+	// LoadRuns reads the runs behind a batch, with their scenarios and metrics
+	// artifacts. A run that no longer exists is simply absent.
+	LoadRuns(ctx context.Context, ids []manifest.ResourceID) ([]ProbeTarget, error)
+
+	// Drop deletes entries with nothing left to push: the receiver accepted
+	// their samples, or their run is gone.
+	Drop(ctx context.Context, ids []uint) error
+
+	// MarkFailed records a failed attempt and holds the entries back until
+	// notBefore.
+	MarkFailed(ctx context.Context, ids []uint, cause error, notBefore time.Time) error
+
+	// Defer holds entries back without counting the claim as an attempt: the
+	// run is waiting for its artifact, not failing.
+	Defer(ctx context.Context, ids []uint, notBefore time.Time) error
+
+	// Retire gives up on entries for good.
+	Retire(ctx context.Context, ids []uint, reason string, at time.Time) error
+
+	// Stats summarises the backlog for monitoring.
+	Stats(ctx context.Context, now time.Time) (RemoteWriteStats, error)
+}
+
+// RemoteWriteStats is what an operator needs to notice the receiver is not
+// keeping up.
+type RemoteWriteStats struct {
+	// Pending is the number of runs not yet pushed, including ones waiting for
+	// their artifact.
+	Pending int64 `json:"pending" yaml:"pending"`
+
+	// Failing is the number of pending runs that have failed at least once.
+	Failing int64 `json:"failing" yaml:"failing"`
+
+	// OldestAge is how long ago the oldest pending run finished. The number to
+	// alert on: it is how far behind the receiver is, and how close the backlog
+	// is to samples the receiver will refuse as too old.
+	OldestAge time.Duration `json:"oldestAge" yaml:"oldestAge"`
+
+	// Retired is the number of runs the receiver rejected, which an operator has
+	// to look at: their samples are missing from the receiver for good.
+	Retired int64 `json:"retired" yaml:"retired"`
+}
+
+// RemoteWriteLabel is one label of a pushed series.
+type RemoteWriteLabel struct {
+	Name  string
+	Value string
+}
+
+// RemoteWriteSample is one value of a series at one instant.
+type RemoteWriteSample struct {
+	Value     float64
+	Timestamp time.Time
+}
+
+// RemoteWriteSeries is one series and its samples, in the shape a write
+// request carries them. Labels are sorted by name, as the protocol requires.
+type RemoteWriteSeries struct {
+	Labels  []RemoteWriteLabel
+	Samples []RemoteWriteSample
+}
+
+// RemoteWriteClient hands series to a receiver.
+//
+// An implementation must return nil only once the receiver has accepted every
+// sample, and wrap ErrRemoteWriteRejected when retrying cannot help.
+type RemoteWriteClient interface {
+	Write(ctx context.Context, series []RemoteWriteSeries) error
+}
+
+// RemoteWriter drains the queue into a receiver.
+type RemoteWriter struct {
+	queue  RemoteWriteQueue
+	client RemoteWriteClient
+
+	writerID     string
+	labels       []string
+	maxSamples   int
+	batchSize    int
+	pollInterval time.Duration
+	lease        time.Duration
+	timeout      time.Duration
+	metricsGrace time.Duration
+	retryBackoff time.Duration
+	maxBackoff   time.Duration
+
+	bookkeepingTimeout time.Duration
+
+	sent     atomic.Uint64
+	dropped  atomic.Uint64
+	failures atomic.Uint64
+}
+
+// RemoteWriterOption configures a RemoteWriter.
+type RemoteWriterOption func(*RemoteWriter)
+
+// WithRemoteWriteLabels sets which scenario labels are copied onto every series.
+func WithRemoteWriteLabels(labels ...string) RemoteWriterOption {
+	return func(w *RemoteWriter) { w.labels = labels }
+}
+
+// WithRemoteWriteMaxSamples caps what one metrics artifact contributes.
+func WithRemoteWriteMaxSamples(limit int) RemoteWriterOption {
+	return func(w *RemoteWriter) { w.maxSamples = limit }
+}
+
+// WithRemoteWriteBatchSize sets how many runs one request carries.
+func WithRemoteWriteBatchSize(value int) RemoteWriterOption {
+	return func(w *RemoteWriter) { w.batchSize = value }
+}
+
+// WithRemoteWritePollInterval sets the idle poll interval.
+func WithRemoteWritePollInterval(value time.Duration) RemoteWriterOption {
+	return func(w *RemoteWriter) { w.pollInterval = value }
+}
+
+// WithRemoteWriteTimeout bounds one write request.
+func WithRemoteWriteTimeout(value time.Duration) RemoteWriterOption {
+	return func(w *RemoteWriter) { w.timeout = value }
+}
+
+// WithRemoteWriteMetricsGrace sets how long a run waits for its artifact.
+func WithRemoteWriteMetricsGrace(value time.Duration) RemoteWriterOption {
+	return func(w *RemoteWriter) { w.metricsGrace = value }
+}
+
+// WithRemoteWriteBackoff sets the initial and maximum retry delays.
+func WithRemoteWriteBackoff(initial, max time.Duration) RemoteWriterOption {
+	return func(w *RemoteWriter) {
+		w.retryBackoff = initial
+		w.maxBackoff = max
+	}
+}
+
+// NewRemoteWriter builds a writer over a queue and a receiver.
+func NewRemoteWriter(queue RemoteWriteQueue, client RemoteWriteClient, options ...RemoteWriterOption) *RemoteWriter {
+	w := &RemoteWriter{
+		queue:              queue,
+		client:             client,
+		writerID:           fmt.Sprintf("remote-write-%s", NewRandToken(8)),
+		maxSamples:         DefaultMaxProbeArtifactSamples,
+		batchSize:          DefaultRemoteWriteBatchSize,
+		pollInterval:       DefaultRemoteWritePollInterval,
+		lease:              DefaultRemoteWriteLease,
+		timeout:            DefaultRemoteWriteTimeout,
+		metricsGrace:       DefaultRemoteWriteMetricsGrace,
+		retryBackoff:       DefaultRemoteWriteRetryBackoff,
+		maxBackoff:         DefaultRemoteWriteMaxBackoff,
+		bookkeepingTimeout: DefaultRelayBookkeepingTimeout,
+	}
+
+	for _, option := range options {
+		option(w)
+	}
+
+	w.labels = probeLabelAllowlist(w.labels)
+
+	// The lease must outlast a write, or a second writer takes over a batch that
+	// is still in flight. Harmless -- the receiver drops an identical sample --
+	// but wasted.
+	w.lease = max(w.lease, 2*w.timeout)
+
+	return w
+}
+
+// remoteWriteRun is one claimed entry and what it will push.
+type remoteWriteRun struct {
+	entry  RemoteWriteEntry
+	series []RemoteWriteSeries
+}
+
+// RunOnce claims one batch and pushes it, returning how many runs were sent.
+//
+// Exposed separately from Run so that tests can drive the writer
+// deterministically rather than racing a ticker.
+func (w *RemoteWriter) RunOnce(ctx context.Context) (sent int, err error) {
+	entries, err := w.queue.Claim(ctx, w.writerID, w.batchSize, w.lease)
+	if err != nil || len(entries) == 0 {
+		return 0, err
+	}
+
+	ids := make([]manifest.ResourceID, 0, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, entry.ResultUID)
+	}
+
+	targets, err := w.queue.LoadRuns(ctx, ids)
+	if err != nil {
+		return 0, w.fail(ctx, entries, err)
+	}
+	byRun := make(map[manifest.ResourceID]ProbeTarget, len(targets))
+	for _, target := range targets {
+		byRun[target.Run.UID] = target
+	}
+
+	now := time.Now()
+
+	var ready []remoteWriteRun
+	var waiting, gone []RemoteWriteEntry
+	for _, entry := range entries {
+		target, ok := byRun[entry.ResultUID]
+		switch {
+		case !ok:
+			// Deleted between finishing and being pushed. There is nothing left
+			// to say which scenario it was, so nothing to push.
+			gone = append(gone, entry)
+		case target.Metrics == nil && now.Before(entry.EndedAt.Add(w.metricsGrace)):
+			waiting = append(waiting, entry)
+		default:
+			ready = append(ready, remoteWriteRun{entry: entry, series: w.seriesFor(target, entry.EndedAt)})
+		}
+	}
+
+	if len(gone) > 0 {
+		err = errors.Join(err, w.drop(ctx, gone))
+	}
+	if len(waiting) > 0 {
+		err = errors.Join(err, w.wait(ctx, waiting, now))
+	}
+	if len(ready) == 0 {
+		return 0, err
+	}
+
+	sent, werr := w.write(ctx, ready)
+
+	return sent, errors.Join(err, werr)
+}
+
+// write pushes runs in one request, falling back to one request per run when
+// the receiver rejects the batch.
+//
+// A rejection names no sample, and one run with a series the receiver cannot
+// take -- too old, out of order -- would otherwise take every run batched with
+// it down too. Sent alone, only that run is retired.
+func (w *RemoteWriter) write(ctx context.Context, runs []remoteWriteRun) (int, error) {
+	err := w.send(ctx, runs)
+	switch {
+	case err == nil:
+		return len(runs), w.markSent(ctx, runs)
+	case !errors.Is(err, ErrRemoteWriteRejected):
+		return 0, w.fail(ctx, entriesOf(runs), err)
+	case len(runs) == 1:
+		return 0, errors.Join(err, w.retire(ctx, entriesOf(runs), err.Error()))
+	}
+
+	var sent int
+	var errs []error
+	for _, run := range runs {
+		n, err := w.write(ctx, []remoteWriteRun{run})
+		sent += n
+		errs = append(errs, err)
+	}
+
+	return sent, errors.Join(errs...)
+}
+
+func (w *RemoteWriter) send(ctx context.Context, runs []remoteWriteRun) error {
+	var series []RemoteWriteSeries
+	for _, run := range runs {
+		series = append(series, run.series...)
+	}
+
+	writeCtx, cancel := context.WithTimeout(ctx, w.timeout)
+	defer cancel()
+
+	return w.client.Write(writeCtx, mergeRemoteWriteSeries(series))
+}
+
+func entriesOf(runs []remoteWriteRun) []RemoteWriteEntry {
+	entries := make([]RemoteWriteEntry, 0, len(runs))
+	for _, run := range runs {
+		entries = append(entries, run.entry)
+	}
+
+	return entries
+}
+
+func entryIDs(entries []RemoteWriteEntry) []uint {
+	ids := make([]uint, 0, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, entry.ID)
+	}
+
+	return ids
+}
+
+// bookkeeping derives the context used to record an outcome, detached from the
+// caller's deadline for the reason DispatchRelay.bookkeeping gives.
+func (w *RemoteWriter) bookkeeping(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), w.bookkeepingTimeout)
+}
+
+func (w *RemoteWriter) markSent(ctx context.Context, runs []remoteWriteRun) error {
+	var samples int
+	for _, run := range runs {
+		for _, series := range run.series {
+			samples += len(series.Samples)
+		}
+	}
+	w.sent.Add(uint64(samples))
+
+	markCtx, cancel := w.bookkeeping(ctx)
+	defer cancel()
+
+	// A failure here leaves the entries to be sent again once their lease
+	// lapses. The receiver drops a sample identical to one it holds, so the
+	// cost is a repeated request, not a repeated sample.
+	return w.queue.Drop(markCtx, entryIDs(entriesOf(runs)))
+}
+
+func (w *RemoteWriter) drop(ctx context.Context, entries []RemoteWriteEntry) error {
+	dropCtx, cancel := w.bookkeeping(ctx)
+	defer cancel()
+
+	return w.queue.Drop(dropCtx, entryIDs(entries))
+}
+
+func (w *RemoteWriter) fail(ctx context.Context, entries []RemoteWriteEntry, cause error) error {
+	w.failures.Add(1)
+
+	// Every entry in a batch was claimed together, so they share an attempt
+	// count closely enough to share a backoff.
+	attempts := 0
+	for _, entry := range entries {
+		attempts = max(attempts, entry.Attempts)
+	}
+	backoff := w.backoffFor(attempts)
+
+	log.Printf("failed to push %d runs (attempt %d, retry in %v): %v", len(entries), attempts, backoff, cause)
+
+	failCtx, cancel := w.bookkeeping(ctx)
+	defer cancel()
+
+	if err := w.queue.MarkFailed(failCtx, entryIDs(entries), cause, time.Now().Add(backoff)); err != nil {
+		return errors.Join(cause, err)
+	}
+
+	return cause
+}
+
+func (w *RemoteWriter) wait(ctx context.Context, entries []RemoteWriteEntry, now time.Time) error {
+	deferCtx, cancel := w.bookkeeping(ctx)
+	defer cancel()
+
+	return w.queue.Defer(deferCtx, entryIDs(entries), now.Add(min(remoteWriteRecheck, w.metricsGrace)))
+}
+
+func (w *RemoteWriter) retire(ctx context.Context, entries []RemoteWriteEntry, reason string) error {
+	for _, entry := range entries {
+		log.Printf("not pushing metrics of run %v: %s", entry.ResultUID, reason)
+	}
+
+	retireCtx, cancel := w.bookkeeping(ctx)
+	defer cancel()
+
+	return w.queue.Retire(retireCtx, entryIDs(entries), reason, time.Now())
+}
+
+// backoffFor computes the delay before a failed batch is retried, exponential
+// in the attempt count as the dispatch relay's is.
+func (w *RemoteWriter) backoffFor(attempts int) time.Duration {
+	backoff := w.retryBackoff
+	for attempt := 1; attempt < attempts && backoff < w.maxBackoff; attempt++ {
+		backoff *= 2
+	}
+
+	return min(backoff, w.maxBackoff)
+}
+
+// Run drains the queue until the context is cancelled.
+//
+// Errors are logged rather than returned, for the reason DispatchRelay.Run
+// gives: a writer that stopped when the receiver did would not be there when it
+// came back.
+func (w *RemoteWriter) Run(ctx context.Context) error {
+	log.Printf("remote writer %q started (batch=%d, poll=%v)", w.writerID, w.batchSize, w.pollInterval)
+
+	for {
+		sent, err := w.RunOnce(ctx)
+		if ctx.Err() != nil {
+			log.Printf("remote writer %q stopped", w.writerID)
+			return ctx.Err()
+		}
+		if err != nil {
+			log.Printf("remote writer %q: %v", w.writerID, err)
+		}
+
+		if sent == w.batchSize {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Printf("remote writer %q stopped", w.writerID)
+			return ctx.Err()
+		case <-time.After(w.pollInterval):
+		}
+	}
+}
+
+// seriesFor flattens one run into the series it pushes, every sample stamped
+// with the run's end.
+//
+// probe_success and probe_duration_seconds come from the Result, as on
+// /metrics/probes, so a run without an artifact still reports its outcome.
+// Histograms and summaries are flattened into the _bucket, _sum and _count
+// series a scrape would have produced.
+func (w *RemoteWriter) seriesFor(target ProbeTarget, ended time.Time) []RemoteWriteSeries {
+	names := probeTargetLabelNames(w.labels)
+	values := probeTargetLabelValues(w.labels, target)
+
+	base := make([]RemoteWriteLabel, 0, len(names))
+	for i, name := range names {
+		if values[i] != "" {
+			base = append(base, RemoteWriteLabel{Name: name, Value: values[i]})
+		}
+	}
+
+	var result []RemoteWriteSeries
+	add := func(name string, extra []RemoteWriteLabel, value float64) {
+		labels := append(slices.Clone(base), RemoteWriteLabel{Name: "__name__", Value: name})
+		labels = append(labels, extra...)
+		slices.SortFunc(labels, func(x, y RemoteWriteLabel) int { return strings.Compare(x.Name, y.Name) })
+
+		result = append(result, RemoteWriteSeries{
+			Labels:  labels,
+			Samples: []RemoteWriteSample{{Value: value, Timestamp: ended}},
+		})
+	}
+
+	add(probeSuccessMetric, nil, probeSuccess(target.Run))
+	if started, finished := target.Run.Spec.TimeStarted, target.Run.Spec.TimeEnded; started != nil && finished != nil {
+		add(probeDurationMetric, nil, finished.Sub(*started).Seconds())
+	}
+	if upstream := target.Run.Status.UpstreamFailed; upstream != "" {
+		add(probeUpstreamFailedMetric, []RemoteWriteLabel{{Name: "upstream", Value: string(upstream)}}, 1)
+	}
+
+	var emitted, dropped int
+	for _, family := range decodeMetricsArtifact(target) {
+		switch family.GetName() {
+		case probeSuccessMetric, probeDurationMetric, probeUpstreamFailedMetric:
+			continue
+		}
+
+		for _, metric := range family.GetMetric() {
+			if emitted >= w.maxSamples {
+				dropped++
+				continue
+			}
+			emitted++
+
+			stored := make([]RemoteWriteLabel, 0, len(metric.GetLabel()))
+			for _, pair := range metric.GetLabel() {
+				stored = append(stored, RemoteWriteLabel{Name: exportedLabelName(pair.GetName(), names), Value: pair.GetValue()})
+			}
+
+			flattenRemoteWriteMetric(family, metric, stored, add)
+		}
+	}
+	w.dropped.Add(uint64(dropped))
+
+	return result
+}
+
+// flattenRemoteWriteMetric emits the plain series one stored sample stands for.
+func flattenRemoteWriteMetric(family *dto.MetricFamily, metric *dto.Metric, labels []RemoteWriteLabel, add func(string, []RemoteWriteLabel, float64)) {
+	name := family.GetName()
+	with := func(name, value string) []RemoteWriteLabel {
+		return append(slices.Clone(labels), RemoteWriteLabel{Name: name, Value: value})
+	}
+
+	switch family.GetType() {
+	case dto.MetricType_COUNTER:
+		add(name, labels, metric.GetCounter().GetValue())
+	case dto.MetricType_GAUGE:
+		add(name, labels, metric.GetGauge().GetValue())
+	case dto.MetricType_HISTOGRAM:
+		histogram := metric.GetHistogram()
+		var infinite bool
+		for _, bucket := range histogram.GetBucket() {
+			infinite = infinite || math.IsInf(bucket.GetUpperBound(), +1)
+			add(name+"_bucket", with("le", formatBound(bucket.GetUpperBound())), float64(bucket.GetCumulativeCount()))
+		}
+		// Implied by the count in the exposition format, explicit in storage.
+		if !infinite {
+			add(name+"_bucket", with("le", "+Inf"), float64(histogram.GetSampleCount()))
+		}
+		add(name+"_sum", labels, histogram.GetSampleSum())
+		add(name+"_count", labels, float64(histogram.GetSampleCount()))
+	case dto.MetricType_SUMMARY:
+		summary := metric.GetSummary()
+		for _, quantile := range summary.GetQuantile() {
+			add(name, with("quantile", formatBound(quantile.GetQuantile())), quantile.GetValue())
+		}
+		add(name+"_sum", labels, summary.GetSampleSum())
+		add(name+"_count", labels, float64(summary.GetSampleCount()))
+	default:
+		add(name, labels, metric.GetUntyped().GetValue())
+	}
+}
+
+// formatBound renders a bucket bound or quantile the way Prometheus does, so a
+// pushed series lands on the same `le` a scraped one would have.
+func formatBound(value float64) string {
+	if math.IsInf(value, +1) {
+		return "+Inf"
+	}
+
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
+
+// mergeRemoteWriteSeries folds series with the same labels into one, samples in
+// time order. Two runs of one target in a batch are one series with two
+// samples, and the protocol expects to see it that way.
+func mergeRemoteWriteSeries(series []RemoteWriteSeries) []RemoteWriteSeries {
+	index := make(map[string]int, len(series))
+	merged := make([]RemoteWriteSeries, 0, len(series))
+
+	var key strings.Builder
+	for _, s := range series {
+		key.Reset()
+		for _, label := range s.Labels {
+			key.WriteString(label.Name)
+			key.WriteByte(0)
+			key.WriteString(label.Value)
+			key.WriteByte(0)
+		}
+
+		if i, ok := index[key.String()]; ok {
+			merged[i].Samples = append(merged[i].Samples, s.Samples...)
+			continue
+		}
+
+		index[key.String()] = len(merged)
+		merged = append(merged, RemoteWriteSeries{Labels: s.Labels, Samples: slices.Clone(s.Samples)})
+	}
+
+	for i := range merged {
+		slices.SortStableFunc(merged[i].Samples, func(x, y RemoteWriteSample) int { return x.Timestamp.Compare(y.Timestamp) })
+	}
+
+	return merged
+}
+
+// Collector reports what the writer has pushed and what it is holding.
+func (w *RemoteWriter) Collector() prometheus.Collector {
+	return &remoteWriteCollector{writer: w}
+}
+
+type remoteWriteCollector struct {
+	writer *RemoteWriter
+}
+
+var (
+	remoteWriteSentDesc = prometheus.NewDesc("urth_remote_write_samples_sent_total",
+		"Samples from finished runs the remote-write receiver accepted.", nil, nil)
+	remoteWriteDroppedDesc = prometheus.NewDesc("urth_remote_write_samples_dropped_total",
+		"Samples from metrics artifacts left out because a run exceeded its sample cap.", nil, nil)
+	remoteWriteFailuresDesc = prometheus.NewDesc("urth_remote_write_failures_total",
+		"Write requests that failed and will be retried.", nil, nil)
+	remoteWriteRetiredDesc = prometheus.NewDesc("urth_remote_write_retired_runs",
+		"Finished runs whose samples the receiver refused, kept in the queue for inspection.", nil, nil)
+	remoteWritePendingDesc = prometheus.NewDesc("urth_remote_write_pending_runs",
+		"Finished runs whose samples have not been pushed yet.", nil, nil)
+	remoteWriteOldestDesc = prometheus.NewDesc("urth_remote_write_oldest_pending_seconds",
+		"How long ago the oldest run waiting to be pushed finished.", nil, nil)
+)
+
+func (c *remoteWriteCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- remoteWriteSentDesc
+	ch <- remoteWriteDroppedDesc
+	ch <- remoteWriteFailuresDesc
+	ch <- remoteWritePendingDesc
+	ch <- remoteWriteOldestDesc
+	ch <- remoteWriteRetiredDesc
+}
+
+func (c *remoteWriteCollector) Collect(ch chan<- prometheus.Metric) {
+	w := c.writer
+	ch <- prometheus.MustNewConstMetric(remoteWriteSentDesc, prometheus.CounterValue, float64(w.sent.Load()))
+	ch <- prometheus.MustNewConstMetric(remoteWriteDroppedDesc, prometheus.CounterValue, float64(w.dropped.Load()))
+	ch <- prometheus.MustNewConstMetric(remoteWriteFailuresDesc, prometheus.CounterValue, float64(w.failures.Load()))
+
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultMetricsTimeout)
+	defer cancel()
+
+	stats, err := w.queue.Stats(ctx, time.Now())
+	if err != nil {
+		// The counters above are still worth exposing; the backlog gauges are
+		// left out rather than reported as an empty queue.
+		log.Printf("metrics: failed to read the remote write backlog: %v", err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(remoteWritePendingDesc, prometheus.GaugeValue, float64(stats.Pending))
+	ch <- prometheus.MustNewConstMetric(remoteWriteOldestDesc, prometheus.GaugeValue, stats.OldestAge.Seconds())
+	ch <- prometheus.MustNewConstMetric(remoteWriteRetiredDesc, prometheus.GaugeValue, float64(stats.Retired))
+}
@@ -0,0 +1,148 @@
+package urth
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+
+	"github.com/klauspost/compress/snappy"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// remoteWriteClient speaks version 1 of the Prometheus remote-write protocol:
+// a snappy-compressed protobuf WriteRequest in an HTTP POST.
+//
+// The request is encoded field by field rather than through generated types.
+// The message is three nested records with six fields between them, frozen
+// since the protocol shipped, and the generated package that carries it would
+// pull Prometheus itself into this module for the sake of one struct.
+type remoteWriteClient struct {
+	url    string
+	client *http.Client
+	token  string
+}
+
+// RemoteWriteClientOption configures the HTTP remote-write client.
+type RemoteWriteClientOption func(*remoteWriteClient)
+
+// WithRemoteWriteHTTPClient sets the HTTP client requests are made with.
+func WithRemoteWriteHTTPClient(client *http.Client) RemoteWriteClientOption {
+	return func(c *remoteWriteClient) { c.client = client }
+}
+
+// WithRemoteWriteBearerToken sends a bearer token with every request.
+func WithRemoteWriteBearerToken(token string) RemoteWriteClientOption {
+	return func(c *remoteWriteClient) { c.token = strings.TrimSpace(token) }
+}
+
+// NewRemoteWriteClient returns a client writing to a remote-write endpoint.
+func NewRemoteWriteClient(url string, options ...RemoteWriteClientOption) RemoteWriteClient {
+	c := &remoteWriteClient{
+		url:    url,
+		client: http.DefaultClient,
+	}
+
+	for _, option := range options {
+		option(c)
+	}
+
+	return c
+}
+
+// remoteWriteErrorLimit bounds how much of a receiver's error body is kept.
+const remoteWriteErrorLimit = 512
+
+// Write implements RemoteWriteClient.
+//
+// A 5xx or a 429 is the receiver being unwell or busy and is retried; any other
+// 4xx is the receiver refusing these samples, and is marked permanent. That is
+// the split Prometheus's own remote-write client makes.
+func (c *remoteWriteClient) Write(ctx context.Context, series []RemoteWriteSeries) error {
+	body := snappy.Encode(nil, encodeWriteRequest(series))
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrRemoteWriteRejected, err)
+	}
+
+	request.Header.Set("Content-Type", "application/x-protobuf")
+	request.Header.Set("Content-Encoding", "snappy")
+	request.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	request.Header.Set("User-Agent", "urth-api-server")
+	if c.token != "" {
+		request.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	response, err := c.client.Do(request)
+	if err != nil {
+		return fmt.Errorf("remote write failed: %w", err)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode/100 == 2 {
+		_, _ = io.Copy(io.Discard, response.Body)
+		return nil
+	}
+
+	text, _ := io.ReadAll(io.LimitReader(response.Body, remoteWriteErrorLimit))
+	cause := fmt.Errorf("remote write answered %s: %s", response.Status, strings.TrimSpace(string(text)))
+
+	if response.StatusCode/100 == 4 && response.StatusCode != http.StatusTooManyRequests {
+		return fmt.Errorf("%w: %w", ErrRemoteWriteRejected, cause)
+	}
+
+	return cause
+}
+
+// Field numbers of the remote-write messages.
+const (
+	writeRequestTimeseries = 1
+
+	timeSeriesLabels  = 1
+	timeSeriesSamples = 2
+
+	labelName  = 1
+	labelValue = 2
+
+	sampleValue     = 1
+	sampleTimestamp = 2
+)
+
+// encodeWriteRequest marshals series as a prometheus.WriteRequest.
+func encodeWriteRequest(series []RemoteWriteSeries) []byte {
+	var request, message, field []byte
+	for _, s := range series {
+		message = message[:0]
+
+		for _, label := range s.Labels {
+			field = field[:0]
+			field = protowire.AppendTag(field, labelName, protowire.BytesType)
+			field = protowire.AppendString(field, label.Name)
+			field = protowire.AppendTag(field, labelValue, protowire.BytesType)
+			field = protowire.AppendString(field, label.Value)
+
+			message = protowire.AppendTag(message, timeSeriesLabels, protowire.BytesType)
+			message = protowire.AppendBytes(message, field)
+		}
+
+		for _, sample := range s.Samples {
+			field = field[:0]
+			field = protowire.AppendTag(field, sampleValue, protowire.Fixed64Type)
+			field = protowire.AppendFixed64(field, math.Float64bits(sample.Value))
+			field = protowire.AppendTag(field, sampleTimestamp, protowire.VarintType)
+			field = protowire.AppendVarint(field, uint64(sample.Timestamp.UnixMilli()))
+
+			message = protowire.AppendTag(message, timeSeriesSamples, protowire.BytesType)
+			message = protowire.AppendBytes(message, field)
+		}
+
+		request = protowire.AppendTag(request, writeRequestTimeseries, protowire.BytesType)
+		request = protowire.AppendBytes(request, message)
+	}
+
+	return request
+}
@@ -0,0 +1,196 @@
+package urth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/sre-norns/wyrd/pkg/manifest"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// remoteWriteQueueStore is the Postgres-backed remote write queue. It talks to
+// gorm directly for the reason dispatchOutboxStore does: a claim that cannot
+// skip locked rows is a claim two writers can both win.
+type remoteWriteQueueStore struct {
+	db *gorm.DB
+}
+
+// NewRemoteWriteQueue returns the queue backed by an existing gorm connection.
+func NewRemoteWriteQueue(db *gorm.DB) RemoteWriteQueue {
+	return &remoteWriteQueueStore{db: db}
+}
+
+// Claim implements RemoteWriteQueue, leasing rows the way the dispatch outbox
+// does.
+func (s *remoteWriteQueueStore) Claim(ctx context.Context, writerID string, limit int, lease time.Duration) ([]RemoteWriteEntry, error) {
+	if limit <= 0 {
+		return nil, nil
+	}
+
+	var claimed []RemoteWriteEntry
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+
+		query := tx.Model(&RemoteWriteEntry{}).
+			Where("retired_at IS NULL").
+			Where("not_before <= ?", now).
+			Where("claim_expires_at IS NULL OR claim_expires_at <= ?", now).
+			Order("ended_at ASC, id ASC").
+			Limit(limit)
+
+		if s.db.Dialector.Name() == "postgres" {
+			query = query.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"})
+		}
+
+		var due []RemoteWriteEntry
+		if err := query.Find(&due).Error; err != nil {
+			return err
+		}
+		if len(due) == 0 {
+			return nil
+		}
+
+		ids := make([]uint, 0, len(due))
+		for _, entry := range due {
+			ids = append(ids, entry.ID)
+		}
+
+		expiry := now.Add(lease)
+		update := tx.Model(&RemoteWriteEntry{}).
+			Where("id IN ?", ids).
+			Updates(map[string]any{
+				"claimed_by":       writerID,
+				"claim_expires_at": expiry,
+				"attempts":         gorm.Expr("attempts + 1"),
+				"updated_at":       now,
+			})
+		if update.Error != nil {
+			return update.Error
+		}
+
+		for i := range due {
+			due[i].ClaimedBy = writerID
+			due[i].ClaimExpiresAt = &expiry
+			due[i].Attempts++
+		}
+		claimed = due
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim remote write entries: %w", err)
+	}
+
+	return claimed, nil
+}
+
+// LoadRuns implements RemoteWriteQueue.
+func (s *remoteWriteQueueStore) LoadRuns(ctx context.Context, ids []manifest.ResourceID) ([]ProbeTarget, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	db := s.db.WithContext(ctx).Session(&gorm.Session{SkipHooks: true})
+
+	var runs []Result
+	if err := db.Select(probeTargetRunColumns).Where("uid IN ?", ids).Find(&runs).Error; err != nil {
+		return nil, fmt.Errorf("failed to read finished runs: %w", err)
+	}
+
+	return probeTargetsFor(db, runs)
+}
+
+func (s *remoteWriteQueueStore) Drop(ctx context.Context, ids []uint) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	if err := s.db.WithContext(ctx).Where("id IN ?", ids).Delete(&RemoteWriteEntry{}).Error; err != nil {
+		return fmt.Errorf("failed to drop pushed remote write entries: %w", err)
+	}
+
+	return nil
+}
+
+func (s *remoteWriteQueueStore) MarkFailed(ctx context.Context, ids []uint, cause error, notBefore time.Time) error {
+	return s.release(ctx, ids, map[string]any{
+		"last_error": truncateError(cause),
+		"not_before": notBefore,
+	})
+}
+
+func (s *remoteWriteQueueStore) Defer(ctx context.Context, ids []uint, notBefore time.Time) error {
+	return s.release(ctx, ids, map[string]any{
+		"not_before": notBefore,
+		// The claim counted an attempt. Waiting for an artifact is not one, and
+		// counting it would start a later real failure on a long backoff.
+		"attempts": gorm.Expr("attempts - 1"),
+	})
+}
+
+func (s *remoteWriteQueueStore) Retire(ctx context.Context, ids []uint, reason string, at time.Time) error {
+	if len(reason) > LastErrorLimit {
+		reason = reason[:LastErrorLimit]
+	}
+
+	return s.release(ctx, ids, map[string]any{
+		"retired_at":     at,
+		"retired_reason": reason,
+	})
+}
+
+// release records an outcome on claimed entries and drops their lease.
+func (s *remoteWriteQueueStore) release(ctx context.Context, ids []uint, updates map[string]any) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	updates["claimed_by"] = ""
+	updates["claim_expires_at"] = nil
+	updates["updated_at"] = time.Now()
+
+	if err := s.db.WithContext(ctx).Model(&RemoteWriteEntry{}).Where("id IN ?", ids).Updates(updates).Error; err != nil {
+		return fmt.Errorf("failed to update remote write entries: %w", err)
+	}
+
+	return nil
+}
+
+func (s *remoteWriteQueueStore) Stats(ctx context.Context, now time.Time) (RemoteWriteStats, error) {
+	var stats RemoteWriteStats
+
+	db := s.db.WithContext(ctx)
+	queued := func() *gorm.DB {
+		return db.Model(&RemoteWriteEntry{})
+	}
+
+	if err := queued().Where("retired_at IS NOT NULL").Count(&stats.Retired).Error; err != nil {
+		return stats, fmt.Errorf("failed to count retired remote writes: %w", err)
+	}
+
+	pending := func() *gorm.DB {
+		return queued().Where("retired_at IS NULL")
+	}
+
+	if err := pending().Count(&stats.Pending).Error; err != nil {
+		return stats, fmt.Errorf("failed to count pending remote writes: %w", err)
+	}
+	if stats.Pending == 0 {
+		return stats, nil
+	}
+
+	if err := pending().Where("last_error <> ''").Count(&stats.Failing).Error; err != nil {
+		return stats, fmt.Errorf("failed to count failing remote writes: %w", err)
+	}
+
+	var oldest RemoteWriteEntry
+	if err := pending().Order("ended_at ASC").First(&oldest).Error; err != nil {
+		return stats, fmt.Errorf("failed to read the oldest pending remote write: %w", err)
+	}
+	stats.OldestAge = max(now.Sub(oldest.EndedAt), 0)
+
+	return stats, nil
+}
@@ -0,0 +1,413 @@
+package urth_test
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+
+	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/urth/pkg/urth"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// A receiver outage must cost a delay, never a sample, and one run the receiver
+// cannot take must not cost the runs batched with it. What reaches the receiver
+// is asserted by decoding the wire format, not by trusting the encoder.
+
+// fakeRemoteWriteQueue keeps the queue in memory, recording what became of each
+// entry.
+type fakeRemoteWriteQueue struct {
+	entries map[uint]*urth.RemoteWriteEntry
+	runs    map[manifest.ResourceID]urth.ProbeTarget
+	retired map[uint]string
+}
+
+func newFakeRemoteWriteQueue(targets ...urth.ProbeTarget) *fakeRemoteWriteQueue {
+	q := &fakeRemoteWriteQueue{
+		entries: map[uint]*urth.RemoteWriteEntry{},
+		runs:    map[manifest.ResourceID]urth.ProbeTarget{},
+		retired: map[uint]string{},
+	}
+
+	for i, target := range targets {
+		q.runs[target.Run.UID] = target
+		entry := urth.NewRemoteWriteEntry(target.Run, *target.Run.Spec.TimeEnded)
+		entry.ID = uint(i + 1)
+		entry.NotBefore = time.Time{}
+		q.entries[entry.ID] = &entry
+	}
+
+	return q
+}
+
+func (q *fakeRemoteWriteQueue) Claim(_ context.Context, writerID string, limit int, _ time.Duration) ([]urth.RemoteWriteEntry, error) {
+	var due []urth.RemoteWriteEntry
+	for _, entry := range q.entries {
+		if entry.RetiredAt == nil && !entry.NotBefore.After(time.Now()) {
+			entry.Attempts++
+			entry.ClaimedBy = writerID
+			due = append(due, *entry)
+		}
+	}
+
+	slices.SortFunc(due, func(x, y urth.RemoteWriteEntry) int { return x.EndedAt.Compare(y.EndedAt) })
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	return due, nil
+}
+
+func (q *fakeRemoteWriteQueue) LoadRuns(_ context.Context, ids []manifest.ResourceID) ([]urth.ProbeTarget, error) {
+	var targets []urth.ProbeTarget
+	for _, id := range ids {
+		if target, ok := q.runs[id]; ok {
+			targets = append(targets, target)
+		}
+	}
+
+	return targets, nil
+}
+
+func (q *fakeRemoteWriteQueue) Drop(_ context.Context, ids []uint) error {
+	for _, id := range ids {
+		delete(q.entries, id)
+	}
+
+	return nil
+}
+
+func (q *fakeRemoteWriteQueue) MarkFailed(_ context.Context, ids []uint, cause error, notBefore time.Time) error {
+	for _, id := range ids {
+		q.entries[id].LastError = cause.Error()
+		q.entries[id].NotBefore = notBefore
+	}
+
+	return nil
+}
+
+func (q *fakeRemoteWriteQueue) Defer(_ context.Context, ids []uint, notBefore time.Time) error {
+	for _, id := range ids {
+		q.entries[id].Attempts--
+		q.entries[id].NotBefore = notBefore
+	}
+
+	return nil
+}
+
+func (q *fakeRemoteWriteQueue) Retire(_ context.Context, ids []uint, reason string, at time.Time) error {
+	for _, id := range ids {
+		q.entries[id].RetiredAt = &at
+		q.retired[id] = reason
+	}
+
+	return nil
+}
+
+func (q *fakeRemoteWriteQueue) Stats(context.Context, time.Time) (urth.RemoteWriteStats, error) {
+	return urth.RemoteWriteStats{Pending: int64(len(q.entries) - len(q.retired)), Retired: int64(len(q.retired))}, nil
+}
+
+// releaseAll makes every held-back entry due again, standing in for time
+// passing.
+func (q *fakeRemoteWriteQueue) releaseAll() {
+	for _, entry := range q.entries {
+		entry.NotBefore = time.Time{}
+	}
+}
+
+// receivedSeries is one series as the receiver decoded it.
+type receivedSeries struct {
+	labels  map[string]string
+	samples []urth.RemoteWriteSample
+}
+
+// remoteWriteReceiver is a stub receiver answering with whatever respond says.
+type remoteWriteReceiver struct {
+	mu       sync.Mutex
+	requests [][]receivedSeries
+	headers  []http.Header
+	respond  func(series []receivedSeries) int
+}
+
+func newRemoteWriteReceiver(t *testing.T, respond func([]receivedSeries) int) (*remoteWriteReceiver, string) {
+	t.Helper()
+
+	receiver := &remoteWriteReceiver{respond: respond}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		compressed, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		body, err := snappy.Decode(nil, compressed)
+		require.NoError(t, err)
+		series := decodeWriteRequest(t, body)
+
+		receiver.mu.Lock()
+		receiver.requests = append(receiver.requests, series)
+		receiver.headers = append(receiver.headers, r.Header.Clone())
+		receiver.mu.Unlock()
+
+		w.WriteHeader(receiver.respond(series))
+	}))
+	t.Cleanup(server.Close)
+
+	return receiver, server.URL
+}
+
+// accepted is every series the receiver answered 2xx for.
+func (r *remoteWriteReceiver) accepted() []receivedSeries {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var result []receivedSeries
+	for _, request := range r.requests {
+		if r.respond(request)/100 == 2 {
+			result = append(result, request...)
+		}
+	}
+
+	return result
+}
+
+// decodeWriteRequest reads a prometheus.WriteRequest with nothing but the wire
+// format, so the test does not share the encoder's assumptions.
+func decodeWriteRequest(t *testing.T, body []byte) []receivedSeries {
+	t.Helper()
+
+	fields := func(message []byte, visit func(number protowire.Number, kind protowire.Type, value []byte, scalar uint64)) {
+		for len(message) > 0 {
+			number, kind, n := protowire.ConsumeTag(message)
+			require.GreaterOrEqual(t, n, 0)
+			message = message[n:]
+
+			switch kind {
+			case protowire.BytesType:
+				value, n := protowire.ConsumeBytes(message)
+				require.GreaterOrEqual(t, n, 0)
+				visit(number, kind, value, 0)
+				message = message[n:]
+			case protowire.Fixed64Type:
+				value, n := protowire.ConsumeFixed64(message)
+				require.GreaterOrEqual(t, n, 0)
+				visit(number, kind, nil, value)
+				message = message[n:]
+			case protowire.VarintType:
+				value, n := protowire.ConsumeVarint(message)
+				require.GreaterOrEqual(t, n, 0)
+				visit(number, kind, nil, value)
+				message = message[n:]
+			default:
+				t.Fatalf("unexpected wire type %v", kind)
+			}
+		}
+	}
+
+	var result []receivedSeries
+	fields(body, func(number protowire.Number, _ protowire.Type, timeseries []byte, _ uint64) {
+		require.EqualValues(t, 1, number)
+
+		series := receivedSeries{labels: map[string]string{}}
+		var names []string
+		fields(timeseries, func(number protowire.Number, _ protowire.Type, value []byte, _ uint64) {
+			switch number {
+			case 1:
+				var name, text string
+				fields(value, func(number protowire.Number, _ protowire.Type, value []byte, _ uint64) {
+					if number == 1 {
+						name = string(value)
+					} else {
+						text = string(value)
+					}
+				})
+				series.labels[name] = text
+				names = append(names, name)
+			case 2:
+				var sample urth.RemoteWriteSample
+				fields(value, func(number protowire.Number, _ protowire.Type, _ []byte, scalar uint64) {
+					if number == 1 {
+						sample.Value = math.Float64frombits(scalar)
+					} else {
+						sample.Timestamp = time.UnixMilli(int64(scalar)).UTC()
+					}
+				})
+				series.samples = append(series.samples, sample)
+			}
+		})
+
+		require.True(t, slices.IsSorted(names), "labels must arrive sorted: %v", names)
+		result = append(result, series)
+	})
+
+	return result
+}
+
+func seriesNamed(series []receivedSeries, name, scenario string) []receivedSeries {
+	var result []receivedSeries
+	for _, s := range series {
+		if s.labels["__name__"] == name && s.labels["scenario"] == scenario {
+			result = append(result, s)
+		}
+	}
+
+	return result
+}
+
+// finishedProbe is a probe target whose run has an identity and an end.
+func finishedProbe(uid, scenario string, outcome prob.RunStatus, metrics *urth.ArtifactSpec, ended time.Time) urth.ProbeTarget {
+	target := probeTarget(scenario, "eu-west", outcome, metrics)
+	started := ended.Add(-2 * time.Second)
+	target.Run.UID = manifest.ResourceID(uid)
+	target.Run.Spec.TimeStarted = &started
+	target.Run.Spec.TimeEnded = &ended
+
+	return target
+}
+
+func TestRemoteWriterPushesEveryRunAtItsEndTime(t *testing.T) {
+	requests := prometheus.NewHistogram(prometheus.HistogramOpts{Name: "probe_http_request_seconds", Help: "Request time.", Buckets: []float64{0.1, 1}})
+	requests.Observe(0.05)
+
+	ended := time.Date(2024, time.March, 4, 12, 0, 0, 0, time.UTC)
+	queue := newFakeRemoteWriteQueue(
+		finishedProbe("run-1", "checkout", prob.RunFinishedFailed, nil, ended),
+		finishedProbe("run-2", "checkout", prob.RunFinishedSuccess, metricsArtifact(t, requests), ended.Add(10*time.Second)),
+	)
+	receiver, url := newRemoteWriteReceiver(t, func([]receivedSeries) int { return http.StatusNoContent })
+
+	writer := urth.NewRemoteWriter(queue, urth.NewRemoteWriteClient(url, urth.WithRemoteWriteBearerToken("secret\n")),
+		urth.WithRemoteWriteLabels("team"),
+		urth.WithRemoteWriteMetricsGrace(0))
+
+	sent, err := writer.RunOnce(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 2, sent)
+	require.Empty(t, queue.entries, "pushed entries leave the queue")
+
+	require.Len(t, receiver.requests, 1, "both runs travel in one request")
+	headers := receiver.headers[0]
+	require.Equal(t, "snappy", headers.Get("Content-Encoding"))
+	require.Equal(t, "application/x-protobuf", headers.Get("Content-Type"))
+	require.Equal(t, "Bearer secret", headers.Get("Authorization"))
+
+	// Two runs of one target are one series with two samples, in time order:
+	// the run a scrape would have missed is there.
+	success := seriesNamed(receiver.accepted(), "probe_success", "checkout")
+	require.Len(t, success, 1)
+	require.Equal(t, "edge", success[0].labels["label_team"])
+	require.Equal(t, []urth.RemoteWriteSample{
+		{Value: 0, Timestamp: ended},
+		{Value: 1, Timestamp: ended.Add(10 * time.Second)},
+	}, success[0].samples)
+
+	buckets := seriesNamed(receiver.accepted(), "probe_http_request_seconds_bucket", "checkout")
+	require.Len(t, buckets, 3, "a histogram is flattened with its +Inf bucket")
+	for _, bucket := range buckets {
+		require.Equal(t, ended.Add(10*time.Second), bucket.samples[0].Timestamp)
+	}
+	require.Len(t, seriesNamed(receiver.accepted(), "probe_http_request_seconds_count", "checkout"), 1)
+}
+
+func TestRemoteWriterKeepsRunsThroughAReceiverOutage(t *testing.T) {
+	ended := time.Date(2024, time.March, 4, 12, 0, 0, 0, time.UTC)
+	queue := newFakeRemoteWriteQueue(finishedProbe("run-1", "checkout", prob.RunFinishedSuccess, nil, ended))
+
+	down := true
+	receiver, url := newRemoteWriteReceiver(t, func([]receivedSeries) int {
+		if down {
+			return http.StatusServiceUnavailable
+		}
+		return http.StatusOK
+	})
+
+	writer := urth.NewRemoteWriter(queue, urth.NewRemoteWriteClient(url), urth.WithRemoteWriteMetricsGrace(0))
+
+	sent, err := writer.RunOnce(context.Background())
+	require.Error(t, err)
+	require.Zero(t, sent)
+	require.Len(t, queue.entries, 1, "nothing is dropped while the receiver is down")
+	require.Contains(t, queue.entries[1].LastError, "503")
+	require.True(t, queue.entries[1].NotBefore.After(time.Now()), "a failed push is retried with backoff")
+
+	down = false
+	queue.releaseAll()
+
+	sent, err = writer.RunOnce(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, sent)
+	require.Empty(t, queue.entries)
+	require.Len(t, receiver.requests, 2)
+}
+
+func TestRemoteWriterRetiresOnlyTheRunTheReceiverRejects(t *testing.T) {
+	ended := time.Date(2024, time.March, 4, 12, 0, 0, 0, time.UTC)
+	queue := newFakeRemoteWriteQueue(
+		finishedProbe("run-1", "checkout", prob.RunFinishedSuccess, nil, ended),
+		finishedProbe("run-2", "too-old", prob.RunFinishedSuccess, nil, ended.Add(time.Second)),
+	)
+
+	receiver, url := newRemoteWriteReceiver(t, func(series []receivedSeries) int {
+		for _, s := range series {
+			if s.labels["scenario"] == "too-old" {
+				return http.StatusBadRequest
+			}
+		}
+		return http.StatusOK
+	})
+
+	writer := urth.NewRemoteWriter(queue, urth.NewRemoteWriteClient(url), urth.WithRemoteWriteMetricsGrace(0))
+
+	sent, err := writer.RunOnce(context.Background())
+	require.ErrorIs(t, err, urth.ErrRemoteWriteRejected)
+	require.Equal(t, 1, sent)
+
+	require.Len(t, seriesNamed(receiver.accepted(), "probe_success", "checkout"), 1)
+	require.Len(t, queue.entries, 1)
+	require.Contains(t, queue.retired[2], "400", "the rejected run is kept, with the receiver's reason")
+}
+
+func TestRemoteWriterWaitsForTheMetricsArtifact(t *testing.T) {
+	ended := time.Now().Add(-time.Minute)
+	queue := newFakeRemoteWriteQueue(finishedProbe("run-1", "checkout", prob.RunFinishedSuccess, nil, ended))
+	receiver, url := newRemoteWriteReceiver(t, func([]receivedSeries) int { return http.StatusOK })
+
+	writer := urth.NewRemoteWriter(queue, urth.NewRemoteWriteClient(url), urth.WithRemoteWriteMetricsGrace(time.Hour))
+
+	sent, err := writer.RunOnce(context.Background())
+	require.NoError(t, err)
+	require.Zero(t, sent)
+	require.Empty(t, receiver.requests, "the artifact may still be uploading")
+	require.Zero(t, queue.entries[1].Attempts, "waiting is not a failed attempt")
+
+	// Past the grace period the run goes out with what its Result says.
+	writer = urth.NewRemoteWriter(queue, urth.NewRemoteWriteClient(url), urth.WithRemoteWriteMetricsGrace(time.Second))
+	queue.releaseAll()
+
+	sent, err = writer.RunOnce(context.Background())
+	require.NoError(t, err)
+	require.Equal(t, 1, sent)
+	require.Len(t, seriesNamed(receiver.accepted(), "probe_success", "checkout"), 1)
+}
+
+func TestRemoteWriterDropsRunsThatAreGone(t *testing.T) {
+	ended := time.Date(2024, time.March, 4, 12, 0, 0, 0, time.UTC)
+	queue := newFakeRemoteWriteQueue(finishedProbe("run-1", "checkout", prob.RunFinishedSuccess, nil, ended))
+	delete(queue.runs, "run-1")
+
+	_, url := newRemoteWriteReceiver(t, func([]receivedSeries) int { return http.StatusOK })
+	writer := urth.NewRemoteWriter(queue, urth.NewRemoteWriteClient(url))
+
+	_, err := writer.RunOnce(context.Background())
+	require.NoError(t, err)
+	require.Empty(t, queue.entries)
+	require.Empty(t, queue.retired)
+}
@@ -0,0 +1,398 @@
+package urth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// Result retention defaults.
+//
+// Deletion is off unless an operator asks for it. Rolling a run up loses its
+// artifacts and every detail a bucket does not keep, and an upgrade that
+// started doing that to history nobody had agreed to lose would be a bad way
+// to introduce the feature. Compaction of rollups is on: it only ever touches
+// rows this feature wrote.
+const (
+	// DefaultResultRetention keeps finished runs forever.
+	DefaultResultRetention time.Duration = 0
+
+	// DefaultHourlyRollupRetention is how long hourly buckets are kept before
+	// being compacted into daily ones. A quarter is what an availability report
+	// is usually written over; past that, nobody asks which hour.
+	DefaultHourlyRollupRetention = 90 * 24 * time.Hour
+
+	// DefaultRetentionInterval is how often a sweep is attempted. Buckets are an
+	// hour wide, so sweeping much more often than that only finds less to do.
+	DefaultRetentionInterval = 15 * time.Minute
+
+	// DefaultRetentionLease is how long one sweep may hold the right to run.
+	DefaultRetentionLease = 10 * time.Minute
+
+	// DefaultRetentionBatchSize bounds how many runs one transaction rolls up
+	// and deletes. A backlog -- the first sweep after retention is switched on
+	// for a year-old scenario -- is drained in many short transactions rather
+	// than one that holds locks on a year of history.
+	DefaultRetentionBatchSize = 500
+
+	// RetentionSweepLeaseName names the lease row guarding the sweep.
+	RetentionSweepLeaseName = "result-retention"
+)
+
+// RetentionPolicy says how long a scenario's run history is kept in full.
+type RetentionPolicy struct {
+	// Results is how long a finished run, and its artifacts, are kept after it
+	// was due. Older runs are folded into hourly rollups and deleted. Zero keeps
+	// them forever.
+	Results time.Duration `form:"results" json:"results,omitempty" yaml:"results,omitempty" xml:"results,omitempty"`
+
+	// HourlyRollups is how long an hourly rollup is kept before it is compacted
+	// into a daily one. Daily rollups are kept forever.
+	HourlyRollups time.Duration `form:"hourlyRollups" json:"hourlyRollups,omitempty" yaml:"hourlyRollups,omitempty" xml:"hourlyRollups,omitempty"`
+}
+
+// Validate refuses a policy that cannot be applied.
+func (p RetentionPolicy) Validate() error {
+	if p.Results < 0 {
+		return fmt.Errorf("retention of results must not be negative, got %v", p.Results)
+	}
+	if p.HourlyRollups < 0 {
+		return fmt.Errorf("retention of hourly rollups must not be negative, got %v", p.HourlyRollups)
+	}
+
+	return nil
+}
+
+// Override applies a scenario's override on top of this policy.
+func (p RetentionPolicy) Override(override *RetentionOverride) RetentionPolicy {
+	if override == nil {
+		return p
+	}
+
+	if override.Results != nil {
+		p.Results = *override.Results
+	}
+	if override.HourlyRollups != nil {
+		p.HourlyRollups = *override.HourlyRollups
+	}
+
+	return p
+}
+
+// RetentionOverride is a scenario's departure from the server's RetentionPolicy.
+//
+// An override only replaces the fields it sets: a scenario that wants its runs
+// kept for a week need not restate how long rollups live. The fields are
+// pointers because zero is a policy in its own right -- keep forever -- and a
+// scenario must be able to ask for it over a finite fleet default, which it
+// could not if zero also meant "not set".
+type RetentionOverride struct {
+	// Results overrides RetentionPolicy.Results. Zero keeps runs forever.
+	Results *time.Duration `form:"results" json:"results,omitempty" yaml:"results,omitempty" xml:"results,omitempty"`
+
+	// HourlyRollups overrides RetentionPolicy.HourlyRollups. Zero never compacts.
+	HourlyRollups *time.Duration `form:"hourlyRollups" json:"hourlyRollups,omitempty" yaml:"hourlyRollups,omitempty" xml:"hourlyRollups,omitempty"`
+}
+
+// Validate refuses an override that cannot be applied.
+func (o RetentionOverride) Validate() error {
+	return RetentionPolicy{}.Override(&o).Validate()
+}
+
+// validateRetention refuses a scenario whose retention override is nonsense.
+func validateRetention(policy *RetentionOverride) error {
+	if policy == nil {
+		return nil
+	}
+
+	if err := policy.Validate(); err != nil {
+		return fmt.Errorf("scenario's retention is invalid: %w", err)
+	}
+
+	return nil
+}
+
+// ScenarioRetention is one scenario's override, as the sweep reads it.
+type ScenarioRetention struct {
+	ScenarioID manifest.ResourceID
+	Policy     *RetentionOverride
+}
+
+// RetentionStore is the retention sweep's view of authoritative state.
+//
+// Whole-table questions and multi-row transactions, for the reason
+// ReconcileStore is its own interface: nothing here is addressable by UID, and
+// the roll-up-then-delete step is only correct as one transaction.
+type RetentionStore interface {
+	// AcquireSweepLease claims the right to run one sweep, reporting false when
+	// another sweep holds it.
+	AcquireSweepLease(ctx context.Context, holder string, lease time.Duration) (bool, error)
+
+	// ReleaseSweepLease gives the lease up early.
+	ReleaseSweepLease(ctx context.Context, holder string) error
+
+	// ScenarioRetentions lists every scenario that may own run history, with its
+	// override. Deleted scenarios are included: their runs outlive them, and
+	// nobody is ever going to tidy those by hand.
+	ScenarioRetentions(ctx context.Context) ([]ScenarioRetention, error)
+
+	// RollUpRuns folds up to limit of a scenario's terminal runs due before
+	// cutoff into hourly rollups, then deletes those runs and their artifacts,
+	// in one transaction. It reports how many runs were removed.
+	RollUpRuns(ctx context.Context, scenarioID manifest.ResourceID, cutoff time.Time, limit int) (int, error)
+
+	// CompactRollups folds up to limit of a scenario's hourly rollups from days
+	// that ended before cutoff into daily ones, in one transaction. It reports
+	// how many hourly rollups were folded.
+	CompactRollups(ctx context.Context, scenarioID manifest.ResourceID, cutoff time.Time, limit int) (int, error)
+}
+
+// ResultStatsStore reads the history a stats request summarises.
+type ResultStatsStore interface {
+	// StatsWindow reads a scenario's rollups and terminal runs in a range, from
+	// one consistent snapshot.
+	StatsWindow(ctx context.Context, scenarioID manifest.ResourceID, from, till time.Time) (ResultStatsWindow, error)
+}
+
+// RetentionReport is what one sweep did.
+type RetentionReport struct {
+	StartedAt time.Time     `json:"startedAt" yaml:"startedAt"`
+	Duration  time.Duration `json:"duration" yaml:"duration"`
+
+	// Skipped reports that another sweep held the lease.
+	Skipped bool `json:"skipped,omitempty" yaml:"skipped,omitempty"`
+
+	// RolledUpRuns counts runs folded into rollups and deleted.
+	RolledUpRuns int `json:"rolledUpRuns" yaml:"rolledUpRuns"`
+
+	// CompactedRollups counts hourly rollups folded into daily ones.
+	CompactedRollups int `json:"compactedRollups" yaml:"compactedRollups"`
+
+	// Failures counts scenarios whose history could not be swept. A sweep
+	// continues past them: one scenario with a bad row is no reason to let every
+	// other one grow without bound.
+	Failures int `json:"failures" yaml:"failures"`
+}
+
+// RetentionSweeper rolls old run history up and deletes it.
+//
+// A control loop in the shape of the Reconciler -- a lease, a report, a
+// RunOnce a test can drive -- and for the same reason hosted by
+// pkg/controllers: it is safe to run in every replica, and a deployment where
+// nobody remembered to start it is one where `results` grows without limit.
+type RetentionSweeper struct {
+	store RetentionStore
+
+	holder    string
+	interval  time.Duration
+	lease     time.Duration
+	batchSize int
+	defaults  RetentionPolicy
+
+	mu   sync.Mutex
+	last RetentionReport
+}
+
+// RetentionSweeperOption configures a RetentionSweeper.
+type RetentionSweeperOption func(*RetentionSweeper)
+
+// WithRetentionSweeperID names this sweeper in the lease it takes.
+func WithRetentionSweeperID(value string) RetentionSweeperOption {
+	return func(s *RetentionSweeper) { s.holder = value }
+}
+
+// WithRetentionInterval sets how often a sweep is attempted.
+func WithRetentionInterval(value time.Duration) RetentionSweeperOption {
+	return func(s *RetentionSweeper) { s.interval = value }
+}
+
+// WithRetentionLease sets how long one sweep holds the right to run.
+func WithRetentionLease(value time.Duration) RetentionSweeperOption {
+	return func(s *RetentionSweeper) { s.lease = value }
+}
+
+// WithRetentionBatchSize bounds how many runs one transaction rolls up.
+func WithRetentionBatchSize(value int) RetentionSweeperOption {
+	return func(s *RetentionSweeper) { s.batchSize = value }
+}
+
+// WithDefaultRetention sets the policy for scenarios that do not override it.
+func WithDefaultRetention(value RetentionPolicy) RetentionSweeperOption {
+	return func(s *RetentionSweeper) { s.defaults = value }
+}
+
+// NewRetentionSweeper builds a sweeper over authoritative state.
+func NewRetentionSweeper(store RetentionStore, options ...RetentionSweeperOption) *RetentionSweeper {
+	sweeper := &RetentionSweeper{
+		store:     store,
+		holder:    fmt.Sprintf("retention-%s", NewRandToken(8)),
+		interval:  DefaultRetentionInterval,
+		lease:     DefaultRetentionLease,
+		batchSize: DefaultRetentionBatchSize,
+		defaults: RetentionPolicy{
+			Results:       DefaultResultRetention,
+			HourlyRollups: DefaultHourlyRollupRetention,
+		},
+	}
+
+	for _, option := range options {
+		option(sweeper)
+	}
+
+	return sweeper
+}
+
+// Last reports the most recent sweep.
+func (s *RetentionSweeper) Last() RetentionReport {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.last
+}
+
+// RunOnce performs one sweep.
+//
+// Exposed separately from Run so tests drive it deterministically, as
+// Reconciler.RunOnce is. Each scenario is drained in batches until it has
+// nothing left past its cutoff or half the lease is spent -- the rest waits for
+// the next sweep rather than running on under a lease about to lapse.
+func (s *RetentionSweeper) RunOnce(ctx context.Context) (RetentionReport, error) {
+	report := RetentionReport{StartedAt: time.Now()}
+
+	held, err := s.store.AcquireSweepLease(ctx, s.holder, s.lease)
+	if err != nil {
+		report.Failures++
+		report.Duration = time.Since(report.StartedAt)
+		s.record(report)
+
+		return report, fmt.Errorf("failed to acquire the retention lease: %w", err)
+	}
+	if !held {
+		report.Skipped = true
+		report.Duration = time.Since(report.StartedAt)
+
+		return report, nil
+	}
+	defer func() {
+		// Detached from the caller's context for the reason the reconciler's
+		// release is: a sweep cut short by shutdown must still hand the lease on.
+		releaseCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 10*time.Second)
+		defer cancel()
+
+		if err := s.store.ReleaseSweepLease(releaseCtx, s.holder); err != nil {
+			log.Printf("retention sweeper %q failed to release its lease: %v", s.holder, err)
+		}
+	}()
+
+	scenarios, err := s.store.ScenarioRetentions(ctx)
+	if err != nil {
+		report.Failures++
+		report.Duration = time.Since(report.StartedAt)
+		s.record(report)
+
+		return report, fmt.Errorf("failed to list scenario retention policies: %w", err)
+	}
+
+	budget := report.StartedAt.Add(s.lease / 2)
+
+	var errs error
+	for _, scenario := range scenarios {
+		if ctx.Err() != nil || time.Now().After(budget) {
+			break
+		}
+
+		if err := s.sweepScenario(ctx, scenario, budget, &report); err != nil {
+			report.Failures++
+			errs = errors.Join(errs, err)
+		}
+	}
+
+	report.Duration = time.Since(report.StartedAt)
+	s.record(report)
+
+	if report.RolledUpRuns > 0 || report.CompactedRollups > 0 || report.Failures > 0 {
+		log.Printf("retention sweeper %q rolled up %d runs and compacted %d hourly rollups in %v (failures=%d)",
+			s.holder, report.RolledUpRuns, report.CompactedRollups, report.Duration, report.Failures)
+	}
+
+	return report, errs
+}
+
+// sweepScenario applies one scenario's effective policy.
+func (s *RetentionSweeper) sweepScenario(ctx context.Context, scenario ScenarioRetention, budget time.Time, report *RetentionReport) error {
+	policy := s.defaults.Override(scenario.Policy)
+	now := time.Now()
+
+	if policy.Results > 0 {
+		cutoff := now.Add(-policy.Results)
+
+		if err := s.drain(ctx, budget, func() (int, error) {
+			return s.store.RollUpRuns(ctx, scenario.ScenarioID, cutoff, s.batchSize)
+		}, &report.RolledUpRuns); err != nil {
+			return fmt.Errorf("failed to roll up runs of scenario %v: %w", scenario.ScenarioID, err)
+		}
+	}
+
+	if policy.HourlyRollups > 0 {
+		// Only whole days are compacted: a day still partly inside the hourly
+		// window keeps all of its hours until the last of them ages out.
+		cutoff := RollupDaily.Truncate(now.Add(-policy.HourlyRollups))
+
+		if err := s.drain(ctx, budget, func() (int, error) {
+			return s.store.CompactRollups(ctx, scenario.ScenarioID, cutoff, s.batchSize)
+		}, &report.CompactedRollups); err != nil {
+			return fmt.Errorf("failed to compact rollups of scenario %v: %w", scenario.ScenarioID, err)
+		}
+	}
+
+	return nil
+}
+
+// drain repeats a batch until it comes back short or the budget is spent.
+func (s *RetentionSweeper) drain(ctx context.Context, budget time.Time, batch func() (int, error), counter *int) error {
+	for ctx.Err() == nil && time.Now().Before(budget) {
+		done, err := batch()
+		if err != nil {
+			return err
+		}
+
+		*counter += done
+		if done < s.batchSize {
+			return nil
+		}
+	}
+
+	return ctx.Err()
+}
+
+func (s *RetentionSweeper) record(report RetentionReport) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.last = report
+}
+
+// Run sweeps until the context is cancelled. Errors are logged rather than
+// returned, for the reason the reconciler's are.
+func (s *RetentionSweeper) Run(ctx context.Context) error {
+	log.Printf("retention sweeper %q started (interval=%v, batch=%d, results=%v, hourly-rollups=%v)",
+		s.holder, s.interval, s.batchSize, s.defaults.Results, s.defaults.HourlyRollups)
+
+	for {
+		if _, err := s.RunOnce(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("retention sweeper %q: %v", s.holder, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			log.Printf("retention sweeper %q stopped", s.holder)
+			return ctx.Err()
+		case <-time.After(s.interval):
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package urth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sre-norns/wyrd/pkg/manifest"
+	"gorm.io/gorm"
+)
+
+// errRetentionRaced aborts a roll-up whose runs changed under it. The sweep
+// retries on its next pass; what it must never do is commit a bucket that
+// counts runs the same transaction then failed to delete.
+var errRetentionRaced = errors.New("runs changed while being rolled up")
+
+// retentionStore rolls run history up straight through gorm.
+//
+// Deliberately not through dbstore. Deleting a run here is not an operator
+// removing a resource: it is history being summarised, and a soft delete --
+// which is what the resource store does -- would keep every row the feature
+// exists to get rid of.
+type retentionStore struct {
+	db *gorm.DB
+}
+
+// NewRetentionStore returns the retention sweep's view of an existing database,
+// in the shape NewReconcileStore established.
+func NewRetentionStore(db *gorm.DB) RetentionStore {
+	return &retentionStore{db: db}
+}
+
+// NewResultStatsStore returns the stats reader over an existing database.
+func NewResultStatsStore(db *gorm.DB) ResultStatsStore {
+	return &retentionStore{db: db}
+}
+
+func (s *retentionStore) AcquireSweepLease(ctx context.Context, holder string, lease time.Duration) (bool, error) {
+	return acquireLease(ctx, s.db, RetentionSweepLeaseName, holder, lease)
+}
+
+func (s *retentionStore) ReleaseSweepLease(ctx context.Context, holder string) error {
+	return releaseLease(ctx, s.db, RetentionSweepLeaseName, holder)
+}
+
+// scan is the session used for bulk reads. Hooks are skipped for the reason
+// reconcileStore.scan gives: Result.AfterFind costs a query per row.
+func (s *retentionStore) scan(tx *gorm.DB) *gorm.DB {
+	return tx.Session(&gorm.Session{SkipHooks: true})
+}
+
+// scenarioRetentionRow is one scenario's override as stored.
+type scenarioRetentionRow struct {
+	UID       manifest.ResourceID
+	Retention *RetentionOverride `gorm:"serializer:json"`
+}
+
+func (s *retentionStore) ScenarioRetentions(ctx context.Context) ([]ScenarioRetention, error) {
+	var rows []scenarioRetentionRow
+
+	// Unscoped: a deleted scenario's runs are still in `results`, and they are
+	// exactly the history nobody is ever going to tidy by hand.
+	err := s.scan(s.db.WithContext(ctx)).
+		Unscoped().
+		Model(&Scenario{}).
+		Select("uid, retention").
+		Order("uid ASC").
+		Scan(&rows).Error
+	if err != nil {
+		return nil, fmt.Errorf("failed to read scenario retention policies: %w", err)
+	}
+
+	result := make([]ScenarioRetention, 0, len(rows))
+	for _, row := range rows {
+		result = append(result, ScenarioRetention{ScenarioID: row.UID, Policy: row.Retention})
+	}
+
+	return result, nil
+}
+
+// RollUpRuns folds old runs into rollups and deletes them, all or nothing.
+//
+// Both halves in one transaction is the whole correctness argument. A bucket
+// committed without the delete counts those runs again next sweep; a delete
+// committed without the bucket loses them from every graph. The delete is
+// re-guarded on terminal state and must remove exactly the rows that were
+// counted, or the transaction is abandoned.
+func (s *retentionStore) RollUpRuns(ctx context.Context, scenarioID manifest.ResourceID, cutoff time.Time, limit int) (int, error) {
+	var removed int
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var runs []Result
+
+		err := s.scan(tx).
+			Where("scenario_id = ?", scenarioID).
+			Where("status_status IN ?", TerminalJobStates()).
+			Where("created_at < ?", cutoff).
+			Order("created_at ASC").
+			Limit(limit).
+			Find(&runs).Error
+		if err != nil {
+			return fmt.Errorf("failed to read runs to roll up: %w", err)
+		}
+		if len(runs) == 0 {
+			return nil
+		}
+
+		accumulator := newRollupAccumulator(RollupHourly, true)
+		uids := make([]manifest.ResourceID, 0, len(runs))
+		for _, run := range runs {
+			accumulator.addResult(run)
+			uids = append(uids, run.UID)
+		}
+
+		for _, rollup := range accumulator.rollups() {
+			if err := mergeRollup(tx, rollup); err != nil {
+				return err
+			}
+		}
+
+		// Artifacts first: they reference the run.
+		if err := tx.Unscoped().Where("result_id IN ?", uids).Delete(&Artifact{}).Error; err != nil {
+			return fmt.Errorf("failed to delete artifacts of rolled-up runs: %w", err)
+		}
+
+		deleted := s.scan(tx).Unscoped().
+			Where("uid IN ?", uids).
+			Where("status_status IN ?", TerminalJobStates()).
+			Delete(&Result{})
+		if deleted.Error != nil {
+			return fmt.Errorf("failed to delete rolled-up runs: %w", deleted.Error)
+		}
+		if deleted.RowsAffected != int64(len(uids)) {
+			return errRetentionRaced
+		}
+
+		removed = len(uids)
+
+		return nil
+	})
+	if errors.Is(err, errRetentionRaced) {
+		return 0, nil
+	}
+
+	return removed, err
+}
+
+// CompactRollups folds old hourly buckets into daily ones, all or nothing, for
+// the reason RollUpRuns is.
+func (s *retentionStore) CompactRollups(ctx context.Context, scenarioID manifest.ResourceID, cutoff time.Time, limit int) (int, error) {
+	var folded int
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var hourly []ResultRollup
+
+		err := tx.
+			Where("scenario_id = ?", scenarioID).
+			Where("granularity = ?", RollupHourly).
+			Where("bucket_start < ?", cutoff).
+			Order("bucket_start ASC").
+			Limit(limit).
+			Find(&hourly).Error
+		if err != nil {
+			return fmt.Errorf("failed to read hourly rollups to compact: %w", err)
+		}
+		if len(hourly) == 0 {
+			return nil
+		}
+
+		accumulator := newRollupAccumulator(RollupDaily, true)
+		ids := make([]uint, 0, len(hourly))
+		for _, rollup := range hourly {
+			accumulator.addRollup(rollup)
+			ids = append(ids, rollup.ID)
+		}
+
+		for _, rollup := range accumulator.rollups() {
+			if err := mergeRollup(tx, rollup); err != nil {
+				return err
+			}
+		}
+
+		if err := tx.Where("id IN ?", ids).Delete(&ResultRollup{}).Error; err != nil {
+			return fmt.Errorf("failed to delete compacted hourly rollups: %w", err)
+		}
+
+		folded = len(ids)
+
+		return nil
+	})
+
+	return folded, err
+}
+
+// mergeRollup adds a bucket to the stored one with the same key, creating it if
+// there is none.
+//
+// Read-modify-write rather than an SQL increment: the outcome counts and the
+// histogram are JSON, and merging them is domain logic that belongs in one
+// place. The sweep lease keeps writers to one, and the unique index on the key
+// turns a second writer that slipped past it into a failed transaction rather
+// than a double count.
+func mergeRollup(tx *gorm.DB, rollup ResultRollup) error {
+	var stored ResultRollup
+
+	err := tx.
+		Where("scenario_id = ?", rollup.ScenarioID).
+		Where("granularity = ?", rollup.Granularity).
+		Where("bucket_start = ?", rollup.BucketStart).
+		Where("runner_id = ?", rollup.RunnerID).
+		Limit(1).
+		Find(&stored).Error
+	if err != nil {
+		return fmt.Errorf("failed to read the %v rollup at %v: %w", rollup.Granularity, rollup.BucketStart, err)
+	}
+
+	if stored.ID == 0 {
+		rollup.ID = 0
+		if err := tx.Create(&rollup).Error; err != nil {
+			return fmt.Errorf("failed to write the %v rollup at %v: %w", rollup.Granularity, rollup.BucketStart, err)
+		}
+
+		return nil
+	}
+
+	stored.merge(rollup)
+	if err := tx.Save(&stored).Error; err != nil {
+		return fmt.Errorf("failed to update the %v rollup at %v: %w", rollup.Granularity, rollup.BucketStart, err)
+	}
+
+	return nil
+}
+
+// StatsWindow reads rollups and live runs from one snapshot.
+//
+// Repeatable read, because the two reads are of the same history on either side
+// of a sweep: under read committed a sweep committing between them moves runs
+// from the second read into the first after it was taken, and the graph shows a
+// dip that never happened. SQLite has only one isolation level and ignores the
+// hint, which is fine for a test.
+func (s *retentionStore) StatsWindow(ctx context.Context, scenarioID manifest.ResourceID, from, till time.Time) (ResultStatsWindow, error) {
+	var window ResultStatsWindow
+
+	err := s.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		// Rollups are selected by overlap rather than by start, so a daily
+		// bucket that began before `from` still contributes the day it covers.
+		err := tx.
+			Where("scenario_id = ?", scenarioID).
+			Where("bucket_start < ?", till).
+			Where("bucket_start > ?", RollupDaily.Truncate(from).Add(-RollupDaily.Width())).
+			Order("bucket_start ASC").
+			Find(&window.Rollups).Error
+		if err != nil {
+			return fmt.Errorf("failed to read rollups: %w", err)
+		}
+
+		window.Rollups = overlapping(window.Rollups, from, till)
+
+		err = s.scan(tx).
+			Select("uid", "created_at", "scenario_id", "time_started", "time_ended",
+				"status_status", "status_result", "status_executor_runner_id", "status_executor_runner_name",
+				"status_maintenance", "status_upstream_failed").
+			Where("scenario_id = ?", scenarioID).
+			Where("status_status IN ?", TerminalJobStates()).
+			Where("created_at >= ?", from).
+			Where("created_at < ?", till).
+			Find(&window.Runs).Error
+		if err != nil {
+			return fmt.Errorf("failed to read runs: %w", err)
+		}
+
+		return nil
+	}, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+
+	return window, err
+}
+
+// overlapping keeps the buckets that cover any part of [from, till).
+func overlapping(rollups []ResultRollup, from, till time.Time) []ResultRollup {
+	kept := rollups[:0]
+	for _, rollup := range rollups {
+		end := rollup.BucketStart.Add(rollup.Granularity.Width())
+		if end.After(from) && rollup.BucketStart.Before(till) {
+			kept = append(kept, rollup)
+		}
+	}
+
+	return kept
+}
@@ -0,0 +1,137 @@
+package urth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sre-norns/wyrd/pkg/manifest"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeRetentionStore records what a sweep asked for. The transactional half is
+// the store's to prove; what is tested here is which cutoffs each scenario's
+// effective policy produces, and that deletion stays off unless asked for.
+type fakeRetentionStore struct {
+	held      bool
+	scenarios []ScenarioRetention
+
+	// backlog is how many runs each scenario has past any cutoff.
+	backlog map[manifest.ResourceID]int
+
+	rolledUp  map[manifest.ResourceID]time.Time
+	compacted map[manifest.ResourceID]time.Time
+}
+
+func newFakeRetentionStore(scenarios ...ScenarioRetention) *fakeRetentionStore {
+	return &fakeRetentionStore{
+		scenarios: scenarios,
+		backlog:   map[manifest.ResourceID]int{},
+		rolledUp:  map[manifest.ResourceID]time.Time{},
+		compacted: map[manifest.ResourceID]time.Time{},
+	}
+}
+
+func (s *fakeRetentionStore) AcquireSweepLease(context.Context, string, time.Duration) (bool, error) {
+	return !s.held, nil
+}
+
+func (s *fakeRetentionStore) ReleaseSweepLease(context.Context, string) error { return nil }
+
+func (s *fakeRetentionStore) ScenarioRetentions(context.Context) ([]ScenarioRetention, error) {
+	return s.scenarios, nil
+}
+
+func (s *fakeRetentionStore) RollUpRuns(_ context.Context, scenarioID manifest.ResourceID, cutoff time.Time, limit int) (int, error) {
+	s.rolledUp[scenarioID] = cutoff
+
+	done := min(s.backlog[scenarioID], limit)
+	s.backlog[scenarioID] -= done
+
+	return done, nil
+}
+
+func (s *fakeRetentionStore) CompactRollups(_ context.Context, scenarioID manifest.ResourceID, cutoff time.Time, _ int) (int, error) {
+	s.compacted[scenarioID] = cutoff
+	return 0, nil
+}
+
+func TestRetentionDeletesNothingByDefault(t *testing.T) {
+	store := newFakeRetentionStore(ScenarioRetention{ScenarioID: "a"})
+
+	report, err := NewRetentionSweeper(store).RunOnce(context.Background())
+	require.NoError(t, err)
+
+	require.Zero(t, report.RolledUpRuns)
+	require.NotContains(t, store.rolledUp, manifest.ResourceID("a"))
+	require.Contains(t, store.compacted, manifest.ResourceID("a"), "compaction only touches rollups and stays on")
+}
+
+func TestRetentionAppliesAScenarioOverride(t *testing.T) {
+	store := newFakeRetentionStore(
+		ScenarioRetention{ScenarioID: "default"},
+		ScenarioRetention{ScenarioID: "short", Policy: &RetentionOverride{Results: ptr(24 * time.Hour)}},
+	)
+
+	before := time.Now()
+	_, err := NewRetentionSweeper(store,
+		WithDefaultRetention(RetentionPolicy{Results: 30 * 24 * time.Hour, HourlyRollups: DefaultHourlyRollupRetention}),
+	).RunOnce(context.Background())
+	require.NoError(t, err)
+
+	require.WithinDuration(t, before.Add(-30*24*time.Hour), store.rolledUp["default"], time.Minute)
+	require.WithinDuration(t, before.Add(-24*time.Hour), store.rolledUp["short"], time.Minute)
+
+	// The override left hourly rollups alone, so both compact at the default,
+	// on a day boundary.
+	require.Equal(t, store.compacted["default"], store.compacted["short"])
+	require.Equal(t, RollupDaily.Truncate(store.compacted["short"]), store.compacted["short"])
+}
+
+// Keeping runs forever is a policy a scenario can ask for over a finite
+// default, not the absence of one.
+func TestRetentionOverrideCanKeepForever(t *testing.T) {
+	store := newFakeRetentionStore(
+		ScenarioRetention{ScenarioID: "default"},
+		ScenarioRetention{ScenarioID: "forever", Policy: &RetentionOverride{Results: ptr(time.Duration(0))}},
+	)
+
+	_, err := NewRetentionSweeper(store,
+		WithDefaultRetention(RetentionPolicy{Results: 30 * 24 * time.Hour, HourlyRollups: DefaultHourlyRollupRetention}),
+	).RunOnce(context.Background())
+	require.NoError(t, err)
+
+	require.Contains(t, store.rolledUp, manifest.ResourceID("default"))
+	require.NotContains(t, store.rolledUp, manifest.ResourceID("forever"))
+}
+
+func TestRetentionDrainsABacklogInBatches(t *testing.T) {
+	store := newFakeRetentionStore(ScenarioRetention{ScenarioID: "a", Policy: &RetentionOverride{Results: ptr(time.Hour)}})
+	store.backlog["a"] = 25
+
+	report, err := NewRetentionSweeper(store, WithRetentionBatchSize(10)).RunOnce(context.Background())
+	require.NoError(t, err)
+
+	require.Equal(t, 25, report.RolledUpRuns)
+	require.Zero(t, store.backlog["a"])
+}
+
+func TestRetentionSkipsWhenAnotherSweepHoldsTheLease(t *testing.T) {
+	store := newFakeRetentionStore(ScenarioRetention{ScenarioID: "a", Policy: &RetentionOverride{Results: ptr(time.Hour)}})
+	store.held = true
+
+	report, err := NewRetentionSweeper(store).RunOnce(context.Background())
+	require.NoError(t, err)
+
+	require.True(t, report.Skipped)
+	require.Empty(t, store.rolledUp)
+}
+
+func TestScenarioRetentionIsValidated(t *testing.T) {
+	require.NoError(t, validateScenario(ScenarioSpec{}))
+	require.Error(t, validateScenario(ScenarioSpec{Retention: &RetentionOverride{Results: ptr(-time.Hour)}}))
+}
+
+func ptr[T any](value T) *T {
+	return &value
+}
@@ -0,0 +1,600 @@
+package urth
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// Result rollups: what remains of a run's history once the run itself is gone.
+//
+// A scenario on a one-minute schedule writes half a million `results` rows a
+// year, each with its artifacts. Nobody reads most of them individually after a
+// few weeks, but everybody still wants the graph: "was this endpoint up last
+// March" is a question about counts and latencies, not about any one run. So
+// before retention deletes a run, its outcome and duration are folded into a
+// bucket that answers exactly that question, and the bucket is what is kept.
+//
+// Buckets are additive by construction -- counts, and a fixed-bound duration
+// histogram rather than stored percentiles -- so that folding a run in twice is
+// the only way to get them wrong, and the transaction that writes a bucket is
+// the same one that deletes the runs it was built from.
+
+// ErrInvalidStatsQuery reports a stats request that cannot be answered as asked.
+var ErrInvalidStatsQuery = errors.New("invalid stats query")
+
+// RollupGranularity is the width of one rollup bucket.
+type RollupGranularity string
+
+const (
+	// RollupHourly buckets are what a run is first folded into.
+	RollupHourly RollupGranularity = "hour"
+
+	// RollupDaily buckets are what hourly ones are compacted into once they are
+	// old enough that nobody graphs them by the hour.
+	RollupDaily RollupGranularity = "day"
+)
+
+// ParseRollupGranularity reads a granularity from a query, defaulting to hourly.
+func ParseRollupGranularity(value string) (RollupGranularity, error) {
+	switch RollupGranularity(strings.ToLower(value)) {
+	case "", RollupHourly:
+		return RollupHourly, nil
+	case RollupDaily:
+		return RollupDaily, nil
+	default:
+		return "", fmt.Errorf("%w: unknown granularity %q, expected %q or %q",
+			ErrInvalidStatsQuery, value, RollupHourly, RollupDaily)
+	}
+}
+
+// Width is the span of time one bucket covers.
+func (g RollupGranularity) Width() time.Duration {
+	if g == RollupDaily {
+		return 24 * time.Hour
+	}
+
+	return time.Hour
+}
+
+// Truncate returns the start of the bucket a moment falls into.
+//
+// Always in UTC. A daily bucket that followed the server's local time would
+// start at a different instant after a deployment moved regions, and two
+// replicas in different zones would fold the same run into different days.
+func (g RollupGranularity) Truncate(at time.Time) time.Time {
+	return at.UTC().Truncate(g.Width())
+}
+
+// coarser reports whether g spans more time than other.
+func (g RollupGranularity) coarser(other RollupGranularity) bool {
+	return g.Width() > other.Width()
+}
+
+// durationBucketBounds are the upper bounds, in seconds, of the duration
+// histogram every rollup carries.
+//
+// Fixed, and never to be edited in place: a bucket recorded against one set of
+// bounds cannot be merged with one recorded against another, and rollups are
+// kept for years. The range runs from a fast HTTP check to a browser scenario
+// near the default run ceiling; anything slower lands in the overflow bucket.
+var durationBucketBounds = []float64{
+	0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5,
+	1, 2.5, 5, 10, 30, 60, 120, 300, 600, 1800,
+}
+
+// DurationHistogram summarises how long a set of runs took.
+//
+// Percentiles are not additive -- the median of two hours is not the mean of
+// their medians -- so what is stored is the distribution, and percentiles are
+// read off it on the way out, the way Prometheus' histogram_quantile does. The
+// answer is approximate within a bucket, which is the price of being able to
+// merge an hour into a day.
+type DurationHistogram struct {
+	// Counts holds one count per bound in durationBucketBounds, plus the
+	// overflow bucket last. Not cumulative.
+	Counts []uint64 `json:"counts,omitempty" yaml:"counts,omitempty"`
+
+	SumSeconds float64 `json:"sumSeconds,omitempty" yaml:"sumSeconds,omitempty"`
+	MinSeconds float64 `json:"minSeconds,omitempty" yaml:"minSeconds,omitempty"`
+	MaxSeconds float64 `json:"maxSeconds,omitempty" yaml:"maxSeconds,omitempty"`
+}
+
+// Count is how many durations were observed.
+func (h DurationHistogram) Count() uint64 {
+	var total uint64
+	for _, count := range h.Counts {
+		total += count
+	}
+
+	return total
+}
+
+// Observe records one run's duration.
+func (h *DurationHistogram) Observe(value time.Duration) {
+	seconds := max(value.Seconds(), 0)
+
+	if len(h.Counts) != len(durationBucketBounds)+1 {
+		h.Counts = make([]uint64, len(durationBucketBounds)+1)
+	}
+
+	if h.Count() == 0 || seconds < h.MinSeconds {
+		h.MinSeconds = seconds
+	}
+	if seconds > h.MaxSeconds {
+		h.MaxSeconds = seconds
+	}
+
+	index, _ := slices.BinarySearch(durationBucketBounds, seconds)
+	h.Counts[index]++
+	h.SumSeconds += seconds
+}
+
+// Merge folds another histogram into this one.
+func (h *DurationHistogram) Merge(other DurationHistogram) {
+	otherCount := other.Count()
+	if otherCount == 0 {
+		return
+	}
+
+	if h.Count() == 0 || other.MinSeconds < h.MinSeconds {
+		h.MinSeconds = other.MinSeconds
+	}
+	if other.MaxSeconds > h.MaxSeconds {
+		h.MaxSeconds = other.MaxSeconds
+	}
+
+	if len(h.Counts) != len(durationBucketBounds)+1 {
+		h.Counts = make([]uint64, len(durationBucketBounds)+1)
+	}
+	for i, count := range other.Counts {
+		if i < len(h.Counts) {
+			h.Counts[i] += count
+		}
+	}
+
+	h.SumSeconds += other.SumSeconds
+}
+
+// Quantile estimates the duration below which the given fraction of runs fell.
+//
+// Interpolated linearly within the bucket the rank lands in, and clamped to the
+// observed minimum and maximum so that a histogram of one run reports that run's
+// duration rather than the edge of its bucket.
+func (h DurationHistogram) Quantile(q float64) time.Duration {
+	total := h.Count()
+	if total == 0 {
+		return 0
+	}
+
+	q = min(max(q, 0), 1)
+	rank := q * float64(total)
+
+	var seen float64
+	for i, count := range h.Counts {
+		if count == 0 {
+			continue
+		}
+
+		if seen+float64(count) < rank {
+			seen += float64(count)
+			continue
+		}
+
+		lower := 0.0
+		if i > 0 {
+			lower = durationBucketBounds[i-1]
+		}
+		upper := h.MaxSeconds
+		if i < len(durationBucketBounds) {
+			upper = durationBucketBounds[i]
+		}
+
+		estimate := lower + (upper-lower)*((rank-seen)/float64(count))
+		estimate = min(max(estimate, h.MinSeconds), h.MaxSeconds)
+
+		return secondsToDuration(estimate)
+	}
+
+	return secondsToDuration(h.MaxSeconds)
+}
+
+// Summary reads the figures a graph wants off the histogram.
+func (h DurationHistogram) Summary() DurationSummary {
+	count := h.Count()
+	if count == 0 {
+		return DurationSummary{}
+	}
+
+	return DurationSummary{
+		Count: count,
+		Min:   secondsToDuration(h.MinSeconds),
+		Max:   secondsToDuration(h.MaxSeconds),
+		Mean:  secondsToDuration(h.SumSeconds / float64(count)),
+		P50:   h.Quantile(0.50),
+		P90:   h.Quantile(0.90),
+		P99:   h.Quantile(0.99),
+	}
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(math.Round(seconds * float64(time.Second)))
+}
+
+// DurationSummary is what a stats reader sees of a duration histogram.
+type DurationSummary struct {
+	Count uint64        `form:"count" json:"count" yaml:"count" xml:"count"`
+	Min   time.Duration `form:"min" json:"min" yaml:"min" xml:"min"`
+	Max   time.Duration `form:"max" json:"max" yaml:"max" xml:"max"`
+	Mean  time.Duration `form:"mean" json:"mean" yaml:"mean" xml:"mean"`
+	P50   time.Duration `form:"p50" json:"p50" yaml:"p50" xml:"p50"`
+	P90   time.Duration `form:"p90" json:"p90" yaml:"p90" xml:"p90"`
+	P99   time.Duration `form:"p99" json:"p99" yaml:"p99" xml:"p99"`
+}
+
+// OutcomeCounts counts runs by how they finished.
+type OutcomeCounts map[prob.RunStatus]uint64
+
+// merge adds another set of counts into this one.
+func (c OutcomeCounts) merge(other OutcomeCounts) {
+	for outcome, count := range other {
+		c[outcome] += count
+	}
+}
+
+// ResultOutcome is how a terminal run counts in a rollup.
+//
+// Mostly the prob's own verdict. The exceptions are runs that never produced
+// one: a Result the server could not schedule is errored, and one the
+// reconciler expired already carries a timeout. Without this they would all be
+// counted under the empty status, which reads as "unknown" on a graph and is
+// the wrong answer for both.
+func ResultOutcome(result Result) prob.RunStatus {
+	if result.Status.Result != prob.RunNotFinished {
+		return result.Status.Result
+	}
+
+	switch result.Status.Status {
+	case JobExpired:
+		return prob.RunFinishedTimeout
+	case JobErrored:
+		return prob.RunFinishedError
+	default:
+		return prob.RunNotFinished
+	}
+}
+
+// ResultRollup is one bucket of summarised run history: one scenario, one
+// runner, one hour or day.
+//
+// Not a resource. Nothing creates or edits one through the API, and it has no
+// name an operator would address it by -- the same reasoning that keeps
+// DispatchOutboxEntry a plain table. Keyed by scenario UID, the way entitlement
+// is: a scenario deleted and re-applied is a new scenario with a history of its
+// own.
+//
+// Time columns carry no explicit `type:` tag, for the reason given on
+// DispatchOutboxEntry.
+type ResultRollup struct {
+	ID uint `gorm:"primaryKey;autoIncrement"`
+
+	ScenarioID  manifest.ResourceID `gorm:"not null;uniqueIndex:idx_result_rollups_bucket,priority:1"`
+	Granularity RollupGranularity   `gorm:"not null;size:8;uniqueIndex:idx_result_rollups_bucket,priority:2"`
+	BucketStart time.Time           `gorm:"not null;uniqueIndex:idx_result_rollups_bucket,priority:3"`
+
+	// RunnerID is part of the key because "is this check failing everywhere or
+	// only from one site" is the first question an availability graph is asked.
+	// Empty for runs that were never placed.
+	RunnerID   manifest.ResourceID `gorm:"not null;default:'';uniqueIndex:idx_result_rollups_bucket,priority:4"`
+	RunnerName manifest.ResourceName
+
+	Total     uint64
+	Outcomes  OutcomeCounts     `gorm:"serializer:json"`
+	Durations DurationHistogram `gorm:"serializer:json"`
+
+	// Maintenance counts, by outcome, the runs in Outcomes that were created
+	// inside a maintenance window. They still ran, so stats count them; an
+	// availability report takes them back out. Kept apart rather than left out
+	// so that rolling a run up does not change which of the two it counts in.
+	Maintenance OutcomeCounts `gorm:"serializer:json"`
+
+	// Consequential counts, by outcome, the runs in Outcomes that failed while
+	// a dependency was failing too. Kept for the reason Maintenance is: an
+	// incident made only of them is still consequential after the sweep.
+	Consequential OutcomeCounts `gorm:"serializer:json"`
+
+	UpdatedAt time.Time
+}
+
+// TableName keeps the table out of gorm's pluralisation guesswork.
+func (ResultRollup) TableName() string {
+	return "result_rollups"
+}
+
+// merge folds another bucket's contents into this one.
+func (r *ResultRollup) merge(other ResultRollup) {
+	if r.Outcomes == nil {
+		r.Outcomes = OutcomeCounts{}
+	}
+
+	r.Total += other.Total
+	r.Outcomes.merge(other.Outcomes)
+	if len(other.Maintenance) > 0 {
+		if r.Maintenance == nil {
+			r.Maintenance = OutcomeCounts{}
+		}
+		r.Maintenance.merge(other.Maintenance)
+	}
+	if len(other.Consequential) > 0 {
+		if r.Consequential == nil {
+			r.Consequential = OutcomeCounts{}
+		}
+		r.Consequential.merge(other.Consequential)
+	}
+	r.Durations.Merge(other.Durations)
+
+	// A bucket that merges runners together names none of them.
+	if r.RunnerID != "" && other.RunnerName != "" {
+		r.RunnerName = other.RunnerName
+	}
+}
+
+// rollupKey identifies one bucket while folding.
+type rollupKey struct {
+	scenario    manifest.ResourceID
+	granularity RollupGranularity
+	start       time.Time
+	runner      manifest.ResourceID
+}
+
+// rollupAccumulator folds runs and finer rollups into buckets.
+//
+// One implementation for both sides of the feature -- the retention sweep that
+// writes buckets and the stats read that serves them -- so a run contributes to
+// a graph identically whether it is still in `results` or long since rolled up.
+type rollupAccumulator struct {
+	granularity RollupGranularity
+
+	// byRunner keeps runners apart. The sweep always does; a stats read only
+	// when asked to.
+	byRunner bool
+
+	buckets map[rollupKey]*ResultRollup
+}
+
+func newRollupAccumulator(granularity RollupGranularity, byRunner bool) *rollupAccumulator {
+	return &rollupAccumulator{
+		granularity: granularity,
+		byRunner:    byRunner,
+		buckets:     map[rollupKey]*ResultRollup{},
+	}
+}
+
+// resultTime is the moment a run is bucketed by: when it was created, which is
+// when it was due. A run that sat pending for an hour belongs to the hour it
+// should have run in -- that is the hour its outcome says something about.
+func resultTime(result Result) (time.Time, bool) {
+	switch {
+	case result.CreatedAt != nil:
+		return *result.CreatedAt, true
+	case result.Spec.TimeStarted != nil:
+		return *result.Spec.TimeStarted, true
+	default:
+		return time.Time{}, false
+	}
+}
+
+// addResult folds one terminal run in. Runs that have not finished are
+// ignored: they have no outcome yet, and counting them would make the most
+// recent bucket of every graph look like a failure.
+func (a *rollupAccumulator) addResult(result Result) {
+	if !result.Status.Status.IsTerminal() {
+		return
+	}
+
+	at, ok := resultTime(result)
+	if !ok {
+		return
+	}
+
+	bucket := a.bucket(result.Spec.ScenarioID, a.granularity, a.granularity.Truncate(at),
+		result.Status.Executor.RunnerID, result.Status.Executor.RunnerName)
+
+	bucket.Total++
+	bucket.Outcomes[ResultOutcome(result)]++
+	if result.Status.Maintenance != "" {
+		if bucket.Maintenance == nil {
+			bucket.Maintenance = OutcomeCounts{}
+		}
+		bucket.Maintenance[ResultOutcome(result)]++
+	}
+	if result.Status.UpstreamFailed != "" {
+		if bucket.Consequential == nil {
+			bucket.Consequential = OutcomeCounts{}
+		}
+		bucket.Consequential[ResultOutcome(result)]++
+	}
+
+	if started, ended := result.Spec.TimeStarted, result.Spec.TimeEnded; started != nil && ended != nil {
+		bucket.Durations.Observe(ended.Sub(*started))
+	}
+}
+
+// addRollup folds an existing bucket in. A bucket coarser than the accumulator
+// keeps its own width -- a day cannot be split back into hours -- so a graph
+// over a range that spans both simply has wider bars where the history is older.
+func (a *rollupAccumulator) addRollup(rollup ResultRollup) {
+	granularity := a.granularity
+	if rollup.Granularity.coarser(granularity) {
+		granularity = rollup.Granularity
+	}
+
+	bucket := a.bucket(rollup.ScenarioID, granularity, granularity.Truncate(rollup.BucketStart),
+		rollup.RunnerID, rollup.RunnerName)
+	bucket.merge(rollup)
+}
+
+func (a *rollupAccumulator) bucket(scenario manifest.ResourceID, granularity RollupGranularity, start time.Time, runner manifest.ResourceID, runnerName manifest.ResourceName) *ResultRollup {
+	if !a.byRunner {
+		runner, runnerName = "", ""
+	}
+
+	key := rollupKey{scenario: scenario, granularity: granularity, start: start, runner: runner}
+	if bucket, ok := a.buckets[key]; ok {
+		if runnerName != "" {
+			bucket.RunnerName = runnerName
+		}
+
+		return bucket
+	}
+
+	bucket := &ResultRollup{
+		ScenarioID:  scenario,
+		Granularity: granularity,
+		BucketStart: start,
+		RunnerID:    runner,
+		RunnerName:  runnerName,
+		Outcomes:    OutcomeCounts{},
+	}
+	a.buckets[key] = bucket
+
+	return bucket
+}
+
+// rollups returns the buckets in time order, then runner order, so that a
+// listing and a write both happen in a stable sequence.
+func (a *rollupAccumulator) rollups() []ResultRollup {
+	result := make([]ResultRollup, 0, len(a.buckets))
+	for _, bucket := range a.buckets {
+		result = append(result, *bucket)
+	}
+
+	slices.SortFunc(result, func(x, y ResultRollup) int {
+		if c := x.BucketStart.Compare(y.BucketStart); c != 0 {
+			return c
+		}
+		if c := strings.Compare(string(x.RunnerID), string(y.RunnerID)); c != 0 {
+			return c
+		}
+
+		return strings.Compare(string(x.Granularity), string(y.Granularity))
+	})
+
+	return result
+}
+
+// DefaultStatsWindow is how far back a stats read looks when not told.
+const DefaultStatsWindow = 24 * time.Hour
+
+// StatsGroupByRunner asks for stats broken down per runner.
+const StatsGroupByRunner = "runner"
+
+// ResultStatsQuery selects the history a stats read summarises.
+type ResultStatsQuery struct {
+	// From and Till bound the range, by when runs were due. Till defaults to
+	// now and From to DefaultStatsWindow before it.
+	From time.Time `form:"from" json:"from,omitempty" yaml:"from,omitempty" xml:"from,omitempty" time_format:"2006-01-02T15:04:05Z07:00"`
+	Till time.Time `form:"till" json:"till,omitempty" yaml:"till,omitempty" xml:"till,omitempty" time_format:"2006-01-02T15:04:05Z07:00"`
+
+	// Granularity is the bucket width wanted. History already compacted past
+	// it is reported at its own, coarser width.
+	Granularity RollupGranularity `form:"granularity" json:"granularity,omitempty" yaml:"granularity,omitempty" xml:"granularity,omitempty"`
+
+	// GroupBy breaks buckets down further. Only "runner" is understood.
+	GroupBy string `form:"groupBy" json:"groupBy,omitempty" yaml:"groupBy,omitempty" xml:"groupBy,omitempty"`
+}
+
+// Normalize fills defaults and refuses what cannot be answered.
+func (q ResultStatsQuery) Normalize(now time.Time) (ResultStatsQuery, error) {
+	granularity, err := ParseRollupGranularity(string(q.Granularity))
+	if err != nil {
+		return q, err
+	}
+	q.Granularity = granularity
+
+	switch q.GroupBy {
+	case "", StatsGroupByRunner:
+	default:
+		return q, fmt.Errorf("%w: cannot group by %q, only by %q", ErrInvalidStatsQuery, q.GroupBy, StatsGroupByRunner)
+	}
+
+	if q.Till.IsZero() {
+		q.Till = now
+	}
+	if q.From.IsZero() {
+		q.From = q.Till.Add(-DefaultStatsWindow)
+	}
+	if !q.From.Before(q.Till) {
+		return q, fmt.Errorf("%w: from (%v) must be before till (%v)", ErrInvalidStatsQuery,
+			q.From.Format(time.RFC3339), q.Till.Format(time.RFC3339))
+	}
+
+	q.From, q.Till = q.From.UTC(), q.Till.UTC()
+
+	return q, nil
+}
+
+// ResultStats is run history for one scenario, bucketed.
+type ResultStats struct {
+	Scenario manifest.ResourceName `form:"scenario" json:"scenario" yaml:"scenario" xml:"scenario"`
+
+	// Query is the request as answered, with its defaults filled in.
+	Query ResultStatsQuery `form:"query" json:"query" yaml:"query" xml:"query"`
+
+	Buckets []ResultStatsBucket `form:"buckets" json:"buckets" yaml:"buckets" xml:"buckets"`
+}
+
+// ResultStatsBucket is one bar on a graph.
+type ResultStatsBucket struct {
+	Start       time.Time         `form:"start" json:"start" yaml:"start" xml:"start"`
+	Granularity RollupGranularity `form:"granularity" json:"granularity" yaml:"granularity" xml:"granularity"`
+
+	// Runner is set when the stats were grouped by runner.
+	RunnerID   manifest.ResourceID   `form:"runnerId,omitempty" json:"runnerId,omitempty" yaml:"runnerId,omitempty" xml:"runnerId,omitempty"`
+	RunnerName manifest.ResourceName `form:"runnerName,omitempty" json:"runnerName,omitempty" yaml:"runnerName,omitempty" xml:"runnerName,omitempty"`
+
+	Total    uint64          `form:"total" json:"total" yaml:"total" xml:"total"`
+	Outcomes OutcomeCounts   `form:"outcomes" json:"outcomes" yaml:"outcomes" xml:"outcomes"`
+	Duration DurationSummary `form:"duration" json:"duration" yaml:"duration" xml:"duration"`
+}
+
+// ResultStatsWindow is the raw material for one stats read: the rollups and the
+// not-yet-rolled-up runs in a range, read from one snapshot so a retention
+// sweep landing between the two reads neither counts a run twice nor loses it.
+type ResultStatsWindow struct {
+	Rollups []ResultRollup
+	Runs    []Result
+}
+
+// Summarize buckets a window at the query's granularity.
+func (w ResultStatsWindow) Summarize(query ResultStatsQuery) []ResultStatsBucket {
+	accumulator := newRollupAccumulator(query.Granularity, query.GroupBy == StatsGroupByRunner)
+	for _, rollup := range w.Rollups {
+		accumulator.addRollup(rollup)
+	}
+	for _, run := range w.Runs {
+		accumulator.addResult(run)
+	}
+
+	rollups := accumulator.rollups()
+	buckets := make([]ResultStatsBucket, 0, len(rollups))
+	for _, rollup := range rollups {
+		buckets = append(buckets, ResultStatsBucket{
+			Start:       rollup.BucketStart,
+			Granularity: rollup.Granularity,
+			RunnerID:    rollup.RunnerID,
+			RunnerName:  rollup.RunnerName,
+			Total:       rollup.Total,
+			Outcomes:    rollup.Outcomes,
+			Duration:    rollup.Durations.Summary(),
+		})
+	}
+
+	return buckets
+}
@@ -0,0 +1,174 @@
+package urth
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+	"github.com/stretchr/testify/require"
+)
+
+// Rollups are pure arithmetic over runs, so the properties that matter -- that
+// buckets merge without drift and that a run counts the same before and after
+// it is rolled up -- are testable without a database.
+
+var rollupEpoch = time.Date(2024, time.March, 4, 0, 0, 0, 0, time.UTC)
+
+// finishedRun builds a terminal run due at `at` that took `took`.
+func finishedRun(at time.Time, took time.Duration, outcome prob.RunStatus, runner string) Result {
+	started, ended := at, at.Add(took)
+
+	return Result{
+		ObjectMeta: manifest.ObjectMeta{
+			UID:       manifest.ResourceID("run-" + at.Format(time.RFC3339Nano)),
+			CreatedAt: &at,
+		},
+		Spec: ResultSpec{
+			ScenarioID:  "scenario",
+			TimeStarted: &started,
+			TimeEnded:   &ended,
+		},
+		Status: ResultStatus{
+			Status: JobCompleted,
+			Result: outcome,
+			Executor: ExecutorRef{
+				RunnerID:   manifest.ResourceID(runner),
+				RunnerName: manifest.ResourceName(runner),
+			},
+		},
+	}
+}
+
+func TestDurationHistogramMergeMatchesObservingEverything(t *testing.T) {
+	durations := []time.Duration{
+		3 * time.Millisecond, 40 * time.Millisecond, 200 * time.Millisecond,
+		time.Second, 7 * time.Second, 45 * time.Minute,
+	}
+
+	var whole, first, second DurationHistogram
+	for i, d := range durations {
+		whole.Observe(d)
+		if i%2 == 0 {
+			first.Observe(d)
+		} else {
+			second.Observe(d)
+		}
+	}
+
+	first.Merge(second)
+
+	require.Equal(t, whole.Counts, first.Counts)
+	require.InDelta(t, whole.SumSeconds, first.SumSeconds, 1e-9)
+	require.Equal(t, whole.MinSeconds, first.MinSeconds)
+	require.Equal(t, whole.MaxSeconds, first.MaxSeconds)
+	require.Equal(t, whole.Summary(), first.Summary())
+}
+
+func TestDurationHistogramQuantilesStayWithinObservedRange(t *testing.T) {
+	var h DurationHistogram
+	for range 99 {
+		h.Observe(100 * time.Millisecond)
+	}
+	h.Observe(20 * time.Second)
+
+	summary := h.Summary()
+	require.EqualValues(t, 100, summary.Count)
+	require.Equal(t, 100*time.Millisecond, summary.Min)
+	require.Equal(t, 20*time.Second, summary.Max)
+
+	// The median sits in the bucket every fast run landed in; interpolation
+	// must not drag it past the fastest or slowest run actually seen.
+	require.GreaterOrEqual(t, summary.P50, summary.Min)
+	require.LessOrEqual(t, summary.P50, 250*time.Millisecond)
+	require.LessOrEqual(t, summary.P99, summary.Max)
+}
+
+func TestEmptyHistogramSummarisesToZero(t *testing.T) {
+	require.Equal(t, DurationSummary{}, DurationHistogram{}.Summary())
+}
+
+func TestResultOutcomeCountsRunsThatNeverReported(t *testing.T) {
+	require.Equal(t, prob.RunFinishedTimeout, ResultOutcome(Result{Status: ResultStatus{Status: JobExpired}}))
+	require.Equal(t, prob.RunFinishedError, ResultOutcome(Result{Status: ResultStatus{Status: JobErrored}}))
+	require.Equal(t, prob.RunFinishedFailed, ResultOutcome(Result{Status: ResultStatus{Status: JobCompleted, Result: prob.RunFinishedFailed}}))
+}
+
+func TestSummarizeCountsARunTheSameBeforeAndAfterRollUp(t *testing.T) {
+	runs := []Result{
+		finishedRun(rollupEpoch.Add(5*time.Minute), 2*time.Second, prob.RunFinishedSuccess, "eu"),
+		finishedRun(rollupEpoch.Add(20*time.Minute), 3*time.Second, prob.RunFinishedFailed, "us"),
+		finishedRun(rollupEpoch.Add(70*time.Minute), time.Second, prob.RunFinishedSuccess, "eu"),
+	}
+	query := ResultStatsQuery{Granularity: RollupHourly, From: rollupEpoch, Till: rollupEpoch.Add(2 * time.Hour)}
+
+	live := ResultStatsWindow{Runs: runs}.Summarize(query)
+
+	// What the sweep would have written for the first hour, in place of the
+	// first two runs.
+	sweep := newRollupAccumulator(RollupHourly, true)
+	sweep.addResult(runs[0])
+	sweep.addResult(runs[1])
+	rolled := ResultStatsWindow{Rollups: sweep.rollups(), Runs: runs[2:]}.Summarize(query)
+
+	require.Equal(t, live, rolled)
+	require.Len(t, live, 2)
+	require.EqualValues(t, 2, live[0].Total)
+	require.EqualValues(t, 1, live[0].Outcomes[prob.RunFinishedSuccess])
+	require.EqualValues(t, 1, live[0].Outcomes[prob.RunFinishedFailed])
+	require.Empty(t, live[0].RunnerID, "runners are merged unless grouping was asked for")
+}
+
+func TestSummarizeKeepsRunnersApartWhenGrouped(t *testing.T) {
+	runs := []Result{
+		finishedRun(rollupEpoch.Add(5*time.Minute), time.Second, prob.RunFinishedSuccess, "eu"),
+		finishedRun(rollupEpoch.Add(6*time.Minute), time.Second, prob.RunFinishedFailed, "us"),
+	}
+	query := ResultStatsQuery{Granularity: RollupHourly, GroupBy: StatsGroupByRunner}
+
+	buckets := ResultStatsWindow{Runs: runs}.Summarize(query)
+
+	require.Len(t, buckets, 2)
+	require.Equal(t, manifest.ResourceName("eu"), buckets[0].RunnerName)
+	require.Equal(t, manifest.ResourceName("us"), buckets[1].RunnerName)
+}
+
+func TestSummarizeKeepsCompactedHistoryAtItsOwnWidth(t *testing.T) {
+	daily := newRollupAccumulator(RollupDaily, true)
+	daily.addResult(finishedRun(rollupEpoch.Add(3*time.Hour), time.Second, prob.RunFinishedSuccess, "eu"))
+
+	buckets := ResultStatsWindow{Rollups: daily.rollups()}.Summarize(ResultStatsQuery{Granularity: RollupHourly})
+
+	require.Len(t, buckets, 1)
+	require.Equal(t, RollupDaily, buckets[0].Granularity)
+	require.Equal(t, rollupEpoch, buckets[0].Start)
+}
+
+func TestSummarizeIgnoresUnfinishedRuns(t *testing.T) {
+	run := finishedRun(rollupEpoch, time.Second, prob.RunNotFinished, "eu")
+	run.Status.Status = JobRunning
+
+	require.Empty(t, ResultStatsWindow{Runs: []Result{run}}.Summarize(ResultStatsQuery{Granularity: RollupHourly}))
+}
+
+func TestStatsQueryNormalize(t *testing.T) {
+	now := rollupEpoch.Add(12 * time.Hour)
+
+	query, err := ResultStatsQuery{}.Normalize(now)
+	require.NoError(t, err)
+	require.Equal(t, RollupHourly, query.Granularity)
+	require.Equal(t, now, query.Till)
+	require.Equal(t, now.Add(-DefaultStatsWindow), query.From)
+
+	for name, bad := range map[string]ResultStatsQuery{
+		"unknown granularity": {Granularity: "minute"},
+		"unknown grouping":    {GroupBy: "region"},
+		"inverted range":      {From: now, Till: now.Add(-time.Hour)},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := bad.Normalize(now)
+			require.True(t, errors.Is(err, ErrInvalidStatsQuery), "got %v", err)
+		})
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"net/http"
 	"reflect"
+	"slices"
 	"strconv"
 	"strings"
 	"time"
@@ -53,7 +54,11 @@ type RunnersAPI interface {
 	ReadableResourceAPI[manifest.ResourceManifest]
 	ManageableResourceAPI
 
-	// GetToken generates a JWT token for a worker instance to auth as a Runner
+	// GetToken generates a JWT token for a worker instance to auth as a Runner.
+	//
+	// Every call issues a new token, and records it: a read of the route that
+	// serves it writes an EnrolmentToken. The records are pruned by the
+	// reconciler once they expire; see ReconcileStore.PruneEnrolmentTokens.
 	GetToken(ctx context.Context, runID manifest.ResourceName) (APIToken, bool, error)
 
 	// Authenticate a worker and receive Identity from the server
@@ -65,6 +70,20 @@ type RunnersAPI interface {
 	// AuthWorker registers a worker, returning its assigned identity, a session
 	// credential for authenticating later calls, and where to collect work.
 	AuthWorker(ctx context.Context, token APIToken, worker manifest.ResourceManifest) (WorkerRegistrationResponse, error)
+
+	// SetDraining drains every worker of a runner, or stops doing so. Workers
+	// registering while the runner is draining are drained too. Reports false
+	// if no such runner exists.
+	SetDraining(ctx context.Context, id manifest.ResourceName, draining bool) (manifest.ResourceManifest, bool, error)
+
+	// Tokens lists the enrolment tokens issued for a runner, revoked and
+	// expired ones included. Reports false if no such runner exists.
+	Tokens(ctx context.Context, id manifest.ResourceName) ([]EnrolmentToken, bool, error)
+
+	// RevokeToken takes back one of a runner's enrolment tokens: no worker may
+	// register with it again, and workers that registered with it may no longer
+	// claim runs. Reports ErrResourceNotFound if the runner has no such token.
+	RevokeToken(ctx context.Context, id manifest.ResourceName, tokenID manifest.ResourceName, request RevokeTokenRequest) (EnrolmentToken, error)
 }
 
 // WorkersAPI encapsulates APIs for the worker instances that have registered
@@ -84,9 +103,14 @@ type WorkersAPI interface {
 	// failed request.
 	SetPaused(ctx context.Context, id manifest.ResourceName, paused bool) (manifest.ResourceManifest, bool, error)
 
+	// SetDraining asks a single worker to finish its in-flight runs and leave,
+	// or withdraws the request. Reports false if no such worker is registered.
+	SetDraining(ctx context.Context, id manifest.ResourceName, draining bool) (manifest.ResourceManifest, bool, error)
+
 	// Delete revokes a worker's registration. The worker keeps its token and can
-	// register again unless its runner is disabled or its token revoked, so this
-	// is how a worker is dropped, not how it is permanently barred.
+	// register again unless its runner is disabled, its token revoked or a
+	// WorkerBlock matches it, so this is how a worker is dropped, not how it is
+	// permanently barred.
 	Delete(ctx context.Context, id manifest.VersionedResourceID) (bool, error)
 
 	// Heartbeat records that the worker holding this session is still there, and
@@ -112,6 +136,27 @@ type ScenarioAPI interface {
 	// is a poor way to learn that a fleet has changed. Reports false if no such
 	// scenario exists.
 	Placement(ctx context.Context, id manifest.ResourceName) (PlacementPreview, bool, error)
+
+	// Stats summarises a scenario's run history over a window, bucketed by hour
+	// or day: outcome counts and duration percentiles.
+	//
+	// It reads rolled-up history and live runs together, so the answer does not
+	// change shape when the retention sweep folds runs away. Reports false if no
+	// such scenario exists, and ErrInvalidStatsQuery for a query that cannot be
+	// answered.
+	Stats(ctx context.Context, id manifest.ResourceName, query ResultStatsQuery) (ResultStats, bool, error)
+
+	// Availability reports how often a scenario's target was up over a window,
+	// measured against the scenario's SLO when it has one: availability, error
+	// budget, burn rate, MTTR and the incidents behind them. Reports false if
+	// no such scenario exists, and ErrInvalidStatsQuery for a query that cannot
+	// be answered.
+	Availability(ctx context.Context, id manifest.ResourceName, query AvailabilityQuery) (AvailabilityReport, bool, error)
+
+	// Dependencies reports every scenario's dependencies with their selectors
+	// resolved, and who depends on each: the graph a consequential failure is
+	// traced back to its root through.
+	Dependencies(ctx context.Context) (DependencyGraph, error)
 }
 
 type RunResultAPI interface {
@@ -130,6 +175,12 @@ type RunResultAPI interface {
 	// dispatch envelope.
 	ClaimRun(ctx context.Context, resultUID manifest.ResourceID, session APIToken, request ClaimJobRequest) (AuthJobResponse, error)
 
+	// Cancel stops a run. A pending run is finished as `canceled` and its
+	// dispatch withdrawn; an executing one moves to `cancelling` and its worker
+	// is asked to stop. Cancelling a finished run is a conflict; cancelling one
+	// already cancelling changes nothing.
+	Cancel(ctx context.Context, runID manifest.ResourceName, request CancelRunRequest) (Result, error)
+
 	// TODO: Token can be used to look-up ID!
 	UpdateStatus(ctx context.Context, id manifest.VersionedResourceID, token APIToken, entry ResultStatus) (bark.CreatedResponse, error)
 }
@@ -184,6 +235,40 @@ type DispatchFailuresAPI interface {
 	Resolve(ctx context.Context, id manifest.ResourceName) (DispatchFailure, error)
 }
 
+// MaintenanceWindowsAPI manages planned maintenance windows. A window is an
+// operator's declaration, applied like a scenario or a runner; see
+// MaintenanceWindow for what one does to the runs it covers.
+type MaintenanceWindowsAPI interface {
+	ReadableResourceAPI[manifest.ResourceManifest]
+	ManageableResourceAPI
+}
+
+// RateLimitsAPI manages the limits on how hard the fleet probes a target. See
+// RateLimit.
+type RateLimitsAPI interface {
+	ReadableResourceAPI[manifest.ResourceManifest]
+	ManageableResourceAPI
+}
+
+// WorkerBlocksAPI manages the blocks that bar workers from registering and
+// working. See WorkerBlock.
+type WorkerBlocksAPI interface {
+	ReadableResourceAPI[manifest.ResourceManifest]
+	ManageableResourceAPI
+}
+
+// AuditLogAPI reads the audit log. There is nothing here to write with: the
+// service writes events itself as changes are made, and nothing changes one
+// once written. See AuditEvent.
+type AuditLogAPI interface {
+	ReadableResourceAPI[AuditEvent]
+
+	// Export visits every event matching the query's labels and time range,
+	// oldest first. The query's offset and limit are ignored: an export is the
+	// whole of what matched, however long.
+	Export(ctx context.Context, searchQuery manifest.SearchQuery, visit func(AuditEvent) error) error
+}
+
 type Service interface {
 	// GetLabels returns APIs to access names/labels/label values to power resource search
 	Labels(manifest.Kind) LabelsAPI
@@ -200,6 +285,18 @@ type Service interface {
 
 	// DispatchFailures reads and acts on dead-lettered dispatches.
 	DispatchFailures() DispatchFailuresAPI
+
+	// MaintenanceWindows manages planned maintenance windows.
+	MaintenanceWindows() MaintenanceWindowsAPI
+
+	// RateLimits manages the limits on load per target.
+	RateLimits() RateLimitsAPI
+
+	// WorkerBlocks manages the workers barred from the fleet.
+	WorkerBlocks() WorkerBlocksAPI
+
+	// AuditLog reads the record of who changed what.
+	AuditLog() AuditLogAPI
 }
 
 // ServiceOption configures optional service dependencies.
@@ -218,6 +315,13 @@ func WithSigningKeys(keys SigningKeys) ServiceOption {
 	return func(s *serviceImpl) { s.keys = keys }
 }
 
+// WithKeyring supplies the keys used to mint and verify tokens as a keyring,
+// which may hold several keys per tier and change them while the service runs.
+// It takes precedence over WithSigningKeys.
+func WithKeyring(keyring *Keyring) ServiceOption {
+	return func(s *serviceImpl) { s.keyring = keyring }
+}
+
 // WithWorkerTransport supplies the provider that tells a registered worker
 // where to collect its jobs. Without it, registration still succeeds but
 // returns no connection details, which is what an asynq-only deployment wants.
@@ -268,6 +372,15 @@ func WithRunnerLoad(store RunnerLoadStore) ServiceOption {
 	return func(s *serviceImpl) { s.runnerLoad = store }
 }
 
+// WithRunRates supplies the store that measures the load behind a RateLimit.
+//
+// Without it, limits can be declared and read but are not enforced. Optional
+// for the reason WithRunnerLoad is: so that a service can be built in a test
+// without a gorm handle. The API server always supplies one.
+func WithRunRates(store RunRateStore) ServiceOption {
+	return func(s *serviceImpl) { s.rates = store }
+}
+
 // WithPlacementCounter records how placement decisions are being reached.
 func WithPlacementCounter(counter PlacementCounter) ServiceOption {
 	return func(s *serviceImpl) { s.placementCounter = counter }
@@ -280,6 +393,63 @@ func WithRunnerChannelObserver(observer RunnerChannelObserver) ServiceOption {
 	return func(s *serviceImpl) { s.channels = observer }
 }
 
+// WithResultStats supplies the reader behind a scenario's stats.
+//
+// Without it a stats request is answered from nothing -- every bucket empty --
+// rather than refused: the scenario exists, and "no history recorded" is what a
+// service with no gorm handle truthfully has.
+func WithResultStats(store ResultStatsStore) ServiceOption {
+	return func(s *serviceImpl) { s.stats = store }
+}
+
+// WithRemoteWriteQueue queues every run a worker reports finished for the
+// remote writer, in the same transaction that records the outcome.
+//
+// Off unless a receiver is configured: a queue nothing drains only grows.
+func WithRemoteWriteQueue() ServiceOption {
+	return func(s *serviceImpl) { s.remoteWrite = true }
+}
+
+// WithLatencyDetector judges every successful run against its scenario's
+// latency baseline as it is reported, and labels the slow ones.
+//
+// Without it runs are recorded as reported, which is all a service with no
+// gorm handle could do anyway.
+func WithLatencyDetector(detector *LatencyDetector) ServiceOption {
+	return func(s *serviceImpl) { s.latency = detector }
+}
+
+// WithLateResultGrace sets how long past its upload grace a run still accepts a
+// report a worker spooled through an outage. Zero accepts none.
+func WithLateResultGrace(d time.Duration) ServiceOption {
+	return func(s *serviceImpl) { s.lateResultGrace = max(d, 0) }
+}
+
+// WithRunDispatches supplies the store a cancellation withdraws a pending run's
+// dispatches through.
+//
+// Without it a cancelled pending run still becomes terminal at once, and its
+// dispatch is left for the reconciler's stale-dispatch sweep to retire.
+func WithRunDispatches(store RunDispatchStore) ServiceOption {
+	return func(s *serviceImpl) { s.dispatches = store }
+}
+
+// WithRunCanceller supplies the transport that tells a worker to stop a run and
+// withdraws queued messages. Without it a cancelled run that is executing is
+// recorded as `cancelling` and finishes however its worker finishes it.
+func WithRunCanceller(canceller RunCanceller) ServiceOption {
+	return func(s *serviceImpl) { s.canceller = canceller }
+}
+
+// WithAuditLog records every change made through the service in the audit log.
+// See auditedService.
+//
+// Off unless asked for, so that a test building a service need not migrate a
+// table it never reads. The API server always asks.
+func WithAuditLog() ServiceOption {
+	return func(s *serviceImpl) { s.auditLog = true }
+}
+
 const (
 	// DefaultSessionTTL bounds a worker session. Short enough that revoking a
 	// worker takes effect within a shift, long enough that renewal is not a
@@ -297,6 +467,10 @@ func NewService(store *dbstore.DBStore, scheduler Scheduler, options ...ServiceO
 		scheduler:      scheduler,
 		sessionTTL:     DefaultSessionTTL,
 		maxRunDuration: DefaultMaxRunDuration,
+
+		lateResultGrace: DefaultLateResultGrace,
+
+		policies: newPolicyCaches(),
 	}
 
 	for _, option := range options {
@@ -305,7 +479,7 @@ func NewService(store *dbstore.DBStore, scheduler Scheduler, options ...ServiceO
 
 	// A service with no keys at all would sign with empty secrets, which is
 	// worse than the hardcoded literals this replaced. Generate instead.
-	if len(s.keys.Session) == 0 || len(s.keys.Run) == 0 || len(s.keys.Enrolment) == 0 {
+	if s.keyring == nil && (len(s.keys.Session) == 0 || len(s.keys.Run) == 0 || len(s.keys.Enrolment) == 0) {
 		keys, err := SigningKeysConfig{}.Build()
 		if err == nil {
 			if len(s.keys.Enrolment) == 0 {
@@ -320,6 +494,14 @@ func NewService(store *dbstore.DBStore, scheduler Scheduler, options ...ServiceO
 		}
 	}
 
+	if s.keyring == nil {
+		s.keyring = NewKeyring(s.keys)
+	}
+
+	if s.auditLog {
+		return &auditedService{Service: s, audit: auditor{store: s.store}}
+	}
+
 	return s
 }
 
@@ -329,6 +511,7 @@ type (
 		scheduler Scheduler
 
 		keys           SigningKeys
+		keyring        *Keyring
 		transport      WorkerTransportProvider
 		sessionTTL     time.Duration
 		maxRunDuration time.Duration
@@ -340,6 +523,22 @@ type (
 
 		runnerLoad       RunnerLoadStore
 		placementCounter PlacementCounter
+
+		rates RunRateStore
+
+		stats ResultStatsStore
+
+		remoteWrite bool
+		latency     *LatencyDetector
+
+		lateResultGrace time.Duration
+
+		dispatches RunDispatchStore
+		canceller  RunCanceller
+
+		auditLog bool
+
+		policies *policyCaches
 	}
 )
 
@@ -363,12 +562,12 @@ func (s *serviceImpl) workerHeartbeatInterval() time.Duration {
 
 func (s *serviceImpl) Runners() RunnersAPI {
 	return &runnersAPIImpl{
-		store:            s.store,
-		hmacSampleSecret: s.keys.Enrolment,
-		keys:             s.keys,
-		transport:        s.transport,
-		sessionTTL:       s.sessionTTL,
-		channels:         s.channels,
+		store:      s.store,
+		keys:       s.keyring,
+		transport:  s.transport,
+		sessionTTL: s.sessionTTL,
+		channels:   s.channels,
+		bars:       s.policies.workerBars,
 	}
 }
 
@@ -376,9 +575,10 @@ func (s *serviceImpl) Workers() WorkersAPI {
 	return &workersAPIImpl{
 		store:             s.store,
 		presence:          s.presence,
-		keys:              s.keys,
+		keys:              s.keyring,
 		heartbeatInterval: s.workerHeartbeatInterval(),
 		offlineAfter:      s.workerOfflineAfter(),
+		bars:              s.policies.workerBars,
 	}
 }
 
@@ -399,8 +599,11 @@ func (s *serviceImpl) newPlacement() placement {
 
 func (s *serviceImpl) Scenarios() ScenarioAPI {
 	return &scenarioAPIImpl{
-		store:     s.store,
-		placement: s.newPlacement(),
+		store:        s.store,
+		placement:    s.newPlacement(),
+		stats:        s.stats,
+		dependencies: s.policies.dependencies,
+		rateLimits:   s.policies.rateLimits,
 	}
 }
 
@@ -411,10 +614,16 @@ func (s *serviceImpl) Results(scenarioName manifest.ResourceName) RunResultAPI {
 		scheduler:  s.scheduler,
 		placement:  s.newPlacement(),
 
-		resultsSigningKey: s.keys.Run,
-		keys:              s.keys,
-		maxRunDuration:    s.maxRunDuration,
-		presence:          s.presence,
+		keys:            s.keyring,
+		maxRunDuration:  s.maxRunDuration,
+		presence:        s.presence,
+		remoteWrite:     s.remoteWrite,
+		latency:         s.latency,
+		lateResultGrace: s.lateResultGrace,
+		dispatches:      s.dispatches,
+		canceller:       s.canceller,
+		rates:           s.rates,
+		policies:        s.policies,
 	}
 }
 
@@ -427,15 +636,42 @@ func (s *serviceImpl) AllResults() RunResultsAPI {
 func (s *serviceImpl) Artifacts() ArtifactAPI {
 	return &artifactAPIImp{
 		store: s.store,
-
-		resultsSigningKey: s.keys.Run,
+		keys:  s.keyring,
 	}
 }
 
 func (s *serviceImpl) DispatchFailures() DispatchFailuresAPI {
 	return &dispatchFailuresAPIImpl{
 		store: s.store,
-		keys:  s.keys,
+		keys:  s.keyring,
+	}
+}
+
+func (s *serviceImpl) MaintenanceWindows() MaintenanceWindowsAPI {
+	return &maintenanceWindowsAPIImpl{
+		store:   s.store,
+		windows: s.policies.windows,
+	}
+}
+
+func (s *serviceImpl) RateLimits() RateLimitsAPI {
+	return &rateLimitsAPIImpl{
+		store:  s.store,
+		rates:  s.rates,
+		limits: s.policies.rateLimits,
+	}
+}
+
+func (s *serviceImpl) WorkerBlocks() WorkerBlocksAPI {
+	return &workerBlocksAPIImpl{
+		store: s.store,
+		bars:  s.policies.workerBars,
+	}
+}
+
+func (s *serviceImpl) AuditLog() AuditLogAPI {
+	return &auditLogAPIImpl{
+		store: s.store,
 	}
 }
 
@@ -452,6 +688,15 @@ func (s *serviceImpl) Labels(k manifest.Kind) LabelsAPI {
 type scenarioAPIImpl struct {
 	store     dbstore.TransactionalStore
 	placement placement
+	stats     ResultStatsStore
+
+	// dependencies is dropped on every write, so that the next failed run
+	// judges its dependencies as they are now declared.
+	dependencies *policyCache[dependencySnapshot]
+
+	// rateLimits is dropped likewise: the scenarios a limit covers are worked
+	// out from their labels and probs.
+	rateLimits *policyCache[rateLimitSnapshot]
 }
 
 func (m *scenarioAPIImpl) List(ctx context.Context, query manifest.SearchQuery) (results []manifest.ResourceManifest, total int64, err error) {
@@ -506,14 +751,54 @@ func (m *scenarioAPIImpl) CreateOrUpdate(ctx context.Context, newEntry manifest.
 }
 
 func (m *scenarioAPIImpl) create(ctx context.Context, newEntry Scenario) (Scenario, error) {
-	if err := validateRequirements(newEntry.Spec.Requirements); err != nil {
+	if err := validateScenario(newEntry.Spec); err != nil {
+		return newEntry, err
+	}
+
+	if err := checkDependencyCycle(ctx, m.store, newEntry); err != nil {
 		return newEntry, err
 	}
 
+	defer m.dependencies.invalidate()
+	defer m.rateLimits.invalidate()
 	err := m.store.Create(ctx, &newEntry)
 	return newEntry, err
 }
 
+// validateScenario refuses a scenario spec that can be stored but never acted
+// on.
+func validateScenario(spec ScenarioSpec) error {
+	if err := validateRequirements(spec.Requirements); err != nil {
+		return err
+	}
+
+	if err := validateRetention(spec.Retention); err != nil {
+		return err
+	}
+
+	if err := validateSLO(spec.SLO); err != nil {
+		return err
+	}
+
+	if err := spec.ConcurrencyPolicy.Validate(); err != nil {
+		return err
+	}
+
+	if err := validateDependencies(spec.DependsOn); err != nil {
+		return err
+	}
+
+	if err := spec.PriorityClass.Validate(); err != nil {
+		return err
+	}
+
+	if err := validateOnlineCapacity(spec.OnlineCapacity, spec.OnlineCapacityAfter); err != nil {
+		return err
+	}
+
+	return validateLatencyPolicy(spec.Latency)
+}
+
 // validateRequirements refuses a scenario whose placement selector cannot be
 // parsed, the way runnersAPIImpl.create already refuses a runner's.
 //
@@ -544,7 +829,7 @@ func (m *scenarioAPIImpl) update(ctx context.Context, id manifest.VersionedResou
 		return entry, bark.ErrResourceNotFound
 	}
 
-	if err := validateRequirements(entry.Spec.Requirements); err != nil {
+	if err := validateScenario(entry.Spec); err != nil {
 		return result, err
 	}
 
@@ -559,9 +844,15 @@ func (m *scenarioAPIImpl) update(ctx context.Context, id manifest.VersionedResou
 		},
 	)
 
+	if err := checkDependencyCycle(ctx, m.store, result); err != nil {
+		return result, err
+	}
+
 	log.Printf("updating scenario: prod.kind: %q, prod.type %q", result.Spec.Prob.Kind, reflect.TypeOf(result.Spec.Prob.Spec))
 	// saveResource, not Update: a scenario being switched to active=false is a
 	// zero value, which Update drops. See saveResource.
+	defer m.dependencies.invalidate()
+	defer m.rateLimits.invalidate()
 	if err := saveResource(ctx, m.store, &result); err != nil {
 		return result, err
 	}
@@ -590,9 +881,22 @@ func (m *scenarioAPIImpl) Update(ctx context.Context, id manifest.VersionedResou
 }
 
 func (m *scenarioAPIImpl) Delete(ctx context.Context, id manifest.VersionedResourceID) (bool, error) {
+	defer m.dependencies.invalidate()
+	defer m.rateLimits.invalidate()
+
 	return m.store.Delete(ctx, &Scenario{}, id.ID, id.Version)
 }
 
+// Dependencies implements ScenarioAPI.
+func (m *scenarioAPIImpl) Dependencies(ctx context.Context) (DependencyGraph, error) {
+	var all []Scenario
+	if _, err := m.store.Find(ctx, &all, manifest.SearchQuery{}); err != nil {
+		return DependencyGraph{}, fmt.Errorf("failed to list scenarios: %w", err)
+	}
+
+	return newDependencyGraph(all), nil
+}
+
 // Placement implements ScenarioAPI.
 //
 // The preview is computed from the scenario's current requirements, not from any
@@ -606,9 +910,81 @@ func (m *scenarioAPIImpl) Placement(ctx context.Context, id manifest.ResourceNam
 		return PlacementPreview{}, false, nil
 	}
 
-	preview, err := m.placement.Preview(ctx, scenario.Spec.Requirements)
+	preview, err := m.placement.Preview(ctx, scenario.Spec.Requirements, scenario.Spec.Prob.Kind)
+	if err != nil {
+		return preview, true, err
+	}
+
+	preview.applyOnlineCapacity(scenario.Spec)
+
+	return preview, true, nil
+}
+
+// Stats implements ScenarioAPI.
+//
+// Rollups are keyed by scenario UID, so the name is resolved first: a scenario
+// deleted and recreated under the same name starts a new history rather than
+// inheriting one it never ran.
+func (m *scenarioAPIImpl) Stats(ctx context.Context, id manifest.ResourceName, query ResultStatsQuery) (ResultStats, bool, error) {
+	query, err := query.Normalize(time.Now())
+	if err != nil {
+		return ResultStats{}, false, err
+	}
+
+	var scenario Scenario
+	if exists, err := m.store.GetByName(ctx, &scenario, id); err != nil {
+		return ResultStats{}, false, err
+	} else if !exists {
+		return ResultStats{}, false, nil
+	}
+
+	var window ResultStatsWindow
+	if m.stats != nil {
+		window, err = m.stats.StatsWindow(ctx, scenario.UID, query.From, query.Till)
+		if err != nil {
+			return ResultStats{}, true, err
+		}
+	}
+
+	return ResultStats{
+		Scenario: scenario.Name,
+		Query:    query,
+		Buckets:  window.Summarize(query),
+	}, true, nil
+}
+
+// Availability implements ScenarioAPI.
+//
+// Read from the same window as Stats, and so from the same snapshot of rolled
+// up and live history: a report must not change because a sweep ran between
+// two requests for it.
+func (m *scenarioAPIImpl) Availability(ctx context.Context, id manifest.ResourceName, query AvailabilityQuery) (AvailabilityReport, bool, error) {
+	var scenario Scenario
+	if exists, err := m.store.GetByName(ctx, &scenario, id); err != nil {
+		return AvailabilityReport{}, false, err
+	} else if !exists {
+		return AvailabilityReport{}, false, nil
+	}
+
+	// Normalized after the lookup, unlike Stats: the default range is the
+	// scenario's own SLO window.
+	query, err := query.Normalize(time.Now(), scenario.Spec.SLO)
+	if err != nil {
+		return AvailabilityReport{}, true, err
+	}
+
+	var window ResultStatsWindow
+	if m.stats != nil {
+		window, err = m.stats.StatsWindow(ctx, scenario.UID, query.From, query.Till)
+		if err != nil {
+			return AvailabilityReport{}, true, err
+		}
+	}
+
+	report := window.Availability(query, scenario.Spec.SLO)
+	report.Scenario = scenario.Name
 
-	return preview, true, err
+	return report, true, nil
 }
 
 func (m *scenarioAPIImpl) UpdateScript(ctx context.Context, id manifest.VersionedResourceID, prob prob.Manifest) (bark.CreatedResponse, bool, error) {
@@ -635,12 +1011,23 @@ type resultsAPIImpl struct {
 	scheduler  Scheduler
 	placement  placement
 
-	resultsSigningKey []byte
-
-	keys           SigningKeys
+	keys           TokenKeys
 	maxRunDuration time.Duration
 
 	presence WorkerPresenceStore
+
+	remoteWrite bool
+	latency     *LatencyDetector
+
+	lateResultGrace time.Duration
+
+	dispatches RunDispatchStore
+	canceller  RunCanceller
+
+	rates RunRateStore
+
+	// policies are the service's policy caches; see policyCaches.
+	policies *policyCaches
 }
 
 func (m *resultsAPIImpl) List(ctx context.Context, searchQuery manifest.SearchQuery) (results []Result, total int64, err error) {
@@ -663,7 +1050,7 @@ func (m *resultsAPIImpl) Create(ctx context.Context, newEntry manifest.ResourceM
 	// }
 
 	// Its ok to post Results without a name, in this case - we will generate a new one:
-	if newEntry.Metadata.Name == "" || strings.HasPrefix(string(newEntry.Metadata.Name), "manual-") { // Generate run name for scheduled runs
+	if newEntry.Metadata.Name == "" || triggerOf(newEntry.Metadata.Name) == TriggerManual { // Generate run name for scheduled runs
 		// log.Print("manual run, prefix: ", newEntry.Metadata.Name)
 		// newEntry.Metadata.Name = manifest.ResourceName(fmt.Sprintf("%v%v-v%v-%v", newEntry.Metadata.Name, scenario.Name, scenario.Version, randToken(32)))
 		newEntry.Metadata.Name = manifest.ResourceName(strings.ToLower(fmt.Sprintf("%v%v", newEntry.Metadata.Name, NewRandToken(16))))
@@ -743,11 +1130,55 @@ func (m *resultsAPIImpl) Create(ctx context.Context, newEntry manifest.ResourceM
 		},
 	)
 
+	// Decided here, with the rest of what the run is, so that every later path
+	// -- the outbox, a retry, an operator reading the run -- sees the one answer.
+	prioritise(&entry, entry.Spec.Scenario.Spec.PriorityClass, triggerOf(entry.Name))
+
+	// The concurrency policy is consulted before placement, so that a trigger it
+	// skips is not placed, dispatched or counted against a runner's capacity.
+	var superseded []Result
+	if policy := entry.Spec.Scenario.Spec.ConcurrencyPolicy; policy == ConcurrencyForbid || policy == ConcurrencyReplace {
+		inFlight, err := m.runsInFlight(ctx, entry.Spec.Scenario)
+		if err != nil {
+			return Result{}, err
+		}
+
+		if policy == ConcurrencyForbid && len(inFlight) > 0 {
+			log.Printf("run %q of %q skipped: %d earlier run(s) still in flight", entry.Name, entry.Spec.Scenario.Name, len(inFlight))
+			skipRun(&entry, ReasonConcurrencyForbidden, time.Now())
+
+			if err := m.createWithDispatch(ctx, &entry); err != nil {
+				return Result{}, err
+			}
+
+			return entry, nil
+		}
+
+		if policy == ConcurrencyReplace {
+			superseded = inFlight
+		}
+	}
+
+	// Rate limits likewise, and for the same reason: a run over its target's
+	// budget is not placed or dispatched, and is not load on any runner.
+	if limit, reason, err := exceededRateLimit(ctx, m.store, m.policies.rateLimits, m.rates, entry.Spec.Scenario,
+		ProbeTargetHost(snapshot.Prob), true, time.Now()); err != nil {
+		return Result{}, err
+	} else if limit != nil {
+		applyRateLimit(&entry, *limit, reason, time.Now())
+
+		if err := m.createWithDispatch(ctx, &entry); err != nil {
+			return Result{}, err
+		}
+
+		return entry, nil
+	}
+
 	// Place the run on a runner before persisting it, so the record carries the
 	// channel it was dispatched to from the moment it exists. ADR 0003 binds a
 	// scheduled Result to a Runner and leaves worker identity empty until a
 	// claim, which is exactly the shape of ExecutorRef here.
-	decision, err := m.placement.Place(ctx, snapshot.Requirements, snapshot.ScenarioName)
+	decision, err := m.placement.Place(ctx, snapshot.Requirements, snapshot.Prob.Kind, snapshot.ScenarioName)
 	if err != nil {
 		return Result{}, err
 	}
@@ -776,6 +1207,33 @@ func (m *resultsAPIImpl) Create(ctx context.Context, newEntry manifest.ResourceM
 		failRun(&entry, decision.Reason, time.Now())
 	}
 
+	// Maintenance is consulted after placement, since a window may select the
+	// runner the run is going to as well as its scenario.
+	var placedOn *Runner
+	if decision.Placed {
+		placedOn = &decision.Runner
+	}
+	now := time.Now()
+	if window, err := coveringWindow(ctx, m.store, m.policies.windows, entry.Spec.Scenario, placedOn, now); err != nil {
+		return Result{}, err
+	} else if window != nil {
+		applyMaintenance(&entry, *window, now)
+	}
+
+	// The online capacity policy last, so that a run a maintenance window
+	// already skipped is not also recorded as a fault.
+	if entry.Status.Status == JobPending {
+		switch entry.Spec.Scenario.Spec.OnlineCapacity {
+		case OnlineCapacityRequireOnline:
+			if err := m.requireOnline(ctx, &entry, decision, now); err != nil {
+				return Result{}, err
+			}
+		case OnlineCapacityFailFastAfter:
+			claimBy := now.Add(entry.Spec.Scenario.Spec.OnlineCapacityAfter)
+			entry.Status.ClaimBy = &claimBy
+		}
+	}
+
 	// TODO: Validate that request is from an authentic worker that is allowed to take jobs!
 	//
 	// The Result and its dispatch commit together or not at all. Creating the
@@ -787,6 +1245,13 @@ func (m *resultsAPIImpl) Create(ctx context.Context, newEntry manifest.ResourceM
 		return Result{}, err
 	}
 
+	// Only a run that will execute replaces anything. One that failed placement
+	// is terminal already, and cancelling the runs in flight for it would leave
+	// the scenario with nothing running at all.
+	if entry.Status.Status == JobPending {
+		m.replaceRuns(ctx, superseded, entry)
+	}
+
 	return entry, nil
 }
 
@@ -908,7 +1373,7 @@ func (m *resultsAPIImpl) Auth(ctx context.Context, resultName manifest.ResourceN
 	// Disabling a runner already stops new workers registering; without this it
 	// would not stop the ones already connected, so a runner could be "disabled"
 	// and still executing work.
-	if !runner.Spec.IsActive {
+	if !runner.acceptsWork() {
 		log.Printf("runner %q is not active; worker %q may not take job %q", runner.Name, worker.Name, resultName)
 		return AuthJobResponse{}, bark.ErrResourceUnauthorized
 	}
@@ -965,8 +1430,7 @@ func (m *resultsAPIImpl) Auth(ctx context.Context, resultName manifest.ResourceN
 		// ID: ,
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(m.resultsSigningKey)
+	tokenString, err := signToken(m.keys, KeyTierRun, claims)
 	if err != nil {
 		return AuthJobResponse{}, fmt.Errorf("failed to sign an auth token: %w", err)
 	}
@@ -1106,7 +1570,7 @@ func (m *resultsAPIImpl) ClaimRun(ctx context.Context, resultUID manifest.Resour
 		if isReclaim(entry, worker.UID, request.DispatchID) {
 			log.Printf("worker %q re-claiming %q for dispatch %v; re-issuing authorization",
 				worker.Name, entry.Name, request.DispatchID)
-			return m.authorizeRun(ctx, entry, entry.Status.Deadline)
+			return m.authorizeRun(ctx, entry, runner, entry.Status.Deadline)
 		}
 
 		// Someone else has it, or this worker has it for an older dispatch.
@@ -1117,6 +1581,45 @@ func (m *resultsAPIImpl) ClaimRun(ctx context.Context, resultUID manifest.Resour
 		return AuthJobResponse{}, claimObsolete("result is not pending")
 	}
 
+	// A run its scenario no longer wants is settled here rather than left for
+	// the reconciler: this worker would otherwise execute it, late, which is the
+	// one thing fail-fast-after exists to prevent. Only when the runner had
+	// nobody online to take it, though: a run that waited behind busy workers
+	// is exactly what this claim is for.
+	if now := time.Now(); claimDeadlinePassed(entry, now) {
+		online, err := m.placement.hasOnlineCapacity(ctx, entry)
+		if err != nil {
+			return AuthJobResponse{}, claimUnavailable("count online workers", err)
+		}
+
+		if !online {
+			log.Printf("run %q was not claimed by %v and its runner has no worker online; expiring it",
+				entry.Name, entry.Status.ClaimBy.Format(time.RFC3339))
+			m.expireUnclaimed(ctx, entry, now)
+
+			return AuthJobResponse{}, claimObsolete("result's claim deadline has passed")
+		}
+	}
+
+	// A worker that cannot run this kind says so rather than handing the
+	// message back. Deferred, like a throttled run: nothing is wrong with the
+	// run, and another worker of the runner may well be able to take it.
+	if entry.Spec.ProbKind != "" && slices.Contains(request.FailingKinds, entry.Spec.ProbKind) {
+		return AuthJobResponse{}, m.deferRun(ctx, entry, fmt.Sprintf("prob kind %q is failing on worker %q", entry.Spec.ProbKind, worker.Name))
+	}
+
+	// Likewise a worker with every slot for the kind taken. It will have room
+	// in a moment, or another worker has room now.
+	if entry.Spec.ProbKind != "" && slices.Contains(request.BusyKinds, entry.Spec.ProbKind) {
+		return AuthJobResponse{}, m.deferRun(ctx, entry, fmt.Sprintf("prob kind %q has no free slot on worker %q", entry.Spec.ProbKind, worker.Name))
+	}
+
+	// Last, of everything that can refuse a claim: the one refusal that is not
+	// about this run, and should not hide a reason that is.
+	if err := m.throttle(ctx, entry); err != nil {
+		return AuthJobResponse{}, err
+	}
+
 	// Business Rule: the server sets the deadline. A worker may ask for less
 	// time than the server allows -- and often should, so a hung probe fails
 	// rather than holding a slot -- but the ceiling is not negotiable. The
@@ -1155,7 +1658,7 @@ func (m *resultsAPIImpl) ClaimRun(ctx context.Context, resultUID manifest.Resour
 
 	log.Printf("worker %q claimed %q until %v (dispatch %v)", worker.Name, entry.Name, deadline, request.DispatchID)
 
-	return m.authorizeRun(ctx, entry, deadline)
+	return m.authorizeRun(ctx, entry, runner, deadline)
 }
 
 // loadClaimant resolves and vets the worker behind a session credential.
@@ -1175,11 +1678,25 @@ func (m *resultsAPIImpl) loadClaimant(ctx context.Context, claims WorkerSessionC
 		return worker, runner, claimForbidden("worker is paused")
 	}
 
+	// Nor does a barred one, which unlike a paused worker is not coming back.
+	if reason, err := workerBarred(ctx, m.store, m.policies.workerBars, worker); err != nil {
+		return worker, runner, claimUnavailable("check worker blocks", err)
+	} else if reason != "" {
+		return worker, runner, claimForbidden(reason)
+	}
+
 	// Business Rule: a worker of a disabled runner takes no jobs either.
 	if !runner.Spec.IsActive {
 		return worker, runner, claimForbidden("runner is disabled")
 	}
 
+	// Nor does one of a runner being deleted. Whatever it could claim was
+	// placed on a runner that will be gone before the run reports, and the
+	// reconciler is about to settle those runs itself.
+	if runner.Status.IsTerminating() {
+		return worker, runner, claimForbidden("runner is being deleted")
+	}
+
 	return worker, runner, nil
 }
 
@@ -1236,7 +1753,11 @@ func (m *resultsAPIImpl) markUnschedulable(ctx context.Context, entry Result, re
 
 // authorizeRun mints the run capability and assembles the claim response,
 // including the execution snapshot the worker needs in order to run anything.
-func (m *resultsAPIImpl) authorizeRun(_ context.Context, entry Result, deadline time.Time) (AuthJobResponse, error) {
+//
+// The runner's network settings go with it as the runner has them now, not as
+// they were when the run was scheduled: they describe where the fleet is, not
+// what the run is, and a proxy fixed since scheduling should be used.
+func (m *resultsAPIImpl) authorizeRun(_ context.Context, entry Result, runner Runner, deadline time.Time) (AuthJobResponse, error) {
 	// The stored snapshot, and nothing else. The scenario is deliberately not
 	// consulted: it is a mutable resource, and reloading it here -- which this
 	// used to do -- meant an edit or a deletion between scheduling and claiming
@@ -1259,10 +1780,15 @@ func (m *resultsAPIImpl) authorizeRun(_ context.Context, entry Result, deadline
 		// A small grace beyond the deadline, so a run that used its full budget
 		// can still report what happened. A worker that cannot upload its
 		// result is a worker whose failure looks identical to a crash.
+		//
+		// The late grace is not part of it. It is for one kind of report only,
+		// and UpdateStatus applies it to that report alone (see
+		// acceptDelivery); a capability that lasted for it would keep every
+		// other operation open for as long too.
 		ExpiresAt: jwt.NewNumericDate(deadline.Add(artifactUploadGrace)),
 	}
 
-	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(m.keys.Run)
+	signed, err := signToken(m.keys, KeyTierRun, claims)
 	if err != nil {
 		return AuthJobResponse{}, claimUnavailable("sign run capability", err)
 	}
@@ -1275,6 +1801,9 @@ func (m *resultsAPIImpl) authorizeRun(_ context.Context, entry Result, deadline
 		Prob:     snapshot.Prob,
 		Scenario: snapshot.ScenarioName,
 		Deadline: deadline,
+		Network:  runner.Spec.Network,
+
+		LateResultGrace: m.lateResultGrace,
 	}, nil
 }
 
@@ -1303,17 +1832,11 @@ func clampRunDuration(requested, maximum time.Duration) time.Duration {
 	return maximum
 }
 
-func (m *resultsAPIImpl) validateUpdateRequest(_ context.Context, entry Result, bearerToken APIToken) error {
-	token, err := jwt.Parse(string(bearerToken), func(token *jwt.Token) (any, error) {
-		// Don't forget to validate the alg is what you expect:
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-
-		// hmacSampleSecret is a []byte containing your secret, e.g. []byte("my_secret_key")
-		// return m.hmacSampleSecret, nil
-		return m.resultsSigningKey, nil // FIXME: Terribly insecure way to confirm token signature. Should use results auth-token
-	})
+// validateUpdateRequest checks that a report comes with the run's capability.
+// leeway is how long past its expiry the capability is still taken; it is zero
+// except for a report acceptDelivery may take late.
+func (m *resultsAPIImpl) validateUpdateRequest(_ context.Context, entry Result, bearerToken APIToken, leeway time.Duration) error {
+	token, err := jwt.Parse(string(bearerToken), tokenKeyfunc(m.keys, KeyTierRun), jwt.WithLeeway(leeway))
 	if err != nil {
 		return bark.ErrResourceUnauthorized
 	}
@@ -1340,18 +1863,30 @@ func (m *resultsAPIImpl) UpdateStatus(ctx context.Context, id manifest.Versioned
 	if ok, err := m.store.GetByUID(ctx, &entry, id.ID, dbstore.WithVersion(id.Version)); err != nil {
 		return bark.CreatedResponse{}, bark.ErrResourceNotFound
 	} else if !ok {
-		return bark.CreatedResponse{}, bark.ErrResourceVersionConflict
+		// The run moved on since it was claimed: an operator cancelled it, or
+		// the reconciler expired it while the worker could not get through.
+		// See acceptsStaleReport for which of those still take the report.
+		if ok, err := m.store.GetByUID(ctx, &entry, id.ID); err != nil || !ok || !acceptsStaleReport(entry, runResults.Delivery) {
+			return bark.CreatedResponse{}, bark.ErrResourceVersionConflict
+		}
 	}
 
 	// Validate API Token
-	if validationErr := m.validateUpdateRequest(ctx, entry, token); validationErr != nil {
+	if validationErr := m.validateUpdateRequest(ctx, entry, token, m.deliveryLeeway(runResults)); validationErr != nil {
 		return bark.CreatedResponse{}, validationErr
 	}
 
 	now := time.Now()
-	entry.Spec.TimeEnded = &now
+	late, err := m.acceptDelivery(entry, runResults, now)
+	if err != nil {
+		return bark.CreatedResponse{}, err
+	}
+
+	ended := deliveredAt(entry, runResults.Delivery, now)
+	entry.Spec.TimeEnded = &ended
 	entry.Status.Status = JobCompleted
 	entry.Status.Result = runResults.Result
+	entry.Status.Network = runResults.Network
 
 	entry.Labels = manifest.MergeLabels(
 		entry.Labels,
@@ -1364,10 +1899,23 @@ func (m *resultsAPIImpl) UpdateStatus(ctx context.Context, id manifest.Versioned
 		},
 	)
 
-	if ok, err := m.store.Update(ctx, &entry, entry.UID, dbstore.WithVersion(entry.Version)); err != nil {
+	if late {
+		entry.Labels[LabelResultDelivery] = DeliveryLate
+	}
+
+	policy, judged := m.judgeLatency(ctx, &entry)
+	m.judgeUpstream(ctx, &entry)
+
+	if err := m.completeRun(ctx, &entry, now); err != nil {
 		return bark.CreatedResponse{}, err
-	} else if !ok {
-		return bark.CreatedResponse{}, bark.ErrResourceVersionConflict
+	}
+
+	// Only after the commit: a run whose outcome was not recorded must not
+	// become part of what the next one is measured against.
+	if judged {
+		if err := m.latency.Observe(ctx, entry, policy); err != nil {
+			log.Print(err)
+		}
 	}
 
 	return bark.CreatedResponse{
@@ -1376,6 +1924,73 @@ func (m *resultsAPIImpl) UpdateStatus(ctx context.Context, id manifest.Versioned
 	}, nil
 }
 
+// judgeLatency labels a successful run that took anomalously long, and degrades
+// its verdict if its scenario asks for that. It reports the scenario's policy,
+// and whether the run should be folded into the baseline once it is recorded.
+//
+// Any failure is logged and the run recorded as reported. A baseline is an
+// opinion about a run; the outcome the worker reported is a fact, and must not
+// be lost for the sake of the opinion.
+func (m *resultsAPIImpl) judgeLatency(ctx context.Context, entry *Result) (*LatencyPolicy, bool) {
+	// A run inside a maintenance window measures the maintenance, and a
+	// baseline that learned from it would call the next normal run fast.
+	if m.latency == nil || entry.Status.Maintenance != "" {
+		return nil, false
+	}
+
+	var scenario Scenario
+	if ok, err := m.store.GetByUID(ctx, &scenario, entry.Spec.ScenarioID); err != nil || !ok {
+		log.Printf("not judging the latency of %q: its scenario could not be read: %v", entry.Name, err)
+		return nil, false
+	}
+	policy := scenario.Spec.Latency
+
+	verdict, err := m.latency.Judge(ctx, *entry, policy)
+	if err != nil {
+		log.Print(err)
+		return policy, true
+	}
+	if !verdict.Slow {
+		return policy, true
+	}
+
+	entry.Labels[LabelResultAnomaly] = AnomalySlow
+	if verdict.Degrade {
+		entry.Status.Result = prob.RunFinishedDegraded
+		entry.Labels[LabelResultStatus] = string(entry.Status.Result)
+	}
+
+	return policy, true
+}
+
+// completeRun commits a reported outcome and, when pushing is on, the queue row
+// that carries the run's metrics to the remote writer -- in one transaction,
+// for the reason createWithDispatch writes its outbox entry in one.
+func (m *resultsAPIImpl) completeRun(ctx context.Context, entry *Result, now time.Time) error {
+	tx, err := m.store.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open transaction to complete a run: %w", err)
+	}
+	defer tx.Rollback()
+
+	if ok, err := tx.Update(entry, entry.UID, dbstore.WithVersion(entry.Version)); err != nil {
+		return err
+	} else if !ok {
+		return bark.ErrResourceVersionConflict
+	}
+
+	// Not for a run inside a maintenance window: a probe_success of 0 pushed
+	// to the receiver is the page the window was declared to prevent.
+	if m.remoteWrite && entry.Status.Maintenance == "" {
+		queued := NewRemoteWriteEntry(*entry, now)
+		if err := tx.Create(&queued); err != nil {
+			return fmt.Errorf("failed to queue metrics of %q for remote write: %w", entry.Name, err)
+		}
+	}
+
+	return tx.Commit()
+}
+
 func (m *resultsAPIImpl) Get(ctx context.Context, id manifest.ResourceName) (result Result, exist bool, err error) {
 	exist, err = m.store.GetByName(ctx, &result, id)
 	return
@@ -1385,13 +2000,15 @@ func (m *resultsAPIImpl) Get(ctx context.Context, id manifest.ResourceName) (res
 // / Runners resources API
 // ------------------------------
 type runnersAPIImpl struct {
-	store            dbstore.TransactionalStore
-	hmacSampleSecret []byte
+	store dbstore.TransactionalStore
 
-	keys       SigningKeys
+	keys       TokenKeys
 	transport  WorkerTransportProvider
 	sessionTTL time.Duration
 	channels   RunnerChannelObserver
+
+	// bars is dropped when a token is revoked; see workerBarSnapshot.
+	bars *policyCache[workerBarSnapshot]
 }
 
 // observeChannel asks the transport what it can see of a runner's queue.
@@ -1494,7 +2111,14 @@ func (m *runnersAPIImpl) update(ctx context.Context, id manifest.VersionedResour
 		return result, bark.ErrResourceNotFound
 	}
 
-	// Validate runner's requirements
+	// A runner being deleted is not edited back to life: its queue may already
+	// be gone, and reactivating it would place runs on it that nothing could
+	// collect. Applying the manifest again once it is removed creates it anew.
+	if result.Status.IsTerminating() {
+		return result, fmt.Errorf("%w: runner %q is being deleted", bark.ErrResourceVersionConflict, result.Name)
+	}
+
+	// Validate runner's requirements
 	if _, err := newEntry.Spec.Requirements.AsSelector(); err != nil {
 		// Note, failed to parse Runner's requirements so wont be able auth any workers
 		return newEntry, fmt.Errorf("runner's requirements are invalid: %v", err)
@@ -1535,8 +2159,69 @@ func (m *runnersAPIImpl) Update(ctx context.Context, id manifest.VersionedResour
 	return result.ToManifest(), err
 }
 
+// Delete marks a runner as terminating; the reconciler removes it.
+//
+// A bare delete left everything the runner had behind: its consumer in the
+// broker forever, its queued messages until the stream aged them out, and the
+// runs placed on it pending until the reconciler gave up on their dispatch --
+// an hour and a half later, with a reason that blamed the transport. Marking it
+// instead stops it being placed on and its workers claiming at once, and leaves
+// the teardown to a loop that can retry it: the runs placed on it are expired
+// saying why, its queue is taken down, and only then does the resource go. See
+// Reconciler.finalizeRunners.
+//
+// Deleting a runner already terminating changes nothing and is not an error.
 func (m *runnersAPIImpl) Delete(ctx context.Context, id manifest.VersionedResourceID) (bool, error) {
-	return m.store.Delete(ctx, &Runner{}, id.ID, id.Version)
+	var runner Runner
+	if ok, err := m.store.GetByUID(ctx, &runner, id.ID, dbstore.WithVersion(id.Version)); err != nil || !ok {
+		return false, err
+	}
+
+	if runner.Status.IsTerminating() {
+		return true, nil
+	}
+
+	now := time.Now()
+	runner.Status.TerminatingSince = &now
+
+	// Version-guarded: a worker registering or an operator editing the runner
+	// meanwhile is a reason to read it again, not to overwrite it.
+	deleted, err := m.store.Update(ctx, &runner, runner.UID, dbstore.WithVersion(runner.Version))
+	if err != nil {
+		return false, fmt.Errorf("failed to mark runner %q for deletion: %w", runner.Name, err)
+	}
+	if deleted {
+		log.Printf("runner %q marked for deletion", runner.Name)
+	}
+
+	return deleted, nil
+}
+
+// SetDraining drains every worker of a runner, or stops doing so.
+//
+// One flag on the runner rather than one written to each worker: a worker that
+// registers while the drain is in force is drained as well, and withdrawing the
+// drain leaves alone any worker an operator drained on its own account.
+func (m *runnersAPIImpl) SetDraining(ctx context.Context, id manifest.ResourceName, draining bool) (manifest.ResourceManifest, bool, error) {
+	var runner Runner
+	if exist, err := m.store.GetByName(ctx, &runner, id); err != nil {
+		return manifest.ResourceManifest{}, false, err
+	} else if !exist {
+		return manifest.ResourceManifest{}, false, nil
+	}
+
+	if runner.Status.IsDraining == draining {
+		return runner.ToManifest(), true, nil
+	}
+
+	runner.Status.IsDraining = draining
+	if err := saveResource(ctx, m.store, &runner); err != nil {
+		return manifest.ResourceManifest{}, false, err
+	}
+
+	log.Printf("runner %q draining=%t", runner.Name, draining)
+
+	return runner.ToManifest(), true, nil
 }
 
 // ------------------------------
@@ -1567,8 +2252,9 @@ func (m *allResultsAPIImpl) Get(ctx context.Context, id manifest.ResourceName) (
 type workersAPIImpl struct {
 	store    *dbstore.DBStore
 	presence WorkerPresenceStore
+	bars     *policyCache[workerBarSnapshot]
 
-	keys              SigningKeys
+	keys              TokenKeys
 	heartbeatInterval time.Duration
 	offlineAfter      time.Duration
 }
@@ -1579,12 +2265,31 @@ type workersAPIImpl struct {
 // rule then has one definition, no sweep's lag becomes part of the answer, and a
 // record read a moment after a worker went quiet reports it correctly rather than
 // waiting for something to notice.
-func (m *workersAPIImpl) withPresence(model WorkerInstance, now time.Time) manifest.ResourceManifest {
-	model.Status.Presence = WorkerPresenceAt(model.Status, now, m.offlineAfter)
+func (m *workersAPIImpl) withPresence(model WorkerInstance, runnerDraining bool, now time.Time) manifest.ResourceManifest {
+	model.Status.Presence = workerPresenceAt(model.Status, runnerDraining, now, m.offlineAfter)
 
 	return model.ToManifest()
 }
 
+// runnerDraining reports whether a worker's runner has been drained or deleted,
+// remembering the answer in seen so that a page of workers costs one read per
+// runner rather than one per worker. A runner that no longer exists is not
+// draining.
+func (m *workersAPIImpl) runnerDraining(ctx context.Context, runnerID manifest.ResourceID, seen map[manifest.ResourceID]bool) (bool, error) {
+	if draining, ok := seen[runnerID]; ok {
+		return draining, nil
+	}
+
+	var runner Runner
+	if _, err := m.store.GetByUID(ctx, &runner, runnerID); err != nil {
+		return false, err
+	}
+
+	seen[runnerID] = runner.drainsWorkers()
+
+	return seen[runnerID], nil
+}
+
 func (m *workersAPIImpl) List(ctx context.Context, searchQuery manifest.SearchQuery) (results []manifest.ResourceManifest, total int64, err error) {
 	var models []WorkerInstance
 	total, err = m.store.Find(ctx, &models, searchQuery, dbstore.OrderByCreatedAt(dbstore.OrderAscending))
@@ -1595,10 +2300,16 @@ func (m *workersAPIImpl) List(ctx context.Context, searchQuery manifest.SearchQu
 	// One clock reading for the whole page, so two workers last heard from at the
 	// same moment cannot be graded differently by the time the loop reaches them.
 	now := time.Now()
+	runners := make(map[manifest.ResourceID]bool)
 
 	results = make([]manifest.ResourceManifest, 0, len(models))
 	for _, model := range models {
-		results = append(results, m.withPresence(model, now))
+		draining, err := m.runnerDraining(ctx, model.Spec.RunnerID, runners)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		results = append(results, m.withPresence(model, draining, now))
 	}
 
 	return
@@ -1607,7 +2318,15 @@ func (m *workersAPIImpl) List(ctx context.Context, searchQuery manifest.SearchQu
 func (m *workersAPIImpl) Get(ctx context.Context, id manifest.ResourceName) (result manifest.ResourceManifest, exist bool, err error) {
 	var model WorkerInstance
 	exist, err = m.store.GetByName(ctx, &model, id)
-	result = m.withPresence(model, time.Now())
+	if !exist || err != nil {
+		return model.ToManifest(), exist, err
+	}
+
+	draining, err := m.runnerDraining(ctx, model.Spec.RunnerID, make(map[manifest.ResourceID]bool))
+	if err != nil {
+		return result, exist, err
+	}
+	result = m.withPresence(model, draining, time.Now())
 
 	return
 }
@@ -1636,12 +2355,33 @@ func (m *workersAPIImpl) Heartbeat(ctx context.Context, session APIToken, reques
 		return WorkerHeartbeatResponse{}, bark.ErrResourceNotFound
 	}
 
+	// A barred worker's heartbeats are refused rather than recorded: it is not
+	// part of the fleet, and its presence should not read as if it were.
+	if reason, err := workerBarred(ctx, m.store, m.bars, worker); err != nil {
+		return WorkerHeartbeatResponse{}, err
+	} else if reason != "" {
+		log.Printf("refused heartbeat of worker %q: %s", worker.Name, reason)
+		return WorkerHeartbeatResponse{}, bark.ErrResourceUnauthorized
+	}
+
 	if m.presence != nil {
 		if found, err := m.presence.RecordContact(ctx, claims.WorkerID, time.Now(), WorkerContactHeartbeat, request.Leaving); err != nil {
 			return WorkerHeartbeatResponse{}, err
 		} else if !found {
 			return WorkerHeartbeatResponse{}, bark.ErrResourceNotFound
 		}
+
+		// Written with every heartbeat, an empty report included: that is how
+		// a kind that has started passing again stops being held against the
+		// worker.
+		if _, err := m.presence.RecordSelfTest(ctx, claims.WorkerID, request.SelfTestFailures); err != nil {
+			return WorkerHeartbeatResponse{}, err
+		}
+	}
+
+	runnerDraining, err := m.runnerDraining(ctx, worker.Spec.RunnerID, make(map[manifest.ResourceID]bool))
+	if err != nil {
+		return WorkerHeartbeatResponse{}, err
 	}
 
 	return WorkerHeartbeatResponse{
@@ -1649,6 +2389,10 @@ func (m *workersAPIImpl) Heartbeat(ctx context.Context, session APIToken, reques
 		// Told rather than left to be discovered by having claims refused: a
 		// paused worker can then stop asking, and its logs say why.
 		Paused: worker.Status.IsPaused,
+		// The heartbeat is the only way a drain reaches a worker, so one is
+		// noticed within an interval of being asked for. A leaving heartbeat is
+		// told too; it has nothing left to do with the answer.
+		Drain: drainRequested(worker.Status, runnerDraining),
 	}, nil
 }
 
@@ -1693,6 +2437,33 @@ func (m *workersAPIImpl) SetPaused(ctx context.Context, id manifest.ResourceName
 	return worker.ToManifest(), true, nil
 }
 
+// SetDraining asks a single worker to finish what it is running and leave, or
+// withdraws the request.
+//
+// Stored and written exactly as SetPaused does, for the same reasons. The worker
+// learns of it from its next heartbeat; nothing here reaches out to it.
+func (m *workersAPIImpl) SetDraining(ctx context.Context, id manifest.ResourceName, draining bool) (manifest.ResourceManifest, bool, error) {
+	var worker WorkerInstance
+	if exist, err := m.store.GetByName(ctx, &worker, id); err != nil {
+		return manifest.ResourceManifest{}, false, err
+	} else if !exist {
+		return manifest.ResourceManifest{}, false, nil
+	}
+
+	if worker.Status.IsDraining == draining {
+		return worker.ToManifest(), true, nil
+	}
+
+	worker.Status.IsDraining = draining
+	if err := saveResource(ctx, m.store, &worker); err != nil {
+		return manifest.ResourceManifest{}, false, err
+	}
+
+	log.Printf("worker %q draining=%t", worker.Name, draining)
+
+	return worker.ToManifest(), true, nil
+}
+
 func (m *workersAPIImpl) Delete(ctx context.Context, id manifest.VersionedResourceID) (bool, error) {
 	return m.store.Delete(ctx, &WorkerInstance{}, id.ID, id.Version)
 }
@@ -1751,22 +2522,31 @@ func (m *runnersAPIImpl) GetToken(ctx context.Context, runnerName manifest.Resou
 	}
 
 	now := time.Now()
+	expiresAt := now.Add(EnrolmentTokenTTL)
+	id := issuedTokenID()
 	// Generate JWT with valid-until clause, to give worker a time to post
 	claims := &jwt.RegisteredClaims{
 		// ExpiresAt: jwt.NewNumericDate(time.Now().Add(30 * time.Minute)),
 		IssuedAt:  jwt.NewNumericDate(now),
-		ExpiresAt: jwt.NewNumericDate(now.Add(23 * time.Hour)),
+		ExpiresAt: jwt.NewNumericDate(expiresAt),
 		Subject:   string(runner.UID),
 		// Issuer: ,
-		// ID: ,
+		ID: id,
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	tokenString, err := token.SignedString(m.hmacSampleSecret)
+	tokenString, err := signToken(m.keys, KeyTierEnrolment, claims)
 	if err != nil {
 		return APIToken(tokenString), true, fmt.Errorf("failed to sign the JWT token: %w", err)
 	}
 
+	// Recorded before it is handed out, and not handed out if it cannot be: a
+	// token nobody recorded is one nobody can revoke, and registration refuses
+	// it anyway.
+	record := newEnrolmentToken(runner, id, APIToken(tokenString), now, expiresAt)
+	if err := m.store.Create(ctx, &record); err != nil {
+		return APIToken(""), true, fmt.Errorf("failed to record the enrolment token: %w", err)
+	}
+
 	return APIToken(tokenString), true, nil
 }
 
@@ -1846,15 +2626,7 @@ func (m *runnersAPIImpl) admitWorker(ctx context.Context, apiToken APIToken, new
 	var result Runner
 	var registered WorkerInstance
 
-	token, err := jwt.Parse(string(apiToken), func(token *jwt.Token) (any, error) {
-		// Don't forget to validate the alg is what you expect:
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-
-		// hmacSampleSecret is a []byte containing your secret, e.g. []byte("my_secret_key")
-		return m.hmacSampleSecret, nil
-	})
+	token, err := jwt.Parse(string(apiToken), tokenKeyfunc(m.keys, KeyTierEnrolment))
 	if err != nil {
 		return result, registered, bark.ErrResourceUnauthorized
 	}
@@ -1872,11 +2644,21 @@ func (m *runnersAPIImpl) admitWorker(ctx context.Context, apiToken APIToken, new
 		return result, registered, bark.ErrResourceUnauthorized
 	}
 
-	// Business Rule: Runner must be active to accept new workers auth
-	if !runner.Spec.IsActive {
+	// Business Rule: Runner must be active, and not being deleted, to accept new
+	// workers auth
+	if !runner.acceptsWork() {
 		return result, registered, bark.ErrResourceUnauthorized
 	}
 
+	// A token that names itself must still be on record and not revoked. One
+	// that does not predates the record, and expires on its own.
+	tokenID := enrolmentTokenID(token)
+	if tokenID != "" {
+		if err := checkEnrolment(ctx, m.store, tokenID, runner); err != nil {
+			return result, registered, err
+		}
+	}
+
 	// It's ok to have nameless workers, will generate name if none provided
 	// if newEntry.Metadata.Name == "" {
 	// 	newEntry.Metadata.Name = manifest.ResourceName(NewRandToken(16))
@@ -1912,6 +2694,22 @@ func (m *runnersAPIImpl) admitWorker(ctx context.Context, apiToken APIToken, new
 	// TODO: Should do min with pre-set TTL
 	worker.Status.TTL = worker.Spec.RequestedTTL
 	worker.Spec.Runner = runner
+	worker.Status.EnrolmentTokenID = tokenID
+	worker.Status.EnrolmentFingerprint = EnrolmentFingerprint(apiToken)
+
+	// Business Rule: a blocked worker may not register, whatever token it holds.
+	// Judged on the worker as it would be recorded, so a block by fingerprint
+	// matches the token it is presenting now, and on the blocks as they are
+	// rather than as cached: registration is rare, and a block declared a
+	// moment ago should keep out the host that prompted it.
+	blocks, err := loadWorkerBlocks(ctx, m.store)
+	if err != nil {
+		return result, registered, err
+	}
+	if block := matchingWorkerBlock(blocks, worker); block != nil {
+		log.Printf("refused worker %q of runner %q: barred by block %q", worker.Name, runner.Name, block.Name)
+		return result, registered, bark.ErrResourceUnauthorized
+	}
 
 	log.Printf("Runner has %d workers matches", len(runner.Status.Instances))
 	if len(runner.Status.Instances) > 0 && runner.Status.Instances[0].Name == worker.Name {
@@ -1926,6 +2724,10 @@ func (m *runnersAPIImpl) admitWorker(ctx context.Context, apiToken APIToken, new
 		existingWorkerRecord.Labels = manifest.MergeLabels(worker.Labels, workerLabels(runner))
 		worker.Spec.RunnerID = existingWorkerRecord.Spec.RunnerID
 		existingWorkerRecord.Spec = worker.Spec
+		// The exception: which token it came in with is the server's record
+		// of this registration, not the worker's claim.
+		existingWorkerRecord.Status.EnrolmentTokenID = worker.Status.EnrolmentTokenID
+		existingWorkerRecord.Status.EnrolmentFingerprint = worker.Status.EnrolmentFingerprint
 
 		_, err = m.store.Update(ctx, &existingWorkerRecord, existingWorkerRecord.UID, dbstore.WithVersion(existingWorkerRecord.Version))
 		registered = existingWorkerRecord
@@ -1950,8 +2752,7 @@ func (m *runnersAPIImpl) admitWorker(ctx context.Context, apiToken APIToken, new
 // ------------------------------
 type artifactAPIImp struct {
 	store dbstore.TransactionalStore
-
-	resultsSigningKey []byte
+	keys  TokenKeys
 }
 
 func (m *artifactAPIImp) List(ctx context.Context, query manifest.SearchQuery) (results []manifest.ResourceManifest, total int64, err error) {
@@ -2024,15 +2825,7 @@ func artifactLabels(workerLabels manifest.Labels, spec ArtifactSpec, result Resu
 }
 
 func (m *artifactAPIImp) Create(ctx context.Context, apiToken APIToken, newEntry manifest.ResourceManifest) (manifest.ResourceManifest, error) {
-	token, err := jwt.Parse(string(apiToken), func(token *jwt.Token) (any, error) {
-		// Don't forget to validate the alg is what you expect:
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-
-		// hmacSampleSecret is a []byte containing your secret, e.g. []byte("my_secret_key")
-		return m.resultsSigningKey, nil
-	})
+	token, err := jwt.Parse(string(apiToken), tokenKeyfunc(m.keys, KeyTierRun))
 	if err != nil {
 		return manifest.ResourceManifest{}, bark.ErrResourceUnauthorized
 	}
@@ -2099,6 +2892,16 @@ func kindToModel(kind manifest.Kind) (model any, found bool) {
 		return &Artifact{}, true
 	case KindDispatchFailure:
 		return &DispatchFailure{}, true
+	case KindMaintenanceWindow:
+		return &MaintenanceWindow{}, true
+	case KindRateLimit:
+		return &RateLimit{}, true
+	case KindEnrolmentToken:
+		return &EnrolmentToken{}, true
+	case KindWorkerBlock:
+		return &WorkerBlock{}, true
+	case KindAuditEvent:
+		return &AuditEvent{}, true
 	default:
 		return nil, false
 	}
@@ -2146,7 +2949,7 @@ func (m *labelsAPIImpl) ListLabelValues(ctx context.Context, label string, searc
 // dispatchFailuresAPIImpl is the operational dead-letter surface.
 type dispatchFailuresAPIImpl struct {
 	store *dbstore.DBStore
-	keys  SigningKeys
+	keys  TokenKeys
 }
 
 // List returns failures newest first. A dead-letter list is read to find what
@@ -2288,3 +3091,391 @@ func (m *dispatchFailuresAPIImpl) Resolve(ctx context.Context, id manifest.Resou
 
 	return updated, err
 }
+
+// ------------------------------
+// / Maintenance windows API
+// ------------------------------
+
+type maintenanceWindowsAPIImpl struct {
+	store dbstore.TransactionalStore
+
+	// windows is dropped on every write, so that the next run created sees
+	// the window as written.
+	windows *policyCache[windowSnapshot]
+}
+
+// List returns windows in the order they were declared, each with its status as
+// of now.
+func (m *maintenanceWindowsAPIImpl) List(ctx context.Context, searchQuery manifest.SearchQuery) (results []manifest.ResourceManifest, total int64, err error) {
+	var models []MaintenanceWindow
+	total, err = m.store.Find(ctx, &models, searchQuery, dbstore.OrderByCreatedAt(dbstore.OrderAscending))
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	results = make([]manifest.ResourceManifest, 0, len(models))
+	for _, model := range models {
+		results = append(results, model.withStatus(now).ToManifest())
+	}
+
+	return
+}
+
+func (m *maintenanceWindowsAPIImpl) Get(ctx context.Context, id manifest.ResourceName) (result manifest.ResourceManifest, exists bool, err error) {
+	var model MaintenanceWindow
+	if exists, err = m.store.GetByName(ctx, &model, id); err != nil || !exists {
+		return
+	}
+
+	return model.withStatus(time.Now()).ToManifest(), true, nil
+}
+
+func (m *maintenanceWindowsAPIImpl) CreateOrUpdate(ctx context.Context, newEntry manifest.ResourceManifest) (manifest.ResourceManifest, bool, error) {
+	window, err := NewMaintenanceWindow(newEntry)
+	if err != nil {
+		return manifest.ResourceManifest{}, false, err
+	}
+
+	var existing MaintenanceWindow
+	if exists, err := m.store.GetByName(ctx, &existing, window.Name); err != nil {
+		return manifest.ResourceManifest{}, false, err
+	} else if !exists {
+		result, err := m.create(ctx, window)
+		return result.ToManifest(), true, err
+	}
+
+	result, err := m.update(ctx, existing.GetVersionedID(), window)
+	return result.ToManifest(), false, err
+}
+
+func (m *maintenanceWindowsAPIImpl) Create(ctx context.Context, newEntry manifest.ResourceManifest) (manifest.ResourceManifest, error) {
+	window, err := NewMaintenanceWindow(newEntry)
+	if err != nil {
+		return manifest.ResourceManifest{}, err
+	}
+
+	result, err := m.create(ctx, window)
+	return result.ToManifest(), err
+}
+
+func (m *maintenanceWindowsAPIImpl) Update(ctx context.Context, id manifest.VersionedResourceID, newEntry manifest.ResourceManifest) (manifest.ResourceManifest, error) {
+	window, err := NewMaintenanceWindow(newEntry)
+	if err != nil {
+		return manifest.ResourceManifest{}, err
+	}
+
+	result, err := m.update(ctx, id, window)
+	return result.ToManifest(), err
+}
+
+// Delete removes a window. Runs it already covered keep their label: see
+// MaintenanceWindow for why the decision is never revisited.
+func (m *maintenanceWindowsAPIImpl) Delete(ctx context.Context, id manifest.VersionedResourceID) (bool, error) {
+	defer m.windows.invalidate()
+
+	return m.store.Delete(ctx, &MaintenanceWindow{}, id.ID, id.Version)
+}
+
+func (m *maintenanceWindowsAPIImpl) create(ctx context.Context, window MaintenanceWindow) (MaintenanceWindow, error) {
+	if err := window.Spec.Validate(); err != nil {
+		return window, err
+	}
+
+	window.Status = MaintenanceWindowStatus{}
+	defer m.windows.invalidate()
+	if err := m.store.Create(ctx, &window); err != nil {
+		return window, err
+	}
+
+	return window.withStatus(time.Now()), nil
+}
+
+func (m *maintenanceWindowsAPIImpl) update(ctx context.Context, id manifest.VersionedResourceID, entry MaintenanceWindow) (MaintenanceWindow, error) {
+	var result MaintenanceWindow
+	if ok, err := m.store.GetByUID(ctx, &result, id.ID, dbstore.WithVersion(id.Version)); err != nil {
+		return result, err
+	} else if !ok {
+		return result, bark.ErrResourceNotFound
+	}
+
+	if result.Name != entry.Name {
+		return entry, bark.ErrResourceNotFound
+	}
+
+	if err := entry.Spec.Validate(); err != nil {
+		return result, err
+	}
+
+	result.Spec = entry.Spec
+	result.Labels = entry.Labels
+
+	// saveResource, for the reason scenarioAPIImpl.update gives: a selector
+	// emptied or a schedule removed is a zero value Update would drop.
+	defer m.windows.invalidate()
+	if err := saveResource(ctx, m.store, &result); err != nil {
+		return result, err
+	}
+
+	return result.withStatus(time.Now()), nil
+}
+
+// ------------------------------
+// / Rate limits API
+// ------------------------------
+
+type rateLimitsAPIImpl struct {
+	store dbstore.TransactionalStore
+	rates RunRateStore
+
+	// limits is dropped on every write, so that the next run is judged by the
+	// limits as they are now declared.
+	limits *policyCache[rateLimitSnapshot]
+}
+
+// List returns limits in the order they were declared, each with the load on
+// it as of now.
+func (m *rateLimitsAPIImpl) List(ctx context.Context, searchQuery manifest.SearchQuery) (results []manifest.ResourceManifest, total int64, err error) {
+	var models []RateLimit
+	total, err = m.store.Find(ctx, &models, searchQuery, dbstore.OrderByCreatedAt(dbstore.OrderAscending))
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	results = make([]manifest.ResourceManifest, 0, len(models))
+	for _, model := range models {
+		results = append(results, model.withStatus(ctx, m.store, m.limits, m.rates, now).ToManifest())
+	}
+
+	return
+}
+
+func (m *rateLimitsAPIImpl) Get(ctx context.Context, id manifest.ResourceName) (result manifest.ResourceManifest, exists bool, err error) {
+	var model RateLimit
+	if exists, err = m.store.GetByName(ctx, &model, id); err != nil || !exists {
+		return
+	}
+
+	return model.withStatus(ctx, m.store, m.limits, m.rates, time.Now()).ToManifest(), true, nil
+}
+
+func (m *rateLimitsAPIImpl) CreateOrUpdate(ctx context.Context, newEntry manifest.ResourceManifest) (manifest.ResourceManifest, bool, error) {
+	limit, err := NewRateLimit(newEntry)
+	if err != nil {
+		return manifest.ResourceManifest{}, false, err
+	}
+
+	var existing RateLimit
+	if exists, err := m.store.GetByName(ctx, &existing, limit.Name); err != nil {
+		return manifest.ResourceManifest{}, false, err
+	} else if !exists {
+		result, err := m.create(ctx, limit)
+		return result.ToManifest(), true, err
+	}
+
+	result, err := m.update(ctx, existing.GetVersionedID(), limit)
+	return result.ToManifest(), false, err
+}
+
+func (m *rateLimitsAPIImpl) Create(ctx context.Context, newEntry manifest.ResourceManifest) (manifest.ResourceManifest, error) {
+	limit, err := NewRateLimit(newEntry)
+	if err != nil {
+		return manifest.ResourceManifest{}, err
+	}
+
+	result, err := m.create(ctx, limit)
+	return result.ToManifest(), err
+}
+
+func (m *rateLimitsAPIImpl) Update(ctx context.Context, id manifest.VersionedResourceID, newEntry manifest.ResourceManifest) (manifest.ResourceManifest, error) {
+	limit, err := NewRateLimit(newEntry)
+	if err != nil {
+		return manifest.ResourceManifest{}, err
+	}
+
+	result, err := m.update(ctx, id, limit)
+	return result.ToManifest(), err
+}
+
+// Delete removes a limit. Runs it kept from being admitted stay skipped and
+// labelled; runs it was deferring are claimed on their next delivery.
+func (m *rateLimitsAPIImpl) Delete(ctx context.Context, id manifest.VersionedResourceID) (bool, error) {
+	defer m.limits.invalidate()
+
+	return m.store.Delete(ctx, &RateLimit{}, id.ID, id.Version)
+}
+
+func (m *rateLimitsAPIImpl) create(ctx context.Context, limit RateLimit) (RateLimit, error) {
+	if err := limit.Spec.Validate(); err != nil {
+		return limit, err
+	}
+
+	limit.Status = RateLimitStatus{}
+	err := m.store.Create(ctx, &limit)
+	// Dropped before the status is measured, which reads the snapshot again:
+	// the one cached may hold no scenarios at all, if there were no limits.
+	m.limits.invalidate()
+	if err != nil {
+		return limit, err
+	}
+
+	return limit.withStatus(ctx, m.store, m.limits, m.rates, time.Now()), nil
+}
+
+func (m *rateLimitsAPIImpl) update(ctx context.Context, id manifest.VersionedResourceID, entry RateLimit) (RateLimit, error) {
+	var result RateLimit
+	if ok, err := m.store.GetByUID(ctx, &result, id.ID, dbstore.WithVersion(id.Version)); err != nil {
+		return result, err
+	} else if !ok {
+		return result, bark.ErrResourceNotFound
+	}
+
+	if result.Name != entry.Name {
+		return entry, bark.ErrResourceNotFound
+	}
+
+	if err := entry.Spec.Validate(); err != nil {
+		return result, err
+	}
+
+	result.Spec = entry.Spec
+	result.Labels = entry.Labels
+
+	// saveResource, for the reason scenarioAPIImpl.update gives: a bound
+	// lifted to zero or a host list emptied is a zero value Update would drop.
+	err := saveResource(ctx, m.store, &result)
+	m.limits.invalidate()
+	if err != nil {
+		return result, err
+	}
+
+	return result.withStatus(ctx, m.store, m.limits, m.rates, time.Now()), nil
+}
+
+// ------------------------------
+// / Worker blocks API
+// ------------------------------
+
+type workerBlocksAPIImpl struct {
+	store dbstore.TransactionalStore
+
+	// bars is the snapshot claims and heartbeats are judged against; see
+	// workerBarSnapshot.
+	bars *policyCache[workerBarSnapshot]
+}
+
+// List returns blocks in the order they were declared, each with the workers it
+// currently bars.
+func (m *workerBlocksAPIImpl) List(ctx context.Context, searchQuery manifest.SearchQuery) (results []manifest.ResourceManifest, total int64, err error) {
+	var models []WorkerBlock
+	total, err = m.store.Find(ctx, &models, searchQuery, dbstore.OrderByCreatedAt(dbstore.OrderAscending))
+	if err != nil {
+		return
+	}
+
+	results = make([]manifest.ResourceManifest, 0, len(models))
+	for _, model := range models {
+		results = append(results, model.withStatus(ctx, m.store).ToManifest())
+	}
+
+	return
+}
+
+func (m *workerBlocksAPIImpl) Get(ctx context.Context, id manifest.ResourceName) (result manifest.ResourceManifest, exists bool, err error) {
+	var model WorkerBlock
+	if exists, err = m.store.GetByName(ctx, &model, id); err != nil || !exists {
+		return
+	}
+
+	return model.withStatus(ctx, m.store).ToManifest(), true, nil
+}
+
+func (m *workerBlocksAPIImpl) CreateOrUpdate(ctx context.Context, newEntry manifest.ResourceManifest) (manifest.ResourceManifest, bool, error) {
+	block, err := NewWorkerBlock(newEntry)
+	if err != nil {
+		return manifest.ResourceManifest{}, false, err
+	}
+
+	var existing WorkerBlock
+	if exists, err := m.store.GetByName(ctx, &existing, block.Name); err != nil {
+		return manifest.ResourceManifest{}, false, err
+	} else if !exists {
+		result, err := m.create(ctx, block)
+		return result.ToManifest(), true, err
+	}
+
+	result, err := m.update(ctx, existing.GetVersionedID(), block)
+	return result.ToManifest(), false, err
+}
+
+func (m *workerBlocksAPIImpl) Create(ctx context.Context, newEntry manifest.ResourceManifest) (manifest.ResourceManifest, error) {
+	block, err := NewWorkerBlock(newEntry)
+	if err != nil {
+		return manifest.ResourceManifest{}, err
+	}
+
+	result, err := m.create(ctx, block)
+	return result.ToManifest(), err
+}
+
+func (m *workerBlocksAPIImpl) Update(ctx context.Context, id manifest.VersionedResourceID, newEntry manifest.ResourceManifest) (manifest.ResourceManifest, error) {
+	block, err := NewWorkerBlock(newEntry)
+	if err != nil {
+		return manifest.ResourceManifest{}, err
+	}
+
+	result, err := m.update(ctx, id, block)
+	return result.ToManifest(), err
+}
+
+// Delete lifts a block. Workers it barred may register again, and those still
+// registered take work again on their next claim.
+func (m *workerBlocksAPIImpl) Delete(ctx context.Context, id manifest.VersionedResourceID) (bool, error) {
+	defer m.bars.invalidate()
+
+	return m.store.Delete(ctx, &WorkerBlock{}, id.ID, id.Version)
+}
+
+func (m *workerBlocksAPIImpl) create(ctx context.Context, block WorkerBlock) (WorkerBlock, error) {
+	if err := block.Spec.Validate(); err != nil {
+		return block, err
+	}
+
+	block.Status = WorkerBlockStatus{}
+	defer m.bars.invalidate()
+	if err := m.store.Create(ctx, &block); err != nil {
+		return block, err
+	}
+
+	return block.withStatus(ctx, m.store), nil
+}
+
+func (m *workerBlocksAPIImpl) update(ctx context.Context, id manifest.VersionedResourceID, entry WorkerBlock) (WorkerBlock, error) {
+	var result WorkerBlock
+	if ok, err := m.store.GetByUID(ctx, &result, id.ID, dbstore.WithVersion(id.Version)); err != nil {
+		return result, err
+	} else if !ok {
+		return result, bark.ErrResourceNotFound
+	}
+
+	if result.Name != entry.Name {
+		return entry, bark.ErrResourceNotFound
+	}
+
+	if err := entry.Spec.Validate(); err != nil {
+		return result, err
+	}
+
+	result.Spec = entry.Spec
+	result.Labels = entry.Labels
+
+	// saveResource, for the reason scenarioAPIImpl.update gives: a block moved
+	// from a hostname to a fingerprint empties a field Update would keep.
+	defer m.bars.invalidate()
+	if err := saveResource(ctx, m.store, &result); err != nil {
+		return result, err
+	}
+
+	return result.withStatus(ctx, m.store), nil
+}
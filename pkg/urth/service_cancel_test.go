@@ -0,0 +1,144 @@
+package urth_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/urth/pkg/urth"
+	"github.com/sre-norns/wyrd/pkg/bark"
+	"github.com/sre-norns/wyrd/pkg/dbstore"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// fakeCanceller stands in for the transport's half of cancellation, reusing the
+// reconciler's fake for withdrawing a queued message.
+type fakeCanceller struct {
+	*fakeChannels
+
+	mu        sync.Mutex
+	signalled []manifest.ResourceID
+}
+
+func (f *fakeCanceller) SignalCancel(_ context.Context, result urth.Result) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.signalled = append(f.signalled, result.UID)
+
+	return nil
+}
+
+// cancellingService is a test service wired for cancellation. It is built over
+// the store newTestService returns, because the dispatch store needs the gorm
+// handle that only exists once the test database does.
+func cancellingService(t *testing.T) (urth.Service, *fakeCanceller, *gorm.DB, *dbstore.DBStore) {
+	t.Helper()
+
+	keys := testKeys(t)
+	scheduler := &stubScheduler{}
+	_, db, store := newTestService(t, scheduler)
+
+	canceller := &fakeCanceller{fakeChannels: newFakeChannels()}
+	srv := urth.NewService(store, scheduler,
+		urth.WithSigningKeys(keys),
+		urth.WithRunDispatches(urth.NewRunDispatchStore(db)),
+		urth.WithRunCanceller(canceller),
+	)
+
+	return srv, canceller, db, store
+}
+
+var cancelRequest = urth.CancelRunRequest{RequestedBy: "alice", Reason: "wrong target"}
+
+// A run nobody has claimed is the server's alone to stop: it becomes terminal at
+// once, and the message already queued for it is withdrawn so no worker is
+// handed a job it could only refuse.
+func TestCancellingAPendingRunFinishesItAndWithdrawsItsDispatch(t *testing.T) {
+	srv, canceller, db, store := cancellingService(t)
+	scenarioName := seedScenario(t, store)
+	ctx := context.Background()
+
+	created, err := srv.Results(scenarioName).Create(ctx, newRunRequest())
+	require.NoError(t, err)
+	publishDispatches(t, db, 42)
+
+	cancelled, err := srv.Results(scenarioName).Cancel(ctx, created.Name, cancelRequest)
+	require.NoError(t, err)
+	require.Equal(t, urth.JobCompleted, cancelled.Status.Status)
+
+	stored := loadResult(t, store, created.UID)
+	require.Equal(t, urth.JobCompleted, stored.Status.Status)
+	require.Equal(t, prob.RunFinishedCanceled, stored.Status.Result)
+	require.NotNil(t, stored.Status.Cancellation)
+	require.Equal(t, "alice", stored.Status.Cancellation.RequestedBy)
+	require.Equal(t, "wrong target", stored.Status.Cancellation.Reason)
+
+	require.Equal(t, []uint64{42}, canceller.droppedSeqs(), "the queued message is withdrawn")
+	require.NotNil(t, loadDispatch(t, db, created.UID).RetiredAt, "and its outbox entry retired")
+	require.Empty(t, canceller.signalled, "nothing was executing it, so nobody is signalled")
+
+	_, err = claimRunErr(t, srv, created)
+	require.Error(t, err, "a cancelled run cannot be claimed from a message that slipped out")
+}
+
+// An executing run belongs to its worker until it reports. Cancelling it records
+// the request and signals the worker; the worker's report -- made against the
+// version it claimed, which the cancellation has since moved past -- is what
+// settles it.
+func TestCancellingARunningRunSignalsItsWorkerAndTakesItsReport(t *testing.T) {
+	srv, canceller, _, store := cancellingService(t)
+	scenarioName := seedScenario(t, store)
+	ctx := context.Background()
+
+	created, err := srv.Results(scenarioName).Create(ctx, newRunRequest())
+	require.NoError(t, err)
+	claim := claimRun(t, srv, created)
+
+	cancelled, err := srv.Results(scenarioName).Cancel(ctx, created.Name, cancelRequest)
+	require.NoError(t, err)
+	require.Equal(t, urth.JobCancelling, cancelled.Status.Status)
+	require.Equal(t, []manifest.ResourceID{created.UID}, canceller.signalled)
+
+	// Asking again changes nothing and is not an error.
+	again, err := srv.Results(scenarioName).Cancel(ctx, created.Name, urth.CancelRunRequest{RequestedBy: "bob"})
+	require.NoError(t, err)
+	require.Equal(t, "alice", again.Status.Cancellation.RequestedBy, "the first request is the one on record")
+
+	_, err = srv.Results(scenarioName).UpdateStatus(ctx, claim.VersionedResourceID, claim.Token,
+		urth.NewRunResults(prob.RunFinishedCanceled))
+	require.NoError(t, err, "the report the cancellation is waiting on must be accepted")
+
+	stored := loadResult(t, store, created.UID)
+	require.Equal(t, urth.JobCompleted, stored.Status.Status)
+	require.Equal(t, prob.RunFinishedCanceled, stored.Status.Result)
+	require.NotNil(t, stored.Status.Cancellation, "who cancelled it outlives the run")
+}
+
+// A finished run is history. Cancelling it would rewrite an outcome that
+// already happened, so it is refused as a conflict.
+func TestCancellingAFinishedRunIsAConflict(t *testing.T) {
+	srv, _, _, store := cancellingService(t)
+	scenarioName := seedScenario(t, store)
+	ctx := context.Background()
+
+	created, err := srv.Results(scenarioName).Create(ctx, newRunRequest())
+	require.NoError(t, err)
+	claim := claimRun(t, srv, created)
+
+	_, err = srv.Results(scenarioName).UpdateStatus(ctx, claim.VersionedResourceID, claim.Token,
+		urth.NewRunResults(prob.RunFinishedSuccess))
+	require.NoError(t, err)
+
+	_, err = srv.Results(scenarioName).Cancel(ctx, created.Name, cancelRequest)
+	require.True(t, errors.Is(err, bark.ErrResourceVersionConflict), "got %v", err)
+
+	stored := loadResult(t, store, created.UID)
+	require.Equal(t, prob.RunFinishedSuccess, stored.Status.Result)
+	require.Nil(t, stored.Status.Cancellation)
+}
@@ -0,0 +1,89 @@
+package urth_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/urth/pkg/urth"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// setConcurrencyPolicy changes a seeded scenario's policy in place.
+func setConcurrencyPolicy(t *testing.T, db *gorm.DB, scenarioName manifest.ResourceName, policy urth.ConcurrencyPolicy) {
+	t.Helper()
+
+	require.NoError(t, db.Model(&urth.Scenario{}).
+		Where("name = ?", scenarioName).
+		UpdateColumn("concurrency_policy", policy).Error)
+}
+
+// A scenario that runs longer than its schedule piles runs up under Allow. Under
+// Forbid the trigger that finds one in flight is skipped -- and recorded, so the
+// missing run is a row in the history saying why rather than a gap in it.
+func TestForbidSkipsARunWhileAnEarlierOneIsInFlight(t *testing.T) {
+	srv, _, db, store := cancellingService(t)
+	scenarioName := seedScenario(t, store)
+	setConcurrencyPolicy(t, db, scenarioName, urth.ConcurrencyForbid)
+
+	ctx := context.Background()
+
+	first, err := srv.Results(scenarioName).Create(ctx, newRunRequest())
+	require.NoError(t, err)
+	require.Equal(t, urth.JobPending, first.Status.Status)
+
+	second, err := srv.Results(scenarioName).Create(ctx, newRunRequest())
+	require.NoError(t, err, "a skipped trigger is recorded, not refused")
+
+	skipped := loadResult(t, store, second.UID)
+	require.Equal(t, urth.JobCompleted, skipped.Status.Status)
+	require.Equal(t, prob.RunFinishedCanceled, skipped.Status.Result)
+	require.Equal(t, urth.ReasonConcurrencyForbidden, skipped.Labels[urth.LabelResultUnschedulable])
+	require.Empty(t, skipped.Status.Executor.RunnerID, "a skipped run is never placed")
+	require.EqualValues(t, 1, countOutbox(t, db), "nor dispatched")
+
+	// Once the first has finished, the next trigger runs.
+	_, err = srv.Results(scenarioName).Cancel(ctx, first.Name, cancelRequest)
+	require.NoError(t, err)
+
+	third, err := srv.Results(scenarioName).Create(ctx, newRunRequest())
+	require.NoError(t, err)
+	require.Equal(t, urth.JobPending, third.Status.Status)
+}
+
+// Under Replace the new run goes ahead and the ones in flight are cancelled, on
+// the record, naming the run that replaced them.
+func TestReplaceCancelsTheRunsInFlight(t *testing.T) {
+	srv, _, db, store := cancellingService(t)
+	scenarioName := seedScenario(t, store)
+	setConcurrencyPolicy(t, db, scenarioName, urth.ConcurrencyReplace)
+
+	ctx := context.Background()
+
+	first, err := srv.Results(scenarioName).Create(ctx, newRunRequest())
+	require.NoError(t, err)
+
+	second, err := srv.Results(scenarioName).Create(ctx, newRunRequest())
+	require.NoError(t, err)
+	require.Equal(t, urth.JobPending, loadResult(t, store, second.UID).Status.Status)
+
+	replaced := loadResult(t, store, first.UID)
+	require.Equal(t, urth.JobCompleted, replaced.Status.Status)
+	require.Equal(t, prob.RunFinishedCanceled, replaced.Status.Result)
+	require.NotNil(t, replaced.Status.Cancellation)
+	require.Equal(t, urth.ConcurrencyRequester, replaced.Status.Cancellation.RequestedBy)
+	require.Contains(t, replaced.Status.Cancellation.Reason, string(second.Name))
+}
+
+// A policy in the wrong case would otherwise read as Allow, and the scenario
+// would run concurrently while its manifest said it did not.
+func TestUnknownConcurrencyPolicyIsRefused(t *testing.T) {
+	for _, policy := range []urth.ConcurrencyPolicy{"", urth.ConcurrencyAllow, urth.ConcurrencyForbid, urth.ConcurrencyReplace} {
+		require.NoError(t, policy.Validate(), "policy %q", policy)
+	}
+
+	require.Error(t, urth.ConcurrencyPolicy("forbid").Validate())
+}
@@ -0,0 +1,166 @@
+package urth_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/urth/pkg/urth"
+	"github.com/sre-norns/wyrd/pkg/dbstore"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// seedUpstream stores an active scenario with one finished run of the given
+// outcome, and returns its name.
+func seedUpstream(t *testing.T, store *dbstore.DBStore, name manifest.ResourceName, outcome prob.RunStatus) manifest.ResourceName {
+	t.Helper()
+
+	ctx := context.Background()
+
+	scenario := urth.Scenario{
+		ObjectMeta: manifest.ObjectMeta{Name: name},
+		Spec: urth.ScenarioSpec{
+			IsActive: true,
+			Prob:     prob.Manifest{Kind: "http", Spec: map[string]any{"target": "http://gateway.corp"}},
+		},
+	}
+	require.NoError(t, store.Create(ctx, &scenario))
+
+	run := urth.Result{
+		ObjectMeta: manifest.ObjectMeta{
+			Name: name + "-1",
+			Labels: manifest.Labels{
+				urth.LabelResultJobState: string(urth.JobCompleted),
+				urth.LabelResultStatus:   string(outcome),
+			},
+		},
+		Spec:   urth.ResultSpec{ScenarioID: scenario.UID},
+		Status: urth.ResultStatus{Status: urth.JobCompleted, Result: outcome},
+	}
+	require.NoError(t, store.Create(ctx, &run))
+
+	return name
+}
+
+// dependOn makes a seeded scenario depend on another by name.
+func dependOn(t *testing.T, store *dbstore.DBStore, scenarioName, upstream manifest.ResourceName) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	var scenario urth.Scenario
+	found, err := store.GetByName(ctx, &scenario, scenarioName)
+	require.NoError(t, err)
+	require.True(t, found)
+
+	scenario.Spec.DependsOn = []urth.ScenarioDependency{{Scenario: upstream}}
+	_, err = store.CreateOrUpdate(ctx, &scenario)
+	require.NoError(t, err)
+}
+
+// The gateway is down, so the check behind it fails too: the failure is
+// recorded as reported, and put down to the gateway.
+func TestFailureBehindAFailingDependencyIsConsequential(t *testing.T) {
+	srv, _, store := newTestService(t, &stubScheduler{}, urth.WithSigningKeys(testKeys(t)))
+	scenarioName := seedScenario(t, store)
+	dependOn(t, store, scenarioName, seedUpstream(t, store, "vpn-gateway", prob.RunFinishedFailed))
+
+	ctx := context.Background()
+
+	created, err := srv.Results(scenarioName).Create(ctx, newRunRequest())
+	require.NoError(t, err)
+
+	claim := claimRun(t, srv, created)
+	_, err = srv.Results(scenarioName).UpdateStatus(ctx, claim.VersionedResourceID, claim.Token, urth.NewRunResults(prob.RunFinishedFailed))
+	require.NoError(t, err)
+
+	failed := loadResult(t, store, created.UID)
+	require.Equal(t, prob.RunFinishedFailed, failed.Status.Result)
+	require.Equal(t, manifest.ResourceName("vpn-gateway"), failed.Status.UpstreamFailed)
+	require.Equal(t, "vpn-gateway", failed.Labels[urth.LabelResultUpstreamFailed])
+}
+
+// With the gateway up, the same failure is the check's own.
+func TestFailureBehindAHealthyDependencyIsARootCause(t *testing.T) {
+	srv, _, store := newTestService(t, &stubScheduler{}, urth.WithSigningKeys(testKeys(t)))
+	scenarioName := seedScenario(t, store)
+	dependOn(t, store, scenarioName, seedUpstream(t, store, "vpn-gateway", prob.RunFinishedSuccess))
+
+	ctx := context.Background()
+
+	created, err := srv.Results(scenarioName).Create(ctx, newRunRequest())
+	require.NoError(t, err)
+
+	claim := claimRun(t, srv, created)
+	_, err = srv.Results(scenarioName).UpdateStatus(ctx, claim.VersionedResourceID, claim.Token, urth.NewRunResults(prob.RunFinishedFailed))
+	require.NoError(t, err)
+
+	failed := loadResult(t, store, created.UID)
+	require.Empty(t, failed.Status.UpstreamFailed)
+	require.NotContains(t, failed.Labels, urth.LabelResultUpstreamFailed)
+}
+
+// A manifest that would close a cycle is refused when it is applied, naming the
+// way round.
+func TestApplyingADependencyCycleIsRefused(t *testing.T) {
+	srv, _, _ := newTestService(t, &stubScheduler{})
+	ctx := context.Background()
+
+	declare := func(name, upstream manifest.ResourceName) error {
+		_, err := srv.Scenarios().Create(ctx, manifest.ResourceManifest{
+			TypeMeta: manifest.TypeMeta{Kind: urth.KindScenario},
+			Metadata: manifest.ObjectMeta{Name: name},
+			Spec: &urth.ScenarioSpec{
+				DependsOn: []urth.ScenarioDependency{{Scenario: upstream}},
+				Prob:      prob.Manifest{Kind: "http", Spec: map[string]any{"target": "http://example.com"}},
+			},
+		})
+		return err
+	}
+
+	require.NoError(t, declare("checkout", "payments"))
+	require.NoError(t, declare("payments", "vpn-gateway"))
+
+	err := declare("vpn-gateway", "checkout")
+	require.ErrorIs(t, err, urth.ErrDependencyCycle)
+	require.ErrorContains(t, err, "vpn-gateway -> checkout -> payments -> vpn-gateway")
+
+	graph, err := srv.Scenarios().Dependencies(ctx)
+	require.NoError(t, err)
+	require.Len(t, graph.Scenarios, 2, "the refused scenario was not stored")
+}
+
+// Dependencies are cached between failed runs, but one declared through the
+// service judges the very next failure.
+func TestDependencyDeclaredThroughTheServiceJudgesTheNextFailure(t *testing.T) {
+	srv, _, store := newTestService(t, &stubScheduler{}, urth.WithSigningKeys(testKeys(t)))
+	scenarioName := seedScenario(t, store)
+	dependOn(t, store, scenarioName, seedUpstream(t, store, "dns", prob.RunFinishedSuccess))
+	seedUpstream(t, store, "vpn-gateway", prob.RunFinishedFailed)
+
+	ctx := context.Background()
+
+	fail := func() urth.Result {
+		created, err := srv.Results(scenarioName).Create(ctx, newRunRequest())
+		require.NoError(t, err)
+
+		claim := claimRun(t, srv, created)
+		_, err = srv.Results(scenarioName).UpdateStatus(ctx, claim.VersionedResourceID, claim.Token, urth.NewRunResults(prob.RunFinishedFailed))
+		require.NoError(t, err)
+
+		return loadResult(t, store, created.UID)
+	}
+
+	require.Empty(t, fail().Status.UpstreamFailed, "the only dependency is healthy")
+
+	current, found, err := srv.Scenarios().Get(ctx, scenarioName)
+	require.NoError(t, err)
+	require.True(t, found)
+	current.Spec.(*urth.ScenarioSpec).DependsOn = []urth.ScenarioDependency{{Scenario: "vpn-gateway"}}
+	_, err = srv.Scenarios().Update(ctx, current.Metadata.GetVersionedID(), current)
+	require.NoError(t, err)
+
+	require.Equal(t, manifest.ResourceName("vpn-gateway"), fail().Status.UpstreamFailed)
+}
@@ -245,6 +245,18 @@ func TestLegacyResultWithoutSnapshotIsNotDispatched(t *testing.T) {
 	require.Empty(t, scheduler.scheduled)
 }
 
+// A worker limits runs per prob kind before it claims them, so the dispatch has
+// to say which kind it is for -- and it is the kind the run was created with.
+func TestDispatchCarriesTheRunsProbKind(t *testing.T) {
+	srv, db, store := newTestService(t, &stubScheduler{})
+	scenario := seedScenarioWithProb(t, store, restProb(probeA))
+
+	created, err := srv.Results(scenario.Name).Create(context.Background(), newRunRequest())
+	require.NoError(t, err)
+
+	require.Equal(t, rest.Kind, loadDispatch(t, db, created.UID).ProbKind)
+}
+
 // The legacy transport puts the whole prob in its queue message, so it is the
 // other place a scenario edit could change a queued run. It publishes what the
 // run was created with, not what the scenario has become.
@@ -364,3 +376,38 @@ func claimRunErr(t *testing.T, srv urth.Service, result urth.Result) (urth.AuthJ
 		ResultVersion: result.Version,
 	})
 }
+
+// The runner's network settings reach the worker with each claim, as they stand
+// then, and what the worker reports it applied is kept on the Result -- which
+// is what lets a failed run be put down to the proxy it went through.
+func TestClaimCarriesTheRunnersNetworkAndTheResultRecordsWhatWasApplied(t *testing.T) {
+	srv, _, store := newTestService(t, &stubScheduler{}, urth.WithSigningKeys(testKeys(t)))
+	scenario := seedScenarioWithProb(t, store, restProb(probeA))
+
+	ctx := context.Background()
+
+	created, err := srv.Results(scenario.Name).Create(ctx, newRunRequest())
+	require.NoError(t, err)
+
+	// Changed after the run was scheduled: the claim still gets the new proxy.
+	var runner urth.Runner
+	found, err := store.GetByName(ctx, &runner, "test-runner")
+	require.NoError(t, err)
+	require.True(t, found)
+
+	runner.Spec.Network = prob.NetworkConfig{HTTPSProxy: "http://proxy.corp:3128", NoProxy: []string{".corp"}}
+	_, err = store.CreateOrUpdate(ctx, &runner)
+	require.NoError(t, err)
+
+	claim := claimRun(t, srv, created)
+	require.Equal(t, runner.Spec.Network, claim.Network)
+
+	applied := &prob.NetworkApplied{HTTPSProxy: "http://proxy.corp:3128", NoProxy: []string{".corp"}}
+	status := urth.NewRunResults(prob.RunFinishedFailed)
+	status.Network = applied
+
+	_, err = srv.Results(scenario.Name).UpdateStatus(ctx, claim.VersionedResourceID, claim.Token, status)
+	require.NoError(t, err)
+
+	require.Equal(t, applied, loadResult(t, store, created.UID).Status.Network)
+}
@@ -0,0 +1,190 @@
+package urth_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/urth/pkg/urth"
+	"github.com/sre-norns/wyrd/pkg/dbstore"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// onlineCapacityScenario seeds "test-runner" with the given number of online
+// workers and a scenario under the given policy, and returns the scenario's
+// name.
+func onlineCapacityScenario(t *testing.T, store *dbstore.DBStore, db *gorm.DB, online int, policy urth.OnlineCapacityPolicy, after time.Duration) manifest.ResourceName {
+	t.Helper()
+
+	seedRunnerWithWorkers(t, store, db, firstRunnerUID, "test-runner", online)
+	scenario := seedOpenScenario(t, store, "time-sensitive")
+
+	require.NoError(t, db.Model(&urth.Scenario{}).
+		Where("name = ?", scenario.Name).
+		UpdateColumns(map[string]any{"online_capacity": policy, "online_capacity_after": after}).Error)
+
+	return scenario.Name
+}
+
+// The fleet is there but nobody is home. Under require-online the run is
+// recorded, terminal, saying so -- rather than queued to execute whenever the
+// workers come back, on data that will by then be stale.
+func TestRequireOnlineFailsARunWhenNoWorkerIsOnline(t *testing.T) {
+	srv, db, store := placementService(t)
+	scenarioName := onlineCapacityScenario(t, store, db, 0, urth.OnlineCapacityRequireOnline, 0)
+
+	created, err := srv.Results(scenarioName).Create(context.Background(), newRunRequest())
+	require.NoError(t, err, "a refused run is recorded, not returned as an error")
+
+	failed := loadResult(t, store, created.UID)
+	require.Equal(t, urth.JobErrored, failed.Status.Status)
+	require.Equal(t, prob.RunFinishedError, failed.Status.Result)
+	require.Equal(t, urth.ReasonNoOnlineCapacity, failed.Labels[urth.LabelResultUnschedulable])
+	require.Equal(t, manifest.ResourceName("test-runner"), failed.Status.Executor.RunnerName,
+		"the runner it would have gone to is kept, since that is where to look")
+	require.Zero(t, countOutbox(t, db), "nothing is dispatched")
+}
+
+// With a worker online the policy changes nothing.
+func TestRequireOnlineQueuesWhenAWorkerIsOnline(t *testing.T) {
+	srv, db, store := placementService(t)
+	scenarioName := onlineCapacityScenario(t, store, db, 1, urth.OnlineCapacityRequireOnline, 0)
+
+	created, err := srv.Results(scenarioName).Create(context.Background(), newRunRequest())
+	require.NoError(t, err)
+
+	require.Equal(t, urth.JobPending, loadResult(t, store, created.UID).Status.Status)
+	require.EqualValues(t, 1, countOutbox(t, db))
+}
+
+// Under fail-fast-after the run is queued as usual, but a worker that turns up
+// for it after the deadline is turned away and the run is recorded expired.
+func TestFailFastAfterRefusesALateClaim(t *testing.T) {
+	srv, db, store := placementService(t)
+	scenarioName := onlineCapacityScenario(t, store, db, 0, urth.OnlineCapacityFailFastAfter, time.Millisecond)
+
+	created, err := srv.Results(scenarioName).Create(context.Background(), newRunRequest())
+	require.NoError(t, err)
+	require.Equal(t, urth.JobPending, created.Status.Status, "fail-fast-after still queues")
+	require.NotNil(t, created.Status.ClaimBy)
+
+	time.Sleep(5 * time.Millisecond)
+
+	_, err = claimRunErr(t, srv, created)
+	require.Error(t, err)
+
+	disposition, ok := urth.ClaimDispositionOf(err)
+	require.True(t, ok)
+	require.Equal(t, urth.ClaimObsolete, disposition)
+
+	expired := loadResult(t, store, created.UID)
+	require.Equal(t, urth.JobExpired, expired.Status.Status)
+	require.Equal(t, urth.ReasonClaimDeadlinePassed, expired.Labels[urth.LabelResultUnschedulable])
+	require.Nil(t, expired.Spec.TimeStarted, "it never ran")
+}
+
+// A run that no worker turns up for at all is settled by the reconciler, well
+// before the pending timeout would have got to it.
+func TestReconcilerExpiresRunsPastTheirClaimDeadline(t *testing.T) {
+	srv, db, store := placementService(t)
+	scenarioName := onlineCapacityScenario(t, store, db, 0, urth.OnlineCapacityFailFastAfter, time.Millisecond)
+
+	ctx := context.Background()
+
+	created, err := srv.Results(scenarioName).Create(ctx, newRunRequest())
+	require.NoError(t, err)
+
+	time.Sleep(5 * time.Millisecond)
+
+	report, err := urth.NewReconciler(urth.NewReconcileStore(db, store)).RunOnce(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, report.ExpiredUnclaimed)
+	require.Zero(t, report.ExpiredPending, "the pending timeout had nothing to do with it")
+
+	expired := loadResult(t, store, created.UID)
+	require.Equal(t, urth.JobExpired, expired.Status.Status)
+	require.Equal(t, urth.ReasonClaimDeadlinePassed, expired.Labels[urth.LabelResultUnschedulable])
+}
+
+// The deadline is about a fleet that is not there. A runner that is online and
+// merely busy still runs a run that waited past it, when a worker gets to it.
+func TestFailFastAfterLetsABusyRunnerClaimLate(t *testing.T) {
+	srv, db, store := placementService(t)
+	scenarioName := onlineCapacityScenario(t, store, db, 1, urth.OnlineCapacityFailFastAfter, time.Millisecond)
+
+	created, err := srv.Results(scenarioName).Create(context.Background(), newRunRequest())
+	require.NoError(t, err)
+
+	time.Sleep(5 * time.Millisecond)
+
+	_, err = claimRunErr(t, srv, created)
+	require.NoError(t, err)
+	require.Equal(t, urth.JobRunning, loadResult(t, store, created.UID).Status.Status)
+}
+
+// Nor does the reconciler expire it, and a backlog on a busy runner does not
+// keep it from the runs of one that is down.
+func TestReconcilerLeavesRunsOfAnOnlineRunnerPending(t *testing.T) {
+	srv, db, store := placementService(t)
+	scenarioName := onlineCapacityScenario(t, store, db, 1, urth.OnlineCapacityFailFastAfter, time.Millisecond)
+
+	ctx := context.Background()
+
+	var waiting []urth.Result
+	for range 3 {
+		created, err := srv.Results(scenarioName).Create(ctx, newRunRequest())
+		require.NoError(t, err)
+		waiting = append(waiting, created)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+
+	report, err := urth.NewReconciler(urth.NewReconcileStore(db, store), urth.WithReconcileBatchSize(2)).RunOnce(ctx)
+	require.NoError(t, err)
+	require.Zero(t, report.ExpiredUnclaimed)
+
+	for _, run := range waiting {
+		require.Equal(t, urth.JobPending, loadResult(t, store, run.UID).Status.Status)
+	}
+}
+
+// The preview names the policy, and under require-online says a run created
+// now would be refused, with the reason it would carry.
+func TestPlacementPreviewReportsTheOnlineCapacityPolicy(t *testing.T) {
+	srv, db, store := placementService(t)
+	scenarioName := onlineCapacityScenario(t, store, db, 0, urth.OnlineCapacityRequireOnline, 0)
+
+	preview, exists, err := srv.Scenarios().Placement(context.Background(), scenarioName)
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	require.Equal(t, urth.OnlineCapacityRequireOnline, preview.OnlineCapacity)
+	require.Equal(t, 1, preview.EligibleRunners)
+	require.False(t, preview.Schedulable)
+	require.Equal(t, urth.ReasonNoOnlineCapacity, preview.Reason)
+}
+
+// fail-fast-after without a duration would fail every run on the spot, which is
+// require-online by another name; refused, so the scenario says what it means.
+func TestScenarioWithFailFastAfterAndNoDurationIsRefused(t *testing.T) {
+	srv, _, _ := newTestService(t, &stubScheduler{})
+
+	scenario := urth.Scenario{
+		ObjectMeta: manifest.ObjectMeta{Name: "no-duration"},
+		Spec: urth.ScenarioSpec{
+			IsActive:       true,
+			OnlineCapacity: urth.OnlineCapacityFailFastAfter,
+			Prob: prob.Manifest{
+				Kind: "http",
+				Spec: map[string]any{"target": "http://example.com"},
+			},
+		},
+	}
+
+	_, err := srv.Scenarios().Create(context.Background(), scenario.ToManifest())
+	require.Error(t, err)
+}
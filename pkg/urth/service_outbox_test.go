@@ -34,7 +34,15 @@ func newTestService(t *testing.T, scheduler urth.Scheduler, options ...urth.Serv
 		&urth.Artifact{},
 		&urth.DispatchOutboxEntry{},
 		&urth.ReconcileLease{},
+		&urth.ResultRollup{},
 		&urth.DispatchFailure{},
+		&urth.RemoteWriteEntry{},
+		&urth.LatencyBaseline{},
+		&urth.MaintenanceWindow{},
+		&urth.RateLimit{},
+		&urth.EnrolmentToken{},
+		&urth.WorkerBlock{},
+		&urth.AuditEvent{},
 	}
 
 	// Dropped in reverse dependency order so a rerun starts clean; leftover rows
@@ -61,8 +61,8 @@ func registerWorker(t *testing.T, srv urth.Service, runnerName, workerName manif
 	return registration.Session, worker.UID
 }
 
-// workerPresence reads back the presence a client would be shown.
-func workerPresence(t *testing.T, srv urth.Service, name manifest.ResourceName) urth.WorkerPresenceReport {
+// workerStatus reads back the status a client would be shown.
+func workerStatus(t *testing.T, srv urth.Service, name manifest.ResourceName) *urth.WorkerInstanceStatus {
 	t.Helper()
 
 	found, exists, err := srv.Workers().Get(context.Background(), name)
@@ -72,7 +72,14 @@ func workerPresence(t *testing.T, srv urth.Service, name manifest.ResourceName)
 	status, ok := found.Status.(*urth.WorkerInstanceStatus)
 	require.True(t, ok, "expected a worker status, got %T", found.Status)
 
-	return status.Presence
+	return status
+}
+
+// workerPresence reads back the presence a client would be shown.
+func workerPresence(t *testing.T, srv urth.Service, name manifest.ResourceName) urth.WorkerPresenceReport {
+	t.Helper()
+
+	return workerStatus(t, srv, name).Presence
 }
 
 // A registered worker that has said nothing is `unknown`, and one heartbeat
@@ -184,6 +191,95 @@ func TestHeartbeatReportsThatAWorkerIsPaused(t *testing.T) {
 	require.True(t, response.Paused)
 }
 
+// A drain reaches the worker on its heartbeat, and the fleet view follows it
+// through: draining while it finishes, left once it has said goodbye.
+func TestHeartbeatTellsADrainedWorkerToLeave(t *testing.T) {
+	srv, _, store := presenceService(t)
+	seedScenarioWithProb(t, store, restProb(probeA))
+
+	session, _ := registerWorker(t, srv, "test-runner", "test-worker")
+	ctx := context.Background()
+
+	_, exists, err := srv.Workers().SetDraining(ctx, "test-worker", true)
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	response, err := srv.Workers().Heartbeat(ctx, session, urth.WorkerHeartbeatRequest{})
+	require.NoError(t, err)
+	require.True(t, response.Drain)
+	require.False(t, response.Paused, "a drain is not a pause")
+
+	presence := workerPresence(t, srv, "test-worker")
+	require.Equal(t, urth.WorkerLifecycleDraining, presence.Lifecycle)
+	require.Equal(t, urth.WorkerPresenceOnline, presence.API, "a draining worker is still reporting")
+
+	_, err = srv.Workers().Heartbeat(ctx, session, urth.WorkerHeartbeatRequest{Leaving: true})
+	require.NoError(t, err)
+
+	presence = workerPresence(t, srv, "test-worker")
+	require.Equal(t, urth.WorkerLifecycleLeft, presence.Lifecycle)
+	require.Equal(t, urth.WorkerConditionOffline, presence.Condition)
+}
+
+// Draining a runner drains every worker behind it, including one that registers
+// afterwards, and withdrawing it leaves alone a worker drained on its own.
+func TestRunnerDrainAppliesToEveryWorker(t *testing.T) {
+	srv, _, store := presenceService(t)
+	seedScenarioWithProb(t, store, restProb(probeA))
+
+	first, _ := registerWorker(t, srv, "test-runner", "first-worker")
+	ctx := context.Background()
+
+	_, exists, err := srv.Runners().SetDraining(ctx, "test-runner", true)
+	require.NoError(t, err)
+	require.True(t, exists)
+
+	second, _ := registerWorker(t, srv, "test-runner", "second-worker")
+
+	for name, session := range map[manifest.ResourceName]urth.APIToken{"first-worker": first, "second-worker": second} {
+		response, err := srv.Workers().Heartbeat(ctx, session, urth.WorkerHeartbeatRequest{})
+		require.NoError(t, err)
+		require.True(t, response.Drain, "%s was not told to drain", name)
+		require.Equal(t, urth.WorkerLifecycleDraining, workerPresence(t, srv, name).Lifecycle)
+	}
+
+	_, _, err = srv.Workers().SetDraining(ctx, "second-worker", true)
+	require.NoError(t, err)
+	_, _, err = srv.Runners().SetDraining(ctx, "test-runner", false)
+	require.NoError(t, err)
+
+	response, err := srv.Workers().Heartbeat(ctx, first, urth.WorkerHeartbeatRequest{})
+	require.NoError(t, err)
+	require.False(t, response.Drain)
+
+	response, err = srv.Workers().Heartbeat(ctx, second, urth.WorkerHeartbeatRequest{})
+	require.NoError(t, err)
+	require.True(t, response.Drain, "a worker drained on its own stays drained")
+}
+
+// What a worker's self-test found is kept in its status, and replaced by every
+// heartbeat: a kind that recovers is cleared by the next report.
+func TestHeartbeatRecordsSelfTestFailures(t *testing.T) {
+	srv, _, store := presenceService(t)
+	seedScenarioWithProb(t, store, restProb(probeA))
+
+	session, _ := registerWorker(t, srv, "test-runner", "test-worker")
+	ctx := context.Background()
+
+	failures := []urth.ProbSelfTestFailure{{Kind: "icmp", Reason: "cannot open an ICMP socket"}}
+	_, err := srv.Workers().Heartbeat(ctx, session, urth.WorkerHeartbeatRequest{SelfTestFailures: failures})
+	require.NoError(t, err)
+
+	status := workerStatus(t, srv, "test-worker")
+	require.Equal(t, failures, status.SelfTestFailures)
+	require.True(t, status.SelfTestFailed("icmp"))
+
+	_, err = srv.Workers().Heartbeat(ctx, session, urth.WorkerHeartbeatRequest{})
+	require.NoError(t, err)
+
+	require.Empty(t, workerStatus(t, srv, "test-worker").SelfTestFailures)
+}
+
 // Claiming a run is itself proof of life, so a worker with a steady stream of
 // work is confirmed without spending a single extra request -- and without
 // waiting out an interval it has no reason to wait out.
@@ -0,0 +1,75 @@
+package urth_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/sre-norns/urth/pkg/urth"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// setPriorityClass changes a seeded scenario's class in place.
+func setPriorityClass(t *testing.T, db *gorm.DB, scenarioName manifest.ResourceName, class urth.PriorityClass) {
+	t.Helper()
+
+	require.NoError(t, db.Model(&urth.Scenario{}).
+		Where("name = ?", scenarioName).
+		UpdateColumn("priority_class", class).Error)
+}
+
+// A "Run now" goes on the high queue and a scheduled run on the normal one, and
+// the dispatch carries the run's priority to the transport.
+func TestManualRunsAreDispatchedAheadOfScheduledOnes(t *testing.T) {
+	srv, _, db, store := cancellingService(t)
+	scenarioName := seedScenario(t, store)
+
+	ctx := context.Background()
+
+	manual, err := srv.Results(scenarioName).Create(ctx, newRunRequest())
+	require.NoError(t, err)
+
+	scheduledRequest := newRunRequest()
+	scheduledRequest.Metadata.Name = ""
+	scheduled, err := srv.Results(scenarioName).Create(ctx, scheduledRequest)
+	require.NoError(t, err)
+
+	manualRun := loadResult(t, store, manual.UID)
+	require.Equal(t, urth.PriorityHigh, manualRun.Spec.Priority)
+	require.Equal(t, string(urth.TriggerManual), manualRun.Labels[urth.LabelResultTrigger])
+	require.Equal(t, string(urth.PriorityHigh), manualRun.Labels[urth.LabelResultPriority])
+	require.Equal(t, urth.PriorityHigh, loadDispatch(t, db, manual.UID).Priority)
+
+	scheduledRun := loadResult(t, store, scheduled.UID)
+	require.Equal(t, urth.PriorityNormal, scheduledRun.Spec.Priority)
+	require.Equal(t, string(urth.TriggerScheduled), scheduledRun.Labels[urth.LabelResultTrigger])
+	require.Equal(t, urth.PriorityNormal, loadDispatch(t, db, scheduled.UID).Priority)
+}
+
+// Priority is the server's decision. A client that could ask for high would,
+// and the queue would be first come, first served again.
+func TestRequestedPriorityIsOverwritten(t *testing.T) {
+	srv, _, _, store := cancellingService(t)
+	scenarioName := seedScenario(t, store)
+
+	request := newRunRequest()
+	request.Metadata.Name = ""
+	request.Spec = &urth.ResultSpec{Priority: urth.PriorityHigh}
+
+	run, err := srv.Results(scenarioName).Create(context.Background(), request)
+	require.NoError(t, err)
+	require.Equal(t, urth.PriorityNormal, loadResult(t, store, run.UID).Spec.Priority)
+}
+
+// A Background scenario stays out of the way even when run by hand.
+func TestBackgroundScenarioRunsAtNormalPriority(t *testing.T) {
+	srv, _, db, store := cancellingService(t)
+	scenarioName := seedScenario(t, store)
+	setPriorityClass(t, db, scenarioName, urth.PriorityClassBackground)
+
+	run, err := srv.Results(scenarioName).Create(context.Background(), newRunRequest())
+	require.NoError(t, err)
+	require.Equal(t, urth.PriorityNormal, loadResult(t, store, run.UID).Spec.Priority)
+}
@@ -0,0 +1,197 @@
+package urth_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/urth/pkg/urth"
+	"github.com/sre-norns/wyrd/pkg/dbstore"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// rateLimitedService is a service that enforces rate limits, as the API server
+// does.
+func rateLimitedService(t *testing.T) (urth.Service, *gorm.DB, *dbstore.DBStore) {
+	t.Helper()
+
+	_, db, store := newTestService(t, &stubScheduler{})
+	srv := urth.NewService(store, &stubScheduler{},
+		urth.WithSigningKeys(testKeys(t)),
+		urth.WithRunRates(urth.NewRunRateStore(db)),
+	)
+
+	return srv, db, store
+}
+
+// declareRateLimit applies a limit on example.com, the host seedScenario probes.
+func declareRateLimit(t *testing.T, srv urth.Service, spec urth.RateLimitSpec) {
+	t.Helper()
+
+	_, err := srv.RateLimits().Create(context.Background(), manifest.ResourceManifest{
+		TypeMeta: manifest.TypeMeta{Kind: urth.KindRateLimit},
+		Metadata: manifest.ObjectMeta{Name: "example-waf"},
+		Spec:     &spec,
+	})
+	require.NoError(t, err)
+}
+
+// A trigger past the budget for the interval is recorded, named after the
+// limit, and never dispatched.
+func TestRunPastItsTargetsBudgetIsSkipped(t *testing.T) {
+	srv, _, store := rateLimitedService(t)
+	scenarioName := seedScenario(t, store)
+	declareRateLimit(t, srv, urth.RateLimitSpec{Hosts: []string{"example.com"}, MaxRuns: 1, Interval: time.Hour})
+
+	first, err := srv.Results(scenarioName).Create(context.Background(), newRunRequest())
+	require.NoError(t, err)
+	require.Equal(t, urth.JobPending, loadResult(t, store, first.UID).Status.Status)
+
+	second, err := srv.Results(scenarioName).Create(context.Background(), newRunRequest())
+	require.NoError(t, err)
+
+	skipped := loadResult(t, store, second.UID)
+	require.Equal(t, urth.JobCompleted, skipped.Status.Status)
+	require.Equal(t, prob.RunFinishedCanceled, skipped.Status.Result)
+	require.Equal(t, urth.ReasonRateLimited, skipped.Labels[urth.LabelResultUnschedulable])
+	require.Equal(t, "example-waf", skipped.Labels[urth.LabelResultRateLimit])
+}
+
+// However many triggers arrive, the interval admits exactly its budget.
+func TestBudgetAdmitsExactlyMaxRunsPerInterval(t *testing.T) {
+	srv, _, store := rateLimitedService(t)
+	scenarioName := seedScenario(t, store)
+	declareRateLimit(t, srv, urth.RateLimitSpec{Hosts: []string{"example.com"}, MaxRuns: 2, Interval: time.Hour})
+
+	for range 4 {
+		_, err := srv.Results(scenarioName).Create(context.Background(), newRunRequest())
+		require.NoError(t, err)
+	}
+
+	var admitted int
+	results, _, err := srv.Results(scenarioName).List(context.Background(), manifest.SearchQuery{})
+	require.NoError(t, err)
+	for _, result := range results {
+		if result.Status.Status == urth.JobPending {
+			admitted++
+		}
+	}
+	require.Equal(t, 2, admitted)
+}
+
+// A claim that would take the target past its concurrency is deferred, not
+// refused: the run stays pending for the redelivery that will find room.
+func TestClaimPastTheConcurrencyLimitIsThrottled(t *testing.T) {
+	srv, _, store := rateLimitedService(t)
+	scenarioName := seedScenario(t, store)
+	declareRateLimit(t, srv, urth.RateLimitSpec{Hosts: []string{"example.com"}, MaxConcurrent: 1})
+
+	first := pendingRun(t, srv, scenarioName)
+	second := pendingRun(t, srv, scenarioName)
+
+	claimRun(t, srv, first)
+
+	_, err := srv.Results("").ClaimRun(context.Background(), second.UID, workerSession(t, srv, "second-worker"),
+		urth.ClaimJobRequest{
+			DispatchID:    urth.DispatchEventUID(second.UID, second.Version),
+			ResultVersion: second.Version,
+		})
+	require.Error(t, err)
+
+	disposition, ok := urth.ClaimDispositionOf(err)
+	require.True(t, ok)
+	require.Equal(t, urth.ClaimThrottled, disposition)
+	require.Equal(t, urth.JobPending, loadResult(t, store, second.UID).Status.Status)
+}
+
+// A limit on another host is no business of this scenario's.
+func TestRateLimitOnAnotherHostDoesNotApply(t *testing.T) {
+	srv, _, store := rateLimitedService(t)
+	scenarioName := seedScenario(t, store)
+	declareRateLimit(t, srv, urth.RateLimitSpec{Hosts: []string{"*.example.org"}, MaxRuns: 1, Interval: time.Hour})
+
+	for range 2 {
+		run, err := srv.Results(scenarioName).Create(context.Background(), newRunRequest())
+		require.NoError(t, err)
+		require.Equal(t, urth.JobPending, loadResult(t, store, run.UID).Status.Status)
+	}
+}
+
+// A run throttled for longer than the consumer's MaxDeliver allows is still
+// pending when the limit frees up, and runs. Each deferral re-queues it as a
+// new dispatch for a new version, so no message is handed back often enough to
+// be dead-lettered.
+func TestThrottledRunOutlastsMaxDeliver(t *testing.T) {
+	const maxDeliver = 5
+
+	srv, db, store := rateLimitedService(t)
+	scenarioName := seedScenario(t, store)
+	declareRateLimit(t, srv, urth.RateLimitSpec{Hosts: []string{"example.com"}, MaxConcurrent: 1})
+
+	claimRun(t, srv, pendingRun(t, srv, scenarioName))
+	waiting := pendingRun(t, srv, scenarioName)
+	session := workerSession(t, srv, "second-worker")
+
+	for deferral := 1; deferral <= maxDeliver*2; deferral++ {
+		current := loadResult(t, store, waiting.UID)
+
+		_, err := srv.Results("").ClaimRun(context.Background(), waiting.UID, session, urth.ClaimJobRequest{
+			DispatchID:    urth.DispatchEventUID(current.UID, current.Version),
+			ResultVersion: current.Version,
+		})
+		disposition, _ := urth.ClaimDispositionOf(err)
+		require.Equal(t, urth.ClaimThrottled, disposition)
+
+		deferred := loadResult(t, store, waiting.UID)
+		require.Equal(t, urth.JobPending, deferred.Status.Status)
+		require.Equal(t, deferral, deferred.Status.Deferrals)
+		require.Greater(t, deferred.Version, current.Version, "a deferral supersedes the dispatch that asked")
+
+		var requeued urth.DispatchOutboxEntry
+		require.NoError(t, db.Where("event_uid = ?", urth.DispatchEventUID(deferred.UID, deferred.Version)).First(&requeued).Error)
+		require.True(t, requeued.NotBefore.After(requeued.CreatedAt), "a re-queued dispatch waits before going out")
+	}
+
+	limit, found, err := srv.RateLimits().Get(context.Background(), "example-waf")
+	require.NoError(t, err)
+	require.True(t, found)
+	_, err = srv.RateLimits().Delete(context.Background(), limit.Metadata.GetVersionedID())
+	require.NoError(t, err)
+
+	current := loadResult(t, store, waiting.UID)
+	_, err = srv.Results("").ClaimRun(context.Background(), waiting.UID, session, urth.ClaimJobRequest{
+		DispatchID:    urth.DispatchEventUID(current.UID, current.Version),
+		ResultVersion: current.Version,
+	})
+	require.NoError(t, err)
+	require.Equal(t, urth.JobRunning, loadResult(t, store, waiting.UID).Status.Status)
+}
+
+// Limits are cached between runs, but one edited through the service judges
+// the very next run.
+func TestRateLimitEditedThroughTheServiceJudgesTheNextRun(t *testing.T) {
+	srv, _, store := rateLimitedService(t)
+	scenarioName := seedScenario(t, store)
+	declareRateLimit(t, srv, urth.RateLimitSpec{Hosts: []string{"*.example.org"}, MaxRuns: 1, Interval: time.Hour})
+
+	ctx := context.Background()
+
+	first, err := srv.Results(scenarioName).Create(ctx, newRunRequest())
+	require.NoError(t, err)
+	require.Equal(t, urth.JobPending, loadResult(t, store, first.UID).Status.Status, "the limit is on another host")
+
+	current, found, err := srv.RateLimits().Get(ctx, "example-waf")
+	require.NoError(t, err)
+	require.True(t, found)
+	current.Spec.(*urth.RateLimitSpec).Hosts = []string{"example.com"}
+	_, err = srv.RateLimits().Update(ctx, current.Metadata.GetVersionedID(), current)
+	require.NoError(t, err)
+
+	second, err := srv.Results(scenarioName).Create(ctx, newRunRequest())
+	require.NoError(t, err)
+	require.Equal(t, urth.ReasonRateLimited, loadResult(t, store, second.UID).Labels[urth.LabelResultUnschedulable])
+}
@@ -0,0 +1,304 @@
+package urth_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/sre-norns/urth/pkg/urth"
+	"github.com/sre-norns/wyrd/pkg/bark"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// Revoking enrolment tokens and blocking workers. Real Postgres, for the reason
+// given in service_outbox_test.go.
+
+// enrolWorker registers a worker with a fresh token, reporting the given host,
+// and returns the token it used and the session it was issued.
+func enrolWorker(t *testing.T, srv urth.Service, workerName manifest.ResourceName, hostname string) (urth.APIToken, urth.APIToken) {
+	t.Helper()
+
+	ctx := context.Background()
+
+	enrolment, found, err := srv.Runners().GetToken(ctx, "test-runner")
+	require.NoError(t, err)
+	require.True(t, found)
+
+	registration, err := srv.Runners().AuthWorker(ctx, enrolment, workerManifest(workerName, hostname))
+	require.NoError(t, err)
+
+	return enrolment, registration.Session
+}
+
+func workerManifest(name manifest.ResourceName, hostname string) manifest.ResourceManifest {
+	labels := manifest.Labels{}
+	if hostname != "" {
+		labels[urth.LabelWorkerHostname] = hostname
+	}
+
+	return manifest.ResourceManifest{
+		TypeMeta: manifest.TypeMeta{Kind: urth.KindWorkerInstance},
+		Metadata: manifest.ObjectMeta{Name: name, Labels: labels},
+		Spec:     &urth.WorkerInstanceSpec{},
+	}
+}
+
+// claimPending creates a run and has the worker holding session claim it.
+func claimPending(t *testing.T, srv urth.Service, scenario manifest.ResourceName, session urth.APIToken) error {
+	t.Helper()
+
+	ctx := context.Background()
+
+	created, err := srv.Results(scenario).Create(ctx, newRunRequest())
+	require.NoError(t, err)
+
+	_, err = srv.Results("").ClaimRun(ctx, created.UID, session, urth.ClaimJobRequest{
+		DispatchID:    urth.DispatchEventUID(created.UID, created.Version),
+		ResultVersion: created.Version,
+	})
+
+	return err
+}
+
+func requireClaimForbidden(t *testing.T, err error) {
+	t.Helper()
+
+	require.Error(t, err)
+	disposition, ok := urth.ClaimDispositionOf(err)
+	require.True(t, ok, "expected a claim disposition, got %v", err)
+	require.Equal(t, urth.ClaimForbidden, disposition)
+}
+
+// Every token issued is on record, and its record is how an operator finds the
+// token a worker came in with.
+func TestIssuedTokensAreListedWithTheirFingerprints(t *testing.T) {
+	srv, _, store := presenceService(t)
+	seedScenarioWithProb(t, store, restProb(probeA))
+
+	enrolment, _ := enrolWorker(t, srv, "test-worker", "")
+
+	tokens, found, err := srv.Runners().Tokens(context.Background(), "test-runner")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Len(t, tokens, 1)
+	require.Equal(t, urth.EnrolmentFingerprint(enrolment), tokens[0].Spec.Fingerprint)
+	require.False(t, tokens[0].IsRevoked())
+
+	status := workerStatus(t, srv, "test-worker")
+	require.Equal(t, string(tokens[0].Name), status.EnrolmentTokenID)
+	require.Equal(t, tokens[0].Spec.Fingerprint, status.EnrolmentFingerprint)
+
+	_, found, err = srv.Runners().Tokens(context.Background(), "no-such-runner")
+	require.NoError(t, err)
+	require.False(t, found)
+}
+
+// A revoked token cannot enrol anybody, and the workers it already enrolled stop
+// taking work: their sessions outlive the revocation, and must not outlive it in
+// effect.
+func TestRevokedTokenRefusesRegistrationAndClaims(t *testing.T) {
+	srv, _, store := presenceService(t)
+	scenario := seedScenarioWithProb(t, store, restProb(probeA))
+
+	ctx := context.Background()
+	enrolment, session := enrolWorker(t, srv, "test-worker", "")
+
+	tokens, _, err := srv.Runners().Tokens(ctx, "test-runner")
+	require.NoError(t, err)
+	require.Len(t, tokens, 1)
+
+	revoked, err := srv.Runners().RevokeToken(ctx, "test-runner", tokens[0].Name, urth.RevokeTokenRequest{Reason: "leaked"})
+	require.NoError(t, err)
+	require.True(t, revoked.IsRevoked())
+	require.Equal(t, "leaked", revoked.Status.Reason)
+
+	_, err = srv.Runners().AuthWorker(ctx, enrolment, workerManifest("another-worker", ""))
+	require.ErrorIs(t, err, bark.ErrResourceUnauthorized)
+
+	requireClaimForbidden(t, claimPending(t, srv, scenario.Name, session))
+
+	_, err = srv.Workers().Heartbeat(ctx, session, urth.WorkerHeartbeatRequest{})
+	require.ErrorIs(t, err, bark.ErrResourceUnauthorized)
+
+	// A token revoked again keeps its first revocation.
+	again, err := srv.Runners().RevokeToken(ctx, "test-runner", tokens[0].Name, urth.RevokeTokenRequest{Reason: "twice"})
+	require.NoError(t, err)
+	require.Equal(t, "leaked", again.Status.Reason)
+	require.Equal(t, revoked.Status.RevokedAt.Unix(), again.Status.RevokedAt.Unix())
+}
+
+// Revoking one token leaves workers enrolled with another alone.
+func TestRevokingATokenLeavesOtherTokensWorking(t *testing.T) {
+	srv, _, store := presenceService(t)
+	scenario := seedScenarioWithProb(t, store, restProb(probeA))
+
+	ctx := context.Background()
+	leaked, _ := enrolWorker(t, srv, "worker-a", "")
+	_, session := enrolWorker(t, srv, "worker-b", "")
+
+	tokens, _, err := srv.Runners().Tokens(ctx, "test-runner")
+	require.NoError(t, err)
+	require.Len(t, tokens, 2)
+
+	for _, token := range tokens {
+		if token.Spec.Fingerprint == urth.EnrolmentFingerprint(leaked) {
+			_, err := srv.Runners().RevokeToken(ctx, "test-runner", token.Name, urth.RevokeTokenRequest{})
+			require.NoError(t, err)
+		}
+	}
+
+	require.NoError(t, claimPending(t, srv, scenario.Name, session))
+}
+
+// A token is revoked through the runner it was issued for; named through any
+// other, it is not found.
+func TestRevokingAnUnknownTokenIsNotFound(t *testing.T) {
+	srv, _, store := presenceService(t)
+	seedScenarioWithProb(t, store, restProb(probeA))
+
+	_, err := srv.Runners().RevokeToken(context.Background(), "test-runner", "no-such-token", urth.RevokeTokenRequest{})
+	require.ErrorIs(t, err, bark.ErrResourceNotFound)
+}
+
+// A host blocked after its worker registered stops working at its next claim
+// and heartbeat, and cannot come back under another name.
+func TestBlockedHostIsRefusedEverywhere(t *testing.T) {
+	srv, _, store := presenceService(t)
+	scenario := seedScenarioWithProb(t, store, restProb(probeA))
+
+	ctx := context.Background()
+	enrolment, session := enrolWorker(t, srv, "test-worker", "build-host-7")
+
+	block := urth.WorkerBlock{
+		ObjectMeta: manifest.ObjectMeta{Name: "build-host-7"},
+		Spec:       urth.WorkerBlockSpec{Hostname: "Build-Host-7"},
+	}
+	created, err := srv.WorkerBlocks().Create(ctx, block.ToManifest())
+	require.NoError(t, err)
+
+	listed, err := urth.NewWorkerBlock(created)
+	require.NoError(t, err)
+	require.Equal(t, []manifest.ResourceName{"test-worker"}, listed.Status.Workers)
+
+	requireClaimForbidden(t, claimPending(t, srv, scenario.Name, session))
+
+	_, err = srv.Workers().Heartbeat(ctx, session, urth.WorkerHeartbeatRequest{})
+	require.ErrorIs(t, err, bark.ErrResourceUnauthorized)
+
+	_, err = srv.Runners().AuthWorker(ctx, enrolment, workerManifest("renamed-worker", "build-host-7"))
+	require.ErrorIs(t, err, bark.ErrResourceUnauthorized)
+
+	// Another host on the same token and runner is not affected.
+	_, err = srv.Runners().AuthWorker(ctx, enrolment, workerManifest("other-worker", "build-host-8"))
+	require.NoError(t, err)
+
+	// Lifting the block lets the worker back in.
+	_, err = srv.WorkerBlocks().Delete(ctx, listed.GetVersionedID())
+	require.NoError(t, err)
+	require.NoError(t, claimPending(t, srv, scenario.Name, session))
+}
+
+// A block by fingerprint bars every holder of the token, whatever they call
+// themselves.
+func TestBlockedFingerprintBarsEveryHolderOfTheToken(t *testing.T) {
+	srv, _, store := presenceService(t)
+	seedScenarioWithProb(t, store, restProb(probeA))
+
+	ctx := context.Background()
+	enrolment, _ := enrolWorker(t, srv, "test-worker", "build-host-7")
+
+	block := urth.WorkerBlock{
+		ObjectMeta: manifest.ObjectMeta{Name: "leaked-token"},
+		Spec:       urth.WorkerBlockSpec{Fingerprint: urth.EnrolmentFingerprint(enrolment)},
+	}
+	_, err := srv.WorkerBlocks().Create(ctx, block.ToManifest())
+	require.NoError(t, err)
+
+	_, err = srv.Runners().AuthWorker(ctx, enrolment, workerManifest("somebody-else", "elsewhere"))
+	require.ErrorIs(t, err, bark.ErrResourceUnauthorized)
+
+	// A fresh token is a different fingerprint.
+	fresh, _, err := srv.Runners().GetToken(ctx, "test-runner")
+	require.NoError(t, err)
+	_, err = srv.Runners().AuthWorker(ctx, fresh, workerManifest("somebody-else", "elsewhere"))
+	require.NoError(t, err)
+}
+
+// A block has to name somebody. One that names nobody is a mistake, not a
+// block of nothing.
+func TestWorkerBlockNamingNobodyIsRefused(t *testing.T) {
+	srv, _, _ := newTestService(t, &stubScheduler{})
+
+	block := urth.WorkerBlock{
+		ObjectMeta: manifest.ObjectMeta{Name: "empty"},
+		Spec:       urth.WorkerBlockSpec{Description: "forgot to say which"},
+	}
+
+	_, err := srv.WorkerBlocks().Create(context.Background(), block.ToManifest())
+	require.Error(t, err)
+}
+
+// Claims are judged against a cached list of blocks and tokens. A token issued
+// after that list was read is not in it, and is looked up rather than refused.
+func TestTokenIssuedSinceTheCacheWasReadIsLookedUp(t *testing.T) {
+	srv, _, store := presenceService(t)
+	scenario := seedScenarioWithProb(t, store, restProb(probeA))
+
+	_, first := enrolWorker(t, srv, "worker-a", "")
+	require.NoError(t, claimPending(t, srv, scenario.Name, first))
+
+	_, second := enrolWorker(t, srv, "worker-b", "")
+	require.NoError(t, claimPending(t, srv, scenario.Name, second))
+}
+
+// The reconciler prunes the records of expired tokens, revoked or not, but not
+// the record of one a registered worker came in with: that worker's claims are
+// judged on it.
+func TestReconcilerPrunesExpiredTokensNobodyHolds(t *testing.T) {
+	srv, db, store := presenceService(t)
+	scenario := seedScenarioWithProb(t, store, restProb(probeA))
+
+	ctx := context.Background()
+	_, session := enrolWorker(t, srv, "test-worker", "")
+
+	for range 2 {
+		_, _, err := srv.Runners().GetToken(ctx, "test-runner")
+		require.NoError(t, err)
+	}
+
+	tokens, _, err := srv.Runners().Tokens(ctx, "test-runner")
+	require.NoError(t, err)
+	require.Len(t, tokens, 3)
+
+	held := workerStatus(t, srv, "test-worker").EnrolmentTokenID
+	var unused []manifest.ResourceName
+	for _, token := range tokens {
+		if string(token.Name) != held {
+			unused = append(unused, token.Name)
+		}
+	}
+
+	_, err = srv.Runners().RevokeToken(ctx, "test-runner", unused[0], urth.RevokeTokenRequest{})
+	require.NoError(t, err)
+
+	// The held token and one unused one expire; the other unused one is good.
+	require.NoError(t, db.Model(&urth.EnrolmentToken{}).
+		Where("name IN ?", []string{held, string(unused[0])}).
+		UpdateColumn("expires_at", time.Now().Add(-time.Minute)).Error)
+
+	report, err := urth.NewReconciler(urth.NewReconcileStore(db, store)).RunOnce(ctx)
+	require.NoError(t, err)
+	require.Equal(t, 1, report.PrunedTokens)
+
+	remaining, _, err := srv.Runners().Tokens(ctx, "test-runner")
+	require.NoError(t, err)
+	names := make([]string, 0, len(remaining))
+	for _, token := range remaining {
+		names = append(names, string(token.Name))
+	}
+	require.ElementsMatch(t, []string{held, string(unused[1])}, names)
+
+	require.NoError(t, claimPending(t, srv, scenario.Name, session), "the worker holding the expired token still works")
+}
@@ -1,9 +1,11 @@
 package urth
 
 import (
+	"bytes"
 	"crypto/rand"
 	"fmt"
 	"log"
+	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -27,6 +29,9 @@ const TokenIssuer = "urth"
 // The prototype signed with two hardcoded literals -- `my_secret_key` and
 // `my_results signing secret key, duh` -- checked into the repository, which is
 // to say it had no key management at all.
+//
+// This is the fixed form: one secret per tier, never rotated. A server holds a
+// Keyring instead, which can hold several per tier and read them again.
 type SigningKeys struct {
 	// Enrolment signs the long-lived runner tokens an operator hands to a
 	// worker to let it register.
@@ -40,12 +45,81 @@ type SigningKeys struct {
 	Run []byte
 }
 
+// secret is the tier's secret.
+func (k SigningKeys) secret(tier KeyTier) []byte {
+	switch tier {
+	case KeyTierEnrolment:
+		return k.Enrolment
+	case KeyTierSession:
+		return k.Session
+	case KeyTierRun:
+		return k.Run
+	default:
+		return nil
+	}
+}
+
+// SigningKey returns the tier's one key, under an ID derived from its secret.
+func (k SigningKeys) SigningKey(tier KeyTier) (SigningKey, error) {
+	secret := k.secret(tier)
+	if len(secret) == 0 {
+		return SigningKey{}, fmt.Errorf("no %s signing key is configured", tier)
+	}
+
+	return SigningKey{ID: staticKeyID(secret), Secret: secret}, nil
+}
+
+// VerificationKeys returns the tier's one key, or none if it has no secret.
+func (k SigningKeys) VerificationKeys(tier KeyTier) []SigningKey {
+	key, err := k.SigningKey(tier)
+	if err != nil {
+		return nil
+	}
+
+	return []SigningKey{key}
+}
+
 // SigningKeysConfig is the operator-facing form of SigningKeys, embeddable into
 // a command's kong configuration.
+//
+// A tier's key can be given three ways, and they combine. A secret, in a flag
+// or from a file, is one key that never retires. A keyring directory holds the
+// keys `urthctl signing-keys rotate` writes, each with an ID and a validity
+// window. A tier with both verifies with both and signs with the directory's
+// newest active key, which is how a deployment moves from a configured secret to
+// rotation without an outage: rotate, wait out the overlap, drop the secret.
 type SigningKeysConfig struct {
 	EnrolmentKey string `help:"Secret used to sign runner enrolment tokens" env:"URTH_ENROLMENT_SIGNING_KEY"`
 	SessionKey   string `help:"Secret used to sign worker session tokens" env:"URTH_SESSION_SIGNING_KEY"`
 	RunKey       string `help:"Secret used to sign per-run capability tokens" env:"URTH_RUN_SIGNING_KEY"`
+
+	// Files rather than flags keep a secret out of the process listing, and let
+	// one mounted from a secret store change without a restart. Replacing a
+	// file's secret cuts over at once; rotation is the keyring directory's job.
+	EnrolmentKeyFile string `help:"File holding the secret used to sign runner enrolment tokens, read again on reload" env:"URTH_ENROLMENT_SIGNING_KEY_FILE" type:"path"`
+	SessionKeyFile   string `help:"File holding the secret used to sign worker session tokens, read again on reload" env:"URTH_SESSION_SIGNING_KEY_FILE" type:"path"`
+	RunKeyFile       string `help:"File holding the secret used to sign per-run capability tokens, read again on reload" env:"URTH_RUN_SIGNING_KEY_FILE" type:"path"`
+
+	KeyringDir     string        `help:"Directory of rotating signing keys, one subdirectory per tier; see urthctl signing-keys" env:"URTH_SIGNING_KEYRING_DIR" type:"path"`
+	ReloadInterval time.Duration `help:"How often key files and the keyring directory are read again; SIGHUP reads them at once. Zero reads them on SIGHUP only" default:"1m"`
+}
+
+// Reloadable reports whether any key is read from a file, so that reading again
+// can change it.
+func (c SigningKeysConfig) Reloadable() bool {
+	return c.KeyringDir != "" || c.EnrolmentKeyFile != "" || c.SessionKeyFile != "" || c.RunKeyFile != ""
+}
+
+// tierSources are the secret and the key file configured for a tier.
+func (c SigningKeysConfig) tierSources(tier KeyTier) (string, string) {
+	switch tier {
+	case KeyTierEnrolment:
+		return c.EnrolmentKey, c.EnrolmentKeyFile
+	case KeyTierSession:
+		return c.SessionKey, c.SessionKeyFile
+	default:
+		return c.RunKey, c.RunKeyFile
+	}
 }
 
 // Build turns configured secrets into signing keys, generating any that were
@@ -77,19 +151,142 @@ func (c SigningKeysConfig) Build() (SigningKeys, error) {
 			continue
 		}
 
-		secret := make([]byte, 32)
-		if _, err := rand.Read(secret); err != nil {
-			return keys, fmt.Errorf("failed to generate %s signing key: %w", k.name, err)
+		secret, err := generateSigningSecret(k.name)
+		if err != nil {
+			return keys, err
 		}
 		*k.dst = secret
 		generated = append(generated, k.name)
 	}
 
+	warnGeneratedKeys(generated)
+
+	return keys, nil
+}
+
+func generateSigningSecret(tier string) ([]byte, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate %s signing key: %w", tier, err)
+	}
+
+	return secret, nil
+}
+
+func warnGeneratedKeys(generated []string) {
 	if len(generated) > 0 {
 		log.Printf("WARNING: no signing key configured for %v; generated ephemeral keys. "+
 			"Tokens will not survive a restart and will not validate across API server replicas. "+
 			"Set the corresponding signing key flags or environment variables for any real deployment.", generated)
 	}
+}
+
+// Keyring builds the keyring a server signs and verifies with, and reads every
+// configured file once to prove it can.
+//
+// A tier given neither a secret, a file nor any key in the keyring directory is
+// generated a key. With a keyring directory configured, the key is written into
+// it, as `urthctl signing-keys rotate` would with no activation delay: the
+// operator has said where keys live, and a key held only in memory there would
+// be lost at the next restart and unknown to every other replica -- the very
+// things a keyring directory is for. Replicas bootstrapping an empty directory
+// at once each write one, and pick up each other's at the next reload.
+//
+// Without a directory the key is generated in memory, as Build does and with
+// the same warning. It is generated once, here, and kept across reloads: a
+// reload that regenerated it would invalidate every token of the tier for no
+// reason.
+func (c SigningKeysConfig) Keyring() (*Keyring, error) {
+	var dirKeys map[KeyTier][]SigningKey
+	if c.KeyringDir != "" {
+		var err error
+		if dirKeys, err = ReadKeyringDir(c.KeyringDir); err != nil {
+			return nil, err
+		}
+	}
+
+	generated := map[KeyTier][]byte{}
+	var names []string
+	for _, tier := range KeyTiers {
+		secret, file := c.tierSources(tier)
+		if secret != "" && file != "" {
+			return nil, fmt.Errorf("the %s signing key is configured both as a secret and as a file; set one", tier)
+		}
+
+		if secret != "" || file != "" || len(dirKeys[tier]) > 0 {
+			continue
+		}
+
+		if c.KeyringDir != "" {
+			key, err := RotateSigningKey(c.KeyringDir, tier, 0, 0)
+			if err != nil {
+				return nil, fmt.Errorf("failed to bootstrap the %s signing key: %w", tier, err)
+			}
+			log.Printf("no %s signing key in %s; wrote key %q", tier, c.KeyringDir, key.ID)
+			continue
+		}
+
+		key, err := generateSigningSecret(string(tier))
+		if err != nil {
+			return nil, err
+		}
+		generated[tier] = key
+		names = append(names, string(tier))
+	}
+
+	warnGeneratedKeys(names)
+
+	ring := &Keyring{
+		load: func() (map[KeyTier][]SigningKey, error) {
+			return c.loadKeys(generated)
+		},
+	}
+
+	if _, err := ring.Reload(); err != nil {
+		return nil, err
+	}
+
+	return ring, nil
+}
+
+// loadKeys reads every configured key, falling back to a generated one for a
+// tier configured with none.
+func (c SigningKeysConfig) loadKeys(generated map[KeyTier][]byte) (map[KeyTier][]SigningKey, error) {
+	keys := map[KeyTier][]SigningKey{}
+	if c.KeyringDir != "" {
+		var err error
+		if keys, err = ReadKeyringDir(c.KeyringDir); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, tier := range KeyTiers {
+		secret, file := c.tierSources(tier)
+
+		var configured []byte
+		switch {
+		case file != "":
+			content, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read the %s signing key: %w", tier, err)
+			}
+
+			// A trailing newline is what an editor or `echo` leaves, not part of
+			// the secret.
+			configured = bytes.TrimRight(content, "\r\n")
+			if len(configured) == 0 {
+				return nil, fmt.Errorf("the %s signing key file %s is empty", tier, file)
+			}
+		case secret != "":
+			configured = []byte(secret)
+		default:
+			configured = generated[tier]
+		}
+
+		if len(configured) > 0 {
+			keys[tier] = append(keys[tier], SigningKey{ID: staticKeyID(configured), Secret: configured})
+		}
+	}
 
 	return keys, nil
 }
@@ -112,7 +309,7 @@ type WorkerSessionClaims struct {
 }
 
 // IssueWorkerSession mints a session credential for a registered worker.
-func IssueWorkerSession(keys SigningKeys, runnerUID, workerUID manifest.ResourceID, ttl time.Duration) (APIToken, time.Time, error) {
+func IssueWorkerSession(keys TokenKeys, runnerUID, workerUID manifest.ResourceID, ttl time.Duration) (APIToken, time.Time, error) {
 	now := time.Now()
 	expiresAt := now.Add(ttl)
 
@@ -128,7 +325,7 @@ func IssueWorkerSession(keys SigningKeys, runnerUID, workerUID manifest.Resource
 		WorkerID: workerUID,
 	}
 
-	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(keys.Session)
+	signed, err := signToken(keys, KeyTierSession, claims)
 	if err != nil {
 		return "", time.Time{}, fmt.Errorf("failed to sign worker session token: %w", err)
 	}
@@ -141,11 +338,11 @@ func IssueWorkerSession(keys SigningKeys, runnerUID, workerUID manifest.Resource
 // Every error is reported as ErrResourceUnauthorized rather than distinguished:
 // telling a caller whether its token was expired, wrongly signed, or issued by
 // someone else tells an attacker which part to change next.
-func ParseWorkerSession(keys SigningKeys, token APIToken) (WorkerSessionClaims, error) {
+func ParseWorkerSession(keys TokenKeys, token APIToken) (WorkerSessionClaims, error) {
 	var claims WorkerSessionClaims
 
 	parsed, err := jwt.ParseWithClaims(string(token), &claims,
-		func(*jwt.Token) (interface{}, error) { return keys.Session, nil },
+		tokenKeyfunc(keys, KeyTierSession),
 		// Pin the algorithm. Without this, a token presented with alg=none --
 		// or signed with a different family than intended -- reaches the
 		// verification step and may be accepted.
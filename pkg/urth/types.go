@@ -43,6 +43,18 @@ type WorkerInstanceStatus struct {
 	// -- keeps taking jobs rather than silently going dark.
 	IsPaused bool `form:"paused" json:"paused,omitempty" yaml:"paused,omitempty" xml:"paused,omitempty"`
 
+	// IsDraining asks this worker to finish what it is running and leave: stop
+	// pulling from its queue, let its in-flight runs complete, say it is
+	// leaving, and exit. Used to empty a host before maintenance without
+	// cutting short a run that is half way through.
+	//
+	// Distinct from IsPaused, which keeps the process up and idle. A paused
+	// worker is still there to be resumed; a drained one has gone, and its
+	// presence reads `left` once it has. Like IsPaused it survives
+	// re-registration, so a drained worker restarted before the flag is cleared
+	// registers, is told to drain, and leaves again.
+	IsDraining bool `form:"draining" json:"draining,omitempty" yaml:"draining,omitempty" xml:"draining,omitempty"`
+
 	// LastSeenTime is when this worker last reached the API server, by any
 	// authenticated route -- see WorkerPresenceAt for what the two liveness
 	// signals mean and why they are kept apart.
@@ -71,6 +83,25 @@ type WorkerInstanceStatus struct {
 	// timeout to show it would make the fleet view feel broken.
 	LeftAt *time.Time `form:"leftAt,omitempty" json:"leftAt,omitempty" yaml:"leftAt,omitempty" xml:"leftAt,omitempty" gorm:"type:TIMESTAMPTZ NULL"`
 
+	// SelfTestFailures are the prob kinds this worker is built to run but found
+	// it cannot, as of its last heartbeat, and why.
+	//
+	// Reported by the worker rather than set by anyone, and replaced whole by
+	// every heartbeat: a kind that starts passing again drops out at the next
+	// report, without anybody having to clear it. Placement reads it so runs of
+	// a kind stop being sent to runners whose workers cannot run it; see
+	// workerCanRun.
+	SelfTestFailures []ProbSelfTestFailure `form:"selfTestFailures,omitempty" json:"selfTestFailures,omitempty" yaml:"selfTestFailures,omitempty" xml:"selfTestFailures,omitempty" gorm:"serializer:json"`
+
+	// EnrolmentTokenID is the `jti` of the enrolment token the worker last
+	// registered with, and EnrolmentFingerprint that token's
+	// EnrolmentFingerprint. Set by the server at registration; they are how a
+	// revoked token or a WorkerBlock by fingerprint reaches a worker that has
+	// already registered. EnrolmentTokenID is empty for a token issued before
+	// tokens had one.
+	EnrolmentTokenID     string `form:"enrolmentTokenId,omitempty" json:"enrolmentTokenId,omitempty" yaml:"enrolmentTokenId,omitempty" xml:"enrolmentTokenId,omitempty"`
+	EnrolmentFingerprint string `form:"enrolmentFingerprint,omitempty" json:"enrolmentFingerprint,omitempty" yaml:"enrolmentFingerprint,omitempty" xml:"enrolmentFingerprint,omitempty"`
+
 	// Presence is the liveness verdict, computed when the record is read and
 	// never stored -- the arrangement of RunnerStatus.NumberInstances and
 	// ScenarioStatus.NextRun.
@@ -82,6 +113,25 @@ type WorkerInstanceStatus struct {
 	Presence WorkerPresenceReport `form:"presence" json:"presence" yaml:"presence" xml:"presence" gorm:"-"`
 }
 
+// ProbSelfTestFailure is one prob kind a worker cannot run, with the reason its
+// self-test gave. See prob.SelfTestFn.
+type ProbSelfTestFailure struct {
+	Kind   prob.Kind `form:"kind" json:"kind" yaml:"kind" xml:"kind"`
+	Reason string    `form:"reason,omitempty" json:"reason,omitempty" yaml:"reason,omitempty" xml:"reason,omitempty"`
+}
+
+// SelfTestFailed reports whether the worker's last self-test said it cannot run
+// kind.
+func (s WorkerInstanceStatus) SelfTestFailed(kind prob.Kind) bool {
+	for _, failure := range s.SelfTestFailures {
+		if failure.Kind == kind {
+			return true
+		}
+	}
+
+	return false
+}
+
 // RunnerSpec holds information about a runner as supplied by the administrator to register one
 type RunnerSpec struct {
 	// Description is a human readable text to describe intent behind this runner
@@ -95,6 +145,12 @@ type RunnerSpec struct {
 
 	// MaxInstances specifies maximum number of worker instance of this runner type. 0 means no limit
 	MaxInstances uint64 `json:"maxInstance,omitempty" yaml:"maxInstance,omitempty"`
+
+	// Network is the proxy and extra CAs the runner's probes go through. It is
+	// the fleet's default: a worker's own --network flags win where they say
+	// something different, since the worker's host is what the traffic leaves.
+	// Delivered with every claim, so a change applies from the next run.
+	Network prob.NetworkConfig `form:"network,omitempty" json:"network,omitempty" yaml:"network,omitempty" xml:"network,omitempty" gorm:"serializer:json"`
 }
 
 // RunnerStatus is information that owned and managed by the runner itself
@@ -114,6 +170,44 @@ type RunnerStatus struct {
 	// the fleet. Nothing on the dispatch path consults it -- see
 	// RunnerChannelObserver.
 	Channel RunnerChannelStatus `json:"channel" yaml:"channel" gorm:"-"`
+
+	// IsDraining drains every worker of this runner, present and future: each
+	// is told to finish its in-flight runs and leave, exactly as if it had been
+	// drained on its own. See WorkerInstanceStatus.IsDraining.
+	//
+	// In Status rather than Spec because it is an operator's action on a
+	// running fleet, not part of the runner's definition: applying the
+	// runner's manifest again must not quietly undo a drain in progress.
+	IsDraining bool `json:"draining,omitempty" yaml:"draining,omitempty"`
+
+	// TerminatingSince is when this runner was deleted. A deleted runner is not
+	// removed at once: it stays, refusing new work, until the reconciler has
+	// settled the runs placed on it and taken down its queue, and only then is
+	// the resource itself removed. See Reconciler.finalizeRunners.
+	//
+	// Never cleared. A deletion is not undone by applying the manifest again --
+	// the queue may already be gone -- and a runner with the same name is
+	// created once this one has been removed.
+	TerminatingSince *time.Time `json:"terminatingSince,omitempty" yaml:"terminatingSince,omitempty" gorm:"type:TIMESTAMPTZ NULL"`
+}
+
+// IsTerminating reports whether the runner has been deleted and is waiting for
+// the reconciler to finish removing it.
+func (s RunnerStatus) IsTerminating() bool {
+	return s.TerminatingSince != nil
+}
+
+// acceptsWork reports whether a runner may be placed on and its workers may
+// claim: it is enabled, and it is not on its way out.
+func (r Runner) acceptsWork() bool {
+	return r.Spec.IsActive && !r.Status.IsTerminating()
+}
+
+// drainsWorkers reports whether every worker of the runner is to finish its
+// in-flight runs and leave. A runner being deleted drains its workers exactly as
+// an operator's drain does: none of them will be handed anything again.
+func (r Runner) drainsWorkers() bool {
+	return r.Status.IsDraining || r.Status.IsTerminating()
 }
 
 // CronSchedule is a type to represent cron-like schedule: "@daily" or "0 */5 * * * *"
@@ -129,11 +223,48 @@ type ScenarioSpec struct {
 	// A schedule to run the script
 	RunSchedule CronSchedule `form:"schedule" json:"schedule,omitempty" yaml:"schedule,omitempty" xml:"schedule"`
 
+	// ConcurrencyPolicy says what a new run does about this scenario's runs
+	// still in flight: Allow (the default), Forbid or Replace. Applied to
+	// scheduled and manual runs alike; see ConcurrencyPolicy.
+	ConcurrencyPolicy ConcurrencyPolicy `form:"concurrencyPolicy" json:"concurrencyPolicy,omitempty" yaml:"concurrencyPolicy,omitempty" xml:"concurrencyPolicy,omitempty"`
+
+	// PriorityClass says which of its runs jump their runner's queue. Empty
+	// reads as PriorityClassStandard: manual runs and retries do, scheduled
+	// runs do not.
+	PriorityClass PriorityClass `form:"priorityClass" json:"priorityClass,omitempty" yaml:"priorityClass,omitempty" xml:"priorityClass,omitempty"`
+
+	// DependsOn lists the scenarios this one's target sits behind, by name or
+	// by label selector. A failure of this scenario while one of them is
+	// failing too is labelled as a consequence of it; see ScenarioDependency.
+	DependsOn []ScenarioDependency `form:"dependsOn" json:"dependsOn,omitempty" yaml:"dependsOn,omitempty" xml:"dependsOn,omitempty" gorm:"serializer:json"`
+
+	// OnlineCapacity says what a run does when no worker that could take it is
+	// online: queue (the default) and wait for one, fail at once
+	// (require-online), or wait no longer than OnlineCapacityAfter
+	// (fail-fast-after). See OnlineCapacityPolicy.
+	OnlineCapacity OnlineCapacityPolicy `form:"onlineCapacity" json:"onlineCapacity,omitempty" yaml:"onlineCapacity,omitempty" xml:"onlineCapacity,omitempty"`
+
+	// OnlineCapacityAfter is how long a run of a fail-fast-after scenario may
+	// wait to be claimed. Required by that policy and refused by the others.
+	OnlineCapacityAfter time.Duration `form:"onlineCapacityAfter" json:"onlineCapacityAfter,omitempty" yaml:"onlineCapacityAfter,omitempty" xml:"onlineCapacityAfter,omitempty"`
+
 	// IsActive - scenario state: If false scenario will not be picked up for scheduling
 	IsActive bool `form:"active" json:"active" yaml:"active" xml:"active"`
 
 	// Script is the actual test scenario that a qualified runner executes
 	Prob prob.Manifest `form:"prob" json:"prob,omitempty" yaml:"prob,omitempty" xml:"prob" gorm:"serializer:json"`
+
+	// Retention overrides how long this scenario's run history is kept in full.
+	// Unset fields follow the server's defaults; see RetentionOverride.
+	Retention *RetentionOverride `form:"retention" json:"retention,omitempty" yaml:"retention,omitempty" xml:"retention" gorm:"serializer:json"`
+
+	// SLO is the availability this scenario's target is held to. Optional: an
+	// availability report is answered without one, just with no error budget.
+	SLO *SLO `form:"slo" json:"slo,omitempty" yaml:"slo,omitempty" xml:"slo" gorm:"serializer:json"`
+
+	// Latency adjusts how this scenario's slow runs are told apart. Unset, the
+	// server's defaults apply; see LatencyPolicy.
+	Latency *LatencyPolicy `form:"latency" json:"latency,omitempty" yaml:"latency,omitempty" xml:"latency" gorm:"serializer:json"`
 }
 
 // ComputeNextRun compute next point in time when a given Scenario can be scheduled to run
@@ -157,7 +288,7 @@ type ScenarioStatus struct {
 	Results []Result   `json:"results,omitempty" yaml:"results,omitempty" gorm:"foreignKey:ScenarioID"`
 }
 
-// JobStatus represents a state of job: pending -> running -> completed | timeout | errored
+// JobStatus represents a state of job: pending -> running [-> cancelling] -> completed | timeout | errored
 type JobStatus string
 
 const (
@@ -171,6 +302,9 @@ const (
 	JobCompleted JobStatus = "completed"
 	// A server failed to schedule the job
 	JobErrored JobStatus = "errored"
+	// An operator cancelled the job while a runner was executing it, and the
+	// runner has not yet reported that it stopped
+	JobCancelling JobStatus = "cancelling"
 )
 
 type ResultSpec struct {
@@ -181,6 +315,11 @@ type ResultSpec struct {
 	// Note that since scenario can be updated, current value of Scenario.Spec.Prob.Kind - might be different
 	ProbKind prob.Kind `form:"probKind" json:"probKind,omitempty" yaml:"probKind,omitempty" xml:"probKind"`
 
+	// Priority is the queue this run is dispatched on, decided when it is
+	// created from its trigger and its scenario's PriorityClass. Set by the
+	// server; a value in a request is overwritten.
+	Priority RunPriority `form:"priority" json:"priority,omitempty" yaml:"priority,omitempty" xml:"priority,omitempty"`
+
 	// Execution is the immutable input this run was created to execute. See
 	// ExecutionSnapshot: it is captured from the Scenario when the run is
 	// scheduled and is the only thing a claim is authorised against.
@@ -268,9 +407,51 @@ type ResultStatus struct {
 	// tell an abandoned run from a slow one.
 	Deadline time.Time `form:"deadline,omitempty" json:"deadline,omitempty" yaml:"deadline,omitempty" xml:"deadline,omitempty" gorm:"type:TIMESTAMPTZ NULL"`
 
+	// ClaimBy is when this run stops being worth executing, for a scenario
+	// whose OnlineCapacity is fail-fast-after. Decided when the run is created.
+	// A claim after it is refused, and the reconciler expires a run still
+	// pending past it. Nil for every other run, which waits as long as it must.
+	ClaimBy *time.Time `form:"claimBy,omitempty" json:"claimBy,omitempty" yaml:"claimBy,omitempty" xml:"claimBy,omitempty" gorm:"type:TIMESTAMPTZ NULL"`
+
+	// Deferrals counts the claims of this run that were turned away for now --
+	// by a rate limit, or by a worker that cannot run its kind -- each of which
+	// re-queued it under a new version. It sets how long the next re-queue
+	// waits. See deferRun.
+	Deferrals int `form:"deferrals,omitempty" json:"deferrals,omitempty" yaml:"deferrals,omitempty" xml:"deferrals,omitempty"`
+
 	// Result of the job execution, if job has been scheduled and finished one way or the other.
 	Result prob.RunStatus `form:"result" json:"result,omitempty" yaml:"result,omitempty" xml:"result"`
 
+	// Cancellation records an operator asking for this run to be stopped. It is
+	// kept once the run finishes, so a `canceled` verdict always says who asked
+	// for it and why.
+	Cancellation *RunCancellation `form:"cancellation,omitempty" json:"cancellation,omitempty" yaml:"cancellation,omitempty" xml:"cancellation,omitempty" gorm:"serializer:json"`
+
+	// Network is the proxy and CAs the probe actually went through, as the
+	// worker applied them, with any proxy credentials redacted. Empty for a
+	// probe that went directly and trusted only the system's roots. Recorded
+	// so that a timeout or a certificate error can be put down to the proxy
+	// rather than to the site.
+	Network *prob.NetworkApplied `form:"network,omitempty" json:"network,omitempty" yaml:"network,omitempty" xml:"network,omitempty" gorm:"serializer:json"`
+
+	// Maintenance names the maintenance window this run was created inside,
+	// if any. Decided when the run is created and never changed. A run that has
+	// one is excluded from availability and from the probe and remote-write
+	// series, and is not learned from by the latency baseline.
+	Maintenance manifest.ResourceName `form:"maintenance,omitempty" json:"maintenance,omitempty" yaml:"maintenance,omitempty" xml:"maintenance,omitempty"`
+
+	// UpstreamFailed names the dependency whose latest verdict was failing
+	// when this run failed, if any: the run's failure is a consequence of that
+	// one rather than a root cause. Decided when the outcome is reported and
+	// never changed. See ScenarioDependency.
+	UpstreamFailed manifest.ResourceName `form:"upstreamFailed,omitempty" json:"upstreamFailed,omitempty" yaml:"upstreamFailed,omitempty" xml:"upstreamFailed,omitempty"`
+
+	// Delivery is set by a worker replaying a report it could not deliver when
+	// the run finished. It describes the upload rather than the run, and is
+	// never stored: what the server makes of it is recorded in TimeEnded and in
+	// LabelResultDelivery.
+	Delivery *ResultDelivery `form:"delivery,omitempty" json:"delivery,omitempty" yaml:"delivery,omitempty" xml:"delivery,omitempty" gorm:"-"`
+
 	// NumberArtifacts is the number of artifacts associated with this results object
 	NumberArtifacts uint64 `json:"numberArtifacts,omitempty" yaml:"numberArtifacts,omitempty" gorm:"-"`
 
@@ -278,6 +459,19 @@ type ResultStatus struct {
 	Artifacts []Artifact `json:"artifacts,omitempty" yaml:"artifacts,omitempty" gorm:"foreignKey:ResultID"`
 }
 
+// ResultDelivery is what a worker says about a report it held back.
+type ResultDelivery struct {
+	// Spooled says the report was kept on the worker's disk while the API server
+	// was unreachable. Only a spooled report is accepted after its run's upload
+	// grace has passed.
+	Spooled bool `form:"spooled" json:"spooled,omitempty" yaml:"spooled,omitempty" xml:"spooled,omitempty"`
+
+	// FinishedAt is when the worker saw the run finish. The server records it
+	// as the run's end rather than the moment the report arrived, which may be
+	// an outage later.
+	FinishedAt time.Time `form:"finishedAt" json:"finishedAt,omitempty" yaml:"finishedAt,omitempty" xml:"finishedAt,omitempty"`
+}
+
 type ArtifactSpec struct {
 	ResultID manifest.ResourceID `json:"-" yaml:"-"`
 	Result   Result              `json:"-" yaml:"-" gorm:"foreignKey:ResultID;references:UID"`
@@ -349,7 +543,12 @@ func init() {
 	manifest.MustRegisterManifest(KindResult, &ResultSpec{}, &ResultStatus{})
 	manifest.MustRegisterManifest(KindScenario, &ScenarioSpec{}, &ScenarioStatus{})
 	manifest.MustRegisterManifest(KindDispatchFailure, &DispatchFailureSpec{}, &DispatchFailureStatus{})
+	manifest.MustRegisterManifest(KindMaintenanceWindow, &MaintenanceWindowSpec{}, &MaintenanceWindowStatus{})
+	manifest.MustRegisterManifest(KindRateLimit, &RateLimitSpec{}, &RateLimitStatus{})
+	manifest.MustRegisterManifest(KindEnrolmentToken, &EnrolmentTokenSpec{}, &EnrolmentTokenStatus{})
+	manifest.MustRegisterManifest(KindWorkerBlock, &WorkerBlockSpec{}, &WorkerBlockStatus{})
 	manifest.MustRegisterKind(KindArtifact, &ArtifactSpec{})
+	manifest.MustRegisterKind(KindAuditEvent, &AuditEventSpec{})
 }
 
 func NewWorkerInstance(m manifest.ResourceManifest) (WorkerInstance, error) {
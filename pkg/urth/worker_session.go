@@ -128,10 +128,21 @@ type NATSConnectionInfo struct {
 	// pulls from the consumer, not the subject.
 	Subject string `form:"subject" json:"subject" yaml:"subject" xml:"subject"`
 
+	// HighPriorityConsumer names the consumer the runner's high-priority runs
+	// are pulled from, and HighPrioritySubject the subject it filters; see
+	// RunPriority. A worker pulls it ahead of Consumer. Empty from a server
+	// that predates priorities, which has only the one queue.
+	HighPriorityConsumer string `form:"highPriorityConsumer,omitempty" json:"highPriorityConsumer,omitempty" yaml:"highPriorityConsumer,omitempty" xml:"highPriorityConsumer,omitempty"`
+	HighPrioritySubject  string `form:"highPrioritySubject,omitempty" json:"highPrioritySubject,omitempty" yaml:"highPrioritySubject,omitempty" xml:"highPrioritySubject,omitempty"`
+
 	// LogSubjectPrefix is where this worker may publish run logs. Scoped to the
 	// runner so a worker cannot inject lines into another runner's run.
 	LogSubjectPrefix string `form:"logSubjectPrefix,omitempty" json:"logSubjectPrefix,omitempty" yaml:"logSubjectPrefix,omitempty" xml:"logSubjectPrefix,omitempty"`
 
+	// CancelSubjectPrefix is where this worker listens for its runs being
+	// cancelled. Scoped to the runner for the same reason.
+	CancelSubjectPrefix string `form:"cancelSubjectPrefix,omitempty" json:"cancelSubjectPrefix,omitempty" yaml:"cancelSubjectPrefix,omitempty" xml:"cancelSubjectPrefix,omitempty"`
+
 	Credential NATSCredential `form:"credential" json:"credential" yaml:"credential" xml:"credential"`
 }
 
@@ -0,0 +1,288 @@
+package urth
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/sre-norns/wyrd/pkg/dbstore"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// Worker blocks: barring one worker, or one host, for good.
+//
+// Dropping a worker's registration -- WorkersAPI.Delete -- does not keep it out.
+// It still holds its runner's enrolment token and registers again on its next
+// attempt, which is right for a worker that was merely stale and wrong for a
+// host that has been compromised. Before this, barring one meant disabling its
+// runner, which bars every other worker of the runner with it, or rotating the
+// signing secret, which bars every worker of every runner.
+//
+// A block names one worker by one of three things:
+//
+//   - its name, for a worker whose name is stable across restarts.
+//   - its host, as the worker reported it in LabelWorkerHostname, for a machine
+//     that may come back under any name.
+//   - the fingerprint of the enrolment token it registered with. Workers hold no
+//     key of their own; the enrolment token is the one secret a worker presents,
+//     so it is the only identity a worker cannot simply claim. A block by
+//     fingerprint bars every worker holding a copy of that token, including one
+//     too old to have a `jti` to revoke.
+//
+// The first two are what the worker says about itself. They keep out a host
+// that is not trying to get back in -- one rebuilt from a compromised image, a
+// rogue deployment that keeps restarting -- but a host under hostile control can
+// report another name. Against that, revoke the token it holds (see
+// EnrolmentToken) or block its fingerprint.
+//
+// Checked everywhere a worker acts on its own authority: registration, a claim,
+// and a heartbeat. A blocked worker's claims are refused as ClaimForbidden, as a
+// paused worker's are, and its heartbeats as unauthorized. A run it had already
+// claimed is not taken back; its lease ends it, or its result does. See
+// workerBarred.
+
+// KindWorkerBlock is the resource kind for worker blocks.
+const KindWorkerBlock manifest.Kind = "workerBlocks"
+
+// fingerprintPrefix is the scheme of an EnrolmentFingerprint.
+const fingerprintPrefix = "sha256:"
+
+// WorkerBlockSpec names the workers a block bars. Exactly one of WorkerName,
+// Hostname and Fingerprint is set.
+type WorkerBlockSpec struct {
+	// Description is a human readable text to say why the worker is barred.
+	Description string `form:"description" json:"description,omitempty" yaml:"description,omitempty" xml:"description,omitempty"`
+
+	// WorkerName bars the worker registering under this name.
+	WorkerName manifest.ResourceName `form:"workerName" json:"workerName,omitempty" yaml:"workerName,omitempty" xml:"workerName,omitempty"`
+
+	// Hostname bars every worker reporting this host. Matched as the worker
+	// reports it, in LabelWorkerHostname, so case does not matter and a
+	// character a label cannot hold matches the one it is reported as.
+	Hostname string `form:"hostname" json:"hostname,omitempty" yaml:"hostname,omitempty" xml:"hostname,omitempty"`
+
+	// Fingerprint bars every worker that registered with the enrolment token
+	// of this EnrolmentFingerprint.
+	Fingerprint string `form:"fingerprint" json:"fingerprint,omitempty" yaml:"fingerprint,omitempty" xml:"fingerprint,omitempty"`
+}
+
+// WorkerBlockStatus is what a block bars as of the moment it was read. Nothing
+// here is stored.
+type WorkerBlockStatus struct {
+	// Workers are the registered workers the block matches.
+	Workers []manifest.ResourceName `json:"workers,omitempty" yaml:"workers,omitempty" gorm:"-"`
+}
+
+// WorkerBlock bars the workers its spec names from registering and working.
+type WorkerBlock manifest.StatefulResource[WorkerBlockSpec, WorkerBlockStatus]
+
+// ToManifest renders the resource for the API.
+func (r WorkerBlock) ToManifest() manifest.ResourceManifest {
+	return manifest.ToManifestWithStatus(manifest.StatefulResource[WorkerBlockSpec, WorkerBlockStatus](r))
+}
+
+// NewWorkerBlock converts a manifest into the model.
+func NewWorkerBlock(m manifest.ResourceManifest) (WorkerBlock, error) {
+	e, err := manifest.ManifestAsStatefulResource[WorkerBlockSpec, WorkerBlockStatus](m)
+	entry := WorkerBlock(e)
+	if err != nil {
+		return entry, fmt.Errorf("failed to convert resource manifest into a WorkerBlock model: %w", err)
+	}
+
+	if entry.ObjectMeta.Name == "" {
+		return entry, ErrResourceNameEmpty
+	}
+
+	if err := entry.ObjectMeta.Validate(); err != nil {
+		return entry, err
+	}
+
+	return entry, nil
+}
+
+// Validate refuses a block that names no worker, or more than one way.
+//
+// One match per block, rather than fields combined: "this name on this host"
+// reads as narrower than either, but a host under hostile control chooses both,
+// so the combination would bar less than it appears to. Two blocks say what
+// each bars.
+func (s WorkerBlockSpec) Validate() error {
+	set := 0
+	for _, field := range []string{string(s.WorkerName), s.Hostname, s.Fingerprint} {
+		if field != "" {
+			set++
+		}
+	}
+
+	if set != 1 {
+		return fmt.Errorf("a worker block needs exactly one of workerName, hostname or fingerprint, got %d", set)
+	}
+
+	if s.Hostname != "" && LabelSafeValue(s.Hostname) == "" {
+		return fmt.Errorf("worker block hostname %q cannot match any worker", s.Hostname)
+	}
+
+	if s.Fingerprint != "" {
+		digest, ok := strings.CutPrefix(normalizeFingerprint(s.Fingerprint), fingerprintPrefix)
+		if !ok || len(digest) != 64 || strings.Trim(digest, "0123456789abcdef") != "" {
+			return fmt.Errorf("worker block fingerprint %q is not a %s digest of an enrolment token", s.Fingerprint, fingerprintPrefix)
+		}
+	}
+
+	return nil
+}
+
+// Matches reports whether the block bars a worker.
+func (s WorkerBlockSpec) Matches(worker WorkerInstance) bool {
+	switch {
+	case s.WorkerName != "":
+		return worker.Name == s.WorkerName
+	case s.Hostname != "":
+		reported := worker.Labels[LabelWorkerHostname]
+		return reported != "" && strings.EqualFold(reported, LabelSafeValue(s.Hostname))
+	case s.Fingerprint != "":
+		return worker.Status.EnrolmentFingerprint != "" &&
+			worker.Status.EnrolmentFingerprint == normalizeFingerprint(s.Fingerprint)
+	default:
+		return false
+	}
+}
+
+// normalizeFingerprint reads a fingerprint the way EnrolmentFingerprint writes
+// one, so that a digest pasted in capitals still matches.
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.TrimSpace(fingerprint))
+}
+
+// loadWorkerBlocks reads every block, oldest first.
+//
+// Every block is read and judged in memory rather than in a query, as
+// coveringWindow does with maintenance windows: a fleet has a handful of
+// blocks, and the hostname match is not one SQL can make.
+func loadWorkerBlocks(ctx context.Context, store dbstore.TransactionalStore) ([]WorkerBlock, error) {
+	var blocks []WorkerBlock
+	if _, err := store.Find(ctx, &blocks, manifest.SearchQuery{}, dbstore.OrderByCreatedAt(dbstore.OrderAscending)); err != nil {
+		return nil, fmt.Errorf("failed to read worker blocks: %w", err)
+	}
+
+	return blocks, nil
+}
+
+// matchingWorkerBlock finds the block, if any, among blocks that bars a worker.
+func matchingWorkerBlock(blocks []WorkerBlock, worker WorkerInstance) *WorkerBlock {
+	for i := range blocks {
+		if blocks[i].Spec.Matches(worker) {
+			return &blocks[i]
+		}
+	}
+
+	return nil
+}
+
+// workerBarSnapshot is what bars registered workers, as of when it was read:
+// every block, and whether each enrolment token on record is revoked.
+//
+// Held in a policyCache: it is asked of each worker every few seconds, and
+// changes when an operator blocks a host or revokes a token, which is rarely.
+type workerBarSnapshot struct {
+	blocks []WorkerBlock
+
+	// revoked is keyed by token ID. A token missing from it was issued since
+	// the snapshot was read, or is not on record at all, and is looked up.
+	revoked map[string]bool
+}
+
+func loadWorkerBarSnapshot(ctx context.Context, store dbstore.TransactionalStore) (workerBarSnapshot, error) {
+	blocks, err := loadWorkerBlocks(ctx, store)
+	if err != nil {
+		return workerBarSnapshot{}, err
+	}
+
+	// Every record, revoked or not, so that a token that is good needs no
+	// lookup either. The reconciler prunes records once they expire, which keeps
+	// this to the tokens of the last EnrolmentTokenTTL and those still in use.
+	var tokens []EnrolmentToken
+	if _, err := store.Find(ctx, &tokens, manifest.SearchQuery{}); err != nil {
+		return workerBarSnapshot{}, fmt.Errorf("failed to read enrolment tokens: %w", err)
+	}
+
+	revoked := make(map[string]bool, len(tokens))
+	for _, token := range tokens {
+		revoked[string(token.Name)] = token.IsRevoked()
+	}
+
+	return workerBarSnapshot{blocks: blocks, revoked: revoked}, nil
+}
+
+// workerBarred reports why a registered worker may no longer act, or "" if it
+// may.
+//
+// Two things bar a worker after it has registered: a block that matches it, and
+// the revocation of the enrolment token it registered with. Both are checked on
+// every claim and heartbeat, not only at registration, because a registered
+// worker never presents its enrolment token again -- it has a session -- and a
+// barring that only took effect at the next registration would leave the host
+// it was meant for working until its session happened to run out.
+//
+// Judged against the cached workerBarSnapshot. Registration, which is rare and
+// is where a block should bite at once, reads the blocks afresh instead.
+//
+// A worker that registered before tokens were recorded has no token ID, and
+// only a block bars it.
+func workerBarred(ctx context.Context, store dbstore.TransactionalStore, cache *policyCache[workerBarSnapshot], worker WorkerInstance) (string, error) {
+	snapshot, err := cache.get(ctx, store)
+	if err != nil {
+		return "", err
+	}
+
+	if block := matchingWorkerBlock(snapshot.blocks, worker); block != nil {
+		return fmt.Sprintf("worker is barred by block %q", block.Name), nil
+	}
+
+	id := worker.Status.EnrolmentTokenID
+	if id == "" {
+		return "", nil
+	}
+
+	if revoked, known := snapshot.revoked[id]; known {
+		if revoked {
+			return fmt.Sprintf("enrolment token %q was revoked", id), nil
+		}
+		return "", nil
+	}
+
+	var record EnrolmentToken
+	if ok, err := store.GetByName(ctx, &record, manifest.ResourceName(id)); err != nil {
+		return "", fmt.Errorf("failed to read enrolment token %q: %w", id, err)
+	} else if !ok {
+		return fmt.Sprintf("enrolment token %q is not on record", id), nil
+	} else if record.IsRevoked() {
+		return fmt.Sprintf("enrolment token %q was revoked", id), nil
+	}
+
+	return "", nil
+}
+
+// withStatus fills in the registered workers the block bars.
+//
+// A failure to list workers is logged and leaves the list empty rather than
+// failing the read: which workers a block matches is a detail on a page, and
+// the block bars them either way.
+func (r WorkerBlock) withStatus(ctx context.Context, store dbstore.TransactionalStore) WorkerBlock {
+	r.Status = WorkerBlockStatus{}
+
+	var workers []WorkerInstance
+	if _, err := store.Find(ctx, &workers, manifest.SearchQuery{}); err != nil {
+		log.Printf("worker block %q: failed to list workers: %v", r.Name, err)
+		return r
+	}
+
+	for _, worker := range workers {
+		if r.Spec.Matches(worker) {
+			r.Status.Workers = append(r.Status.Workers, worker.Name)
+		}
+	}
+
+	return r
+}
@@ -0,0 +1,102 @@
+package urth
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+	"github.com/stretchr/testify/require"
+)
+
+// A block names a worker exactly one way. None matches nothing, which is a
+// mistake; two would read as narrower than either while barring less than it
+// appears to.
+func TestWorkerBlockNeedsExactlyOneMatch(t *testing.T) {
+	fingerprint := EnrolmentFingerprint("some-token")
+
+	for _, spec := range []WorkerBlockSpec{
+		{WorkerName: "worker-a"},
+		{Hostname: "build-host-7.ci.example.com"},
+		{Fingerprint: fingerprint},
+		{Fingerprint: strings.ToUpper(fingerprint)},
+	} {
+		require.NoError(t, spec.Validate(), "spec %+v", spec)
+	}
+
+	for _, spec := range []WorkerBlockSpec{
+		{},
+		{Description: "nothing to match"},
+		{WorkerName: "worker-a", Hostname: "build-host-7"},
+		{Hostname: "..."},
+		{Fingerprint: "build-host-7"},
+		{Fingerprint: "sha256:abc"},
+		{Fingerprint: "md5:" + strings.TrimPrefix(fingerprint, "sha256:")},
+	} {
+		require.Error(t, spec.Validate(), "spec %+v", spec)
+	}
+}
+
+func TestWorkerBlockMatches(t *testing.T) {
+	worker := WorkerInstance{
+		ObjectMeta: manifest.ObjectMeta{
+			Name:   "worker-a",
+			Labels: manifest.Labels{LabelWorkerHostname: "build-host-7.ci.example.com"},
+		},
+		Status: WorkerInstanceStatus{EnrolmentFingerprint: EnrolmentFingerprint("some-token")},
+	}
+
+	for _, tc := range []struct {
+		name    string
+		spec    WorkerBlockSpec
+		matches bool
+	}{
+		{name: "by name", spec: WorkerBlockSpec{WorkerName: "worker-a"}, matches: true},
+		{name: "another name", spec: WorkerBlockSpec{WorkerName: "worker-b"}},
+		{name: "by hostname", spec: WorkerBlockSpec{Hostname: "build-host-7.ci.example.com"}, matches: true},
+		{name: "hostname in capitals", spec: WorkerBlockSpec{Hostname: "Build-Host-7.CI.example.com"}, matches: true},
+		{name: "another host", spec: WorkerBlockSpec{Hostname: "build-host-8.ci.example.com"}},
+		{name: "by fingerprint", spec: WorkerBlockSpec{Fingerprint: EnrolmentFingerprint("some-token")}, matches: true},
+		{name: "fingerprint in capitals", spec: WorkerBlockSpec{Fingerprint: strings.ToUpper(EnrolmentFingerprint("some-token"))}, matches: true},
+		{name: "another token", spec: WorkerBlockSpec{Fingerprint: EnrolmentFingerprint("other-token")}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			require.Equal(t, tc.matches, tc.spec.Matches(worker))
+		})
+	}
+
+	// A worker that reported no host, or registered before fingerprints were
+	// recorded, is not matched by a block on what it never said.
+	anonymous := WorkerInstance{ObjectMeta: manifest.ObjectMeta{Name: "worker-c"}}
+	require.False(t, WorkerBlockSpec{Hostname: "build-host-7"}.Matches(anonymous))
+	require.False(t, WorkerBlockSpec{Fingerprint: EnrolmentFingerprint("")}.Matches(anonymous))
+}
+
+// The fingerprint is a digest an operator can paste into a block, and never the
+// token itself.
+func TestEnrolmentFingerprintDoesNotDiscloseTheToken(t *testing.T) {
+	fingerprint := EnrolmentFingerprint("some-token")
+
+	require.True(t, strings.HasPrefix(fingerprint, "sha256:"))
+	require.NotContains(t, fingerprint, "some-token")
+	require.Equal(t, fingerprint, EnrolmentFingerprint("some-token"), "the same token, the same fingerprint")
+	require.NotEqual(t, fingerprint, EnrolmentFingerprint("other-token"))
+	require.NoError(t, WorkerBlockSpec{Fingerprint: fingerprint}.Validate())
+}
+
+func TestEnrolmentTokenIDIsReadFromTheJTI(t *testing.T) {
+	withID := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "runner", "jti": "3kq9x0m2v7c1h8ra"})
+	require.Equal(t, "3kq9x0m2v7c1h8ra", enrolmentTokenID(withID))
+
+	legacy := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": "runner"})
+	require.Empty(t, enrolmentTokenID(legacy), "a token issued before tokens had IDs")
+}
+
+// The ID is also the record's name, so it has to be one.
+func TestIssuedTokenIDIsAResourceName(t *testing.T) {
+	id := issuedTokenID()
+
+	require.Len(t, id, 16)
+	require.Equal(t, strings.ToLower(id), id)
+	require.NoError(t, manifest.ObjectMeta{Name: manifest.ResourceName(id)}.Validate())
+}
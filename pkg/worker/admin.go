@@ -0,0 +1,201 @@
+package worker
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// The admin endpoint is for whoever is on the host: the operator debugging a
+// worker behind a firewall the control plane cannot see through, and the
+// systemd or Kubernetes probe deciding whether the process is healthy. It
+// answers what only this process knows -- whether it is registered and
+// connected right now, what it is running and for how long, how its last runs
+// ended -- and lets that operator stop a run or drain the worker without a
+// route to the API server.
+//
+// Opt-in, like /metrics, and for the same reason: this process runs inside the
+// segment it probes, and a listener is the operator's decision. The two
+// endpoints that change anything are further restricted to loopback callers,
+// whatever address the listener is bound to. A probe from the kubelet arrives on
+// the pod's address and only ever reads; a cancel or a drain is somebody on the
+// box, and nobody else should be able to send one.
+
+// readiness is what /readyz reports. Each check is named, so a failing probe
+// says which one failed rather than only that something did.
+type readiness struct {
+	Ready bool `json:"ready"`
+
+	Registered     bool      `json:"registered"`
+	NATSConnected  bool      `json:"natsConnected"`
+	SessionValid   bool      `json:"sessionValid"`
+	Draining       bool      `json:"draining"`
+	SessionExpires time.Time `json:"sessionExpires,omitzero"`
+}
+
+// inFlightRun is one entry of /runs.
+type inFlightRun struct {
+	ResultUID manifest.ResourceID   `json:"resultUID"`
+	Scenario  manifest.ResourceName `json:"scenario,omitempty"`
+	Kind      prob.Kind             `json:"kind,omitempty"`
+
+	// State is `claiming` until the API has authorised the run, and `running`
+	// once the probe has started.
+	State   string    `json:"state"`
+	Since   time.Time `json:"since"`
+	Elapsed string    `json:"elapsed"`
+}
+
+// finishedRun is one entry of /runs/recent.
+type finishedRun struct {
+	ResultUID manifest.ResourceID   `json:"resultUID"`
+	Scenario  manifest.ResourceName `json:"scenario"`
+	Kind      prob.Kind             `json:"kind"`
+	Result    prob.RunStatus        `json:"result"`
+	Started   time.Time             `json:"started"`
+	Duration  string                `json:"duration"`
+}
+
+// recentRuns keeps the last few finished runs, newest last.
+//
+// In memory and lost on restart: it is a view for someone looking at this host
+// now, and the API server keeps the record that matters.
+type recentRuns struct {
+	mu    sync.Mutex
+	limit int
+	runs  []finishedRun
+}
+
+func (r *recentRuns) add(run finishedRun) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.limit <= 0 {
+		return
+	}
+
+	r.runs = append(r.runs, run)
+	if excess := len(r.runs) - r.limit; excess > 0 {
+		r.runs = append(r.runs[:0], r.runs[excess:]...)
+	}
+}
+
+// list returns the kept runs, newest first.
+func (r *recentRuns) list() []finishedRun {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	runs := make([]finishedRun, len(r.runs))
+	for i, run := range r.runs {
+		runs[len(r.runs)-1-i] = run
+	}
+
+	return runs
+}
+
+// readiness evaluates the checks behind /readyz.
+//
+// A draining worker is still ready: it is doing what it was asked to, and a
+// probe that restarted it for that would cut short the very runs the drain is
+// waiting for.
+func (w *Worker) readiness(now time.Time) readiness {
+	w.mu.RLock()
+	state := readiness{
+		Registered:     w.workerMeta.UID != "",
+		SessionValid:   w.session != "" && now.Before(w.sessionUntil),
+		SessionExpires: w.sessionUntil,
+	}
+	w.mu.RUnlock()
+
+	conn := w.conn.Load()
+	state.NATSConnected = conn != nil && conn.IsConnected()
+	state.Draining = w.drain.draining()
+	state.Ready = state.Registered && state.NATSConnected && state.SessionValid
+
+	return state
+}
+
+// adminHandler routes the admin endpoint.
+func (w *Worker) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	// Liveness is the process answering at all. Anything more belongs in
+	// readiness: a liveness probe that failed on a lost connection would have
+	// the worker restarted in the middle of reconnecting.
+	mux.HandleFunc("GET /healthz", func(rw http.ResponseWriter, _ *http.Request) {
+		writeJSON(rw, http.StatusOK, map[string]string{"status": "ok"})
+	})
+
+	mux.HandleFunc("GET /readyz", func(rw http.ResponseWriter, _ *http.Request) {
+		state := w.readiness(time.Now())
+
+		status := http.StatusOK
+		if !state.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(rw, status, state)
+	})
+
+	mux.HandleFunc("GET /runs", func(rw http.ResponseWriter, _ *http.Request) {
+		writeJSON(rw, http.StatusOK, w.inFlight.snapshot(time.Now()))
+	})
+
+	mux.HandleFunc("GET /runs/recent", func(rw http.ResponseWriter, _ *http.Request) {
+		writeJSON(rw, http.StatusOK, w.recent.list())
+	})
+
+	mux.HandleFunc("GET /labels", func(rw http.ResponseWriter, _ *http.Request) {
+		writeJSON(rw, http.StatusOK, w.effectiveLabels())
+	})
+
+	mux.Handle("POST /runs/{uid}/cancel", loopbackOnly(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		uid := manifest.ResourceID(r.PathValue("uid"))
+
+		switch found, started := w.inFlight.cancel(uid); {
+		case !found:
+			writeJSON(rw, http.StatusNotFound, map[string]string{"error": "this worker is not running " + string(uid)})
+		case !started:
+			// Still being claimed: there is no probe to stop, and the claim
+			// resolves in seconds. Asking again then is simpler than a cancel
+			// that waits for a run to exist.
+			writeJSON(rw, http.StatusConflict, map[string]string{"error": "run " + string(uid) + " is still being claimed; try again"})
+		default:
+			writeJSON(rw, http.StatusAccepted, map[string]string{"status": "cancelling"})
+		}
+	})))
+
+	mux.Handle("POST /drain", loopbackOnly(http.HandlerFunc(func(rw http.ResponseWriter, _ *http.Request) {
+		w.requestDrain()
+		writeJSON(rw, http.StatusAccepted, map[string]string{"status": "draining"})
+	})))
+
+	return mux
+}
+
+// loopbackOnly refuses requests that did not come from this host.
+func loopbackOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if ip := net.ParseIP(host); err != nil || ip == nil || !ip.IsLoopback() {
+			writeJSON(rw, http.StatusForbidden, map[string]string{"error": "only accepted from this host"})
+			return
+		}
+
+		next.ServeHTTP(rw, r)
+	})
+}
+
+func writeJSON(rw http.ResponseWriter, status int, body any) {
+	rw.Header().Set("Content-Type", "application/json")
+	rw.WriteHeader(status)
+
+	if err := json.NewEncoder(rw).Encode(body); err != nil {
+		log.Printf("admin endpoint: failed to write a response: %v", err)
+	}
+}
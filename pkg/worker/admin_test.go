@@ -0,0 +1,172 @@
+package worker
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/sre-norns/urth/pkg/prob"
+)
+
+// adminRequest sends one request to the worker's admin handler. from is the
+// caller's address, which decides whether a mutating request is accepted.
+func adminRequest(w *Worker, method, path, from string) *httptest.ResponseRecorder {
+	request := httptest.NewRequest(method, path, nil)
+	request.RemoteAddr = from
+
+	recorder := httptest.NewRecorder()
+	w.adminHandler().ServeHTTP(recorder, request)
+
+	return recorder
+}
+
+const (
+	fromHost   = "127.0.0.1:40000"
+	fromRemote = "10.0.0.7:40000"
+)
+
+// Readiness names what it checked, so a failing probe says what is missing. A
+// worker that has not registered is alive but not ready.
+func TestReadinessNamesItsChecks(t *testing.T) {
+	w := newTestWorker(nil)
+
+	if got := adminRequest(w, http.MethodGet, "/healthz", fromRemote).Code; got != http.StatusOK {
+		t.Errorf("liveness = %d, want 200 for a process that answers", got)
+	}
+
+	response := adminRequest(w, http.MethodGet, "/readyz", fromRemote)
+	if response.Code != http.StatusServiceUnavailable {
+		t.Fatalf("readiness before registering = %d, want 503", response.Code)
+	}
+
+	var state readiness
+	if err := json.NewDecoder(response.Body).Decode(&state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Ready || state.Registered || state.SessionValid || state.NATSConnected {
+		t.Errorf("an unregistered worker reported %+v", state)
+	}
+
+	w.workerMeta.UID = "worker-1"
+	w.session = "session"
+	w.sessionUntil = time.Now().Add(time.Hour)
+
+	state = w.readiness(time.Now())
+	if !state.Registered || !state.SessionValid || state.NATSConnected || state.Ready {
+		t.Errorf("a registered worker with no NATS connection reported %+v", state)
+	}
+
+	state = w.readiness(time.Now().Add(2 * time.Hour))
+	if state.SessionValid {
+		t.Error("an expired session was reported valid")
+	}
+}
+
+// The admin endpoint answers from the moment the worker starts, while Run is
+// still dialling NATS on another goroutine. Meaningful under -race.
+func TestReadinessWhileConnecting(t *testing.T) {
+	w := newTestWorker(nil)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		w.conn.Store(&nats.Conn{})
+	}()
+
+	for range 100 {
+		_ = w.readiness(time.Now())
+	}
+	<-done
+
+	if w.readiness(time.Now()).NATSConnected {
+		t.Error("a connection that never connected was reported connected")
+	}
+}
+
+// In-flight runs are listed with how long they have been going, and a running
+// one can be cancelled from the host -- and only from the host.
+func TestAdminListsAndCancelsInFlightRuns(t *testing.T) {
+	w := newTestWorker(nil)
+
+	w.inFlight.acquire("claiming-run")
+	w.inFlight.acquire("running-run")
+
+	var cancelled int
+	w.inFlight.start("running-run", "a-scenario", "http", func() { cancelled++ })
+
+	response := adminRequest(w, http.MethodGet, "/runs", fromRemote)
+	var runs []inFlightRun
+	if err := json.NewDecoder(response.Body).Decode(&runs); err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 {
+		t.Fatalf("listed %d runs, want 2: %+v", len(runs), runs)
+	}
+	for _, run := range runs {
+		switch run.ResultUID {
+		case "claiming-run":
+			if run.State != "claiming" || run.Kind != "" {
+				t.Errorf("a run being claimed was listed as %+v", run)
+			}
+		case "running-run":
+			if run.State != "running" || run.Scenario != "a-scenario" || run.Kind != "http" || run.Elapsed == "" {
+				t.Errorf("a running run was listed as %+v", run)
+			}
+		}
+	}
+
+	if got := adminRequest(w, http.MethodPost, "/runs/running-run/cancel", fromRemote).Code; got != http.StatusForbidden {
+		t.Errorf("a cancel from another host = %d, want 403", got)
+	}
+	if cancelled != 0 {
+		t.Fatal("a refused cancel stopped the run")
+	}
+
+	if got := adminRequest(w, http.MethodPost, "/runs/running-run/cancel", fromHost).Code; got != http.StatusAccepted {
+		t.Errorf("a cancel from the host = %d, want 202", got)
+	}
+	if cancelled != 1 {
+		t.Errorf("the run was stopped %d times, want once", cancelled)
+	}
+
+	if got := adminRequest(w, http.MethodPost, "/runs/claiming-run/cancel", fromHost).Code; got != http.StatusConflict {
+		t.Errorf("a cancel of a run still being claimed = %d, want 409", got)
+	}
+	if got := adminRequest(w, http.MethodPost, "/runs/unknown-run/cancel", fromHost).Code; got != http.StatusNotFound {
+		t.Errorf("a cancel of a run this worker does not hold = %d, want 404", got)
+	}
+}
+
+func TestAdminDrainsFromTheHostOnly(t *testing.T) {
+	w := newTestWorker(nil)
+
+	if got := adminRequest(w, http.MethodPost, "/drain", fromRemote).Code; got != http.StatusForbidden {
+		t.Errorf("a drain from another host = %d, want 403", got)
+	}
+	if w.drain.draining() {
+		t.Fatal("a refused drain started one")
+	}
+
+	if got := adminRequest(w, http.MethodPost, "/drain", "[::1]:40000").Code; got != http.StatusAccepted {
+		t.Errorf("a drain from the host = %d, want 202", got)
+	}
+	if !w.drain.draining() {
+		t.Error("a drain from the host did not start one")
+	}
+}
+
+func TestRecentRunsKeepsTheNewest(t *testing.T) {
+	recent := recentRuns{limit: 2}
+	for _, result := range []prob.RunStatus{prob.RunFinishedSuccess, prob.RunFinishedFailed, prob.RunFinishedCanceled} {
+		recent.add(finishedRun{Result: result})
+	}
+
+	runs := recent.list()
+	if len(runs) != 2 || runs[0].Result != prob.RunFinishedCanceled || runs[1].Result != prob.RunFinishedFailed {
+		t.Errorf("kept %+v, want the two newest, newest first", runs)
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"github.com/nats-io/nats.go/jetstream"
 
 	"github.com/sre-norns/urth/pkg/natsq"
+	"github.com/sre-norns/urth/pkg/prob"
 	"github.com/sre-norns/urth/pkg/urth"
 	"github.com/sre-norns/wyrd/pkg/manifest"
 )
@@ -47,15 +48,24 @@ const (
 	// answered. That is not a verdict on the run, so leave the message
 	// unacknowledged and let the broker redeliver it after AckWait.
 	claimAbandon
+
+	// claimThrottled: the run may not start yet (429). The server has already
+	// re-queued it under a new version, to come back after a growing delay, so
+	// the message in hand describes a superseded dispatch: acknowledge it.
+	// Handing it back instead would spend one of the consumer's MaxDeliver on
+	// every deferral, and dead-letter a run that only had to wait.
+	claimThrottled
 )
 
-// consume pulls jobs and executes them, up to the configured concurrency.
-func (w *Worker) consume(ctx context.Context, consumer jetstream.Consumer) error {
+// consume pulls jobs and executes them, up to the configured concurrency, until
+// ctx ends or the worker is drained.
+func (w *Worker) consume(ctx context.Context, queues *jobQueues) error {
 	// The whole claim handshake has to fit inside the consumer's AckWait, and the
 	// consumer is the only place a worker can learn what that is -- it has the
 	// client half of the NATS configuration (URL and credentials) and never the
-	// stream settings, which are the control plane's to set.
-	w.budgetHandshake(consumer)
+	// stream settings, which are the control plane's to set. Both of a runner's
+	// consumers are provisioned with the same one.
+	w.budgetHandshake(queues.normal)
 
 	// The semaphore is the backpressure ADR 0004 asks for: the worker fetches
 	// only as much as it can currently execute, rather than reserving jobs it
@@ -64,24 +74,105 @@ func (w *Worker) consume(ctx context.Context, consumer jetstream.Consumer) error
 	slots := make(chan struct{}, w.config.Concurrency)
 	var inFlight sync.WaitGroup
 
-	log.Printf("consuming jobs, concurrency %d", w.config.Concurrency)
+	log.Printf("consuming jobs, concurrency %d, per kind %v", w.config.Concurrency, w.config.KindConcurrency)
 
 	for {
+		// Checked before reserving a slot as well as while waiting for one:
+		// with a slot free both cases below are ready, and select would
+		// otherwise go on fetching half the time.
+		if w.drain.draining() {
+			log.Print("draining; waiting for in-flight runs to finish")
+			inFlight.Wait()
+			return nil
+		}
+
+		// A worker that can run none of its kinds takes nothing off the queue
+		// until one recovers. Every run it fetched would only be claimed to be
+		// deferred, and each is a run another worker could have started now.
+		if w.capabilities.allFailing() {
+			select {
+			case <-ctx.Done():
+				log.Print("shutdown requested; waiting for in-flight runs to finish")
+				inFlight.Wait()
+				return nil
+			case <-w.drain.requested():
+			case <-time.After(fetchMaxWait):
+			}
+
+			continue
+		}
+
 		select {
 		case <-ctx.Done():
 			log.Print("shutdown requested; waiting for in-flight runs to finish")
 			inFlight.Wait()
 			return nil
+		case <-w.drain.requested():
+			continue
 		case slots <- struct{}{}:
 		}
 
-		if !w.pump(ctx, consumer, slots, &inFlight) {
+		if !w.pump(ctx, queues, slots, &inFlight) {
 			inFlight.Wait()
 			return nil
 		}
 	}
 }
 
+// jobQueues is the pair of consumers a worker pulls its runner's jobs from.
+//
+// Runs a person is waiting on -- manual runs and retries, unless their scenario
+// says otherwise -- are dispatched to the high-priority consumer; scheduled load
+// to the normal one. See urth.RunPriority.
+type jobQueues struct {
+	normal jetstream.Consumer
+
+	// high is nil against a server that predates priorities, which dispatches
+	// everything to normal. The worker then pulls exactly as it used to.
+	high jetstream.Consumer
+
+	// pulls counts the passes made over both queues, to reserve every
+	// normalPullEvery-th one for normal. Only the consume loop touches it.
+	pulls uint64
+}
+
+// normalPullEvery is how often the normal queue is asked first.
+//
+// Strict priority would be simpler and is the wrong answer: an incident is
+// exactly when manual runs arrive faster than a runner can execute them, and a
+// queue that served only those would stop every scheduled run -- the ones that
+// say whether the incident is over -- for as long as someone keeps clicking.
+// One pull in four keeps scheduled load draining at a quarter of the runner's
+// capacity at worst, while a manual run waits behind at most a few scheduled
+// ones rather than all of them.
+const normalPullEvery = 4
+
+// ordered returns the queues in the order this pass asks them.
+func (q *jobQueues) ordered() []jetstream.Consumer {
+	q.pulls++
+	if q.pulls%normalPullEvery == 0 {
+		return []jetstream.Consumer{q.normal, q.high}
+	}
+
+	return []jetstream.Consumer{q.high, q.normal}
+}
+
+// parked is the queue a worker with nothing to do waits on.
+//
+// The high one, when there is one. A long pull returns the moment its own
+// consumer has a message and not before, so the queue it is parked on is the
+// one whose runs start without delay on an idle runner. A scheduled run arriving
+// meanwhile waits at most one pull window, fetchMaxWait, which nobody watching
+// a schedule will notice; a manual run waiting as long would be the delay this
+// split exists to remove.
+func (q *jobQueues) parked() jetstream.Consumer {
+	if q.high != nil {
+		return q.high
+	}
+
+	return q.normal
+}
+
 // Pull timings.
 //
 // fetchMaxWait is how long one pull waits for work before it is reissued;
@@ -100,6 +191,12 @@ const (
 // The caller has already reserved a slot for the message this fetch may return;
 // pump releases it again on every path that does not start a handler.
 //
+// With two queues, pump first asks each without waiting, in the order
+// jobQueues.ordered gives, and takes the first job either has. Only when both
+// are empty does it park a long pull, on jobQueues.parked. Asking without
+// waiting is what makes the order mean anything: a long pull on one queue would
+// sit out its window while the other had work.
+//
 // Both bounds on the fetch are load-bearing, and neither was there before.
 //
 // The *context* is what makes a shutdown prompt: a pull is a request-reply, and
@@ -114,14 +211,33 @@ const (
 // consumes nothing at all -- which is the worst shape a failure can take here,
 // because every other signal says the fleet is healthy. Found by
 // test/integration, where a broker restart mid-suite reproduced it.
-func (w *Worker) pump(ctx context.Context, consumer jetstream.Consumer, slots chan struct{}, inFlight *sync.WaitGroup) bool {
+func (w *Worker) pump(ctx context.Context, queues *jobQueues, slots chan struct{}, inFlight *sync.WaitGroup) bool {
+	if queues.high != nil {
+		for _, consumer := range queues.ordered() {
+			batch, err := consumer.FetchNoWait(1)
+			if err != nil {
+				// Left to the long pull below, which meets the same broker and
+				// reports and backs off from its failures in one place.
+				break
+			}
+
+			received, carryOn := w.start(ctx, batch, slots, inFlight)
+			if !carryOn {
+				return false
+			}
+			if received {
+				return true
+			}
+		}
+	}
+
 	// Cancelled only once the batch has been drained: the batch is filled by a
 	// goroutine watching this context, so cancelling it early would discard
 	// messages the broker has already handed over.
 	fetchCtx, cancelFetch := context.WithTimeout(ctx, fetchMaxWait)
 	defer cancelFetch()
 
-	batch, err := consumer.Fetch(1,
+	batch, err := queues.parked().Fetch(1,
 		jetstream.FetchContext(fetchCtx),
 		jetstream.FetchHeartbeat(fetchHeartbeat),
 	)
@@ -146,7 +262,25 @@ func (w *Worker) pump(ctx context.Context, consumer jetstream.Consumer, slots ch
 		return true
 	}
 
-	var received bool
+	received, carryOn := w.start(ctx, batch, slots, inFlight)
+	if !carryOn {
+		return false
+	}
+
+	if !received {
+		// Nothing waiting; release the slot we reserved for it.
+		<-slots
+	}
+
+	return true
+}
+
+// start hands each message of a batch to a handler, reporting whether there
+// was one and whether the consume loop should carry on.
+//
+// The first message takes the slot the caller reserved; when there is none, the
+// slot is still the caller's to release.
+func (w *Worker) start(ctx context.Context, batch jetstream.MessageBatch, slots chan struct{}, inFlight *sync.WaitGroup) (received, carryOn bool) {
 	for msg := range batch.Messages() {
 		if received {
 			// Only the first message uses the slot reserved before the fetch.
@@ -158,7 +292,7 @@ func (w *Worker) pump(ctx context.Context, consumer jetstream.Consumer, slots ch
 			// and resolves as soon as a running handler finishes.
 			select {
 			case <-ctx.Done():
-				return false
+				return received, false
 			case slots <- struct{}{}:
 			}
 		}
@@ -180,12 +314,7 @@ func (w *Worker) pump(ctx context.Context, consumer jetstream.Consumer, slots ch
 		log.Printf("job batch ended with error: %v", err)
 	}
 
-	if !received {
-		// Nothing waiting; release the slot we reserved for it.
-		<-slots
-	}
-
-	return true
+	return received, true
 }
 
 // budgetHandshake divides the consumer's AckWait between claim and ack.
@@ -228,6 +357,17 @@ func (w *Worker) handle(ctx context.Context, msg jetstream.Msg) {
 		return
 	}
 
+	// A message fetched as the drain began is handed back at once rather than
+	// claimed: this worker is leaving, and another can start it now instead of
+	// after AckWait.
+	if w.drain.draining() {
+		if err := msg.Nak(); err != nil {
+			log.Printf("failed to hand back job %v while draining: %v", envelope.ResultUID, err)
+		}
+
+		return
+	}
+
 	// Claimed locally before the API is asked, because the duplicate this guards
 	// against is a redelivery arriving while the first claim is still in flight.
 	// See inFlightRuns.
@@ -251,12 +391,49 @@ func (w *Worker) handle(ctx context.Context, msg jetstream.Msg) {
 	}
 	defer w.inFlight.release(envelope.ResultUID)
 
-	auth, outcome := w.claim(ctx, envelope)
+	// A kind this host cannot run right now is still claimed, with the claim
+	// naming the kinds failing here, and the server defers the run for a worker
+	// that can take it. Handing the message back instead would spend one of its
+	// deliveries each time, and a run that met a few such workers in a row was
+	// dead-lettered at MaxDeliver. Placement stops counting this worker for the
+	// kind once its heartbeat reports the failure; this covers the runs already
+	// queued, and the interval before that. See selftest.go.
+	if w.capabilities.failing(envelope.ProbKind) {
+		w.metrics.kindUnavailable(envelope.ProbKind)
+		log.Printf("asking for job %v to be deferred: prob kind %q is failing its self-test here", envelope.ResultUID, envelope.ProbKind)
+	}
+
+	// After the duplicate guard, not before it: a redelivery of a run this
+	// process is executing would find that run's own slot taken, and be
+	// deferred for no reason. A kind with no slot free is claimed naming it,
+	// and deferred by the server like a failing one. See kindslots.go.
+	var busyKinds []prob.Kind
+	if w.kindSlots.acquire(envelope.ProbKind, w.config.kindLimit(envelope.ProbKind)) {
+		defer w.kindSlots.release(envelope.ProbKind)
+	} else {
+		w.metrics.kindBusy(envelope.ProbKind)
+		log.Printf("asking for job %v to be deferred: every %q slot here is taken", envelope.ResultUID, envelope.ProbKind)
+		busyKinds = []prob.Kind{envelope.ProbKind}
+	}
+
+	auth, outcome := w.claim(ctx, envelope, busyKinds)
 	w.metrics.claimed(outcome)
 
-	if applyDisposition(ctx, msg, outcome, envelope.ResultUID, report, w.confirmClaimAck) {
-		w.runJob(ctx, envelope, auth)
+	if !applyDisposition(ctx, msg, outcome, envelope.ResultUID, report, w.confirmClaimAck) {
+		return
+	}
+
+	// Granted regardless, by an API server that predates BusyKinds. The claim
+	// has committed and the message is acknowledged, so declining now would
+	// only leave the run to the reconciler; it runs over the kind's limit, and
+	// is counted, so the slot it takes is given back like any other.
+	if len(busyKinds) > 0 {
+		log.Printf("job %v was granted with every %q slot taken; running it over the limit", envelope.ResultUID, envelope.ProbKind)
+		w.kindSlots.acquire(envelope.ProbKind, 0)
+		defer w.kindSlots.release(envelope.ProbKind)
 	}
+
+	w.runJob(ctx, envelope, auth)
 }
 
 // confirmClaimAck is the worker's ackConfirmer, bound to its handshake budget.
@@ -320,6 +497,14 @@ func applyDisposition(ctx context.Context, msg jetstream.Msg, outcome claimOutco
 			log.Printf("failed to nak job %v: %v", resultUID, err)
 		}
 
+	case claimThrottled:
+		// Not a failure, and not this message's to retry: the server re-queued
+		// the run when it deferred it. See urth.ClaimThrottled.
+		log.Printf("job %v deferred by the server; it will be dispatched again", resultUID)
+		if err := msg.Ack(); err != nil {
+			log.Printf("failed to ack deferred job %v: %v", resultUID, err)
+		}
+
 	case claimStale:
 		log.Printf("discarding stale job message for result %v", resultUID)
 		if err := msg.Ack(); err != nil {
@@ -345,8 +530,9 @@ func applyDisposition(ctx context.Context, msg jetstream.Msg, outcome claimOutco
 	return false
 }
 
-// claim asks the API server for authority to run the job.
-func (w *Worker) claim(ctx context.Context, envelope natsq.DispatchEnvelope) (urth.AuthJobResponse, claimOutcome) {
+// claim asks the API server for authority to run the job, naming the kinds this
+// worker has no slot free for.
+func (w *Worker) claim(ctx context.Context, envelope natsq.DispatchEnvelope, busyKinds []prob.Kind) (urth.AuthJobResponse, claimOutcome) {
 	// Bounded by this worker's share of the handshake budget rather than by a
 	// number of its own, so that a claim which runs long enough to make its
 	// acknowledgement meaningless is abandoned instead. See handshakeBudget.
@@ -365,7 +551,9 @@ func (w *Worker) claim(ctx context.Context, envelope natsq.DispatchEnvelope) (ur
 			DispatchID:    envelope.DispatchID,
 			ResultVersion: envelope.ResultVersion,
 			Timeout:       w.config.RunnerConfig.Timeout,
-			Labels:        w.config.GetEffectiveLabels(),
+			Labels:        w.effectiveLabels(),
+			FailingKinds:  w.capabilities.failingKinds(),
+			BusyKinds:     busyKinds,
 		})
 	if err == nil {
 		return auth, claimAccepted
@@ -402,6 +590,10 @@ func classifyClaimFailure(err error) claimOutcome {
 		// elsewhere. Drop the message.
 		return claimStale
 
+	case http.StatusTooManyRequests:
+		// The run may not start yet. Wait for the limit, not for the server.
+		return claimThrottled
+
 	case http.StatusForbidden, http.StatusUnauthorized,
 		http.StatusBadRequest, http.StatusNotFound:
 		// A refusal redelivery to this worker will not reverse, or a message
@@ -424,6 +616,8 @@ func outcomeName(o claimOutcome) string {
 		return "terminal"
 	case claimAbandon:
 		return "abandon"
+	case claimThrottled:
+		return "throttled"
 	default:
 		return "unknown"
 	}
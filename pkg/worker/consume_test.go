@@ -150,6 +150,7 @@ func TestApplyDisposition(t *testing.T) {
 		{name: "stale acks and drops", outcome: claimStale, wantAck: 1},
 		{name: "terminal terminates", outcome: claimTerminal, wantTerm: true},
 		{name: "abandon leaves the message untouched", outcome: claimAbandon},
+		{name: "throttled acks the superseded dispatch", outcome: claimThrottled, wantAck: 1},
 	}
 
 	for _, tc := range cases {
@@ -236,6 +237,26 @@ func TestApplyDispositionRetryDelays(t *testing.T) {
 	}
 }
 
+// However many times a run is throttled, none of its deliveries is handed
+// back: the server re-queued it each time, and a message naked once per
+// deferral would reach the consumer's MaxDeliver and be dead-lettered while its
+// run was still waiting its turn.
+func TestThrottledClaimsNeverSpendDeliveries(t *testing.T) {
+	const maxDeliver = 5
+
+	for delivery := range maxDeliver * 3 {
+		msg := &fakeMsg{}
+		applyDisposition(context.Background(), msg, claimThrottled, manifest.ResourceID("run-1"), reportedOK(nil), confirmingAck)
+
+		if msg.naked || msg.termed {
+			t.Fatalf("deferral %d handed the message back (naked %v, termed %v)", delivery+1, msg.naked, msg.termed)
+		}
+		if plain, _ := msg.acks(); plain != 1 {
+			t.Fatalf("deferral %d: Ack calls = %v, want 1", delivery+1, plain)
+		}
+	}
+}
+
 // TestPermanentRefusalIsReportedBeforeTermination proves the ordering the
 // dead-letter path depends on: the failure is recorded, and only then is the
 // message removed. A terminated message is not redelivered, so a report made
@@ -316,6 +337,7 @@ func TestClassifyClaimFailure(t *testing.T) {
 		{"service unavailable retries", apiError(http.StatusServiceUnavailable), claimRetry},
 		{"internal error retries", apiError(http.StatusInternalServerError), claimRetry},
 		{"conflict is stale", apiError(http.StatusConflict), claimStale},
+		{"too many requests is throttled", apiError(http.StatusTooManyRequests), claimThrottled},
 		{"forbidden is terminal", apiError(http.StatusForbidden), claimTerminal},
 		{"unauthorized is terminal", apiError(http.StatusUnauthorized), claimTerminal},
 		{"bad request is terminal", apiError(http.StatusBadRequest), claimTerminal},
@@ -358,12 +380,18 @@ type stubResults struct {
 	// onClaim, when set, runs as the claim commits, so a test can place the claim
 	// in an ordering against the acknowledgement and the probe.
 	onClaim func()
+
+	// claimed, when set, receives every claim request.
+	claimed *[]urth.ClaimJobRequest
 }
 
-func (s stubResults) ClaimRun(context.Context, manifest.ResourceID, urth.APIToken, urth.ClaimJobRequest) (urth.AuthJobResponse, error) {
+func (s stubResults) ClaimRun(_ context.Context, _ manifest.ResourceID, _ urth.APIToken, request urth.ClaimJobRequest) (urth.AuthJobResponse, error) {
 	if s.onClaim != nil {
 		s.onClaim()
 	}
+	if s.claimed != nil {
+		*s.claimed = append(*s.claimed, request)
+	}
 
 	return s.auth, s.err
 }
@@ -385,7 +413,7 @@ func TestClaimAbandonsOnShutdown(t *testing.T) {
 	cancel() // simulate shutdown before the claim resolves
 
 	w := newTestWorker(apiError(http.StatusServiceUnavailable))
-	_, outcome := w.claim(ctx, natsq.DispatchEnvelope{ResultUID: "run-1"})
+	_, outcome := w.claim(ctx, natsq.DispatchEnvelope{ResultUID: "run-1"}, nil)
 
 	if outcome != claimAbandon {
 		t.Fatalf("claim during shutdown = %s, want abandon", outcomeName(outcome))
@@ -397,7 +425,7 @@ func TestClaimAbandonsOnShutdown(t *testing.T) {
 // stale drop, not a retry.
 func TestClaimClassifiesLiveFailure(t *testing.T) {
 	w := newTestWorker(apiError(http.StatusConflict))
-	_, outcome := w.claim(context.Background(), natsq.DispatchEnvelope{ResultUID: "run-1"})
+	_, outcome := w.claim(context.Background(), natsq.DispatchEnvelope{ResultUID: "run-1"}, nil)
 
 	if outcome != claimStale {
 		t.Fatalf("live 409 claim = %s, want stale", outcomeName(outcome))
@@ -540,3 +568,50 @@ func TestHandleDeduplicatesConcurrentRedelivery(t *testing.T) {
 		t.Error("ownership was not released after the run finished")
 	}
 }
+
+// namedConsumer stands in for a bound consumer where only its identity matters.
+type namedConsumer struct {
+	jetstream.Consumer
+	name string
+}
+
+// High first, but not always: one pass in normalPullEvery asks the normal
+// queue first, so a flood of manual runs slows scheduled load rather than
+// stopping it.
+func TestJobQueuesAskHighFirstButReserveAShareForNormal(t *testing.T) {
+	normal := &namedConsumer{name: "normal"}
+	high := &namedConsumer{name: "high"}
+	queues := &jobQueues{normal: normal, high: high}
+
+	const passes = normalPullEvery * 3
+
+	var normalFirst int
+	for range passes {
+		order := queues.ordered()
+		if len(order) != 2 {
+			t.Fatalf("a pass asks %d queues, want both", len(order))
+		}
+		if order[0] == jetstream.Consumer(normal) {
+			normalFirst++
+		}
+	}
+
+	if normalFirst != passes/normalPullEvery {
+		t.Errorf("normal asked first in %d of %d passes, want %d", normalFirst, passes, passes/normalPullEvery)
+	}
+
+	if queues.parked() != jetstream.Consumer(high) {
+		t.Error("an idle worker should park its long pull on the high-priority queue")
+	}
+}
+
+// Against a server from before priorities there is one queue, and the worker
+// pulls it exactly as it always did.
+func TestJobQueuesWithoutAHighQueueParkOnNormal(t *testing.T) {
+	normal := &namedConsumer{name: "normal"}
+	queues := &jobQueues{normal: normal}
+
+	if queues.parked() != jetstream.Consumer(normal) {
+		t.Error("without a high-priority queue the worker should pull the normal one")
+	}
+}
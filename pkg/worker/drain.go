@@ -0,0 +1,69 @@
+package worker
+
+import (
+	"log"
+	"sync"
+)
+
+// A drain is an operator asking this worker to leave without cutting anything
+// short: stop pulling from the queue, let the runs already held finish and
+// report, say it is leaving, and exit cleanly. It is how a host is emptied
+// before maintenance.
+//
+// The request arrives on a heartbeat response, so it is noticed within one
+// reporting interval. It is one-way for the life of the process: withdrawing
+// the drain on the server stops the worker being told, but a process that has
+// begun to drain finishes doing so, and it is the next process that comes back
+// to work.
+
+// drainSignal is closed once a drain has been requested.
+type drainSignal struct {
+	once sync.Once
+	mu   sync.Mutex
+	ch   chan struct{}
+}
+
+// requested is closed once the drain has been requested. Made on first use, so a
+// worker literal in a test needs no constructor.
+func (d *drainSignal) requested() <-chan struct{} {
+	return d.channel()
+}
+
+func (d *drainSignal) channel() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.ch == nil {
+		d.ch = make(chan struct{})
+	}
+
+	return d.ch
+}
+
+// request starts the drain, reporting whether this call was the one that did.
+func (d *drainSignal) request() (started bool) {
+	d.once.Do(func() {
+		close(d.channel())
+		started = true
+	})
+
+	return started
+}
+
+// draining reports whether a drain has been requested.
+func (d *drainSignal) draining() bool {
+	select {
+	case <-d.requested():
+		return true
+	default:
+		return false
+	}
+}
+
+// requestDrain starts draining this worker, logging the first time only: every
+// heartbeat until the worker leaves repeats the request.
+func (w *Worker) requestDrain() {
+	if w.drain.request() {
+		log.Print("drain requested by an operator: taking no more work, finishing in-flight runs, then leaving")
+	}
+}
@@ -0,0 +1,84 @@
+package worker
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sre-norns/urth/pkg/natsq"
+	"github.com/sre-norns/urth/pkg/urth"
+)
+
+// drainingService answers heartbeats with a canned response, on top of the claim
+// stubs the rest of the worker tests use.
+type drainingService struct {
+	stubService
+	workers urth.WorkersAPI
+}
+
+func (s drainingService) Workers() urth.WorkersAPI { return s.workers }
+
+type stubWorkers struct {
+	urth.WorkersAPI
+	response urth.WorkerHeartbeatResponse
+}
+
+func (s stubWorkers) Heartbeat(context.Context, urth.APIToken, urth.WorkerHeartbeatRequest) (urth.WorkerHeartbeatResponse, error) {
+	return s.response, nil
+}
+
+func TestHeartbeatStartsADrain(t *testing.T) {
+	w := newTestWorker(nil)
+	w.apiClient = drainingService{workers: stubWorkers{response: urth.WorkerHeartbeatResponse{Interval: time.Minute}}}
+
+	w.heartbeat(context.Background(), time.Minute, false)
+	if w.drain.draining() {
+		t.Fatal("a heartbeat that did not ask for a drain started one")
+	}
+
+	w.apiClient = drainingService{workers: stubWorkers{response: urth.WorkerHeartbeatResponse{Interval: time.Minute, Drain: true}}}
+
+	// The farewell is answered like any other heartbeat, but a worker already
+	// leaving has nothing to drain.
+	w.heartbeat(context.Background(), time.Minute, true)
+	if w.drain.draining() {
+		t.Fatal("the leaving heartbeat started a drain")
+	}
+
+	w.heartbeat(context.Background(), time.Minute, false)
+	select {
+	case <-w.drain.requested():
+	default:
+		t.Fatal("a heartbeat asking for a drain did not start one")
+	}
+
+	// Repeated on every heartbeat until the worker leaves; it must not close
+	// the signal twice.
+	w.heartbeat(context.Background(), time.Minute, false)
+}
+
+// A message fetched as the drain began goes straight back to the queue: this
+// worker is leaving, and claiming the run would hold it here until it finished.
+func TestHandleHandsBackWorkWhileDraining(t *testing.T) {
+	w := newTestWorker(nil)
+	w.runnerUID = testRunnerUID
+
+	var claimed, executed bool
+	w.apiClient = stubService{results: stubResults{onClaim: func() { claimed = true }}}
+	w.executeJob = func(context.Context, natsq.DispatchEnvelope, urth.AuthJobResponse) { executed = true }
+
+	w.requestDrain()
+
+	msg := &fakeMsg{data: testEnvelope("run-1")}
+	w.handle(context.Background(), msg)
+
+	if claimed || executed {
+		t.Errorf("a draining worker claimed (%t) or executed (%t) new work", claimed, executed)
+	}
+	if !msg.naked {
+		t.Error("a draining worker should hand the message back for another worker")
+	}
+	if plain, confirmed := msg.acks(); plain != 0 || confirmed != 0 || msg.termed {
+		t.Error("a handed-back message must be neither acknowledged nor terminated")
+	}
+}
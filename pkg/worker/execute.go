@@ -5,11 +5,14 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/sre-norns/urth/pkg/natsq"
 	"github.com/sre-norns/urth/pkg/prob"
 	"github.com/sre-norns/urth/pkg/runner"
+	"github.com/sre-norns/urth/pkg/runner/egress"
 	"github.com/sre-norns/urth/pkg/urth"
 
 	"github.com/sre-norns/wyrd/pkg/grace"
@@ -36,10 +39,25 @@ func (w *Worker) execute(ctx context.Context, envelope natsq.DispatchEnvelope, a
 	runCtx, cancel := context.WithTimeout(ctx, timeout)
 	defer cancel()
 
+	cancelled, stopWatching := w.watchCancel(envelope.ResultUID, cancel)
+	defer stopWatching()
+
+	// Cancellable on this host too, through the admin endpoint, for the
+	// operator who is on the box and cannot reach the API. It ends the run the
+	// same way: as cancelled, reported like any other outcome.
+	var cancelledHere atomic.Bool
+	w.inFlight.start(envelope.ResultUID, envelope.ScenarioName, auth.Prob.Kind, func() {
+		if cancelledHere.CompareAndSwap(false, true) {
+			log.Printf("run %v cancelled through the admin endpoint", envelope.ResultUID)
+			cancel()
+		}
+	})
+	started := time.Now()
+
 	var playOptions []runner.PlayOption
-	if w.config.StreamLogs && w.conn != nil {
+	if conn := w.conn.Load(); w.config.StreamLogs && conn != nil {
 		playOptions = append(playOptions,
-			runner.WithLogPublisher(natsq.NewLogPublisher(w.conn, w.runnerUID, envelope.ResultUID)))
+			runner.WithLogPublisher(natsq.NewLogPublisher(conn, w.runnerUID, envelope.ResultUID)))
 	}
 
 	log.Printf("running %v: kind=%v timeout=%v", envelope.ResultUID, auth.Prob.Kind, timeout)
@@ -56,6 +74,8 @@ func (w *Worker) execute(ctx context.Context, envelope natsq.DispatchEnvelope, a
 				WorkingDirectory: w.config.WorkingDirectory,
 				TempDirPrefix:    string(envelope.ResultUID),
 			},
+			Sandbox: w.config.Sandbox,
+			Network: w.config.Network.WithDefaults(egress.FromNetwork(auth.Network)).Network(),
 		},
 		playOptions...)
 	if err != nil {
@@ -65,9 +85,55 @@ func (w *Worker) execute(ctx context.Context, envelope natsq.DispatchEnvelope, a
 		log.Printf("run %v failed: %v", envelope.ResultUID, err)
 	}
 
+	// Whatever the probe made of its context ending, the reason it ended was an
+	// operator. Only that verdict says so; a timeout or an error would send
+	// someone looking for a fault that is not there.
+	if cancelled() || cancelledHere.Load() {
+		runResult.Result = prob.RunFinishedCanceled
+	}
+
+	w.recent.add(finishedRun{
+		ResultUID: envelope.ResultUID,
+		Scenario:  envelope.ScenarioName,
+		Kind:      auth.Prob.Kind,
+		Result:    runResult.Result,
+		Started:   started,
+		Duration:  time.Since(started).Round(time.Millisecond).String(),
+	})
+
 	w.report(ctx, envelope, auth, runResult, artifacts)
 }
 
+// watchCancel stops the run when an operator cancels it. It reports whether
+// that happened, and returns the function that stops listening.
+//
+// Without a NATS connection there is nowhere to hear the signal from, and the
+// run goes on to finish as it would have; the server records the outcome the
+// worker reports. Failing to subscribe is logged and treated the same way --
+// a run must not be refused for want of a way to stop it early.
+func (w *Worker) watchCancel(resultUID manifest.ResourceID, stopRun context.CancelFunc) (cancelled func() bool, stop func()) {
+	var requested atomic.Bool
+	cancelled = requested.Load
+
+	conn := w.conn.Load()
+	if conn == nil {
+		return cancelled, func() {}
+	}
+
+	sub, err := natsq.SubscribeCancel(conn, w.runnerUID, resultUID, func(signal natsq.CancelSignal) {
+		if requested.CompareAndSwap(false, true) {
+			log.Printf("run %v cancelled by %q: %v", resultUID, signal.RequestedBy, signal.Reason)
+			stopRun()
+		}
+	})
+	if err != nil {
+		log.Printf("run %v cannot be cancelled while it executes: %v", resultUID, err)
+		return cancelled, func() {}
+	}
+
+	return cancelled, func() { _ = sub.Unsubscribe() }
+}
+
 // runTimeout picks how long to allow the probe, respecting the server's lease.
 func (w *Worker) runTimeout(auth urth.AuthJobResponse) time.Duration {
 	timeout := w.config.RunnerConfig.Timeout
@@ -109,8 +175,12 @@ func (w *Worker) report(ctx context.Context, envelope natsq.DispatchEnvelope, au
 	reportCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), reportTimeout)
 	defer cancel()
 
+	// Taken before any upload, so that a report delivered from the spool an
+	// outage later still says when the run finished.
+	finishedAt := time.Now()
+
 	labels := manifest.MergeLabels(
-		w.config.LabelJob(w.runnerMeta, w.workerMeta, urth.Job{
+		w.labelJob(urth.Job{
 			ResultName:   manifest.ResourceName(envelope.ResultUID),
 			ScenarioName: envelope.ScenarioName,
 		}),
@@ -130,46 +200,81 @@ func (w *Worker) report(ctx context.Context, envelope natsq.DispatchEnvelope, au
 	// until its lease expires: a worse outcome than a run missing an artifact.
 	wg := grace.NewCollectingWorkgroup(reportCtx, reportConcurrency)
 
+	// Whatever fails for want of a reachable API is kept for the spool. The
+	// work items run concurrently, so this one is guarded.
+	var undelivered struct {
+		sync.Mutex
+		report spooledReport
+	}
+	keep := func(err error, update func(*spooledReport)) {
+		if transientReportFailure(err) {
+			undelivered.Lock()
+			update(&undelivered.report)
+			undelivered.Unlock()
+		}
+	}
+
 	// Go only refuses work once the report context is done, and the caller is
-	// the only producer, so collecting the refusals here needs no locking.
+	// the only producer, so collecting the refusals here needs no locking. A
+	// refused item never ran, and what it would have delivered is kept.
 	var scheduleErrs []error
-	schedule := func(work grace.WorkItem) {
-		if err := wg.Go(work); err != nil {
+	schedule := func(work grace.WorkItem, onRefused func(error)) {
+		var started atomic.Bool
+		err := wg.Go(func(ctx context.Context) error {
+			started.Store(true)
+			return work(ctx)
+		})
+		if err != nil {
 			scheduleErrs = append(scheduleErrs, err)
+			if !started.Load() {
+				onRefused(err)
+			}
 		}
 	}
 
 	artifactsAPI := w.apiClient.Artifacts()
 	for _, a := range artifacts {
-		artifact := a
+		artifact := manifest.ResourceManifest{
+			TypeMeta: manifest.TypeMeta{
+				APIVersion: "v1",
+				Kind:       urth.KindArtifact,
+			},
+			Metadata: manifest.ObjectMeta{
+				Name:   manifest.ResourceName(fmt.Sprintf("%v.%v", envelope.ResultUID, a.Artifact.Rel)),
+				Labels: labels,
+			},
+			Spec: a,
+		}
+		keepArtifact := func(err error) {
+			keep(err, func(r *spooledReport) { r.Artifacts = append(r.Artifacts, artifact) })
+		}
+
 		schedule(func(ctx context.Context) error {
-			_, err := artifactsAPI.Create(ctx, auth.Token, manifest.ResourceManifest{
-				TypeMeta: manifest.TypeMeta{
-					APIVersion: "v1",
-					Kind:       urth.KindArtifact,
-				},
-				Metadata: manifest.ObjectMeta{
-					Name:   manifest.ResourceName(fmt.Sprintf("%v.%v", envelope.ResultUID, artifact.Artifact.Rel)),
-					Labels: labels,
-				},
-				Spec: artifact,
-			})
-			if err != nil {
-				return fmt.Errorf("failed to post artifact %q: %w", artifact.Artifact.Rel, err)
+			if _, err := artifactsAPI.Create(ctx, auth.Token, artifact); err != nil {
+				keepArtifact(err)
+				return fmt.Errorf("failed to post artifact %q: %w", a.Artifact.Rel, err)
 			}
 
 			return nil
-		})
+		}, keepArtifact)
 	}
 
 	resultsAPI := w.apiClient.Results(envelope.ScenarioName)
+	keepStatus := func(err error) {
+		keep(err, func(r *spooledReport) {
+			spooled := runResult
+			spooled.Delivery = &urth.ResultDelivery{Spooled: true, FinishedAt: finishedAt}
+			r.Status = &spooled
+		})
+	}
 	schedule(func(ctx context.Context) error {
 		if _, err := resultsAPI.UpdateStatus(ctx, auth.VersionedResourceID, auth.Token, runResult); err != nil {
+			keepStatus(err)
 			return fmt.Errorf("failed to post run status: %w", err)
 		}
 
 		return nil
-	})
+	}, keepStatus)
 
 	w.metrics.ran(string(runResult.Result))
 
@@ -179,6 +284,17 @@ func (w *Worker) report(ctx context.Context, envelope natsq.DispatchEnvelope, au
 	// was invisible.
 	if err := errors.Join(append(scheduleErrs, wg.Wait())...); err != nil {
 		log.Printf("run %v: failed to report fully: %v", envelope.ResultUID, err)
+
+		undelivered.report.Run = auth.VersionedResourceID
+		undelivered.report.ScenarioName = envelope.ScenarioName
+		undelivered.report.Token = auth.Token
+		undelivered.report.SpooledAt = time.Now()
+		undelivered.report.ExpiresAt = capabilityExpiry(auth.Token)
+		if !undelivered.report.ExpiresAt.IsZero() {
+			undelivered.report.DeliverBy = undelivered.report.ExpiresAt.Add(auth.LateResultGrace)
+		}
+		w.spoolReport(undelivered.report)
+
 		return
 	}
 
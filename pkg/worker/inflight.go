@@ -1,8 +1,12 @@
 package worker
 
 import (
+	"slices"
+	"strings"
 	"sync"
+	"time"
 
+	"github.com/sre-norns/urth/pkg/prob"
 	"github.com/sre-norns/wyrd/pkg/manifest"
 )
 
@@ -25,9 +29,28 @@ import (
 // The set is bounded by construction. Every entry is held by one handle() call,
 // which holds one slot of the consume semaphore for its whole life and releases
 // both together -- so it can never hold more than --concurrency entries.
+//
+// It is also what the admin endpoint lists and cancels through, which is why an
+// entry carries more than its key. See admin.go.
 type inFlightRuns struct {
 	mu   sync.Mutex
-	runs map[manifest.ResourceID]struct{}
+	runs map[manifest.ResourceID]*localRun
+}
+
+// localRun is one run this process holds: being claimed, and then executing.
+type localRun struct {
+	// acquired is when the delivery was taken on; started is when the probe
+	// began, and is zero while the claim is still in flight.
+	acquired time.Time
+	started  time.Time
+
+	// scenario and kind are known once the run is executing.
+	scenario manifest.ResourceName
+	kind     prob.Kind
+
+	// cancel stops the probe. Nil until it starts: a claim is not something
+	// there is anything to cancel of yet.
+	cancel func()
 }
 
 // acquire claims local ownership of a run, reporting whether it was granted.
@@ -45,13 +68,85 @@ func (f *inFlightRuns) acquire(uid manifest.ResourceID) bool {
 	}
 
 	if f.runs == nil {
-		f.runs = make(map[manifest.ResourceID]struct{})
+		f.runs = make(map[manifest.ResourceID]*localRun)
 	}
-	f.runs[uid] = struct{}{}
+	f.runs[uid] = &localRun{acquired: time.Now()}
 
 	return true
 }
 
+// start records that a held run has begun executing, and how to stop it.
+func (f *inFlightRuns) start(uid manifest.ResourceID, scenario manifest.ResourceName, kind prob.Kind, cancel func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	run, held := f.runs[uid]
+	if !held {
+		return
+	}
+
+	run.started = time.Now()
+	run.scenario = scenario
+	run.kind = kind
+	run.cancel = cancel
+}
+
+// cancel stops a run this process is executing. found is false for a run it
+// does not hold; started is false for one it holds but has not begun, which
+// has nothing to stop yet.
+func (f *inFlightRuns) cancel(uid manifest.ResourceID) (found, started bool) {
+	f.mu.Lock()
+	run, held := f.runs[uid]
+	var cancel func()
+	if held {
+		cancel = run.cancel
+	}
+	f.mu.Unlock()
+
+	if cancel == nil {
+		return held, false
+	}
+
+	// Outside the lock: stopping a run is the run's business, and it must not
+	// be able to wait on anything that needs this set.
+	cancel()
+
+	return true, true
+}
+
+// snapshot lists the runs held now, oldest first.
+func (f *inFlightRuns) snapshot(now time.Time) []inFlightRun {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	runs := make([]inFlightRun, 0, len(f.runs))
+	for uid, run := range f.runs {
+		entry := inFlightRun{
+			ResultUID: uid,
+			State:     "claiming",
+			Since:     run.acquired,
+			Elapsed:   now.Sub(run.acquired).Round(time.Millisecond).String(),
+		}
+		if !run.started.IsZero() {
+			entry.State = "running"
+			entry.Scenario = run.scenario
+			entry.Kind = run.kind
+			entry.Since = run.started
+			entry.Elapsed = now.Sub(run.started).Round(time.Millisecond).String()
+		}
+		runs = append(runs, entry)
+	}
+
+	slices.SortFunc(runs, func(a, b inFlightRun) int {
+		if c := a.Since.Compare(b.Since); c != 0 {
+			return c
+		}
+		return strings.Compare(string(a.ResultUID), string(b.ResultUID))
+	})
+
+	return runs
+}
+
 // release gives up ownership once the run has been executed and reported, or
 // abandoned without ever starting.
 func (f *inFlightRuns) release(uid manifest.ResourceID) {
@@ -0,0 +1,191 @@
+package worker
+
+import (
+	"fmt"
+	"log"
+	"maps"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/alecthomas/kong"
+
+	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/urth/pkg/urth"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// Prob kinds do not cost the same. A TCP check is a socket and a timer; a
+// puppeteer run is a browser, a few hundred megabytes and a core for as long as
+// the page takes. One slot pool sized for either is wrong for the other: sized
+// for browsers it leaves cheap checks queueing behind a handful of them, and
+// sized for checks it lets five browsers take the host down.
+//
+// So a kind can be given slots of its own, inside the overall pool. A run still
+// needs one of the --concurrency slots; a run of a limited kind also needs one
+// of that kind's.
+//
+// The kind is checked before the claim, from the dispatch envelope. A run the
+// worker has no slot for is not held: sitting on it until a slot frees up would
+// outlast AckWait, the broker would redeliver it to somebody else anyway, and in
+// the meantime it would occupy one of the runner's MaxAckPending reservations.
+//
+// Nor is it handed back. Every hand-back is a delivery, deliveries are bounded
+// by MaxDeliver, and a burst of half-minute puppeteer runs on a worker allowed
+// one at a time used up five of them long before a slot came free: the runs were
+// dead-lettered though nothing was wrong with them. So the run is claimed all
+// the same, naming the kinds with no slot free (urth.ClaimJobRequest.BusyKinds),
+// and the server defers it as it would a throttled run -- re-queued, with a
+// fresh set of deliveries, for whichever worker has room when it comes back.
+// See urth's deferral.go.
+
+// kindSlots counts the runs of each prob kind this process is executing.
+//
+// Counted for every kind, limited or not, so that a limit is only ever
+// consulted and never has to be remembered alongside the run: releasing a slot
+// is the same call whatever the limit was when it was taken.
+type kindSlots struct {
+	mu      sync.Mutex
+	running map[prob.Kind]int
+}
+
+// acquire takes a slot for a run of kind, reporting whether one was free. A
+// limit of zero or less is no limit.
+func (s *kindSlots) acquire(kind prob.Kind, limit int) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if limit > 0 && s.running[kind] >= limit {
+		return false
+	}
+
+	if s.running == nil {
+		s.running = make(map[prob.Kind]int)
+	}
+	s.running[kind]++
+
+	return true
+}
+
+// release gives back a slot taken by acquire.
+func (s *kindSlots) release(kind prob.Kind) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running[kind] <= 1 {
+		delete(s.running, kind)
+		return
+	}
+
+	s.running[kind]--
+}
+
+// kindConcurrencyFlagPrefix names a kind's flag: --concurrency.puppeteer.
+const kindConcurrencyFlagPrefix = "concurrency."
+
+// kindConcurrencyFlags declares a --concurrency.<kind> flag for every prob kind
+// this build registers.
+//
+// Kong only knows flags declared as struct fields, and the kinds are not known
+// until the probers have registered, so the struct is made at startup, one int
+// field per kind, and handed to kong as a plugin. Declaring a flag per kind,
+// rather than taking KIND=N pairs, is what makes a misspelt kind an unknown
+// flag at parse time and lists every kind a worker can limit in --help.
+func kindConcurrencyFlags() kong.Plugins {
+	kinds := slices.Sorted(maps.Keys(prob.ListProbs()))
+
+	fields := make([]reflect.StructField, 0, len(kinds))
+	for i, kind := range kinds {
+		fields = append(fields, reflect.StructField{
+			Name: fmt.Sprintf("Kind%d", i),
+			Type: reflect.TypeFor[int](),
+			Tag: reflect.StructTag(fmt.Sprintf(
+				`name:"%s%s" help:"Maximum number of %s runs to execute at once, within --concurrency. 0 for no limit of its own" placeholder:"N"`,
+				kindConcurrencyFlagPrefix, kind, kind,
+			)),
+		})
+	}
+
+	return kong.Plugins{reflect.New(reflect.StructOf(fields)).Interface()}
+}
+
+// applyKindConcurrencyFlags copies the limits given as --concurrency.<kind>
+// into KindConcurrency. A flag left at zero sets nothing, so a limit a caller
+// put in KindConcurrency directly stands.
+func (c *Config) applyKindConcurrencyFlags() {
+	for _, plugin := range c.KindConcurrencyFlags {
+		flags := reflect.ValueOf(plugin).Elem()
+
+		for i := range flags.NumField() {
+			limit := int(flags.Field(i).Int())
+			if limit == 0 {
+				continue
+			}
+
+			if c.KindConcurrency == nil {
+				c.KindConcurrency = make(map[prob.Kind]int)
+			}
+
+			name := flags.Type().Field(i).Tag.Get("name")
+			c.KindConcurrency[prob.Kind(strings.TrimPrefix(name, kindConcurrencyFlagPrefix))] = limit
+		}
+	}
+}
+
+// normalizeKindLimits drops the limits that cannot mean what they say.
+func (c *Config) normalizeKindLimits() {
+	registered := prob.ListProbs()
+
+	for _, kind := range slices.Sorted(maps.Keys(c.KindConcurrency)) {
+		switch _, ok := registered[kind]; {
+		case !ok:
+			// Only kinds this build can run. A limit on anything else is a
+			// typo, and advertising it would put the typo in every
+			// registration.
+			log.Printf("ignoring a concurrency limit for %q: this worker has no such prober", kind)
+			delete(c.KindConcurrency, kind)
+		case c.KindConcurrency[kind] <= 0:
+			// No limit rather than a kind that can never run: a worker that
+			// should not run a kind is one built without its prober, and a
+			// zero here is far more likely to be a slip than that decision
+			// made another way.
+			delete(c.KindConcurrency, kind)
+		}
+	}
+}
+
+// kindLimit is the slot limit for a prob kind; zero means only the overall
+// concurrency applies. A dispatch that does not name its kind is never limited
+// by one.
+func (c *Config) kindLimit(kind prob.Kind) int {
+	if kind == "" {
+		return 0
+	}
+
+	return c.KindConcurrency[kind]
+}
+
+// GetEffectiveLabels is the runner configuration's labels plus this worker's
+// slot limits, and the queues it pulls.
+//
+// Advertised rather than kept to itself, because from the control plane a
+// worker declining puppeteer runs and a worker that is broken look alike. The
+// labels are what lets an operator tell them apart, and select on them.
+//
+// The queue label is what the server routes high-priority runs by: until every
+// worker of a runner carries it, they are published to the normal queue too.
+// See urth.HighPriorityQueueSupport.
+func (c *Config) GetEffectiveLabels() manifest.Labels {
+	limits := manifest.Labels{
+		urth.LabelWorkerCapConcurrency:       strconv.Itoa(c.Concurrency),
+		urth.LabelWorkerCapHighPriorityQueue: "true",
+	}
+
+	for kind, limit := range c.KindConcurrency {
+		limits[urth.LabelWorkerCapConcurrencyPrefix+string(kind)] = strconv.Itoa(limit)
+	}
+
+	return manifest.MergeLabels(c.RunnerConfig.GetEffectiveLabels(), limits)
+}
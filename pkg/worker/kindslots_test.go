@@ -0,0 +1,172 @@
+package worker
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/alecthomas/kong"
+
+	"github.com/sre-norns/urth/pkg/natsq"
+	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/urth/pkg/urth"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+func kindEnvelope(resultUID manifest.ResourceID, kind prob.Kind) []byte {
+	data, err := natsq.MarshalEnvelope(natsq.DispatchEnvelope{
+		SchemaVersion: natsq.DispatchEnvelopeVersion,
+		ResultUID:     resultUID,
+		ResultVersion: 1,
+		ScenarioName:  "a-scenario",
+		RunnerUID:     testRunnerUID,
+		DispatchID:    "dispatch-" + string(resultUID),
+		ProbKind:      kind,
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	return data
+}
+
+// A run whose kind has no free slot is claimed naming the kind, so the server
+// defers it with a fresh set of deliveries, and its message is acknowledged
+// rather than handed back; a run of a kind with room is claimed as usual.
+func TestHandleAsksForARunWhoseKindIsFullToBeDeferred(t *testing.T) {
+	w := newTestWorker(nil)
+	w.runnerUID = testRunnerUID
+	w.config.KindConcurrency = map[prob.Kind]int{"puppeteer": 1}
+
+	var claims []urth.ClaimJobRequest
+	var executions int
+	w.apiClient = stubService{results: stubResults{err: apiError(http.StatusTooManyRequests), claimed: &claims}}
+	w.executeJob = func(context.Context, natsq.DispatchEnvelope, urth.AuthJobResponse) { executions++ }
+
+	// One puppeteer run is already executing.
+	if !w.kindSlots.acquire("puppeteer", w.config.kindLimit("puppeteer")) {
+		t.Fatal("the first puppeteer slot should be free")
+	}
+
+	// More than MaxDeliver of them: none may spend a delivery.
+	for i := range 6 {
+		busy := &fakeMsg{data: kindEnvelope(manifest.ResourceID(fmt.Sprintf("run-%d", i)), "puppeteer")}
+		w.handle(context.Background(), busy)
+
+		if busy.naked || busy.termed {
+			t.Errorf("a run whose kind is full must not spend a delivery, got naked=%t termed=%t", busy.naked, busy.termed)
+		}
+		if plain, _ := busy.acks(); plain != 1 {
+			t.Errorf("a deferred run's message should be acknowledged once, got %d", plain)
+		}
+	}
+	if executions != 0 {
+		t.Fatalf("a run whose kind is full was executed %d times", executions)
+	}
+	for _, claim := range claims {
+		if !slices.Equal(claim.BusyKinds, []prob.Kind{"puppeteer"}) {
+			t.Errorf("a run whose kind is full should be claimed naming the kind, got %+v", claim)
+		}
+	}
+
+	w.apiClient = stubService{results: stubResults{claimed: &claims}}
+	w.handle(context.Background(), &fakeMsg{data: kindEnvelope("run-tcp", "tcp")})
+
+	if executions != 1 {
+		t.Errorf("a run of an unlimited kind was executed %d times, want once", executions)
+	}
+	if last := claims[len(claims)-1]; len(last.BusyKinds) != 0 {
+		t.Errorf("a run of a kind with room was claimed naming busy kinds: %v", last.BusyKinds)
+	}
+
+	// Once the running one finishes, the kind runs again.
+	w.kindSlots.release("puppeteer")
+	w.handle(context.Background(), &fakeMsg{data: kindEnvelope("run-0", "puppeteer")})
+
+	if executions != 2 {
+		t.Errorf("a puppeteer run with its slot free was not executed")
+	}
+}
+
+// The slot is held for the length of the run and given back afterwards, whatever
+// the run's outcome.
+func TestHandleReleasesTheKindSlotAfterTheRun(t *testing.T) {
+	w := newTestWorker(nil)
+	w.runnerUID = testRunnerUID
+	w.config.KindConcurrency = map[prob.Kind]int{"puppeteer": 1}
+
+	var heldDuringRun bool
+	w.executeJob = func(context.Context, natsq.DispatchEnvelope, urth.AuthJobResponse) {
+		heldDuringRun = !w.kindSlots.acquire("puppeteer", 1)
+	}
+
+	w.handle(context.Background(), &fakeMsg{data: kindEnvelope("run-1", "puppeteer")})
+
+	if !heldDuringRun {
+		t.Error("the run's kind slot was free while it executed")
+	}
+	if !w.kindSlots.acquire("puppeteer", 1) {
+		t.Error("the run's kind slot was not released once it finished")
+	}
+}
+
+// The limits are advertised, so an operator can tell a worker declining a kind
+// from one that is broken.
+func TestKindLimitsAreAdvertisedAsLabels(t *testing.T) {
+	cfg := Config{
+		Concurrency:     8,
+		KindConcurrency: map[prob.Kind]int{"puppeteer": 1},
+	}
+
+	labels := cfg.GetEffectiveLabels()
+	if got := labels[urth.LabelWorkerCapConcurrency]; got != "8" {
+		t.Errorf("overall concurrency label = %q, want 8", got)
+	}
+	if got := labels[urth.LabelWorkerCapConcurrencyPrefix+"puppeteer"]; got != "1" {
+		t.Errorf("puppeteer concurrency label = %q, want 1", got)
+	}
+}
+
+// A worker says it pulls the high-priority queue, or the server keeps
+// publishing high runs to the normal queue as well for the workers that don't.
+func TestHighPriorityQueueIsAdvertised(t *testing.T) {
+	cfg := Config{Concurrency: 1}
+
+	if _, ok := cfg.GetEffectiveLabels()[urth.LabelWorkerCapHighPriorityQueue]; !ok {
+		t.Errorf("labels %v do not advertise the high-priority queue", cfg.GetEffectiveLabels())
+	}
+}
+
+// Each kind this build can run has a flag of its own, and a kind it cannot run
+// has none, so a misspelt limit fails the command instead of being ignored.
+func TestKindLimitsAreFlagsPerKind(t *testing.T) {
+	cfg := NewDefaultConfig()
+	parser, err := kong.New(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := parser.Parse([]string{"--working-directory", t.TempDir(), "--concurrency", "32", "--concurrency.puppeteer=1", "--concurrency.tcp=50"}); err != nil {
+		t.Fatalf("parsing per-kind limits: %v", err)
+	}
+	cfg.Normalize()
+
+	if cfg.Concurrency != 32 {
+		t.Errorf("overall concurrency = %d, want 32", cfg.Concurrency)
+	}
+	if want := map[prob.Kind]int{"puppeteer": 1, "tcp": 50}; !maps.Equal(cfg.KindConcurrency, want) {
+		t.Errorf("kind limits = %v, want %v", cfg.KindConcurrency, want)
+	}
+
+	fresh := NewDefaultConfig()
+	parser, err = kong.New(&fresh)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.Parse([]string{"--working-directory", t.TempDir(), "--concurrency.puppeter=1"}); err == nil {
+		t.Error("a limit on a kind this worker has no prober for was accepted")
+	}
+}
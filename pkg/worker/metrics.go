@@ -10,6 +10,8 @@ import (
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/sre-norns/urth/pkg/prob"
 )
 
 // workerMetrics is what this process can tell an operator about its own claim
@@ -29,12 +31,16 @@ import (
 // registry, and so do the tests that build a worker literal; making each call
 // site check would spread that decision over the claim path for no benefit.
 type workerMetrics struct {
-	claims              *prometheus.CounterVec
-	runs                *prometheus.CounterVec
-	ackConfirmSeconds   prometheus.Histogram
-	ackRetriesTotal     prometheus.Counter
-	ackUnconfirmedTotal prometheus.Counter
-	duplicateTotal      prometheus.Counter
+	claims               *prometheus.CounterVec
+	runs                 *prometheus.CounterVec
+	ackConfirmSeconds    prometheus.Histogram
+	ackRetriesTotal      prometheus.Counter
+	ackUnconfirmedTotal  prometheus.Counter
+	duplicateTotal       prometheus.Counter
+	kindBusyTotal        *prometheus.CounterVec
+	kindUnavailableTotal *prometheus.CounterVec
+	selfTestFailing      *prometheus.GaugeVec
+	spoolTotal           *prometheus.CounterVec
 }
 
 // newWorkerMetrics builds the worker's collectors and its registry.
@@ -79,6 +85,26 @@ func newWorkerMetrics() (*workerMetrics, *prometheus.Registry) {
 			Name: namespace + "_duplicate_deliveries_total",
 			Help: "Redeliveries dropped because this worker was already executing that run.",
 		}),
+
+		kindBusyTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: namespace + "_kind_busy_total",
+			Help: "Runs claimed only to be deferred, because every slot for their prob kind was taken on this worker.",
+		}, []string{"kind"}),
+
+		kindUnavailableTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: namespace + "_kind_unavailable_total",
+			Help: "Runs claimed only to be deferred, because their prob kind is failing its self-test on this worker.",
+		}, []string{"kind"}),
+
+		selfTestFailing: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: namespace + "_self_test_failing",
+			Help: "1 for each prob kind whose latest self-test failed on this worker, 0 for each that passed.",
+		}, []string{"kind"}),
+
+		spoolTotal: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: namespace + "_spooled_reports_total",
+			Help: "Run reports kept on disk through an API outage, by what became of them.",
+		}, []string{"outcome"}),
 	}
 
 	registry := prometheus.NewRegistry()
@@ -92,7 +118,7 @@ func newWorkerMetrics() (*workerMetrics, *prometheus.Registry) {
 	)
 
 	registry.MustRegister(m.claims, m.runs, m.ackConfirmSeconds,
-		m.ackRetriesTotal, m.ackUnconfirmedTotal, m.duplicateTotal)
+		m.ackRetriesTotal, m.ackUnconfirmedTotal, m.duplicateTotal, m.kindBusyTotal, m.kindUnavailableTotal, m.selfTestFailing, m.spoolTotal)
 
 	return m, registry
 }
@@ -145,6 +171,52 @@ func (m *workerMetrics) duplicateDelivery() {
 	m.duplicateTotal.Inc()
 }
 
+func (m *workerMetrics) kindBusy(kind prob.Kind) {
+	if m == nil {
+		return
+	}
+
+	m.kindBusyTotal.WithLabelValues(string(kind)).Inc()
+}
+
+func (m *workerMetrics) kindUnavailable(kind prob.Kind) {
+	if m == nil {
+		return
+	}
+
+	m.kindUnavailableTotal.WithLabelValues(string(kind)).Inc()
+}
+
+func (m *workerMetrics) selfTest(kind prob.Kind, failed bool) {
+	if m == nil {
+		return
+	}
+
+	value := 0.0
+	if failed {
+		value = 1
+	}
+
+	m.selfTestFailing.WithLabelValues(string(kind)).Set(value)
+}
+
+// Outcomes of a spooled report. Spooled counts reports written; the other three
+// count how each one left the spool, so the difference is what is still held.
+const (
+	spoolOutcomeSpooled   = "spooled"
+	spoolOutcomeDelivered = "delivered"
+	spoolOutcomeExpired   = "expired"
+	spoolOutcomeRefused   = "refused"
+)
+
+func (m *workerMetrics) spooled(outcome string) {
+	if m == nil {
+		return
+	}
+
+	m.spoolTotal.WithLabelValues(outcome).Inc()
+}
+
 // serveMetrics exposes the registry until the worker's context is cancelled.
 //
 // Opt-in, and off by default, because of where this process runs: a worker sits
@@ -162,9 +234,16 @@ func serveMetrics(ctx context.Context, address string, registry *prometheus.Regi
 		ErrorHandling: promhttp.ContinueOnError,
 	}))
 
+	serveHTTP(ctx, "metrics endpoint", address, mux)
+}
+
+// serveHTTP serves handler on address until ctx is cancelled. Failures are
+// logged and survived, for the reason given on serveMetrics: a worker that
+// cannot serve an endpoint can still run probes.
+func serveHTTP(ctx context.Context, what, address string, handler http.Handler) {
 	server := &http.Server{
 		Addr:              address,
-		Handler:           mux,
+		Handler:           handler,
 		ReadHeaderTimeout: 5 * time.Second,
 	}
 
@@ -175,13 +254,13 @@ func serveMetrics(ctx context.Context, address string, registry *prometheus.Regi
 		defer cancel()
 
 		if err := server.Shutdown(shutdownCtx); err != nil {
-			log.Printf("metrics endpoint did not shut down cleanly: %v", err)
+			log.Printf("%s did not shut down cleanly: %v", what, err)
 		}
 	}()
 
-	log.Printf("serving metrics on %s/metrics", address)
+	log.Printf("serving %s on %s", what, address)
 
 	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
-		log.Printf("metrics endpoint stopped: %v", err)
+		log.Printf("%s stopped: %v", what, err)
 	}
 }
@@ -0,0 +1,103 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"maps"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/sre-norns/urth/pkg/urth"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// A worker's custom labels say where it is and what it can reach -- a rack, a
+// network segment, a tenant -- and they change with the host rather than with
+// the binary: a VM is moved, a VLAN added. Restarting the worker to say so
+// would hand back every run it holds, so on SIGHUP it reads its labels again
+// and re-registers with them instead.
+//
+// Re-registering is what session renewal already does every few minutes, and
+// it leaves everything the worker is doing alone: the NATS consumer stays
+// bound, and a run in flight carries its own capability, issued at its claim.
+// What changes is what the server holds for placement, what the next claim
+// reports, and the labels on the results reported from now on -- including
+// those of runs that were already in flight.
+//
+// Only the labels are reloaded. Everything else in the configuration is either
+// fixed by what the worker has already done with it -- its name, its queue, its
+// working directory -- or is read once by something that would need restarting
+// anyway.
+
+// effectiveLabels is what this worker says about itself: the build's
+// capabilities, the kind limits and the custom labels as they stand.
+func (w *Worker) effectiveLabels() manifest.Labels {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.config.GetEffectiveLabels()
+}
+
+// labelJob labels a result this worker reports.
+func (w *Worker) labelJob(job urth.Job) manifest.Labels {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+
+	return w.config.LabelJob(w.runnerMeta, w.workerMeta, job)
+}
+
+// reloadOnHangup reloads the labels on every SIGHUP until ctx ends.
+func (w *Worker) reloadOnHangup(ctx context.Context) {
+	if w.reloadLabels == nil {
+		return
+	}
+
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangups:
+			w.relabel(ctx)
+		}
+	}
+}
+
+// relabel takes the custom labels from reloadLabels and registers with them.
+//
+// A registration the server refuses -- the runner's requirements may not admit
+// the new labels -- puts the old ones back. The server still holds those, and a
+// worker claiming runs with labels its runner never accepted is the mismatch
+// registration exists to prevent.
+func (w *Worker) relabel(ctx context.Context) {
+	labels, err := w.reloadLabels()
+	if err != nil {
+		log.Printf("labels not reloaded, keeping the current ones: %v", err)
+		return
+	}
+
+	w.mu.Lock()
+	previous := w.config.CustomLabels
+	w.config.CustomLabels = labels
+	w.mu.Unlock()
+
+	if maps.Equal(previous, labels) {
+		log.Print("labels reloaded; unchanged")
+		return
+	}
+
+	if _, err := w.register(ctx); err != nil {
+		w.mu.Lock()
+		w.config.CustomLabels = previous
+		w.mu.Unlock()
+
+		log.Printf("failed to register with the reloaded labels, keeping the previous ones: %v", err)
+		return
+	}
+
+	log.Printf("labels reloaded and registered: %v", labels)
+}
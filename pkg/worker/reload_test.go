@@ -0,0 +1,99 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sre-norns/urth/pkg/urth"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// registeringService admits or refuses a registration, keeping the labels it
+// was last offered.
+type registeringService struct {
+	stubService
+	runners registeringRunners
+}
+
+func (s registeringService) Runners() urth.RunnersAPI { return s.runners }
+
+type registeringRunners struct {
+	urth.RunnersAPI
+	offered *manifest.Labels
+	refuse  error
+}
+
+func (r registeringRunners) AuthWorker(_ context.Context, _ urth.APIToken, worker manifest.ResourceManifest) (urth.WorkerRegistrationResponse, error) {
+	*r.offered = worker.Metadata.Labels
+	if r.refuse != nil {
+		return urth.WorkerRegistrationResponse{}, r.refuse
+	}
+
+	return urth.WorkerRegistrationResponse{
+		Runner:           urth.Runner{ObjectMeta: manifest.ObjectMeta{Name: "runner", UID: testRunnerUID}}.ToManifest(),
+		Worker:           urth.WorkerInstance{ObjectMeta: worker.Metadata}.ToManifest(),
+		Session:          "session-2",
+		SessionExpiresAt: time.Now().Add(time.Hour),
+	}, nil
+}
+
+func TestRelabelRegistersTheNewLabels(t *testing.T) {
+	var offered manifest.Labels
+
+	w := newTestWorker(nil)
+	w.config.Name = "worker"
+	w.config.APIRegistrationTimeout = time.Second
+	w.config.CustomLabels = manifest.Labels{"rack": "a1"}
+	w.apiClient = registeringService{runners: registeringRunners{offered: &offered}}
+	w.reloadLabels = func() (manifest.Labels, error) { return manifest.Labels{"rack": "b2"}, nil }
+
+	w.relabel(context.Background())
+
+	if offered["rack"] != "b2" {
+		t.Fatalf("registered with rack=%q, want the reloaded b2", offered["rack"])
+	}
+	if got := w.effectiveLabels()["rack"]; got != "b2" {
+		t.Errorf("claims would report rack=%q, want b2", got)
+	}
+	if w.currentSession() != "session-2" {
+		t.Error("the session the registration issued was not taken")
+	}
+
+	// Unchanged labels are not registered again.
+	offered = nil
+	w.relabel(context.Background())
+	if offered != nil {
+		t.Error("unchanged labels were registered again")
+	}
+}
+
+// Labels the server will not admit are not used: the server still holds the
+// old ones.
+func TestRelabelKeepsThePreviousLabelsWhenRefused(t *testing.T) {
+	var offered manifest.Labels
+
+	w := newTestWorker(nil)
+	w.config.Name = "worker"
+	w.config.APIRegistrationTimeout = time.Second
+	w.config.CustomLabels = manifest.Labels{"rack": "a1"}
+	w.apiClient = registeringService{runners: registeringRunners{offered: &offered, refuse: errors.New("requirements not met")}}
+	w.reloadLabels = func() (manifest.Labels, error) { return manifest.Labels{"rack": "b2"}, nil }
+
+	w.relabel(context.Background())
+
+	if offered["rack"] != "b2" {
+		t.Fatal("the reloaded labels were not offered")
+	}
+	if got := w.effectiveLabels()["rack"]; got != "a1" {
+		t.Errorf("after a refused registration claims would report rack=%q, want the previous a1", got)
+	}
+
+	// Nor is a reload that fails to read anything.
+	w.reloadLabels = func() (manifest.Labels, error) { return nil, errors.New("bad flag") }
+	w.relabel(context.Background())
+	if got := w.effectiveLabels()["rack"]; got != "a1" {
+		t.Errorf("a failed reload changed the labels to rack=%q", got)
+	}
+}
@@ -0,0 +1,215 @@
+package worker
+
+import (
+	"context"
+	"log"
+	"maps"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/urth/pkg/urth"
+)
+
+// A prober being linked into the binary says nothing about the host it was
+// started on. The icmp prober needs CAP_NET_RAW or a ping group, the puppeteer
+// prober needs node and a browser that starts, and a worker missing either used
+// to advertise the kind anyway and fail every run of it it was given.
+//
+// So each prober may carry a self-test (prob.SelfTestFn), and the worker acts on
+// the results in three places:
+//
+//   - at startup, runner.ProberAsLabels leaves a failing kind out of the labels
+//     the worker registers with;
+//   - on a timer, the tests are run again and the failures reported with every
+//     heartbeat, which is how a capability lost after registration -- a browser
+//     removed by an upgrade, a capability dropped by a redeploy -- reaches
+//     placement;
+//   - on delivery, a run of a kind that is failing here is claimed naming the
+//     failing kinds, and the server defers it for another worker of the runner
+//     to take (urth.ClaimJobRequest.FailingKinds). A worker whose every kind is
+//     failing stops fetching until one recovers.
+//
+// The startup results are the ones the labels were made from; the tests are
+// not run a second time for the worker. See startingCapabilities.
+//
+// Labels are a registration snapshot, so a kind that failed at startup and has
+// since started passing is not advertised again until the worker restarts. The
+// worker runs it if it is given one; it is placement that will not count it.
+
+// selfTestResults is what the latest self-test found, by kind.
+type selfTestResults struct {
+	mu       sync.RWMutex
+	failures map[prob.Kind]string
+}
+
+// record replaces the results, and returns the kinds that started failing and
+// those that recovered since the last time.
+func (r *selfTestResults) record(failures map[prob.Kind]error) (failed, recovered []prob.Kind) {
+	reasons := make(map[prob.Kind]string, len(failures))
+	for kind, err := range failures {
+		reasons[kind] = err.Error()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for kind := range reasons {
+		if _, was := r.failures[kind]; !was {
+			failed = append(failed, kind)
+		}
+	}
+	for kind := range r.failures {
+		if _, still := reasons[kind]; !still {
+			recovered = append(recovered, kind)
+		}
+	}
+	r.failures = reasons
+
+	slices.Sort(failed)
+	slices.Sort(recovered)
+
+	return failed, recovered
+}
+
+// failing reports whether kind failed the latest self-test. A dispatch that
+// does not name its kind is never held back by one.
+func (r *selfTestResults) failing(kind prob.Kind) bool {
+	if kind == "" {
+		return false
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	_, failed := r.failures[kind]
+
+	return failed
+}
+
+// failingKinds lists the kinds that failed the latest self-test, in kind
+// order, as a claim carries them.
+func (r *selfTestResults) failingKinds() []prob.Kind {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.failures) == 0 {
+		return nil
+	}
+
+	return slices.Sorted(maps.Keys(r.failures))
+}
+
+// allFailing reports whether every kind this build registers failed the latest
+// self-test. A build with no probers at all is not said to be failing.
+func (r *selfTestResults) allFailing() bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	probs := prob.ListProbs()
+	if len(probs) == 0 {
+		return false
+	}
+
+	for kind := range probs {
+		if _, failed := r.failures[kind]; !failed {
+			return false
+		}
+	}
+
+	return true
+}
+
+// report is the results as a heartbeat carries them, in kind order.
+func (r *selfTestResults) report() []urth.ProbSelfTestFailure {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.failures) == 0 {
+		return nil
+	}
+
+	report := make([]urth.ProbSelfTestFailure, 0, len(r.failures))
+	for _, kind := range slices.Sorted(maps.Keys(r.failures)) {
+		report = append(report, urth.ProbSelfTestFailure{Kind: kind, Reason: r.failures[kind]})
+	}
+
+	return report
+}
+
+// testCapabilities runs the probers' self-tests and records what they found.
+//
+// Only changes are logged. The tests run every few minutes for the life of the
+// worker, and a kind that has been failing since startup has already been said
+// to be.
+func (w *Worker) testCapabilities(ctx context.Context) {
+	selfTest := w.selfTest
+	if selfTest == nil {
+		selfTest = prob.SelfTest
+	}
+
+	failures := selfTest(ctx)
+	if ctx.Err() != nil {
+		// Interrupted, not failed: a test cut short by shutdown says nothing
+		// about the host.
+		return
+	}
+
+	w.recordCapabilities(failures)
+}
+
+// startingCapabilities records the self-test results the worker starts with.
+//
+// Those are the results its capability labels were made from, when the
+// configuration ran the tests -- runner.NewDefaultConfig does. Running them
+// again here would start a browser twice before the first heartbeat and could
+// leave the worker disagreeing with its own labels about a kind that is flaky.
+// A configuration built without the tests has none to offer, and they run now.
+func (w *Worker) startingCapabilities(ctx context.Context) {
+	if failures, tested := w.config.SelfTestFailures(); tested {
+		w.recordCapabilities(failures)
+		return
+	}
+
+	w.testCapabilities(ctx)
+}
+
+// recordCapabilities records one run of the self-tests, logging what changed
+// and updating the per-kind metric.
+func (w *Worker) recordCapabilities(failures map[prob.Kind]error) {
+	failed, recovered := w.capabilities.record(failures)
+
+	for _, kind := range failed {
+		log.Printf("prob kind %q failed its self-test and will not be run here: %v", kind, failures[kind])
+	}
+	for _, kind := range recovered {
+		log.Printf("prob kind %q passes its self-test again", kind)
+	}
+
+	for kind := range prob.ListProbs() {
+		_, failed := failures[kind]
+		w.metrics.selfTest(kind, failed)
+	}
+}
+
+// retestCapabilities re-runs the self-tests every SelfTestInterval until ctx
+// ends. An interval of zero or less leaves the startup results standing.
+func (w *Worker) retestCapabilities(ctx context.Context) {
+	if w.config.SelfTestInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(w.config.SelfTestInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		w.testCapabilities(ctx)
+	}
+}
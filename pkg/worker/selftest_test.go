@@ -0,0 +1,181 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/sre-norns/urth/pkg/natsq"
+	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/urth/pkg/urth"
+)
+
+// recordingWorkers keeps the last heartbeat it was sent.
+type recordingWorkers struct {
+	urth.WorkersAPI
+	last *urth.WorkerHeartbeatRequest
+}
+
+func (s recordingWorkers) Heartbeat(_ context.Context, _ urth.APIToken, request urth.WorkerHeartbeatRequest) (urth.WorkerHeartbeatResponse, error) {
+	*s.last = request
+	return urth.WorkerHeartbeatResponse{Interval: time.Minute}, nil
+}
+
+func failingSelfTest(failures map[prob.Kind]error) func(context.Context) map[prob.Kind]error {
+	return func(context.Context) map[prob.Kind]error { return failures }
+}
+
+// A run of a kind this host cannot run is claimed naming the kinds failing
+// here, so the server can defer it for a worker that can, and the message is
+// acknowledged rather than handed back. Other kinds are claimed as usual.
+func TestHandleAsksForARunOfAFailingKindToBeDeferred(t *testing.T) {
+	w := newTestWorker(nil)
+	w.runnerUID = testRunnerUID
+	w.selfTest = failingSelfTest(map[prob.Kind]error{"icmp": errors.New("no CAP_NET_RAW")})
+	w.testCapabilities(context.Background())
+
+	var claims []urth.ClaimJobRequest
+	var executions int
+	w.apiClient = stubService{results: stubResults{err: apiError(http.StatusTooManyRequests), claimed: &claims}}
+	w.executeJob = func(context.Context, natsq.DispatchEnvelope, urth.AuthJobResponse) { executions++ }
+
+	unavailable := &fakeMsg{data: kindEnvelope("run-1", "icmp")}
+	w.handle(context.Background(), unavailable)
+
+	if len(claims) != 1 || !slices.Equal(claims[0].FailingKinds, []prob.Kind{"icmp"}) {
+		t.Fatalf("a run of a failing kind should be claimed naming the failing kinds, got %+v", claims)
+	}
+	if executions != 0 {
+		t.Errorf("a deferred run was executed %d times", executions)
+	}
+	if unavailable.naked || unavailable.termed {
+		t.Errorf("a deferred run must not spend a delivery, got naked=%t termed=%t", unavailable.naked, unavailable.termed)
+	}
+	if plain, _ := unavailable.acks(); plain != 1 {
+		t.Errorf("a deferred run's message should be acknowledged once, got %d", plain)
+	}
+
+	w.apiClient = stubService{results: stubResults{claimed: &claims}}
+	w.handle(context.Background(), &fakeMsg{data: kindEnvelope("run-2", "tcp")})
+	if executions != 1 {
+		t.Errorf("a run of a passing kind was executed %d times, want once", executions)
+	}
+
+	// Once the kind passes again, the claims stop naming it.
+	w.selfTest = failingSelfTest(nil)
+	w.testCapabilities(context.Background())
+
+	w.handle(context.Background(), &fakeMsg{data: kindEnvelope("run-3", "icmp")})
+	if last := claims[len(claims)-1]; len(last.FailingKinds) != 0 {
+		t.Errorf("a claim after recovery still names failing kinds: %v", last.FailingKinds)
+	}
+}
+
+// A worker that can run nothing it was built with has nothing to fetch for.
+func TestEveryKindFailingIsAllFailing(t *testing.T) {
+	var results selfTestResults
+	if results.allFailing() {
+		t.Fatal("a worker with no results yet is not failing")
+	}
+
+	everything := make(map[prob.Kind]error)
+	for kind := range prob.ListProbs() {
+		everything[kind] = errors.New("broken")
+	}
+	if len(everything) < 2 {
+		t.Skip("needs at least two registered prob kinds")
+	}
+
+	results.record(everything)
+	if !results.allFailing() {
+		t.Error("every kind failing should be all failing")
+	}
+
+	for kind := range everything {
+		delete(everything, kind)
+		break
+	}
+	results.record(everything)
+	if results.allFailing() {
+		t.Error("one passing kind is enough to fetch for")
+	}
+}
+
+// The worker starts from the results its labels were made from, instead of
+// running the self-tests a second time.
+func TestStartupSelfTestIsNotRepeated(t *testing.T) {
+	var runs int
+	counting := func(context.Context) map[prob.Kind]error {
+		runs++
+		return nil
+	}
+
+	w := newTestWorker(nil)
+	w.selfTest = counting
+	w.startingCapabilities(context.Background())
+	if runs != 0 {
+		t.Errorf("the self-tests ran %d more times at startup after the configuration ran them", runs)
+	}
+
+	w = &Worker{config: &Config{}, selfTest: counting}
+	w.startingCapabilities(context.Background())
+	if runs != 1 {
+		t.Errorf("a configuration that ran no self-tests should have them run at startup, ran %d", runs)
+	}
+}
+
+// Every heartbeat carries the whole set of failures, so a kind that recovers is
+// cleared by the next report rather than by anybody.
+func TestHeartbeatReportsSelfTestFailures(t *testing.T) {
+	var sent urth.WorkerHeartbeatRequest
+
+	w := newTestWorker(nil)
+	w.apiClient = drainingService{workers: recordingWorkers{last: &sent}}
+
+	w.selfTest = failingSelfTest(map[prob.Kind]error{
+		"puppeteer": errors.New("no installed browser starts"),
+		"icmp":      errors.New("no CAP_NET_RAW"),
+	})
+	w.testCapabilities(context.Background())
+	w.heartbeat(context.Background(), time.Minute, false)
+
+	want := []urth.ProbSelfTestFailure{
+		{Kind: "icmp", Reason: "no CAP_NET_RAW"},
+		{Kind: "puppeteer", Reason: "no installed browser starts"},
+	}
+	if len(sent.SelfTestFailures) != len(want) {
+		t.Fatalf("heartbeat reported %v, want %v", sent.SelfTestFailures, want)
+	}
+	for i := range want {
+		if sent.SelfTestFailures[i] != want[i] {
+			t.Errorf("failure %d = %v, want %v", i, sent.SelfTestFailures[i], want[i])
+		}
+	}
+
+	w.selfTest = failingSelfTest(nil)
+	w.testCapabilities(context.Background())
+	w.heartbeat(context.Background(), time.Minute, false)
+
+	if len(sent.SelfTestFailures) != 0 {
+		t.Errorf("heartbeat after recovery reported %v, want nothing", sent.SelfTestFailures)
+	}
+}
+
+// A self-test cut short by shutdown is not a result, and must not mark every
+// kind it interrupted as failing.
+func TestInterruptedSelfTestIsNotRecorded(t *testing.T) {
+	w := newTestWorker(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	w.selfTest = failingSelfTest(map[prob.Kind]error{"puppeteer": context.Canceled})
+	w.testCapabilities(ctx)
+
+	if w.capabilities.failing("puppeteer") {
+		t.Error("an interrupted self-test was recorded as a failure")
+	}
+}
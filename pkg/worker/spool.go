@@ -0,0 +1,363 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/sre-norns/urth/pkg/urth"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// The spool keeps a finished run's report through an API outage.
+//
+// Without it a report that cannot be delivered is gone: the run sits in
+// `running` until the reconciler expires it, and a perfectly good observation --
+// often of the very outage that made the API unreachable -- is replaced by
+// "nobody told us". With it the undelivered parts of the report go to disk, one
+// file per run, and are replayed once the API answers again.
+//
+// A spooled report lives as long as the server will take it. Its artifacts
+// land only while the run capability lasts, and are dropped once it expires.
+// Its outcome is taken for the server's late grace past that, which the server
+// states in its answer to the claim; past that the server refuses the report
+// anyway, so keeping it would only grow the directory.
+
+// spoolDirectory is where undelivered reports are kept, under the working
+// directory.
+const spoolDirectory = "spool"
+
+// spooledReport is what remains to be delivered of one run's report.
+type spooledReport struct {
+	Run          manifest.VersionedResourceID `json:"run"`
+	ScenarioName manifest.ResourceName        `json:"scenario"`
+	Token        urth.APIToken                `json:"token"`
+
+	// SpooledAt orders replay: oldest first, so the runs closest to losing
+	// their capability go first.
+	SpooledAt time.Time `json:"spooledAt"`
+
+	// ExpiresAt is when the run capability stops working, and with it the
+	// artifact uploads. Zero if it could not be read, in which case the
+	// server's refusal is what ends the report.
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+
+	// DeliverBy is when the server stops taking the status: ExpiresAt plus
+	// the late grace the server advertised. Zero in a report spooled before
+	// the grace was advertised, which is kept until ExpiresAt as it was then.
+	DeliverBy time.Time `json:"deliverBy,omitempty"`
+
+	// Status is nil once the final status has been delivered.
+	Status *urth.ResultStatus `json:"status,omitempty"`
+
+	// Artifacts are the uploads still outstanding, with the names and labels
+	// they were first attempted with.
+	Artifacts []manifest.ResourceManifest `json:"artifacts,omitempty"`
+}
+
+// deliverableUntil is when the last of the report stops being deliverable,
+// zero if that is not known.
+func (r spooledReport) deliverableUntil() time.Time {
+	if r.DeliverBy.IsZero() {
+		return r.ExpiresAt
+	}
+
+	return r.DeliverBy
+}
+
+// done reports whether nothing is left to deliver.
+func (r spooledReport) done() bool {
+	return r.Status == nil && len(r.Artifacts) == 0
+}
+
+// spool is a directory of spooled reports.
+type spool struct {
+	dir string
+}
+
+// openSpool makes sure the spool directory exists.
+//
+// Private to this user: a spooled report carries a run capability, and an
+// artifact that may hold whatever the probe captured.
+func openSpool(dir string) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create spool directory %q: %w", dir, err)
+	}
+
+	return &spool{dir: dir}, nil
+}
+
+func (s *spool) path(id manifest.ResourceID) string {
+	return filepath.Join(s.dir, string(id)+".json")
+}
+
+// put writes a report, replacing any earlier one for the same run.
+//
+// Written aside and renamed into place, so a worker killed mid-write leaves
+// either the previous report or the new one, and never half of either.
+func (s *spool) put(report spooledReport) error {
+	data, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to encode spooled report: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(s.dir, ".spooling-*")
+	if err != nil {
+		return fmt.Errorf("failed to spool report: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to spool report: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to spool report: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to spool report: %w", err)
+	}
+
+	return os.Rename(tmp.Name(), s.path(report.Run.ID))
+}
+
+// remove forgets a run's report.
+func (s *spool) remove(id manifest.ResourceID) error {
+	if err := os.Remove(s.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// reports lists the spooled reports, oldest first. One that cannot be read is
+// logged and removed: it would fail the same way on every replay.
+func (s *spool) reports() ([]spooledReport, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read spool directory: %w", err)
+	}
+
+	reports := make([]spooledReport, 0, len(entries))
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || strings.HasPrefix(name, ".") || filepath.Ext(name) != ".json" {
+			continue
+		}
+
+		path := filepath.Join(s.dir, name)
+
+		var report spooledReport
+		data, err := os.ReadFile(path)
+		if err == nil {
+			err = json.Unmarshal(data, &report)
+		}
+		if err != nil || report.Run.ID == "" {
+			log.Printf("dropping unreadable spooled report %q: %v", name, err)
+			_ = os.Remove(path)
+			continue
+		}
+
+		reports = append(reports, report)
+	}
+
+	slices.SortFunc(reports, func(a, b spooledReport) int { return a.SpooledAt.Compare(b.SpooledAt) })
+
+	return reports, nil
+}
+
+// capabilityExpiry reads when a run capability stops working.
+//
+// Read without verifying the signature, which the worker has no key for and no
+// need of: the server verifies it on every request. This only decides when to
+// stop trying.
+func capabilityExpiry(token urth.APIToken) time.Time {
+	claims := jwt.RegisteredClaims{}
+	if _, _, err := jwt.NewParser().ParseUnverified(string(token), &claims); err != nil || claims.ExpiresAt == nil {
+		return time.Time{}
+	}
+
+	return claims.ExpiresAt.Time
+}
+
+// transientReportFailure reports whether a failed upload is worth trying again.
+//
+// The mirror of permanentReportRefusal: no status at all is the API not being
+// reachable, and a 5xx or a 429 is it being unwell. Anything else is a verdict,
+// and will be the same verdict next time.
+func transientReportFailure(err error) bool {
+	status, ok := apiStatus(err)
+
+	return !ok || status >= 500 || status == http.StatusTooManyRequests
+}
+
+// alreadyDelivered reports whether a refusal means the upload had in fact
+// landed, on an earlier attempt whose answer was lost.
+func alreadyDelivered(err error) bool {
+	status, ok := apiStatus(err)
+
+	return ok && status == http.StatusConflict
+}
+
+// spoolReport keeps what could not be delivered of a run's report.
+func (w *Worker) spoolReport(report spooledReport) {
+	if w.spool == nil || report.done() {
+		return
+	}
+
+	if err := w.spool.put(report); err != nil {
+		log.Printf("run %v: could not deliver its report, nor spool it: %v", report.Run.ID, err)
+		return
+	}
+
+	w.metrics.spooled(spoolOutcomeSpooled)
+	log.Printf("run %v: report spooled for delivery once the API server is reachable", report.Run.ID)
+}
+
+// replaySpool delivers spooled reports until ctx ends.
+//
+// The first pass runs at once, so a worker restarted after an outage delivers
+// what its previous process could not without first waiting out an interval.
+func (w *Worker) replaySpool(ctx context.Context) {
+	if w.spool == nil {
+		return
+	}
+
+	interval := w.config.SpoolReplayInterval
+	if interval <= 0 {
+		interval = defaultSpoolReplayInterval
+	}
+
+	for {
+		w.replaySpooled(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// defaultSpoolReplayInterval is how often spooled reports are retried when the
+// flag does not say.
+const defaultSpoolReplayInterval = 30 * time.Second
+
+// replaySpooled makes one pass over the spool, oldest report first.
+//
+// A pass stops at the first upload that fails for a reason worth retrying: the
+// API is still not answering, and trying every other report against it only
+// delays the next pass.
+func (w *Worker) replaySpooled(ctx context.Context) {
+	reports, err := w.spool.reports()
+	if err != nil {
+		log.Print(err)
+		return
+	}
+
+	for _, report := range reports {
+		if ctx.Err() != nil {
+			return
+		}
+
+		now := time.Now()
+		if until := report.deliverableUntil(); !until.IsZero() && now.After(until) {
+			log.Printf("run %v: dropping its spooled report, which the API server stopped taking at %v",
+				report.Run.ID, until.UTC().Format(time.RFC3339))
+			w.forgetSpooled(report, spoolOutcomeExpired)
+			continue
+		}
+
+		// Inside the late grace only the outcome is still taken. Uploading the
+		// artifacts first would only collect a refusal for each.
+		if !report.ExpiresAt.IsZero() && now.After(report.ExpiresAt) && len(report.Artifacts) > 0 {
+			log.Printf("run %v: dropping %d spooled artifacts, whose run capability expired at %v",
+				report.Run.ID, len(report.Artifacts), report.ExpiresAt.UTC().Format(time.RFC3339))
+			report.Artifacts = nil
+			if report.done() {
+				w.forgetSpooled(report, spoolOutcomeExpired)
+				continue
+			}
+		}
+
+		remaining, retry := w.deliverSpooled(ctx, report)
+		switch {
+		case remaining.done():
+			log.Printf("run %v: spooled report delivered", report.Run.ID)
+			w.forgetSpooled(report, spoolOutcomeDelivered)
+		case !retry:
+			w.forgetSpooled(report, spoolOutcomeRefused)
+		default:
+			if err := w.spool.put(remaining); err != nil {
+				log.Printf("run %v: failed to update spooled report: %v", report.Run.ID, err)
+			}
+			return
+		}
+	}
+}
+
+// forgetSpooled removes a report that is finished with, one way or the other.
+func (w *Worker) forgetSpooled(report spooledReport, outcome string) {
+	if err := w.spool.remove(report.Run.ID); err != nil {
+		log.Printf("run %v: failed to remove spooled report: %v", report.Run.ID, err)
+	}
+
+	w.metrics.spooled(outcome)
+}
+
+// deliverSpooled attempts what is left of one report, artifacts before status so
+// that a run never reads as finished without the artifacts it already had. It
+// returns what is still outstanding, and whether that is worth another try.
+func (w *Worker) deliverSpooled(ctx context.Context, report spooledReport) (spooledReport, bool) {
+	reportCtx, cancel := context.WithTimeout(ctx, reportTimeout)
+	defer cancel()
+
+	remaining := report
+	remaining.Artifacts = nil
+
+	artifactsAPI := w.apiClient.Artifacts()
+	for i, artifact := range report.Artifacts {
+		_, err := artifactsAPI.Create(reportCtx, report.Token, artifact)
+		switch {
+		case err == nil || alreadyDelivered(err):
+		case transientReportFailure(err):
+			remaining.Artifacts = report.Artifacts[i:]
+			return remaining, true
+		default:
+			log.Printf("run %v: API server refused spooled artifact %q: %v", report.Run.ID, artifact.Metadata.Name, err)
+		}
+	}
+
+	if report.Status == nil {
+		return remaining, true
+	}
+
+	_, err := w.apiClient.Results(report.ScenarioName).UpdateStatus(reportCtx, report.Run, report.Token, *report.Status)
+	switch {
+	case err == nil:
+		remaining.Status = nil
+	case alreadyDelivered(err):
+		// The run already holds an outcome: this report's own, from an attempt
+		// whose answer was lost, or one the server will not trade for it.
+		log.Printf("run %v: API server already holds an outcome; dropping spooled status", report.Run.ID)
+		remaining.Status = nil
+	case transientReportFailure(err):
+		return remaining, true
+	default:
+		log.Printf("run %v: API server refused spooled status: %v", report.Run.ID, err)
+		return remaining, false
+	}
+
+	return remaining, true
+}
@@ -0,0 +1,272 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"github.com/sre-norns/urth/pkg/natsq"
+	"github.com/sre-norns/urth/pkg/prob"
+	"github.com/sre-norns/urth/pkg/runner"
+	"github.com/sre-norns/urth/pkg/urth"
+	"github.com/sre-norns/wyrd/pkg/bark"
+	"github.com/sre-norns/wyrd/pkg/manifest"
+)
+
+// outageAPI is an API server that may or may not be reachable, and remembers
+// what reached it while it was.
+type outageAPI struct {
+	mu       sync.Mutex
+	err      error
+	statuses []urth.ResultStatus
+	uploaded []manifest.ResourceName
+}
+
+func (a *outageAPI) fail(err error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.err = err
+}
+
+type outageArtifacts struct {
+	urth.ArtifactAPI
+	api *outageAPI
+}
+
+func (s outageArtifacts) Create(_ context.Context, _ urth.APIToken, entry manifest.ResourceManifest) (manifest.ResourceManifest, error) {
+	s.api.mu.Lock()
+	defer s.api.mu.Unlock()
+
+	if s.api.err != nil {
+		return entry, s.api.err
+	}
+	s.api.uploaded = append(s.api.uploaded, entry.Metadata.Name)
+
+	return entry, nil
+}
+
+type outageResults struct {
+	urth.RunResultAPI
+	api *outageAPI
+}
+
+func (s outageResults) UpdateStatus(_ context.Context, _ manifest.VersionedResourceID, _ urth.APIToken, status urth.ResultStatus) (bark.CreatedResponse, error) {
+	s.api.mu.Lock()
+	defer s.api.mu.Unlock()
+
+	if s.api.err != nil {
+		return bark.CreatedResponse{}, s.api.err
+	}
+	s.api.statuses = append(s.api.statuses, status)
+
+	return bark.CreatedResponse{}, nil
+}
+
+func newSpoolingWorker(t *testing.T, api *outageAPI) *Worker {
+	t.Helper()
+
+	spool, err := openSpool(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &Worker{
+		config: &Config{RunnerConfig: runner.NewDefaultConfig()},
+		apiClient: stubService{
+			results:   outageResults{api: api},
+			artifacts: outageArtifacts{api: api},
+		},
+		spool: spool,
+	}
+}
+
+// runCapability mints a token the way the server does, expiring at expiry. The
+// worker never verifies it, so the key is irrelevant.
+func runCapability(t *testing.T, expiry time.Time) urth.APIToken {
+	t.Helper()
+
+	signed, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{
+		Subject:   "run-1",
+		ExpiresAt: jwt.NewNumericDate(expiry),
+	}).SignedString([]byte("any key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return urth.APIToken(signed)
+}
+
+func spooled(t *testing.T, w *Worker) []spooledReport {
+	t.Helper()
+
+	reports, err := w.spool.reports()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return reports
+}
+
+// The observation outlives the outage: what the API could not take is on disk,
+// marked as spooled and carrying when the run actually finished.
+func TestReportSpoolsWhatTheAPIServerCouldNotTake(t *testing.T) {
+	api := &outageAPI{err: errors.New("dial tcp: connection refused")}
+	w := newSpoolingWorker(t, api)
+
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	before := time.Now()
+	w.report(context.Background(),
+		natsq.DispatchEnvelope{ResultUID: "run-1", ScenarioName: "scenario-1", DispatchID: "dispatch-1"},
+		urth.AuthJobResponse{
+			CreatedResponse: bark.CreatedResponse{VersionedResourceID: manifest.NewVersionedID("run-1", 3)},
+			Token:           runCapability(t, expiry),
+			LateResultGrace: time.Hour,
+		},
+		urth.NewRunResults(prob.RunFinishedSuccess),
+		[]urth.ArtifactSpec{artifact("logs"), artifact("har")},
+	)
+
+	reports := spooled(t, w)
+	if len(reports) != 1 {
+		t.Fatalf("spooled %d reports, want 1", len(reports))
+	}
+
+	report := reports[0]
+	if report.Run != manifest.NewVersionedID("run-1", 3) || report.ScenarioName != "scenario-1" {
+		t.Errorf("spooled report is for %v of %q", report.Run, report.ScenarioName)
+	}
+	if !report.ExpiresAt.Equal(expiry) {
+		t.Errorf("spooled report expires at %v, want the capability's %v", report.ExpiresAt, expiry)
+	}
+	if want := expiry.Add(time.Hour); !report.DeliverBy.Equal(want) {
+		t.Errorf("spooled report is kept until %v, want the capability's expiry plus the late grace, %v", report.DeliverBy, want)
+	}
+	if len(report.Artifacts) != 2 {
+		t.Errorf("spooled %d artifacts, want both", len(report.Artifacts))
+	}
+	if report.Status == nil || report.Status.Delivery == nil || !report.Status.Delivery.Spooled {
+		t.Fatalf("spooled status is not marked as spooled: %+v", report.Status)
+	}
+	if finished := report.Status.Delivery.FinishedAt; finished.Before(before) || finished.After(time.Now()) {
+		t.Errorf("spooled status finished at %v, want the time of the report", finished)
+	}
+}
+
+// A refusal is a verdict, and a verdict does not change on replay: only what
+// failed for want of a reachable API is kept.
+func TestReportDoesNotSpoolRefusals(t *testing.T) {
+	api := &outageAPI{err: apiError(http.StatusUnauthorized)}
+	w := newSpoolingWorker(t, api)
+
+	w.report(context.Background(),
+		natsq.DispatchEnvelope{ResultUID: "run-1", ScenarioName: "scenario-1"},
+		urth.AuthJobResponse{Token: runCapability(t, time.Now().Add(time.Hour))},
+		urth.NewRunResults(prob.RunFinishedFailed),
+		[]urth.ArtifactSpec{artifact("logs")},
+	)
+
+	if reports := spooled(t, w); len(reports) != 0 {
+		t.Fatalf("spooled %d reports the API refused", len(reports))
+	}
+}
+
+func TestReplayDeliversOnceTheAPIServerIsBack(t *testing.T) {
+	api := &outageAPI{}
+	w := newSpoolingWorker(t, api)
+
+	status := urth.NewRunResults(prob.RunFinishedSuccess)
+	status.Delivery = &urth.ResultDelivery{Spooled: true, FinishedAt: time.Now().Add(-time.Hour)}
+	report := spooledReport{
+		Run:       manifest.NewVersionedID("run-1", 3),
+		Token:     runCapability(t, time.Now().Add(time.Hour)),
+		SpooledAt: time.Now(),
+		ExpiresAt: time.Now().Add(time.Hour),
+		Status:    &status,
+		Artifacts: []manifest.ResourceManifest{{Metadata: manifest.ObjectMeta{Name: "run-1.logs"}}},
+	}
+	if err := w.spool.put(report); err != nil {
+		t.Fatal(err)
+	}
+
+	api.fail(&bark.ErrorResponse{Code: http.StatusServiceUnavailable})
+	w.replaySpooled(context.Background())
+	if reports := spooled(t, w); len(reports) != 1 {
+		t.Fatalf("a report was dropped while the API was still down")
+	}
+
+	api.fail(nil)
+	w.replaySpooled(context.Background())
+	if reports := spooled(t, w); len(reports) != 0 {
+		t.Fatalf("%d reports still spooled after the API came back", len(reports))
+	}
+
+	if len(api.uploaded) != 1 || len(api.statuses) != 1 {
+		t.Fatalf("delivered %v artifacts and %d statuses, want one of each", api.uploaded, len(api.statuses))
+	}
+	if delivery := api.statuses[0].Delivery; delivery == nil || !delivery.Spooled {
+		t.Errorf("replayed status did not say it was spooled")
+	}
+}
+
+func TestReplayDropsReportsThatCanNoLongerLand(t *testing.T) {
+	api := &outageAPI{}
+	w := newSpoolingWorker(t, api)
+
+	status := urth.NewRunResults(prob.RunFinishedSuccess)
+	for id, expiry := range map[manifest.ResourceID]time.Time{
+		"expired": time.Now().Add(-time.Minute),
+		"refused": time.Now().Add(time.Hour),
+	} {
+		if err := w.spool.put(spooledReport{Run: manifest.NewVersionedID(id, 1), ExpiresAt: expiry, Status: &status}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	api.fail(apiError(http.StatusUnauthorized))
+	w.replaySpooled(context.Background())
+
+	if reports := spooled(t, w); len(reports) != 0 {
+		t.Fatalf("kept %d reports that can never be delivered", len(reports))
+	}
+}
+
+// Past the capability's expiry but inside the server's late grace the outcome
+// is still taken, so it is replayed rather than dropped. The artifacts are not:
+// they end with the capability.
+func TestReplayDeliversTheOutcomeWithinTheLateGrace(t *testing.T) {
+	api := &outageAPI{}
+	w := newSpoolingWorker(t, api)
+
+	status := urth.NewRunResults(prob.RunFinishedSuccess)
+	status.Delivery = &urth.ResultDelivery{Spooled: true, FinishedAt: time.Now().Add(-time.Hour)}
+	expiry := time.Now().Add(-time.Minute)
+	report := spooledReport{
+		Run:       manifest.NewVersionedID("run-1", 3),
+		Token:     runCapability(t, expiry),
+		SpooledAt: time.Now().Add(-time.Hour),
+		ExpiresAt: expiry,
+		DeliverBy: expiry.Add(time.Hour),
+		Status:    &status,
+		Artifacts: []manifest.ResourceManifest{{Metadata: manifest.ObjectMeta{Name: "run-1.logs"}}},
+	}
+	if err := w.spool.put(report); err != nil {
+		t.Fatal(err)
+	}
+
+	w.replaySpooled(context.Background())
+
+	if reports := spooled(t, w); len(reports) != 0 {
+		t.Fatalf("%d reports still spooled after replay", len(reports))
+	}
+	if len(api.statuses) != 1 {
+		t.Fatalf("delivered %d statuses, want the spooled outcome", len(api.statuses))
+	}
+	if len(api.uploaded) != 0 {
+		t.Errorf("uploaded %v on an expired capability", api.uploaded)
+	}
+}
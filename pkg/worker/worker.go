@@ -26,15 +26,19 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/alecthomas/kong"
 	"github.com/nats-io/nats.go"
 	"github.com/nats-io/nats.go/jetstream"
 
 	"github.com/sre-norns/urth/pkg/natsq"
+	"github.com/sre-norns/urth/pkg/prob"
 	"github.com/sre-norns/urth/pkg/runner"
 	"github.com/sre-norns/urth/pkg/urth"
 	"github.com/sre-norns/wyrd/pkg/manifest"
@@ -63,6 +67,24 @@ type Config struct {
 
 	Concurrency int `help:"Maximum number of scenarios to execute at once"`
 
+	// KindConcurrency caps individual prob kinds inside the Concurrency pool,
+	// so a few browser runs cannot take every slot a host has while cheap TCP
+	// checks queue behind them. See kindslots.go.
+	//
+	// Not a flag itself: it is filled by Normalize from the
+	// --concurrency.<kind> flags KindConcurrencyFlags declares, one per prober
+	// this build has.
+	KindConcurrency map[prob.Kind]int `kong:"-"`
+
+	// KindConcurrencyFlags declares --concurrency.<kind>. See
+	// kindConcurrencyFlags.
+	KindConcurrencyFlags kong.Plugins `embed:""`
+
+	// SelfTestInterval is how often the probers' self-tests are run again
+	// after startup, so that a capability the host loses is noticed by the
+	// worker and by placement. See selftest.go.
+	SelfTestInterval time.Duration `help:"How often to re-run the probers' self-tests. 0 tests only at startup" default:"5m"`
+
 	APIRegistrationTimeout time.Duration `help:"Maximum time alloted for this worker to register with API server" default:"1m"`
 
 	// HeartbeatInterval is only the starting cadence. The server answers every
@@ -75,6 +97,11 @@ type Config struct {
 	// so the cost is the worker's own upstream bandwidth.
 	StreamLogs bool `help:"Publish run logs live over NATS" default:"true" negatable:""`
 
+	// Spool keeps the parts of a report the API server could not take, under
+	// the working directory, and delivers them once it is back. See spool.go.
+	Spool               bool          `help:"Keep undelivered run reports on disk and deliver them when the API server is reachable again" default:"true" negatable:""`
+	SpoolReplayInterval time.Duration `help:"How often spooled run reports are retried" default:"30s"`
+
 	// MetricsAddress exports what this worker knows about its own claim
 	// handshake, which is the part no other process can see.
 	//
@@ -83,12 +110,19 @@ type Config struct {
 	// for having very little listening on it. Opening a port nobody asked for is
 	// not a call this binary makes on an operator's behalf.
 	MetricsAddress string `help:"Address to serve Prometheus metrics on, e.g. :9101. Empty serves none" env:"METRICS_ADDRESS"`
+
+	// AdminAddress serves health, readiness and the runs in flight to whoever
+	// is on the host, and takes a cancel or a drain from a loopback caller.
+	// Empty by default for the reason MetricsAddress is. See admin.go.
+	AdminAddress string `help:"Address to serve the local admin endpoint on, e.g. 127.0.0.1:9102. Empty serves none" env:"ADMIN_ADDRESS"`
+	AdminHistory int    `help:"Finished runs the admin endpoint lists" default:"20"`
 }
 
 // NewDefaultConfig returns a config carrying this build's capability labels.
 func NewDefaultConfig() Config {
 	return Config{
-		RunnerConfig: runner.NewDefaultConfig(),
+		RunnerConfig:         runner.NewDefaultConfig(),
+		KindConcurrencyFlags: kindConcurrencyFlags(),
 	}
 }
 
@@ -105,6 +139,8 @@ func (c *Config) Normalize() {
 	if c.Name == "" {
 		c.Name = runner.GenerateWorkerName()
 	}
+	c.applyKindConcurrencyFlags()
+	c.normalizeKindLimits()
 }
 
 // EnrolmentToken resolves the enrolment secret from its file or the
@@ -144,6 +180,12 @@ func WithProbeRunner(fn ProbeRunner) Option {
 	return func(w *Worker) { w.executeJob = fn }
 }
 
+// WithLabelReload has the worker take new custom labels from fn on SIGHUP.
+// See reload.go.
+func WithLabelReload(fn func() (manifest.Labels, error)) Option {
+	return func(w *Worker) { w.reloadLabels = fn }
+}
+
 // Worker holds the identity and connections established at startup.
 type Worker struct {
 	config    *Config
@@ -160,7 +202,10 @@ type Worker struct {
 	workerMeta manifest.ObjectMeta
 	runnerUID  manifest.ResourceID
 
-	conn *nats.Conn
+	// conn is the NATS connection, once connect has dialled it. Atomic rather
+	// than under mu: the admin endpoint reads it from its own goroutine from
+	// the moment the worker starts, while Run is still dialling.
+	conn atomic.Pointer[nats.Conn]
 
 	// presence announces this worker on its runner's subject. Built once the
 	// NATS connection and the worker's identity both exist.
@@ -175,12 +220,35 @@ type Worker struct {
 	// execution of a run this process already holds.
 	inFlight inFlightRuns
 
+	// drain is closed when an operator asks this worker to finish up and leave.
+	drain drainSignal
+
+	// kindSlots counts the runs of each limited prob kind being executed.
+	kindSlots kindSlots
+
+	// capabilities is what the probers' latest self-test found.
+	capabilities selfTestResults
+
+	// recent is the last few finished runs, for the admin endpoint.
+	recent recentRuns
+
+	// spool holds reports the API server could not take. Nil when spooling is
+	// off, and in tests that build a worker literal.
+	spool *spool
+
 	// metrics is nil unless MetricsAddress was given. Every method on it is
 	// nil-safe for that reason.
 	metrics *workerMetrics
 
 	// executeJob replaces probe execution in tests. Nil in production; see runJob.
 	executeJob ProbeRunner
+
+	// selfTest replaces prob.SelfTest in tests. Nil in production.
+	selfTest func(context.Context) map[prob.Kind]error
+
+	// reloadLabels reads the custom labels again on SIGHUP. Nil when the
+	// process around the worker offers no way to.
+	reloadLabels func() (manifest.Labels, error)
 }
 
 // New builds a worker over an API client and an enrolment token.
@@ -196,6 +264,7 @@ func New(cfg *Config, client urth.Service, token urth.APIToken, options ...Optio
 		config:    cfg,
 		apiClient: client,
 		token:     token,
+		recent:    recentRuns{limit: cfg.AdminHistory},
 	}
 
 	for _, option := range options {
@@ -242,7 +311,7 @@ func (w *Worker) register(ctx context.Context) (urth.WorkerRegistrationResponse,
 				// architecture it is on. The server stores this snapshot and
 				// admits or refuses the worker against the runner's
 				// requirements -- it is not re-read from later requests.
-				Labels: w.config.GetEffectiveLabels(),
+				Labels: w.effectiveLabels(),
 			},
 		}.ToManifest())
 	if err != nil {
@@ -270,8 +339,16 @@ func (w *Worker) register(ctx context.Context) (urth.WorkerRegistrationResponse,
 	return registration, nil
 }
 
-// Run registers, binds this runner's queue, and consumes jobs until ctx ends.
+// Run registers, binds this runner's queue, and consumes jobs until ctx ends or
+// an operator drains the worker. Either way it returns once the runs in flight
+// have finished, having told the API server it is leaving.
 func (w *Worker) Run(ctx context.Context) error {
+	// Cancelled on the way out, so that a worker returning from a drain -- which
+	// ctx knows nothing about -- stops renewing its session and replaying its
+	// spool along with everything else.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
 	if w.config.MetricsAddress != "" {
 		metrics, registry := newWorkerMetrics()
 		w.metrics = metrics
@@ -279,6 +356,42 @@ func (w *Worker) Run(ctx context.Context) error {
 		go serveMetrics(ctx, w.config.MetricsAddress, registry)
 	}
 
+	if w.config.AdminAddress != "" {
+		// Up before registering, so that a readiness probe sees the worker
+		// start and then become ready rather than nothing at all; and up
+		// until Run returns rather than until ctx ends, so that a worker
+		// finishing its runs after a shutdown signal can still be looked at.
+		adminCtx, stopAdmin := context.WithCancel(context.WithoutCancel(ctx))
+		defer stopAdmin()
+
+		go serveHTTP(adminCtx, "admin endpoint", w.config.AdminAddress, w.adminHandler())
+	}
+
+	// Checked before registering, for the reason the spool is opened first: a
+	// worker that cannot build the sandbox it was configured with must not
+	// claim runs it would only fail.
+	if err := w.config.Sandbox.Validate(); err != nil {
+		return fmt.Errorf("cannot sandbox scripts as configured: %w", err)
+	}
+	if err := w.config.Network.Validate(); err != nil {
+		return fmt.Errorf("cannot route probes as configured: %w", err)
+	}
+
+	if w.config.Spool {
+		// Opened before registering, so that a spool directory that cannot be
+		// created stops the worker before it claims a run whose report it
+		// would then have nowhere to keep.
+		spool, err := openSpool(filepath.Join(w.config.WorkingDirectory, spoolDirectory))
+		if err != nil {
+			return err
+		}
+		w.spool = spool
+	}
+
+	// Needed per kind, with reasons, for the first heartbeat and the first
+	// delivery.
+	w.startingCapabilities(ctx)
+
 	registration, err := w.register(ctx)
 	if err != nil {
 		return fmt.Errorf("failed to register with the API server: %w", err)
@@ -287,22 +400,41 @@ func (w *Worker) Run(ctx context.Context) error {
 	log.Printf("registered as worker %q (%v) of runner %q (%v); session valid until %v",
 		w.workerMeta.Name, w.workerMeta.UID, w.runnerMeta.Name, w.runnerMeta.UID, registration.SessionExpiresAt)
 
-	consumer, err := w.connect(ctx, registration.NATS)
+	queues, err := w.connect(ctx, registration.NATS)
 	if err != nil {
 		return err
 	}
-	defer w.conn.Drain()
+	conn := w.conn.Load()
+	defer conn.Drain()
+
+	w.presence = natsq.NewPresencePublisher(conn, w.runnerUID, w.workerMeta.UID)
 
-	w.presence = natsq.NewPresencePublisher(w.conn, w.runnerUID, w.workerMeta.UID)
+	// Presence outlives ctx: a worker shutting down still has runs to finish,
+	// and goes on reporting until the last of them has, so that it is not
+	// declared offline while it is visibly working. Only then does it leave.
+	presenceCtx, stopPresence := context.WithCancel(context.WithoutCancel(ctx))
+	presenceDone := make(chan struct{})
 
 	go w.renewSession(ctx)
-	go w.reportPresence(ctx)
+	go func() {
+		defer close(presenceDone)
+		w.reportPresence(presenceCtx)
+	}()
+	go w.replaySpool(ctx)
+	go w.retestCapabilities(ctx)
+	go w.reloadOnHangup(ctx)
+
+	err = w.consume(ctx, queues)
+
+	stopPresence()
+	<-presenceDone
+	w.leave()
 
-	return w.consume(ctx, consumer)
+	return err
 }
 
-// connect dials NATS and binds the runner's durable consumer.
-func (w *Worker) connect(ctx context.Context, info urth.NATSConnectionInfo) (jetstream.Consumer, error) {
+// connect dials NATS and binds the runner's durable consumers.
+func (w *Worker) connect(ctx context.Context, info urth.NATSConnectionInfo) (*jobQueues, error) {
 	if info.SchemaVersion != urth.NATSConnectionInfoVersion {
 		// Refusing rather than guessing: the fields that matter here are the
 		// stream and consumer to bind to, and binding to the wrong one either
@@ -326,7 +458,7 @@ func (w *Worker) connect(ctx context.Context, info urth.NATSConnectionInfo) (jet
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
 	}
-	w.conn = conn
+	w.conn.Store(conn)
 
 	js, err := jetstream.New(conn)
 	if err != nil {
@@ -344,7 +476,25 @@ func (w *Worker) connect(ctx context.Context, info urth.NATSConnectionInfo) (jet
 	log.Printf("bound consumer %q on stream %q for subject %q",
 		info.Consumer, info.Stream, info.Subject)
 
-	return consumer, nil
+	queues := &jobQueues{normal: consumer}
+	if info.HighPriorityConsumer == "" {
+		// A server from before priorities: everything arrives on the one queue.
+		return queues, nil
+	}
+
+	// Offered but missing is the same configuration error as the normal
+	// consumer missing, and fatal for the same reason: this worker's runner
+	// would have its manual runs published where nothing pulls them.
+	high, err := natsq.BindHighPriorityConsumer(ctx, js, w.runnerUID)
+	if err != nil {
+		return nil, err
+	}
+	queues.high = high
+
+	log.Printf("bound consumer %q on stream %q for subject %q, pulled first",
+		info.HighPriorityConsumer, info.Stream, info.HighPrioritySubject)
+
+	return queues, nil
 }
 
 // renewSession re-registers before the session expires.
@@ -410,7 +560,6 @@ func (w *Worker) reportPresence(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
-			w.leave()
 			return
 		case <-time.After(interval):
 		}
@@ -434,7 +583,10 @@ func (w *Worker) announce() {
 // reporting exactly as often as it intended. The value is floored so that a
 // misconfigured server cannot turn this into a busy loop.
 func (w *Worker) heartbeat(ctx context.Context, current time.Duration, leaving bool) time.Duration {
-	response, err := w.apiClient.Workers().Heartbeat(ctx, w.currentSession(), urth.WorkerHeartbeatRequest{Leaving: leaving})
+	response, err := w.apiClient.Workers().Heartbeat(ctx, w.currentSession(), urth.WorkerHeartbeatRequest{
+		Leaving:          leaving,
+		SelfTestFailures: w.capabilities.report(),
+	})
 	if err != nil {
 		log.Printf("failed to report worker heartbeat: %v", err)
 		return current
@@ -447,6 +599,10 @@ func (w *Worker) heartbeat(ctx context.Context, current time.Duration, leaving b
 		log.Print("this worker is paused by an operator and will not be given work")
 	}
 
+	if response.Drain && !leaving {
+		w.requestDrain()
+	}
+
 	if response.Interval >= urth.MinWorkerHeartbeatInterval {
 		return response.Interval
 	}
@@ -461,8 +617,9 @@ func (w *Worker) heartbeat(ctx context.Context, current time.Duration, leaving b
 // is worth doing because a clean stop is the common case, and waiting out a
 // timeout to reflect one makes the fleet view look broken.
 func (w *Worker) leave() {
-	// Rooted at Background rather than the worker's context, which is already
-	// cancelled: this request exists precisely because the process is going away.
+	// Rooted at Background rather than the worker's context, which a shutdown
+	// has already cancelled: this request exists precisely because the process
+	// is going away.
 	leaveCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 